@@ -20,13 +20,22 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/chartmuseum/storage"
 	"helm.sh/chartmuseum/pkg/cache"
 	"helm.sh/chartmuseum/pkg/chartmuseum"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	"helm.sh/chartmuseum/pkg/config"
+	"helm.sh/chartmuseum/pkg/eventbus"
+	"helm.sh/chartmuseum/pkg/ingestion"
+	"helm.sh/chartmuseum/pkg/kubeauth"
+	"helm.sh/chartmuseum/pkg/lock"
+	"helm.sh/chartmuseum/pkg/replication"
+	"helm.sh/chartmuseum/pkg/tenant"
+	"helm.sh/chartmuseum/pkg/webhook"
 
 	"github.com/urfave/cli"
 )
@@ -50,10 +59,37 @@ func main() {
 	app.Usage = "Helm Chart Repository with support for Amazon S3, Google Cloud Storage, Oracle Cloud Infrastructure Object Storage and Openstack"
 	app.Action = cliHandler
 	app.Flags = config.CLIFlags
+	app.Commands = []cli.Command{
+		{
+			Name:   "export",
+			Usage:  "write the repos in storage to a directory as static chart repositories, e.g. for GitHub Pages or S3 website hosting",
+			Action: exportHandler,
+			// flags of the app are not seen by the config of a command, see UpdateFromCLIContext
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "directory to export the repos to",
+				},
+			}, config.CLIFlags...),
+		},
+	}
 	app.Run(os.Args)
 }
 
 func cliHandler(c *cli.Context) {
+	runServer(c, "")
+}
+
+// exportHandler exports the repos in storage with the configured server, which exits once done
+func exportHandler(c *cli.Context) {
+	out := c.String("out")
+	if out == "" {
+		crash("Missing required flags(s): --out")
+	}
+	runServer(c, out)
+}
+
+func runServer(c *cli.Context, exportDirectory string) {
 	conf := config.NewConfig()
 	err := conf.UpdateFromCLIContext(c)
 	if err != nil {
@@ -61,8 +97,14 @@ func cliHandler(c *cli.Context) {
 	}
 
 	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{
-		Debug:   conf.GetBool("debug"),
-		LogJSON: conf.GetBool("logjson"),
+		Debug:          conf.GetBool("debug"),
+		LogJSON:        conf.GetBool("logjson"),
+		Output:         conf.GetString("log.output"),
+		MaxSize:        conf.GetInt("log.maxsize"),
+		RotateInterval: conf.GetDuration("log.rotateinterval"),
+		MaxBackups:     conf.GetInt("log.maxbackups"),
+		SyslogAddress:  conf.GetString("log.syslogaddress"),
+		SyslogTag:      conf.GetString("log.syslogtag"),
 	})
 	if err != nil {
 		crash(err)
@@ -72,17 +114,30 @@ func cliHandler(c *cli.Context) {
 
 	backend := backendFromConfig(conf)
 	store := storeFromConfig(conf)
+	tenantConfig := tenantConfigFromConfig(conf)
+	locker := lockerFromConfig(conf)
 
 	options := chartmuseum.ServerOptions{
 		Version:                Version,
+		Revision:               Revision,
 		StorageBackend:         backend,
 		ExternalCacheStore:     store,
 		Logger:                 logger,
 		TimestampTolerance:     conf.GetDuration("storage.timestamptolerance"),
 		ChartURL:               conf.GetString("charturl"),
+		ChartURLTemplate:       conf.GetString("charturltemplate"),
 		TlsCert:                conf.GetString("tls.cert"),
 		TlsKey:                 conf.GetString("tls.key"),
 		TlsCACert:              conf.GetString("tls.cacert"),
+		TLSMinVersion:          conf.GetString("tls.minversion"),
+		TLSCipherSuites:        splitConfigList(conf.GetString("tls.ciphersuites")),
+		DisableHTTP2:           conf.GetBool("disablehttp2"),
+		TLSAuto:                conf.GetBool("tls.auto"),
+		TLSAutoHosts:           splitConfigList(conf.GetString("tls.autohost")),
+		TLSAutoEmail:           conf.GetString("tls.autoemail"),
+		TLSAutoDirectoryURL:    conf.GetString("tls.autodirectoryurl"),
+		TLSHTTPPort:            conf.GetInt("tls.httpport"),
+		TLSHTTPMode:            conf.GetString("tls.httpmode"),
 		Username:               conf.GetString("basicauth.user"),
 		Password:               conf.GetString("basicauth.pass"),
 		ChartPostFormFieldName: conf.GetString("chartpostformfieldname"),
@@ -90,16 +145,31 @@ func cliHandler(c *cli.Context) {
 		ContextPath:            conf.GetString("contextpath"),
 		LogHealth:              conf.GetBool("loghealth"),
 		LogLatencyInteger:      conf.GetBool("loglatencyinteger"),
+		LogUploadProgress:      conf.GetInt("loguploadprogress"),
 		EnableAPI:              !conf.GetBool("disableapi"),
 		DisableDelete:          conf.GetBool("disabledelete"),
 		UseStatefiles:          !conf.GetBool("disablestatefiles"),
+		UseMetadataCache:       conf.GetBool("metadatacache"),
+		PersistMetadataCache:   conf.GetBool("persistmetadatacache"),
 		AllowOverwrite:         conf.GetBool("allowoverwrite"),
 		AllowForceOverwrite:    !conf.GetBool("disableforceoverwrite"),
+		IdempotentUploads:      conf.GetBool("idempotentuploads"),
 		EnableMetrics:          !conf.GetBool("disablemetrics"),
+		MetricsMaxTenants:      conf.GetInt("metricsmaxtenants"),
+		MetricsUsername:        conf.GetString("metricsbasicauthuser"),
+		MetricsPassword:        conf.GetString("metricsbasicauthpass"),
+		MetricsBearerToken:     conf.GetString("metricsbearertoken"),
 		AnonymousGet:           conf.GetBool("authanonymousget"),
+		AnonymousMethods:       splitConfigList(conf.GetString("authanonymousmethods")),
 		GenIndex:               conf.GetBool("genindex"),
+		ExportDirectory:        exportDirectory,
+		ValidateConfig:         conf.GetBool("validateconfig"),
 		MaxStorageObjects:      conf.GetInt("maxstorageobjects"),
 		IndexLimit:             conf.GetInt("indexlimit"),
+		RegenerationLimit:      conf.GetInt("index.regenerationlimit"),
+		IndexSharding:          conf.GetString("indexsharding"),
+		IndexShards:            conf.GetInt("indexshards"),
+		IndexJournal:           conf.GetBool("indexjournal"),
 		Depth:                  conf.GetInt("depth"),
 		MaxUploadSize:          conf.GetInt("maxuploadsize"),
 		BearerAuth:             conf.GetBool("bearerauth"),
@@ -107,14 +177,101 @@ func cliHandler(c *cli.Context) {
 		AuthService:            conf.GetString("authservice"),
 		AuthCertPath:           conf.GetString("authcertpath"),
 		AuthActionsSearchPath:  conf.GetString("authactionssearchpath"),
+		KubeAuth:               kubeAuthFromConfig(conf),
 		DepthDynamic:           conf.GetBool("depthdynamic"),
 		CORSAllowOrigin:        conf.GetString("cors.alloworigin"),
+		EnableCompression:      conf.GetBool("compression.enabled"),
+		CompressionMinSize:     conf.GetInt("compression.minsize"),
+		CompressionTypes:       splitConfigList(conf.GetString("compression.types")),
 		WriteTimeout:           conf.GetInt("writetimeout"),
 		ReadTimeout:            conf.GetInt("readtimeout"),
+		ShutdownTimeout:        conf.GetInt("shutdowntimeout"),
+		RequestTimeout:         conf.GetInt("requesttimeout"),
+		IdleTimeout:            conf.GetInt("idletimeout"),
+		ReadHeaderTimeout:      conf.GetInt("readheadertimeout"),
+		MaxHeaderBytes:         conf.GetInt("maxheaderbytes"),
 		EnforceSemver2:         conf.GetBool("enforce-semver2"),
 		CacheInterval:          conf.GetDuration("cacheinterval"),
+		StaleWhileRevalidate:   conf.GetBool("stalewhilerevalidate"),
+		MaxStaleness:           conf.GetDuration("maxstaleness"),
 		Host:                   conf.GetString("listen.host"),
+		UnixSocket:             conf.GetString("listen.unixsocket"),
+		TrustedProxies:         splitConfigList(conf.GetString("trustedproxies")),
+		ProxyProtocol:          conf.GetBool("listen.proxyprotocol"),
+		AdminPort:              conf.GetInt("adminport"),
 		PerChartLimit:          conf.GetInt("per-chart-limit"),
+		TenantConfig:           tenantConfig,
+		EnableTenantAPI:        conf.GetBool("enabletenantapi"),
+		TenantHostPattern:      conf.GetString("tenanthostpattern"),
+		WebhookURLs:            webhookURLsFromConfig(conf),
+		WebhookSecret:          conf.GetString("webhooksecret"),
+		WebhookRetries:         conf.GetInt("webhookretries"),
+		EventPublishers:        eventPublishersFromConfig(conf),
+		EnableOCI:              conf.GetBool("enableoci"),
+		EnableWebUI:            conf.GetBool("webui"),
+		LandingJSON:            conf.GetBool("landing.json"),
+		LandingTemplate:        conf.GetString("landing.template"),
+		EnablePprof:            conf.GetBool("pprof.enabled"),
+		MutexProfileFraction:   conf.GetInt("pprof.mutexprofilefraction"),
+		TracingEndpoint:        conf.GetString("tracing.endpoint"),
+		TracingServiceName:     conf.GetString("tracing.servicename"),
+		TracingSampleRatio:     conf.GetFloat64("tracing.sampleratio"),
+		EnableAccessLog:        conf.GetBool("accesslog.enabled"),
+		AccessLogOutput:        conf.GetString("accesslog.output"),
+		AccessLogFormat:        conf.GetString("accesslog.format"),
+		AccessLogFields:        splitConfigList(conf.GetString("accesslog.fields")),
+		AccessLogMaxSize:       conf.GetInt("accesslog.maxsize"),
+		AccessLogMaxBackups:    conf.GetInt("accesslog.maxbackups"),
+		StatsdAddress:          conf.GetString("statsd.addr"),
+		StatsdInterval:         conf.GetDuration("statsd.interval"),
+		StatsdTags:             splitConfigList(conf.GetString("statsd.tags")),
+		LegacyErrorBodies:      conf.GetBool("legacyerrorbodies"),
+		ResponseHeaders:        conf.GetString("responseheaders"),
+		ProxyUpstream:          conf.GetString("proxy.upstream"),
+		ProxyIndexTTL:          conf.GetDuration("proxy.indexttl"),
+		Replication:            replicationConfigFromConfig(conf),
+		Ingestion:              ingestionConfigFromConfig(conf),
+		TrashRetention:         conf.GetDuration("trash.retention"),
+		MaintenanceMessage:     conf.GetString("maintenance.message"),
+		ScanURL:                conf.GetString("scan.url"),
+		ScanPayload:            conf.GetString("scan.payload"),
+		ScanSeverity:           conf.GetString("scan.severity"),
+		ScanBlock:              conf.GetBool("scan.block"),
+		ScanTimeout:            conf.GetDuration("scan.timeout"),
+		IndexAnnotations:       conf.GetBool("index.annotations"),
+		ChartOwners:            conf.GetBool("owners.enabled"),
+		RestrictToOwners:       conf.GetBool("owners.restrict"),
+		ChartHistory:           conf.GetBool("charthistory"),
+		ChartAdmins:            splitConfigList(conf.GetString("owners.admins")),
+		ForceOverwriteUsers:    splitConfigList(conf.GetString("forceoverwrite.users")),
+		ProtectedCharts:        splitConfigList(conf.GetString("protectedcharts")),
+		IndexSigningKey:        conf.GetString("index.signingkey"),
+		IndexSigningKeyName:    conf.GetString("index.signingkeyname"),
+		IndexSigningPassphrase: conf.GetString("index.signingpassphrase"),
+		CacheControlIndex:      conf.GetString("cachecontrol.index"),
+		CacheControlCharts:     conf.GetString("cachecontrol.charts"),
+		ChartIcons:             conf.GetString("charticons"),
+		DownloadLinkSecret:     conf.GetString("downloadlinks.secret"),
+		DownloadLinkMaxTTL:     conf.GetDuration("downloadlinks.maxttl"),
+		DownloadRedirectURL:    conf.GetString("downloadredirect.url"),
+		PresignDownloads:       conf.GetBool("downloadredirect.presign"),
+		DownloadRedirectTTL:    conf.GetDuration("downloadredirect.ttl"),
+		PrimeTenants:           splitConfigList(conf.GetString("cache.primetenants")),
+		PrimeAllTenants:        conf.GetBool("cache.primeall"),
+		CacheTTL:               conf.GetDuration("cache.ttl"),
+		CacheMaxEntries:        conf.GetInt("cache.maxentries"),
+		APICacheTTL:            conf.GetDuration("cache.apittl"),
+		ChartNamePattern:       conf.GetString("chartpolicy.name"),
+		ChartVersionPattern:    conf.GetString("chartpolicy.version"),
+		StrictSemver:           conf.GetBool("chartpolicy.strictsemver"),
+		Locker:                 locker,
+		LockTimeout:            conf.GetDuration("lock.timeout"),
+		Leader:                 leaderFromConfig(conf, logger, locker),
+		AlertStorageBytes:      conf.GetInt("alert.storagebytes"),
+		AlertChartVersions:     conf.GetInt("alert.chartversions"),
+		AlertIndexBytes:        conf.GetInt("alert.indexbytes"),
+		AlertErrorRate:         conf.GetFloat64("alert.errorrate"),
+		AlertInterval:          conf.GetDuration("alert.interval"),
 	}
 
 	server, err := newServer(options)
@@ -122,7 +279,53 @@ func cliHandler(c *cli.Context) {
 		crash(err)
 	}
 
+	go reloadOnSignal(conf, server, logger)
+	go toggleDebugOnSignal(logger)
 	server.Listen(conf.GetInt("port"))
+	logger.Close()
+}
+
+// reloadOnSignal reloads the config file and applies its dynamic settings on SIGHUP
+func reloadOnSignal(conf *config.Config, server chartmuseum.Server, logger *cm_logger.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadConfig(conf, server, logger)
+	}
+}
+
+// toggleDebugOnSignal switches debug messages on and off on SIGUSR1
+func toggleDebugOnSignal(logger *cm_logger.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		logger.Warnw("Log level changed", "level", logger.ToggleDebug())
+	}
+}
+
+func reloadConfig(conf *config.Config, server chartmuseum.Server, logger *cm_logger.Logger) {
+	if err := conf.Reload(); err != nil {
+		logger.Errorw("Error reloading config", "error", err.Error())
+		return
+	}
+	if err := server.Reload(reloadOptionsFromConfig(conf)); err != nil {
+		logger.Errorw("Error reloading config", "error", err.Error())
+		return
+	}
+	logger.Infow("Reloaded config", "file", conf.ConfigFileUsed())
+}
+
+// reloadOptionsFromConfig returns the settings changed without a restart, through the config
+// file. Any of them set with CLI flags or environment variables stays the same
+func reloadOptionsFromConfig(conf *config.Config) chartmuseum.ReloadOptions {
+	return chartmuseum.ReloadOptions{
+		Username:           conf.GetString("basicauth.user"),
+		Password:           conf.GetString("basicauth.pass"),
+		AnonymousGet:       conf.GetBool("authanonymousget"),
+		PerChartLimit:      conf.GetInt("per-chart-limit"),
+		TrashRetention:     conf.GetDuration("trash.retention"),
+		MaintenanceMessage: conf.GetString("maintenance.message"),
+	}
 }
 
 func backendFromConfig(conf *config.Config) storage.Backend {
@@ -311,6 +514,168 @@ func redisCacheFromConfig(conf *config.Config) cache.Store {
 	))
 }
 
+func lockerFromConfig(conf *config.Config) lock.Locker {
+	if conf.GetString("lock.backend") == "" {
+		return nil
+	}
+
+	var locker lock.Locker
+
+	lockFlag := strings.ToLower(conf.GetString("lock.backend"))
+	switch lockFlag {
+	case "redis":
+		crashIfConfigMissingVars(conf, []string{"lock.redis.addr"})
+		locker = lock.NewRedisLocker(
+			conf.GetString("lock.redis.addr"),
+			conf.GetString("lock.redis.password"),
+			conf.GetInt("lock.redis.db"),
+			conf.GetDuration("lock.ttl"),
+		)
+	case "dynamodb":
+		crashIfConfigMissingVars(conf, []string{"lock.dynamodb.table"})
+		locker = lock.NewDynamoDBLocker(
+			conf.GetString("lock.dynamodb.table"),
+			conf.GetString("lock.dynamodb.region"),
+			conf.GetString("lock.dynamodb.endpoint"),
+			conf.GetDuration("lock.ttl"),
+		)
+	default:
+		crash("Unsupported lock backend: ", lockFlag)
+	}
+
+	return locker
+}
+
+func leaderFromConfig(conf *config.Config, logger *cm_logger.Logger, locker lock.Locker) *lock.Elector {
+	if conf.GetString("leaderelection.backend") == "" {
+		return nil
+	}
+
+	var lease lock.Lease
+
+	leaderFlag := strings.ToLower(conf.GetString("leaderelection.backend"))
+	switch leaderFlag {
+	case "lock":
+		crashIfConfigMissingVars(conf, []string{"lock.backend"})
+		lease = locker.(lock.Lease)
+	case "kubernetes":
+		kubernetesLease, err := lock.NewKubernetesLease(conf.GetString("leaderelection.namespace"))
+		if err != nil {
+			crash(err)
+		}
+		lease = kubernetesLease
+	default:
+		crash("Unsupported leader election: ", leaderFlag)
+	}
+
+	return lock.NewElector(lock.ElectorOptions{
+		Logger: logger,
+		Lease:  lease,
+		Name:   conf.GetString("leaderelection.name"),
+		ID:     conf.GetString("leaderelection.id"),
+		TTL:    conf.GetDuration("leaderelection.ttl"),
+	})
+}
+
+func tenantConfigFromConfig(conf *config.Config) *tenant.Config {
+	path := conf.GetString("tenantconfig")
+	if path == "" {
+		return nil
+	}
+
+	tenantConfig, err := tenant.LoadConfig(path)
+	if err != nil {
+		crash("Could not load tenant config: ", err)
+	}
+	return tenantConfig
+}
+
+func kubeAuthFromConfig(conf *config.Config) *kubeauth.Authenticator {
+	path := conf.GetString("kubeauthconfig")
+	if path == "" {
+		return nil
+	}
+
+	kubeAuthConfig, err := kubeauth.LoadConfig(path)
+	if err != nil {
+		crash("Could not load kubeauth config: ", err)
+	}
+	authenticator, err := kubeauth.NewAuthenticator(kubeAuthConfig)
+	if err != nil {
+		crash(err)
+	}
+	return authenticator
+}
+
+func replicationConfigFromConfig(conf *config.Config) *replication.Config {
+	path := conf.GetString("replicationconfig")
+	if path == "" {
+		return nil
+	}
+
+	replicationConfig, err := replication.LoadConfig(path)
+	if err != nil {
+		crash("Could not load replication config: ", err)
+	}
+	return replicationConfig
+}
+
+func ingestionConfigFromConfig(conf *config.Config) *ingestion.Config {
+	path := conf.GetString("ingestionconfig")
+	if path == "" {
+		return nil
+	}
+
+	ingestionConfig, err := ingestion.LoadConfig(path)
+	if err != nil {
+		crash("Could not load ingestion config: ", err)
+	}
+	return ingestionConfig
+}
+
+func webhookURLsFromConfig(conf *config.Config) []string {
+	return splitConfigList(conf.GetString("webhookurls"))
+}
+
+func eventPublishersFromConfig(conf *config.Config) []webhook.Publisher {
+	var publishers []webhook.Publisher
+	if natsURL := conf.GetString("events.nats.url"); natsURL != "" {
+		publisher, err := eventbus.NewNATSPublisher(natsURL, conf.GetString("events.nats.subject"), 0)
+		if err != nil {
+			crash("Could not configure NATS events: ", err)
+		}
+		publishers = append(publishers, publisher)
+	}
+	if brokers := splitConfigList(conf.GetString("events.kafka.brokers")); len(brokers) > 0 {
+		publisher, err := eventbus.NewKafkaPublisher(brokers, conf.GetString("events.kafka.topic"), eventbus.KafkaOptions{
+			TLS:           conf.GetBool("events.kafka.tls"),
+			CACert:        conf.GetString("events.kafka.cacert"),
+			SASLMechanism: conf.GetString("events.kafka.sasl.mechanism"),
+			SASLUsername:  conf.GetString("events.kafka.sasl.username"),
+			SASLPassword:  conf.GetString("events.kafka.sasl.password"),
+		})
+		if err != nil {
+			crash("Could not configure Kafka events: ", err)
+		}
+		publishers = append(publishers, publisher)
+	}
+	if slackURL := conf.GetString("alert.slackurl"); slackURL != "" {
+		publishers = append(publishers, webhook.NewSlackPublisher(slackURL, 0))
+	}
+	return publishers
+}
+
+// splitConfigList splits a comma-separated config value, dropping blank items
+func splitConfigList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func crashIfConfigMissingVars(conf *config.Config, vars []string) {
 	var missing []string
 	for _, v := range vars {
@@ -17,17 +17,39 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	pathutil "path"
 	"testing"
+	"time"
 
 	"helm.sh/chartmuseum/pkg/chartmuseum"
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	"helm.sh/chartmuseum/pkg/config"
 
 	"github.com/alicebob/miniredis"
 	"github.com/stretchr/testify/suite"
 )
 
+type reloadedServer struct {
+	options []chartmuseum.ReloadOptions
+}
+
+func (server *reloadedServer) Listen(port int) {}
+
+func (server *reloadedServer) Handler() http.Handler { return nil }
+
+func (server *reloadedServer) Shutdown(ctx context.Context) {}
+
+func (server *reloadedServer) Reload(options chartmuseum.ReloadOptions) error {
+	server.options = append(server.options, options)
+	return nil
+}
+
 type MainTestSuite struct {
 	suite.Suite
 	RedisMock        *miniredis.Miniredis
@@ -39,7 +61,7 @@ func (suite *MainTestSuite) SetupSuite() {
 		suite.LastCrashMessage = fmt.Sprint(v...)
 		panic(v)
 	}
-	newServer = func(options chartmuseum.ServerOptions) (chartmuseum.Server, error) {
+	newServer = func(options chartmuseum.ServerOptions, opts ...chartmuseum.Option) (chartmuseum.Server, error) {
 		return nil, errors.New("graceful crash")
 	}
 
@@ -115,6 +137,37 @@ func (suite *MainTestSuite) TestMain() {
 	suite.Panics(main, "bad cache")
 	suite.Equal("Unsupported cache store: wallet", suite.LastCrashMessage, "crashes with bad cache")
 
+	// export
+	os.Args = []string{"chartmuseum", "export", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage"}
+	suite.Panics(main, "export without --out")
+	suite.Equal("Missing required flags(s): --out", suite.LastCrashMessage, "crashes without --out")
+
+	os.Args = []string{"chartmuseum", "export", "--out", "../../.chartexport", "--storage", "local", "--storage-local-rootdir", "../../.chartstorage"}
+	suite.Panics(main, "export")
+	suite.Equal("graceful crash", suite.LastCrashMessage, "no error exporting")
+}
+
+func (suite *MainTestSuite) TestReloadConfig() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err, "no error creating logger")
+	server := &reloadedServer{}
+
+	conf := config.NewConfig()
+	reloadConfig(conf, server, logger)
+	suite.Len(server.options, 0, "nothing reloaded without config file")
+
+	configFile := pathutil.Join(suite.T().TempDir(), "chartmuseum.yaml")
+	err = ioutil.WriteFile(configFile, []byte("basicauth.user: user\n"), 0644)
+	suite.Nil(err, "no error writing config file")
+	conf.SetConfigFile(configFile)
+	suite.Nil(conf.ReadInConfig(), "no error reading config file")
+
+	err = ioutil.WriteFile(configFile, []byte("basicauth.user: newuser\ntrash.retention: 24h\n"), 0644)
+	suite.Nil(err, "no error updating config file")
+	reloadConfig(conf, server, logger)
+	suite.Len(server.options, 1, "server reloaded")
+	suite.Equal("newuser", server.options[0].Username)
+	suite.Equal(24*time.Hour, server.options[0].TrashRetention)
 }
 
 func TestMainTestSuite(t *testing.T) {
@@ -18,6 +18,9 @@ package logger
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -29,12 +32,28 @@ type (
 	// Logger handles all logger from application
 	Logger struct {
 		*zap.SugaredLogger
+		// Level is the minimum level of messages logged, changed at runtime with SetLevel
+		Level        zap.AtomicLevel
+		defaultLevel zapcore.Level
+		// closer closes the file or the syslog connection written to, if any
+		closer io.Closer
 	}
 
 	// LoggerOptions are options for constructing a Logger
 	LoggerOptions struct {
 		Debug   bool
 		LogJSON bool
+		// Output is stderr, stdout, syslog or the path of a file, stderr by default
+		Output string
+		// MaxSize is the size in megabytes above which the file is rotated, RotateInterval how long
+		// it is written to before being rotated, never if 0, and MaxBackups the number of rotated files kept
+		MaxSize        int
+		RotateInterval time.Duration
+		MaxBackups     int
+		// SyslogAddress is the syslog server, e.g. udp://localhost:514, the local one if empty.
+		// SyslogTag is the tag of the messages sent, chartmuseum by default
+		SyslogAddress string
+		SyslogTag     string
 	}
 
 	// LoggingFn is generic logging function with some additonal context
@@ -50,6 +69,11 @@ const (
 	ErrorLevel logLevel = "ERROR"
 )
 
+const (
+	// OutputSyslog sends the logs to syslog instead of a file
+	OutputSyslog = "syslog"
+)
+
 // NewLogger creates a new Logger instance
 func NewLogger(options LoggerOptions) (*Logger, error) {
 	config := zap.NewDevelopmentConfig()
@@ -61,15 +85,97 @@ func NewLogger(options LoggerOptions) (*Logger, error) {
 	} else {
 		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
+	defaultLevel := zap.DebugLevel
 	if !options.Debug {
-		config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+		defaultLevel = zap.InfoLevel
+	}
+	config.Level = zap.NewAtomicLevelAt(defaultLevel)
+
+	var closer io.Closer
+	var logger *zap.Logger
+	var err error
+	switch options.Output {
+	case "", "stderr", "stdout":
+		if options.Output != "" {
+			config.OutputPaths = []string{options.Output}
+		}
+		logger, err = config.Build()
+	default:
+		// colors are left to terminals
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		var core zapcore.Core
+		core, closer, err = newOutputCore(config, options)
+		if err == nil {
+			logger = zap.New(core, zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+		}
 	}
-	logger, err := config.Build()
 	if err != nil {
 		return new(Logger), err
 	}
 	defer logger.Sync()
-	return &Logger{logger.Sugar()}, nil
+	return &Logger{SugaredLogger: logger.Sugar(), Level: config.Level, defaultLevel: defaultLevel, closer: closer}, nil
+}
+
+// newOutputCore returns the core writing to syslog or a rotating file, and the closer of either
+func newOutputCore(config zap.Config, options LoggerOptions) (zapcore.Core, io.Closer, error) {
+	if options.Output == OutputSyslog {
+		// syslog records the time of the messages
+		config.EncoderConfig.TimeKey = ""
+		tag := options.SyslogTag
+		if tag == "" {
+			tag = "chartmuseum"
+		}
+		return newSyslogCore(newEncoder(config), config.Level, options.SyslogAddress, tag)
+	}
+	file, err := NewRotatingFile(RotatingFileOptions{
+		Path:       options.Output,
+		MaxSize:    int64(options.MaxSize) * 1024 * 1024,
+		Interval:   options.RotateInterval,
+		MaxBackups: options.MaxBackups,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(newEncoder(config), file, config.Level), file, nil
+}
+
+func newEncoder(config zap.Config) zapcore.Encoder {
+	if config.Encoding == "json" {
+		return zapcore.NewJSONEncoder(config.EncoderConfig)
+	}
+	return zapcore.NewConsoleEncoder(config.EncoderConfig)
+}
+
+// Close closes the file or the syslog connection the logger writes to, if any
+func (logger *Logger) Close() error {
+	if logger.closer == nil {
+		return nil
+	}
+	logger.Sync()
+	return logger.closer.Close()
+}
+
+// SetLevel changes the minimum level of messages logged, one of debug, info, warn or error
+func (logger *Logger) SetLevel(level string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil || l < zap.DebugLevel || l > zap.ErrorLevel {
+		return fmt.Errorf("invalid log level %q, must be debug, info, warn or error", level)
+	}
+	logger.Level.SetLevel(l)
+	return nil
+}
+
+// ToggleDebug switches to debug messages, or back to the level the logger was created with,
+// and returns the new level
+func (logger *Logger) ToggleDebug() string {
+	if logger.Level.Level() == zap.DebugLevel && logger.defaultLevel != zap.DebugLevel {
+		logger.Level.SetLevel(logger.defaultLevel)
+	} else if logger.Level.Level() == zap.DebugLevel {
+		logger.Level.SetLevel(zap.InfoLevel)
+	} else {
+		logger.Level.SetLevel(zap.DebugLevel)
+	}
+	return logger.Level.String()
 }
 
 /*
@@ -115,13 +221,16 @@ func (logger *Logger) Errorc(c *gin.Context, msg string, keysAndValues ...interf
 	logger.Errorw(msg, keysAndValues...)
 }
 
-// transformLogcArgs prefixes msg with RequestCount and adds RequestId to keysAndValues
+// transformLogcArgs prefixes msg with RequestCount and adds RequestId and TraceId to keysAndValues
 func transformLogcArgs(c *gin.Context, msg string, keysAndValues []interface{}) (string, []interface{}) {
 	if reqCount, exists := c.Get("requestcount"); exists {
 		msg = fmt.Sprintf("[%s] %s", reqCount, msg)
 		if reqID, exists := c.Get("requestid"); exists {
 			keysAndValues = append(keysAndValues, "reqID", reqID)
 		}
+		if traceID, exists := c.Get("traceid"); exists {
+			keysAndValues = append(keysAndValues, "traceID", traceID)
+		}
 	}
 	return msg, keysAndValues
 }
@@ -17,7 +17,14 @@ limitations under the License.
 package logger
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
@@ -76,6 +83,109 @@ func (suite *LoggerTestSuite) TestContextLoggingFn() {
 	log(ErrorLevel, "ContextLoggingFn error test", "x", "y")
 }
 
+func (suite *LoggerTestSuite) TestSetLevel() {
+	logger, err := NewLogger(LoggerOptions{})
+	suite.Nil(err)
+	suite.Equal("info", logger.Level.String(), "info level without debug")
+
+	suite.Nil(logger.SetLevel("warn"), "no error setting level")
+	suite.Equal("warn", logger.Level.String())
+	suite.Nil(logger.SetLevel("DEBUG"), "no error setting level in capitals")
+	suite.Equal("debug", logger.Level.String())
+	suite.NotNil(logger.SetLevel("verbose"), "error with unknown level")
+	suite.NotNil(logger.SetLevel("fatal"), "error with level silencing errors")
+	suite.Equal("debug", logger.Level.String(), "level unchanged on error")
+
+	suite.Equal("info", logger.ToggleDebug(), "back to the level of the logger")
+	suite.Equal("debug", logger.ToggleDebug(), "debug toggled on")
+
+	logger, err = NewLogger(LoggerOptions{Debug: true})
+	suite.Nil(err)
+	suite.Equal("info", logger.ToggleDebug(), "debug toggled off")
+	suite.Equal("debug", logger.ToggleDebug(), "debug toggled on")
+}
+
+func (suite *LoggerTestSuite) TestRotatingFile() {
+	tempDir, err := ioutil.TempDir("", "chartmuseum-log")
+	suite.Nil(err)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "access.log")
+
+	file, err := NewRotatingFile(RotatingFileOptions{Path: path, MaxSize: 10, MaxBackups: 2})
+	suite.Nil(err, "no error opening file")
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		_, err := file.Write([]byte(line))
+		suite.Nil(err, "no error writing")
+	}
+	suite.Nil(file.Close())
+	suite.Nil(file.Close(), "no error closing twice")
+	_, err = file.Write([]byte("line 5\n"))
+	suite.Equal(os.ErrClosed, err, "error writing once closed")
+
+	for name, expected := range map[string]string{"access.log": "line 4\n", "access.log.1": "line 3\n", "access.log.2": "line 2\n"} {
+		content, err := ioutil.ReadFile(filepath.Join(tempDir, name))
+		suite.Nil(err, "no error reading %s", name)
+		suite.Equal(expected, string(content), name)
+	}
+	_, err = os.Stat(path + ".3")
+	suite.True(os.IsNotExist(err), "oldest file removed beyond max backups")
+
+	path = filepath.Join(tempDir, "interval.log")
+	file, err = NewRotatingFile(RotatingFileOptions{Path: path, Interval: time.Hour, MaxBackups: 1})
+	suite.Nil(err, "no error opening file")
+	file.Write([]byte("line 1\n"))
+	file.Write([]byte("line 2\n"))
+	file.rotateAt = time.Now()
+	file.Write([]byte("line 3\n"))
+	suite.Nil(file.Close())
+	content, _ := ioutil.ReadFile(path)
+	suite.Equal("line 3\n", string(content), "rotated once the interval is over")
+	content, _ = ioutil.ReadFile(path + ".1")
+	suite.Equal("line 1\nline 2\n", string(content))
+}
+
+func (suite *LoggerTestSuite) TestOutput() {
+	tempDir, err := ioutil.TempDir("", "chartmuseum-log")
+	suite.Nil(err)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "chartmuseum.log")
+
+	logger, err := NewLogger(LoggerOptions{Output: path, MaxSize: 1, MaxBackups: 1})
+	suite.Nil(err, "no error logging to a file")
+	logger.Infow("Starting ChartMuseum", "port", 8080)
+	logger.Debug("not logged below info")
+	suite.Nil(logger.Close())
+	content, err := ioutil.ReadFile(path)
+	suite.Nil(err)
+	suite.Contains(string(content), "INFO\tStarting ChartMuseum\t{\"port\": 8080}", "levels without colors")
+	suite.NotContains(string(content), "not logged")
+
+	_, err = NewLogger(LoggerOptions{Output: filepath.Join(tempDir, "missing", "chartmuseum.log")})
+	suite.NotNil(err, "error with a file in a missing directory")
+
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		return
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Nil(err)
+	defer conn.Close()
+	logger, err = NewLogger(LoggerOptions{Output: OutputSyslog, LogJSON: true, SyslogAddress: "udp://" + conn.LocalAddr().String()})
+	suite.Nil(err, "no error logging to syslog")
+	logger.With("repo", "org1").Warnw("Could not review service account token", "error", "timeout")
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	suite.Nil(err, "message received")
+	message := string(buf[:n])
+	suite.True(strings.HasPrefix(message, "<28>"), "daemon facility with warning severity, got %q", message)
+	suite.Contains(message, "chartmuseum")
+	suite.Contains(message, `{"L":"WARN","M":"Could not review service account token","repo":"org1","error":"timeout"}`)
+	suite.Nil(logger.Close())
+
+	_, err = NewLogger(LoggerOptions{Output: OutputSyslog, SyslogAddress: "localhost:514"})
+	suite.NotNil(err, "error with an address missing its network")
+}
+
 func TestLoggerTestSuite(t *testing.T) {
 	suite.Run(t, new(LoggerTestSuite))
 }
@@ -0,0 +1,143 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// RotatingFile is a file renaming itself to path.1, path.2 and so on when growing above its
+	// max size, or once written to for its rotation interval
+	RotatingFile struct {
+		path       string
+		maxSize    int64
+		interval   time.Duration
+		maxBackups int
+		file       *os.File
+		size       int64
+		rotateAt   time.Time
+		mutex      sync.Mutex
+	}
+
+	// RotatingFileOptions are options for opening a RotatingFile
+	RotatingFileOptions struct {
+		Path string
+		// MaxSize is the size in bytes above which the file is rotated, never if 0
+		MaxSize int64
+		// Interval is how long the file is written to before being rotated, never if 0
+		Interval time.Duration
+		// MaxBackups is the number of rotated files kept
+		MaxBackups int
+	}
+)
+
+// NewRotatingFile opens a RotatingFile, appending to the file at its path if any
+func NewRotatingFile(options RotatingFileOptions) (*RotatingFile, error) {
+	file := &RotatingFile{
+		path:       options.Path,
+		maxSize:    options.MaxSize,
+		interval:   options.Interval,
+		maxBackups: options.MaxBackups,
+	}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *RotatingFile) open() error {
+	f, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	file.file = f
+	file.size = info.Size()
+	if file.interval > 0 {
+		file.rotateAt = time.Now().Add(file.interval)
+	}
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would make it grow above its max size or
+// its rotation interval is over
+func (file *RotatingFile) Write(p []byte) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.file == nil {
+		return 0, os.ErrClosed
+	}
+	if file.size > 0 && ((file.maxSize > 0 && file.size+int64(len(p)) > file.maxSize) ||
+		(file.interval > 0 && !time.Now().Before(file.rotateAt))) {
+		if err := file.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.file.Write(p)
+	file.size += int64(n)
+	return n, err
+}
+
+// Sync commits the content of the file to disk
+func (file *RotatingFile) Sync() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.file == nil {
+		return nil
+	}
+	return file.file.Sync()
+}
+
+// Close closes the file, later writes failing with os.ErrClosed
+func (file *RotatingFile) Close() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if file.file == nil {
+		return nil
+	}
+	err := file.file.Close()
+	file.file = nil
+	return err
+}
+
+// rotate shifts the rotated files, dropping the oldest beyond maxBackups, and starts a new file
+func (file *RotatingFile) rotate() error {
+	if err := file.file.Close(); err != nil {
+		return err
+	}
+	file.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", file.path, file.maxBackups))
+	for i := file.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", file.path, i), fmt.Sprintf("%s.%d", file.path, i+1))
+	}
+	if file.maxBackups > 0 {
+		if err := os.Rename(file.path, file.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(file.path); err != nil {
+		return err
+	}
+	return file.open()
+}
@@ -0,0 +1,100 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// syslogCore writes the entries logged to syslog, with the severity of their level
+	syslogCore struct {
+		zapcore.LevelEnabler
+		encoder zapcore.Encoder
+		writer  *syslog.Writer
+	}
+)
+
+// newSyslogCore connects to the syslog server at address, e.g. udp://localhost:514, or to the
+// local one without address
+func newSyslogCore(encoder zapcore.Encoder, level zapcore.LevelEnabler, address string, tag string) (zapcore.Core, io.Closer, error) {
+	network, raddr := "", ""
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "unix") {
+			return nil, nil, fmt.Errorf("invalid syslog address %q, must be a udp://, tcp:// or unix:// url", address)
+		}
+		network, raddr = u.Scheme, u.Host
+		if u.Scheme == "unix" {
+			raddr = u.Path
+		}
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &syslogCore{LevelEnabler: level, encoder: encoder, writer: writer}, writer, nil
+}
+
+func (core *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := core.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+	return &syslogCore{LevelEnabler: core.LevelEnabler, encoder: encoder, writer: core.writer}
+}
+
+func (core *syslogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if core.Enabled(entry.Level) {
+		return checked.AddCore(entry, core)
+	}
+	return checked
+}
+
+func (core *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := core.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	message := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+	switch entry.Level {
+	case zapcore.DebugLevel:
+		return core.writer.Debug(message)
+	case zapcore.InfoLevel:
+		return core.writer.Info(message)
+	case zapcore.WarnLevel:
+		return core.writer.Warning(message)
+	case zapcore.ErrorLevel:
+		return core.writer.Err(message)
+	default:
+		return core.writer.Crit(message)
+	}
+}
+
+func (core *syslogCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// AccessLogFormatJSON writes one json object per request, with the fields selected
+	AccessLogFormatJSON = "json"
+	// AccessLogFormatCombined writes the Apache combined log format
+	AccessLogFormatCombined = "combined"
+)
+
+var (
+	// accessLogFields are the fields of json access logs, all written unless some are selected
+	accessLogFields = []string{"time", "clientIP", "method", "path", "status", "size", "latency",
+		"tenant", "user", "chart", "version", "userAgent", "referer", "requestID"}
+)
+
+type (
+	// AccessLogger writes a line per request served, apart from the application logs
+	AccessLogger struct {
+		Format string
+		Fields []string
+		writer io.Writer
+		mutex  sync.Mutex
+	}
+
+	// AccessLoggerOptions are options for constructing an AccessLogger
+	AccessLoggerOptions struct {
+		// Output is stdout, stderr or the path of a file
+		Output string
+		Format string
+		Fields []string
+		// MaxSize is the size in megabytes above which a file is rotated, never if 0,
+		// MaxBackups the number of rotated files kept
+		MaxSize    int
+		MaxBackups int
+	}
+)
+
+// NewAccessLogger creates a new AccessLogger, opening its file if it writes to one
+func NewAccessLogger(options AccessLoggerOptions) (*AccessLogger, error) {
+	format := options.Format
+	if format == "" {
+		format = AccessLogFormatJSON
+	}
+	if format != AccessLogFormatJSON && format != AccessLogFormatCombined {
+		return nil, fmt.Errorf("invalid access log format %q, must be %s or %s", format, AccessLogFormatJSON, AccessLogFormatCombined)
+	}
+	fields := accessLogFields
+	if len(options.Fields) > 0 {
+		fields = nil
+		for _, field := range options.Fields {
+			known := false
+			for _, f := range accessLogFields {
+				if strings.EqualFold(field, f) {
+					fields = append(fields, f)
+					known = true
+				}
+			}
+			if !known {
+				return nil, fmt.Errorf("invalid access log field %q, must be one of %s", field, strings.Join(accessLogFields, ", "))
+			}
+		}
+	}
+
+	accessLogger := &AccessLogger{Format: format, Fields: fields}
+	switch options.Output {
+	case "", "stdout", "-":
+		accessLogger.writer = os.Stdout
+	case "stderr":
+		accessLogger.writer = os.Stderr
+	default:
+		file, err := cm_logger.NewRotatingFile(cm_logger.RotatingFileOptions{
+			Path:       options.Output,
+			MaxSize:    int64(options.MaxSize) * 1024 * 1024,
+			MaxBackups: options.MaxBackups,
+		})
+		if err != nil {
+			return nil, err
+		}
+		accessLogger.writer = file
+	}
+	return accessLogger, nil
+}
+
+// Close closes the file of the access logger, if any
+func (accessLogger *AccessLogger) Close() error {
+	accessLogger.mutex.Lock()
+	defer accessLogger.mutex.Unlock()
+	if file, ok := accessLogger.writer.(*cm_logger.RotatingFile); ok {
+		return file.Close()
+	}
+	return nil
+}
+
+// Log writes the line of a request served, started at start
+func (accessLogger *AccessLogger) Log(c *gin.Context, start time.Time) {
+	var line []byte
+	if accessLogger.Format == AccessLogFormatCombined {
+		line = []byte(combinedLogLine(c, start))
+	} else {
+		entry := map[string]interface{}{}
+		for _, field := range accessLogger.Fields {
+			if value := accessLogField(c, start, field); value != nil {
+				entry[field] = value
+			}
+		}
+		line, _ = json.Marshal(entry)
+		line = append(line, '\n')
+	}
+
+	accessLogger.mutex.Lock()
+	defer accessLogger.mutex.Unlock()
+	accessLogger.writer.Write(line)
+}
+
+// accessLogField returns the value of a field of json access logs, or nil if the request has none
+func accessLogField(c *gin.Context, start time.Time, field string) interface{} {
+	var value string
+	switch field {
+	case "time":
+		return start.Format(time.RFC3339Nano)
+	case "status":
+		return c.Writer.Status()
+	case "size":
+		// gin reports -1 until the body is written
+		if c.Writer.Size() < 0 {
+			return 0
+		}
+		return c.Writer.Size()
+	case "latency":
+		return time.Since(start).Seconds()
+	case "clientIP":
+		value = c.ClientIP()
+	case "method":
+		value = c.Request.Method
+	case "path":
+		value = c.Request.URL.EscapedPath()
+	case "tenant":
+		value = c.Param("repo")
+	case "user":
+		value = RequestUser(c.Request)
+	case "chart":
+		value, _ = requestChart(c)
+	case "version":
+		_, value = requestChart(c)
+	case "userAgent":
+		value = c.Request.UserAgent()
+	case "referer":
+		value = c.Request.Referer()
+	case "requestID":
+		value = c.GetString("requestid")
+	}
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// combinedLogLine formats a request as Apache does with its combined log format
+func combinedLogLine(c *gin.Context, start time.Time) string {
+	dash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	size := "-"
+	if c.Writer.Size() > 0 {
+		size = fmt.Sprint(c.Writer.Size())
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		c.ClientIP(),
+		dash(RequestUser(c.Request)),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		c.Request.Method, c.Request.URL.RequestURI(), c.Request.Proto,
+		c.Writer.Status(),
+		size,
+		dash(c.Request.Referer()),
+		dash(c.Request.UserAgent()),
+	)
+}
+
+// requestChart returns the name and version of the chart of a request, from the api
+// routes or the filename of a chart package or provenance file
+func requestChart(c *gin.Context) (string, string) {
+	if name := c.Param("name"); name != "" {
+		return name, c.Param("version")
+	}
+	filename := strings.TrimSuffix(c.Param("filename"), ".prov")
+	if !strings.HasSuffix(filename, "."+cm_repo.ChartPackageFileExtension) {
+		return "", ""
+	}
+	return cm_repo.ChartNameVersionFromPackageFilename(filename)
+}
+
+// RequestUser returns the name of the user of a request, from basic auth or the subject
+// of a bearer token, as the client sent it. Once the request is authorized, it is the user authenticated
+func RequestUser(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok {
+		return username
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Subject
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// isProbePath reports whether a path is one of the health checks, /health and /live
+// reporting that the process is up and /ready that it can serve charts
+func isProbePath(path string) bool {
+	return path == "/health" || path == "/live" || path == "/ready"
+}
+
+// isAdminPath reports whether a path is served on the admin port when it is set:
+// metrics, health checks and the /api/admin routes
+func (router *Router) isAdminPath(path string) bool {
+	if path == "/metrics" {
+		return true
+	}
+	if router.ContextPath != "" {
+		if !strings.HasPrefix(path, router.ContextPath+"/") {
+			return false
+		}
+		path = strings.TrimPrefix(path, router.ContextPath)
+	}
+	return isProbePath(path) || strings.HasPrefix(path, "/api/admin/")
+}
+
+// adminHandler serves only the admin paths, or only the other paths
+func (router *Router) adminHandler(admin bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if router.isAdminPath(r.URL.Path) != admin {
+			router.writeNotFound(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// writeNotFound writes a 404 for the handlers of other listeners than the main one, as routes
+// not found are written
+func (router *Router) writeNotFound(w http.ResponseWriter, r *http.Request) {
+	if router.LegacyErrorBodies {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"not found"}`))
+		return
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(newProblem(http.StatusNotFound, ErrorCodeNotFound, "not found", r.URL.Path))
+}
+
+// startAdminServer serves the admin paths on the admin port, until the end of the shutdown of
+// the main listener so that health checks and metrics are still served while draining
+func (router *Router) startAdminServer() {
+	server := router.newHTTPServer(router.AdminPort)
+	server.Handler = router.adminHandler(true)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		router.Logger.Fatal(err)
+	}
+	router.Logger.Infow("Starting admin listener",
+		"address", listener.Addr().String(),
+	)
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			router.Logger.Fatal(err)
+		}
+	}()
+	router.RegisterOnShutdown(func(ctx context.Context) {
+		server.Shutdown(ctx)
+	})
+}
@@ -0,0 +1,267 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrUnauthorized denies requests without valid credentials
+	ErrUnauthorized = &AuthError{Status: http.StatusUnauthorized, Message: "unauthorized"}
+	// ErrForbidden denies requests of users not allowed to perform the action
+	ErrForbidden = &AuthError{Status: http.StatusForbidden, Message: "forbidden"}
+)
+
+type (
+	// Authorizer decides whether a request may perform an action, cm_auth.PullAction or
+	// cm_auth.PushAction, on a resource, the repo of the route or "" for the root one. It returns
+	// nil to allow it, an *AuthError such as ErrUnauthorized or ErrForbidden to deny it, and other
+	// errors when it cannot decide. The request is available with RequestFromContext
+	Authorizer interface {
+		Authorize(ctx context.Context, action string, resource string) error
+	}
+
+	// AuthorizerFunc is a func used as an Authorizer
+	AuthorizerFunc func(ctx context.Context, action string, resource string) error
+
+	// AuthError denies a request with a 401 or 403 status, and the WWW-Authenticate header of the
+	// response if any
+	AuthError struct {
+		Status          int
+		Message         string
+		WWWAuthenticate string
+	}
+
+	// TokenAuthorizerOptions are options for constructing a bearer token Authorizer, as with
+	// --bearer-auth
+	TokenAuthorizerOptions struct {
+		Realm                 string
+		Service               string
+		AuthCertPath          string
+		AuthActionsSearchPath string
+		AnonymousGet          bool
+	}
+
+	// RBACAuthorizer allows the users of a request the actions their roles grant. Users are those
+	// of RequestUser, so it only authorizes: the credentials are checked by the server or an
+	// Authorizer registered before it
+	RBACAuthorizer struct {
+		// Roles are the rules granting actions to each role
+		Roles map[string][]RBACRule
+		// Users are the roles of each user
+		Users map[string][]string
+	}
+
+	// RBACRule grants actions on a repo and the repos under it, or on every repo with ""
+	RBACRule struct {
+		Repo    string   `json:"repo"`
+		Actions []string `json:"actions"`
+	}
+
+	// credentialsAuthorizer allows requests with the credentials accepted by any of its authorizers
+	credentialsAuthorizer struct {
+		authorizers []*cm_auth.Authorizer
+	}
+
+	requestContextKey struct{}
+)
+
+// Authorize calls f(ctx, action, resource)
+func (f AuthorizerFunc) Authorize(ctx context.Context, action string, resource string) error {
+	return f(ctx, action, resource)
+}
+
+func (err *AuthError) Error() string {
+	return err.Message
+}
+
+// RequestFromContext returns the request being authorized, or nil outside of an Authorizer
+func RequestFromContext(ctx context.Context) *http.Request {
+	request, _ := ctx.Value(requestContextKey{}).(*http.Request)
+	return request
+}
+
+// NewBasicAuthorizer returns an Authorizer accepting the basic auth credentials of any of the
+// users, with their passwords. With anonymousGet, requests without credentials may pull charts
+func NewBasicAuthorizer(credentials map[string]string, anonymousGet bool) Authorizer {
+	authorizer := &credentialsAuthorizer{}
+	for _, username := range sortedKeys(credentials) {
+		// basic auth authorizers are never in error
+		basicAuthorizer, _ := cm_auth.NewAuthorizer(&cm_auth.AuthorizerOptions{
+			Realm:    "ChartMuseum",
+			Username: username,
+			Password: credentials[username],
+		})
+		if anonymousGet {
+			basicAuthorizer.AnonymousActions = []string{cm_auth.PullAction}
+		}
+		authorizer.authorizers = append(authorizer.authorizers, basicAuthorizer)
+	}
+	return authorizer
+}
+
+// NewTokenAuthorizer returns an Authorizer accepting the bearer tokens signed by an auth server,
+// as with --bearer-auth
+func NewTokenAuthorizer(options TokenAuthorizerOptions) (Authorizer, error) {
+	tokenAuthorizer, err := cm_auth.NewAuthorizer(&cm_auth.AuthorizerOptions{
+		Realm:                    options.Realm,
+		Service:                  options.Service,
+		PublicKeyPath:            options.AuthCertPath,
+		AllowedActionsSearchPath: options.AuthActionsSearchPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if options.AnonymousGet {
+		tokenAuthorizer.AnonymousActions = []string{cm_auth.PullAction}
+	}
+	return &credentialsAuthorizer{authorizers: []*cm_auth.Authorizer{tokenAuthorizer}}, nil
+}
+
+func (authorizer *credentialsAuthorizer) Authorize(ctx context.Context, action string, resource string) error {
+	if len(authorizer.authorizers) == 0 {
+		return ErrUnauthorized
+	}
+	authHeader := ""
+	if request := RequestFromContext(ctx); request != nil {
+		authHeader = request.Header.Get("Authorization")
+	}
+	namespace := resource
+	if namespace == "" {
+		namespace = cm_auth.DefaultNamespace
+	}
+	return permissionError(authorize(authorizer.authorizers, authHeader, action, namespace))
+}
+
+// Authorize allows the request if a role of its user grants the action on the resource. Requests
+// without a user are unauthorized, those of users without such a role forbidden
+func (authorizer *RBACAuthorizer) Authorize(ctx context.Context, action string, resource string) error {
+	user := ""
+	if request := RequestFromContext(ctx); request != nil {
+		user = RequestUser(request)
+	}
+	if user == "" {
+		return ErrUnauthorized
+	}
+	for _, role := range authorizer.Users[user] {
+		for _, rule := range authorizer.Roles[role] {
+			if rule.allows(action, resource) {
+				return nil
+			}
+		}
+	}
+	return ErrForbidden
+}
+
+// allows tells whether the rule grants an action on a repo, its repo matching on path segment
+// boundaries as tenant prefixes do
+func (rule RBACRule) allows(action string, repo string) bool {
+	prefix := strings.Trim(rule.Repo, "/")
+	repo = strings.Trim(repo, "/")
+	if prefix != "" && repo != prefix && !strings.HasPrefix(repo, prefix+"/") {
+		return false
+	}
+	for _, allowed := range rule.Actions {
+		if allowed == action || allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantAuthOnly tells whether tenants have credentials of their own while the server has none,
+// neither basic auth, bearer tokens nor service account tokens, leaving routes on no repo open
+func (router *Router) TenantAuthOnly() bool {
+	router.authLock.RLock()
+	serverAuth := router.Authorizer != nil || router.KubeAuth != nil
+	router.authLock.RUnlock()
+	return !serverAuth && router.TenantConfig.HasCredentials()
+}
+
+// RegisterAuthorizer adds an Authorizer consulted for every request the server credentials allow,
+// the request being denied unless each Authorizer registered allows it
+func (router *Router) RegisterAuthorizer(authorizer Authorizer) {
+	router.authLock.Lock()
+	defer router.authLock.Unlock()
+	router.Authorizers = append(router.Authorizers, authorizer)
+}
+
+// AuthorizeRequest checks whether a request may perform an action on a repo, with the credentials
+// of the server first and then the Authorizers registered. It returns nil when allowed, an
+// *AuthError when denied
+func (router *Router) AuthorizeRequest(request *http.Request, action string, repo string) error {
+	if err := permissionError(router.Authorize(request.Header.Get("Authorization"), action, repo)); err != nil {
+		return err
+	}
+	return router.authorizeRegistered(request, action, repo)
+}
+
+// authorizeRegistered checks a request against the Authorizers registered with RegisterAuthorizer
+func (router *Router) authorizeRegistered(request *http.Request, action string, repo string) error {
+	router.authLock.RLock()
+	authorizers := router.Authorizers
+	router.authLock.RUnlock()
+	ctx := context.WithValue(request.Context(), requestContextKey{}, request)
+	for _, authorizer := range authorizers {
+		if err := authorizer.Authorize(ctx, action, repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAuthError writes the response of a request denied by AuthorizeRequest, or a 500 when it
+// could not be authorized
+func WriteAuthError(c *gin.Context, err error) {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		WriteError(c, http.StatusInternalServerError, ErrorCodeInternal, "internal server error")
+		return
+	}
+	if authErr.WWWAuthenticate != "" {
+		c.Header("WWW-Authenticate", authErr.WWWAuthenticate)
+	}
+	WriteError(c, authErr.Status, statusErrorCode(authErr.Status), authErr.Message)
+}
+
+// IsAuthError tells whether err denies a request, rather than keeping it from being authorized
+func IsAuthError(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr)
+}
+
+// permissionError is the error of AuthorizeRequest for the permission of chartmuseum/auth authorizers
+func permissionError(permission *cm_auth.Permission, err error) error {
+	if err != nil {
+		return err
+	}
+	if !permission.Allowed {
+		return &AuthError{
+			Status:          http.StatusUnauthorized,
+			Message:         "unauthorized",
+			WWWAuthenticate: permission.WWWAuthenticateHeader,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	pathutil "path"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertPrefix holds the account key and certificates obtained with --tls-auto. Being nested,
+// its objects are not listed with the charts of the root repo
+const autocertPrefix = ".autocert"
+
+type (
+	// autocertCache keeps the certificates obtained with ACME in the storage backend,
+	// so replicas of the server share them and they survive restarts
+	autocertCache struct {
+		backend cm_storage.Backend
+	}
+)
+
+func newAutocertManager(options RouterOptions) (*autocert.Manager, error) {
+	if len(options.TLSAutoHosts) == 0 {
+		return nil, errors.New("--tls-auto requires the hosts to obtain certificates for")
+	}
+	if options.TlsCert != "" || options.TlsKey != "" {
+		return nil, errors.New("--tls-auto cannot be used with --tls-cert and --tls-key")
+	}
+	if options.TLSAutoStorage == nil {
+		return nil, errors.New("--tls-auto requires a storage backend")
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      &autocertCache{backend: options.TLSAutoStorage},
+		HostPolicy: autocert.HostWhitelist(options.TLSAutoHosts...),
+		Email:      options.TLSAutoEmail,
+	}
+	if options.TLSAutoDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: options.TLSAutoDirectoryURL}
+	}
+	return manager, nil
+}
+
+// autocertTLSConfig serves the certificates of the ACME manager, answering tls-alpn-01 challenges
+func (router *Router) autocertTLSConfig() *tls.Config {
+	config := router.TLSAutoManager.TLSConfig()
+	config.MinVersion = router.TLSMinVersion
+	config.CipherSuites = router.TLSCipherSuites
+	if router.DisableHTTP2 {
+		config.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	}
+	return config
+}
+
+func (cache *autocertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	object, err := cache.backend.GetObject(pathutil.Join(autocertPrefix, key))
+	if err != nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	return object.Content, nil
+}
+
+func (cache *autocertCache) Put(ctx context.Context, key string, data []byte) error {
+	return cache.backend.PutObject(pathutil.Join(autocertPrefix, key), data)
+}
+
+func (cache *autocertCache) Delete(ctx context.Context, key string) error {
+	return cache.backend.DeleteObject(pathutil.Join(autocertPrefix, key))
+}
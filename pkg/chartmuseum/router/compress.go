@@ -0,0 +1,135 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(ioutil.Discard) },
+}
+
+type (
+	// gzipResponseWriter compresses a response if its content type is one of types and its
+	// first write is at least minSize bytes, which is the whole body for c.Data and c.JSON
+	gzipResponseWriter struct {
+		gin.ResponseWriter
+		types   []string
+		minSize int
+		decided bool
+		gzip    *gzip.Writer
+	}
+)
+
+// compressionMiddleware gzips the responses of requests accepting it, such as index.yaml
+// and the api responses, leaving chart packages and event streams as they are
+func compressionMiddleware(types []string, minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer, types: types, minSize: minSize}
+		c.Writer = writer
+		defer func() {
+			if writer.gzip != nil {
+				writer.gzip.Close()
+				gzipWriterPool.Put(writer.gzip)
+			}
+			c.Writer = writer.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.start(len(data))
+	}
+	if w.gzip != nil {
+		return w.gzip.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gzip != nil {
+		w.gzip.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// start sets the headers of a compressed response, before they are written with the first write
+func (w *gzipResponseWriter) start(size int) {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || !compressibleType(header.Get("Content-Type"), w.types) {
+		return
+	}
+	header.Add("Vary", "Accept-Encoding")
+	if size < w.minSize {
+		return
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gzip = gzipWriterPool.Get().(*gzip.Writer)
+	w.gzip.Reset(w.ResponseWriter)
+}
+
+func compressibleType(contentType string, types []string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	for _, t := range types {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		rejected := false
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[len("q="):], 64)
+				rejected = err == nil && q == 0
+			}
+		}
+		if !rejected {
+			return true
+		}
+	}
+	return false
+}
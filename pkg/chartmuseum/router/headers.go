@@ -0,0 +1,108 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// responseHeaderStart matches the items of the response headers starting a header, a name
+	// optionally prefixed with a route group, followed by a colon
+	responseHeaderStart = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~=-]+:`)
+
+	// responseHeaderGroups are the groups of routes headers may be restricted to
+	responseHeaderGroups = map[string]bool{"api": true, "repo": true, "oci": true}
+)
+
+type (
+	responseHeader struct {
+		Name  string
+		Value string
+	}
+)
+
+// parseResponseHeaders parses comma-separated headers, e.g. "X-Frame-Options: DENY,api=Cache-Control: no-store",
+// by route group, the headers of all routes being under "". Items not starting with a header name
+// and a colon continue the value of the previous header, for values holding commas
+func parseResponseHeaders(value string) (map[string][]responseHeader, error) {
+	headers := map[string][]responseHeader{}
+	var last *responseHeader
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !responseHeaderStart.MatchString(item) {
+			if last == nil {
+				return nil, fmt.Errorf("invalid response header %q, must be Name: value", item)
+			}
+			last.Value += ", " + item
+			continue
+		}
+		colon := strings.Index(item, ":")
+		group, name := "", item[:colon]
+		if equal := strings.Index(name, "="); equal >= 0 {
+			group, name = name[:equal], name[equal+1:]
+			if !responseHeaderGroups[group] {
+				return nil, fmt.Errorf("invalid route group %q of response header %s, must be api, repo or oci", group, name)
+			}
+		}
+		headerValue := strings.TrimSpace(item[colon+1:])
+		if name == "" || strings.Contains(name, "=") || headerValue == "" {
+			return nil, fmt.Errorf("invalid response header %q, must be Name: value", item)
+		}
+		headers[group] = append(headers[group], responseHeader{Name: http.CanonicalHeaderKey(name), Value: headerValue})
+		last = &headers[group][len(headers[group])-1]
+	}
+	return headers, nil
+}
+
+// routeGroup returns the group of a route response headers may be restricted to, "api" for the
+// routes prefixed with /api, "oci" for the OCI distribution routes and "repo" for the other routes
+// of repos, such as index.yaml and chart downloads
+func routeGroup(route *Route) string {
+	switch {
+	case strings.HasPrefix(route.Path, "/api/"):
+		return "api"
+	case strings.HasPrefix(route.Path, "/v2/"):
+		return "oci"
+	case strings.Contains(route.Path, ":repo"):
+		return "repo"
+	}
+	return ""
+}
+
+// responseHeadersMiddleware sets headers on every response, errors and routes not found included
+func responseHeadersMiddleware(headers []responseHeader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setResponseHeaders(c, headers)
+		c.Next()
+	}
+}
+
+// setResponseHeaders sets headers before the handler of a request, which may still replace them
+func setResponseHeaders(c *gin.Context, headers []responseHeader) {
+	for _, header := range headers {
+		c.Header(header.Name, header.Value)
+	}
+}
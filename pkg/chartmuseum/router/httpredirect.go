@@ -0,0 +1,107 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HTTPModeRedirect redirects every request of the plain http port to https
+	HTTPModeRedirect = "redirect"
+	// HTTPModeHealth serves only the health checks on the plain http port
+	HTTPModeHealth = "health"
+)
+
+func validateHTTPMode(mode string) error {
+	switch mode {
+	case "", HTTPModeRedirect, HTTPModeHealth:
+		return nil
+	}
+	return fmt.Errorf("invalid tls http mode %q, must be %s or %s", mode, HTTPModeRedirect, HTTPModeHealth)
+}
+
+// httpHandler serves the plain http port of a server listening with tls on tlsPort. Clients with
+// the http url of a repo are redirected to https, with a 308 for the methods other than GET and
+// HEAD so that uploads are not turned into GET requests. With ACME, it answers http-01 challenges
+func (router *Router) httpHandler(tlsPort int) http.Handler {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if router.HTTPMode == HTTPModeHealth {
+			path := r.URL.Path
+			if router.ContextPath != "" {
+				path = strings.TrimPrefix(path, router.ContextPath)
+			}
+			if !isProbePath(path) {
+				router.writeNotFound(w, r)
+				return
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, httpsURL(r, tlsPort), status)
+	})
+	if router.TLSAutoManager != nil {
+		handler = router.TLSAutoManager.HTTPHandler(handler)
+	}
+	return handler
+}
+
+// httpsURL is the url of a request on the tls port, the port left out when it is 443
+func httpsURL(r *http.Request, tlsPort int) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tlsPort != 443 && tlsPort != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// startHTTPServer listens on the plain http port besides the tls one, until the end of the
+// shutdown of the main listener
+func (router *Router) startHTTPServer(tlsPort int) {
+	server := router.newHTTPServer(router.HTTPPort)
+	server.Handler = router.httpHandler(tlsPort)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		router.Logger.Fatal(err)
+	}
+	router.Logger.Infow("Starting http listener",
+		"address", listener.Addr().String(),
+		"mode", router.HTTPMode,
+	)
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			router.Logger.Fatal(err)
+		}
+	}()
+	router.RegisterOnShutdown(func(ctx context.Context) {
+		server.Shutdown(ctx)
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+const (
+	// systemdListenFDsStart is the first file descriptor passed with systemd socket activation
+	systemdListenFDsStart = 3
+
+	// unixSocketMode lets the group of the server reach the socket, e.g. a local reverse proxy
+	unixSocketMode = 0660
+)
+
+// listen opens the listener of the server: the socket passed by systemd socket activation,
+// the unix socket if one is set, or else host:port, reading the PROXY protocol header if enabled
+func (router *Router) listen(port int) (net.Listener, error) {
+	listener, err := router.openListener(port)
+	if err != nil || !router.ProxyProtocol {
+		return listener, err
+	}
+	return newProxyProtocolListener(listener, router.TrustedProxies), nil
+}
+
+func (router *Router) openListener(port int) (net.Listener, error) {
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		return listener, err
+	}
+	if router.UnixSocket != "" {
+		return listenUnixSocket(router.UnixSocket)
+	}
+	return net.Listen("tcp", fmt.Sprintf("%s:%d", router.Host, port))
+}
+
+// systemdListener returns the first socket passed with systemd socket activation,
+// or nil if the server was not started that way, see sd_listen_fds(3)
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	// not inherited by child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(systemdListenFDsStart), "LISTEN_FD_3")
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// listenUnixSocket listens on a unix socket, replacing the socket left by a previous run
+func listenUnixSocket(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -56,27 +56,28 @@ func match(routes []*Route, method string, url string, contextPath string, depth
 	var repo, repoPath, noRepoPath string
 	var startIndex, numNoRepoPathParts int
 	var tryRepoRoutes bool
+	var routePrefix string
 
 	if contextPath != "" {
 		if url == contextPath {
 			url = "/"
-		} else if strings.HasPrefix(url, contextPath) {
+		} else if strings.HasPrefix(url, contextPath+"/") {
 			url = strings.Replace(url, contextPath, "", 1)
 		} else {
 			return nil, nil
 		}
 	}
 
-	if url == "/health" && method == http.MethodGet {
+	if isProbePath(url) && method == http.MethodGet {
 		for _, route := range routes {
-			if route.Path == "/health" {
+			if route.Path == url {
 				return route, nil
 			}
 		}
 	}
 
-	isApiRoute := checkApiRoute(url)
-	if isApiRoute {
+	routePrefix = getRoutePrefix(url)
+	if routePrefix != "" {
 		startIndex = 2
 	} else {
 		startIndex = 1
@@ -86,14 +87,8 @@ func match(routes []*Route, method string, url string, contextPath string, depth
 
 	if depthdynamic {
 		for _, route := range routes {
-			if isApiRoute {
-				if !strings.HasPrefix(route.Path, "/api") {
-					continue
-				}
-			} else {
-				if strings.HasPrefix(route.Path, "/api") {
-					continue
-				}
+			if getRoutePrefix(route.Path) != routePrefix {
+				continue
 			}
 			depth = getDepth(url, route.Path)
 			if depth >= 0 {
@@ -109,9 +104,9 @@ func match(routes []*Route, method string, url string, contextPath string, depth
 			repo = strings.Join(repoParts, "/")
 			noRepoPath = "/" + strings.Join(pathSplit[depth+startIndex:], "/")
 			repoPath = "/:repo" + noRepoPath
-			if isApiRoute {
-				repoPath = "/api" + repoPath
-				noRepoPath = "/api" + noRepoPath
+			if routePrefix != "" {
+				repoPath = routePrefix + repoPath
+				noRepoPath = routePrefix + noRepoPath
 			}
 			noRepoPathSplit = strings.Split(noRepoPath, "/")
 			numNoRepoPathParts = len(noRepoPathSplit)
@@ -156,6 +151,18 @@ func checkApiRoute(url string) bool {
 	return strings.HasPrefix(url, "/api/") && !validRepoRoute.MatchString(url)
 }
 
+// getRoutePrefix returns the prefix of routes followed by the repo, "/api" for the chart
+// manipulation routes and "/v2" for the OCI distribution routes
+func getRoutePrefix(url string) string {
+	if checkApiRoute(url) {
+		return "/api"
+	}
+	if strings.HasPrefix(url, "/v2/") && !validRepoRoute.MatchString(url) {
+		return "/v2"
+	}
+	return ""
+}
+
 func splitPath(key string) []string {
 	key = strings.Trim(key, "/ ")
 	if key == "" {
@@ -35,7 +35,7 @@ func (suite *MatchTestSuite) TestMatch() {
 
 	handlers := []gin.HandlerFunc{}
 
-	for i := 0; i <= 9; i++ {
+	for i := 0; i <= 10; i++ {
 		{
 			j := i
 			handlers = append(handlers, func(c *gin.Context) {
@@ -55,6 +55,7 @@ func (suite *MatchTestSuite) TestMatch() {
 		{"POST", "/api/:repo/charts", handlers[7], cm_auth.PushAction},
 		{"POST", "/api/:repo/prov", handlers[8], cm_auth.PushAction},
 		{"DELETE", "/api/:repo/charts/:name/:version", handlers[9], cm_auth.PushAction},
+		{"GET", "/v2/:repo/:name/manifests/:reference", handlers[10], cm_auth.PullAction},
 	}
 
 	for depth := 0; depth <= 3; depth++ {
@@ -230,12 +231,44 @@ func (suite *MatchTestSuite) TestMatch() {
 			suite.True(exists)
 			suite.Equal(9, val)
 			suite.Equal([]gin.Param{{"name", "mychart"}, {"version", "0.1.0"}, {"repo", repo}}, params)
+
+			// GET /v2/mychart/manifests/0.1.0
+			r = pathutil.Join("/", contextPath, "v2", repo, "mychart/manifests/0.1.0")
+			route, params = match(routes, "GET", r, contextPath, depth, false)
+			routeWithDepthDynamic, paramsWithDepthDynamic = match(routes, "GET", r, contextPath, 0, true)
+			suite.Equal(route, routeWithDepthDynamic)
+			suite.Equal(params, paramsWithDepthDynamic)
+
+			suite.NotNil(route)
+			if route != nil {
+				route.Handler(c)
+			}
+			val, exists = c.Get("index")
+			suite.True(exists)
+			suite.Equal(10, val)
+			suite.Equal([]gin.Param{{"name", "mychart"}, {"reference", "0.1.0"}, {"repo", repo}}, params)
 		}
 	}
 
+	// Test route repos named "v2"
+	route, params := match(routes, "GET", "/v2/index.yaml", "", 1, false)
+	suite.NotNil(route)
+	if route != nil {
+		route.Handler(c)
+	}
+	val, exists := c.Get("index")
+	suite.True(exists)
+	suite.Equal(2, val)
+	suite.Equal([]gin.Param{{"repo", "v2"}}, params)
+
+	// Test context path only matched on a path segment boundary
+	route, params = match(routes, "GET", "/xy/index.yaml", "/x", 0, false)
+	suite.Nil(route, "no route outside of context path")
+	suite.Nil(params)
+
 	// Test route repos named "api*"
 	r := "/apix/index.yaml"
-	route, params := match(routes, "GET", r, "", 1, false)
+	route, params = match(routes, "GET", r, "", 1, false)
 	routeWithDepthDynamic, paramsWithDepthDynamic := match(routes, "GET", r, "", 0, true)
 	suite.Equal(route, routeWithDepthDynamic)
 	suite.Equal(params, paramsWithDepthDynamic)
@@ -244,7 +277,7 @@ func (suite *MatchTestSuite) TestMatch() {
 	if route != nil {
 		route.Handler(c)
 	}
-	val, exists := c.Get("index")
+	val, exists = c.Get("index")
 	suite.True(exists)
 	suite.Equal(2, val)
 	suite.Equal([]gin.Param{{"repo", "apix"}}, params)
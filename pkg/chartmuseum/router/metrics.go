@@ -0,0 +1,77 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"helm.sh/chartmuseum/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Number of requests to the routes of a repo
+	tenantRequestCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "chartmuseum",
+			Name:      "tenant_requests_total",
+			Help:      "Number of HTTP requests per repo",
+		},
+		[]string{"repo", "method", "code"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tenantRequestCounterVec)
+}
+
+// countTenantRequest is deferred by rootHandler, so requests failing auth are counted too. Repos
+// are only given a label once a request to them is authorized, requests failing auth being
+// counted under tenant.OtherMetricsLabel for repos without one
+func countTenantRequest(c *gin.Context) {
+	label := tenant.AssignedMetricsLabel(c.Param("repo"))
+	tenantRequestCounterVec.WithLabelValues(label, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+}
+
+// metricsAuth protects /metrics with credentials of its own, apart from those of the repos, so
+// that scrapers can be given access to the metrics only. Either credential is accepted when both are set
+func metricsAuth(username string, password string, bearerToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := c.GetHeader("Authorization")
+		if bearerToken != "" && strings.HasPrefix(authorization, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(bearerToken)) == 1 {
+			return
+		}
+		if user, pass, ok := c.Request.BasicAuth(); ok && username != "" &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1 {
+			return
+		}
+		if username != "" {
+			c.Header("WWW-Authenticate", `Basic realm="metrics"`)
+		} else {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+		}
+		WriteError(c, http.StatusUnauthorized, ErrorCodeUnauthorized, "unauthorized")
+		c.Abort()
+	}
+}
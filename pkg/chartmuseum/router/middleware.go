@@ -17,6 +17,7 @@ limitations under the License.
 package router
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -29,17 +30,22 @@ import (
 	"github.com/gofrs/uuid"
 )
 
+type (
+	requestIDContextKey struct{}
+)
+
 var (
 	requestCount         int64
 	requestServedMessage = "Request served"
 )
 
-func requestWrapper(logger *cm_logger.Logger, logHealth bool, logLatencyInt bool) func(c *gin.Context) {
+func requestWrapper(logger *cm_logger.Logger, accessLogger *AccessLogger, logHealth bool, logLatencyInt bool) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		setupContext(c)
 
 		reqPath := c.Request.URL.EscapedPath()
-		logRequest := !strings.HasSuffix(reqPath, "/health") || logHealth
+		logRequest := logHealth || !(strings.HasSuffix(reqPath, "/health") ||
+			strings.HasSuffix(reqPath, "/live") || strings.HasSuffix(reqPath, "/ready"))
 		if logRequest {
 			logger.Debugc(c, fmt.Sprintf("Incoming request: %s", reqPath))
 		}
@@ -47,6 +53,10 @@ func requestWrapper(logger *cm_logger.Logger, logHealth bool, logLatencyInt bool
 
 		c.Next()
 
+		if accessLogger != nil && logRequest {
+			accessLogger.Log(c, start)
+		}
+
 		status := c.Writer.Status()
 
 		meta := []interface{}{
@@ -77,13 +87,45 @@ func requestWrapper(logger *cm_logger.Logger, logHealth bool, logLatencyInt bool
 	}
 }
 
+// validRequestID reports whether the X-Request-Id of a client may be used in logs and responses:
+// at most 128 printable ascii characters, without spaces
+func validRequestID(reqID string) bool {
+	if reqID == "" || len(reqID) > 128 {
+		return false
+	}
+	for _, r := range reqID {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrorBody is the JSON body of error responses, with the id of the request so that failures
+// reported by clients can be found in the logs
+func ErrorBody(c *gin.Context, message string) gin.H {
+	body := gin.H{"error": message}
+	if reqID := c.GetString("requestid"); reqID != "" {
+		body["requestId"] = reqID
+	}
+	return body
+}
+
+// RequestIDFromContext returns the id of the request of ctx, if any
+func RequestIDFromContext(ctx context.Context) string {
+	reqID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return reqID
+}
+
 func setupContext(c *gin.Context) {
 	reqCount := strconv.FormatInt(atomic.AddInt64(&requestCount, 1), 10)
 	c.Set("requestcount", reqCount)
 	reqID := c.Request.Header.Get("X-Request-Id")
-	if reqID == "" {
+	if !validRequestID(reqID) {
 		reqID = uuid.Must(uuid.NewV4()).String()
 	}
 	c.Set("requestid", reqID)
 	c.Writer.Header().Set("X-Request-Id", reqID)
+	// for work done on behalf of the request without its gin context, e.g. index regeneration
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDContextKey{}, reqID))
 }
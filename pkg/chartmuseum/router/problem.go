@@ -0,0 +1,123 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the content type of error responses, see https://www.rfc-editor.org/rfc/rfc7807
+const ProblemContentType = "application/problem+json"
+
+// Error codes of problem responses, for clients to branch on the cause of failures
+const (
+	ErrorCodeBadRequest          = "bad_request"
+	ErrorCodeUnauthorized        = "unauthorized"
+	ErrorCodeForbidden           = "forbidden"
+	ErrorCodeNotFound            = "not_found"
+	ErrorCodeConflict            = "conflict"
+	ErrorCodeReadOnly            = "read_only"
+	ErrorCodeVersionExists       = "version_exists"
+	ErrorCodePreconditionFailed  = "precondition_failed"
+	ErrorCodeInvalidChart        = "invalid_chart"
+	ErrorCodeChecksumMismatch    = "checksum_mismatch"
+	ErrorCodeStorageLimit        = "storage_limit_reached"
+	ErrorCodeStorageUnavailable  = "storage_unavailable"
+	ErrorCodeUpstreamUnavailable = "upstream_unavailable"
+	ErrorCodeScanFailed          = "scan_failed"
+	ErrorCodeMaintenance         = "maintenance"
+	ErrorCodeNotReady            = "not_ready"
+	ErrorCodeTimeout             = "timeout"
+	ErrorCodeCancelled           = "cancelled"
+	ErrorCodeInternal            = "internal_error"
+
+	legacyErrorsKey = "legacyerrors"
+)
+
+type (
+	// Problem is the RFC 7807 body of error responses, with the error code and the request id
+	// as extension members
+	Problem struct {
+		Type      string `json:"type"`
+		Title     string `json:"title"`
+		Status    int    `json:"status"`
+		Detail    string `json:"detail,omitempty"`
+		Instance  string `json:"instance,omitempty"`
+		Code      string `json:"code"`
+		RequestID string `json:"requestId,omitempty"`
+	}
+)
+
+// NewProblem returns the problem of a request failing with status, code and message. Without a
+// code, the status text is used, e.g. "not_found" for 404
+func NewProblem(c *gin.Context, status int, code string, message string) *Problem {
+	var instance string
+	if c.Request != nil {
+		instance = c.Request.URL.Path
+	}
+	problem := newProblem(status, code, message, instance)
+	problem.RequestID = c.GetString("requestid")
+	return problem
+}
+
+// WriteError writes an error response as problem+json, or as the {"error": message} body of
+// former releases with --legacy-error-bodies
+func WriteError(c *gin.Context, status int, code string, message string) {
+	if c.GetBool(legacyErrorsKey) {
+		c.JSON(status, ErrorBody(c, message))
+		return
+	}
+	// set first, as c.JSON keeps the content type of the response if set
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(status, NewProblem(c, status, code, message))
+}
+
+func newProblem(status int, code string, message string, instance string) *Problem {
+	if code == "" {
+		code = statusErrorCode(status)
+	}
+	return &Problem{
+		Type:     "urn:chartmuseum:error:" + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   message,
+		Instance: instance,
+		Code:     code,
+	}
+}
+
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusPreconditionFailed:
+		return ErrorCodePreconditionFailed
+	case http.StatusGatewayTimeout:
+		return ErrorCodeTimeout
+	}
+	return ErrorCodeInternal
+}
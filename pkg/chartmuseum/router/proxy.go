@@ -0,0 +1,235 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout bounds how long a trusted proxy may take to send the PROXY protocol header
+	proxyHeaderTimeout = 10 * time.Second
+
+	// proxyV1MaxLength is the longest PROXY protocol v1 header, see the spec
+	proxyV1MaxLength = 107
+)
+
+// proxyV2Signature starts PROXY protocol v2 headers
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+type (
+	// proxyProtocolListener reads the PROXY protocol header sent by trusted proxies, such as
+	// load balancers passing tcp through, so that the remote address is the one of the client
+	proxyProtocolListener struct {
+		net.Listener
+		trustedProxies []*net.IPNet
+	}
+
+	// proxyProtocolConn reads the header on first use, in the goroutine serving the connection
+	proxyProtocolConn struct {
+		net.Conn
+		reader     *bufio.Reader
+		once       sync.Once
+		remoteAddr net.Addr
+		err        error
+	}
+)
+
+// parseTrustedProxies parses the ips and cidrs of the proxies trusted to forward client info
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isTrustedProxy reports whether a peer may forward client info. Without trusted proxies,
+// every peer is trusted as before they could be set
+func isTrustedProxy(trustedProxies []*net.IPNet, addr string) bool {
+	if len(trustedProxies) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustsForwardedHeaders reports whether the X-Forwarded-* headers of a request are used,
+// which is when it comes from a trusted proxy
+func (router *Router) TrustsForwardedHeaders(r *http.Request) bool {
+	return isTrustedProxy(router.TrustedProxies, r.RemoteAddr)
+}
+
+func newProxyProtocolListener(listener net.Listener, trustedProxies []*net.IPNet) net.Listener {
+	return &proxyProtocolListener{Listener: listener, trustedProxies: trustedProxies}
+}
+
+// Accept wraps the connections of trusted proxies, which must send the PROXY protocol header
+func (listener *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !isTrustedProxy(listener.trustedProxies, conn.RemoteAddr().String()) {
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (conn *proxyProtocolConn) readHeader() {
+	conn.once.Do(func() {
+		conn.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		conn.remoteAddr, conn.err = readProxyHeader(conn.reader)
+		conn.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (conn *proxyProtocolConn) Read(b []byte) (int, error) {
+	conn.readHeader()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(b)
+}
+
+// RemoteAddr is the address of the client sent by the proxy, or the one of the proxy
+// for health checks of the proxy itself
+func (conn *proxyProtocolConn) RemoteAddr() net.Addr {
+	conn.readHeader()
+	if conn.remoteAddr != nil {
+		return conn.remoteAddr
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header, returning the source address
+// it carries, or nil for connections of the proxy itself
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		return readProxyV2Header(reader)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyV1Header(reader)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// readProxyV1Header reads a header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyV1Header(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("invalid PROXY protocol version")
+	}
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, addresses); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections are health checks of the proxy itself
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	var ipLength int
+	switch header[13] {
+	case 0x11: // tcp over ipv4
+		ipLength = net.IPv4len
+	case 0x21: // tcp over ipv6
+		ipLength = net.IPv6len
+	default:
+		return nil, nil
+	}
+	if len(addresses) < 2*ipLength+4 {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+	return &net.TCPAddr{
+		IP:   net.IP(addresses[:ipLength]),
+		Port: int(binary.BigEndian.Uint16(addresses[2*ipLength:])),
+	}, nil
+}
@@ -17,39 +17,108 @@ limitations under the License.
 package router
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	"helm.sh/chartmuseum/pkg/kubeauth"
+	"helm.sh/chartmuseum/pkg/tenant"
+	"helm.sh/chartmuseum/pkg/tracing"
 
 	cm_auth "github.com/chartmuseum/auth"
+	cm_storage "github.com/chartmuseum/storage"
 	limits "github.com/gin-contrib/size"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	ginprometheus "github.com/zsais/go-gin-prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type (
 	// Router handles all incoming HTTP requests
 	Router struct {
 		*gin.Engine
-		Logger          *cm_logger.Logger
-		Authorizer      *cm_auth.Authorizer
-		Routes          []*Route
-		TlsCert         string
-		TlsKey          string
-		TlsCACert       string
+		Logger     *cm_logger.Logger
+		Authorizer *cm_auth.Authorizer
+		Routes     []*Route
+		TlsCert    string
+		TlsKey     string
+		TlsCACert  string
+		// TLSMinVersion and TLSCipherSuites are left to crypto/tls when zero
+		TLSMinVersion   uint16
+		TLSCipherSuites []uint16
+		DisableHTTP2    bool
+		// TLSAutoManager obtains and renews certificates with ACME, e.g. from Let's Encrypt
+		TLSAutoManager *autocert.Manager
+		// HTTPPort is listened on with plain http besides the tls port, in HTTPMode
+		HTTPPort        int
+		HTTPMode        string
 		ContextPath     string
 		Depth           int
 		DepthDynamic    bool
 		CORSAllowOrigin string
 		ReadTimeout     time.Duration
 		WriteTimeout    time.Duration
-		Host            string
+		// IdleTimeout, ReadHeaderTimeout and MaxHeaderBytes keep slow or idle clients
+		// from holding connections, see http.Server
+		IdleTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		MaxHeaderBytes    int
+		// ShutdownTimeout bounds how long in-flight requests and shutdown hooks may run on SIGTERM
+		ShutdownTimeout time.Duration
+		// RequestTimeout is the deadline of the context of each request, but for event streams
+		RequestTimeout time.Duration
+		Host           string
+		// UnixSocket is listened on instead of Host and the port
+		UnixSocket string
+		// TrustedProxies may send X-Forwarded-* headers, and the PROXY protocol header with
+		// ProxyProtocol. Without them, every peer is trusted
+		TrustedProxies []*net.IPNet
+		ProxyProtocol  bool
+		// AdminPort serves metrics, health checks and the /api/admin routes apart from the charts
+		AdminPort     int
+		TenantConfig  *tenant.Config
+		AnonymousGet  bool
+		EnableMetrics bool
+		// AnonymousMethods are the methods of the routes writing to repos, e.g. POST, that
+		// requests without credentials may use
+		AnonymousMethods map[string]bool
+		// TenantHost matches hosts naming their tenant, e.g. teama.charts.example.com
+		TenantHost *regexp.Regexp
+		// TracerProvider records a span for each request, continuing the traces of clients
+		TracerProvider *sdktrace.TracerProvider
+		// KubeAuth accepts the tokens of Kubernetes service accounts, besides the credentials of Authorizer
+		KubeAuth *kubeauth.Authenticator
+		// Authorizers are consulted once the credentials of the server allow a request, see RegisterAuthorizer
+		Authorizers []Authorizer
+		// AccessLogger writes a line per request for log pipelines, apart from the application logs
+		AccessLogger *AccessLogger
+		// LegacyErrorBodies writes errors as {"error": message} instead of problem+json
+		LegacyErrorBodies bool
+		// UploadProgressBytes logs the progress of request bodies every so many bytes received,
+		// and their throughput once received
+		UploadProgressBytes int64
+
+		shutdownHooks []func(ctx context.Context)
+		shutdownOnce  sync.Once
+		bearerAuth    bool
+		// responseHeaders are set on the responses of the routes of their group, see routeGroup
+		responseHeaders map[string][]responseHeader
+		// authLock guards Authorizer and AnonymousGet, replaced on config reload, and Authorizers
+		authLock sync.RWMutex
 	}
 
 	// RouterOptions are options for constructing a Router
@@ -62,10 +131,24 @@ type (
 		TlsCert               string
 		TlsKey                string
 		TlsCACert             string
+		TLSMinVersion         string
+		TLSCipherSuites       []string
+		DisableHTTP2          bool
+		TLSAuto               bool
+		TLSAutoHosts          []string
+		TLSAutoEmail          string
+		TLSAutoDirectoryURL   string
+		TLSAutoStorage        cm_storage.Backend
+		TLSHTTPPort           int
+		TLSHTTPMode           string
 		PathPrefix            string
 		LogHealth             bool
 		EnableMetrics         bool
+		MetricsUsername       string
+		MetricsPassword       string
+		MetricsBearerToken    string
 		AnonymousGet          bool
+		AnonymousMethods      []string
 		Depth                 int
 		MaxUploadSize         int
 		BearerAuth            bool
@@ -76,8 +159,34 @@ type (
 		DepthDynamic          bool
 		ReadTimeout           int
 		WriteTimeout          int
+		ShutdownTimeout       int
+		RequestTimeout        int
+		IdleTimeout           int
+		ReadHeaderTimeout     int
+		MaxHeaderBytes        int
 		CORSAllowOrigin       string
+		EnableCompression     bool
+		CompressionMinSize    int
+		CompressionTypes      []string
 		Host                  string
+		UnixSocket            string
+		TrustedProxies        []string
+		ProxyProtocol         bool
+		AdminPort             int
+		TenantConfig          *tenant.Config
+		TenantHostPattern     string
+		TracerProvider        *sdktrace.TracerProvider
+		KubeAuth              *kubeauth.Authenticator
+		Authorizers           []Authorizer
+		EnableAccessLog       bool
+		AccessLogOutput       string
+		AccessLogFormat       string
+		AccessLogFields       []string
+		AccessLogMaxSize      int
+		AccessLogMaxBackups   int
+		LegacyErrorBodies     bool
+		ResponseHeaders       string
+		LogUploadProgress     int
 	}
 
 	// Route represents an application route
@@ -95,29 +204,80 @@ func NewRouter(options RouterOptions) *Router {
 	engine := gin.New()
 	engine.RedirectTrailingSlash = false // This was causing /health to 301 to /health/
 	engine.Use(gin.Recovery())
-	engine.Use(requestWrapper(options.Logger, options.LogHealth, options.LogLatencyInteger))
+	if options.TracerProvider != nil {
+		engine.Use(otelgin.Middleware("chartmuseum",
+			otelgin.WithTracerProvider(options.TracerProvider),
+			otelgin.WithPropagators(tracing.Propagator),
+		))
+	}
+	var accessLogger *AccessLogger
+	if options.EnableAccessLog {
+		var err error
+		accessLogger, err = NewAccessLogger(AccessLoggerOptions{
+			Output:     options.AccessLogOutput,
+			Format:     options.AccessLogFormat,
+			Fields:     options.AccessLogFields,
+			MaxSize:    options.AccessLogMaxSize,
+			MaxBackups: options.AccessLogMaxBackups,
+		})
+		if err != nil {
+			options.Logger.Fatal(err)
+		}
+	}
+	engine.Use(requestWrapper(options.Logger, accessLogger, options.LogHealth, options.LogLatencyInteger))
 	engine.Use(limits.RequestSizeLimiter(int64(options.MaxUploadSize)))
+	if options.EnableCompression {
+		engine.Use(compressionMiddleware(options.CompressionTypes, options.CompressionMinSize))
+	}
 
 	if options.EnableMetrics {
 		p := ginprometheus.NewPrometheus("chartmuseum")
 		p.ReqCntURLLabelMappingFn = mapURLWithParamsBackToRouteTemplate
-		p.Use(engine)
+		if options.MetricsUsername != "" || options.MetricsBearerToken != "" {
+			engine.Use(p.HandlerFunc())
+			engine.GET(p.MetricsPath, metricsAuth(options.MetricsUsername, options.MetricsPassword, options.MetricsBearerToken),
+				gin.WrapH(promhttp.Handler()))
+		} else {
+			p.Use(engine)
+		}
 	}
 
 	router := &Router{
-		Engine:          engine,
-		Routes:          []*Route{},
-		Logger:          options.Logger,
-		TlsCert:         options.TlsCert,
-		TlsKey:          options.TlsKey,
-		TlsCACert:       options.TlsCACert,
-		ContextPath:     options.ContextPath,
-		Depth:           options.Depth,
-		DepthDynamic:    options.DepthDynamic,
-		CORSAllowOrigin: options.CORSAllowOrigin,
-		ReadTimeout:     time.Duration(options.ReadTimeout) * time.Second,
-		WriteTimeout:    time.Duration(options.WriteTimeout) * time.Second,
-		Host:            options.Host,
+		Engine:            engine,
+		Routes:            []*Route{},
+		Logger:            options.Logger,
+		TlsCert:           options.TlsCert,
+		TlsKey:            options.TlsKey,
+		TlsCACert:         options.TlsCACert,
+		DisableHTTP2:      options.DisableHTTP2,
+		ContextPath:       options.ContextPath,
+		Depth:             options.Depth,
+		DepthDynamic:      options.DepthDynamic,
+		CORSAllowOrigin:   options.CORSAllowOrigin,
+		ReadTimeout:       time.Duration(options.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(options.WriteTimeout) * time.Second,
+		ShutdownTimeout:   time.Duration(options.ShutdownTimeout) * time.Second,
+		RequestTimeout:    time.Duration(options.RequestTimeout) * time.Second,
+		IdleTimeout:       time.Duration(options.IdleTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(options.ReadHeaderTimeout) * time.Second,
+		MaxHeaderBytes:    options.MaxHeaderBytes,
+		Host:              options.Host,
+		UnixSocket:        options.UnixSocket,
+		ProxyProtocol:     options.ProxyProtocol,
+		AdminPort:         options.AdminPort,
+		TenantConfig:      options.TenantConfig,
+		AnonymousGet:      options.AnonymousGet,
+		EnableMetrics:     options.EnableMetrics,
+		TracerProvider:    options.TracerProvider,
+		KubeAuth:          options.KubeAuth,
+		Authorizers:       options.Authorizers,
+		AccessLogger:      accessLogger,
+		LegacyErrorBodies: options.LegacyErrorBodies,
+		bearerAuth:        options.BearerAuth,
+	}
+	router.UploadProgressBytes = int64(options.LogUploadProgress)
+	if accessLogger != nil {
+		router.RegisterOnShutdown(func(ctx context.Context) { accessLogger.Close() })
 	}
 
 	var err error
@@ -164,43 +324,170 @@ func NewRouter(options RouterOptions) *Router {
 
 	router.Authorizer = authorizer
 
+	if router.AnonymousMethods, err = parseAnonymousMethods(options.AnonymousMethods); err != nil {
+		router.Logger.Fatal(err)
+	}
+
+	if router.TLSMinVersion, err = parseTLSVersion(options.TLSMinVersion); err != nil {
+		router.Logger.Fatal(err)
+	}
+	if router.TLSCipherSuites, err = parseCipherSuites(options.TLSCipherSuites); err != nil {
+		router.Logger.Fatal(err)
+	}
+
+	if len(options.TrustedProxies) > 0 {
+		if router.TrustedProxies, err = parseTrustedProxies(options.TrustedProxies); err != nil {
+			router.Logger.Fatal(err)
+		}
+		// c.ClientIP() only reads X-Forwarded-For from trusted proxies
+		if err = engine.SetTrustedProxies(options.TrustedProxies); err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	if options.TLSAuto {
+		if router.TLSAutoManager, err = newAutocertManager(options); err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
+	if options.TLSHTTPPort > 0 {
+		if (options.TlsCert == "" || options.TlsKey == "") && !options.TLSAuto {
+			router.Logger.Fatal("--tls-http-port requires tls, with --tls-cert and --tls-key or --tls-auto")
+		}
+		if err = validateHTTPMode(options.TLSHTTPMode); err != nil {
+			router.Logger.Fatal(err)
+		}
+		router.HTTPPort, router.HTTPMode = options.TLSHTTPPort, options.TLSHTTPMode
+		if router.HTTPMode == "" {
+			router.HTTPMode = HTTPModeRedirect
+		}
+	}
+
+	if router.responseHeaders, err = parseResponseHeaders(options.ResponseHeaders); err != nil {
+		router.Logger.Fatal(err)
+	}
+	if headers := router.responseHeaders[""]; len(headers) > 0 {
+		engine.Use(responseHeadersMiddleware(headers))
+	}
+
+	if options.TenantHostPattern != "" {
+		router.TenantHost, err = tenantHostRegexp(options.TenantHostPattern)
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+	}
+
 	router.NoRoute(router.rootHandler)
 
 	return router
 }
 
 func (router *Router) Start(port int) {
+	listener, err := router.listen(port)
+	if err != nil {
+		router.Logger.Fatal(err)
+	}
 	router.Logger.Infow("Starting ChartMuseum",
-		"host", router.Host, "port", port,
+		"address", listener.Addr().String(),
 	)
 
-	server := http.Server{
-		Addr:         fmt.Sprintf("%s:%d", router.Host, port),
-		Handler:      router,
-		ReadTimeout:  router.ReadTimeout,
-		WriteTimeout: router.WriteTimeout,
+	server := router.newHTTPServer(port)
+	if router.AdminPort > 0 {
+		server.Handler = router.adminHandler(false)
+		router.startAdminServer()
 	}
-
+	listen := func() error { return server.Serve(listener) }
 	if router.TlsCert != "" && router.TlsKey != "" {
-		if router.TlsCACert != "" {
-			keypair, _ := tls.LoadX509KeyPair(router.TlsCert, router.TlsKey)
-			certpool := x509.NewCertPool()
-			capem, _ := ioutil.ReadFile(router.TlsCACert)
-			if !certpool.AppendCertsFromPEM(capem) {
-				router.Logger.Fatal("Can't parse CA certificate file")
-			}
-			server.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{keypair},
-				ClientAuth:   tls.RequireAndVerifyClientCert,
-				ClientCAs:    certpool,
+		server.TLSConfig, err = router.tlsConfig()
+		if err != nil {
+			router.Logger.Fatal(err)
+		}
+	} else if router.TLSAutoManager != nil {
+		server.TLSConfig = router.autocertTLSConfig()
+	}
+	if server.TLSConfig != nil {
+		if router.DisableHTTP2 {
+			// a non-nil map keeps net/http from negotiating h2
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+		listen = func() error { return server.ServeTLS(listener, "", "") }
+		if router.HTTPPort > 0 {
+			router.startHTTPServer(port)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	router.serve(server, listen, signals)
+}
+
+func (router *Router) newHTTPServer(port int) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", router.Host, port),
+		Handler:           router,
+		ReadTimeout:       router.ReadTimeout,
+		WriteTimeout:      router.WriteTimeout,
+		IdleTimeout:       router.IdleTimeout,
+		ReadHeaderTimeout: router.ReadHeaderTimeout,
+		MaxHeaderBytes:    router.MaxHeaderBytes,
+	}
+}
+
+// RegisterOnShutdown adds a hook run on shutdown once in-flight requests are done,
+// e.g. to finish writing indexes, with a context ending at the shutdown timeout
+func (router *Router) RegisterOnShutdown(hook func(ctx context.Context)) {
+	router.shutdownHooks = append(router.shutdownHooks, hook)
+}
+
+// Handler returns the handler of every route, the admin ones included whatever AdminPort, for
+// programs serving the router with their own http.Server, TLS and mux instead of Start
+func (router *Router) Handler() http.Handler {
+	return router
+}
+
+// Shutdown runs the hooks registered with RegisterOnShutdown, then exports the spans left. Start
+// calls it once in-flight requests are done, programs serving Handler once theirs are. Only the
+// first call counts
+func (router *Router) Shutdown(ctx context.Context) {
+	router.shutdownOnce.Do(func() {
+		for _, hook := range router.shutdownHooks {
+			hook(ctx)
+		}
+		// last, as requests and hooks record spans
+		if router.TracerProvider != nil {
+			if err := router.TracerProvider.Shutdown(ctx); err != nil {
+				router.Logger.Warnw("Could not export the spans left", "error", err.Error())
 			}
-			router.Logger.Fatal(server.ListenAndServeTLS("", ""))
-		} else {
-			router.Logger.Fatal(server.ListenAndServeTLS(router.TlsCert, router.TlsKey))
 		}
-	} else {
-		router.Logger.Fatal(server.ListenAndServe())
+	})
+}
+
+// serve runs the server until a signal is received, then stops accepting connections
+// and waits for in-flight requests and the shutdown hooks, up to the shutdown timeout
+func (router *Router) serve(server *http.Server, listen func() error, signals <-chan os.Signal) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := <-signals
+		router.Logger.Infow("Shutting down ChartMuseum",
+			"signal", sig.String(), "timeout", router.ShutdownTimeout.String(),
+		)
+		ctx, cancel := context.WithTimeout(context.Background(), router.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			router.Logger.Warnw("Requests still in flight at the end of the shutdown timeout",
+				"error", err.Error(),
+			)
+		}
+		router.Shutdown(ctx)
+	}()
+
+	if err := listen(); err != http.ErrServerClosed {
+		router.Logger.Fatal(err)
 	}
+	<-done
+	router.Logger.Info("ChartMuseum stopped")
 }
 
 // SetRoutes applies list of routes
@@ -210,45 +497,288 @@ func (router *Router) SetRoutes(routes []*Route) {
 
 // all incoming requests are passed through this handler
 func (router *Router) rootHandler(c *gin.Context) {
-	route, params := match(router.Routes, c.Request.Method, c.Request.URL.Path, router.ContextPath, router.Depth,
-		router.DepthDynamic)
+	var route *Route
+	var params []gin.Param
+	if router.LegacyErrorBodies {
+		c.Set(legacyErrorsKey, true)
+	}
+	if hostTenant, ok := router.tenantFromHost(c.Request.Host); ok {
+		// the tenant is not in the path, so routes match as with --depth=0
+		route, params = match(router.Routes, c.Request.Method, c.Request.URL.Path, router.ContextPath, 0, false)
+		for i := range params {
+			if params[i].Key == "repo" {
+				params[i].Value = hostTenant
+			}
+		}
+	} else {
+		route, params = match(router.Routes, c.Request.Method, c.Request.URL.Path, router.ContextPath, router.Depth,
+			router.DepthDynamic)
+	}
 	if route == nil {
-		c.JSON(404, gin.H{"error": "not found"})
+		WriteError(c, 404, ErrorCodeNotFound, "not found")
 		return
 	}
 	c.Params = params
+	if group := routeGroup(route); group != "" {
+		setResponseHeaders(c, router.responseHeaders[group])
+	}
 
-	if route.Action != "" && router.Authorizer != nil {
-		authHeader := c.Request.Header.Get("Authorization")
+	if router.TracerProvider != nil {
+		router.traceRequest(c, route)
+	}
 
-		namespace := c.Param("repo")
-		if namespace == "" {
-			namespace = cm_auth.DefaultNamespace
-		}
+	if router.EnableMetrics && strings.Contains(route.Path, ":repo") {
+		defer countTenantRequest(c)
+	}
 
-		permissions, err := router.Authorizer.Authorize(authHeader, route.Action, namespace)
-		if err != nil {
-			router.Logger.Error(err)
-			c.JSON(500, gin.H{"error": "internal server error"})
-			return
+	if route.Action != "" {
+		var err error
+		if router.anonymousWrite(c, route) {
+			// only the server and tenant credentials are skipped, registered Authorizers still decide
+			err = router.authorizeRegistered(c.Request, route.Action, c.Param("repo"))
+		} else {
+			err = router.AuthorizeRequest(c.Request, route.Action, c.Param("repo"))
 		}
-
-		if !permissions.Allowed {
-			if permissions.WWWAuthenticateHeader != "" {
-				c.Header("WWW-Authenticate", permissions.WWWAuthenticateHeader)
+		if err != nil {
+			if !IsAuthError(err) {
+				router.Logger.Error(err)
 			}
-			c.JSON(401, gin.H{"error": "unauthorized"})
+			WriteAuthError(c, err)
 			return
 		}
 	}
+	if router.EnableMetrics && strings.Contains(route.Path, ":repo") {
+		tenant.MetricsLabel(c.Param("repo"))
+	}
 
 	if checkApiRoute(c.Request.URL.Path) && router.CORSAllowOrigin != "" {
 		c.Header("Access-Control-Allow-Origin", router.CORSAllowOrigin)
 	}
 
+	if router.RequestTimeout > 0 && !strings.HasSuffix(route.Path, "/events") {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), router.RequestTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+	}
+	// skip the work of clients gone, or past their deadline, while being authorized
+	switch c.Request.Context().Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		WriteError(c, 504, ErrorCodeTimeout, "request timed out")
+		return
+	default:
+		return
+	}
+
+	if c.Request.ContentLength != 0 && (router.EnableMetrics || router.UploadProgressBytes > 0) {
+		defer router.countRequestBody(c, route)()
+	}
+
 	route.Handler(c)
 }
 
+// anonymousWrite tells whether a request without credentials may use a route writing to a repo
+// with its method, as set with AnonymousMethods, without the server credentials. Requests with
+// credentials are still authorized, for the user of the request to be the one authenticated
+func (router *Router) anonymousWrite(c *gin.Context, route *Route) bool {
+	return route.Action == cm_auth.PushAction && strings.Contains(route.Path, ":repo") &&
+		router.AnonymousMethods[c.Request.Method] && c.GetHeader("Authorization") == ""
+}
+
+func parseAnonymousMethods(methods []string) (map[string]bool, error) {
+	anonymousMethods := map[string]bool{}
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		switch method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			anonymousMethods[method] = true
+		default:
+			return nil, fmt.Errorf("invalid anonymous method %q, must be POST, PUT, PATCH or DELETE", method)
+		}
+	}
+	return anonymousMethods, nil
+}
+
+// tenantHostRegexp compiles a host pattern such as "{tenant}.charts.example.com"
+func tenantHostRegexp(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(strings.ToLower(pattern), "{tenant}")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid tenant host pattern %q, must contain {tenant} once", pattern)
+	}
+	return regexp.Compile("^" + regexp.QuoteMeta(parts[0]) + "([a-z0-9]([a-z0-9-]*[a-z0-9])?)" + regexp.QuoteMeta(parts[1]) + "$")
+}
+
+// tenantFromHost returns the tenant named by the host of a request, if it matches TenantHost
+func (router *Router) tenantFromHost(host string) (string, bool) {
+	if router.TenantHost == nil {
+		return "", false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	matches := router.TenantHost.FindStringSubmatch(strings.ToLower(host))
+	if matches == nil {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// Authorize checks whether a request with the given Authorization header may perform an action on a repo,
+// for handlers acting on repos other than the one in their route
+func (router *Router) Authorize(authHeader string, action string, repo string) (*cm_auth.Permission, error) {
+	if router.KubeAuth != nil {
+		allowed, err := router.KubeAuth.Authorize(context.Background(), authHeader, action, repo)
+		if err != nil {
+			// other credentials are still checked while the api server cannot review tokens
+			router.Logger.Warnc(&gin.Context{}, "Could not review service account token",
+				"error", err.Error(),
+			)
+		} else if allowed {
+			return &cm_auth.Permission{Allowed: true}, nil
+		}
+	}
+
+	if overrides := router.TenantConfig.Lookup(repo); overrides != nil && len(overrides.BasicAuthCredentials()) > 0 {
+		return router.tenantPermission(overrides, authHeader, action), nil
+	}
+	authorizers := router.authorizersForRepo(repo)
+	if len(authorizers) == 0 {
+		if router.KubeAuth != nil {
+			return router.kubeAuthPermission(action), nil
+		}
+		return &cm_auth.Permission{Allowed: true}, nil
+	}
+
+	namespace := repo
+	if namespace == "" {
+		namespace = cm_auth.DefaultNamespace
+	}
+	return authorize(authorizers, authHeader, action, namespace)
+}
+
+// kubeAuthPermission is the permission of requests not authenticated by KubeAuth, when it is
+// the only auth of the server
+func (router *Router) kubeAuthPermission(action string) *cm_auth.Permission {
+	router.authLock.RLock()
+	anonymousGet := router.AnonymousGet
+	router.authLock.RUnlock()
+	if anonymousGet && action == cm_auth.PullAction {
+		return &cm_auth.Permission{Allowed: true}
+	}
+	return &cm_auth.Permission{Allowed: false, WWWAuthenticateHeader: `Bearer realm="ChartMuseum"`}
+}
+
+// tenantPermission is the permission of a request on the repo of a tenant with its own credentials,
+// which are checked against the bcrypt hashes of their passwords
+func (router *Router) tenantPermission(overrides *tenant.Overrides, authHeader string, action string) *cm_auth.Permission {
+	router.authLock.RLock()
+	anonymousGet := router.AnonymousGet
+	router.authLock.RUnlock()
+	if overrides.AnonymousGet != nil {
+		anonymousGet = *overrides.AnonymousGet
+	}
+	if anonymousGet && action == cm_auth.PullAction {
+		return &cm_auth.Permission{Allowed: true}
+	}
+	request := &http.Request{Header: http.Header{"Authorization": []string{authHeader}}}
+	if username, password, ok := request.BasicAuth(); ok && overrides.Authenticate(username, password) {
+		return &cm_auth.Permission{Allowed: true}
+	}
+	return &cm_auth.Permission{Allowed: false, WWWAuthenticateHeader: `Basic realm="ChartMuseum"`}
+}
+
+// authorizersForRepo returns the authorizers of the tenant a repo belongs to, for tenants without
+// credentials of their own: tenants overriding anonymous access get a copy of the server one,
+// others share the server one
+func (router *Router) authorizersForRepo(repo string) []*cm_auth.Authorizer {
+	router.authLock.RLock()
+	serverAuthorizer, anonymousGet := router.Authorizer, router.AnonymousGet
+	router.authLock.RUnlock()
+
+	overrides := router.TenantConfig.Lookup(repo)
+	if overrides == nil {
+		if serverAuthorizer == nil {
+			return nil
+		}
+		return []*cm_auth.Authorizer{serverAuthorizer}
+	}
+
+	var authorizers []*cm_auth.Authorizer
+	if serverAuthorizer != nil && overrides.AnonymousGet != nil {
+		authorizer := *serverAuthorizer
+		authorizers = append(authorizers, &authorizer)
+	} else if serverAuthorizer != nil {
+		return []*cm_auth.Authorizer{serverAuthorizer}
+	} else {
+		return nil
+	}
+
+	if overrides.AnonymousGet != nil {
+		anonymousGet = *overrides.AnonymousGet
+	}
+	for _, authorizer := range authorizers {
+		authorizer.AnonymousActions = nil
+		if anonymousGet {
+			authorizer.AnonymousActions = []string{cm_auth.PullAction}
+		}
+	}
+	return authorizers
+}
+
+// SetBasicAuth replaces the basic auth credentials and anonymous access of the server on config reload
+func (router *Router) SetBasicAuth(username string, password string, anonymousGet bool) {
+	router.authLock.Lock()
+	defer router.authLock.Unlock()
+
+	authorizer := router.Authorizer
+	if router.bearerAuth {
+		if authorizer != nil {
+			copied := *authorizer
+			authorizer = &copied
+		}
+	} else if username != "" && password != "" {
+		// basic auth authorizers are never in error
+		authorizer, _ = cm_auth.NewAuthorizer(&cm_auth.AuthorizerOptions{
+			Realm:    "ChartMuseum",
+			Username: username,
+			Password: password,
+		})
+	} else {
+		authorizer = nil
+	}
+
+	if authorizer != nil {
+		authorizer.AnonymousActions = nil
+		if anonymousGet {
+			authorizer.AnonymousActions = []string{cm_auth.PullAction}
+		}
+	}
+	router.Authorizer = authorizer
+	router.AnonymousGet = anonymousGet
+}
+
+// authorize allows a request if any of the authorizers allows it
+func authorize(authorizers []*cm_auth.Authorizer, authHeader string, action string, namespace string) (*cm_auth.Permission, error) {
+	var permissions *cm_auth.Permission
+	for _, authorizer := range authorizers {
+		var err error
+		permissions, err = authorizer.Authorize(authHeader, action, namespace)
+		if err != nil || permissions.Allowed {
+			return permissions, err
+		}
+	}
+	return permissions, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 /*
 mapURLWithParamsBackToRouteTemplate is a valid ginprometheus ReqCntURLLabelMappingFn.
 For every route containing parameters (e.g. `/charts/:filename`, `/api/charts/:name/:version`, etc)
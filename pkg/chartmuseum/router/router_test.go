@@ -17,17 +17,37 @@ limitations under the License.
 package router
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	"helm.sh/chartmuseum/pkg/kubeauth"
+	"helm.sh/chartmuseum/pkg/tenant"
 
 	cm_auth "github.com/chartmuseum/auth"
+	cm_storage "github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"k8s.io/client-go/rest"
 	"net/http/httptest"
 )
 
@@ -199,6 +219,119 @@ func (suite *RouterTestSuite) TestRouterHandleContext() {
 	basicAuthRouterAnonGet.HandleContext(testContext)
 	suite.Equal(200, testContext.Writer.Status())
 
+	// Test basic auth (anonymous post)
+	basicAuthRouterAnonPost := NewRouter(RouterOptions{
+		Logger:           log,
+		Depth:            0,
+		Username:         "testuser",
+		Password:         "testpass",
+		AnonymousMethods: []string{"post"},
+	})
+	basicAuthRouterAnonPost.SetRoutes(append(testRoutes, &Route{"DELETE", "/api/:repo/writetorepo", func(c *gin.Context) {
+		c.Data(200, "text/html", []byte(c.GetString("repo")))
+	}, cm_auth.PushAction}))
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("POST", "/api/writetorepo", nil)
+	basicAuthRouterAnonPost.HandleContext(testContext)
+	suite.Equal(200, testContext.Writer.Status(), "anonymous post allowed")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("POST", "/api/writetorepo", nil)
+	testContext.Request.SetBasicAuth("baduser", "badpass")
+	basicAuthRouterAnonPost.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "credentials of post still checked")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("DELETE", "/api/writetorepo", nil)
+	basicAuthRouterAnonPost.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "anonymous delete not allowed")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("DELETE", "/api/writetorepo", nil)
+	testContext.Request.SetBasicAuth("testuser", "testpass")
+	basicAuthRouterAnonPost.HandleContext(testContext)
+	suite.Equal(200, testContext.Writer.Status(), "delete with credentials allowed")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/", nil)
+	basicAuthRouterAnonPost.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "anonymous get not allowed")
+
+	basicAuthRouterAnonPost.RegisterAuthorizer(AuthorizerFunc(func(ctx context.Context, action string, resource string) error {
+		return ErrForbidden
+	}))
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("POST", "/api/writetorepo", nil)
+	basicAuthRouterAnonPost.HandleContext(testContext)
+	suite.Equal(403, testContext.Writer.Status(), "registered authorizers consulted for anonymous post")
+
+	_, err = parseAnonymousMethods([]string{"POST", "GET"})
+	suite.NotNil(err, "only methods of writes may be anonymous")
+
+	// Test basic auth (tenant overrides)
+	anonymousGet := true
+	tenantAuthRouter := NewRouter(RouterOptions{
+		Logger:   log,
+		Depth:    1,
+		Username: "testuser",
+		Password: "testpass",
+		TenantConfig: &tenant.Config{Tenants: map[string]*tenant.Overrides{
+			"dev":  {AnonymousGet: &anonymousGet},
+			"prod": {BasicAuthUser: "produser", BasicAuthPass: "prodpass"},
+			"team": {Credentials: map[string]string{"alice": "alicepass", "bob": "bobpass"}},
+		}},
+	})
+	tenantAuthRouter.SetRoutes(testRoutes)
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/dev/whatsmyrepo", nil)
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(200, testContext.Writer.Status(), "anonymous get allowed for tenant")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/other/whatsmyrepo", nil)
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "anonymous get not allowed for other tenants")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/prod/whatsmyrepo", nil)
+	testContext.Request.SetBasicAuth("testuser", "testpass")
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "server credentials rejected for tenant with own credentials")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/prod/whatsmyrepo", nil)
+	testContext.Request.SetBasicAuth("produser", "prodpass")
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(200, testContext.Writer.Status(), "tenant credentials accepted")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/other/whatsmyrepo", nil)
+	testContext.Request.SetBasicAuth("testuser", "testpass")
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(200, testContext.Writer.Status(), "server credentials accepted for other tenants")
+
+	for _, username := range []string{"alice", "bob"} {
+		testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+		testContext.Request, _ = http.NewRequest("GET", "/team/whatsmyrepo", nil)
+		testContext.Request.SetBasicAuth(username, username+"pass")
+		tenantAuthRouter.HandleContext(testContext)
+		suite.Equal(200, testContext.Writer.Status(), "credentials of "+username+" accepted for tenant")
+	}
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/team/whatsmyrepo", nil)
+	testContext.Request.SetBasicAuth("alice", "bobpass")
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "password of another user rejected for tenant")
+
+	testContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	testContext.Request, _ = http.NewRequest("GET", "/prod/whatsmyrepo", nil)
+	testContext.Request.SetBasicAuth("alice", "alicepass")
+	tenantAuthRouter.HandleContext(testContext)
+	suite.Equal(401, testContext.Writer.Status(), "credentials of a tenant rejected for other tenants")
+
 	// Client Certificate Auth
 	clientAuthRouter := NewRouter(RouterOptions{
 		Logger:    log,
@@ -263,6 +396,1000 @@ func (suite *RouterTestSuite) TestRouterHandleContext() {
 	suite.Equal(401, testContext.Writer.Status())
 }
 
+func (suite *RouterTestSuite) TestTenantHost() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{
+		Logger:            log,
+		Depth:             1,
+		TenantHostPattern: "{tenant}.charts.example.com",
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/whatsmyrepo", func(c *gin.Context) {
+			c.Data(200, "text/html", []byte(c.Param("repo")))
+		}, cm_auth.PullAction},
+		{"GET", "/api/:repo/whatsmyrepo", func(c *gin.Context) {
+			c.Data(200, "text/html", []byte(c.Param("repo")))
+		}, cm_auth.PullAction},
+	})
+
+	tests := []struct {
+		host   string
+		path   string
+		status int
+		repo   string
+	}{
+		{"teama.charts.example.com", "/whatsmyrepo", 200, "teama"},
+		{"TeamA.Charts.Example.com:8080", "/api/whatsmyrepo", 200, "teama"},
+		{"teama.charts.example.com", "/teamb/whatsmyrepo", 404, ""},
+		{"charts.example.com", "/teamb/whatsmyrepo", 200, "teamb"},
+		{"a.b.charts.example.com", "/teamb/whatsmyrepo", 200, "teamb"},
+		{"teama.charts.example.org", "/whatsmyrepo", 404, ""},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		testContext, _ := gin.CreateTestContext(recorder)
+		testContext.Request, _ = http.NewRequest("GET", test.path, nil)
+		testContext.Request.Host = test.host
+		router.HandleContext(testContext)
+		suite.Equal(test.status, recorder.Code, test.host+test.path)
+		if test.status == 200 {
+			suite.Equal(test.repo, recorder.Body.String(), test.host+test.path)
+		}
+	}
+
+	_, err = tenantHostRegexp("charts.example.com")
+	suite.NotNil(err, "error with pattern missing {tenant}")
+}
+
+func (suite *RouterTestSuite) TestHTTPServerTimeouts() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{
+		Logger:            log,
+		Host:              "127.0.0.1",
+		ReadTimeout:       30,
+		WriteTimeout:      60,
+		IdleTimeout:       120,
+		ReadHeaderTimeout: 10,
+		MaxHeaderBytes:    4096,
+	})
+	server := router.newHTTPServer(8080)
+	suite.Equal("127.0.0.1:8080", server.Addr)
+	suite.Equal(30*time.Second, server.ReadTimeout)
+	suite.Equal(60*time.Second, server.WriteTimeout)
+	suite.Equal(120*time.Second, server.IdleTimeout)
+	suite.Equal(10*time.Second, server.ReadHeaderTimeout)
+	suite.Equal(4096, server.MaxHeaderBytes)
+}
+
+func (suite *RouterTestSuite) TestListen() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+	dir, err := ioutil.TempDir("", "chartmuseum-router")
+	suite.Nil(err, "no error creating temp dir")
+	defer os.RemoveAll(dir)
+
+	router := NewRouter(RouterOptions{Logger: log, Host: "127.0.0.1"})
+	listener, err := router.listen(0)
+	suite.Nil(err, "no error listening on tcp")
+	suite.Equal("tcp", listener.Addr().Network())
+	listener.Close()
+
+	socket := filepath.Join(dir, "chartmuseum.sock")
+	router = NewRouter(RouterOptions{Logger: log, UnixSocket: socket})
+	listener, err = router.listen(8080)
+	suite.Nil(err, "no error listening on unix socket")
+	suite.Equal("unix", listener.Addr().Network())
+	info, err := os.Stat(socket)
+	suite.Nil(err, "socket created")
+	suite.Equal(os.FileMode(unixSocketMode), info.Mode().Perm(), "socket reachable by its group")
+
+	// a socket left by a process which did not exit cleanly
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = router.listen(8080)
+	suite.Nil(err, "no error replacing stale socket")
+	listener.Close()
+
+	notSocket := filepath.Join(dir, "file")
+	suite.Nil(ioutil.WriteFile(notSocket, []byte{}, 0644))
+	router = NewRouter(RouterOptions{Logger: log, UnixSocket: notSocket})
+	_, err = router.listen(8080)
+	suite.NotNil(err, "error when the socket path is a file")
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	listener, err = systemdListener()
+	suite.Nil(listener, "sockets passed to another process ignored")
+	suite.Nil(err)
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "0")
+	_, err = systemdListener()
+	suite.NotNil(err, "error when no sockets are passed")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+}
+
+func (suite *RouterTestSuite) TestAdminHandler() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{
+		Logger:        log,
+		ContextPath:   "/charts",
+		EnableMetrics: true,
+		AdminPort:     9090,
+	})
+	ok := func(c *gin.Context) { c.String(200, "ok") }
+	router.SetRoutes([]*Route{
+		{"GET", "/health", ok, ""},
+		{"GET", "/ready", ok, ""},
+		{"GET", "/api/admin/maintenance", ok, ""},
+		{"GET", "/:repo/index.yaml", ok, ""},
+	})
+
+	tests := []struct {
+		path  string
+		admin bool
+	}{
+		{"/metrics", true},
+		{"/charts/health", true},
+		{"/charts/ready", true},
+		{"/charts/api/admin/maintenance", true},
+		{"/charts/index.yaml", false},
+	}
+	for _, test := range tests {
+		for _, admin := range []bool{true, false} {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", test.path, nil)
+			router.adminHandler(admin).ServeHTTP(recorder, request)
+			if admin == test.admin {
+				suite.Equal(200, recorder.Code, fmt.Sprintf("%s served with admin=%t", test.path, admin))
+			} else {
+				suite.Equal(404, recorder.Code, fmt.Sprintf("%s not served with admin=%t", test.path, admin))
+			}
+		}
+	}
+}
+
+func (suite *RouterTestSuite) TestTLSConfig() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	version, err := parseTLSVersion("1.3")
+	suite.Nil(err)
+	suite.Equal(uint16(tls.VersionTLS13), version)
+	version, err = parseTLSVersion("")
+	suite.Nil(err)
+	suite.Equal(uint16(0), version, "crypto/tls default version")
+	_, err = parseTLSVersion("1.4")
+	suite.NotNil(err, "error with unknown tls version")
+
+	suites, err := parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	suite.Nil(err)
+	suite.Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, suites)
+	_, err = parseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	suite.NotNil(err, "error with insecure cipher suite")
+
+	dir, err := ioutil.TempDir("", "chartmuseum-tls")
+	suite.Nil(err, "no error creating temp dir")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	copyPair := func(cert string, key string, modTime time.Time) {
+		for from, to := range map[string]string{cert: certFile, key: keyFile} {
+			content, err := ioutil.ReadFile(from)
+			suite.Nil(err, "no error reading "+from)
+			suite.Nil(ioutil.WriteFile(to, content, 0600))
+			suite.Nil(os.Chtimes(to, modTime, modTime))
+		}
+	}
+	copyPair(testClientAuthCert, testClientAuthKey, time.Now().Add(-time.Hour))
+
+	router := NewRouter(RouterOptions{
+		Logger:        log,
+		TlsCert:       certFile,
+		TlsKey:        keyFile,
+		TLSMinVersion: "1.2",
+	})
+	config, err := router.tlsConfig()
+	suite.Nil(err, "no error building tls config")
+	suite.Equal(uint16(tls.VersionTLS12), config.MinVersion)
+	first, err := config.GetCertificate(nil)
+	suite.Nil(err)
+
+	copyPair(testPublicKey, testPrivateKey, time.Now())
+	rotated, err := config.GetCertificate(nil)
+	suite.Nil(err)
+	suite.False(bytes.Equal(first.Certificate[0], rotated.Certificate[0]), "certificate reloaded once rotated")
+
+	suite.Nil(ioutil.WriteFile(certFile, []byte("partially written"), 0600))
+	suite.Nil(os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	current, err := config.GetCertificate(nil)
+	suite.Nil(err)
+	suite.Equal(rotated, current, "previous certificate served while rotating")
+
+	router.TlsCert = filepath.Join(dir, "missing.crt")
+	_, err = router.tlsConfig()
+	suite.NotNil(err, "error with missing certificate")
+	suite.NotNil(router.ValidateTLS(), "missing certificate invalid")
+
+	router.TlsCert = certFile
+	suite.NotNil(router.ValidateTLS(), "partially written certificate invalid")
+	copyPair(testClientAuthCert, testClientAuthKey, time.Now())
+	suite.Nil(router.ValidateTLS(), "certificate valid")
+	router.TlsCACert = testClientAuthCA
+	suite.Nil(router.ValidateTLS(), "ca cert valid")
+	router.TlsCACert = filepath.Join(dir, "missing.pem")
+	suite.NotNil(router.ValidateTLS(), "missing ca cert invalid")
+	router.TlsKey = ""
+	suite.NotNil(router.ValidateTLS(), "certificate without key invalid")
+	router.TlsCert, router.TlsCACert = "", ""
+	suite.Nil(router.ValidateTLS(), "no tls valid")
+}
+
+func (suite *RouterTestSuite) TestTLSAuto() {
+	dir, err := ioutil.TempDir("", "chartmuseum-autocert")
+	suite.Nil(err, "no error creating temp dir")
+	defer os.RemoveAll(dir)
+	backend := cm_storage.Backend(cm_storage.NewLocalFilesystemBackend(dir))
+
+	for _, options := range []RouterOptions{
+		{TLSAuto: true, TLSAutoStorage: backend},
+		{TLSAuto: true, TLSAutoStorage: backend, TLSAutoHosts: []string{"charts.example.com"}, TlsCert: "tls.crt"},
+		{TLSAuto: true, TLSAutoHosts: []string{"charts.example.com"}},
+	} {
+		_, err = newAutocertManager(options)
+		suite.NotNil(err, "error with invalid tls auto options")
+	}
+
+	manager, err := newAutocertManager(RouterOptions{
+		TLSAuto:             true,
+		TLSAutoHosts:        []string{"charts.example.com"},
+		TLSAutoEmail:        "admin@example.com",
+		TLSAutoDirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+		TLSAutoStorage:      backend,
+	})
+	suite.Nil(err, "no error creating autocert manager")
+	suite.Nil(manager.HostPolicy(context.Background(), "charts.example.com"), "host allowed")
+	suite.NotNil(manager.HostPolicy(context.Background(), "other.example.com"), "other hosts not allowed")
+	suite.Equal("https://acme-staging-v02.api.letsencrypt.org/directory", manager.Client.DirectoryURL)
+
+	_, err = manager.Cache.Get(context.Background(), "charts.example.com")
+	suite.Equal(autocert.ErrCacheMiss, err, "cache miss before obtaining a certificate")
+	suite.Nil(manager.Cache.Put(context.Background(), "charts.example.com", []byte("cert")))
+	content, err := manager.Cache.Get(context.Background(), "charts.example.com")
+	suite.Nil(err)
+	suite.Equal("cert", string(content))
+	_, err = backend.GetObject(".autocert/charts.example.com")
+	suite.Nil(err, "certificate kept in storage")
+	suite.Nil(manager.Cache.Delete(context.Background(), "charts.example.com"))
+
+	router := &Router{TLSAutoManager: manager, TLSMinVersion: tls.VersionTLS12, DisableHTTP2: true}
+	config := router.autocertTLSConfig()
+	suite.Equal(uint16(tls.VersionTLS12), config.MinVersion)
+	suite.Equal([]string{"http/1.1", acme.ALPNProto}, config.NextProtos, "tls-alpn-01 challenges answered without h2")
+}
+
+func (suite *RouterTestSuite) TestTLSHTTPPort() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{Logger: log, TlsCert: "tls.crt", TlsKey: "tls.key", TLSHTTPPort: 8080})
+	suite.Equal(8080, router.HTTPPort)
+	suite.Equal(HTTPModeRedirect, router.HTTPMode, "redirect by default")
+	router.SetRoutes([]*Route{{"GET", "/health", func(c *gin.Context) { c.String(200, "ok") }, ""}})
+
+	serveHTTP := func(handler http.Handler, method string, url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, url, nil)
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	res := serveHTTP(router.httpHandler(8443), "GET", "http://charts.example.com:8080/org1/index.yaml?offset=1")
+	suite.Equal(301, res.Code, "301 GET")
+	suite.Equal("https://charts.example.com:8443/org1/index.yaml?offset=1", res.Header().Get("Location"))
+	res = serveHTTP(router.httpHandler(443), "POST", "http://charts.example.com/api/charts")
+	suite.Equal(308, res.Code, "308 POST, keeping the method")
+	suite.Equal("https://charts.example.com/api/charts", res.Header().Get("Location"), "port 443 left out")
+	res = serveHTTP(router.httpHandler(443), "GET", "http://[::1]:8080/health")
+	suite.Equal("https://[::1]/health", res.Header().Get("Location"))
+
+	router.HTTPMode = HTTPModeHealth
+	suite.Equal(200, serveHTTP(router.httpHandler(8443), "GET", "http://charts.example.com/health").Code, "health served")
+	res = serveHTTP(router.httpHandler(8443), "GET", "http://charts.example.com/index.yaml")
+	suite.Equal(404, res.Code, "only health served")
+	suite.Equal(ProblemContentType, res.Header().Get("Content-Type"))
+
+	suite.Nil(validateHTTPMode(HTTPModeHealth))
+	suite.NotNil(validateHTTPMode("proxy"), "error with invalid mode")
+}
+
+func (suite *RouterTestSuite) TestRequestTimeout() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{Logger: log, RequestTimeout: 30})
+	deadlines := map[string]bool{}
+	handler := func(c *gin.Context) {
+		_, ok := c.Request.Context().Deadline()
+		deadlines[c.Request.URL.Path] = ok
+		c.Status(200)
+	}
+	router.SetRoutes([]*Route{
+		{"GET", "/index.yaml", handler, ""},
+		{"GET", "/api/events", handler, ""},
+	})
+
+	for _, path := range []string{"/index.yaml", "/api/events"} {
+		testContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+		testContext.Request, _ = http.NewRequest("GET", path, nil)
+		router.HandleContext(testContext)
+	}
+	suite.True(deadlines["/index.yaml"], "deadline set on requests")
+	suite.False(deadlines["/api/events"], "no deadline on event streams")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	recorder := httptest.NewRecorder()
+	testContext, _ := gin.CreateTestContext(recorder)
+	testContext.Request, _ = http.NewRequestWithContext(ctx, "GET", "/index.yaml", nil)
+	delete(deadlines, "/index.yaml")
+	router.HandleContext(testContext)
+	suite.Equal(504, recorder.Code, "504 past the deadline")
+	_, handled := deadlines["/index.yaml"]
+	suite.False(handled, "handler skipped past the deadline")
+}
+
+func (suite *RouterTestSuite) TestCompression() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	index := bytes.Repeat([]byte("apiVersion: v1\n"), 100)
+	router := NewRouter(RouterOptions{
+		Logger:             log,
+		EnableCompression:  true,
+		CompressionMinSize: 64,
+		CompressionTypes:   []string{"application/x-yaml", "application/json"},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/index.yaml", func(c *gin.Context) {
+			c.Data(200, "application/x-yaml", index)
+		}, ""},
+		{"GET", "/charts/mychart-0.1.0.tgz", func(c *gin.Context) {
+			c.Data(200, "application/x-tar", index)
+		}, ""},
+		{"GET", "/api/charts", func(c *gin.Context) {
+			c.JSON(200, gin.H{})
+		}, ""},
+	})
+	doRequest := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		testContext, _ := gin.CreateTestContext(recorder)
+		testContext.Request, _ = http.NewRequest("GET", path, nil)
+		testContext.Request.Header.Set("Accept-Encoding", acceptEncoding)
+		router.HandleContext(testContext)
+		return recorder
+	}
+
+	recorder := doRequest("/index.yaml", "br, gzip")
+	suite.Equal("gzip", recorder.Header().Get("Content-Encoding"), "index compressed")
+	suite.Equal("Accept-Encoding", recorder.Header().Get("Vary"))
+	reader, err := gzip.NewReader(recorder.Body)
+	suite.Nil(err, "no error reading compressed index")
+	content, err := ioutil.ReadAll(reader)
+	suite.Nil(err, "no error decompressing index")
+	suite.Equal(index, content, "index decompressed")
+
+	recorder = doRequest("/index.yaml", "gzip;q=0")
+	suite.Equal("", recorder.Header().Get("Content-Encoding"), "gzip refused by client")
+	suite.Equal(index, recorder.Body.Bytes())
+	recorder = doRequest("/index.yaml", "")
+	suite.Equal("", recorder.Header().Get("Content-Encoding"), "gzip not accepted by client")
+
+	recorder = doRequest("/charts/mychart-0.1.0.tgz", "gzip")
+	suite.Equal("", recorder.Header().Get("Content-Encoding"), "chart packages not compressed")
+	suite.Equal(index, recorder.Body.Bytes())
+
+	recorder = doRequest("/api/charts", "gzip")
+	suite.Equal("", recorder.Header().Get("Content-Encoding"), "small responses not compressed")
+	suite.Equal("Accept-Encoding", recorder.Header().Get("Vary"))
+	suite.Equal("{}", recorder.Body.String())
+}
+
+func (suite *RouterTestSuite) TestTrustedProxies() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	_, err = parseTrustedProxies([]string{"10.0.0.0/33"})
+	suite.NotNil(err, "error with invalid cidr")
+	_, err = parseTrustedProxies([]string{"proxy.local"})
+	suite.NotNil(err, "error with hostname")
+
+	router := NewRouter(RouterOptions{Logger: log, TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}})
+	router.SetRoutes([]*Route{
+		{"GET", "/whoami", func(c *gin.Context) {
+			c.String(200, "%s %t", c.ClientIP(), router.TrustsForwardedHeaders(c.Request))
+		}, ""},
+	})
+
+	tests := []struct {
+		remoteAddr string
+		response   string
+	}{
+		{"10.1.2.3:40000", "203.0.113.7 true"},
+		{"192.0.2.1:40000", "203.0.113.7 true"},
+		{"192.0.2.2:40000", "192.0.2.2 false"},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/whoami", nil)
+		request.RemoteAddr = test.remoteAddr
+		request.Header.Set("X-Forwarded-For", "203.0.113.7")
+		router.ServeHTTP(recorder, request)
+		suite.Equal(test.response, recorder.Body.String(), test.remoteAddr)
+	}
+
+	suite.True(isTrustedProxy(nil, "192.0.2.2:40000"), "every peer trusted without trusted proxies")
+}
+
+func (suite *RouterTestSuite) TestProxyProtocol() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{Logger: log, Host: "127.0.0.1", ProxyProtocol: true})
+	listener, err := router.listen(0)
+	suite.Nil(err, "no error listening")
+	defer listener.Close()
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	doRequest := func(header string) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		suite.Nil(err, "no error connecting")
+		defer conn.Close()
+		fmt.Fprintf(conn, "%sGET / HTTP/1.0\r\n\r\n", header)
+		response, _ := ioutil.ReadAll(conn)
+		return string(response)
+	}
+	suite.Contains(doRequest("PROXY TCP4 203.0.113.7 127.0.0.1 56324 80\r\n"), "203.0.113.7:56324", "client address from v1 header")
+	suite.Contains(doRequest("PROXY UNKNOWN\r\n"), "127.0.0.1:", "proxy address with unknown v1 header")
+	suite.NotContains(doRequest(""), "200 OK", "connection refused without header")
+
+	v2 := append([]byte{}, proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12, 203, 0, 113, 7, 127, 0, 0, 1, 0xdc, 0x04, 0, 80)
+	addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(v2)))
+	suite.Nil(err, "no error reading v2 header")
+	suite.Equal("203.0.113.7:56324", addr.String(), "client address from v2 header")
+	local := append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0, 0)
+	addr, err = readProxyHeader(bufio.NewReader(bytes.NewReader(local)))
+	suite.Nil(err, "no error reading v2 local header")
+	suite.Nil(addr, "no client address for local connections")
+}
+
+func (suite *RouterTestSuite) TestGracefulShutdown() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{
+		Logger:          log,
+		ShutdownTimeout: 5,
+	})
+	started := make(chan struct{})
+	router.SetRoutes([]*Route{
+		{"POST", "/slow", func(c *gin.Context) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			c.String(201, "saved")
+		}, ""},
+	})
+	hookCalled := false
+	router.RegisterOnShutdown(func(ctx context.Context) {
+		_, hasDeadline := ctx.Deadline()
+		hookCalled = hasDeadline
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Nil(err, "no error listening")
+	server := &http.Server{Handler: router}
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	go func() {
+		router.serve(server, func() error { return server.Serve(listener) }, signals)
+		close(stopped)
+	}()
+
+	status := make(chan int)
+	go func() {
+		res, err := http.Post("http://"+listener.Addr().String()+"/slow", "text/plain", nil)
+		if err != nil {
+			status <- 0
+			return
+		}
+		res.Body.Close()
+		status <- res.StatusCode
+	}()
+	<-started
+	signals <- syscall.SIGTERM
+	suite.Equal(201, <-status, "in-flight request finished on shutdown")
+	<-stopped
+	suite.True(hookCalled, "shutdown hook called with the shutdown deadline")
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/slow")
+	suite.NotNil(err, "no connections accepted after shutdown")
+}
+
+func (suite *RouterTestSuite) TestRequestID() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{Logger: log})
+	var contextID string
+	router.SetRoutes([]*Route{
+		{"GET", "/index.yaml", func(c *gin.Context) {
+			contextID = RequestIDFromContext(c.Request.Context())
+			c.JSON(500, ErrorBody(c, "storage unavailable"))
+		}, ""},
+	})
+	doRequest := func(reqID string) (string, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		testContext, _ := gin.CreateTestContext(recorder)
+		testContext.Request, _ = http.NewRequest("GET", "/index.yaml", nil)
+		testContext.Request.Header.Set("X-Request-Id", reqID)
+		router.HandleContext(testContext)
+		body := map[string]interface{}{}
+		suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &body), "no error decoding error body")
+		return recorder.Header().Get("X-Request-Id"), body
+	}
+
+	reqID, body := doRequest("proxy-1234")
+	suite.Equal("proxy-1234", reqID, "request id of the client echoed")
+	suite.Equal("proxy-1234", body["requestId"], "request id in error body")
+	suite.Equal("storage unavailable", body["error"])
+	suite.Equal("proxy-1234", contextID, "request id in request context")
+
+	for _, invalid := range []string{"", "two words", "line\nbreak", strings.Repeat("a", 129)} {
+		reqID, body = doRequest(invalid)
+		suite.NotEqual(invalid, reqID, "request id %q replaced", invalid)
+		suite.Len(reqID, 36, "uuid generated")
+		suite.Equal(reqID, body["requestId"])
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	suite.Equal(gin.H{"error": "not found"}, ErrorBody(c, "not found"), "no request id outside requests")
+}
+
+func (suite *RouterTestSuite) TestAccessLog() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	tempDir, err := ioutil.TempDir("", "chartmuseum-access-log")
+	suite.Nil(err)
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "access.log")
+
+	router := NewRouter(RouterOptions{
+		Logger:          log,
+		Depth:           1,
+		EnableAccessLog: true,
+		AccessLogOutput: path,
+		AccessLogFields: []string{"tenant", "user", "chart", "version", "status", "latency"},
+	})
+	router.SetRoutes([]*Route{
+		{"GET", "/:repo/charts/:filename", func(c *gin.Context) {
+			c.Data(200, "application/x-tar", []byte("chart"))
+		}, ""},
+	})
+	doRequest := func(path string) {
+		testContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+		testContext.Request, _ = http.NewRequest("GET", path, nil)
+		testContext.Request.SetBasicAuth("user1", "secret")
+		router.HandleContext(testContext)
+	}
+	doRequest("/org1/charts/mychart-0.1.0.tgz")
+	doRequest("/health")
+	suite.Nil(router.AccessLogger.Close())
+
+	content, err := ioutil.ReadFile(path)
+	suite.Nil(err, "no error reading access log")
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	suite.Len(lines, 1, "health checks not logged")
+	var entry map[string]interface{}
+	suite.Nil(json.Unmarshal(lines[0], &entry), "access log as json")
+	suite.Equal("org1", entry["tenant"])
+	suite.Equal("user1", entry["user"])
+	suite.Equal("mychart", entry["chart"])
+	suite.Equal("0.1.0", entry["version"])
+	suite.Equal(float64(200), entry["status"])
+	suite.Contains(entry, "latency")
+	suite.Len(entry, 6, "only the fields selected")
+
+	accessLogger, err := NewAccessLogger(AccessLoggerOptions{Format: AccessLogFormatCombined})
+	suite.Nil(err)
+	buffer := &bytes.Buffer{}
+	accessLogger.writer = buffer
+	recorder := httptest.NewRecorder()
+	testContext, _ := gin.CreateTestContext(recorder)
+	testContext.Request, _ = http.NewRequest("GET", "/index.yaml?x=1", nil)
+	testContext.Request.RemoteAddr = "10.0.0.1:1234"
+	testContext.Request.Header.Set("User-Agent", "Helm/3.8.0")
+	testContext.String(404, "not found")
+	accessLogger.Log(testContext, time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC))
+	suite.Equal("10.0.0.1 - - [02/Jan/2022:15:04:05 +0000] \"GET /index.yaml?x=1 HTTP/1.1\" 404 9 \"-\" \"Helm/3.8.0\"\n", buffer.String())
+
+	_, err = NewAccessLogger(AccessLoggerOptions{Format: "xml"})
+	suite.NotNil(err, "error with unknown format")
+	_, err = NewAccessLogger(AccessLoggerOptions{Fields: []string{"password"}})
+	suite.NotNil(err, "error with unknown field")
+}
+
+func (suite *RouterTestSuite) TestMetricsAuth() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	getMetrics := func(router *Router, setAuth func(*http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/metrics", nil)
+		if setAuth != nil {
+			setAuth(request)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	basicAuth := func(username string, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	router := NewRouter(RouterOptions{
+		Logger:          log,
+		EnableMetrics:   true,
+		Username:        "testuser",
+		Password:        "testpass",
+		MetricsUsername: "scraper",
+		MetricsPassword: "scraperpass",
+	})
+	res := getMetrics(router, nil)
+	suite.Equal(401, res.Code, "401 GET /metrics without credentials")
+	suite.Equal(`Basic realm="metrics"`, res.Header().Get("WWW-Authenticate"))
+	suite.Equal(401, getMetrics(router, basicAuth("testuser", "testpass")).Code, "401 GET /metrics with credentials of the repos")
+	suite.Equal(401, getMetrics(router, basicAuth("scraper", "badpass")).Code, "401 GET /metrics with a bad password")
+	res = getMetrics(router, basicAuth("scraper", "scraperpass"))
+	suite.Equal(200, res.Code, "200 GET /metrics with credentials of the metrics")
+	suite.Contains(res.Body.String(), "chartmuseum_", "metrics served")
+
+	router = NewRouter(RouterOptions{
+		Logger:             log,
+		EnableMetrics:      true,
+		MetricsBearerToken: "scrapertoken",
+	})
+	res = getMetrics(router, nil)
+	suite.Equal(401, res.Code, "401 GET /metrics without token")
+	suite.Equal(`Bearer realm="metrics"`, res.Header().Get("WWW-Authenticate"))
+	suite.Equal(401, getMetrics(router, bearer("badtoken")).Code, "401 GET /metrics with a bad token")
+	suite.Equal(200, getMetrics(router, bearer("scrapertoken")).Code, "200 GET /metrics with the token")
+
+	router = NewRouter(RouterOptions{
+		Logger:        log,
+		EnableMetrics: true,
+		Username:      "testuser",
+		Password:      "testpass",
+	})
+	suite.Equal(200, getMetrics(router, nil).Code, "metrics not protected by default")
+}
+
+func (suite *RouterTestSuite) TestTenantMetricsLabels() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+	tenant.SetMaxMetricsLabels(1)
+	defer tenant.SetMaxMetricsLabels(0)
+
+	router := NewRouter(RouterOptions{
+		Logger:        log,
+		Depth:         1,
+		EnableMetrics: true,
+		Username:      "testuser",
+		Password:      "testpass",
+	})
+	router.SetRoutes([]*Route{{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, "ok") }, cm_auth.PullAction}})
+	doRequest := func(repo string, withAuth bool) int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/"+repo+"/index.yaml", nil)
+		if withAuth {
+			request.SetBasicAuth("testuser", "testpass")
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for _, repo := range []string{"madeup1", "madeup2"} {
+		suite.Equal(401, doRequest(repo, false), "401 GET made up repo without credentials")
+	}
+	suite.Equal(tenant.OtherMetricsLabel, tenant.AssignedMetricsLabel("madeup1"), "no label for unauthorized requests")
+	suite.Equal(200, doRequest("org1", true), "200 GET repo with credentials")
+	suite.Equal("org1", tenant.AssignedMetricsLabel("org1"), "label of authorized repo")
+	suite.Equal(401, doRequest("org1", false), "401 GET known repo without credentials")
+	suite.Equal("org1", tenant.AssignedMetricsLabel("org1"), "unauthorized requests of known repos keep their label")
+}
+
+func (suite *RouterTestSuite) TestKubeAuth() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := map[string]map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&review)
+		if review["spec"]["token"] == "builder-token" {
+			review["status"] = map[string]interface{}{
+				"authenticated": true,
+				"user":          map[string]string{"username": "system:serviceaccount:ci:builder"},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer apiServer.Close()
+	config, err := kubeauth.ConfigFromContent([]byte(`accounts: [{serviceAccount: ci/builder, repos: [org1], actions: [push]}]`))
+	suite.Nil(err)
+	authenticator, err := kubeauth.NewAuthenticatorForConfig(&rest.Config{Host: apiServer.URL}, config)
+	suite.Nil(err)
+
+	router := NewRouter(RouterOptions{Logger: log, KubeAuth: authenticator, AnonymousGet: true})
+	permissions, err := router.Authorize("Bearer builder-token", cm_auth.PushAction, "org1")
+	suite.Nil(err)
+	suite.True(permissions.Allowed, "service account granted push")
+	permissions, err = router.Authorize("Bearer builder-token", cm_auth.PushAction, "org2")
+	suite.Nil(err)
+	suite.False(permissions.Allowed, "service account not granted the repo, without other auth")
+	suite.Equal(`Bearer realm="ChartMuseum"`, permissions.WWWAuthenticateHeader)
+	permissions, err = router.Authorize("", cm_auth.PullAction, "org2")
+	suite.Nil(err)
+	suite.True(permissions.Allowed, "anonymous get")
+
+	router = NewRouter(RouterOptions{Logger: log, KubeAuth: authenticator, Username: "user", Password: "pass"})
+	permissions, err = router.Authorize("Bearer builder-token", cm_auth.PushAction, "org1")
+	suite.Nil(err)
+	suite.True(permissions.Allowed, "service account granted push besides basic auth")
+	permissions, err = router.Authorize("Basic dXNlcjpwYXNz", cm_auth.PushAction, "org2")
+	suite.Nil(err)
+	suite.True(permissions.Allowed, "basic auth still accepted")
+	permissions, err = router.Authorize("Bearer other-token", cm_auth.PullAction, "org1")
+	suite.Nil(err)
+	suite.False(permissions.Allowed, "invalid token")
+
+	apiServer.Close()
+	permissions, err = router.Authorize("Basic dXNlcjpwYXNz", cm_auth.PushAction, "org1")
+	suite.Nil(err, "no error while the api server is unreachable")
+	suite.True(permissions.Allowed, "basic auth accepted while the api server is unreachable")
+}
+
+func (suite *RouterTestSuite) TestAuthorizers() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	doRequest := func(router *Router, method string, path string, username string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, path, nil)
+		if username != "" {
+			request.SetBasicAuth(username, username+"pass")
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	routes := []*Route{
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) { c.String(200, "index") }, cm_auth.PullAction},
+		{"DELETE", "/api/:repo/charts/:name/:version", func(c *gin.Context) { c.String(200, "deleted") }, cm_auth.PushAction},
+	}
+
+	rbac := &RBACAuthorizer{
+		Roles: map[string][]RBACRule{
+			"reader":     {{Repo: "", Actions: []string{cm_auth.PullAction}}},
+			"org1-admin": {{Repo: "org1", Actions: []string{"*"}}},
+		},
+		Users: map[string][]string{
+			"alice": {"reader", "org1-admin"},
+			"bob":   {"reader"},
+		},
+	}
+	router := NewRouter(RouterOptions{
+		Logger:      log,
+		Depth:       1,
+		Authorizers: []Authorizer{NewBasicAuthorizer(map[string]string{"alice": "alicepass", "bob": "bobpass"}, false)},
+	})
+	router.RegisterAuthorizer(rbac)
+	router.SetRoutes(routes)
+
+	res := doRequest(router, "GET", "/org1/index.yaml", "")
+	suite.Equal(401, res.Code, "401 without credentials")
+	suite.Equal(`Basic realm="ChartMuseum"`, res.Header().Get("WWW-Authenticate"))
+	suite.Equal(200, doRequest(router, "GET", "/org2/index.yaml", "bob").Code, "reader may pull any repo")
+	suite.Equal(403, doRequest(router, "DELETE", "/api/org1/charts/mychart/0.1.0", "bob").Code, "reader may not push")
+	suite.Equal(200, doRequest(router, "DELETE", "/api/org1/charts/mychart/0.1.0", "alice").Code, "admin of org1 may push to it")
+	suite.Equal(403, doRequest(router, "DELETE", "/api/org2/charts/mychart/0.1.0", "alice").Code, "admin of org1 may not push to org2")
+
+	// registered authorizers only restrict the requests the credentials of the server allow
+	router = NewRouter(RouterOptions{Logger: log, Depth: 1, Username: "alice", Password: "alicepass"})
+	router.RegisterAuthorizer(rbac)
+	router.SetRoutes(routes)
+	suite.Equal(401, doRequest(router, "GET", "/org1/index.yaml", "bob").Code, "credentials of the server checked first")
+	suite.Equal(200, doRequest(router, "DELETE", "/api/org1/charts/mychart/0.1.0", "alice").Code)
+
+	router = NewRouter(RouterOptions{Logger: log, Depth: 1})
+	router.RegisterAuthorizer(AuthorizerFunc(func(ctx context.Context, action string, resource string) error {
+		request := RequestFromContext(ctx)
+		suite.NotNil(request, "request being authorized in context")
+		switch request.Header.Get("X-Test") {
+		case "error":
+			return fmt.Errorf("auth backend unavailable")
+		case "challenge":
+			return &AuthError{Status: 401, Message: "token required", WWWAuthenticate: `Bearer realm="custom"`}
+		}
+		return nil
+	}))
+	router.SetRoutes(routes)
+	for header, status := range map[string]int{"": 200, "error": 500, "challenge": 401} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/org1/index.yaml", nil)
+		request.Header.Set("X-Test", header)
+		router.ServeHTTP(recorder, request)
+		suite.Equal(status, recorder.Code, "status with X-Test %q", header)
+		if header == "challenge" {
+			suite.Equal(`Bearer realm="custom"`, recorder.Header().Get("WWW-Authenticate"))
+			suite.Contains(recorder.Body.String(), "token required")
+		}
+	}
+
+	_, err = NewTokenAuthorizer(TokenAuthorizerOptions{Realm: "https://auth.example.com/token", Service: "example.com", AuthCertPath: "nonexistent.pem"})
+	suite.NotNil(err, "error without the public key of the auth server")
+}
+
+func (suite *RouterTestSuite) TestResponseHeaders() {
+	headers, err := parseResponseHeaders("x-frame-options: DENY, api=Cache-Control: no-cache, no-store,oci=X-Registry: chartmuseum")
+	suite.Nil(err, "no error parsing response headers")
+	suite.Equal(map[string][]responseHeader{
+		"":    {{"X-Frame-Options", "DENY"}},
+		"api": {{"Cache-Control", "no-cache, no-store"}},
+		"oci": {{"X-Registry", "chartmuseum"}},
+	}, headers, "headers parsed by route group, values continued after commas")
+	for _, invalid := range []string{"nosniff", "X-Frame-Options:", "ui=X-Frame-Options: DENY", "api=repo=X-Frame-Options: DENY"} {
+		_, err = parseResponseHeaders(invalid)
+		suite.NotNil(err, "error parsing response headers %q", invalid)
+	}
+
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{
+		Logger:          log,
+		Depth:           1,
+		ResponseHeaders: "Strict-Transport-Security: max-age=31536000; includeSubDomains,api=Cache-Control: no-store,repo=X-Repo: true",
+	})
+	handler := func(c *gin.Context) { c.Status(200) }
+	router.SetRoutes([]*Route{
+		{"GET", "/health", handler, ""},
+		{"GET", "/:repo/index.yaml", func(c *gin.Context) {
+			c.Header("Cache-Control", "max-age=60")
+			c.Status(200)
+		}, ""},
+		{"GET", "/api/:repo/charts", handler, ""},
+	})
+	doRequest := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		testContext, _ := gin.CreateTestContext(recorder)
+		testContext.Request, _ = http.NewRequest("GET", path, nil)
+		router.HandleContext(testContext)
+		return recorder
+	}
+
+	for _, path := range []string{"/health", "/repo1/index.yaml", "/api/repo1/charts", "/missing/route/here"} {
+		suite.Equal("max-age=31536000; includeSubDomains", doRequest(path).Header().Get("Strict-Transport-Security"), "header of all routes set on %s", path)
+	}
+	recorder := doRequest("/repo1/index.yaml")
+	suite.Equal("true", recorder.Header().Get("X-Repo"), "header of repo routes set")
+	suite.Equal("max-age=60", recorder.Header().Get("Cache-Control"), "header set by the handler kept")
+	recorder = doRequest("/api/repo1/charts")
+	suite.Equal("no-store", recorder.Header().Get("Cache-Control"), "header of api routes set")
+	suite.Empty(recorder.Header().Get("X-Repo"), "header of repo routes not set on api routes")
+	suite.Empty(doRequest("/health").Header().Get("Cache-Control"), "header of api routes not set on other routes")
+}
+
+func (suite *RouterTestSuite) TestUploadProgress() {
+	var progress []int64
+	counter := &bodyCounter{
+		ReadCloser:    ioutil.NopCloser(bytes.NewReader(make([]byte, 100))),
+		start:         time.Now(),
+		progressBytes: 30,
+		nextProgress:  30,
+		progress:      func(counter *bodyCounter) { progress = append(progress, counter.bytes) },
+	}
+	buffer := make([]byte, 20)
+	for {
+		if _, err := counter.Read(buffer); err != nil {
+			break
+		}
+	}
+	suite.Equal(int64(100), counter.bytes, "bytes of the body counted")
+	suite.True(counter.complete, "body read to the end")
+	suite.Equal([]int64{40, 80}, progress, "progress every 30 bytes at least")
+	suite.True(counter.duration() > 0, "time taken to receive the body")
+
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+	router := NewRouter(RouterOptions{Logger: log, MaxUploadSize: 1024, LogUploadProgress: 50})
+	var received int64
+	router.SetRoutes([]*Route{
+		{"POST", "/api/charts", func(c *gin.Context) {
+			content, _ := c.GetRawData()
+			received = c.Request.Body.(*bodyCounter).bytes
+			c.String(201, strconv.Itoa(len(content)))
+		}, ""},
+	})
+	recorder := httptest.NewRecorder()
+	testContext, _ := gin.CreateTestContext(recorder)
+	testContext.Request, _ = http.NewRequest("POST", "/api/charts", bytes.NewReader(make([]byte, 200)))
+	router.HandleContext(testContext)
+	suite.Equal(201, recorder.Code)
+	suite.Equal("200", recorder.Body.String(), "body read by the handler")
+	suite.Equal(int64(200), received, "body of the request counted")
+}
+
+func (suite *RouterTestSuite) TestWriteError() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err)
+
+	doRequest := func(router *Router, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		router.SetRoutes([]*Route{
+			{"GET", "/index.yaml", func(c *gin.Context) {
+				WriteError(c, 500, ErrorCodeStorageUnavailable, "storage unavailable")
+			}, ""},
+			{"GET", "/health", func(c *gin.Context) {
+				WriteError(c, 504, "", "request timed out")
+			}, ""},
+		})
+		recorder := httptest.NewRecorder()
+		testContext, _ := gin.CreateTestContext(recorder)
+		testContext.Request, _ = http.NewRequest("GET", path, nil)
+		testContext.Request.Header.Set("X-Request-Id", "req-1")
+		router.HandleContext(testContext)
+		body := map[string]interface{}{}
+		suite.Nil(json.Unmarshal(recorder.Body.Bytes(), &body), "no error decoding error body")
+		return recorder, body
+	}
+
+	router := NewRouter(RouterOptions{Logger: log})
+	res, body := doRequest(router, "/index.yaml")
+	suite.Equal(500, res.Code)
+	suite.Equal(ProblemContentType, res.Header().Get("Content-Type"))
+	suite.Equal(map[string]interface{}{
+		"type":      "urn:chartmuseum:error:storage_unavailable",
+		"title":     "Internal Server Error",
+		"status":    float64(500),
+		"detail":    "storage unavailable",
+		"instance":  "/index.yaml",
+		"code":      "storage_unavailable",
+		"requestId": "req-1",
+	}, body, "problem details")
+	_, body = doRequest(router, "/health")
+	suite.Equal(ErrorCodeTimeout, body["code"], "code of the status without a code")
+	res, body = doRequest(router, "/unknown")
+	suite.Equal(404, res.Code)
+	suite.Equal(ErrorCodeNotFound, body["code"], "code of unknown routes")
+
+	router = NewRouter(RouterOptions{Logger: log, LegacyErrorBodies: true})
+	res, body = doRequest(router, "/index.yaml")
+	suite.Equal(500, res.Code)
+	suite.Equal("application/json; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Equal(map[string]interface{}{"error": "storage unavailable", "requestId": "req-1"}, body, "legacy error body")
+}
+
 func (suite *RouterTestSuite) TestMapURLWithParamsBackToRouteTemplate() {
 	tests := []struct {
 		ctx    *gin.Context
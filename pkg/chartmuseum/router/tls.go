@@ -0,0 +1,169 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type (
+	// certReloader serves the certificate of a cert and key pair,
+	// loading it again once the files change on disk, e.g. when rotated by cert-manager
+	certReloader struct {
+		logger   *cm_logger.Logger
+		certFile string
+		keyFile  string
+		mutex    sync.Mutex
+		cert     *tls.Certificate
+		modTimes [2]time.Time
+	}
+)
+
+// parseTLSVersion reads a tls version such as "1.2", "" leaving the default of crypto/tls
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid tls version %q, must be one of 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+// parseCipherSuites reads cipher suites by name, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+// Only the suites considered secure by crypto/tls are accepted, and TLS 1.3 suites are not configurable
+func parseCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		found := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported cipher suite %s", name)
+		}
+	}
+	return ids, nil
+}
+
+func newCertReloader(logger *cm_logger.Logger, certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{logger: logger, certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) reload() error {
+	var modTimes [2]time.Time
+	for i, path := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if reloader.cert != nil && modTimes == reloader.modTimes {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	if reloader.cert != nil {
+		reloader.logger.Infow("Reloaded tls certificate", "cert", reloader.certFile)
+	}
+	reloader.cert = &cert
+	reloader.modTimes = modTimes
+	return nil
+}
+
+// GetCertificate is called by crypto/tls for each handshake. While the files are
+// being rotated, the previous certificate is served
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	if err := reloader.reload(); err != nil {
+		reloader.logger.Warnw("Error reloading tls certificate, serving the previous one",
+			"cert", reloader.certFile,
+			"error", err.Error(),
+		)
+	}
+	return reloader.cert, nil
+}
+
+// tlsConfig builds the tls config of the server, requiring client certificates if a ca cert is set
+func (router *Router) tlsConfig() (*tls.Config, error) {
+	reloader, err := newCertReloader(router.Logger, router.TlsCert, router.TlsKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     router.TLSMinVersion,
+		CipherSuites:   router.TLSCipherSuites,
+	}
+	if router.TlsCACert != "" {
+		certpool := x509.NewCertPool()
+		capem, err := ioutil.ReadFile(router.TlsCACert)
+		if err != nil {
+			return nil, err
+		}
+		if !certpool.AppendCertsFromPEM(capem) {
+			return nil, errors.New("can't parse CA certificate file")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = certpool
+	}
+	return config, nil
+}
+
+// ValidateTLS loads the certificate, key and ca cert of the router as Start does, returning
+// the first error found, e.g. for --validate-config
+func (router *Router) ValidateTLS() error {
+	if (router.TlsCert == "") != (router.TlsKey == "") {
+		return errors.New("tls cert and key must be set together")
+	}
+	if router.TlsCert == "" {
+		if router.TlsCACert != "" {
+			return errors.New("tls ca cert requires a tls cert and key")
+		}
+		return nil
+	}
+	_, err := router.tlsConfig()
+	return err
+}
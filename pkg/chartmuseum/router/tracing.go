@@ -0,0 +1,46 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceRequest names the span started by otelgin for a request after the route it matches, as
+// requests are routed by rootHandler rather than gin, and adds the repo and chart of the request
+func (router *Router) traceRequest(c *gin.Context, route *Route) {
+	span := trace.SpanFromContext(c.Request.Context())
+	span.SetName(route.Method + " " + route.Path)
+	span.SetAttributes(semconv.HTTPRouteKey.String(route.Path))
+	for _, param := range c.Params {
+		switch param.Key {
+		case "repo":
+			span.SetAttributes(attribute.String("chartmuseum.repo", param.Value))
+		case "name":
+			span.SetAttributes(attribute.String("chartmuseum.chart.name", param.Value))
+		case "version":
+			span.SetAttributes(attribute.String("chartmuseum.chart.version", param.Value))
+		}
+	}
+	if spanContext := span.SpanContext(); spanContext.IsValid() {
+		// logged with each message of the request, see cm_logger
+		c.Set("traceid", spanContext.TraceID().String())
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Size of the bodies of requests, such as chart uploads
+	requestBodyHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "chartmuseum",
+			Name:      "request_body_bytes",
+			Help:      "Size of the request bodies received, by method and route",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+		[]string{"method", "route"},
+	)
+	// Time taken to receive the bodies of requests
+	requestBodyDurationHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "chartmuseum",
+			Name:      "request_body_duration_seconds",
+			Help:      "Time taken to receive the request bodies, by method and route",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "route"},
+	)
+)
+
+type (
+	// bodyCounter counts the bytes of a request body read by its handler, logging the progress
+	// every progressBytes when set
+	bodyCounter struct {
+		io.ReadCloser
+		start         time.Time
+		end           time.Time
+		bytes         int64
+		complete      bool
+		progressBytes int64
+		nextProgress  int64
+		progress      func(counter *bodyCounter)
+	}
+)
+
+func init() {
+	prometheus.MustRegister(requestBodyHistogramVec, requestBodyDurationHistogramVec)
+}
+
+func (counter *bodyCounter) Read(p []byte) (int, error) {
+	n, err := counter.ReadCloser.Read(p)
+	counter.bytes += int64(n)
+	counter.end = time.Now()
+	if err == io.EOF {
+		counter.complete = true
+	}
+	if counter.progressBytes > 0 && counter.bytes >= counter.nextProgress && !counter.complete {
+		counter.nextProgress = counter.bytes + counter.progressBytes
+		counter.progress(counter)
+	}
+	return n, err
+}
+
+// duration is the time taken to receive the bytes read so far
+func (counter *bodyCounter) duration() time.Duration {
+	if counter.end.IsZero() {
+		return 0
+	}
+	return counter.end.Sub(counter.start)
+}
+
+// bytesPerSecond is the throughput of the bytes read so far
+func (counter *bodyCounter) bytesPerSecond() int64 {
+	if seconds := counter.duration().Seconds(); seconds > 0 {
+		return int64(float64(counter.bytes) / seconds)
+	}
+	return counter.bytes
+}
+
+// countRequestBody counts the bytes of the body of a request as its handler reads it, for the
+// metrics of the route and, with UploadProgressBytes, to log the progress of large uploads.
+// The returned func records them once the request is served
+func (router *Router) countRequestBody(c *gin.Context, route *Route) func() {
+	counter := &bodyCounter{
+		ReadCloser:    c.Request.Body,
+		start:         time.Now(),
+		progressBytes: router.UploadProgressBytes,
+		nextProgress:  router.UploadProgressBytes,
+		progress: func(counter *bodyCounter) {
+			router.Logger.Infoc(c, "Upload progress",
+				"route", route.Path,
+				"bytes", counter.bytes,
+				"expected_bytes", c.Request.ContentLength,
+				"duration", counter.duration().String(),
+				"bytes_per_second", counter.bytesPerSecond(),
+			)
+		},
+	}
+	c.Request.Body = counter
+
+	return func() {
+		if router.EnableMetrics {
+			requestBodyHistogramVec.WithLabelValues(c.Request.Method, route.Path).Observe(float64(counter.bytes))
+			requestBodyDurationHistogramVec.WithLabelValues(c.Request.Method, route.Path).Observe(counter.duration().Seconds())
+		}
+		if router.UploadProgressBytes > 0 && counter.bytes >= router.UploadProgressBytes {
+			router.Logger.Infoc(c, "Upload received",
+				"route", route.Path,
+				"bytes", counter.bytes,
+				"complete", counter.complete,
+				"duration", counter.duration().String(),
+				"bytes_per_second", counter.bytesPerSecond(),
+			)
+		}
+	}
+}
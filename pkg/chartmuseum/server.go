@@ -17,6 +17,9 @@ limitations under the License.
 package chartmuseum
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -25,6 +28,16 @@ import (
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	mt "helm.sh/chartmuseum/pkg/chartmuseum/server/multitenant"
+	"helm.sh/chartmuseum/pkg/ingestion"
+	"helm.sh/chartmuseum/pkg/kubeauth"
+	"helm.sh/chartmuseum/pkg/lock"
+	"helm.sh/chartmuseum/pkg/replication"
+	"helm.sh/chartmuseum/pkg/statsd"
+	"helm.sh/chartmuseum/pkg/tenant"
+	"helm.sh/chartmuseum/pkg/tracing"
+	"helm.sh/chartmuseum/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
 )
 
 type (
@@ -35,9 +48,19 @@ type (
 		TimestampTolerance     time.Duration
 		Logger                 *cm_logger.Logger
 		ChartURL               string
+		ChartURLTemplate       string
 		TlsCert                string
 		TlsKey                 string
 		TlsCACert              string
+		TLSMinVersion          string
+		TLSCipherSuites        []string
+		DisableHTTP2           bool
+		TLSAuto                bool
+		TLSAutoHosts           []string
+		TLSAutoEmail           string
+		TLSAutoDirectoryURL    string
+		TLSHTTPPort            int
+		TLSHTTPMode            string
 		Username               string
 		Password               string
 		ChartPostFormFieldName string
@@ -45,16 +68,30 @@ type (
 		ContextPath            string
 		LogHealth              bool
 		LogLatencyInteger      bool
+		LogUploadProgress      int
 		EnableAPI              bool
 		UseStatefiles          bool
+		UseMetadataCache       bool
+		PersistMetadataCache   bool
 		AllowOverwrite         bool
 		DisableDelete          bool
 		AllowForceOverwrite    bool
+		IdempotentUploads      bool
 		EnableMetrics          bool
+		MetricsMaxTenants      int
+		MetricsUsername        string
+		MetricsPassword        string
+		MetricsBearerToken     string
 		AnonymousGet           bool
 		GenIndex               bool
+		ExportDirectory        string
+		ValidateConfig         bool
 		MaxStorageObjects      int
 		IndexLimit             int
+		RegenerationLimit      int
+		IndexSharding          string
+		IndexShards            int
+		IndexJournal           bool
 		Depth                  int
 		MaxUploadSize          int
 		BearerAuth             bool
@@ -64,14 +101,164 @@ type (
 		AuthActionsSearchPath  string
 		DepthDynamic           bool
 		CORSAllowOrigin        string
+		EnableCompression      bool
+		CompressionMinSize     int
+		CompressionTypes       []string
 		ReadTimeout            int
 		WriteTimeout           int
+		ShutdownTimeout        int
+		RequestTimeout         int
+		IdleTimeout            int
+		ReadHeaderTimeout      int
+		MaxHeaderBytes         int
 		CacheInterval          time.Duration
+		StaleWhileRevalidate   bool
+		MaxStaleness           time.Duration
 		Host                   string
+		UnixSocket             string
+		TrustedProxies         []string
+		ProxyProtocol          bool
+		AdminPort              int
 		Version                string
+		Revision               string
+		// AnonymousMethods, e.g. POST, may be used without credentials on the routes writing to repos
+		AnonymousMethods []string
+		// KubeAuth accepts the tokens of Kubernetes service accounts as credentials of the repos it grants them
+		KubeAuth *kubeauth.Authenticator
+		// Authorizers let packages embedding the server authorize requests their own way, each of them
+		// having to allow the requests the credentials above allow, see cm_router.Authorizer
+		Authorizers []cm_router.Authorizer
 		// PerChartLimit allow museum server to keep max N version Charts
 		// And avoid swelling too large(if so , the index genertion will become slow)
 		PerChartLimit int
+		// TenantConfig holds settings overridden by some tenants of a multitenant server
+		TenantConfig *tenant.Config
+		// EnableTenantAPI adds routes to manage TenantConfig, which is then also kept in storage
+		EnableTenantAPI bool
+		// TenantHostPattern resolves the tenant from the request host, e.g. "{tenant}.charts.example.com"
+		TenantHostPattern string
+		// WebhookURLs are notified of chart and index events, signed with WebhookSecret if set
+		WebhookURLs    []string
+		WebhookSecret  string
+		WebhookRetries int
+		// EventPublishers receive the same events as WebhookURLs, e.g. to publish them on a message bus
+		EventPublishers []webhook.Publisher
+		// EnableOCI serves charts with the OCI distribution api under /v2/
+		EnableOCI bool
+		// EnableWebUI serves a page browsing the charts of a repo with the api at the root
+		EnableWebUI bool
+		// LandingJSON serves the landing document at / as json, describing the version, features and
+		// repos of the server, otherwise only served to clients accepting json. LandingTemplate is an
+		// html/template file rendering it instead of the welcome page
+		LandingJSON     bool
+		LandingTemplate string
+		// EnablePprof serves net/http/pprof and a dump of the server state under /api/admin/debug,
+		// with mutex contention sampled at a rate of 1/MutexProfileFraction if set
+		EnablePprof          bool
+		MutexProfileFraction int
+		// TracingEndpoint is an OTLP/HTTP collector receiving traces of the server, e.g. Jaeger or Tempo
+		TracingEndpoint    string
+		TracingServiceName string
+		TracingSampleRatio float64
+		// EnableAccessLog writes a line per request, as json or in the Apache combined format
+		EnableAccessLog     bool
+		AccessLogOutput     string
+		AccessLogFormat     string
+		AccessLogFields     []string
+		AccessLogMaxSize    int
+		AccessLogMaxBackups int
+		// StatsdAddress receives the metrics in the dogstatsd format, for servers not scraped by Prometheus
+		StatsdAddress  string
+		StatsdInterval time.Duration
+		StatsdTags     []string
+		// LegacyErrorBodies returns errors as {"error": message} as former releases did, instead of problem+json
+		LegacyErrorBodies bool
+		// ResponseHeaders are comma-separated headers set on responses, e.g. for HSTS, see the router
+		ResponseHeaders string
+		// ProxyUpstream is a chart repo proxied by the server, tenants may override it
+		ProxyUpstream string
+		ProxyIndexTTL time.Duration
+		// Replication lists chart repos periodically copied into storage, e.g. for air-gapped mirrors
+		Replication *replication.Config
+		// Ingestion lists GitHub and GitLab projects whose releases are periodically imported into storage
+		Ingestion *ingestion.Config
+		// TrashRetention keeps deleted chart versions restorable for a while, 0 deletes them right away
+		TrashRetention time.Duration
+		// MaintenanceMessage is returned for writes in maintenance mode, toggled with /api/admin/maintenance
+		MaintenanceMessage string
+		// ScanURL is a vulnerability scanner uploaded charts are submitted to, their images or their
+		// package, failing with findings at or above ScanSeverity. ScanBlock rejects them
+		ScanURL      string
+		ScanPayload  string
+		ScanSeverity string
+		ScanBlock    bool
+		ScanTimeout  time.Duration
+		// IndexAnnotations adds the annotations set on chart versions with the api to index.yaml
+		IndexAnnotations bool
+		// ChartOwners records the user uploading a chart first as the owner of its name. With
+		// RestrictToOwners, only its owners and the ChartAdmins may change the chart then
+		ChartOwners      bool
+		RestrictToOwners bool
+		ChartAdmins      []string
+		// ProtectedCharts are chart names or regular expressions whose versions may only be deleted
+		// or overwritten by the ChartAdmins, forcing it with the X-Force-Protected header
+		ProtectedCharts []string
+		// ForceOverwriteUsers are the only users who may overwrite chart versions with ?force if set
+		ForceOverwriteUsers []string
+		// ChartHistory records every upload, overwrite and deletion of a chart version, with
+		// the user and request making it, served by the history route of the chart
+		ChartHistory bool
+		// IndexSigningKey is a PGP keyring whose key signs index.yaml, served as index.yaml.asc,
+		// the key with an identity containing IndexSigningKeyName if set
+		IndexSigningKey        string
+		IndexSigningKeyName    string
+		IndexSigningPassphrase string
+		// CacheControlIndex and CacheControlCharts are the Cache-Control headers of index files,
+		// and of chart packages and provenance files, e.g. for CDNs
+		CacheControlIndex  string
+		CacheControlCharts string
+		// ChartIcons serves the icons of chart packages and rewrites the icon urls of the index to
+		// them, "proxy" also fetching remote icons once to cache them in storage
+		ChartIcons string
+		// DownloadLinkSecret signs the links of /api/charts/<name>/<version>/link, downloading a chart
+		// version without credentials for up to DownloadLinkMaxTTL
+		DownloadLinkSecret string
+		DownloadLinkMaxTTL time.Duration
+		// DownloadRedirectURL redirects downloads of chart packages and provenance files to a CDN,
+		// e.g. https://cdn.example.com/{path}, PresignDownloads to presigned urls of the
+		// Amazon S3 bucket valid for DownloadRedirectTTL
+		DownloadRedirectURL string
+		PresignDownloads    bool
+		DownloadRedirectTTL time.Duration
+		// PrimeTenants are the repos whose index is built at startup in multitenant mode, along
+		// with every repo found in storage with PrimeAllTenants
+		PrimeTenants    []string
+		PrimeAllTenants bool
+		// CacheTTL and CacheMaxEntries bound the repo indexes held in memory in multitenant mode,
+		// dropping those unused for CacheTTL and the least recently used beyond CacheMaxEntries
+		CacheTTL        time.Duration
+		CacheMaxEntries int
+		// APICacheTTL caches the responses of the chart listing api in multitenant mode, for the
+		// index they were built from and for APICacheTTL at most
+		APICacheTTL time.Duration
+		// ChartNamePattern and ChartVersionPattern are regular expressions the names and versions
+		// of the charts uploaded must match, StrictSemver requiring versions to be semver 2.0
+		ChartNamePattern    string
+		ChartVersionPattern string
+		StrictSemver        bool
+		// Locker serializes the writes to a repo and to its index-cache.yaml across the replicas
+		// sharing the storage backend, waiting LockTimeout for a lock at most
+		Locker      lock.Locker
+		LockTimeout time.Duration
+		// Leader elects the replica running the trash purges and replication in multitenant mode
+		Leader *lock.Elector
+		// AlertStorageBytes, AlertChartVersions, AlertIndexBytes and AlertErrorRate are thresholds of
+		// the repos checked every AlertInterval in multitenant mode, alerts being sent as events
+		AlertStorageBytes  int
+		AlertChartVersions int
+		AlertIndexBytes    int
+		AlertErrorRate     float64
+		AlertInterval      time.Duration
 		// Deprecated: see https://github.com/helm/chartmuseum/issues/485 for more info
 		EnforceSemver2 bool
 		// Deprecated: Debug is no longer effective. ServerOptions now requires the Logger field to be set and configured with LoggerOptions accordingly.
@@ -80,19 +267,82 @@ type (
 		LogJSON bool
 	}
 
+	// ReloadOptions are the options of a running Server changed on config reload
+	ReloadOptions = mt.ReloadOptions
+
 	// Server is a generic interface for web servers
 	Server interface {
 		Listen(port int)
+		Reload(options ReloadOptions) error
+		// Handler serves the routes of the server, for embedding it under the mux and TLS stack of
+		// another program instead of calling Listen
+		Handler() http.Handler
+		// Shutdown stops the background work of a server served with Handler, once its requests are done
+		Shutdown(ctx context.Context)
+	}
+
+	// Option customizes the Server created by NewServer beyond ServerOptions
+	Option func(*serverConfig)
+
+	serverConfig struct {
+		middleware []gin.HandlerFunc
 	}
 )
 
+// WithMiddleware adds gin middleware run for every request once its id is set, before it is
+// routed to its handler and authorized, in the order given
+func WithMiddleware(middleware ...gin.HandlerFunc) Option {
+	return func(config *serverConfig) {
+		config.middleware = append(config.middleware, middleware...)
+	}
+}
+
 // NewServer creates a new Server instance
-func NewServer(options ServerOptions) (Server, error) {
+func NewServer(options ServerOptions, opts ...Option) (Server, error) {
+	config := &serverConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if options.Depth < 0 {
+		return nil, fmt.Errorf("invalid depth: %d, must be 0 or greater", options.Depth)
+	}
+
 	contextPath := strings.TrimSuffix(options.ContextPath, "/")
 	if contextPath != "" && !strings.HasPrefix(contextPath, "/") {
 		contextPath = "/" + contextPath
 	}
 
+	// the router and the server must share the config, so tenants created through the api are seen by both
+	if options.EnableTenantAPI && options.TenantConfig == nil {
+		options.TenantConfig = &tenant.Config{}
+	}
+
+	tenant.SetMaxMetricsLabels(options.MetricsMaxTenants)
+
+	tracerProvider, err := tracing.NewTracerProvider(tracing.TracerProviderOptions{
+		Logger:         options.Logger,
+		Endpoint:       options.TracingEndpoint,
+		ServiceName:    options.TracingServiceName,
+		ServiceVersion: options.Version,
+		SampleRatio:    options.TracingSampleRatio,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.StatsdAddress != "" && !options.EnableMetrics {
+		return nil, fmt.Errorf("statsd requires metrics, which are disabled")
+	}
+	emitter, err := statsd.NewEmitter(statsd.EmitterOptions{
+		Logger:   options.Logger,
+		Address:  options.StatsdAddress,
+		Interval: options.StatsdInterval,
+		Tags:     options.StatsdTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	router := cm_router.NewRouter(cm_router.RouterOptions{
 		Logger:                options.Logger,
 		LogLatencyInteger:     options.LogLatencyInteger,
@@ -102,9 +352,23 @@ func NewServer(options ServerOptions) (Server, error) {
 		TlsCert:               options.TlsCert,
 		TlsKey:                options.TlsKey,
 		TlsCACert:             options.TlsCACert,
+		TLSMinVersion:         options.TLSMinVersion,
+		TLSCipherSuites:       options.TLSCipherSuites,
+		DisableHTTP2:          options.DisableHTTP2,
+		TLSAuto:               options.TLSAuto,
+		TLSAutoHosts:          options.TLSAutoHosts,
+		TLSAutoEmail:          options.TLSAutoEmail,
+		TLSAutoDirectoryURL:   options.TLSAutoDirectoryURL,
+		TLSAutoStorage:        options.StorageBackend,
+		TLSHTTPPort:           options.TLSHTTPPort,
+		TLSHTTPMode:           options.TLSHTTPMode,
 		LogHealth:             options.LogHealth,
 		EnableMetrics:         options.EnableMetrics,
+		MetricsUsername:       options.MetricsUsername,
+		MetricsPassword:       options.MetricsPassword,
+		MetricsBearerToken:    options.MetricsBearerToken,
 		AnonymousGet:          options.AnonymousGet,
+		AnonymousMethods:      options.AnonymousMethods,
 		Depth:                 options.Depth,
 		MaxUploadSize:         options.MaxUploadSize,
 		BearerAuth:            options.BearerAuth,
@@ -112,12 +376,44 @@ func NewServer(options ServerOptions) (Server, error) {
 		AuthService:           options.AuthService,
 		AuthCertPath:          options.AuthCertPath,
 		AuthActionsSearchPath: options.AuthActionsSearchPath,
+		KubeAuth:              options.KubeAuth,
+		Authorizers:           options.Authorizers,
 		DepthDynamic:          options.DepthDynamic,
 		CORSAllowOrigin:       options.CORSAllowOrigin,
+		EnableCompression:     options.EnableCompression,
+		CompressionMinSize:    options.CompressionMinSize,
+		CompressionTypes:      options.CompressionTypes,
 		ReadTimeout:           options.ReadTimeout,
 		WriteTimeout:          options.WriteTimeout,
+		ShutdownTimeout:       options.ShutdownTimeout,
+		RequestTimeout:        options.RequestTimeout,
+		IdleTimeout:           options.IdleTimeout,
+		ReadHeaderTimeout:     options.ReadHeaderTimeout,
+		MaxHeaderBytes:        options.MaxHeaderBytes,
 		Host:                  options.Host,
+		UnixSocket:            options.UnixSocket,
+		TrustedProxies:        options.TrustedProxies,
+		ProxyProtocol:         options.ProxyProtocol,
+		AdminPort:             options.AdminPort,
+		TenantConfig:          options.TenantConfig,
+		TenantHostPattern:     options.TenantHostPattern,
+		TracerProvider:        tracerProvider,
+		EnableAccessLog:       options.EnableAccessLog,
+		AccessLogOutput:       options.AccessLogOutput,
+		AccessLogFormat:       options.AccessLogFormat,
+		AccessLogFields:       options.AccessLogFields,
+		AccessLogMaxSize:      options.AccessLogMaxSize,
+		AccessLogMaxBackups:   options.AccessLogMaxBackups,
+		LegacyErrorBodies:     options.LegacyErrorBodies,
+		ResponseHeaders:       options.ResponseHeaders,
+		LogUploadProgress:     options.LogUploadProgress,
 	})
+	if emitter != nil {
+		router.RegisterOnShutdown(emitter.Stop)
+	}
+	if len(config.middleware) > 0 {
+		router.Use(config.middleware...)
+	}
 
 	server, err := mt.NewMultiTenantServer(mt.MultiTenantServerOptions{
 		Logger:                 options.Logger,
@@ -126,19 +422,89 @@ func NewServer(options ServerOptions) (Server, error) {
 		ExternalCacheStore:     options.ExternalCacheStore,
 		TimestampTolerance:     options.TimestampTolerance,
 		ChartURL:               strings.TrimSuffix(options.ChartURL, "/"),
+		ChartURLTemplate:       strings.TrimSuffix(options.ChartURLTemplate, "/"),
 		ChartPostFormFieldName: options.ChartPostFormFieldName,
 		ProvPostFormFieldName:  options.ProvPostFormFieldName,
 		MaxStorageObjects:      options.MaxStorageObjects,
 		IndexLimit:             options.IndexLimit,
+		RegenerationLimit:      options.RegenerationLimit,
+		IndexSharding:          options.IndexSharding,
+		IndexShards:            options.IndexShards,
+		IndexJournal:           options.IndexJournal,
 		GenIndex:               options.GenIndex,
+		ExportDirectory:        options.ExportDirectory,
+		ValidateConfig:         options.ValidateConfig,
 		EnableAPI:              options.EnableAPI,
 		DisableDelete:          options.DisableDelete,
 		UseStatefiles:          options.UseStatefiles,
+		UseMetadataCache:       options.UseMetadataCache,
+		PersistMetadataCache:   options.PersistMetadataCache,
 		AllowOverwrite:         options.AllowOverwrite,
 		AllowForceOverwrite:    options.AllowForceOverwrite,
+		IdempotentUploads:      options.IdempotentUploads,
 		Version:                options.Version,
+		Revision:               options.Revision,
 		CacheInterval:          options.CacheInterval,
+		StaleWhileRevalidate:   options.StaleWhileRevalidate,
+		MaxStaleness:           options.MaxStaleness,
 		PerChartLimit:          options.PerChartLimit,
+		TenantConfig:           options.TenantConfig,
+		EnableTenantAPI:        options.EnableTenantAPI,
+		WebhookURLs:            options.WebhookURLs,
+		WebhookSecret:          options.WebhookSecret,
+		WebhookRetries:         options.WebhookRetries,
+		EventPublishers:        options.EventPublishers,
+		EnableOCI:              options.EnableOCI,
+		EnableWebUI:            options.EnableWebUI,
+		LandingJSON:            options.LandingJSON,
+		LandingTemplate:        options.LandingTemplate,
+		EnablePprof:            options.EnablePprof,
+		MutexProfileFraction:   options.MutexProfileFraction,
+		ProxyUpstream:          options.ProxyUpstream,
+		ProxyIndexTTL:          options.ProxyIndexTTL,
+		Replication:            options.Replication,
+		Ingestion:              options.Ingestion,
+		TrashRetention:         options.TrashRetention,
+		MaintenanceMessage:     options.MaintenanceMessage,
+		ScanURL:                options.ScanURL,
+		ScanPayload:            options.ScanPayload,
+		ScanSeverity:           options.ScanSeverity,
+		ScanBlock:              options.ScanBlock,
+		ScanTimeout:            options.ScanTimeout,
+		IndexAnnotations:       options.IndexAnnotations,
+		ChartOwners:            options.ChartOwners,
+		RestrictToOwners:       options.RestrictToOwners,
+		ChartHistory:           options.ChartHistory,
+		ChartAdmins:            options.ChartAdmins,
+		ForceOverwriteUsers:    options.ForceOverwriteUsers,
+		ProtectedCharts:        options.ProtectedCharts,
+		IndexSigningKey:        options.IndexSigningKey,
+		IndexSigningKeyName:    options.IndexSigningKeyName,
+		IndexSigningPassphrase: options.IndexSigningPassphrase,
+		CacheControlIndex:      options.CacheControlIndex,
+		CacheControlCharts:     options.CacheControlCharts,
+		ChartIcons:             options.ChartIcons,
+		DownloadLinkSecret:     options.DownloadLinkSecret,
+		DownloadLinkMaxTTL:     options.DownloadLinkMaxTTL,
+		DownloadRedirectURL:    options.DownloadRedirectURL,
+		PresignDownloads:       options.PresignDownloads,
+		DownloadRedirectTTL:    options.DownloadRedirectTTL,
+		PrimeTenants:           options.PrimeTenants,
+		PrimeAllTenants:        options.PrimeAllTenants,
+		CacheTTL:               options.CacheTTL,
+		CacheMaxEntries:        options.CacheMaxEntries,
+		APICacheTTL:            options.APICacheTTL,
+		ChartNamePattern:       options.ChartNamePattern,
+		ChartVersionPattern:    options.ChartVersionPattern,
+		StrictSemver:           options.StrictSemver,
+		Locker:                 options.Locker,
+		LockTimeout:            options.LockTimeout,
+		Leader:                 options.Leader,
+		AlertStorageBytes:      options.AlertStorageBytes,
+		AlertChartVersions:     options.AlertChartVersions,
+		AlertIndexBytes:        options.AlertIndexBytes,
+		AlertErrorRate:         options.AlertErrorRate,
+		AlertInterval:          options.AlertInterval,
 		// Deprecated options
 		// EnforceSemver2 - see https://github.com/helm/chartmuseum/issues/485 for more info
 		EnforceSemver2: options.EnforceSemver2,
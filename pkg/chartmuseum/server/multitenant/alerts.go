@@ -0,0 +1,294 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"errors"
+	pathutil "path"
+	"sort"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	alertMetricStorageBytes  = "storage_bytes"
+	alertMetricChartVersions = "chart_versions"
+	alertMetricIndexBytes    = "index_bytes"
+	alertMetricErrorRate     = "error_rate"
+
+	// minAlertRequests is the number of requests to a repo in an interval under which its
+	// error rate is not checked, so that a single failure does not raise an alert
+	minAlertRequests = 10
+)
+
+type (
+	// alerts holds the thresholds of the metrics of the repos, and which ones are crossed
+	alerts struct {
+		StorageBytes  int
+		ChartVersions int
+		IndexBytes    int
+		ErrorRate     float64
+		Interval      time.Duration
+		// Firing holds the metrics of each repo above their threshold when last checked
+		Firing    map[string]map[string]bool
+		Responses map[string]*responseCount
+		// Sizes holds the size of the objects of each repo when last checked, so that only those
+		// added or changed since are read, listings having no sizes
+		Sizes map[string]map[string]objectSize
+		Mutex *sync.Mutex
+	}
+
+	objectSize struct {
+		Size         int
+		LastModified time.Time
+	}
+
+	// responseCount counts the responses to the requests of a repo since the last check
+	responseCount struct {
+		Total  int
+		Errors int
+	}
+)
+
+// newAlerts returns the alerts of the thresholds set, or nil when none is
+func newAlerts(storageBytes int, chartVersions int, indexBytes int, errorRate float64, interval time.Duration) (*alerts, error) {
+	if errorRate < 0 || errorRate > 1 {
+		return nil, errors.New("alert error rate must be between 0 and 1")
+	}
+	if storageBytes <= 0 && chartVersions <= 0 && indexBytes <= 0 && errorRate <= 0 {
+		return nil, nil
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &alerts{
+		StorageBytes:  storageBytes,
+		ChartVersions: chartVersions,
+		IndexBytes:    indexBytes,
+		ErrorRate:     errorRate,
+		Interval:      interval,
+		Firing:        map[string]map[string]bool{},
+		Responses:     map[string]*responseCount{},
+		Sizes:         map[string]map[string]objectSize{},
+		Mutex:         &sync.Mutex{},
+	}, nil
+}
+
+// countResponses wraps the handler of a repo route, counting the 5xx responses of the repo
+// for its error rate
+func (server *MultiTenantServer) countResponses(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler(c)
+		repo := c.Param("repo")
+		server.Alerts.Mutex.Lock()
+		defer server.Alerts.Mutex.Unlock()
+		count := server.Alerts.Responses[repo]
+		if count == nil {
+			count = &responseCount{}
+			server.Alerts.Responses[repo] = count
+		}
+		count.Total++
+		if c.Writer.Status() >= 500 {
+			count.Errors++
+		}
+	}
+}
+
+// initAlertTimer checks the alert thresholds of the repos every alert interval
+func (server *MultiTenantServer) initAlertTimer() {
+	if server.Alerts == nil {
+		return
+	}
+	go func() {
+		log := server.Logger.ContextLoggingFn(&gin.Context{})
+		t := time.NewTicker(server.Alerts.Interval)
+		for range t.C {
+			server.checkAlerts(log)
+		}
+	}()
+}
+
+// checkAlerts sends an alert event for each metric of a repo crossing its threshold since the
+// last check, either way. The metrics of storage are checked by the elected replica only, the
+// error rate of the requests served by every replica
+func (server *MultiTenantServer) checkAlerts(log cm_logger.LoggingFn) {
+	alerts := server.Alerts
+	alerts.Mutex.Lock()
+	responses := alerts.Responses
+	alerts.Responses = map[string]*responseCount{}
+	alerts.Mutex.Unlock()
+
+	repos := map[string]bool{}
+	for repo := range responses {
+		repos[repo] = true
+	}
+	server.TenantCacheKeyLock.RLock()
+	for repo := range server.Tenants {
+		repos[repo] = true
+	}
+	server.TenantCacheKeyLock.RUnlock()
+	sortedRepos := make([]string, 0, len(repos))
+	for repo := range repos {
+		sortedRepos = append(sortedRepos, repo)
+	}
+	sort.Strings(sortedRepos)
+
+	// sizes of the repos no longer in memory are read again if they come back
+	alerts.Mutex.Lock()
+	for repo := range alerts.Sizes {
+		if !repos[repo] {
+			delete(alerts.Sizes, repo)
+		}
+	}
+	alerts.Mutex.Unlock()
+
+	leader := server.Leader.IsLeader()
+	for _, repo := range sortedRepos {
+		if count := responses[repo]; alerts.ErrorRate > 0 && count != nil && count.Total >= minAlertRequests {
+			server.checkAlert(log, repo, alertMetricErrorRate, float64(count.Errors)/float64(count.Total), alerts.ErrorRate)
+		}
+		if !leader || server.getTenant(repo) == nil {
+			continue
+		}
+		if alerts.ChartVersions > 0 || alerts.IndexBytes > 0 {
+			if chartVersions, indexBytes, ok := server.indexMetrics(repo); ok {
+				if alerts.ChartVersions > 0 {
+					server.checkAlert(log, repo, alertMetricChartVersions, float64(chartVersions), float64(alerts.ChartVersions))
+				}
+				if alerts.IndexBytes > 0 {
+					server.checkAlert(log, repo, alertMetricIndexBytes, float64(indexBytes), float64(alerts.IndexBytes))
+				}
+			}
+		}
+		if alerts.StorageBytes > 0 {
+			storageBytes, err := server.storageBytes(repo)
+			if err != nil {
+				log(cm_logger.ErrorLevel, "Error reading storage for alerts",
+					"repo", repo,
+					"error", err.Error(),
+				)
+			} else {
+				server.checkAlert(log, repo, alertMetricStorageBytes, float64(storageBytes), float64(alerts.StorageBytes))
+			}
+		}
+	}
+}
+
+// checkAlert sends an alert event when a metric of a repo crosses its threshold
+func (server *MultiTenantServer) checkAlert(log cm_logger.LoggingFn, repo string, metric string, value float64, threshold float64) {
+	firing := value > threshold
+	server.Alerts.Mutex.Lock()
+	if server.Alerts.Firing[repo] == nil {
+		server.Alerts.Firing[repo] = map[string]bool{}
+	}
+	wasFiring := server.Alerts.Firing[repo][metric]
+	server.Alerts.Firing[repo][metric] = firing
+	server.Alerts.Mutex.Unlock()
+	if firing == wasFiring {
+		return
+	}
+
+	eventType := webhook.AlertResolvedEvent
+	level := cm_logger.InfoLevel
+	if firing {
+		eventType = webhook.AlertFiringEvent
+		level = cm_logger.WarnLevel
+	}
+	log(level, "Alert threshold crossed",
+		"repo", repo,
+		"metric", metric,
+		"value", value,
+		"threshold", threshold,
+		"firing", firing,
+	)
+	event := webhook.NewEvent(eventType, repo, nil)
+	event.Alert = &webhook.Alert{Metric: metric, Value: value, Threshold: threshold}
+	server.Notifier.Notify(event)
+	server.EventStream.publish(event)
+}
+
+// indexMetrics returns the number of chart versions of a repo and the size of its index, when its
+// index is cached and built. Indexes are neither built nor kept from eviction for alerts
+func (server *MultiTenantServer) indexMetrics(repo string) (int, int, bool) {
+	var index *cm_repo.Index
+	if server.ExternalCacheStore != nil {
+		content, err := server.ExternalCacheStore.Get(repo)
+		if err != nil {
+			return 0, 0, false
+		}
+		entry := &cacheEntry{}
+		if err := json.Unmarshal(content, entry); err != nil {
+			return 0, 0, false
+		}
+		index = entry.RepoIndex
+	} else {
+		server.TenantCacheKeyLock.RLock()
+		entry, ok := server.InternalCacheStore[repo]
+		server.TenantCacheKeyLock.RUnlock()
+		if !ok {
+			return 0, 0, false
+		}
+		index = server.getRepoIndex(entry)
+		if len(index.Entries) == 0 && !server.refreshed(entry) {
+			return 0, 0, false
+		}
+	}
+	chartVersions := 0
+	for _, versions := range index.Entries {
+		chartVersions += len(versions)
+	}
+	return chartVersions, len(index.Raw), true
+}
+
+// storageBytes returns the size of the objects of a repo. Storage listings have no sizes, so only
+// the objects added or modified since the last check are read
+func (server *MultiTenantServer) storageBytes(repo string) (int, error) {
+	objects, err := server.StorageBackend.ListObjects(repo)
+	if err != nil {
+		return 0, err
+	}
+	server.Alerts.Mutex.Lock()
+	known := server.Alerts.Sizes[repo]
+	server.Alerts.Mutex.Unlock()
+
+	sizes := make(map[string]objectSize, len(objects))
+	total := 0
+	for _, object := range objects {
+		size, ok := known[object.Path]
+		if !ok || !size.LastModified.Equal(object.LastModified) {
+			content, err := server.StorageBackend.GetObject(pathutil.Join(repo, object.Path))
+			if err != nil {
+				return 0, err
+			}
+			size = objectSize{Size: len(content.Content), LastModified: object.LastModified}
+		}
+		sizes[object.Path] = size
+		total += size.Size
+	}
+
+	server.Alerts.Mutex.Lock()
+	server.Alerts.Sizes[repo] = sizes
+	server.Alerts.Mutex.Unlock()
+	return total, nil
+}
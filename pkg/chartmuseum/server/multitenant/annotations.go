@@ -0,0 +1,128 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (server *MultiTenantServer) getChartVersionAnnotationsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	annotations, readErr := server.readAnnotations(server.attachmentRepos(repo), chartVersion.Name, chartVersion.Version)
+	if readErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, readErr.Error())
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	c.JSON(200, annotations)
+}
+
+// patchChartVersionAnnotationsRequestHandler sets annotations of a chart version with a JSON merge
+// patch, e.g. {"approved-for-prod": "true", "deprecated-by": null}, and returns them
+func (server *MultiTenantServer) patchChartVersionAnnotationsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	patch, getContentErr := c.GetRawData()
+	if getContentErr != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large"
+		}
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, fmt.Sprintf("%s", getContentErr))
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	name, version := chartVersion.Name, chartVersion.Version
+
+	// patches of a chart version are applied one at a time, so none is lost
+	server.AnnotationsLock.Lock()
+	defer server.AnnotationsLock.Unlock()
+
+	annotations, readErr := server.readAnnotations([]string{repo}, name, version)
+	if readErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, readErr.Error())
+		return
+	}
+	annotations, patchErr := cm_repo.PatchAnnotations(annotations, patch)
+	if patchErr != nil {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, patchErr.Error())
+		return
+	}
+
+	filename := pathutil.Join(repo, cm_repo.AttachmentFilenameFromNameVersion(name, version, cm_repo.AnnotationsAttachmentKind))
+	if len(annotations) == 0 {
+		log(cm_logger.DebugLevel, "Deleting annotations from storage",
+			"annotations", filename,
+		)
+		server.StorageBackend.DeleteObject(filename) // ignore error here, may be no annotations
+	} else {
+		content, _ := json.Marshal(annotations)
+		log(cm_logger.DebugLevel, "Adding annotations to storage",
+			"annotations", filename,
+		)
+		if putErr := server.StorageBackend.PutObject(filename, content); putErr != nil {
+			cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, putErr.Error())
+			return
+		}
+	}
+
+	if server.IndexAnnotations {
+		// the chart version of the index may carry the annotations removed, start again from its package
+		object, getErr := server.StorageBackend.GetObject(pathutil.Join(repo, cm_repo.ChartPackageFilenameFromNameVersion(name, version)))
+		if getErr == nil {
+			chartVersion, chartErr := cm_repo.ChartVersionFromStorageObject(object)
+			if chartErr == nil {
+				server.emitEvent(c, repo, updateChart, cm_repo.ChartVersionWithAnnotations(chartVersion, annotations))
+			}
+		}
+	}
+	c.JSON(200, annotations)
+}
+
+// readAnnotations reads the annotations set on a chart version with the api, from the first of
+// repos having them, or returns nil
+func (server *MultiTenantServer) readAnnotations(repos []string, name string, version string) (map[string]string, error) {
+	filename := cm_repo.AttachmentFilenameFromNameVersion(name, version, cm_repo.AnnotationsAttachmentKind)
+	for _, repo := range repos {
+		object, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+		if err != nil {
+			continue
+		}
+		return cm_repo.AnnotationsFromContent(object.Content)
+	}
+	return nil, nil
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package multitenant
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	pathutil "path/filepath"
@@ -26,16 +28,18 @@ import (
 	"github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/scan"
 
 	"helm.sh/helm/v3/pkg/chart"
 	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
-func (server *MultiTenantServer) getAllCharts(log cm_logger.LoggingFn, repo string, offset int, limit int) (map[string]helm_repo.ChartVersions, *HTTPError) {
-	indexFile, err := server.getIndexFile(log, repo)
+func (server *MultiTenantServer) getAllCharts(ctx context.Context, log cm_logger.LoggingFn, repo string, offset int, limit int) (map[string]helm_repo.ChartVersions, *HTTPError) {
+	indexFile, err := server.getIndexFileForAPI(ctx, log, repo)
 	if err != nil {
-		return nil, &HTTPError{http.StatusInternalServerError, err.Message}
+		return nil, &HTTPError{http.StatusInternalServerError, err.Code, err.Message}
 	}
 	if offset == 0 && limit == -1 {
 		return indexFile.Entries, nil
@@ -56,58 +60,104 @@ func (server *MultiTenantServer) getAllCharts(log cm_logger.LoggingFn, repo stri
 	return result, nil
 }
 
-func (server *MultiTenantServer) getChart(log cm_logger.LoggingFn, repo string, name string) (helm_repo.ChartVersions, *HTTPError) {
-	allCharts, err := server.getAllCharts(log, repo, 0, -1)
+func (server *MultiTenantServer) getChart(ctx context.Context, log cm_logger.LoggingFn, repo string, name string) (helm_repo.ChartVersions, *HTTPError) {
+	allCharts, err := server.getAllCharts(ctx, log, repo, 0, -1)
 	if err != nil {
 		return nil, err
 	}
 	chart := allCharts[name]
 	if chart == nil {
-		return nil, &HTTPError{http.StatusNotFound, "chart not found"}
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "chart not found"}
 	}
 	return chart, nil
 }
 
-func (server *MultiTenantServer) getChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) (*helm_repo.ChartVersion, *HTTPError) {
-	indexFile, err := server.getIndexFile(log, repo)
+func (server *MultiTenantServer) getChartVersion(ctx context.Context, log cm_logger.LoggingFn, repo string, name string, version string) (*helm_repo.ChartVersion, *HTTPError) {
+	indexFile, err := server.getIndexFileForAPI(ctx, log, repo)
 	if err != nil {
-		return nil, &HTTPError{http.StatusInternalServerError, err.Message}
+		return nil, &HTTPError{http.StatusInternalServerError, err.Code, err.Message}
 	}
 	if version == "latest" {
 		version = ""
 	}
 	chartVersion, getErr := indexFile.Get(name, version)
 	if getErr != nil {
-		return nil, &HTTPError{http.StatusNotFound, getErr.Error()}
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, getErr.Error()}
 	}
 	return chartVersion, nil
 }
 
 func (server *MultiTenantServer) deleteChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) *HTTPError {
+	if server.trashRetention() > 0 {
+		return server.trashChartVersion(log, repo, name, version)
+	}
 	filename := pathutil.Join(repo, cm_repo.ChartPackageFilenameFromNameVersion(name, version))
 	log(cm_logger.DebugLevel, "Deleting package from storage",
 		"package", filename,
 	)
 	deleteObjErr := server.StorageBackend.DeleteObject(filename)
 	if deleteObjErr != nil {
-		return &HTTPError{http.StatusNotFound, deleteObjErr.Error()}
+		return &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, deleteObjErr.Error()}
+	}
+	for _, attachmentFilename := range server.attachmentFilenames(repo, name, version) {
+		server.StorageBackend.DeleteObject(pathutil.Join(repo, attachmentFilename)) // ignore error here, may be no attachment
 	}
-	provFilename := pathutil.Join(repo, cm_repo.ProvenanceFilenameFromNameVersion(name, version))
-	server.StorageBackend.DeleteObject(provFilename) // ignore error here, may be no prov file
 	return nil
 }
 
+// promoteChartVersion copies a chart package and its attachments from one repo to another,
+// following the overwrite and storage limit rules of the target repo
+func (server *MultiTenantServer) promoteChartVersion(ctx context.Context, log cm_logger.LoggingFn, repo string, name string, version string, target string, force bool) (string, []byte, *HTTPError) {
+	chartVersion, err := server.getChartVersion(ctx, log, repo, name, version)
+	if err != nil {
+		return "", nil, err
+	}
+
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+	object, getObjErr := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	if getObjErr != nil {
+		return filename, nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, getObjErr.Error()}
+	}
+	log(cm_logger.DebugLevel, "Promoting package",
+		"package", filename,
+		"repo", repo,
+		"target", target,
+	)
+
+	_, err = server.uploadChartPackage(log, target, object.Content, force)
+	if err != nil && (err.Status != http.StatusConflict || err.Message != "") {
+		return filename, nil, err
+	}
+
+	for _, attachmentFilename := range server.attachmentFilenames(repo, chartVersion.Name, chartVersion.Version) {
+		attachmentObject, getAttachmentErr := server.StorageBackend.GetObject(pathutil.Join(repo, attachmentFilename))
+		if getAttachmentErr != nil { // may be no attachment
+			continue
+		}
+		kind := strings.TrimPrefix(attachmentFilename, filename+".")
+		if attachmentErr := server.uploadAttachment(log, target, chartVersion.Name, chartVersion.Version, kind, attachmentObject.Content, force); attachmentErr != nil {
+			return filename, nil, attachmentErr
+		}
+	}
+
+	return filename, object.Content, err
+}
+
 func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, repo string, content []byte, force bool) (string, *HTTPError) {
 	var filename string
 
 	filename, err := cm_repo.ChartPackageFilenameFromContent(content)
 	if err != nil {
-		return filename, &HTTPError{http.StatusBadRequest, err.Error()}
+		return filename, &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, err.Error()}
 	}
 
 	if pathutil.Base(filename) != filename {
 		// Name wants to break out of current directory
-		return filename, &HTTPError{http.StatusBadRequest, fmt.Sprintf("%s is improperly formatted", filename)}
+		return filename, &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, fmt.Sprintf("%s is improperly formatted", filename)}
+	}
+
+	if err := server.checkChartPolicy(content); err != nil {
+		return filename, err
 	}
 
 	// we should ensure that whether chart is existed even if the `overwrite` option is set
@@ -118,29 +168,40 @@ func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, rep
 	if err == nil {
 		found = true
 		// For those no-overwrite servers, return the Conflict error.
-		if !server.AllowOverwrite && (!server.AllowForceOverwrite || !force) {
-			return filename, &HTTPError{http.StatusConflict, "file already exists"}
+		if !server.allowOverwrite(repo) && (!server.AllowForceOverwrite || !force) {
+			return filename, &HTTPError{http.StatusConflict, cm_router.ErrorCodeVersionExists, "file already exists"}
 		}
 		// continue with the `overwrite` servers
 	}
 
 	limitReached, err := server.checkStorageLimit(repo, filename, force)
 	if err != nil {
-		return filename, &HTTPError{http.StatusInternalServerError, err.Error()}
+		return filename, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
 	}
 	if limitReached {
-		return filename, &HTTPError{http.StatusInsufficientStorage, "repo has reached storage limit"}
+		return filename, &HTTPError{http.StatusInsufficientStorage, cm_router.ErrorCodeStorageLimit, "repo has reached storage limit"}
+	}
+	var scanResult *scan.Result
+	if server.Scanner != nil && server.Scanner.Block {
+		var scanErr *HTTPError
+		if scanResult, scanErr = server.scanBeforeUpload(log, repo, content); scanErr != nil {
+			return filename, scanErr
+		}
 	}
 	log(cm_logger.DebugLevel, "Adding package to storage",
 		"package", filename,
 	)
 	if err := server.PutWithLimit(&gin.Context{}, log, repo, filename, content); err != nil {
-		return filename, &HTTPError{http.StatusInternalServerError, err.Error()}
+		return filename, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	observeUpload(repo, "chart", len(content))
+	if server.Scanner != nil {
+		server.recordScan(log, repo, content, scanResult)
 	}
 	if found {
 		// here is a fake conflict error for outside call
 		// In order to not add another return `bool` check (API Compatibility)
-		return filename, &HTTPError{http.StatusConflict, ""}
+		return filename, &HTTPError{http.StatusConflict, cm_router.ErrorCodeVersionExists, ""}
 	}
 	return filename, nil
 }
@@ -148,46 +209,67 @@ func (server *MultiTenantServer) uploadChartPackage(log cm_logger.LoggingFn, rep
 func (server *MultiTenantServer) uploadProvenanceFile(log cm_logger.LoggingFn, repo string, content []byte, force bool) *HTTPError {
 	filename, err := cm_repo.ProvenanceFilenameFromContent(content)
 	if err != nil {
-		return &HTTPError{http.StatusInternalServerError, err.Error()}
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInvalidChart, err.Error()}
 	}
 
 	if pathutil.Base(filename) != filename {
 		// Name wants to break out of current directory
-		return &HTTPError{http.StatusBadRequest, fmt.Sprintf("%s is improperly formatted", filename)}
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, fmt.Sprintf("%s is improperly formatted", filename)}
 	}
 
-	if !server.AllowOverwrite && (!server.AllowForceOverwrite || !force) {
+	if !server.allowOverwrite(repo) && (!server.AllowForceOverwrite || !force) {
 		_, err = server.StorageBackend.GetObject(pathutil.Join(repo, filename))
 		if err == nil {
-			return &HTTPError{http.StatusConflict, "file already exists"}
+			return &HTTPError{http.StatusConflict, cm_router.ErrorCodeVersionExists, "file already exists"}
 		}
 	}
 	limitReached, err := server.checkStorageLimit(repo, filename, force)
 	if err != nil {
-		return &HTTPError{http.StatusInternalServerError, err.Error()}
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
 	}
 	if limitReached {
-		return &HTTPError{http.StatusInsufficientStorage, "repo has reached storage limit"}
+		return &HTTPError{http.StatusInsufficientStorage, cm_router.ErrorCodeStorageLimit, "repo has reached storage limit"}
 	}
 	log(cm_logger.DebugLevel, "Adding provenance file to storage",
 		"provenance_file", filename,
 	)
 	err = server.StorageBackend.PutObject(pathutil.Join(repo, filename), content)
 	if err != nil {
-		return &HTTPError{http.StatusInternalServerError, err.Error()}
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
 	}
+	observeUpload(repo, "provenance", len(content))
 	return nil
 }
 
+// uploadUnchanged reports whether a file conflicting with an upload has the same content, which
+// makes the upload a no-op with IdempotentUploads instead of a conflict
+func (server *MultiTenantServer) uploadUnchanged(repo string, filename string, content []byte) bool {
+	if !server.IdempotentUploads || filename == "" {
+		return false
+	}
+	existing, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	return err == nil && bytes.Equal(existing.Content, content)
+}
+
+// formUploadUnchanged reports whether every file of a form upload is stored with the same content
+func (server *MultiTenantServer) formUploadUnchanged(repo string, cpFiles map[string]*chartOrProvenanceFile) bool {
+	for _, ppf := range cpFiles {
+		if !server.uploadUnchanged(repo, ppf.filename, ppf.content) {
+			return false
+		}
+	}
+	return len(cpFiles) > 0
+}
+
 func (server *MultiTenantServer) checkStorageLimit(repo string, filename string, force bool) (bool, error) {
-	if server.MaxStorageObjects > 0 {
+	if maxStorageObjects := server.maxStorageObjects(repo); maxStorageObjects > 0 {
 		allObjects, err := server.StorageBackend.ListObjects(repo)
 		if err != nil {
 			return false, err
 		}
-		if len(allObjects) >= server.MaxStorageObjects {
+		if len(allObjects) >= maxStorageObjects {
 			limitReached := true
-			if server.AllowOverwrite || (server.AllowForceOverwrite && force) {
+			if server.allowOverwrite(repo) || (server.AllowForceOverwrite && force) {
 				// if the max has been reached, we should still allow
 				// user to overwrite an existing file
 				for _, object := range allObjects {
@@ -203,6 +285,21 @@ func (server *MultiTenantServer) checkStorageLimit(repo string, filename string,
 	return false, nil
 }
 
+// checkChartPolicy rejects chart packages whose name or version is not allowed by ChartPolicy
+func (server *MultiTenantServer) checkChartPolicy(content []byte) *HTTPError {
+	if server.ChartPolicy == nil {
+		return nil
+	}
+	name, version, err := extractFromChart(content)
+	if err != nil {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, err.Error()}
+	}
+	if err := server.ChartPolicy.Check(name, version); err != nil {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, err.Error()}
+	}
+	return nil
+}
+
 func extractFromChart(content []byte) (name string, version string, err error) {
 	cv, err := cm_repo.ChartVersionFromStorageObject(storage.Object{
 		Content: content,
@@ -219,7 +316,6 @@ func (server *MultiTenantServer) PutWithLimit(ctx *gin.Context, log cm_logger.Lo
 		log(cm_logger.DebugLevel, "PutWithLimit: per-chart-limit not set")
 		return server.StorageBackend.PutObject(pathutil.Join(repo, filename), content)
 	}
-	limit := server.ChartLimits.Limit
 	name, _, err := extractFromChart(content)
 	if err != nil {
 		return err
@@ -227,6 +323,7 @@ func (server *MultiTenantServer) PutWithLimit(ctx *gin.Context, log cm_logger.Lo
 	// lock the backend storage resource to always get the correct one
 	server.ChartLimits.Lock()
 	defer server.ChartLimits.Unlock()
+	limit := server.ChartLimits.Limit
 	// clean the oldest chart(both index and storage)
 	// storage cache first
 	objs, err := server.StorageBackend.ListObjects(repo)
@@ -235,7 +332,7 @@ func (server *MultiTenantServer) PutWithLimit(ctx *gin.Context, log cm_logger.Lo
 	}
 	var newObjs []storage.Object
 	for _, obj := range objs {
-		if !strings.HasPrefix(obj.Path, name) || strings.HasSuffix(obj.Path, ".prov") {
+		if !strings.HasPrefix(obj.Path, name) || !obj.HasExtension(cm_repo.ChartPackageFileExtension) {
 			continue
 		}
 		log(cm_logger.DebugLevel, "PutWithLimit", "current object name", obj.Path)
@@ -0,0 +1,261 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	pathutil "path"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/scan"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+type (
+	// chartVersionWithAttachments is a chart version as served by the api, along with
+	// the kinds of the attachments stored next to its package
+	chartVersionWithAttachments struct {
+		*helm_repo.ChartVersion
+		Attachments []string `json:"attachments"`
+		// Annotations are those of its Chart.yaml along with those set with the api, overriding them
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+)
+
+func (server *MultiTenantServer) getChartVersionAttachmentRequestHandler(c *gin.Context) {
+	server.getChartVersionAttachment(c, c.Param("kind"))
+}
+
+func (server *MultiTenantServer) postChartVersionAttachmentRequestHandler(c *gin.Context) {
+	if server.Scanner != nil && c.Param("kind") == scan.AttachmentKind {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "scan attachments are written by the scanner")
+		return
+	}
+	server.postChartVersionAttachment(c, c.Param("kind"))
+}
+
+func (server *MultiTenantServer) getChartVersionSBOMRequestHandler(c *gin.Context) {
+	server.getChartVersionAttachment(c, cm_repo.SBOMAttachmentKind)
+}
+
+func (server *MultiTenantServer) postChartVersionSBOMRequestHandler(c *gin.Context) {
+	server.postChartVersionAttachment(c, cm_repo.SBOMAttachmentKind)
+}
+
+func (server *MultiTenantServer) deleteChartVersionAttachmentRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	kind := c.Param("kind")
+	if err := cm_repo.ValidateAttachmentKind(kind); err != nil {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	filename := pathutil.Join(repo, cm_repo.AttachmentFilenameFromNameVersion(chartVersion.Name, chartVersion.Version, kind))
+	if _, getErr := server.StorageBackend.GetObject(filename); getErr != nil {
+		cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, "attachment not found")
+		return
+	}
+	log(cm_logger.DebugLevel, "Deleting attachment from storage",
+		"attachment", filename,
+	)
+	if deleteErr := server.StorageBackend.DeleteObject(filename); deleteErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, deleteErr.Error())
+		return
+	}
+	c.JSON(200, objectDeletedResponse)
+}
+
+// getChartVersionAttachment serves an attachment of a chart version, the one of the
+// first member having it for a virtual repo
+func (server *MultiTenantServer) getChartVersionAttachment(c *gin.Context, kind string) {
+	repo := c.Param("repo")
+	if err := cm_repo.ValidateAttachmentKind(kind); err != nil {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	filename := cm_repo.AttachmentFilenameFromNameVersion(chartVersion.Name, chartVersion.Version, kind)
+	for _, r := range server.attachmentRepos(repo) {
+		object, getErr := server.StorageBackend.GetObject(pathutil.Join(r, filename))
+		if getErr != nil {
+			continue
+		}
+		var contentType string
+		switch kind {
+		case cm_repo.ProvenanceAttachmentKind:
+			contentType = provenanceFileContentType
+		case cm_repo.AnnotationsAttachmentKind:
+			contentType = "application/json"
+		case cm_repo.SBOMAttachmentKind:
+			var typeErr error
+			if contentType, typeErr = cm_repo.SBOMContentTypeFromContent(object.Content); typeErr != nil {
+				cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, typeErr.Error())
+				return
+			}
+		default:
+			contentType = http.DetectContentType(object.Content)
+		}
+		c.Data(200, contentType, object.Content)
+		return
+	}
+	cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, "attachment not found")
+}
+
+func (server *MultiTenantServer) postChartVersionAttachment(c *gin.Context, kind string) {
+	repo := c.Param("repo")
+	content, getContentErr := c.GetRawData()
+	if getContentErr != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large"
+		}
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, fmt.Sprintf("%s", getContentErr))
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if err := server.uploadAttachment(log, repo, chartVersion.Name, chartVersion.Version, kind, content, forceQuery(c)); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(201, objectSavedResponse)
+}
+
+// uploadAttachment stores an attachment of a chart version next to its package, following the
+// overwrite and storage limit rules of the repo. Provenance files, SBOMs and annotations are validated
+func (server *MultiTenantServer) uploadAttachment(log cm_logger.LoggingFn, repo string, name string, version string, kind string, content []byte, force bool) *HTTPError {
+	if err := cm_repo.ValidateAttachmentKind(kind); err != nil {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error()}
+	}
+	filename := cm_repo.AttachmentFilenameFromNameVersion(name, version, kind)
+	uploadType := "attachment"
+	switch kind {
+	case cm_repo.ProvenanceAttachmentKind:
+		provFilename, err := cm_repo.ProvenanceFilenameFromContent(content)
+		if err != nil || provFilename != filename {
+			return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "invalid provenance file for this chart version"}
+		}
+		uploadType = "provenance"
+	case cm_repo.SBOMAttachmentKind:
+		if _, err := cm_repo.SBOMContentTypeFromContent(content); err != nil {
+			return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error()}
+		}
+		uploadType = "sbom"
+	case cm_repo.AnnotationsAttachmentKind:
+		if _, err := cm_repo.AnnotationsFromContent(content); err != nil {
+			return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error()}
+		}
+	}
+
+	if !server.allowOverwrite(repo) && (!server.AllowForceOverwrite || !force) {
+		_, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+		if err == nil {
+			return &HTTPError{http.StatusConflict, cm_router.ErrorCodeConflict, "attachment already exists"}
+		}
+	}
+	limitReached, err := server.checkStorageLimit(repo, filename, force)
+	if err != nil {
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	if limitReached {
+		return &HTTPError{http.StatusInsufficientStorage, cm_router.ErrorCodeStorageLimit, "repo has reached storage limit"}
+	}
+	log(cm_logger.DebugLevel, "Adding attachment to storage",
+		"attachment", filename,
+	)
+	if err := server.StorageBackend.PutObject(pathutil.Join(repo, filename), content); err != nil {
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	observeUpload(repo, uploadType, len(content))
+	return nil
+}
+
+// attachmentRepos returns the repos holding the attachments of the charts of a repo,
+// its members for a virtual repo
+func (server *MultiTenantServer) attachmentRepos(repo string) []string {
+	if members := server.virtualMembers(repo); members != nil {
+		return members
+	}
+	return []string{repo}
+}
+
+// attachmentFilenames returns the filenames of the attachments of a chart version stored in a
+// directory, a repo or its trash, falling back to its provenance file and SBOM when it cannot be listed
+func (server *MultiTenantServer) attachmentFilenames(directory string, name string, version string) []string {
+	kinds := []string{cm_repo.ProvenanceAttachmentKind, cm_repo.SBOMAttachmentKind}
+	if objects, err := server.StorageBackend.ListObjects(directory); err == nil {
+		kinds = cm_repo.AttachmentKindsFromObjects(objects, name, version)
+	}
+	filenames := make([]string, len(kinds))
+	for i, kind := range kinds {
+		filenames[i] = cm_repo.AttachmentFilenameFromNameVersion(name, version, kind)
+	}
+	return filenames
+}
+
+// withAttachments lists the attachments of chart versions of a repo, with a single listing of its objects,
+// and adds their annotations set with the api
+func (server *MultiTenantServer) withAttachments(repo string, chartVersions ...*helm_repo.ChartVersion) ([]*chartVersionWithAttachments, *HTTPError) {
+	var objects []cm_storage.Object
+	repos := server.attachmentRepos(repo)
+	for _, r := range repos {
+		repoObjects, err := server.StorageBackend.ListObjects(r)
+		if err != nil {
+			return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+		}
+		objects = append(objects, repoObjects...)
+	}
+	result := make([]*chartVersionWithAttachments, len(chartVersions))
+	for i, chartVersion := range chartVersions {
+		kinds := cm_repo.AttachmentKindsFromObjects(objects, chartVersion.Name, chartVersion.Version)
+		result[i] = &chartVersionWithAttachments{chartVersion, kinds, nil}
+		if chartVersion.Metadata != nil {
+			result[i].Annotations = chartVersion.Annotations
+		}
+		for _, kind := range kinds {
+			if kind != cm_repo.AnnotationsAttachmentKind {
+				continue
+			}
+			annotations, err := server.readAnnotations(repos, chartVersion.Name, chartVersion.Version)
+			if err != nil {
+				return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error()}
+			}
+			result[i].Annotations = cm_repo.MergeAnnotations(result[i].Annotations, annotations)
+		}
+	}
+	return result, nil
+}
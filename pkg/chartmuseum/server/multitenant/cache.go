@@ -36,18 +36,25 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	pathutil "path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/webhook"
 
 	cm_storage "github.com/chartmuseum/storage"
 	"github.com/ghodss/yaml"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
@@ -56,6 +63,9 @@ type (
 		// cryptic JSON field names to minimize size saved in cache
 		RepoName  string         `json:"a"`
 		RepoIndex *cm_repo.Index `json:"b"`
+		// tenant is the one of the repo when the entry was looked up, which requests in flight
+		// keep using once it is evicted
+		tenant *tenantInternals
 	}
 
 	event struct {
@@ -63,6 +73,12 @@ type (
 		RepoName     string                  `json:"repo_name"`
 		OpType       operationType           `json:"operation_type"`
 		ChartVersion *helm_repo.ChartVersion `json:"chart_version"`
+		// RequestID is read when the event is emitted, as gin contexts are reused once their request is served
+		RequestID string `json:"request_id,omitempty"`
+		// User is the user of the request, read along with RequestID
+		User string `json:"user,omitempty"`
+		// SpanContext is the one of the request, read along with RequestID, for the index update to be traced with it
+		SpanContext trace.SpanContext `json:"-"`
 	}
 
 	operationType int
@@ -77,123 +93,214 @@ const (
 var (
 	EntrySavedMessage             = "Entry saved in cache store"
 	CouldNotSaveEntryErrorMessage = "Could not save entry in cache store"
+
+	errRegenerationLimited = errors.New("regeneration limit reached")
 )
 
 func (server *MultiTenantServer) primeCache() error {
-	// only prime the cache if this is a single tenant setup
-	if server.Router.Depth == 0 {
-		log := server.Logger.ContextLoggingFn(&gin.Context{})
-		_, err := server.getIndexFile(log, "")
-		if err != nil {
-			return errors.New(err.Message)
+	// only prime the cache if this is a single tenant setup, or for the tenants set to be primed
+	repos, err := server.primedRepos()
+	if err != nil {
+		return err
+	}
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	errs := make(chan error, len(repos))
+	for _, repo := range repos {
+		go func(repo string) {
+			_, err := server.getIndexFile(context.Background(), log, repo)
+			if err != nil && repo != "" {
+				errs <- fmt.Errorf("repo %s: %s", repo, err.Message)
+			} else if err != nil {
+				errs <- errors.New(err.Message)
+			} else {
+				errs <- nil
+			}
+		}(repo)
+	}
+	for range repos {
+		if primeErr := <-errs; primeErr != nil && err == nil {
+			err = primeErr
 		}
 	}
-	return nil
+	return err
 }
 
 // getChartList fetches from the server and accumulates concurrent requests to be fulfilled all at once.
-func (server *MultiTenantServer) getChartList(log cm_logger.LoggingFn, repo string) <-chan fetchedObjects {
+// Without a free regeneration slot, it fails with errRegenerationLimited unless wait is set
+func (server *MultiTenantServer) getChartList(ctx context.Context, log cm_logger.LoggingFn, entry *cacheEntry, wait bool) <-chan fetchedObjects {
 	ch := make(chan fetchedObjects, 1)
-	tenant := server.Tenants[repo]
-
-	tenant.FetchedObjectsLock.Lock()
-	tenant.FetchedObjectsChans = append(tenant.FetchedObjectsChans, ch)
-
-	if len(tenant.FetchedObjectsChans) == 1 {
-		// this unlock is wanted, while fetching the list, allow other channeled requests to be added
-		tenant.FetchedObjectsLock.Unlock()
-
-		objects, err := server.fetchChartsInStorage(log, repo)
+	repo := entry.RepoName
 
-		tenant.FetchedObjectsLock.Lock()
+	// every caller waiting on the same repo shares the result of a single storage listing
+	value, err, _ := entry.tenant.FetchedObjectsGroup.Do(repo, func() (interface{}, error) {
+		ctx, cancel := server.sharedContext(ctx)
+		defer cancel()
+		return server.limitRegeneration(ctx, wait, func() (interface{}, error) {
+			return server.fetchChartsInStorage(ctx, log, repo)
+		})
+	})
+	objects, _ := value.([]cm_storage.Object)
+	ch <- fetchedObjects{objects, err}
 
-		// flush every other consumer that also wanted the index
-		for _, foCh := range tenant.FetchedObjectsChans {
-			foCh <- fetchedObjects{objects, err}
-		}
+	return ch
+}
 
-		tenant.FetchedObjectsChans = nil
-	}
+func (server *MultiTenantServer) regenerateRepositoryIndex(ctx context.Context, log cm_logger.LoggingFn, entry *cacheEntry, diff cm_storage.ObjectSliceDiff, wait bool) <-chan indexRegeneration {
+	ch := make(chan indexRegeneration, 1)
 
-	tenant.FetchedObjectsLock.Unlock()
+	value, err, _ := entry.tenant.RegenerationGroup.Do(entry.RepoName, func() (interface{}, error) {
+		ctx, cancel := server.sharedContext(ctx)
+		defer cancel()
+		return server.limitRegeneration(ctx, wait, func() (interface{}, error) {
+			return server.regenerateRepositoryIndexWorker(ctx, log, entry, diff)
+		})
+	})
+	index, _ := value.(*cm_repo.Index)
+	ch <- indexRegeneration{index, err}
 
 	return ch
 }
 
-func (server *MultiTenantServer) regenerateRepositoryIndex(log cm_logger.LoggingFn, entry *cacheEntry, diff cm_storage.ObjectSliceDiff) <-chan indexRegeneration {
-	ch := make(chan indexRegeneration, 1)
-	tenant := server.Tenants[entry.RepoName]
-
-	tenant.RegenerationLock.Lock()
-	tenant.RegeneratedIndexesChans = append(tenant.RegeneratedIndexesChans, ch)
+// sharedContext returns the context of work shared by the requests coalesced on it, which
+// keeps the values of ctx, such as its span, but outlives the request of ctx up to RequestTimeout
+func (server *MultiTenantServer) sharedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = detachedContext{ctx}
+	if server.Router.RequestTimeout > 0 {
+		return context.WithTimeout(ctx, server.Router.RequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
 
-	if len(tenant.RegeneratedIndexesChans) == 1 {
-		tenant.RegenerationLock.Unlock()
-		index, err := server.regenerateRepositoryIndexWorker(log, entry, diff)
-		tenant.RegenerationLock.Lock()
-		for _, riCh := range tenant.RegeneratedIndexesChans {
-			riCh <- indexRegeneration{index, err}
+// limitRegeneration runs fn in one of the RegenerationLimit slots shared by every tenant, so that
+// storage is not listed and charts parsed for many repos at once, e.g. after the cache is flushed.
+// When all slots are taken, it waits for one if wait is set, or fails with errRegenerationLimited
+func (server *MultiTenantServer) limitRegeneration(ctx context.Context, wait bool, fn func() (interface{}, error)) (interface{}, error) {
+	if server.RegenerationSlots == nil {
+		return fn()
+	}
+	select {
+	case server.RegenerationSlots <- struct{}{}:
+	default:
+		if !wait {
+			return nil, errRegenerationLimited
+		}
+		select {
+		case server.RegenerationSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		tenant.RegeneratedIndexesChans = nil
 	}
-
-	tenant.RegenerationLock.Unlock()
-
-	return ch
+	defer func() { <-server.RegenerationSlots }()
+	return fn()
 }
 
-func (server *MultiTenantServer) regenerateRepositoryIndexWorker(log cm_logger.LoggingFn, entry *cacheEntry, diff cm_storage.ObjectSliceDiff) (*cm_repo.Index, error) {
+func (server *MultiTenantServer) regenerateRepositoryIndexWorker(ctx context.Context, log cm_logger.LoggingFn, entry *cacheEntry, diff cm_storage.ObjectSliceDiff) (*cm_repo.Index, error) {
 	repo := entry.RepoName
+	start := time.Now()
+
+	ctx, span := server.startSpan(ctx, "regenerate index", repo)
+	defer span.End()
+	span.SetAttributes(
+		attribute.Int("chartmuseum.index.added", len(diff.Added)),
+		attribute.Int("chartmuseum.index.updated", len(diff.Updated)),
+		attribute.Int("chartmuseum.index.removed", len(diff.Removed)),
+	)
 
 	log(cm_logger.DebugLevel, "Regenerating index.yaml",
 		"repo", repo,
 	)
-	index := &cm_repo.Index{
-		IndexFile: entry.RepoIndex.IndexFile,
-		RepoName:  repo,
-		Raw:       entry.RepoIndex.Raw,
-		ChartURL:  entry.RepoIndex.ChartURL,
+
+	// Load updated and added objects before taking the lock on the tenant,
+	// so that readers of the current index are not blocked by storage calls
+	updated, err := server.loadIndexObjectsAsync(ctx, log, repo, diff.Updated, "updated")
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	// Parallelize retrieval of added objects to improve speed
+	added, err := server.loadIndexObjectsAsync(ctx, log, repo, diff.Added, "added")
+	if err != nil {
+		recordError(span, err)
+		return nil, err
 	}
 
+	tenant := entry.tenant
+	tenant.RegenerationLock.Lock()
+	defer tenant.RegenerationLock.Unlock()
+
+	index := entry.RepoIndex.Copy()
+
 	for _, object := range diff.Removed {
-		err := server.removeIndexObject(log, repo, index, object)
+		err := server.removeIndexObject(ctx, log, repo, index, object)
 		if err != nil {
 			return nil, err
 		}
+		if tenant.MetadataCache != nil {
+			tenant.MetadataCache.Remove(object.Path)
+		}
 	}
 
-	for _, object := range diff.Updated {
-		err := server.updateIndexObject(log, repo, index, object)
-		if err != nil {
-			return nil, err
-		}
+	for _, chartVersion := range updated {
+		log(cm_logger.DebugLevel, "Updating chart in index",
+			"repo", repo,
+			"name", chartVersion.Name,
+			"version", chartVersion.Version,
+		)
+		index.UpdateEntry(chartVersion)
 	}
 
-	// Parallelize retrieval of added objects to improve speed
-	err := server.addIndexObjectsAsync(log, repo, index, diff.Added)
-	if err != nil {
-		return nil, err
+	for _, chartVersion := range added {
+		log(cm_logger.DebugLevel, "Adding chart to index",
+			"repo", repo,
+			"name", chartVersion.Name,
+			"version", chartVersion.Version,
+		)
+		index.AddEntry(chartVersion)
 	}
 
 	err = index.Regenerate()
 	if err != nil {
+		recordError(span, err)
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("chartmuseum.index.charts", len(index.Entries)))
 
 	log(cm_logger.DebugLevel, "index.yaml regenerated",
 		"repo", repo,
 	)
+	cm_repo.ObserveIndexRegeneration(repo, time.Since(start))
+	server.notify(webhook.IndexRegeneratedEvent, repo, nil, cm_router.RequestIDFromContext(ctx))
 
 	entry.RepoIndex = index
 	err = server.saveCacheEntry(log, entry)
+
+	if tenant.MetadataCache != nil && server.PersistMetadataCache {
+		// Dont wait, save metadata-cache.yaml to storage in the background.
+		// It is not crucial if this does not succeed, we will just log any errors
+		go server.saveMetadataCache(log, repo, tenant.MetadataCache)
+	}
+
 	return index, err
 }
 
-func (server *MultiTenantServer) fetchChartsInStorage(log cm_logger.LoggingFn, repo string) ([]cm_storage.Object, error) {
+func (server *MultiTenantServer) fetchChartsInStorage(ctx context.Context, log cm_logger.LoggingFn, repo string) ([]cm_storage.Object, error) {
 	log(cm_logger.DebugLevel, "Fetching chart list from storage",
 		"repo", repo,
 	)
-	allObjects, err := server.StorageBackend.ListObjects(repo)
+	if paged := server.pagedStorage(ctx); paged != nil {
+		// only chart packages are kept, and objects of nested repos are not listed
+		filteredObjects := []cm_storage.Object{}
+		err := listPages(ctx, paged, repo, func(page *objectsPage) {
+			for _, object := range page.Objects {
+				if object.HasExtension(cm_repo.ChartPackageFileExtension) {
+					filteredObjects = append(filteredObjects, object)
+				}
+			}
+		})
+		return filteredObjects, err
+	}
+	allObjects, err := server.storage(ctx).ListObjects(repo)
 	if err != nil {
 		return []cm_storage.Object{}, err
 	}
@@ -209,8 +316,8 @@ func (server *MultiTenantServer) fetchChartsInStorage(log cm_logger.LoggingFn, r
 	return filteredObjects, nil
 }
 
-func (server *MultiTenantServer) removeIndexObject(log cm_logger.LoggingFn, repo string, index *cm_repo.Index, object cm_storage.Object) error {
-	chartVersion, err := server.getObjectChartVersion(repo, object, false)
+func (server *MultiTenantServer) removeIndexObject(ctx context.Context, log cm_logger.LoggingFn, repo string, index *cm_repo.Index, object cm_storage.Object) error {
+	chartVersion, err := server.getObjectChartVersion(ctx, repo, object, false)
 	if err != nil {
 		return server.checkInvalidChartPackageError(log, repo, object, err, "removed")
 	}
@@ -223,24 +330,10 @@ func (server *MultiTenantServer) removeIndexObject(log cm_logger.LoggingFn, repo
 	return nil
 }
 
-func (server *MultiTenantServer) updateIndexObject(log cm_logger.LoggingFn, repo string, index *cm_repo.Index, object cm_storage.Object) error {
-	chartVersion, err := server.getObjectChartVersion(repo, object, true)
-	if err != nil {
-		return server.checkInvalidChartPackageError(log, repo, object, err, "updated")
-	}
-	log(cm_logger.DebugLevel, "Updating chart in index",
-		"repo", repo,
-		"name", chartVersion.Name,
-		"version", chartVersion.Version,
-	)
-	index.UpdateEntry(chartVersion)
-	return nil
-}
-
-func (server *MultiTenantServer) addIndexObjectsAsync(log cm_logger.LoggingFn, repo string, index *cm_repo.Index, objects []cm_storage.Object) error {
+func (server *MultiTenantServer) loadIndexObjectsAsync(ctx context.Context, log cm_logger.LoggingFn, repo string, objects []cm_storage.Object, action string) ([]*helm_repo.ChartVersion, error) {
 	numObjects := len(objects)
 	if numObjects == 0 {
-		return nil
+		return nil, nil
 	}
 
 	log(cm_logger.DebugLevel, "Loading charts packages from storage (this could take awhile)",
@@ -253,10 +346,12 @@ func (server *MultiTenantServer) addIndexObjectsAsync(log cm_logger.LoggingFn, r
 		err error
 	}
 
-	cvChan := make(chan cvResult)
+	// buffered so that workers never block once we stop reading on error
+	cvChan := make(chan cvResult, numObjects)
 
-	// Provide a mechanism to short-circuit object downloads in case of error
-	ctx, cancel := context.WithCancel(context.Background())
+	// Provide a mechanism to short-circuit object downloads in case of error,
+	// apart from ctx which may be the one of a request served meanwhile
+	loadCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	for _, object := range objects {
@@ -269,12 +364,12 @@ func (server *MultiTenantServer) addIndexObjectsAsync(log cm_logger.LoggingFn, r
 				defer func() { <-server.Limiter }()
 			}
 			select {
-			case <-ctx.Done():
+			case <-loadCtx.Done():
 				return
 			default:
-				chartVersion, err := server.getObjectChartVersion(repo, o, true)
+				chartVersion, err := server.getObjectChartVersion(ctx, repo, o, true)
 				if err != nil {
-					err = server.checkInvalidChartPackageError(log, repo, o, err, "added")
+					err = server.checkInvalidChartPackageError(log, repo, o, err, action)
 					if err != nil {
 						cancel()
 					}
@@ -284,37 +379,48 @@ func (server *MultiTenantServer) addIndexObjectsAsync(log cm_logger.LoggingFn, r
 		}(object)
 	}
 
+	var chartVersions []*helm_repo.ChartVersion
 	for validCount := 0; validCount < numObjects; validCount++ {
 		cvRes := <-cvChan
 		if cvRes.err != nil {
-			return cvRes.err
+			return nil, cvRes.err
 		}
 		if cvRes.cv == nil {
 			continue
 		}
-		log(cm_logger.DebugLevel, "Adding chart to index",
-			"repo", repo,
-			"name", cvRes.cv.Name,
-			"version", cvRes.cv.Version,
-		)
-		index.AddEntry(cvRes.cv)
+		chartVersions = append(chartVersions, cvRes.cv)
 	}
 
-	return nil
+	return chartVersions, nil
 }
 
-func (server *MultiTenantServer) getObjectChartVersion(repo string, object cm_storage.Object, load bool) (*helm_repo.ChartVersion, error) {
+func (server *MultiTenantServer) getObjectChartVersion(ctx context.Context, repo string, object cm_storage.Object, load bool) (*helm_repo.ChartVersion, error) {
 	op := object.Path
 	if load {
 		var err error
 		objectPath := pathutil.Join(repo, op)
-		object, err = server.StorageBackend.GetObject(objectPath)
+		object, err = server.storage(ctx).GetObject(objectPath)
 		if err != nil {
 			return nil, err
 		}
 		if len(object.Content) == 0 {
 			return nil, cm_repo.ErrorInvalidChartPackage
 		}
+		var chartVersion *helm_repo.ChartVersion
+		if tenant := server.getTenant(repo); tenant != nil && tenant.MetadataCache != nil {
+			chartVersion, err = tenant.MetadataCache.ChartVersionFromStorageObject(object)
+		} else {
+			chartVersion, err = cm_repo.ChartVersionFromStorageObject(object)
+		}
+		if err != nil || !server.IndexAnnotations {
+			return chartVersion, err
+		}
+		// annotations set with the api are in the index along with those of Chart.yaml
+		annotations, err := server.readAnnotations([]string{repo}, chartVersion.Name, chartVersion.Version)
+		if err != nil {
+			return nil, err
+		}
+		return cm_repo.ChartVersionWithAnnotations(chartVersion, annotations), nil
 	}
 	return cm_repo.ChartVersionFromStorageObject(object)
 }
@@ -331,67 +437,118 @@ func (server *MultiTenantServer) checkInvalidChartPackageError(log cm_logger.Log
 	return err
 }
 
-func (server *MultiTenantServer) initCacheEntry(log cm_logger.LoggingFn, repo string) (*cacheEntry, error) {
-	var entry *cacheEntry
-	var content []byte
-	var err error
+func (server *MultiTenantServer) initCacheEntry(ctx context.Context, log cm_logger.LoggingFn, repo string) (*cacheEntry, error) {
+	// fast path: tenant already initialized and its entry held in memory
+	server.TenantCacheKeyLock.RLock()
+	if tenant, ok := server.Tenants[repo]; ok && server.ExternalCacheStore == nil {
+		if entry, ok := server.InternalCacheStore[repo]; ok {
+			touchTenant(tenant)
+			server.TenantCacheKeyLock.RUnlock()
+			log(cm_logger.DebugLevel, "Entry found in cache store",
+				"repo", repo,
+			)
+			observeCacheLookup(repo, true)
+			return entry, nil
+		}
+	}
+	server.TenantCacheKeyLock.RUnlock()
 
-	server.TenantCacheKeyLock.Lock()
-	defer server.TenantCacheKeyLock.Unlock()
+	// slow path: concurrent calls for the same tenant share one initialization, while
+	// other tenants initialize independently, as storage is accessed with no server lock held
+	value, err, _ := server.TenantInitGroup.Do(repo, func() (interface{}, error) {
+		return server.initCacheEntryWorker(ctx, log, repo)
+	})
+	entry, _ := value.(*cacheEntry)
+	return entry, err
+}
 
-	if _, ok := server.Tenants[repo]; !ok {
-		server.Tenants[repo] = &tenantInternals{
-			FetchedObjectsLock: &sync.Mutex{},
-			RegenerationLock:   &sync.Mutex{},
+func (server *MultiTenantServer) initCacheEntryWorker(ctx context.Context, log cm_logger.LoggingFn, repo string) (*cacheEntry, error) {
+	var entry *cacheEntry
+
+	ctx, span := server.startSpan(ctx, "init tenant", repo)
+	defer span.End()
+
+	tenant := server.getTenant(repo)
+	if tenant == nil {
+		tenant = &tenantInternals{
+			FetchedObjectsGroup: &singleflight.Group{},
+			RegenerationGroup:   &singleflight.Group{},
+			RegenerationLock:    &sync.RWMutex{},
+			RefreshLock:         &sync.Mutex{},
+			LastAccessed:        new(int64),
+		}
+		if server.UseMetadataCache {
+			tenant.MetadataCache = server.newMetadataCache(ctx, log, repo)
 		}
+		touchTenant(tenant)
+		server.TenantCacheKeyLock.Lock()
+		server.Tenants[repo] = tenant
+		server.evictCacheEntries(log, time.Now())
+		server.TenantCacheKeyLock.Unlock()
 	}
+	touchTenant(tenant)
 
 	if server.ExternalCacheStore == nil {
-		var ok bool
-		entry, ok = server.InternalCacheStore[repo]
-		if !ok {
-			repoIndex := server.newRepositoryIndex(log, repo)
-			entry = &cacheEntry{
-				RepoName:  repo,
-				RepoIndex: repoIndex,
-			}
-			server.InternalCacheStore[repo] = entry
-		} else {
+		server.TenantCacheKeyLock.RLock()
+		cached, ok := server.InternalCacheStore[repo]
+		server.TenantCacheKeyLock.RUnlock()
+		if ok {
 			log(cm_logger.DebugLevel, "Entry found in cache store",
 				"repo", repo,
 			)
+			observeCacheLookup(repo, true)
+			return cached, nil
 		}
-	} else {
-		content, err = server.ExternalCacheStore.Get(repo)
-		if err != nil {
-			repoIndex := server.newRepositoryIndex(log, repo)
-			entry = &cacheEntry{
-				RepoName:  repo,
-				RepoIndex: repoIndex,
-			}
-			content, err = json.Marshal(entry)
-			if err != nil {
-				return nil, err
-			}
-			err := server.ExternalCacheStore.Set(repo, content)
-			if err != nil {
-				log(cm_logger.ErrorLevel, CouldNotSaveEntryErrorMessage,
-					"error", err.Error(),
-					"repo", repo,
-				)
-			}
-			return entry, nil
-		}
+		observeCacheLookup(repo, false)
 
-		log(cm_logger.DebugLevel, "Entry found in cache store",
-			"repo", repo,
-		)
+		repoIndex := server.newRepositoryIndex(ctx, log, repo)
+		entry = &cacheEntry{
+			RepoName:  repo,
+			RepoIndex: repoIndex,
+			tenant:    tenant,
+		}
+		server.TenantCacheKeyLock.Lock()
+		if server.Tenants[repo] == tenant {
+			server.InternalCacheStore[repo] = entry
+			server.observeCacheEntries()
+		}
+		server.TenantCacheKeyLock.Unlock()
+		return entry, nil
+	}
 
-		err = json.Unmarshal(content, &entry)
+	content, err := server.ExternalCacheStore.Get(repo)
+	if err != nil {
+		observeCacheLookup(repo, false)
+		repoIndex := server.newRepositoryIndex(ctx, log, repo)
+		entry = &cacheEntry{
+			RepoName:  repo,
+			RepoIndex: repoIndex,
+			tenant:    tenant,
+		}
+		content, err = json.Marshal(entry)
 		if err != nil {
 			return nil, err
 		}
+		err := server.ExternalCacheStore.Set(repo, content)
+		if err != nil {
+			log(cm_logger.ErrorLevel, CouldNotSaveEntryErrorMessage,
+				"error", err.Error(),
+				"repo", repo,
+			)
+		}
+		return entry, nil
+	}
+
+	log(cm_logger.DebugLevel, "Entry found in cache store",
+		"repo", repo,
+	)
+	observeCacheLookup(repo, true)
+
+	err = json.Unmarshal(content, &entry)
+	if err != nil {
+		return nil, err
 	}
+	entry.tenant = tenant
 
 	return entry, nil
 }
@@ -399,10 +556,19 @@ func (server *MultiTenantServer) initCacheEntry(log cm_logger.LoggingFn, repo st
 func (server *MultiTenantServer) saveCacheEntry(log cm_logger.LoggingFn, entry *cacheEntry) error {
 	repo := entry.RepoName
 	if server.ExternalCacheStore == nil {
-		server.InternalCacheStore[repo] = entry
-		log(cm_logger.DebugLevel, EntrySavedMessage,
-			"repo", repo,
-		)
+		server.TenantCacheKeyLock.Lock()
+		// entries of tenants evicted meanwhile are not brought back, their repo being looked up again
+		evicted := server.Tenants[repo] != entry.tenant
+		if !evicted {
+			server.InternalCacheStore[repo] = entry
+			server.observeCacheEntries()
+		}
+		server.TenantCacheKeyLock.Unlock()
+		if !evicted {
+			log(cm_logger.DebugLevel, EntrySavedMessage,
+				"repo", repo,
+			)
+		}
 	} else {
 		content, err := json.Marshal(entry)
 		if err != nil {
@@ -423,7 +589,21 @@ func (server *MultiTenantServer) saveCacheEntry(log cm_logger.LoggingFn, entry *
 	return nil
 }
 
-func (server *MultiTenantServer) newRepositoryIndex(log cm_logger.LoggingFn, repo string) *cm_repo.Index {
+// getTenant returns the internals of a tenant initialized by initCacheEntry
+func (server *MultiTenantServer) getTenant(repo string) *tenantInternals {
+	server.TenantCacheKeyLock.RLock()
+	defer server.TenantCacheKeyLock.RUnlock()
+	return server.Tenants[repo]
+}
+
+// getRepoIndex returns the index currently held by a cache entry
+func (server *MultiTenantServer) getRepoIndex(entry *cacheEntry) *cm_repo.Index {
+	entry.tenant.RegenerationLock.RLock()
+	defer entry.tenant.RegenerationLock.RUnlock()
+	return entry.RepoIndex
+}
+
+func (server *MultiTenantServer) newRepositoryIndex(ctx context.Context, log cm_logger.LoggingFn, repo string) *cm_repo.Index {
 	var chartURL string
 	if server.ChartURL != "" {
 		chartURL = server.ChartURL
@@ -441,7 +621,7 @@ func (server *MultiTenantServer) newRepositoryIndex(log cm_logger.LoggingFn, rep
 	}
 
 	objectPath := pathutil.Join(repo, cm_repo.StatefileFilename)
-	object, err := server.StorageBackend.GetObject(objectPath)
+	object, err := server.storage(ctx).GetObject(objectPath)
 	if err != nil {
 		return cm_repo.NewIndex(chartURL, repo, serverInfo)
 	}
@@ -468,6 +648,50 @@ func (server *MultiTenantServer) newRepositoryIndex(log cm_logger.LoggingFn, rep
 	}
 }
 
+func (server *MultiTenantServer) newMetadataCache(ctx context.Context, log cm_logger.LoggingFn, repo string) *cm_repo.MetadataCache {
+	if !server.PersistMetadataCache {
+		return cm_repo.NewMetadataCache()
+	}
+
+	objectPath := pathutil.Join(repo, cm_repo.MetadataCacheFilename)
+	object, err := server.storage(ctx).GetObject(objectPath)
+	if err != nil {
+		return cm_repo.NewMetadataCache()
+	}
+
+	metadataCache, err := cm_repo.MetadataCacheFromContent(object.Content)
+	if err != nil {
+		log(cm_logger.WarnLevel, "metadata-cache.yaml found but could not be parsed",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return cm_repo.NewMetadataCache()
+	}
+
+	log(cm_logger.DebugLevel, "metadata-cache.yaml loaded",
+		"repo", repo,
+	)
+
+	return metadataCache
+}
+
+func (server *MultiTenantServer) saveMetadataCache(log cm_logger.LoggingFn, repo string, metadataCache *cm_repo.MetadataCache) {
+	content, err := metadataCache.Content()
+	if err == nil {
+		err = server.StorageBackend.PutObject(pathutil.Join(repo, cm_repo.MetadataCacheFilename), content)
+	}
+	if err != nil {
+		log(cm_logger.WarnLevel, "Error saving metadata-cache.yaml",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return
+	}
+	log(cm_logger.DebugLevel, "metadata-cache.yaml saved in storage",
+		"repo", repo,
+	)
+}
+
 func (server *MultiTenantServer) initCacheTimer() {
 	if server.CacheInterval > 0 {
 		// delta update the cache every X duration
@@ -482,91 +706,150 @@ func (server *MultiTenantServer) initCacheTimer() {
 }
 
 func (server *MultiTenantServer) emitEvent(c *gin.Context, repo string, operationType operationType, chart *helm_repo.ChartVersion) {
+	atomic.AddInt64(server.PendingWrites, 1)
+	var user string
+	if c != nil && c.Request != nil {
+		user = cm_router.RequestUser(c.Request)
+	}
 	server.EventChan <- event{
 		Context:      c,
 		RepoName:     repo,
 		OpType:       operationType,
 		ChartVersion: chart,
+		RequestID:    cm_router.RequestIDFromContext(requestContext(c)),
+		User:         user,
+		SpanContext:  trace.SpanContextFromContext(requestContext(c)),
 	}
 }
 
 func (server *MultiTenantServer) startEventListener() {
 	server.Router.Logger.Debug("Starting internal event listener")
 	for {
-
 		e := <-server.EventChan
-		log := server.Logger.ContextLoggingFn(e.Context)
-
-		repo := e.RepoName
-		log(cm_logger.DebugLevel, "Event received", zap.Any("event", e))
+		server.handleEvent(e)
+		atomic.AddInt64(server.PendingWrites, -1)
+	}
+}
 
-		entry, err := server.initCacheEntry(log, repo)
-		if err != nil {
-			log(cm_logger.ErrorLevel, "Error initializing cache entry", zap.Error(err), zap.String("repo", repo))
-			continue
-		}
-		index := entry.RepoIndex
+func (server *MultiTenantServer) handleEvent(e event) {
+	log := server.Logger.ContextLoggingFn(e.Context)
 
-		tenant, ok := server.Tenants[e.RepoName]
-		if !ok {
-			log(cm_logger.ErrorLevel, "Error find tenants repo name", zap.Error(err), zap.String("repo", repo))
-			continue
-		}
-		tenant.RegenerationLock.Lock()
+	repo := e.RepoName
+	log(cm_logger.DebugLevel, "Event received", zap.Any("event", e))
 
-		if e.ChartVersion == nil {
-			log(cm_logger.WarnLevel, "Event does not contain chart version", zap.String("repo", repo),
-				"operation_type", e.OpType)
-			tenant.RegenerationLock.Unlock()
-			continue
-		}
+	// a child of the span of the request writing the chart, if traced
+	ctx, span := server.startSpan(trace.ContextWithSpanContext(context.Background(), e.SpanContext), "update index", repo)
+	defer span.End()
+	if e.ChartVersion != nil {
+		span.SetAttributes(
+			attribute.String("chartmuseum.chart.name", e.ChartVersion.Name),
+			attribute.String("chartmuseum.chart.version", e.ChartVersion.Version),
+		)
+	}
 
-		switch e.OpType {
-		case updateChart:
-			index.UpdateEntry(e.ChartVersion)
-		case addChart:
-			index.AddEntry(e.ChartVersion)
-		case deleteChart:
-			index.RemoveEntry(e.ChartVersion)
-		default:
-			log(cm_logger.ErrorLevel, "Invalid operation type", zap.String("repo", repo),
-				"operation_type", e.OpType)
-			tenant.RegenerationLock.Unlock()
-			continue
-		}
+	entry, err := server.initCacheEntry(ctx, log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error initializing cache entry", zap.Error(err), zap.String("repo", repo))
+		return
+	}
+	tenant := entry.tenant
 
-		err = index.Regenerate()
-		if err != nil {
-			log(cm_logger.ErrorLevel, "Error regenerating index", zap.Error(err), zap.String("repo", repo))
-			tenant.RegenerationLock.Unlock()
-			continue
-		}
-		entry.RepoIndex = index
+	if e.ChartVersion == nil {
+		log(cm_logger.WarnLevel, "Event does not contain chart version", zap.String("repo", repo),
+			"operation_type", e.OpType)
+		return
+	}
 
-		err = server.saveCacheEntry(log, entry)
-		if err != nil {
-			log(cm_logger.ErrorLevel, "Error saving cache entry", zap.Error(err), zap.String("repo", repo))
-			tenant.RegenerationLock.Unlock()
-			continue
-		}
+	start := time.Now()
+	tenant.RegenerationLock.Lock()
+	index := entry.RepoIndex.Copy()
+
+	switch e.OpType {
+	case updateChart:
+		index.UpdateEntry(e.ChartVersion)
+	case addChart:
+		index.AddEntry(e.ChartVersion)
+	case deleteChart:
+		index.RemoveEntry(e.ChartVersion)
+	default:
+		log(cm_logger.ErrorLevel, "Invalid operation type", zap.String("repo", repo),
+			"operation_type", e.OpType)
+		tenant.RegenerationLock.Unlock()
+		return
+	}
 
-		if server.UseStatefiles {
-			// Dont wait, save index-cache.yaml to storage in the background.
-			// It is not crucial if this does not succeed, we will just log any errors
-			go server.saveStatefile(log, e.RepoName, entry.RepoIndex.Raw)
-		}
+	err = index.Regenerate()
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error regenerating index", zap.Error(err), zap.String("repo", repo))
+		tenant.RegenerationLock.Unlock()
+		return
+	}
+	cm_repo.ObserveIndexRegeneration(repo, time.Since(start))
+	server.journalChange(log, repo, tenant, entry.RepoIndex, e.OpType, e.ChartVersion)
+	entry.RepoIndex = index
 
+	err = server.saveCacheEntry(log, entry)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error saving cache entry", zap.Error(err), zap.String("repo", repo))
 		tenant.RegenerationLock.Unlock()
-		log(cm_logger.DebugLevel, "Event handled successfully", zap.Any("event", e))
+		return
+	}
+
+	if server.UseStatefiles {
+		// Dont wait, save index-cache.yaml to storage in the background.
+		// It is not crucial if this does not succeed, we will just log any errors
+		atomic.AddInt64(server.PendingWrites, 1)
+		go func() {
+			server.saveStatefile(log, e.RepoName, index.Raw)
+			atomic.AddInt64(server.PendingWrites, -1)
+		}()
+	}
+
+	tenant.RegenerationLock.Unlock()
+	log(cm_logger.DebugLevel, "Event handled successfully", zap.Any("event", e))
+	server.recordChartHistory(log, e)
+
+	eventType := webhook.ChartUploadedEvent
+	if e.OpType == deleteChart {
+		eventType = webhook.ChartDeletedEvent
+	}
+	server.notify(eventType, repo, &webhook.Chart{
+		Name:    e.ChartVersion.Name,
+		Version: e.ChartVersion.Version,
+	}, e.RequestID)
+	server.notify(webhook.IndexRegeneratedEvent, repo, nil, e.RequestID)
+}
+
+// drainWrites waits on shutdown for the events already emitted to be applied to the indexes,
+// and for the statefiles being saved
+func (server *MultiTenantServer) drainWrites(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(server.PendingWrites) > 0 {
+		select {
+		case <-ctx.Done():
+			server.Logger.Warnw("Index writes still pending at the end of the shutdown timeout",
+				"writes", atomic.LoadInt64(server.PendingWrites),
+			)
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
 func (server *MultiTenantServer) rebuildIndex() {
-	if len(server.Tenants) == 0 {
+	server.TenantCacheKeyLock.RLock()
+	repos := make([]string, 0, len(server.Tenants))
+	for repo := range server.Tenants {
+		repos = append(repos, repo)
+	}
+	server.TenantCacheKeyLock.RUnlock()
+
+	if len(repos) == 0 {
 		return
 	}
 	server.Logger.Info("Rebuilding index for all tenants in cache")
-	for repo, _ := range server.Tenants {
+	for _, repo := range repos {
 		go server.rebuildIndexForTenant(repo)
 	}
 }
@@ -574,56 +857,141 @@ func (server *MultiTenantServer) rebuildIndex() {
 func (server *MultiTenantServer) rebuildIndexForTenant(repo string) {
 	log := server.Logger.ContextLoggingFn(&gin.Context{})
 	log(cm_logger.InfoLevel, "Rebuilding index for tenant", zap.String("repo", repo))
-	entry, err := server.initCacheEntry(log, repo)
+	ctx, span := server.startSpan(context.Background(), "rebuild index", repo)
+	defer span.End()
+	entry, err := server.initCacheEntry(ctx, log, repo)
 	if err != nil {
 		errStr := err.Error()
 		log(cm_logger.ErrorLevel, errStr,
 			"repo", repo,
 		)
+		recordError(span, err)
 		return
 	}
-	server.refreshCacheEntry(log, repo, entry)
+	server.refreshCacheEntry(ctx, log, repo, entry)
 }
 
-func (server *MultiTenantServer) refreshCacheEntry(log cm_logger.LoggingFn, repo string, entry *cacheEntry) {
-	fo := <-server.getChartList(log, repo)
+// refreshCacheEntry brings the cached index of a repo up to date with storage, returning the resulting index
+func (server *MultiTenantServer) refreshCacheEntry(ctx context.Context, log cm_logger.LoggingFn, repo string, entry *cacheEntry) (*cm_repo.Index, error) {
+	ctx, span := server.startSpan(ctx, "refresh index", repo)
+	defer span.End()
 
+	// with an index to serve, requests beyond the regeneration limit are served it as is
+	index := server.getRepoIndex(entry)
+	wait := len(index.Entries) == 0
+
+	fo := <-server.getChartList(ctx, log, entry, wait)
+
+	if fo.err == errRegenerationLimited {
+		log(cm_logger.DebugLevel, "Regeneration limit reached, serving cached index",
+			"repo", repo,
+		)
+		return index, nil
+	}
 	if fo.err != nil {
 		errStr := fo.err.Error()
 		log(cm_logger.ErrorLevel, errStr,
 			"repo", repo,
 		)
-		return
+		recordError(span, fo.err)
+		return nil, fo.err
 	}
 
 	objects := server.getRepoObjectSlice(entry)
 	diff := cm_storage.GetObjectSliceDiff(objects, fo.objects, server.TimestampTolerance)
+	span.SetAttributes(attribute.Bool("chartmuseum.index.changed", diff.Change))
 
 	// return fast if no changes
 	if !diff.Change {
 		log(cm_logger.DebugLevel, "No change detected between cache and storage",
 			"repo", repo,
 		)
-		return
+		server.markRefreshed(entry)
+		return server.getRepoIndex(entry), nil
 	}
 
 	log(cm_logger.DebugLevel, "Change detected between cache and storage",
 		"repo", repo,
 	)
 
-	ir := <-server.regenerateRepositoryIndex(log, entry, diff)
+	ir := <-server.regenerateRepositoryIndex(ctx, log, entry, diff, wait)
+	if ir.err == errRegenerationLimited {
+		log(cm_logger.DebugLevel, "Regeneration limit reached, serving cached index",
+			"repo", repo,
+		)
+		return index, nil
+	}
 	if ir.err != nil {
 		errStr := ir.err.Error()
 		log(cm_logger.ErrorLevel, errStr,
 			"repo", repo,
 		)
-		return
+		recordError(span, ir.err)
+		return ir.index, ir.err
 	}
-	entry.RepoIndex = ir.index
+	server.markRefreshed(entry)
 
 	if server.UseStatefiles {
 		// Dont wait, save index-cache.yaml to storage in the background.
 		// It is not crucial if this does not succeed, we will just log any errors
 		go server.saveStatefile(log, repo, ir.index.Raw)
 	}
+
+	return ir.index, nil
+}
+
+// markRefreshed records that the cached index of a repo was just checked against storage
+func (server *MultiTenantServer) markRefreshed(entry *cacheEntry) {
+	tenant := entry.tenant
+	tenant.RefreshLock.Lock()
+	tenant.LastRefreshed = time.Now()
+	tenant.RefreshLock.Unlock()
+}
+
+// refreshed tells whether the cached index of a repo was checked against storage since its tenant
+// was initialized
+func (server *MultiTenantServer) refreshed(entry *cacheEntry) bool {
+	entry.tenant.RefreshLock.Lock()
+	defer entry.tenant.RefreshLock.Unlock()
+	return !entry.tenant.LastRefreshed.IsZero()
+}
+
+// revalidateCacheEntry serves the cached index of a repo while refreshing it in the background.
+// Requests only wait for the refresh when nothing is cached yet, or the cached index is older than MaxStaleness
+func (server *MultiTenantServer) revalidateCacheEntry(ctx context.Context, log cm_logger.LoggingFn, repo string, entry *cacheEntry, index *cm_repo.Index) (*cm_repo.Index, error) {
+	tenant := entry.tenant
+
+	tenant.RefreshLock.Lock()
+	lastRefreshed := tenant.LastRefreshed
+	if lastRefreshed.IsZero() {
+		// not refreshed since startup, e.g. index loaded from index-cache.yaml
+		lastRefreshed = index.Generated
+	}
+	empty := len(index.Entries) == 0 && tenant.LastRefreshed.IsZero()
+	stale := server.MaxStaleness > 0 && time.Since(lastRefreshed) > server.MaxStaleness
+	if empty || stale {
+		tenant.RefreshLock.Unlock()
+		log(cm_logger.DebugLevel, "Cached index too stale to serve, waiting for refresh",
+			"repo", repo,
+		)
+		return server.refreshCacheEntry(ctx, log, repo, entry)
+	}
+	refreshing := tenant.Refreshing
+	tenant.Refreshing = true
+	tenant.RefreshLock.Unlock()
+
+	if !refreshing {
+		go func() {
+			// the request context is gone by the time this completes
+			log := server.Logger.ContextLoggingFn(&gin.Context{})
+			ctx, span := server.startSpan(context.Background(), "revalidate index", repo)
+			defer span.End()
+			server.refreshCacheEntry(ctx, log, repo, entry)
+			tenant.RefreshLock.Lock()
+			tenant.Refreshing = false
+			tenant.RefreshLock.Unlock()
+		}()
+	}
+
+	return index, nil
 }
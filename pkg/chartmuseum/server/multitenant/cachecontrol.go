@@ -0,0 +1,42 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var maxAgeRegex = regexp.MustCompile(`(?:^|[\s,])max-age=(\d+)`)
+
+// setCacheControl sets the Cache-Control header of a response, e.g. --cache-control-index, along
+// with the Expires header of its max-age for HTTP/1.0 caches
+func setCacheControl(c *gin.Context, cacheControl string) {
+	if cacheControl == "" {
+		return
+	}
+	c.Header("Cache-Control", cacheControl)
+	if matches := maxAgeRegex.FindStringSubmatch(cacheControl); matches != nil {
+		if maxAge, err := strconv.Atoi(matches[1]); err == nil {
+			c.Header("Expires", time.Now().Add(time.Duration(maxAge)*time.Second).UTC().Format(http.TimeFormat))
+		}
+	}
+}
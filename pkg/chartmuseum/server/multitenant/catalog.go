@@ -0,0 +1,142 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"net/http"
+	pathutil "path"
+	"sort"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// catalogEntry summarizes a tenant in GET /api/catalog
+	catalogEntry struct {
+		Name           string     `json:"name"`
+		Charts         int        `json:"charts"`
+		ChartVersions  int        `json:"chartVersions"`
+		StorageObjects int        `json:"storageObjects"`
+		StorageBytes   *int64     `json:"storageBytes,omitempty"`
+		LastUpdated    *time.Time `json:"lastUpdated,omitempty"`
+	}
+)
+
+func (server *MultiTenantServer) getCatalogRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	_, usage := c.GetQuery("usage")
+	catalog, err := server.getCatalog(requestContext(c), log, usage)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"tenants": catalog})
+}
+
+// getCatalog summarizes the tenants found in storage, held in cache or set in the tenant config.
+// Sizes of storage objects are only summed with usage, as every object has to be read
+func (server *MultiTenantServer) getCatalog(ctx context.Context, log cm_logger.LoggingFn, usage bool) ([]catalogEntry, *HTTPError) {
+	repos, reposErr := server.catalogRepos()
+	if reposErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, reposErr.Error()}
+	}
+	catalog := []catalogEntry{}
+	for _, repo := range repos {
+		index, err := server.getIndexFile(ctx, log, repo)
+		if err != nil {
+			return nil, err
+		}
+		entry := catalogEntry{
+			Name:   repo,
+			Charts: len(index.Entries),
+		}
+		for _, chartVersions := range index.Entries {
+			entry.ChartVersions += len(chartVersions)
+			for _, chartVersion := range chartVersions {
+				if entry.LastUpdated == nil || chartVersion.Created.After(*entry.LastUpdated) {
+					created := chartVersion.Created
+					entry.LastUpdated = &created
+				}
+			}
+		}
+
+		objects, listErr := server.storage(ctx).ListObjects(repo)
+		if listErr != nil {
+			return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, listErr.Error()}
+		}
+		var storageBytes int64
+		for _, object := range objects {
+			// attachments, such as provenance files, are named after their chart package
+			if !object.HasExtension(cm_repo.ChartPackageFileExtension) && !strings.Contains(object.Path, "."+cm_repo.ChartPackageFileExtension+".") {
+				continue
+			}
+			entry.StorageObjects++
+			if usage {
+				fullObject, getErr := server.storage(ctx).GetObject(pathutil.Join(repo, object.Path))
+				if getErr != nil {
+					return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, getErr.Error()}
+				}
+				storageBytes += int64(len(fullObject.Content))
+			}
+		}
+		if usage {
+			entry.StorageBytes = &storageBytes
+		}
+		catalog = append(catalog, entry)
+	}
+	return catalog, nil
+}
+
+// catalogRepos returns the sorted repos of tenants found in storage, held in cache or set in the
+// tenant config, virtual repos included. Repos in storage are listed even before their index is loaded
+func (server *MultiTenantServer) catalogRepos() ([]string, error) {
+	discovered, err := server.discoverTenants()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, repo := range discovered {
+		seen[repo] = true
+	}
+	server.TenantCacheKeyLock.RLock()
+	for repo := range server.Tenants {
+		seen[repo] = true
+	}
+	server.TenantCacheKeyLock.RUnlock()
+	if server.TenantConfig != nil {
+		for _, prefix := range server.TenantConfig.Prefixes() {
+			// prefixes shorter than the depth cover several repos, and are not repos themselves
+			if server.Router.DepthDynamic || len(strings.Split(prefix, "/")) == server.Router.Depth {
+				seen[prefix] = true
+			}
+		}
+	}
+
+	repos := make([]string, 0, len(seen))
+	for repo := range seen {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
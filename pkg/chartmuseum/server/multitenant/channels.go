@@ -0,0 +1,193 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// channelResource is how the version of a channel is sent to the channels api
+	channelResource struct {
+		Version string `json:"version"`
+	}
+)
+
+func (server *MultiTenantServer) getChartChannelsRequestHandler(c *gin.Context) {
+	channels, err := server.readChartChannels(server.attachmentRepos(c.Param("repo")), c.Param("name"))
+	if err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	c.JSON(200, channels)
+}
+
+// getChartChannelRequestHandler describes the chart version a channel of a chart points to, as
+// /api/charts/<name>/<version> does, so that deploy tooling may follow the channel
+func (server *MultiTenantServer) getChartChannelRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version, err := server.resolveChartChannel(repo, name, c.Param("channel"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, name, version)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	chartVersionWithAttachments, err := server.withAttachments(repo, chartVersion)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(200, chartVersionWithAttachments[0])
+}
+
+// putChartChannelRequestHandler points a channel of a chart to one of its versions, e.g. with
+// {"version": "1.2.0"}, and returns the channels of the chart
+func (server *MultiTenantServer) putChartChannelRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	channel := c.Param("channel")
+	if err := cm_repo.ValidateChannelName(channel); err != nil {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	resource := channelResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil || resource.Version == "" {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, `invalid channel, must be {"version": "<version>"}`)
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	// the version must be in the repo, "latest" being resolved once and for all
+	chartVersion, httpErr := server.getChartVersion(requestContext(c), log, repo, name, resource.Version)
+	if httpErr != nil {
+		writeError(c, httpErr)
+		return
+	}
+
+	server.ChannelsLock.Lock()
+	defer server.ChannelsLock.Unlock()
+	channels, err := server.readChartChannels([]string{repo}, name)
+	if err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	channels[channel] = chartVersion.Version
+	if err := server.writeChartChannels(log, repo, name, channels); err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+	log(cm_logger.InfoLevel, "Chart channel updated",
+		"repo", repo,
+		"name", name,
+		"channel", channel,
+		"version", chartVersion.Version,
+		"user", cm_router.RequestUser(c.Request),
+	)
+	c.JSON(200, channels)
+}
+
+// deleteChartChannelRequestHandler removes a channel of a chart, and returns the channels left
+func (server *MultiTenantServer) deleteChartChannelRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	channel := c.Param("channel")
+	log := server.Logger.ContextLoggingFn(c)
+
+	server.ChannelsLock.Lock()
+	defer server.ChannelsLock.Unlock()
+	if _, err := server.resolveChartChannel(repo, name, channel); err != nil {
+		writeError(c, err)
+		return
+	}
+	channels, err := server.readChartChannels([]string{repo}, name)
+	if err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	delete(channels, channel)
+	if err := server.writeChartChannels(log, repo, name, channels); err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+	log(cm_logger.InfoLevel, "Chart channel deleted",
+		"repo", repo,
+		"name", name,
+		"channel", channel,
+		"user", cm_router.RequestUser(c.Request),
+	)
+	c.JSON(200, channels)
+}
+
+// resolveChartChannel returns the version a channel of a chart points to
+func (server *MultiTenantServer) resolveChartChannel(repo string, name string, channel string) (string, *HTTPError) {
+	if err := cm_repo.ValidateChannelName(channel); err != nil {
+		return "", &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error()}
+	}
+	channels, err := server.readChartChannels(server.attachmentRepos(repo), name)
+	if err != nil {
+		return "", &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error()}
+	}
+	version, ok := channels[channel]
+	if !ok {
+		return "", &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, fmt.Sprintf("channel %s of chart %s not found", channel, name)}
+	}
+	return version, nil
+}
+
+// readChartChannels returns the channels of a chart name from the first of repos having them,
+// empty if none has
+func (server *MultiTenantServer) readChartChannels(repos []string, name string) (map[string]string, error) {
+	filename := cm_repo.ChartChannelsFilenameFromName(name)
+	for _, repo := range repos {
+		object, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+		if err != nil {
+			continue
+		}
+		return cm_repo.ChartChannelsFromContent(object.Content)
+	}
+	return map[string]string{}, nil
+}
+
+func (server *MultiTenantServer) writeChartChannels(log cm_logger.LoggingFn, repo string, name string, channels map[string]string) error {
+	filename := pathutil.Join(repo, cm_repo.ChartChannelsFilenameFromName(name))
+	if len(channels) == 0 {
+		log(cm_logger.DebugLevel, "Deleting chart channels from storage",
+			"channels", filename,
+		)
+		server.StorageBackend.DeleteObject(filename) // ignore error here, may be no channels
+		return nil
+	}
+	content, _ := json.Marshal(channels)
+	log(cm_logger.DebugLevel, "Adding chart channels to storage",
+		"channels", filename,
+	)
+	return server.StorageBackend.PutObject(filename, content)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	checksumHeader = "X-Checksum-SHA256"
+	digestHeader   = "Digest"
+)
+
+var (
+	// digestAlgorithms are the algorithms of RFC 3230 Digest headers verified, others being ignored
+	digestAlgorithms = map[string]func() hash.Hash{
+		"sha-256": sha256.New,
+		"sha-512": sha512.New,
+	}
+)
+
+type (
+	// bodyChecksum is a checksum of a request body sent by the client
+	bodyChecksum struct {
+		header    string
+		algorithm string
+		sum       []byte
+		new       func() hash.Hash
+	}
+)
+
+// verifyChecksum wraps the handler of an upload, so that bodies not matching the checksum sent
+// along with them are rejected before anything is saved, e.g. truncated by a proxy.
+// Uploads without an X-Checksum-SHA256 or a Digest header are not checked
+func (server *MultiTenantServer) verifyChecksum(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checksums, err := requestChecksums(c.Request.Header)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if len(checksums) == 0 {
+			handler(c)
+			return
+		}
+		content, getContentErr := c.GetRawData()
+		if getContentErr != nil {
+			if len(c.Errors) > 0 {
+				return // this is a "request too large"
+			}
+			cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, fmt.Sprintf("%s", getContentErr))
+			return
+		}
+		for _, checksum := range checksums {
+			h := checksum.new()
+			h.Write(content)
+			if !bytes.Equal(h.Sum(nil), checksum.sum) {
+				cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeChecksumMismatch,
+					fmt.Sprintf("%s checksum of the %d bytes received does not match the %s header", checksum.algorithm, len(content), checksum.header))
+				return
+			}
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(content))
+		handler(c)
+	}
+}
+
+// requestChecksums parses the hex sha256 of an X-Checksum-SHA256 header, and the base64
+// checksums of a Digest header such as "SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE="
+func requestChecksums(header http.Header) ([]*bodyChecksum, *HTTPError) {
+	var checksums []*bodyChecksum
+	if value := strings.TrimSpace(header.Get(checksumHeader)); value != "" {
+		sum, err := hex.DecodeString(value)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest,
+				fmt.Sprintf("invalid %s header, must be a hex sha256", checksumHeader)}
+		}
+		checksums = append(checksums, &bodyChecksum{checksumHeader, "sha-256", sum, sha256.New})
+	}
+	for _, value := range header.Values(digestHeader) {
+		for _, instance := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(instance), "=", 2)
+			newHash, ok := digestAlgorithms[strings.ToLower(parts[0])]
+			if !ok || len(parts) != 2 {
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil || len(sum) != newHash().Size() {
+				return nil, &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest,
+					fmt.Sprintf("invalid %s header, the %s digest must be base64", digestHeader, parts[0])}
+			}
+			checksums = append(checksums, &bodyChecksum{digestHeader, strings.ToLower(parts[0]), sum, newHash})
+		}
+	}
+	return checksums, nil
+}
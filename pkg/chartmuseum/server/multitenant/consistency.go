@@ -0,0 +1,209 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// consistencyReport is how the index of a repo held in cache differs from its charts in storage,
+	// returned by /api/admin/consistency
+	consistencyReport struct {
+		Repo       string             `json:"repo"`
+		Consistent bool               `json:"consistent"`
+		Missing    []consistencyEntry `json:"missing"`
+		Extra      []consistencyEntry `json:"extra"`
+		Changed    []consistencyEntry `json:"changed"`
+		Healed     bool               `json:"healed"`
+	}
+
+	// consistencyEntry is a chart package missing from the index, in the index but not in storage,
+	// or modified in storage since it was indexed
+	consistencyEntry struct {
+		Path            string     `json:"path"`
+		Name            string     `json:"name,omitempty"`
+		Version         string     `json:"version,omitempty"`
+		IndexModified   *time.Time `json:"indexModified,omitempty"`
+		StorageModified *time.Time `json:"storageModified,omitempty"`
+	}
+)
+
+func (server *MultiTenantServer) getConsistencyRequestHandler(c *gin.Context) {
+	server.checkConsistency(c, false)
+}
+
+func (server *MultiTenantServer) postConsistencyRequestHandler(c *gin.Context) {
+	server.checkConsistency(c, true)
+}
+
+// checkConsistency compares the index of the repo of ?repo, the root one by default, with a fresh
+// listing of its storage, and with heal set regenerates the index from the differences found
+func (server *MultiTenantServer) checkConsistency(c *gin.Context, heal bool) {
+	log := server.Logger.ContextLoggingFn(c)
+	ctx := requestContext(c)
+	repo := strings.Trim(c.Query("repo"), "/")
+	if repo != "" {
+		if err := server.validateTenantName(repo); err != nil {
+			writeError(c, err)
+			return
+		}
+		if server.virtualMembers(repo) != nil {
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "virtual repos have no storage of their own")
+			return
+		}
+	}
+
+	// the index served is loaded first, so that a repo not requested yet is not reported as empty
+	if _, err := server.getIndexFile(ctx, log, repo); err != nil {
+		writeError(c, err)
+		return
+	}
+	entry, err := server.initCacheEntry(ctx, log, repo)
+	if err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+	objects, err := server.fetchChartsInStorage(ctx, log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error listing charts to check consistency",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+
+	report, diff := server.consistencyDiff(entry, objects)
+	if report.Consistent {
+		c.JSON(200, report)
+		return
+	}
+	log(cm_logger.WarnLevel, "Index inconsistent with storage",
+		"repo", repo,
+		"missing", len(report.Missing),
+		"extra", len(report.Extra),
+		"changed", len(report.Changed),
+	)
+	if heal {
+		if err := server.healIndex(ctx, log, entry, diff); err != nil {
+			cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error())
+			return
+		}
+		report.Healed = true
+	}
+	c.JSON(200, report)
+}
+
+// consistencyDiff returns the report of the differences between the index of a cache entry and the chart packages
+// in storage, and the diff regenerating the index from them. Unlike index refreshes, which only
+// pick up packages modified since they were indexed, packages older than in the index are changed
+// as well, e.g. after storage was restored from a backup
+func (server *MultiTenantServer) consistencyDiff(entry *cacheEntry, objects []cm_storage.Object) (*consistencyReport, cm_storage.ObjectSliceDiff) {
+	report := &consistencyReport{
+		Repo:    entry.RepoName,
+		Missing: []consistencyEntry{},
+		Extra:   []consistencyEntry{},
+		Changed: []consistencyEntry{},
+	}
+	var diff cm_storage.ObjectSliceDiff
+
+	indexed := map[string]cm_storage.Object{}
+	for _, object := range server.getRepoObjectSlice(entry) {
+		indexed[object.Path] = object
+	}
+	stored := map[string]bool{}
+	for _, object := range objects {
+		stored[object.Path] = true
+		storageModified := object.LastModified
+		previous, ok := indexed[object.Path]
+		if !ok {
+			report.Missing = append(report.Missing, consistencyEntry{Path: object.Path, StorageModified: &storageModified})
+			diff.Added = append(diff.Added, object)
+			continue
+		}
+		delta := object.LastModified.Sub(previous.LastModified)
+		if delta < 0 {
+			delta = -delta
+		}
+		if delta > server.TimestampTolerance {
+			indexModified := previous.LastModified
+			report.Changed = append(report.Changed, consistencyEntry{
+				Path:            object.Path,
+				Name:            previous.Meta.Name,
+				Version:         previous.Meta.Version,
+				IndexModified:   &indexModified,
+				StorageModified: &storageModified,
+			})
+			diff.Updated = append(diff.Updated, object)
+		}
+	}
+	for path, object := range indexed {
+		if stored[path] {
+			continue
+		}
+		indexModified := object.LastModified
+		report.Extra = append(report.Extra, consistencyEntry{
+			Path:          path,
+			Name:          object.Meta.Name,
+			Version:       object.Meta.Version,
+			IndexModified: &indexModified,
+		})
+		diff.Removed = append(diff.Removed, object)
+	}
+
+	for _, entries := range [][]consistencyEntry{report.Missing, report.Extra, report.Changed} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+	diff.Change = len(diff.Added)+len(diff.Updated)+len(diff.Removed) > 0
+	report.Consistent = !diff.Change
+	return report, diff
+}
+
+// healIndex regenerates the index of a repo from the differences found with its storage, as refreshes do
+func (server *MultiTenantServer) healIndex(ctx context.Context, log cm_logger.LoggingFn, entry *cacheEntry, diff cm_storage.ObjectSliceDiff) error {
+	repo := entry.RepoName
+	ir := <-server.regenerateRepositoryIndex(ctx, log, entry, diff, true)
+	if ir.err != nil {
+		log(cm_logger.ErrorLevel, "Error healing index",
+			"repo", repo,
+			"error", ir.err.Error(),
+		)
+		return ir.err
+	}
+	server.markRefreshed(entry)
+	if server.UseStatefiles {
+		go server.saveStatefile(log, repo, ir.index.Raw)
+	}
+	log(cm_logger.InfoLevel, "Index healed",
+		"repo", repo,
+		"added", len(diff.Added),
+		"removed", len(diff.Removed),
+		"updated", len(diff.Updated),
+	)
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// maxAbandonedRequests is the number of requests whose storage calls may still run in the background
+// once they are cancelled or time out, beyond which requests are refused until some are done
+const maxAbandonedRequests = 100
+
+type (
+	// detachedContext has the values of its context but is never done, for work outliving a request
+	detachedContext struct {
+		context.Context
+	}
+
+	// contextBackend is implemented by storage backends whose reads stop once their context is done.
+	// The Backend interface of chartmuseum/storage takes no context
+	contextBackend interface {
+		ListObjectsContext(ctx context.Context, prefix string) ([]cm_storage.Object, error)
+		GetObjectContext(ctx context.Context, path string) (cm_storage.Object, error)
+	}
+
+	// cancellableBackend reads from storage until ctx is done. Writes are never cut short, so
+	// that a chart is not left half saved
+	cancellableBackend struct {
+		cm_storage.Backend
+		reads contextBackend
+		ctx   context.Context
+	}
+)
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// withContext returns backend reading from storage until ctx is done, for the backends which can
+func withContext(backend cm_storage.Backend, ctx context.Context) cm_storage.Backend {
+	if ctx.Done() == nil {
+		return backend
+	}
+	if instrumented, ok := backend.(*instrumentedBackend); ok {
+		return &instrumentedBackend{Backend: withContext(instrumented.Backend, ctx)}
+	}
+	if reads, ok := backend.(contextBackend); ok {
+		return &cancellableBackend{Backend: backend, reads: reads, ctx: ctx}
+	}
+	return backend
+}
+
+func (backend *cancellableBackend) ListObjects(prefix string) ([]cm_storage.Object, error) {
+	return backend.reads.ListObjectsContext(backend.ctx, prefix)
+}
+
+func (backend *cancellableBackend) GetObject(path string) (cm_storage.Object, error) {
+	return backend.reads.GetObjectContext(backend.ctx, path)
+}
+
+// awaitRequest runs fn until it returns, or until the request is cancelled by its client or
+// runs past its deadline. Storage calls of fn stop then with backends taking a context, see
+// withContext, while others go on in the background, given a copy of the request context as gin
+// reuses the original once the handler returns. Requests are refused while maxAbandonedRequests
+// are still running in the background, storage being too slow to keep up
+func (server *MultiTenantServer) awaitRequest(c *gin.Context, fn func(c *gin.Context)) *HTTPError {
+	if atomic.LoadInt64(server.AbandonedRequests) >= maxAbandonedRequests {
+		return &HTTPError{http.StatusServiceUnavailable, cm_router.ErrorCodeStorageUnavailable, "too many requests still waiting on storage"}
+	}
+	ctx := c.Request.Context()
+	if ctx.Done() == nil {
+		fn(c)
+		return nil
+	}
+
+	done := make(chan struct{})
+	copied := c.Copy()
+	go func() {
+		defer close(done)
+		fn(copied)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(server.AbandonedRequests, 1)
+		go func() {
+			<-done
+			atomic.AddInt64(server.AbandonedRequests, -1)
+		}()
+		return requestContextError(ctx)
+	}
+}
+
+func requestContextError(ctx context.Context) *HTTPError {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return &HTTPError{http.StatusGatewayTimeout, cm_router.ErrorCodeTimeout, "request timed out"}
+	default:
+		return &HTTPError{http.StatusServiceUnavailable, cm_router.ErrorCodeCancelled, "request cancelled"}
+	}
+}
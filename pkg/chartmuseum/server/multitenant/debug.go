@@ -0,0 +1,183 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// mutexWaitMetric is the time goroutines spent blocked on sync.Mutex and sync.RWMutex,
+	// only reported by go 1.20 and later
+	mutexWaitMetric = "/sync/mutex/wait/total:seconds"
+)
+
+type (
+	// debugState is a dump of the internals of the server returned by /api/admin/debug/state
+	debugState struct {
+		Ready                bool               `json:"ready"`
+		Goroutines           int                `json:"goroutines"`
+		PendingWrites        int64              `json:"pendingWrites"`
+		QueuedEvents         int                `json:"queuedEvents"`
+		MutexWaitSeconds     *float64           `json:"mutexWaitSeconds,omitempty"`
+		MutexProfileFraction int                `json:"mutexProfileFraction"`
+		Memory               debugMemoryState   `json:"memory"`
+		Tenants              []debugTenantState `json:"tenants"`
+		ProxyIndexes         int                `json:"proxyIndexes"`
+		VirtualIndexes       int                `json:"virtualIndexes"`
+	}
+
+	debugMemoryState struct {
+		HeapAllocBytes uint64 `json:"heapAllocBytes"`
+		HeapObjects    uint64 `json:"heapObjects"`
+		SysBytes       uint64 `json:"sysBytes"`
+		GCCycles       uint32 `json:"gcCycles"`
+	}
+
+	debugTenantState struct {
+		Repo          string     `json:"repo"`
+		Charts        int        `json:"charts"`
+		ChartVersions int        `json:"chartVersions"`
+		MetadataCache int        `json:"metadataCache"`
+		Refreshing    bool       `json:"refreshing"`
+		LastRefreshed *time.Time `json:"lastRefreshed,omitempty"`
+	}
+)
+
+// debugRoutes serve the state of the server, and the profiles of net/http/pprof listed on the page
+// of /api/admin/debug/pprof/. Profiles are routed one by one, as the router only matches wildcards
+// after repos
+func (server *MultiTenantServer) debugRoutes() []*cm_router.Route {
+	routes := []*cm_router.Route{
+		{"GET", "/api/admin/debug/state", server.getDebugStateRequestHandler, cm_auth.PushAction},
+		{"GET", "/api/admin/debug/pprof/", pprofHandler(http.HandlerFunc(pprof.Index)), cm_auth.PushAction},
+		{"GET", "/api/admin/debug/pprof/cmdline", pprofHandler(http.HandlerFunc(pprof.Cmdline)), cm_auth.PushAction},
+		{"GET", "/api/admin/debug/pprof/profile", pprofHandler(http.HandlerFunc(pprof.Profile)), cm_auth.PushAction},
+		{"GET", "/api/admin/debug/pprof/symbol", pprofHandler(http.HandlerFunc(pprof.Symbol)), cm_auth.PushAction},
+		{"POST", "/api/admin/debug/pprof/symbol", pprofHandler(http.HandlerFunc(pprof.Symbol)), cm_auth.PushAction},
+		{"GET", "/api/admin/debug/pprof/trace", pprofHandler(http.HandlerFunc(pprof.Trace)), cm_auth.PushAction},
+	}
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		routes = append(routes, &cm_router.Route{
+			Method:  "GET",
+			Path:    "/api/admin/debug/pprof/" + name,
+			Handler: pprofHandler(pprof.Handler(name)),
+			Action:  cm_auth.PushAction,
+		})
+	}
+	return routes
+}
+
+// pprofHandler serves a handler of net/http/pprof with a 200 status unless it sets another, as
+// the status of the NoRoute handler of gin serving all routes defaults to 404
+func pprofHandler(handler http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func (server *MultiTenantServer) getDebugStateRequestHandler(c *gin.Context) {
+	state := debugState{
+		Ready:                server.ready(),
+		Goroutines:           runtime.NumGoroutine(),
+		PendingWrites:        atomic.LoadInt64(server.PendingWrites),
+		QueuedEvents:         len(server.EventChan),
+		MutexProfileFraction: runtime.SetMutexProfileFraction(-1),
+		Tenants:              server.debugTenantStates(),
+	}
+
+	samples := []metrics.Sample{{Name: mutexWaitMetric}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() == metrics.KindFloat64 {
+		seconds := samples[0].Value.Float64()
+		state.MutexWaitSeconds = &seconds
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	state.Memory = debugMemoryState{
+		HeapAllocBytes: memStats.HeapAlloc,
+		HeapObjects:    memStats.HeapObjects,
+		SysBytes:       memStats.Sys,
+		GCCycles:       memStats.NumGC,
+	}
+
+	server.ProxyLock.Lock()
+	state.ProxyIndexes = len(server.ProxyIndexes)
+	server.ProxyLock.Unlock()
+	server.VirtualLock.Lock()
+	state.VirtualIndexes = len(server.VirtualIndexes)
+	server.VirtualLock.Unlock()
+
+	c.JSON(200, state)
+}
+
+// debugTenantStates returns the state of the tenants in cache, sorted by repo. Indexes are only
+// counted when held in memory, not with an external cache store
+func (server *MultiTenantServer) debugTenantStates() []debugTenantState {
+	server.TenantCacheKeyLock.RLock()
+	entries := map[string]*cacheEntry{}
+	tenants := map[string]*tenantInternals{}
+	for repo, tenant := range server.Tenants {
+		tenants[repo] = tenant
+		entries[repo] = server.InternalCacheStore[repo]
+	}
+	server.TenantCacheKeyLock.RUnlock()
+
+	states := []debugTenantState{}
+	for repo, tenant := range tenants {
+		state := debugTenantState{Repo: repo}
+		if entry := entries[repo]; entry != nil {
+			tenant.RegenerationLock.RLock()
+			index := entry.RepoIndex
+			tenant.RegenerationLock.RUnlock()
+			if index != nil {
+				state.Charts = len(index.Entries)
+				for _, chartVersions := range index.Entries {
+					state.ChartVersions += len(chartVersions)
+				}
+			}
+		}
+		if tenant.MetadataCache != nil {
+			state.MetadataCache = tenant.MetadataCache.Len()
+		}
+		tenant.RefreshLock.Lock()
+		state.Refreshing = tenant.Refreshing
+		if !tenant.LastRefreshed.IsZero() {
+			lastRefreshed := tenant.LastRefreshed
+			state.LastRefreshed = &lastRefreshed
+		}
+		tenant.RefreshLock.Unlock()
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Repo < states[j].Repo
+	})
+	return states
+}
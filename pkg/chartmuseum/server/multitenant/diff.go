@@ -0,0 +1,65 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// chartVersionDiff is returned by /api/charts/<name>/diff
+	chartVersionDiff struct {
+		Name string `json:"name"`
+		From string `json:"from"`
+		To   string `json:"to"`
+		*cm_repo.ChartDiff
+	}
+)
+
+// getChartVersionDiffRequestHandler compares two stored versions of a chart, given with ?from and ?to
+func (server *MultiTenantServer) getChartVersionDiffRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	from, to := c.Query("from"), c.Query("to")
+	if from == "" || to == "" {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "from and to versions are required")
+		return
+	}
+
+	var contents [2][]byte
+	for i, version := range []string{from, to} {
+		filename := cm_repo.ChartPackageFilenameFromNameVersion(name, version)
+		storageObject, err := server.findStorageObject(c, repo, filename)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		contents[i] = storageObject.Content
+	}
+
+	diff, diffErr := cm_repo.DiffChartPackages(contents[0], contents[1])
+	if diffErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInvalidChart, diffErr.Error())
+		return
+	}
+	c.JSON(200, chartVersionDiff{Name: name, From: from, To: to, ChartDiff: diff})
+}
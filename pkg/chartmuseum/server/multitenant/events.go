@@ -0,0 +1,132 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	"helm.sh/chartmuseum/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventStreamBuffer is the number of events a slow client may lag behind before missing some
+	eventStreamBuffer = 100
+	// eventStreamKeepAlive is how often idle streams get a comment, so proxies keep them open
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+type (
+	// eventStream fans the events of each repo out to the clients of GET /api/:repo/events
+	eventStream struct {
+		mutex       sync.Mutex
+		subscribers map[chan *webhook.Event]string
+	}
+)
+
+func newEventStream() *eventStream {
+	return &eventStream{subscribers: map[chan *webhook.Event]string{}}
+}
+
+func (stream *eventStream) subscribe(repo string) chan *webhook.Event {
+	events := make(chan *webhook.Event, eventStreamBuffer)
+	stream.mutex.Lock()
+	stream.subscribers[events] = repo
+	stream.mutex.Unlock()
+	return events
+}
+
+func (stream *eventStream) unsubscribe(events chan *webhook.Event) {
+	stream.mutex.Lock()
+	delete(stream.subscribers, events)
+	stream.mutex.Unlock()
+}
+
+// publish sends an event to the clients of its repo, skipping the ones whose buffer is full
+func (stream *eventStream) publish(event *webhook.Event) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	for events, repo := range stream.subscribers {
+		if repo != event.Repo {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// notify sends an event to webhooks, message buses and event stream clients, with the id of
+// the request causing it if any
+func (server *MultiTenantServer) notify(eventType string, repo string, chart *webhook.Chart, requestID string) {
+	event := webhook.NewEvent(eventType, repo, chart)
+	event.RequestID = requestID
+	server.Notifier.Notify(event)
+	server.EventStream.publish(event)
+}
+
+// getEventsRequestHandler streams the events of a repo as server-sent events, until the client
+// goes away or the server write timeout ends the response
+func (server *MultiTenantServer) getEventsRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	repo := c.Param("repo")
+	events := server.EventStream.subscribe(repo)
+	defer server.EventStream.unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // disable buffering in nginx
+	c.Status(200)
+
+	// the comment sends the headers right away, so clients know they are subscribed
+	if _, err := fmt.Fprint(c.Writer, ": subscribed\n\n"); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventStreamKeepAlive)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ticker.C:
+			_, err = fmt.Fprint(c.Writer, ": keepalive\n\n")
+		case event := <-events:
+			var data []byte
+			data, err = json.Marshal(event)
+			if err == nil {
+				_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Type, data)
+			}
+		}
+		if err != nil {
+			log(cm_logger.DebugLevel, "Event stream closed",
+				"repo", repo,
+				"error", err.Error(),
+			)
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheEvictionInterval is how often indexes unused for CacheTTL are looked for, at most
+const cacheEvictionInterval = time.Minute
+
+// touchTenant records that the index of a tenant was just looked up
+func touchTenant(tenant *tenantInternals) {
+	atomic.StoreInt64(tenant.LastAccessed, time.Now().UnixNano())
+}
+
+// initCacheEviction starts dropping the tenants unused for CacheTTL from memory, along with their
+// indexes without an external cache store, which has its own expiry
+func (server *MultiTenantServer) initCacheEviction() {
+	if server.CacheTTL <= 0 {
+		return
+	}
+	interval := cacheEvictionInterval
+	if server.CacheTTL < interval {
+		interval = server.CacheTTL
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		for range t.C {
+			log := server.Logger.ContextLoggingFn(&gin.Context{})
+			server.TenantCacheKeyLock.Lock()
+			server.evictCacheEntries(log, time.Now())
+			server.TenantCacheKeyLock.Unlock()
+		}
+	}()
+}
+
+// evictCacheEntries drops from memory the tenants unused for CacheTTL, then the least recently
+// used beyond CacheMaxEntries, with their indexes, with TenantCacheKeyLock held. Requests in flight
+// keep the tenant of their entry, see saveCacheEntry, while the next lookup of the repo starts a
+// tenant never refreshed, its index being built again before being served
+func (server *MultiTenantServer) evictCacheEntries(log cm_logger.LoggingFn, now time.Time) {
+	defer server.observeCacheEntries()
+	if server.CacheTTL <= 0 && server.CacheMaxEntries <= 0 {
+		return
+	}
+
+	repos := make([]string, 0, len(server.Tenants))
+	accessed := map[string]int64{}
+	for repo, tenant := range server.Tenants {
+		repos = append(repos, repo)
+		accessed[repo] = atomic.LoadInt64(tenant.LastAccessed)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return accessed[repos[i]] < accessed[repos[j]]
+	})
+
+	evict := func(repo string, reason string) {
+		delete(server.Tenants, repo)
+		delete(server.InternalCacheStore, repo)
+		cacheEvictionCounterVec.WithLabelValues(reason).Inc()
+		log(cm_logger.DebugLevel, "Entry evicted from cache store",
+			"repo", repo,
+			"reason", reason,
+		)
+	}
+	for len(repos) > 0 {
+		repo := repos[0]
+		if server.CacheTTL > 0 && now.Sub(time.Unix(0, accessed[repo])) > server.CacheTTL {
+			evict(repo, "ttl")
+		} else if server.CacheMaxEntries > 0 && len(repos) > server.CacheMaxEntries {
+			evict(repo, "size")
+		} else {
+			break
+		}
+		repos = repos[1:]
+	}
+}
+
+// observeCacheEntries exports the number of indexes held in memory, with TenantCacheKeyLock held
+func (server *MultiTenantServer) observeCacheEntries() {
+	cacheEntriesGauge.Set(float64(len(server.InternalCacheStore)))
+}
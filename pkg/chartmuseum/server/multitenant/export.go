@@ -0,0 +1,104 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"sort"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// export writes the repos in storage to directory as a static chart repository, each with its
+// index.yaml and a charts directory holding the packages and provenance files, then exits
+func (server *MultiTenantServer) export(directory string) {
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	repos := []string{""}
+	if server.Router.Depth > 0 || server.Router.DepthDynamic {
+		var err error
+		repos, err = server.discoverTenants()
+		if err != nil {
+			echo(fmt.Sprintf("Could not list repos: %s\n", err))
+			exit(1)
+			return
+		}
+		sort.Strings(repos)
+	}
+	for _, repo := range repos {
+		charts, err := server.exportRepo(log, directory, repo)
+		if err != nil {
+			echo(fmt.Sprintf("Could not export repo %q: %s\n", repo, err))
+			exit(1)
+			return
+		}
+		log(cm_logger.InfoLevel, "Exported repo",
+			"repo", repo,
+			"charts", charts,
+			"directory", filepath.Join(directory, filepath.FromSlash(repo)),
+		)
+	}
+	exit(0)
+}
+
+// exportRepo writes the index and chart packages of a repo, returning the number of packages
+func (server *MultiTenantServer) exportRepo(log cm_logger.LoggingFn, directory string, repo string) (int, error) {
+	index, indexErr := server.getIndexFile(context.Background(), log, repo)
+	if indexErr != nil {
+		return 0, errors.New(indexErr.Message)
+	}
+
+	repoDirectory := filepath.Join(directory, filepath.FromSlash(repo))
+	chartsDirectory := filepath.Join(repoDirectory, "charts")
+	if err := os.MkdirAll(chartsDirectory, 0755); err != nil {
+		return 0, err
+	}
+
+	charts := 0
+	for _, chartVersions := range index.Entries {
+		for _, chartVersion := range chartVersions {
+			filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+			object, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+			if err != nil {
+				return charts, err
+			}
+			if err = ioutil.WriteFile(filepath.Join(chartsDirectory, filename), object.Content, 0644); err != nil {
+				return charts, err
+			}
+			charts++
+
+			// provenance files are optional
+			provFilename := cm_repo.ProvenanceFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+			if prov, err := server.StorageBackend.GetObject(pathutil.Join(repo, provFilename)); err == nil {
+				if err = ioutil.WriteFile(filepath.Join(chartsDirectory, provFilename), prov.Content, 0644); err != nil {
+					return charts, err
+				}
+			}
+		}
+	}
+
+	// written last, so that a directory with an index is a complete export
+	return charts, ioutil.WriteFile(filepath.Join(repoDirectory, "index.yaml"), index.Raw, 0644)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	"strings"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// restrictForceOverwrite rejects the uploads and promotions sent with ?force by users not among
+// the ForceOverwriteUsers, so that only they may republish a chart version on purpose
+func (server *MultiTenantServer) restrictForceOverwrite(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		repo := c.Param("repo")
+		if target := c.Query("target"); target != "" {
+			repo = strings.Trim(target, "/") // promotions overwrite in their target
+		}
+		if !forceQuery(c) || !server.AllowForceOverwrite || server.allowOverwrite(repo) {
+			handler(c)
+			return
+		}
+		user := cm_router.RequestUser(c.Request)
+		if user == "" || !server.ForceOverwriteUsers[user] {
+			cm_router.WriteError(c, http.StatusForbidden, cm_router.ErrorCodeForbidden, "overwriting chart versions with ?force is not allowed for this user")
+			return
+		}
+		server.Logger.ContextLoggingFn(c)(cm_logger.DebugLevel, "Overwrite forced",
+			"repo", repo,
+			"user", user,
+		)
+		handler(c)
+	}
+}
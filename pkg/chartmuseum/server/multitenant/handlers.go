@@ -18,16 +18,21 @@ package multitenant
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	pathutil "path"
 	"strconv"
+	"strings"
 	"time"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
 
+	cm_auth "github.com/chartmuseum/auth"
 	cm_storage "github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -36,10 +41,11 @@ import (
 )
 
 var (
-	objectSavedResponse   = gin.H{"saved": true}
-	objectDeletedResponse = gin.H{"deleted": true}
-	healthCheckResponse   = gin.H{"healthy": true}
-	welcomePageHTML       = []byte(`<!DOCTYPE html>
+	objectSavedResponse     = gin.H{"saved": true}
+	objectUnchangedResponse = gin.H{"saved": true, "unchanged": true}
+	objectDeletedResponse   = gin.H{"deleted": true}
+	healthCheckResponse     = gin.H{"healthy": true}
+	welcomePageHTML         = []byte(`<!DOCTYPE html>
 <html>
 <head>
 <title>Welcome to ChartMuseum!</title>
@@ -67,7 +73,9 @@ working.</p>
 
 type (
 	HTTPError struct {
-		Status  int
+		Status int
+		// Code is the machine-readable cause of the error, one of the cm_router.ErrorCode constants
+		Code    string
 		Message string
 	}
 )
@@ -81,8 +89,9 @@ type (
 	filenameFromContentFn func([]byte) (string, error)
 )
 
-func (server *MultiTenantServer) getWelcomePageHandler(c *gin.Context) {
-	c.Data(200, "text/html", welcomePageHTML)
+// writeError writes the problem details of err, see cm_router.WriteError
+func writeError(c *gin.Context, err *HTTPError) {
+	cm_router.WriteError(c, err.Status, err.Code, err.Message)
 }
 
 func (server *MultiTenantServer) getInfoHandler(c *gin.Context) {
@@ -95,28 +104,126 @@ func (server *MultiTenantServer) getHealthCheckHandler(c *gin.Context) {
 }
 
 func (server *MultiTenantServer) getIndexFileRequestHandler(c *gin.Context) {
-	repo := c.Param("repo")
-	log := server.Logger.ContextLoggingFn(c)
-	indexFile, err := server.getIndexFile(log, repo)
+	if _, ok := c.GetQuery("at"); ok {
+		server.getJournaledIndexFile(c, c.Param("repo"))
+		return
+	}
+	content, err := server.getIndexFileContent(c, c.Param("repo"))
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		writeError(c, err)
 		return
 	}
-	c.Data(200, indexFileContentType, indexFile.Raw)
+	setCacheControl(c, server.CacheControlIndex)
+	c.Data(200, indexFileContentType, content)
 }
 
-func (server *MultiTenantServer) getStorageObjectRequestHandler(c *gin.Context) {
+func (server *MultiTenantServer) getIndexFileSignatureRequestHandler(c *gin.Context) {
+	content, err := server.getIndexFileContent(c, c.Param("repo"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	server.writeIndexSignature(c, content)
+}
+
+// getIndexFileContent returns the index.yaml of a repo as served for a request, its root
+// index with index sharding
+func (server *MultiTenantServer) getIndexFileContent(c *gin.Context, repo string) ([]byte, *HTTPError) {
+	indexFile, err := server.awaitIndexFileForRequest(c, repo)
+	if err != nil {
+		return nil, err
+	}
+	if server.IndexSharding != nil {
+		raw, rootErr := indexFile.RootIndex(server.IndexSharding)
+		if rootErr != nil {
+			return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, rootErr.Error()}
+		}
+		return raw, nil
+	}
+	return indexFile.Raw, nil
+}
+
+// getIndexShardRequestHandler serves a shard of the index of a repo, or its signature
+// for filenames ending in .asc with --index-signing-key
+func (server *MultiTenantServer) getIndexShardRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	filename := c.Param("filename")
-	log := server.Logger.ContextLoggingFn(c)
-	storageObject, err := server.getStorageObject(log, repo, filename)
+	signature := server.IndexSigner != nil && strings.HasSuffix(filename, "."+cm_repo.IndexSignatureFileExtension)
+	if signature {
+		filename = strings.TrimSuffix(filename, "."+cm_repo.IndexSignatureFileExtension)
+	}
+	shard, ok := cm_repo.ShardFromIndexShardFilename(filename)
+	if !ok || !server.IndexSharding.Valid(shard) {
+		cm_router.WriteError(c, 404, cm_router.ErrorCodeNotFound, "not found")
+		return
+	}
+	indexFile, err := server.awaitIndexFileForRequest(c, repo)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	raw, shardErr := indexFile.Shard(server.IndexSharding, shard)
+	if shardErr != nil {
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, shardErr.Error())
+		return
+	}
+	if signature {
+		server.writeIndexSignature(c, raw)
+		return
+	}
+	setCacheControl(c, server.CacheControlIndex)
+	c.Data(200, indexFileContentType, raw)
+}
+
+// writeIndexSignature serves the signature of an index file, signed as served
+func (server *MultiTenantServer) writeIndexSignature(c *gin.Context, content []byte) {
+	signature, err := server.IndexSigner.Sign(content)
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	setCacheControl(c, server.CacheControlIndex)
+	c.Data(200, cm_repo.IndexSignatureContentType, signature)
+}
+
+func (server *MultiTenantServer) getStorageObjectRequestHandler(c *gin.Context) {
+	server.serveStorageObject(c, c.Param("repo"), c.Param("filename"))
+}
+
+// serveStorageObject serves a chart package or provenance file of a repo, or redirects to it
+func (server *MultiTenantServer) serveStorageObject(c *gin.Context, repo string, filename string) {
+	if server.redirectDownload(c, repo, filename) {
 		return
 	}
+	storageObject, err := server.findStorageObject(c, repo, filename)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	setCacheControl(c, server.CacheControlCharts)
 	c.Data(200, storageObject.ContentType, storageObject.Content)
 }
 
+// findStorageObject returns a chart package or provenance file served by a repo: from its
+// members for a virtual repo, or else from storage or its upstream repo
+func (server *MultiTenantServer) findStorageObject(c *gin.Context, repo string, filename string) (*StorageObject, *HTTPError) {
+	if server.virtualMembers(repo) != nil {
+		return server.getVirtualObject(c, server.Logger.ContextLoggingFn(c), repo, filename)
+	}
+	var storageObject *StorageObject
+	var err *HTTPError
+	if awaitErr := server.awaitRequest(c, func(c *gin.Context) {
+		log := server.Logger.ContextLoggingFn(c)
+		storageObject, err = server.getStorageObject(requestContext(c), log, repo, filename)
+		if err != nil && err.Status == http.StatusNotFound {
+			storageObject, err = server.getUpstreamObject(c, log, repo, filename)
+		}
+	}); awaitErr != nil {
+		return nil, awaitErr
+	}
+	return storageObject, err
+}
+
 func (server *MultiTenantServer) getAllChartsRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	offset := 0
@@ -125,7 +232,7 @@ func (server *MultiTenantServer) getAllChartsRequestHandler(c *gin.Context) {
 		var convErr error
 		offset, convErr = strconv.Atoi(offsetString)
 		if convErr != nil || offset < 0 {
-			c.JSON(400, gin.H{"error": "offset is not a valid non-negative integer"})
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "offset is not a valid non-negative integer")
 			return
 		}
 	}
@@ -136,37 +243,46 @@ func (server *MultiTenantServer) getAllChartsRequestHandler(c *gin.Context) {
 		var convErr error
 		limit, convErr = strconv.Atoi(limitString)
 		if convErr != nil || limit <= 0 {
-			c.JSON(400, gin.H{"error": "limit is not a valid positive integer"})
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "limit is not a valid positive integer")
 			return
 		}
 	}
 
 	log := server.Logger.ContextLoggingFn(c)
-	allCharts, err := server.getAllCharts(log, repo, offset, limit)
+	index, err := server.getIndexFileForAPI(requestContext(c), log, repo)
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		writeError(c, &HTTPError{http.StatusInternalServerError, err.Code, err.Message})
 		return
 	}
-	c.JSON(200, allCharts)
+	key := fmt.Sprintf("charts\x00%s\x00%d\x00%d", repo, offset, limit)
+	server.writeCachedJSON(c, key, index, func() (interface{}, *HTTPError) {
+		return server.getAllCharts(requestContext(c), log, repo, offset, limit)
+	})
 }
 
 func (server *MultiTenantServer) getChartRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
 	log := server.Logger.ContextLoggingFn(c)
-	chart, err := server.getChart(log, repo, name)
+	index, err := server.getIndexFileForAPI(requestContext(c), log, repo)
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		writeError(c, &HTTPError{http.StatusInternalServerError, err.Code, err.Message})
 		return
 	}
-	c.JSON(200, chart)
+	server.writeCachedJSON(c, "chart\x00"+repo+"\x00"+name, index, func() (interface{}, *HTTPError) {
+		chart, err := server.getChart(requestContext(c), log, repo, name)
+		if err != nil {
+			return nil, err
+		}
+		return server.withAttachments(repo, chart...)
+	})
 }
 
 func (server *MultiTenantServer) headChartRequestHandler(c *gin.Context) {
 	repo := c.Param("repo")
 	name := c.Param("name")
 	log := server.Logger.ContextLoggingFn(c)
-	_, err := server.getChart(log, repo, name)
+	_, err := server.getChart(requestContext(c), log, repo, name)
 	if err != nil {
 		c.Status(err.Status)
 		return
@@ -179,12 +295,17 @@ func (server *MultiTenantServer) getChartVersionRequestHandler(c *gin.Context) {
 	name := c.Param("name")
 	version := c.Param("version")
 	log := server.Logger.ContextLoggingFn(c)
-	chartVersion, err := server.getChartVersion(log, repo, name, version)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, name, version)
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		writeError(c, err)
 		return
 	}
-	c.JSON(200, chartVersion)
+	chartVersionWithAttachments, err := server.withAttachments(repo, chartVersion)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(200, chartVersionWithAttachments[0])
 }
 
 func (server *MultiTenantServer) headChartVersionRequestHandler(c *gin.Context) {
@@ -192,7 +313,7 @@ func (server *MultiTenantServer) headChartVersionRequestHandler(c *gin.Context)
 	name := c.Param("name")
 	version := c.Param("version")
 	log := server.Logger.ContextLoggingFn(c)
-	_, err := server.getChartVersion(log, repo, name, version)
+	_, err := server.getChartVersion(requestContext(c), log, repo, name, version)
 	if err != nil {
 		c.Status(err.Status)
 		return
@@ -205,9 +326,13 @@ func (server *MultiTenantServer) deleteChartVersionRequestHandler(c *gin.Context
 	name := c.Param("name")
 	version := c.Param("version")
 	log := server.Logger.ContextLoggingFn(c)
+	if err := server.checkChartProtection(c, name); err != nil {
+		writeError(c, err)
+		return
+	}
 	err := server.deleteChartVersion(log, repo, name, version)
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		writeError(c, err)
 		return
 	}
 
@@ -222,6 +347,77 @@ func (server *MultiTenantServer) deleteChartVersionRequestHandler(c *gin.Context
 	c.JSON(200, objectDeletedResponse)
 }
 
+func (server *MultiTenantServer) promoteChartVersionRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version := c.Param("version")
+	target := strings.Trim(c.Query("target"), "/")
+	log := server.Logger.ContextLoggingFn(c)
+	if target == "" || target == repo {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "target must be a repo other than the source repo")
+		return
+	}
+	if server.virtualMembers(target) != nil {
+		cm_router.WriteError(c, http.StatusMethodNotAllowed, cm_router.ErrorCodeReadOnly, "virtual repos are read-only")
+		return
+	}
+	if !server.Router.DepthDynamic && len(strings.Split(target, "/")) != server.Router.Depth {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("target must have %d path segments", server.Router.Depth))
+		return
+	}
+
+	// the route only checked access to the source repo
+	if authErr := server.Router.AuthorizeRequest(c.Request, cm_auth.PushAction, target); authErr != nil {
+		if !cm_router.IsAuthError(authErr) {
+			log(cm_logger.ErrorLevel, authErr.Error(),
+				"repo", target,
+			)
+		}
+		cm_router.WriteAuthError(c, authErr)
+		return
+	}
+
+	// the promotion writes to the target, which is locked along with the source
+	unlock, lockErr := server.lockRepos(c.Request.Context(), repo, target)
+	if lockErr != nil {
+		cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeTimeout, lockErr.Error())
+		return
+	}
+	defer unlock()
+
+	if err := server.checkChartOwner(c, target, name); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	force := forceQuery(c)
+	if err := server.checkOverwriteProtection(c, target, cm_repo.ChartPackageFilenameFromNameVersion(name, version), force); err != nil {
+		writeError(c, err)
+		return
+	}
+	action := addChart
+	filename, content, err := server.promoteChartVersion(requestContext(c), log, repo, name, version, target, force)
+	if err != nil {
+		if err.Status != http.StatusConflict || err.Message != "" {
+			writeError(c, err)
+			return
+		}
+		action = updateChart
+	}
+
+	chart, chartErr := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+		Path:         pathutil.Join(target, filename),
+		Content:      content,
+		LastModified: time.Now()})
+	if chartErr != nil {
+		log(cm_logger.ErrorLevel, "cannot get chart from content", zap.Error(chartErr), zap.Binary("content", content))
+	}
+	server.emitEvent(c, target, action, chart)
+	server.recordChartOwner(c, target, chartNameFromFilename(filename))
+
+	c.JSON(201, objectSavedResponse)
+}
+
 func (server *MultiTenantServer) postRequestHandler(c *gin.Context) {
 	if c.ContentType() == "multipart/form-data" {
 		server.postPackageAndProvenanceRequestHandler(c) // new route handling form-based chart and/or prov files
@@ -237,25 +433,51 @@ func (server *MultiTenantServer) postPackageRequestHandler(c *gin.Context) {
 		if len(c.Errors) > 0 {
 			return // this is a "request too large"
 		}
-		c.JSON(500, gin.H{"error": fmt.Sprintf("%s", getContentErr)})
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, fmt.Sprintf("%s", getContentErr))
+		return
+	}
+	content, overrideErr := overrideChartVersion(c.Request.URL.Query(), content)
+	if overrideErr != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeInvalidChart, fmt.Sprintf("%s", overrideErr))
 		return
 	}
 	log := server.Logger.ContextLoggingFn(c)
-	_, force := c.GetQuery("force")
+	force := forceQuery(c)
 	action := addChart
+	name, _, _ := extractFromChart(content)
+	if err := server.checkChartOwner(c, repo, name); err != nil {
+		writeError(c, err)
+		return
+	}
+	if filename, err := cm_repo.ChartPackageFilenameFromContent(content); err == nil {
+		if err := server.checkOverwriteProtection(c, repo, filename, force); err != nil {
+			writeError(c, err)
+			return
+		}
+		unlock, err := server.checkExpectedDigest(c, repo, filename)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		defer unlock()
+	}
 	filename, err := server.uploadChartPackage(log, repo, content, force)
 	if err != nil {
 		// here should check both err.Status and err.Message
 		// The http.StatusConflict status means the chart is existed but overwrite is not sed OR chart is existed and overwrite is set
 		// err.Status == http.StatusConflict only denotes for chart is existed now.
 		if err.Status == http.StatusConflict {
+			if err.Message != "" && server.uploadUnchanged(repo, filename, content) {
+				c.JSON(200, objectUnchangedResponse)
+				return
+			}
 			if err.Message != "" {
-				c.JSON(err.Status, gin.H{"error": err.Message})
+				writeError(c, err)
 				return
 			}
 			action = updateChart
 		} else {
-			c.JSON(err.Status, gin.H{"error": err.Message})
+			writeError(c, err)
 			return
 		}
 	}
@@ -268,6 +490,7 @@ func (server *MultiTenantServer) postPackageRequestHandler(c *gin.Context) {
 		log(cm_logger.ErrorLevel, "cannot get chart from content", zap.Error(chartErr), zap.Binary("content", content))
 	}
 	server.emitEvent(c, repo, action, chart)
+	server.recordChartOwner(c, repo, name)
 
 	c.JSON(201, objectSavedResponse)
 }
@@ -280,14 +503,29 @@ func (server *MultiTenantServer) postProvenanceFileRequestHandler(c *gin.Context
 		if len(c.Errors) > 0 {
 			return // this is a "request too large"
 		}
-		c.JSON(500, gin.H{"error": fmt.Sprintf("%s", getContentErr)})
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, fmt.Sprintf("%s", getContentErr))
 		return
 	}
 	log := server.Logger.ContextLoggingFn(c)
-	_, force := c.GetQuery("force")
+	force := forceQuery(c)
+	provFilename, provErr := cm_repo.ProvenanceFilenameFromContent(content)
+	if provErr == nil {
+		if err := server.checkChartOwner(c, repo, chartNameFromFilename(provFilename)); err != nil {
+			writeError(c, err)
+			return
+		}
+		if err := server.checkOverwriteProtection(c, repo, provFilename, force); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
 	err := server.uploadProvenanceFile(log, repo, content, force)
+	if err != nil && err.Status == http.StatusConflict && server.uploadUnchanged(repo, provFilename, content) {
+		c.JSON(200, objectUnchangedResponse)
+		return
+	}
 	if err != nil {
-		c.JSON(err.Status, gin.H{"error": err.Message})
+		writeError(c, err)
 		return
 	}
 	c.JSON(201, objectSavedResponse)
@@ -296,28 +534,36 @@ func (server *MultiTenantServer) postProvenanceFileRequestHandler(c *gin.Context
 func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.Context) {
 	log := server.Logger.ContextLoggingFn(&gin.Context{})
 	repo := c.Param("repo")
-	_, force := c.GetQuery("force")
+	force := forceQuery(c)
 	var chartContent []byte
 	var path string
 	// action used to determine what operation to emit
 	action := addChart
 	cpFiles, status, err := server.getChartAndProvFiles(c.Request, repo, force)
+	code := cm_router.ErrorCodeInternal
+	if status == http.StatusBadRequest {
+		code = cm_router.ErrorCodeInvalidChart
+	}
 	if err != nil {
-		c.JSON(status, gin.H{"error": fmt.Sprintf("%s", err)})
+		cm_router.WriteError(c, status, code, fmt.Sprintf("%s", err))
 		return
 	}
 	switch status {
 	case http.StatusOK:
 	case http.StatusConflict:
-		if !server.AllowOverwrite && (!server.AllowForceOverwrite || !force) {
-			c.JSON(status, gin.H{"error": fmt.Sprintf("%s", fmt.Errorf("chart already exists"))}) // conflict
+		if !server.allowOverwrite(repo) && (!server.AllowForceOverwrite || !force) && server.formUploadUnchanged(repo, cpFiles) {
+			c.JSON(200, objectUnchangedResponse)
+			return
+		}
+		if !server.allowOverwrite(repo) && (!server.AllowForceOverwrite || !force) {
+			cm_router.WriteError(c, status, cm_router.ErrorCodeVersionExists, fmt.Sprintf("%s", fmt.Errorf("chart already exists"))) // conflict
 			return
 		}
 		log(cm_logger.DebugLevel, "chart already exists, but overwrite is allowed", zap.String("repo", repo))
 		// update chart if chart already exists and overwrite is allowed
 		action = updateChart
 	default:
-		c.JSON(status, gin.H{"error": fmt.Sprintf("%s", err)})
+		cm_router.WriteError(c, status, code, fmt.Sprintf("%s", err))
 		return
 	}
 
@@ -325,13 +571,40 @@ func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.C
 		if len(c.Errors) > 0 {
 			return // this is a "request too large"
 		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, fmt.Sprintf(
 			"no package or provenance file found in form fields %s and %s",
 			server.ChartPostFormFieldName, server.ProvPostFormFieldName),
-		})
+		)
 		return
 	}
 
+	var name string
+	for filename := range cpFiles {
+		name = chartNameFromFilename(filename)
+	}
+	if err := server.checkChartOwner(c, repo, name); err != nil {
+		writeError(c, err)
+		return
+	}
+	for filename := range cpFiles {
+		if err := server.checkOverwriteProtection(c, repo, filename, force); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	for _, ppf := range cpFiles {
+		if ppf.field == defaultProvField || ppf.field == server.ProvPostFormFieldName {
+			continue
+		}
+		unlock, err := server.checkExpectedDigest(c, repo, ppf.filename)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		defer unlock()
+		break
+	}
+
 	// At this point input is presumed valid, we now proceed to store it
 	// Undo transaction if there is an error
 	var storedFiles []*chartOrProvenanceFile
@@ -348,7 +621,7 @@ func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.C
 			for _, ppf := range storedFiles {
 				server.StorageBackend.DeleteObject(ppf.filename)
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s", err)})
+			cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, fmt.Sprintf("%s", err))
 			return
 		}
 		if ppf.field == defaultFormField {
@@ -367,6 +640,7 @@ func (server *MultiTenantServer) postPackageAndProvenanceRequestHandler(c *gin.C
 	}
 
 	server.emitEvent(c, repo, action, chart)
+	server.recordChartOwner(c, repo, name)
 
 	c.JSON(http.StatusCreated, objectSavedResponse)
 }
@@ -386,6 +660,7 @@ func (server *MultiTenantServer) getChartAndProvFiles(req *http.Request, repo st
 
 	validReturnStatusCode := http.StatusOK
 	cpFiles := make(map[string]*chartOrProvenanceFile)
+	query := req.URL.Query()
 	for _, ff := range ffp {
 		content, err := extractContentFromRequest(req, ff.field)
 		if err != nil {
@@ -394,6 +669,16 @@ func (server *MultiTenantServer) getChartAndProvFiles(req *http.Request, repo st
 		if content == nil {
 			continue
 		}
+		if isProvField := ff.field == defaultProvField || ff.field == server.ProvPostFormFieldName; !isProvField {
+			if content, err = overrideChartVersion(query, content); err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			if policyErr := server.checkChartPolicy(content); policyErr != nil {
+				return nil, policyErr.Status, errors.New(policyErr.Message)
+			}
+		} else if hasChartVersionOverride(query) {
+			return nil, http.StatusBadRequest, fmt.Errorf("provenance files cannot be uploaded with a version override")
+		}
 		filename, err := ff.fn(content)
 		if err != nil {
 			return nil, http.StatusBadRequest, err
@@ -426,6 +711,33 @@ func (server *MultiTenantServer) getChartAndProvFiles(req *http.Request, repo st
 	return cpFiles, validReturnStatusCode, nil
 }
 
+// forceQuery reports whether an upload may overwrite a chart, with "?force" or "?force=true"
+func forceQuery(c *gin.Context) bool {
+	value, ok := c.GetQuery("force")
+	if !ok {
+		return false
+	}
+	if value == "" {
+		return true
+	}
+	force, err := strconv.ParseBool(value)
+	return err == nil && force
+}
+
+// hasChartVersionOverride reports whether an upload sets ?version or ?appVersion,
+// as helm cm-push does with --version and --app-version
+func hasChartVersionOverride(query url.Values) bool {
+	return query.Get("version") != "" || query.Get("appVersion") != ""
+}
+
+// overrideChartVersion applies the ?version and ?appVersion of an upload to its chart package
+func overrideChartVersion(query url.Values, content []byte) ([]byte, error) {
+	if !hasChartVersionOverride(query) {
+		return content, nil
+	}
+	return cm_repo.OverrideChartVersion(content, query.Get("version"), query.Get("appVersion"))
+}
+
 func extractContentFromRequest(req *http.Request, field string) ([]byte, error) {
 	file, header, _ := req.FormFile(field)
 	if file == nil || header == nil {
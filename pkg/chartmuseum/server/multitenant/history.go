@@ -0,0 +1,92 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"net/http"
+	pathutil "path"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+var historyActions = map[operationType]string{
+	addChart:    cm_repo.ChartVersionUploaded,
+	updateChart: cm_repo.ChartVersionOverwritten,
+	deleteChart: cm_repo.ChartVersionDeleted,
+}
+
+func (server *MultiTenantServer) getChartHistoryRequestHandler(c *gin.Context) {
+	history, err := server.readChartHistory(c.Param("repo"), c.Param("name"))
+	if err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	if len(history) == 0 {
+		cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, "chart not found")
+		return
+	}
+	c.JSON(200, history)
+}
+
+// recordChartHistory appends the change of a chart version applied to the index to the history of
+// its chart. Events are handled one at a time, so the history is never written concurrently
+func (server *MultiTenantServer) recordChartHistory(log cm_logger.LoggingFn, e event) {
+	if !server.ChartHistory {
+		return
+	}
+	name := e.ChartVersion.Name
+	history, err := server.readChartHistory(e.RepoName, name)
+	if err != nil {
+		log(cm_logger.WarnLevel, "Could not read chart history, starting it anew",
+			"repo", e.RepoName,
+			"name", name,
+			"error", err.Error(),
+		)
+	}
+	history = append(history, cm_repo.ChartHistoryEvent{
+		Version:   e.ChartVersion.Version,
+		Action:    historyActions[e.OpType],
+		Time:      time.Now().UTC(),
+		User:      e.User,
+		Digest:    e.ChartVersion.Digest,
+		RequestID: e.RequestID,
+	})
+	content, _ := json.Marshal(history)
+	filename := pathutil.Join(e.RepoName, cm_repo.ChartHistoryFilenameFromName(name))
+	if err := server.StorageBackend.PutObject(filename, content); err != nil {
+		log(cm_logger.WarnLevel, "Could not record chart history",
+			"repo", e.RepoName,
+			"name", name,
+			"error", err.Error(),
+		)
+	}
+}
+
+// readChartHistory returns the history of a chart name of a repo, empty if it has none
+func (server *MultiTenantServer) readChartHistory(repo string, name string) ([]cm_repo.ChartHistoryEvent, error) {
+	object, err := server.StorageBackend.GetObject(pathutil.Join(repo, cm_repo.ChartHistoryFilenameFromName(name)))
+	if err != nil {
+		return []cm_repo.ChartHistoryEvent{}, nil
+	}
+	return cm_repo.ChartHistoryFromContent(object.Content)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ChartIconsPackage serves the icons of chart packages, redirecting to the remote icon of
+	// the others, with --chart-icons=package
+	ChartIconsPackage = "package"
+	// ChartIconsProxy serves the icons of chart packages, or else fetches their remote icon
+	// once and caches it in storage, with --chart-icons=proxy
+	ChartIconsProxy = "proxy"
+
+	// maxIconSize bounds the remote icons fetched
+	maxIconSize = 1 << 20
+	iconTimeout = 10 * time.Second
+)
+
+// validateChartIcons checks the mode of --chart-icons
+func validateChartIcons(mode string) error {
+	switch mode {
+	case "", ChartIconsPackage, ChartIconsProxy:
+		return nil
+	}
+	return fmt.Errorf("invalid chart icons mode %q, must be %s or %s", mode, ChartIconsPackage, ChartIconsProxy)
+}
+
+// iconURL returns the base url of the icons of the charts of a repo as served for a request
+func (server *MultiTenantServer) iconURL(c *gin.Context, repo string) string {
+	scheme, host := server.requestSchemeHost(c)
+	return scheme + "://" + host + server.Router.ContextPath + pathutil.Join("/api", repo, "charts")
+}
+
+// getChartVersionIconRequestHandler serves the icon of a chart version, from its icon attachment,
+// from its package, or in proxy mode from its remote icon url, cached as its icon attachment
+func (server *MultiTenantServer) getChartVersionIconRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	iconFilename := cm_repo.AttachmentFilenameFromNameVersion(chartVersion.Name, chartVersion.Version, cm_repo.IconAttachmentKind)
+	for _, r := range server.attachmentRepos(repo) {
+		if object, getErr := server.StorageBackend.GetObject(pathutil.Join(r, iconFilename)); getErr == nil {
+			server.writeIcon(c, "", object.Content)
+			return
+		}
+	}
+
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+	storageObject, err := server.findStorageObject(c, repo, filename)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	content, icon, iconErr := cm_repo.ChartIconFromContent(storageObject.Content)
+	if iconErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInvalidChart, iconErr.Error())
+		return
+	}
+	if content != nil {
+		server.writeIcon(c, icon, content)
+		return
+	}
+	if !strings.HasPrefix(icon, "http://") && !strings.HasPrefix(icon, "https://") {
+		cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, "chart has no icon")
+		return
+	}
+	if server.ChartIcons != ChartIconsProxy {
+		// as the index points to this route for every icon, remote ones included
+		c.Redirect(http.StatusFound, icon)
+		return
+	}
+
+	content, fetchErr := server.fetchIcon(icon)
+	if fetchErr != nil {
+		log(cm_logger.WarnLevel, "Error fetching chart icon",
+			"repo", repo,
+			"package", filename,
+			"icon", redactURL(icon),
+			"error", fetchErr.Error(),
+		)
+		cm_router.WriteError(c, http.StatusBadGateway, cm_router.ErrorCodeUpstreamUnavailable, "error fetching chart icon")
+		return
+	}
+	// cached in the repo holding the chart, members of virtual repos being read-only through them
+	if inMaintenance, _ := server.inMaintenance(); !inMaintenance && server.virtualMembers(repo) == nil {
+		if putErr := server.StorageBackend.PutObject(pathutil.Join(repo, iconFilename), content); putErr != nil {
+			log(cm_logger.WarnLevel, "Error caching chart icon",
+				"repo", repo,
+				"package", filename,
+				"error", putErr.Error(),
+			)
+		}
+		server.ResponseCache.purge()
+	}
+	server.writeIcon(c, icon, content)
+}
+
+// fetchIcon downloads a remote icon, which must be an image of maxIconSize at most
+func (server *MultiTenantServer) fetchIcon(icon string) ([]byte, error) {
+	client := &http.Client{Timeout: iconTimeout}
+	resp, err := client.Get(icon)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("icon url returned %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxIconSize + 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxIconSize {
+		return nil, fmt.Errorf("icon is larger than %d bytes", maxIconSize)
+	}
+	iconURL, _ := url.Parse(icon)
+	if !strings.HasPrefix(cm_repo.IconContentType(iconURL.Path, content), "image/") {
+		return nil, errors.New("icon is not an image")
+	}
+	return content, nil
+}
+
+func (server *MultiTenantServer) writeIcon(c *gin.Context, filename string, content []byte) {
+	setCacheControl(c, server.CacheControlCharts)
+	c.Data(200, cm_repo.IconContentType(filename, content), content)
+}
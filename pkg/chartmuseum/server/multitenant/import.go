@@ -0,0 +1,196 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"sort"
+	"strings"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	"helm.sh/chartmuseum/pkg/replication"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/upstream"
+
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	importStatusImported = "imported"
+	importStatusSkipped  = "skipped"
+	importStatusFailed   = "failed"
+)
+
+type (
+	// importProgress is written as a line of the response of POST /api/admin/import for each chart
+	// version of the remote repo, as it is imported
+	importProgress struct {
+		Filename string `json:"filename"`
+		Status   string `json:"status"`
+		Error    string `json:"error,omitempty"`
+		Done     int    `json:"done"`
+		Total    int    `json:"total"`
+	}
+
+	// importSummary is the last line of the response of POST /api/admin/import
+	importSummary struct {
+		Source   string `json:"source"`
+		Repo     string `json:"repo"`
+		Imported int    `json:"imported"`
+		Skipped  int    `json:"skipped"`
+		Failed   int    `json:"failed"`
+	}
+)
+
+// postImportRequestHandler copies the charts of an existing Helm chart repo, read from its index.yaml,
+// into a local repo. Chart versions already in the repo are skipped, never overwritten. The progress
+// is streamed as a json line per chart version, followed by a summary
+func (server *MultiTenantServer) postImportRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	source := &replication.Source{
+		URL:     c.Query("url"),
+		Repo:    strings.Trim(c.Query("repo"), "/"),
+		Include: c.QueryArray("include"),
+		Exclude: c.QueryArray("exclude"),
+	}
+	if source.URL == "" {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "url is required")
+		return
+	}
+	if source.Repo != "" {
+		if err := server.validateTenantName(source.Repo); err != nil {
+			writeError(c, err)
+			return
+		}
+		if server.virtualMembers(source.Repo) != nil {
+			cm_router.WriteError(c, http.StatusMethodNotAllowed, cm_router.ErrorCodeReadOnly, "virtual repos are read-only")
+			return
+		}
+	}
+	for _, pattern := range append(append([]string{}, source.Include...), source.Exclude...) {
+		if _, err := pathutil.Match(pattern, ""); err != nil {
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("invalid filter %q", pattern))
+			return
+		}
+	}
+	client, err := upstream.NewClient(upstream.ClientOptions{URL: source.URL})
+	if err != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	index, err := client.Refresh()
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error fetching index of imported repo",
+			"repo", source.Repo,
+			"source", client.Name(),
+			"error", err.Error(),
+		)
+		cm_router.WriteError(c, http.StatusBadGateway, cm_router.ErrorCodeUpstreamUnavailable, "error fetching index of repo to import")
+		return
+	}
+
+	unlock, err := server.lock(c.Request.Context(), "repo/"+source.Repo)
+	if err != nil {
+		cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeTimeout, err.Error())
+		return
+	}
+	defer unlock()
+	objects, err := server.fetchChartsInStorage(c.Request.Context(), log, source.Repo)
+	if err != nil {
+		cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+	local := map[string]bool{}
+	for _, object := range objects {
+		local[pathutil.Base(object.Path)] = true
+	}
+	// the index is built from storage first, as the charts imported are added to it by events
+	if _, httpErr := server.getIndexFile(context.Background(), log, source.Repo); httpErr != nil {
+		writeError(c, httpErr)
+		return
+	}
+
+	var names []string
+	total := 0
+	for name, chartVersions := range index.Entries {
+		if source.Matches(name) {
+			names = append(names, name)
+			total += len(chartVersions)
+		}
+	}
+	sort.Strings(names)
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Accel-Buffering", "no") // disable buffering in nginx
+	c.Status(200)
+	encoder := json.NewEncoder(c.Writer)
+	replica := &replica{source: source, client: client}
+	summary := importSummary{Source: client.Name(), Repo: source.Repo}
+	done := 0
+	for _, name := range names {
+		for _, chartVersion := range index.Entries[name] {
+			done++
+			progress := server.importChartVersion(log, replica, local, chartVersion)
+			progress.Done, progress.Total = done, total
+			switch progress.Status {
+			case importStatusImported:
+				summary.Imported++
+			case importStatusSkipped:
+				summary.Skipped++
+			default:
+				summary.Failed++
+			}
+			// the import goes on when the client goes away, only the progress is lost
+			encoder.Encode(progress)
+			c.Writer.Flush()
+		}
+	}
+
+	log(cm_logger.InfoLevel, "Imported repo",
+		"repo", source.Repo,
+		"source", client.Name(),
+		"imported", summary.Imported,
+		"skipped", summary.Skipped,
+		"failed", summary.Failed,
+	)
+	encoder.Encode(summary)
+}
+
+// importChartVersion saves a chart version of the repo imported, unless it is already in the local repo
+func (server *MultiTenantServer) importChartVersion(log cm_logger.LoggingFn, replica *replica, local map[string]bool, chartVersion *helm_repo.ChartVersion) *importProgress {
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+	if local[filename] {
+		return &importProgress{Filename: filename, Status: importStatusSkipped}
+	}
+	if err := server.replicateChartVersion(log, replica, filename, chartVersion); err != nil {
+		log(cm_logger.ErrorLevel, "Error importing chart",
+			"repo", replica.source.Repo,
+			"source", replica.client.Name(),
+			"filename", filename,
+			"error", err.Error(),
+		)
+		return &importProgress{Filename: filename, Status: importStatusFailed, Error: err.Error()}
+	}
+	local[filename] = true
+	return &importProgress{Filename: filename, Status: importStatusImported}
+}
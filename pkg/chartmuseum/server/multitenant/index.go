@@ -17,11 +17,15 @@ limitations under the License.
 package multitenant
 
 import (
+	"context"
 	"net/http"
 	pathutil "path"
+	"strings"
 
 	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
 )
 
@@ -29,60 +33,123 @@ var (
 	indexFileContentType = "application/x-yaml"
 )
 
-func (server *MultiTenantServer) getIndexFile(log cm_logger.LoggingFn, repo string) (*cm_repo.Index, *HTTPError) {
-	entry, err := server.initCacheEntry(log, repo)
+func (server *MultiTenantServer) getIndexFile(ctx context.Context, log cm_logger.LoggingFn, repo string) (*cm_repo.Index, *HTTPError) {
+	entry, err := server.initCacheEntry(ctx, log, repo)
 	if err != nil {
 		errStr := err.Error()
 		log(cm_logger.ErrorLevel, errStr,
 			"repo", repo,
 		)
-		return nil, &HTTPError{http.StatusInternalServerError, errStr}
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, errStr}
 	}
 
-	// if cache is nil, and not on a timer, regenerate it
-	if len(entry.RepoIndex.Entries) == 0 && server.CacheInterval == 0 {
+	index := server.getRepoIndex(entry)
 
-		fo := <-server.getChartList(log, repo)
+	if server.StaleWhileRevalidate {
+		index, err = server.revalidateCacheEntry(ctx, log, repo, entry, index)
+		if err != nil {
+			return index, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+		}
+		return index, nil
+	}
 
-		if fo.err != nil {
-			errStr := fo.err.Error()
-			log(cm_logger.ErrorLevel, errStr,
-				"repo", repo,
-			)
-			return nil, &HTTPError{http.StatusInternalServerError, errStr}
+	// if cache is nil, and not on a timer or never refreshed, e.g. once evicted, regenerate it
+	if len(index.Entries) == 0 && (server.CacheInterval == 0 || !server.refreshed(entry)) {
+		index, err = server.refreshCacheEntry(ctx, log, repo, entry)
+		if err != nil {
+			return index, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
 		}
+	}
+	return index, nil
+}
+
+// getIndexFileForRequest returns the index of a repo with chart and icon URLs built for the incoming request
+func (server *MultiTenantServer) getIndexFileForRequest(c *gin.Context, log cm_logger.LoggingFn, repo string) (*cm_repo.Index, *HTTPError) {
+	index, err := server.getServedIndex(requestContext(c), log, repo)
+	if err != nil || (server.ChartURLTemplate == "" && server.ChartIcons == "") {
+		return index, err
+	}
+	var rewriteErr error
+	if server.ChartURLTemplate != "" {
+		index, rewriteErr = index.WithChartURL(server.chartURLFromTemplate(c, repo))
+	}
+	if rewriteErr == nil && server.ChartIcons != "" {
+		index, rewriteErr = index.WithIconURL(server.iconURL(c, repo))
+	}
+	if rewriteErr != nil {
+		errStr := rewriteErr.Error()
+		log(cm_logger.ErrorLevel, errStr,
+			"repo", repo,
+		)
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, errStr}
+	}
+	return index, nil
+}
+
+// awaitIndexFileForRequest returns the index served for a request, unless the request is
+// cancelled or times out first
+func (server *MultiTenantServer) awaitIndexFileForRequest(c *gin.Context, repo string) (*cm_repo.Index, *HTTPError) {
+	var index *cm_repo.Index
+	var err *HTTPError
+	if awaitErr := server.awaitRequest(c, func(c *gin.Context) {
+		index, err = server.getIndexFileForRequest(c, server.Logger.ContextLoggingFn(c), repo)
+	}); awaitErr != nil {
+		return nil, awaitErr
+	}
+	return index, err
+}
 
-		objects := server.getRepoObjectSlice(entry)
-		diff := cm_storage.GetObjectSliceDiff(objects, fo.objects, server.TimestampTolerance)
-
-		// return fast if no changes
-		if !diff.Change {
-			log(cm_logger.DebugLevel, "No change detected between cache and storage",
-				"repo", repo,
-			)
-		} else {
-			ir := <-server.regenerateRepositoryIndex(log, entry, diff)
-			if ir.err != nil {
-				errStr := ir.err.Error()
-				log(cm_logger.ErrorLevel, errStr,
-					"repo", repo,
-				)
-				return ir.index, &HTTPError{http.StatusInternalServerError, errStr}
-			}
-			entry.RepoIndex = ir.index
-
-			if server.UseStatefiles {
-				// Dont wait, save index-cache.yaml to storage in the background.
-				// It is not crucial if this does not succeed, we will just log any errors
-				go server.saveStatefile(log, repo, ir.index.Raw)
-			}
+func (server *MultiTenantServer) chartURLFromTemplate(c *gin.Context, repo string) string {
+	scheme, host := server.requestSchemeHost(c)
+	chartURL := strings.NewReplacer(
+		"{scheme}", scheme,
+		"{host}", host,
+		"{tenant}", repo,
+		"{contextpath}", server.Router.ContextPath,
+	).Replace(server.ChartURLTemplate)
+
+	// an empty tenant or context path should not leave "//" in the url
+	if i := strings.Index(chartURL, "://"); i >= 0 {
+		chartURL = chartURL[:i+3] + pathutil.Clean(chartURL[i+3:])
+	}
+	return chartURL
+}
+
+// requestSchemeHost returns the scheme and host of a request as sent by clients, those set by
+// trusted proxies taking precedence
+func (server *MultiTenantServer) requestSchemeHost(c *gin.Context) (string, string) {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if server.Router.TrustsForwardedHeaders(c.Request) {
+		if proto := firstHeaderValue(c.GetHeader("X-Forwarded-Proto")); proto != "" {
+			scheme = proto
+		}
+		if forwardedHost := firstHeaderValue(c.GetHeader("X-Forwarded-Host")); forwardedHost != "" {
+			host = forwardedHost
 		}
 	}
-	return entry.RepoIndex, nil
+	return scheme, host
+}
+
+// firstHeaderValue returns the first of comma-separated values set by proxies
+func firstHeaderValue(value string) string {
+	return strings.TrimSpace(strings.Split(value, ",")[0])
 }
 
 func (server *MultiTenantServer) saveStatefile(log cm_logger.LoggingFn, repo string, content []byte) {
-	err := server.StorageBackend.PutObject(pathutil.Join(repo, cm_repo.StatefileFilename), content)
+	unlock, err := server.lock(context.Background(), "index-cache/"+repo)
+	if err != nil {
+		log(cm_logger.WarnLevel, "Error locking index-cache.yaml",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return
+	}
+	defer unlock()
+	err = server.StorageBackend.PutObject(pathutil.Join(repo, cm_repo.StatefileFilename), content)
 	if err != nil {
 		log(cm_logger.WarnLevel, "Error saving index-cache.yaml",
 			"repo", repo,
@@ -96,7 +163,7 @@ func (server *MultiTenantServer) saveStatefile(log cm_logger.LoggingFn, repo str
 
 func (server *MultiTenantServer) getRepoObjectSlice(entry *cacheEntry) []cm_storage.Object {
 	var objects []cm_storage.Object
-	for _, entry := range entry.RepoIndex.Entries {
+	for _, entry := range server.getRepoIndex(entry).Entries {
 		for _, chartVersion := range entry {
 			object := cm_repo.StorageObjectFromChartVersion(chartVersion)
 			objects = append(objects, object)
@@ -0,0 +1,243 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"errors"
+	pathutil "path"
+	"strings"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	"helm.sh/chartmuseum/pkg/ingestion"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// ingester imports the charts of the releases of a GitHub or GitLab project into local storage
+	ingester struct {
+		source *ingestion.Source
+		client *ingestion.Client
+		// ingested holds the tags of the releases imported without error, not checked again
+		ingested map[string]bool
+		// mutex serializes the runs of ingest of the source
+		mutex sync.Mutex
+	}
+)
+
+func newIngesters(config *ingestion.Config) ([]*ingester, error) {
+	if config == nil {
+		return nil, nil
+	}
+	var ingesters []*ingester
+	for _, source := range config.Sources {
+		client, err := ingestion.NewClient(source, 0)
+		if err != nil {
+			return nil, err
+		}
+		ingesters = append(ingesters, &ingester{source: source, client: client, ingested: map[string]bool{}})
+	}
+	return ingesters, nil
+}
+
+// startIngestion imports the releases of the sources right away, then every ingestion interval
+func (server *MultiTenantServer) startIngestion() {
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	ticker := time.NewTicker(server.Ingestion.Interval.Duration)
+	for {
+		if inMaintenance, _ := server.inMaintenance(); inMaintenance {
+			log(cm_logger.InfoLevel, "Skipping ingestion in maintenance mode")
+		} else if !server.Leader.IsLeader() {
+			log(cm_logger.DebugLevel, "Skipping ingestion, run by the leader")
+		} else {
+			for _, ingester := range server.Ingesters {
+				server.ingest(log, ingester)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// ingest imports the chart packages attached to the releases of a source, and with a path the
+// charts packaged from their source archive, which are missing from the local repo. Chart
+// versions already in the repo are never overwritten. It returns how many charts were added
+func (server *MultiTenantServer) ingest(log cm_logger.LoggingFn, ingester *ingester) (int, error) {
+	ingester.mutex.Lock()
+	defer ingester.mutex.Unlock()
+	source, repo := ingester.source, ingester.source.Repo
+	releases, err := ingester.client.Releases()
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error listing releases to ingest",
+			"repo", repo,
+			"source", source.Name(),
+			"error", err.Error(),
+		)
+		return 0, err
+	}
+
+	// the charts ingested are written as uploads are, so that those of other replicas do not interleave
+	unlock, err := server.lock(context.Background(), "repo/"+repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error locking ingestion repo",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return 0, err
+	}
+	defer unlock()
+	objects, err := server.fetchChartsInStorage(context.Background(), log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error listing charts of ingestion repo",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return 0, err
+	}
+	local := map[string]bool{}
+	for _, object := range objects {
+		local[pathutil.Base(object.Path)] = true
+	}
+	// the index is built from storage first, as the charts ingested are added to it by events,
+	// and an index holding them only would never be rebuilt with the charts already in the repo
+	if _, httpErr := server.getIndexFile(context.Background(), log, repo); httpErr != nil {
+		return 0, errors.New(httpErr.Message)
+	}
+
+	added := 0
+	for _, release := range releases {
+		if ingester.ingested[release.Tag] {
+			continue
+		}
+		failed := false
+		fail := func(message string, err error) {
+			log(cm_logger.ErrorLevel, message,
+				"repo", repo,
+				"source", source.Name(),
+				"release", release.Tag,
+				"error", err.Error(),
+			)
+			failed = true
+		}
+
+		assets := map[string]*ingestion.Asset{}
+		for _, asset := range release.Assets {
+			assets[asset.Name] = asset
+		}
+		for _, asset := range release.Assets {
+			if !strings.HasSuffix(asset.Name, ".tgz") || local[asset.Name] {
+				continue
+			}
+			content, err := ingester.client.Download(asset.URL)
+			if err != nil {
+				fail("Error downloading release asset", err)
+				continue
+			}
+			var provContent []byte
+			if prov := assets[asset.Name+".prov"]; prov != nil {
+				if provContent, err = ingester.client.Download(prov.URL); err != nil {
+					fail("Error downloading release asset", err)
+					continue
+				}
+			}
+			ok, err := server.ingestChartVersion(log, repo, local, content, provContent)
+			if err != nil {
+				fail("Error ingesting chart", err)
+			} else if ok {
+				added++
+			}
+		}
+
+		if source.Path != "" {
+			archive, err := ingester.client.Download(release.Archive)
+			if err != nil {
+				fail("Error downloading release source", err)
+			} else {
+				packages, err := ingestion.ChartsFromArchive(archive, source.Path)
+				if err != nil {
+					fail("Error packaging charts of release", err)
+				}
+				for _, content := range packages {
+					ok, err := server.ingestChartVersion(log, repo, local, content, nil)
+					if err != nil {
+						fail("Error ingesting chart", err)
+					} else if ok {
+						added++
+					}
+				}
+			}
+		}
+
+		if !failed {
+			ingester.ingested[release.Tag] = true
+		}
+	}
+
+	log(cm_logger.InfoLevel, "Ingested releases",
+		"repo", repo,
+		"source", source.Name(),
+		"added", added,
+	)
+	return added, nil
+}
+
+// ingestChartVersion saves a chart package in storage along with its provenance file if any,
+// unless its version is already in the repo, reporting whether it was saved
+func (server *MultiTenantServer) ingestChartVersion(log cm_logger.LoggingFn, repo string, local map[string]bool, content []byte, provContent []byte) (bool, error) {
+	name, version, err := extractFromChart(content)
+	if err != nil {
+		return false, err
+	}
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(name, version)
+	if local[filename] {
+		return false, nil
+	}
+	if err := server.checkChartPolicy(content); err != nil {
+		return false, errors.New(err.Message)
+	}
+	objectPath := pathutil.Join(repo, filename)
+	chartVersion, err := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+		Path:         objectPath,
+		Content:      content,
+		LastModified: time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// the provenance file goes first, so the chart is never in the index without it
+	if provContent != nil {
+		provPath := pathutil.Join(repo, cm_repo.ProvenanceFilenameFromNameVersion(name, version))
+		if err := server.StorageBackend.PutObject(provPath, provContent); err != nil {
+			return false, err
+		}
+	}
+	if err := server.StorageBackend.PutObject(objectPath, content); err != nil {
+		return false, err
+	}
+	local[filename] = true
+	log(cm_logger.DebugLevel, "Ingested chart",
+		"repo", repo,
+		"package", filename,
+	)
+	server.emitEvent(&gin.Context{}, repo, addChart, chartVersion)
+	return true, nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"sort"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+// journalDirectory holds the changes of the index of a repo with --index-journal, one object
+// per change named after its time, so that listing them in order replays the index
+const journalDirectory = ".journal"
+
+const (
+	journalSnapshot = "snapshot"
+	journalAdd      = "add"
+	journalUpdate   = "update"
+	journalDelete   = "delete"
+)
+
+type (
+	// journalEntry is a change of the index of a repo. The first entry of a journal is a snapshot
+	// of the charts of the repo when the journal started
+	journalEntry struct {
+		Time   time.Time                 `json:"time"`
+		Action string                    `json:"action"`
+		Chart  *helm_repo.ChartVersion   `json:"chart,omitempty"`
+		Charts []*helm_repo.ChartVersion `json:"charts,omitempty"`
+	}
+)
+
+var journalActions = map[operationType]string{
+	addChart:    journalAdd,
+	updateChart: journalUpdate,
+	deleteChart: journalDelete,
+}
+
+func journalPath(repo string, filename string) string {
+	return pathutil.Join(repo, journalDirectory, filename)
+}
+
+// journalChange appends a change of the index of a repo to its journal, starting the journal
+// with a snapshot of the index before the change if there is none yet
+func (server *MultiTenantServer) journalChange(log cm_logger.LoggingFn, repo string, tenant *tenantInternals, before *cm_repo.Index, operationType operationType, chartVersion *helm_repo.ChartVersion) {
+	if !server.IndexJournal {
+		return
+	}
+	now := time.Now().UTC()
+	if !tenant.Journaled {
+		objects, err := server.StorageBackend.ListObjects(journalPath(repo, ""))
+		if err != nil {
+			log(cm_logger.ErrorLevel, "Error listing index journal",
+				"repo", repo,
+				"error", err.Error(),
+			)
+			return
+		}
+		if len(objects) == 0 {
+			snapshot := &journalEntry{Time: now, Action: journalSnapshot, Charts: []*helm_repo.ChartVersion{}}
+			for _, chartVersions := range before.Entries {
+				snapshot.Charts = append(snapshot.Charts, chartVersions...)
+			}
+			if !server.saveJournalEntry(log, repo, fmt.Sprintf("%020d-%s.json", now.UnixNano(), journalSnapshot), snapshot) {
+				return
+			}
+			now = now.Add(time.Nanosecond)
+		}
+		tenant.Journaled = true
+	}
+	entry := &journalEntry{Time: now, Action: journalActions[operationType], Chart: chartVersion}
+	filename := fmt.Sprintf("%020d-%s-%s.json", now.UnixNano(), entry.Action,
+		strings.TrimSuffix(cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version), ".tgz"))
+	server.saveJournalEntry(log, repo, filename, entry)
+}
+
+func (server *MultiTenantServer) saveJournalEntry(log cm_logger.LoggingFn, repo string, filename string, entry *journalEntry) bool {
+	content, err := json.Marshal(entry)
+	if err == nil {
+		err = server.StorageBackend.PutObject(journalPath(repo, filename), content)
+	}
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error saving index journal entry",
+			"repo", repo,
+			"entry", filename,
+			"error", err.Error(),
+		)
+		return false
+	}
+	return true
+}
+
+// journaledIndex replays the journal of a repo up to at, returning the index of the repo at that time
+func (server *MultiTenantServer) journaledIndex(log cm_logger.LoggingFn, repo string, at time.Time) (*cm_repo.Index, *HTTPError) {
+	objects, err := server.StorageBackend.ListObjects(journalPath(repo, ""))
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+
+	index := cm_repo.NewIndex("", repo, &cm_repo.ServerInfo{
+		ContextPath: server.Router.ContextPath,
+	})
+	replayed := 0
+	for _, object := range objects {
+		object, err := server.StorageBackend.GetObject(journalPath(repo, object.Path))
+		if err != nil {
+			return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+		}
+		entry := &journalEntry{}
+		if err := json.Unmarshal(object.Content, entry); err != nil {
+			log(cm_logger.WarnLevel, "Skipping invalid index journal entry",
+				"repo", repo,
+				"entry", object.Path,
+				"error", err.Error(),
+			)
+			continue
+		}
+		if entry.Time.After(at) {
+			break
+		}
+		switch entry.Action {
+		case journalSnapshot:
+			for _, chartVersion := range entry.Charts {
+				index.AddEntry(chartVersion)
+			}
+		case journalAdd:
+			index.AddEntry(entry.Chart)
+		case journalUpdate:
+			index.UpdateEntry(entry.Chart)
+		case journalDelete:
+			index.RemoveEntry(entry.Chart)
+		}
+		replayed++
+	}
+	if replayed == 0 {
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound,
+			fmt.Sprintf("no index journal of the repo before %s", at.Format(time.RFC3339))}
+	}
+	if err := index.Regenerate(); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error()}
+	}
+	return index, nil
+}
+
+// getJournaledIndexFile serves the index.yaml of a repo as of the time of ?at
+func (server *MultiTenantServer) getJournaledIndexFile(c *gin.Context, repo string) {
+	at, err := time.Parse(time.RFC3339, c.Query("at"))
+	if err != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("invalid at %q, must be an RFC 3339 timestamp", c.Query("at")))
+		return
+	}
+	if !server.IndexJournal {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "index journal not enabled, see --index-journal")
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	index, indexErr := server.journaledIndex(log, repo, at)
+	if indexErr != nil {
+		writeError(c, indexErr)
+		return
+	}
+	if server.ChartURLTemplate != "" {
+		if index, err = index.WithChartURL(server.chartURLFromTemplate(c, repo)); err != nil {
+			cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, err.Error())
+			return
+		}
+	}
+	c.Data(200, indexFileContentType, index.Raw)
+}
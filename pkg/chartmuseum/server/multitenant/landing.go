@@ -0,0 +1,178 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"bytes"
+	"strings"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// landingDocument describes the server at /, for clients discovering its capabilities and
+	// for landing page templates
+	landingDocument struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Depth   int    `json:"depth"`
+		// Features are enabled or not, by name, e.g. "api" or "oci"
+		Features map[string]bool `json:"features"`
+		// Repos are those the client may pull from, known to the server
+		Repos []landingRepo `json:"repos"`
+	}
+
+	landingRepo struct {
+		Name     string `json:"name"`
+		URL      string `json:"url"`
+		IndexURL string `json:"indexURL"`
+	}
+
+	// versionDocument is served at /api/version, for client tooling adapting to the server
+	versionDocument struct {
+		Version  string          `json:"version"`
+		Revision string          `json:"revision"`
+		Options  versionOptions  `json:"options"`
+		Features map[string]bool `json:"features"`
+	}
+
+	versionOptions struct {
+		Multitenant  bool   `json:"multitenant"`
+		Depth        int    `json:"depth"`
+		DepthDynamic bool   `json:"depthDynamic"`
+		ContextPath  string `json:"contextPath"`
+		APIEnabled   bool   `json:"apiEnabled"`
+		// Overwrite is "always" with --allow-overwrite, "force" when uploads must ask for it with
+		// ?force and "never" otherwise. Tenants may override it
+		Overwrite     string `json:"overwrite"`
+		DisableDelete bool   `json:"disableDelete"`
+	}
+)
+
+func (server *MultiTenantServer) getVersionRequestHandler(c *gin.Context) {
+	overwrite := "never"
+	if server.AllowOverwrite {
+		overwrite = "always"
+	} else if server.AllowForceOverwrite {
+		overwrite = "force"
+	}
+	c.JSON(200, &versionDocument{
+		Version:  server.Version,
+		Revision: server.Revision,
+		Options: versionOptions{
+			Multitenant:   server.Router.Depth > 0 || server.Router.DepthDynamic,
+			Depth:         server.Router.Depth,
+			DepthDynamic:  server.Router.DepthDynamic,
+			ContextPath:   server.Router.ContextPath,
+			APIEnabled:    server.APIEnabled,
+			Overwrite:     overwrite,
+			DisableDelete: server.DisableDelete,
+		},
+		Features: server.features(),
+	})
+}
+
+// features tells which optional features of the server are enabled, by name
+func (server *MultiTenantServer) features() map[string]bool {
+	return map[string]bool{
+		"api":           server.APIEnabled,
+		"delete":        server.APIEnabled && !server.DisableDelete,
+		"overwrite":     server.AllowOverwrite,
+		"oci":           server.OCIEnabled,
+		"webUI":         server.WebUIEnabled,
+		"tenantAPI":     server.TenantAPIEnabled,
+		"indexSigning":  server.IndexSigner != nil,
+		"indexSharding": server.IndexSharding != nil,
+		"indexJournal":  server.IndexJournal,
+		"chartOwners":   server.ChartOwners,
+		"chartHistory":  server.ChartHistory,
+		"chartChannels": server.APIEnabled,
+		"scan":          server.Scanner != nil,
+		"proxy":         server.ProxyUpstream != "",
+	}
+}
+
+func (server *MultiTenantServer) getWelcomePageHandler(c *gin.Context) {
+	if !server.LandingJSON && server.LandingTemplate == nil && !acceptsJSON(c) {
+		c.Data(200, "text/html", welcomePageHTML)
+		return
+	}
+	server.writeLandingDocument(c)
+}
+
+// writeLandingDocument writes the landing document as json, or as rendered by LandingTemplate
+func (server *MultiTenantServer) writeLandingDocument(c *gin.Context) {
+	document := server.landingDocument(c)
+	if server.LandingJSON || server.LandingTemplate == nil || acceptsJSON(c) {
+		c.JSON(200, document)
+		return
+	}
+	var page bytes.Buffer
+	if err := server.LandingTemplate.Execute(&page, document); err != nil {
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	c.Data(200, "text/html; charset=utf-8", page.Bytes())
+}
+
+// acceptsJSON tells whether a request prefers json to html
+func acceptsJSON(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+}
+
+func (server *MultiTenantServer) landingDocument(c *gin.Context) *landingDocument {
+	document := &landingDocument{
+		Name:     "ChartMuseum",
+		Version:  server.Version,
+		Depth:    server.Router.Depth,
+		Features: server.features(),
+		Repos:    []landingRepo{},
+	}
+
+	scheme, host := server.requestSchemeHost(c)
+	baseURL := scheme + "://" + host + strings.TrimSuffix(server.Router.ContextPath, "/")
+	repos := []string{""}
+	if server.Router.Depth > 0 || server.Router.DepthDynamic {
+		var err error
+		if repos, err = server.catalogRepos(); err != nil {
+			// the landing page is served without repos rather than failing
+			server.Logger.ContextLoggingFn(c)(cm_logger.WarnLevel, "Unable to list repos for landing page",
+				"error", err.Error(),
+			)
+		}
+	}
+	for _, repo := range repos {
+		// repos of other tenants are not disclosed
+		if err := server.Router.AuthorizeRequest(c.Request, cm_auth.PullAction, repo); err != nil {
+			continue
+		}
+		repoURL := baseURL
+		if repo != "" {
+			repoURL += "/" + repo
+		}
+		document.Repos = append(document.Repos, landingRepo{
+			Name:     repo,
+			URL:      repoURL,
+			IndexURL: repoURL + "/index.yaml",
+		})
+	}
+	return document
+}
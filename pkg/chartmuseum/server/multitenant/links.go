@@ -0,0 +1,152 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultDownloadLinkTTL is how long download links are valid without ?ttl
+	defaultDownloadLinkTTL = time.Hour
+)
+
+type (
+	// downloadLink is the payload of the token of a download link, signed with the secret of the server
+	downloadLink struct {
+		Repo     string `json:"repo"`
+		Filename string `json:"file"`
+		Expires  int64  `json:"exp"`
+	}
+
+	downloadLinkResponse struct {
+		URL     string    `json:"url"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+// signDownloadLink returns the token of a download link, its payload and the signature of the
+// payload as base64url
+func signDownloadLink(secret []byte, link downloadLink) string {
+	payload, _ := json.Marshal(link)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDownloadLink returns the download link of a token signed with secret and not expired
+func verifyDownloadLink(secret []byte, token string, now time.Time) (*downloadLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid download link")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid download link")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid download link")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid download link")
+	}
+	var link downloadLink
+	if err := json.Unmarshal(payload, &link); err != nil {
+		return nil, fmt.Errorf("invalid download link")
+	}
+	if !now.Before(time.Unix(link.Expires, 0)) {
+		return nil, fmt.Errorf("download link expired")
+	}
+	return &link, nil
+}
+
+// getChartVersionLinkRequestHandler returns a url downloading a chart version without credentials
+// until ?ttl is over, an hour by default
+func (server *MultiTenantServer) getChartVersionLinkRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	ttl := defaultDownloadLinkTTL
+	if ttlString, ok := c.GetQuery("ttl"); ok {
+		var err error
+		if ttl, err = time.ParseDuration(ttlString); err != nil || ttl <= 0 {
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "ttl is not a valid positive duration, e.g. 1h")
+			return
+		}
+	}
+	if server.DownloadLinkMaxTTL > 0 && ttl > server.DownloadLinkMaxTTL {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("ttl is above the max of %s", server.DownloadLinkMaxTTL))
+		return
+	}
+
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	token := signDownloadLink(server.DownloadLinkSecret, downloadLink{
+		Repo:     repo,
+		Filename: filename,
+		Expires:  expires.Unix(),
+	})
+	log(cm_logger.InfoLevel, "Download link created",
+		"repo", repo,
+		"package", filename,
+		"user", cm_router.RequestUser(c.Request),
+		"expires", expires.UTC().Format(time.RFC3339),
+	)
+
+	scheme, host := server.requestSchemeHost(c)
+	c.JSON(200, downloadLinkResponse{
+		URL:     scheme + "://" + host + server.Router.ContextPath + pathutil.Join("/", repo, "links", token, filename),
+		Expires: expires.UTC(),
+	})
+}
+
+// getDownloadLinkRequestHandler serves the chart package of a download link, its token standing
+// for the credentials of the request
+func (server *MultiTenantServer) getDownloadLinkRequestHandler(c *gin.Context) {
+	link, err := verifyDownloadLink(server.DownloadLinkSecret, c.Param("token"), time.Now())
+	if err != nil {
+		cm_router.WriteError(c, http.StatusForbidden, cm_router.ErrorCodeForbidden, err.Error())
+		return
+	}
+	if c.Param("repo") != link.Repo || c.Param("filename") != link.Filename {
+		cm_router.WriteError(c, http.StatusForbidden, cm_router.ErrorCodeForbidden, "invalid download link")
+		return
+	}
+	server.serveStorageObject(c, link.Repo, link.Filename)
+}
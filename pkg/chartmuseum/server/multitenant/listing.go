@@ -0,0 +1,215 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"io/ioutil"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// storagePageSize is the number of objects listed per call to backends listing a page at a time
+const storagePageSize = 1000
+
+type (
+	// pagedBackend is implemented by storage backends listing the objects directly under a prefix a
+	// page at a time, along with its directories, as object stores do with a delimiter. The Backend
+	// interface of chartmuseum/storage lists every object under a prefix at once, nested ones included
+	pagedBackend interface {
+		// ListObjectsPage lists up to limit objects directly under prefix and the names of the
+		// directories of prefix, from token, "" for the first page. NextToken is "" on the last page
+		ListObjectsPage(ctx context.Context, prefix string, token string, limit int) (*objectsPage, error)
+	}
+
+	objectsPage struct {
+		Objects     []cm_storage.Object
+		Directories []string
+		NextToken   string
+	}
+
+	// amazonPagedBackend lists Amazon S3 buckets, and compatible ones, with ListObjectsV2 and the / delimiter,
+	// and reads them until their context is done, see contextBackend
+	amazonPagedBackend struct {
+		*cm_storage.AmazonS3Backend
+	}
+
+	// observedPagedBackend records the latency and errors of each page listed, and a span when traced
+	observedPagedBackend struct {
+		pagedBackend
+		tracer trace.Tracer
+	}
+)
+
+// withPagedListing returns backend listing a page at a time, for the backends this is implemented for.
+// Listing the local filesystem is already limited to a directory, see storagePaths
+func withPagedListing(backend cm_storage.Backend) cm_storage.Backend {
+	if amazon, ok := backend.(*cm_storage.AmazonS3Backend); ok {
+		return &amazonPagedBackend{AmazonS3Backend: amazon}
+	}
+	return backend
+}
+
+// pagedStorage returns the storage backend listing a page at a time, or nil if it cannot
+func (server *MultiTenantServer) pagedStorage(ctx context.Context) pagedBackend {
+	backend := server.StorageBackend
+	if instrumented, ok := backend.(*instrumentedBackend); ok {
+		backend = instrumented.Backend
+	}
+	paged, ok := backend.(pagedBackend)
+	if !ok {
+		return nil
+	}
+	tracer := noopTracer
+	if trace.SpanFromContext(ctx).IsRecording() {
+		tracer = server.tracer()
+	}
+	return &observedPagedBackend{pagedBackend: paged, tracer: tracer}
+}
+
+// listPages calls fn with every page of the listing of prefix, until ctx is done
+func listPages(ctx context.Context, paged pagedBackend, prefix string, fn func(page *objectsPage)) error {
+	token := ""
+	for {
+		page, err := paged.ListObjectsPage(ctx, prefix, token, storagePageSize)
+		if err != nil {
+			return err
+		}
+		fn(page)
+		if page.NextToken == "" {
+			return nil
+		}
+		token = page.NextToken
+	}
+}
+
+func (backend *amazonPagedBackend) ListObjectsPage(ctx context.Context, prefix string, token string, limit int) (*objectsPage, error) {
+	keyPrefix := pathutil.Join(backend.Prefix, prefix)
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(backend.Bucket),
+		Prefix:    aws.String(keyPrefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(int64(limit)),
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	output, err := backend.Client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &objectsPage{}
+	for _, object := range output.Contents {
+		name := strings.TrimPrefix(aws.StringValue(object.Key), keyPrefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		page.Objects = append(page.Objects, cm_storage.Object{
+			Path:         name,
+			Content:      []byte{},
+			LastModified: aws.TimeValue(object.LastModified),
+		})
+	}
+	for _, commonPrefix := range output.CommonPrefixes {
+		if name := strings.Trim(strings.TrimPrefix(aws.StringValue(commonPrefix.Prefix), keyPrefix), "/"); name != "" {
+			page.Directories = append(page.Directories, name)
+		}
+	}
+	if aws.BoolValue(output.IsTruncated) {
+		page.NextToken = aws.StringValue(output.NextContinuationToken)
+	}
+	return page, nil
+}
+
+// ListObjectsContext lists the objects under prefix as ListObjects of AmazonS3Backend does
+func (backend *amazonPagedBackend) ListObjectsContext(ctx context.Context, prefix string) ([]cm_storage.Object, error) {
+	var objects []cm_storage.Object
+	prefix = pathutil.Join(backend.Prefix, prefix)
+	input := &s3.ListObjectsInput{
+		Bucket: aws.String(backend.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		output, err := backend.Client.ListObjectsWithContext(ctx, input)
+		if err != nil {
+			return objects, err
+		}
+		for _, object := range output.Contents {
+			name := aws.StringValue(object.Key)
+			if prefix != "" {
+				name = strings.Replace(name, prefix+"/", "", 1)
+			}
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			objects = append(objects, cm_storage.Object{
+				Path:         name,
+				Content:      []byte{},
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		if !aws.BoolValue(output.IsTruncated) || len(output.Contents) == 0 {
+			return objects, nil
+		}
+		input.Marker = output.Contents[len(output.Contents)-1].Key
+	}
+}
+
+// GetObjectContext reads an object as GetObject of AmazonS3Backend does
+func (backend *amazonPagedBackend) GetObjectContext(ctx context.Context, path string) (cm_storage.Object, error) {
+	object := cm_storage.Object{Path: path}
+	output, err := backend.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(backend.Bucket),
+		Key:    aws.String(pathutil.Join(backend.Prefix, path)),
+	})
+	if err != nil {
+		return object, err
+	}
+	defer output.Body.Close()
+	object.Content, err = ioutil.ReadAll(output.Body)
+	if err != nil {
+		return object, err
+	}
+	object.LastModified = aws.TimeValue(output.LastModified)
+	return object, nil
+}
+
+func (backend *observedPagedBackend) ListObjectsPage(ctx context.Context, prefix string, token string, limit int) (*objectsPage, error) {
+	_, span := backend.tracer.Start(ctx, "storage ListObjectsPage", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.prefix", prefix)),
+	)
+	defer span.End()
+	start := time.Now()
+	page, err := backend.pagedBackend.ListObjectsPage(ctx, prefix, token, limit)
+	observeStorageOperation("ListObjectsPage", start, err)
+	if page != nil {
+		span.SetAttributes(attribute.Int("storage.objects", len(page.Objects)))
+	}
+	recordError(span, err)
+	return page, err
+}
@@ -0,0 +1,80 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lock locks name with the Locker shared by the replicas of the server, waiting LockTimeout at most.
+// Without a Locker, the writes of a single replica need no lock
+func (server *MultiTenantServer) lock(ctx context.Context, name string) (func(), error) {
+	if server.Locker == nil {
+		return func() {}, nil
+	}
+	if server.LockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, server.LockTimeout)
+		defer cancel()
+	}
+	return server.Locker.Lock(ctx, name)
+}
+
+// lockRepos locks several repos, in the order of their names so that requests locking the same
+// repos never wait on each other, and returns a function unlocking them all
+func (server *MultiTenantServer) lockRepos(ctx context.Context, repos ...string) (func(), error) {
+	names := make([]string, len(repos))
+	for i, repo := range repos {
+		names[i] = "repo/" + repo
+	}
+	sort.Strings(names)
+	var unlocks []func()
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, name := range names {
+		unlock, err := server.lock(ctx, name)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// lockRepoWrites serializes the requests writing to a repo across replicas, so that overwrite checks,
+// uploads and deletes of one replica never interleave with those of another
+func (server *MultiTenantServer) lockRepoWrites(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		unlock, err := server.lockRepos(c.Request.Context(), c.Param("repo"))
+		if err != nil {
+			cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeTimeout, err.Error())
+			return
+		}
+		defer unlock()
+		handler(c)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// logLevelResource is how the log level is sent to and returned by the log level api
+	logLevelResource struct {
+		Level string `json:"level"`
+	}
+)
+
+func (server *MultiTenantServer) getLogLevelRequestHandler(c *gin.Context) {
+	c.JSON(200, logLevelResource{Level: server.Logger.Level.String()})
+}
+
+func (server *MultiTenantServer) putLogLevelRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	resource := logLevelResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("invalid log level: %s", err))
+		return
+	}
+	if err := server.Logger.SetLevel(resource.Level); err != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	// logged at warn so that it is seen whatever the new level
+	log(cm_logger.WarnLevel, "Log level changed",
+		"level", server.Logger.Level.String(),
+	)
+	server.getLogLevelRequestHandler(c)
+}
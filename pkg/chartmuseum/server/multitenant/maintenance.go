@@ -0,0 +1,110 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaintenanceMessage = "server is in maintenance mode, writes are disabled"
+
+type (
+	// maintenanceMode rejects writes, e.g. while storage is migrated or backed up, and keeps serving reads
+	maintenanceMode struct {
+		lock    *sync.RWMutex
+		enabled bool
+		message string
+	}
+
+	// maintenanceResource is how the maintenance mode is sent to and returned by the maintenance api
+	maintenanceResource struct {
+		Enabled *bool  `json:"enabled"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func newMaintenanceMode() *maintenanceMode {
+	return &maintenanceMode{lock: &sync.RWMutex{}}
+}
+
+// inMaintenance reports whether writes are disabled, along with the message returned for them
+func (server *MultiTenantServer) inMaintenance() (bool, string) {
+	server.Maintenance.lock.RLock()
+	defer server.Maintenance.lock.RUnlock()
+	return server.Maintenance.enabled, server.Maintenance.message
+}
+
+func (server *MultiTenantServer) setMaintenance(enabled bool, message string) {
+	if message == "" {
+		message = server.maintenanceMessage()
+	}
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	server.Maintenance.lock.Lock()
+	defer server.Maintenance.lock.Unlock()
+	server.Maintenance.enabled = enabled
+	server.Maintenance.message = message
+}
+
+// rejectInMaintenance wraps the handler of a route writing to storage or to the tenant config
+func (server *MultiTenantServer) rejectInMaintenance(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if enabled, message := server.inMaintenance(); enabled {
+			cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeMaintenance, message)
+			return
+		}
+		handler(c)
+	}
+}
+
+func (server *MultiTenantServer) getMaintenanceRequestHandler(c *gin.Context) {
+	enabled, message := server.inMaintenance()
+	resource := maintenanceResource{Enabled: &enabled}
+	if enabled {
+		resource.Message = message
+	}
+	c.JSON(200, resource)
+}
+
+func (server *MultiTenantServer) putMaintenanceRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	resource := maintenanceResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("invalid maintenance mode: %s", err))
+		return
+	}
+	if resource.Enabled == nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "enabled must be set")
+		return
+	}
+	server.setMaintenance(*resource.Enabled, resource.Message)
+
+	if *resource.Enabled {
+		log(cm_logger.InfoLevel, "Maintenance mode enabled, rejecting writes")
+	} else {
+		log(cm_logger.InfoLevel, "Maintenance mode disabled")
+	}
+	server.getMaintenanceRequestHandler(c)
+}
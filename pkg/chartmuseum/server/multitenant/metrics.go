@@ -0,0 +1,145 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"time"
+
+	"helm.sh/chartmuseum/pkg/tenant"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Latency of calls to the storage backend
+	storageOperationHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "chartmuseum",
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Time taken by storage backend operations",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"operation"},
+	)
+	// Failed calls to the storage backend
+	storageOperationErrorCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "chartmuseum",
+			Name:      "storage_operation_errors_total",
+			Help:      "Total number of failed storage backend operations, objects not found included",
+		},
+		[]string{"operation"},
+	)
+	// Lookups of the index of a repo in the cache store
+	cacheLookupCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "chartmuseum",
+			Name:      "cache_lookups_total",
+			Help:      "Total number of lookups of repo indexes in the cache store, by result (hit or miss)",
+		},
+		[]string{"repo", "result"},
+	)
+	// Indexes dropped from memory, see evictCacheEntries
+	cacheEvictionCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "chartmuseum",
+			Name:      "cache_evictions_total",
+			Help:      "Total number of repo indexes dropped from memory, by reason (ttl or size)",
+		},
+		[]string{"reason"},
+	)
+	// Indexes held in memory
+	cacheEntriesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "chartmuseum",
+			Name:      "cache_entries",
+			Help:      "Number of repo indexes held in memory",
+		},
+	)
+	// Size of the charts and provenance files uploaded
+	uploadSizeHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "chartmuseum",
+			Name:      "upload_size_bytes",
+			Help:      "Size of the files uploaded, by type (chart, provenance or sbom)",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+		[]string{"repo", "type"},
+	)
+)
+
+type (
+	// instrumentedBackend records the latency and errors of each call to the storage backend
+	instrumentedBackend struct {
+		cm_storage.Backend
+	}
+)
+
+func init() {
+	prometheus.MustRegister(storageOperationHistogramVec, storageOperationErrorCounterVec, cacheLookupCounterVec,
+		cacheEvictionCounterVec, cacheEntriesGauge, uploadSizeHistogramVec)
+}
+
+func observeCacheLookup(repo string, hit bool) {
+	label, _ := tenant.MetricsLabel(repo)
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookupCounterVec.WithLabelValues(label, result).Inc()
+}
+
+func observeUpload(repo string, fileType string, size int) {
+	label, _ := tenant.MetricsLabel(repo)
+	uploadSizeHistogramVec.WithLabelValues(label, fileType).Observe(float64(size))
+}
+
+func observeStorageOperation(operation string, start time.Time, err error) {
+	storageOperationHistogramVec.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storageOperationErrorCounterVec.WithLabelValues(operation).Inc()
+	}
+}
+
+func (backend *instrumentedBackend) ListObjects(prefix string) ([]cm_storage.Object, error) {
+	start := time.Now()
+	objects, err := backend.Backend.ListObjects(prefix)
+	observeStorageOperation("ListObjects", start, err)
+	return objects, err
+}
+
+func (backend *instrumentedBackend) GetObject(path string) (cm_storage.Object, error) {
+	start := time.Now()
+	object, err := backend.Backend.GetObject(path)
+	observeStorageOperation("GetObject", start, err)
+	return object, err
+}
+
+func (backend *instrumentedBackend) PutObject(path string, content []byte) error {
+	start := time.Now()
+	err := backend.Backend.PutObject(path, content)
+	observeStorageOperation("PutObject", start, err)
+	return err
+}
+
+func (backend *instrumentedBackend) DeleteObject(path string) error {
+	start := time.Now()
+	err := backend.Backend.DeleteObject(path)
+	observeStorageOperation("DeleteObject", start, err)
+	return err
+}
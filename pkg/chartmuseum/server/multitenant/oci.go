@@ -0,0 +1,656 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	ociManifestMediaType      = "application/vnd.oci.image.manifest.v1+json"
+	helmConfigMediaType       = "application/vnd.cncf.helm.config.v1+json"
+	helmChartContentMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	helmProvenanceMediaType   = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+
+	// blobs pushed before their manifest, and the manifests pushed, are kept under this
+	// directory of each repo. Their names have no chart extension, so they are never indexed
+	ociStorageDirectory = ".oci"
+)
+
+var (
+	validOCIDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+type (
+	// ociDescriptor references a blob from a manifest
+	ociDescriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int64             `json:"size"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	// ociBlob is where a blob of a manifest served for a chart version is found: the config is
+	// generated from the chart metadata, the layers are the chart files in storage
+	ociBlob struct {
+		mediaType string
+		path      string
+		content   []byte
+	}
+
+	// ociManifest is an OCI image manifest holding a Helm chart
+	ociManifest struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType,omitempty"`
+		Config        ociDescriptor     `json:"config"`
+		Layers        []ociDescriptor   `json:"layers"`
+		Annotations   map[string]string `json:"annotations,omitempty"`
+	}
+)
+
+func ociDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ociTag returns the tag of a chart version, as OCI tags cannot contain "+"
+func ociTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
+
+func ociBlobPath(repo string, digest string) string {
+	return pathutil.Join(repo, ociStorageDirectory, "blobs", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func ociUploadPath(repo string, uuid string) string {
+	return pathutil.Join(repo, ociStorageDirectory, "uploads", uuid)
+}
+
+func ociManifestPath(repo string, name string, version string) string {
+	return pathutil.Join(repo, ociStorageDirectory, "manifests", fmt.Sprintf("%s-%s.json", name, version))
+}
+
+// ociError responds in the error format of the OCI distribution spec
+func ociError(c *gin.Context, status int, code string, message string) {
+	c.JSON(status, gin.H{"errors": []gin.H{{"code": code, "message": message}}})
+}
+
+func (server *MultiTenantServer) getOCIBaseRequestHandler(c *gin.Context) {
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+	c.JSON(200, gin.H{})
+}
+
+func (server *MultiTenantServer) getOCITagsRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersions, err := server.getChart(requestContext(c), log, repo, name)
+	if err != nil {
+		ociError(c, err.Status, "NAME_UNKNOWN", err.Message)
+		return
+	}
+
+	tags := make([]string, 0, len(chartVersions))
+	for _, chartVersion := range chartVersions {
+		tags = append(tags, ociTag(chartVersion.Version))
+	}
+	sort.Strings(tags)
+	if last := c.Query("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	if n, convErr := strconv.Atoi(c.Query("n")); convErr == nil && n >= 0 && n < len(tags) {
+		tags = tags[:n]
+	}
+	c.JSON(200, gin.H{"name": pathutil.Join(repo, name), "tags": tags})
+}
+
+func (server *MultiTenantServer) getOCIManifestRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	log := server.Logger.ContextLoggingFn(c)
+	_, content, err := server.findOCIManifest(requestContext(c), log, repo, name, c.Param("reference"))
+	if err != nil {
+		ociError(c, err.Status, "MANIFEST_UNKNOWN", err.Message)
+		return
+	}
+	c.Header("Docker-Content-Digest", ociDigest(content))
+	if c.Request.Method == http.MethodHead {
+		c.Header("Content-Type", ociManifestMediaType)
+		c.Header("Content-Length", strconv.Itoa(len(content)))
+		c.Status(200)
+		return
+	}
+	c.Data(200, ociManifestMediaType, content)
+}
+
+func (server *MultiTenantServer) getOCIBlobRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	digest := c.Param("digest")
+	log := server.Logger.ContextLoggingFn(c)
+	content, mediaType, err := server.findOCIBlob(requestContext(c), log, repo, name, digest)
+	if err != nil {
+		ociError(c, err.Status, "BLOB_UNKNOWN", err.Message)
+		return
+	}
+	c.Header("Docker-Content-Digest", digest)
+	if c.Request.Method == http.MethodHead {
+		c.Header("Content-Type", mediaType)
+		c.Header("Content-Length", strconv.Itoa(len(content)))
+		c.Status(200)
+		return
+	}
+	c.Data(200, mediaType, content)
+}
+
+func (server *MultiTenantServer) postOCIUploadRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	ctx := requestContext(c)
+	log := server.Logger.ContextLoggingFn(c)
+	uuid, err := newOCIUploadUUID()
+	if err != nil {
+		ociError(c, 500, "UNKNOWN", err.Error())
+		return
+	}
+
+	content, readErr := c.GetRawData()
+	if readErr != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large"
+		}
+		ociError(c, 500, "UNKNOWN", readErr.Error())
+		return
+	}
+
+	// a digest makes this a monolithic upload, completed with this single request
+	if digest, ok := c.GetQuery("digest"); ok {
+		if httpErr := server.saveOCIBlob(ctx, log, repo, digest, content); httpErr != nil {
+			ociError(c, httpErr.Status, "DIGEST_INVALID", httpErr.Message)
+			return
+		}
+		c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/uploads/")+"/"+digest)
+		c.Header("Docker-Content-Digest", digest)
+		c.Status(201)
+		return
+	}
+
+	if putErr := server.storage(ctx).PutObject(ociUploadPath(repo, uuid), content); putErr != nil {
+		ociError(c, 500, "UNKNOWN", putErr.Error())
+		return
+	}
+	c.Header("Location", c.Request.URL.Path+uuid)
+	c.Header("Docker-Upload-UUID", uuid)
+	c.Header("Range", ociRange(len(content)))
+	c.Status(202)
+}
+
+// patchOCIUploadRequestHandler appends a chunk to an upload
+func (server *MultiTenantServer) patchOCIUploadRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	uuid := c.Param("uuid")
+	content, err := server.appendOCIUpload(c, repo, uuid)
+	if err != nil {
+		ociError(c, err.Status, "BLOB_UPLOAD_UNKNOWN", err.Message)
+		return
+	}
+	if putErr := server.storage(requestContext(c)).PutObject(ociUploadPath(repo, uuid), content); putErr != nil {
+		ociError(c, 500, "UNKNOWN", putErr.Error())
+		return
+	}
+	c.Header("Location", c.Request.URL.Path)
+	c.Header("Docker-Upload-UUID", uuid)
+	c.Header("Range", ociRange(len(content)))
+	c.Status(202)
+}
+
+// putOCIUploadRequestHandler completes an upload, with an optional last chunk
+func (server *MultiTenantServer) putOCIUploadRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	ctx := requestContext(c)
+	uuid := c.Param("uuid")
+	log := server.Logger.ContextLoggingFn(c)
+	content, err := server.appendOCIUpload(c, repo, uuid)
+	if err != nil {
+		ociError(c, err.Status, "BLOB_UPLOAD_UNKNOWN", err.Message)
+		return
+	}
+	digest := c.Query("digest")
+	if err := server.saveOCIBlob(ctx, log, repo, digest, content); err != nil {
+		ociError(c, err.Status, "DIGEST_INVALID", err.Message)
+		return
+	}
+	server.storage(ctx).DeleteObject(ociUploadPath(repo, uuid))
+
+	uploadsPath := pathutil.Dir(c.Request.URL.Path)
+	c.Header("Location", pathutil.Dir(uploadsPath)+"/"+digest)
+	c.Header("Docker-Content-Digest", digest)
+	c.Status(201)
+}
+
+// putOCIManifestRequestHandler adds the chart of a pushed manifest to the repo, as if it
+// was uploaded with POST /api/:repo/charts
+func (server *MultiTenantServer) putOCIManifestRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	ctx := requestContext(c)
+	name := c.Param("name")
+	reference := c.Param("reference")
+	log := server.Logger.ContextLoggingFn(c)
+	content, readErr := c.GetRawData()
+	if readErr != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large"
+		}
+		ociError(c, 500, "UNKNOWN", readErr.Error())
+		return
+	}
+
+	manifest := ociManifest{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		ociError(c, 400, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	if manifest.Config.MediaType != helmConfigMediaType {
+		ociError(c, 400, "MANIFEST_INVALID", "only Helm charts can be pushed")
+		return
+	}
+	var chartContent, provContent []byte
+	for _, layer := range manifest.Layers {
+		var layerContent []byte
+		if layer.MediaType == helmChartContentMediaType || layer.MediaType == helmProvenanceMediaType {
+			var err *HTTPError
+			layerContent, _, err = server.findOCIBlob(ctx, log, repo, name, layer.Digest)
+			if err != nil {
+				ociError(c, 400, "MANIFEST_BLOB_UNKNOWN", fmt.Sprintf("%s: %s", layer.Digest, err.Message))
+				return
+			}
+		}
+		switch layer.MediaType {
+		case helmChartContentMediaType:
+			chartContent = layerContent
+		case helmProvenanceMediaType:
+			provContent = layerContent
+		}
+	}
+	if chartContent == nil {
+		ociError(c, 400, "MANIFEST_INVALID", "manifest has no chart layer")
+		return
+	}
+
+	chartName, chartVersion, err := extractFromChart(chartContent)
+	if err != nil {
+		ociError(c, 400, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	if chartName != name {
+		ociError(c, 400, "NAME_INVALID", fmt.Sprintf("chart %s pushed as %s", chartName, name))
+		return
+	}
+	if !validOCIDigest.MatchString(reference) && reference != ociTag(chartVersion) {
+		ociError(c, 400, "TAG_INVALID", fmt.Sprintf("chart version %s pushed with tag %s", chartVersion, reference))
+		return
+	}
+
+	// the same rules as uploads with POST /api/:repo/charts
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartName, chartVersion)
+	if err := server.checkChartOwner(c, repo, chartName); err != nil {
+		ociError(c, err.Status, "DENIED", err.Message)
+		return
+	}
+	if err := server.checkOverwriteProtection(c, repo, filename, false); err != nil {
+		ociError(c, err.Status, "DENIED", err.Message)
+		return
+	}
+	if provContent != nil {
+		provFilename := cm_repo.ProvenanceFilenameFromNameVersion(chartName, chartVersion)
+		if err := server.checkOverwriteProtection(c, repo, provFilename, false); err != nil {
+			ociError(c, err.Status, "DENIED", err.Message)
+			return
+		}
+	}
+	unlock, digestErr := server.checkExpectedDigest(c, repo, filename)
+	if digestErr != nil {
+		ociError(c, digestErr.Status, "DENIED", digestErr.Message)
+		return
+	}
+	defer unlock()
+
+	action, changed, httpErr := server.uploadOCIChart(ctx, log, repo, chartContent, provContent)
+	if httpErr != nil {
+		ociError(c, httpErr.Status, "DENIED", httpErr.Message)
+		return
+	}
+	if err := server.storage(ctx).PutObject(ociManifestPath(repo, chartName, chartVersion), content); err != nil {
+		ociError(c, 500, "UNKNOWN", err.Error())
+		return
+	}
+	for _, layer := range manifest.Layers {
+		// layers are now kept as the chart files, the config stays a blob
+		server.storage(ctx).DeleteObject(ociBlobPath(repo, layer.Digest))
+	}
+
+	if changed {
+		chart, chartErr := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+			Path:         pathutil.Join(repo, filename),
+			Content:      chartContent,
+			LastModified: time.Now()})
+		if chartErr != nil {
+			log(cm_logger.ErrorLevel, "cannot get chart from content", zap.Error(chartErr))
+		}
+		server.emitEvent(c, repo, action, chart)
+		server.recordChartOwner(c, repo, chartName)
+	}
+
+	c.Header("Location", c.Request.URL.Path)
+	c.Header("Docker-Content-Digest", ociDigest(content))
+	c.Status(201)
+}
+
+// uploadOCIChart stores a pushed chart and its provenance file. Pushing the same chart
+// again is not a conflict, it is reported as unchanged instead
+func (server *MultiTenantServer) uploadOCIChart(ctx context.Context, log cm_logger.LoggingFn, repo string, chartContent []byte, provContent []byte) (operationType, bool, *HTTPError) {
+	action := addChart
+	changed := true
+	filename, err := server.uploadChartPackage(log, repo, chartContent, false)
+	if err != nil && err.Status == http.StatusConflict {
+		if err.Message == "" {
+			action = updateChart
+		} else if existing, getErr := server.storage(ctx).GetObject(pathutil.Join(repo, filename)); getErr == nil && bytes.Equal(existing.Content, chartContent) {
+			changed = false
+		} else {
+			return action, false, err
+		}
+	} else if err != nil {
+		return action, false, err
+	}
+
+	if provContent != nil {
+		if err := server.uploadProvenanceFile(log, repo, provContent, false); err != nil {
+			provFilename := strings.TrimSuffix(filename, cm_repo.ChartPackageFileExtension) + cm_repo.ProvenanceFileExtension
+			existing, getErr := server.storage(ctx).GetObject(pathutil.Join(repo, provFilename))
+			if err.Status != http.StatusConflict || getErr != nil || !bytes.Equal(existing.Content, provContent) {
+				return action, false, err
+			}
+		}
+	}
+	return action, changed, nil
+}
+
+// appendOCIUpload returns the content of an upload followed by the body of the request
+func (server *MultiTenantServer) appendOCIUpload(c *gin.Context, repo string, uuid string) ([]byte, *HTTPError) {
+	upload, err := server.storage(requestContext(c)).GetObject(ociUploadPath(repo, uuid))
+	if err != nil {
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "upload not found"}
+	}
+	content, err := c.GetRawData()
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error()}
+	}
+	return append(upload.Content, content...), nil
+}
+
+// saveOCIBlob keeps a blob until the manifest referencing it is pushed
+func (server *MultiTenantServer) saveOCIBlob(ctx context.Context, log cm_logger.LoggingFn, repo string, digest string, content []byte) *HTTPError {
+	if !validOCIDigest.MatchString(digest) {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "invalid digest, expected sha256:<hex>"}
+	}
+	if ociDigest(content) != digest {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "digest does not match content"}
+	}
+	if err := server.storage(ctx).PutObject(ociBlobPath(repo, digest), content); err != nil {
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	log(cm_logger.DebugLevel, "OCI blob saved",
+		"repo", repo,
+		"digest", digest,
+	)
+	return nil
+}
+
+// findOCIManifest returns the chart version and manifest of a tag or manifest digest
+func (server *MultiTenantServer) findOCIManifest(ctx context.Context, log cm_logger.LoggingFn, repo string, name string, reference string) (*helm_repo.ChartVersion, []byte, *HTTPError) {
+	chartVersions, err := server.getChart(ctx, log, repo, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	byDigest := validOCIDigest.MatchString(reference)
+	for _, chartVersion := range chartVersions {
+		if !byDigest && ociTag(chartVersion.Version) != reference {
+			continue
+		}
+		content, err := server.ociManifest(ctx, log, repo, chartVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !byDigest || ociDigest(content) == reference {
+			return chartVersion, content, nil
+		}
+	}
+	return nil, nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "manifest not found"}
+}
+
+// ociManifest returns the pushed manifest of a chart version, or generates one for charts
+// uploaded with the api or pushed again since
+func (server *MultiTenantServer) ociManifest(ctx context.Context, log cm_logger.LoggingFn, repo string, chartVersion *helm_repo.ChartVersion) ([]byte, *HTTPError) {
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+	chartObject, err := server.getStorageObject(ctx, log, repo, filename)
+	if err != nil {
+		return nil, err
+	}
+	chartDigest := ociDigest(chartObject.Content)
+
+	filePaths := map[string]string{
+		helmChartContentMediaType: pathutil.Join(repo, filename),
+		helmProvenanceMediaType:   pathutil.Join(repo, cm_repo.ProvenanceFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)),
+	}
+	if pushed, getErr := server.storage(ctx).GetObject(ociManifestPath(repo, chartVersion.Name, chartVersion.Version)); getErr == nil {
+		manifest := ociManifest{}
+		if json.Unmarshal(pushed.Content, &manifest) == nil {
+			for _, layer := range manifest.Layers {
+				if layer.MediaType == helmChartContentMediaType && layer.Digest == chartDigest {
+					// the config of pushed manifests stays a blob, found by its digest
+					server.recordOCIBlobs(repo, chartVersion, manifest, filePaths, nil)
+					return pushed.Content, nil
+				}
+			}
+		}
+	}
+
+	config, marshalErr := json.Marshal(chartVersion.Metadata)
+	if marshalErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, marshalErr.Error()}
+	}
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Config: ociDescriptor{
+			MediaType: helmConfigMediaType,
+			Digest:    ociDigest(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ociDescriptor{{
+			MediaType: helmChartContentMediaType,
+			Digest:    chartDigest,
+			Size:      int64(len(chartObject.Content)),
+		}},
+	}
+	provObject, getErr := server.storage(ctx).GetObject(filePaths[helmProvenanceMediaType])
+	if getErr == nil {
+		manifest.Layers = append(manifest.Layers, ociDescriptor{
+			MediaType: helmProvenanceMediaType,
+			Digest:    ociDigest(provObject.Content),
+			Size:      int64(len(provObject.Content)),
+		})
+	}
+	content, marshalErr := json.Marshal(manifest)
+	if marshalErr != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, marshalErr.Error()}
+	}
+	server.recordOCIBlobs(repo, chartVersion, manifest, filePaths, config)
+	return content, nil
+}
+
+// ociManifestKey identifies a chart version of a repo, along with its chart digest, so that
+// chart versions pushed again have their manifest served again
+func ociManifestKey(repo string, chartVersion *helm_repo.ChartVersion) string {
+	return pathutil.Join(repo, chartVersion.Name, chartVersion.Version) + "@" + chartVersion.Digest
+}
+
+// recordOCIBlobs keeps where the blobs of a manifest served for a chart version are found, as
+// clients get the blobs of a manifest after the manifest itself
+func (server *MultiTenantServer) recordOCIBlobs(repo string, chartVersion *helm_repo.ChartVersion, manifest ociManifest, filePaths map[string]string, config []byte) {
+	server.OCILock.Lock()
+	defer server.OCILock.Unlock()
+	if config != nil {
+		server.OCIBlobs[repo+"@"+manifest.Config.Digest] = &ociBlob{mediaType: helmConfigMediaType, content: config}
+	}
+	for _, layer := range manifest.Layers {
+		if path, ok := filePaths[layer.MediaType]; ok {
+			server.OCIBlobs[repo+"@"+layer.Digest] = &ociBlob{mediaType: layer.MediaType, path: path}
+		}
+	}
+	server.OCIManifests[ociManifestKey(repo, chartVersion)] = true
+}
+
+// evictOCIBlobs forgets the blobs recorded for a repo and the repos nested under it
+func (server *MultiTenantServer) evictOCIBlobs(name string) {
+	server.OCILock.Lock()
+	defer server.OCILock.Unlock()
+	for key := range server.OCIBlobs {
+		if strings.HasPrefix(key, name+"@") || strings.HasPrefix(key, name+"/") {
+			delete(server.OCIBlobs, key)
+		}
+	}
+	for key := range server.OCIManifests {
+		if strings.HasPrefix(key, name+"/") {
+			delete(server.OCIManifests, key)
+		}
+	}
+}
+
+// readOCIBlob returns a blob recorded by recordOCIBlobs, forgetting blobs whose file changed since
+func (server *MultiTenantServer) readOCIBlob(ctx context.Context, repo string, digest string) ([]byte, string, bool) {
+	key := repo + "@" + digest
+	server.OCILock.Lock()
+	blob, ok := server.OCIBlobs[key]
+	server.OCILock.Unlock()
+	if !ok {
+		return nil, "", false
+	}
+	if blob.content != nil {
+		return blob.content, blob.mediaType, true
+	}
+	object, err := server.storage(ctx).GetObject(blob.path)
+	if err == nil && ociDigest(object.Content) == digest {
+		return object.Content, blob.mediaType, true
+	}
+	server.OCILock.Lock()
+	delete(server.OCIBlobs, key)
+	server.OCILock.Unlock()
+	return nil, "", false
+}
+
+// findOCIBlob returns a blob pushed for a manifest, or the config, chart package or provenance
+// file of a version of the chart. Chart packages are found with the digests of the index, other
+// blobs with those recorded when serving manifests. Only the manifests of the chart versions not
+// served yet are generated to find a missing blob, rather than reading every version each time
+func (server *MultiTenantServer) findOCIBlob(ctx context.Context, log cm_logger.LoggingFn, repo string, name string, digest string) ([]byte, string, *HTTPError) {
+	if !validOCIDigest.MatchString(digest) {
+		return nil, "", &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "invalid digest, expected sha256:<hex>"}
+	}
+	if blob, err := server.storage(ctx).GetObject(ociBlobPath(repo, digest)); err == nil {
+		return blob.Content, "application/octet-stream", nil
+	}
+	if content, mediaType, ok := server.readOCIBlob(ctx, repo, digest); ok {
+		return content, mediaType, nil
+	}
+
+	chartVersions, err := server.getChart(ctx, log, repo, name)
+	if err != nil {
+		return nil, "", &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "blob not found"}
+	}
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	for _, chartVersion := range chartVersions {
+		if chartVersion.Digest != hexDigest {
+			continue
+		}
+		filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+		if object, err := server.storage(ctx).GetObject(pathutil.Join(repo, filename)); err == nil && ociDigest(object.Content) == digest {
+			return object.Content, helmChartContentMediaType, nil
+		}
+	}
+
+	found := false
+	for _, chartVersion := range chartVersions {
+		server.OCILock.Lock()
+		served := server.OCIManifests[ociManifestKey(repo, chartVersion)]
+		server.OCILock.Unlock()
+		if served {
+			continue
+		}
+		if _, err := server.ociManifest(ctx, log, repo, chartVersion); err == nil {
+			found = true
+		}
+	}
+	if found {
+		if content, mediaType, ok := server.readOCIBlob(ctx, repo, digest); ok {
+			return content, mediaType, nil
+		}
+	}
+	return nil, "", &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "blob not found"}
+}
+
+func newOCIUploadUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ociRange returns the Range header of an upload holding size bytes
+func ociRange(size int) string {
+	if size == 0 {
+		return "0-0"
+	}
+	return fmt.Sprintf("0-%d", size-1)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strings"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (server *MultiTenantServer) getChartOwnersRequestHandler(c *gin.Context) {
+	owners, err := server.readChartOwners(c.Param("repo"), c.Param("name"))
+	if err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+	c.JSON(200, owners)
+}
+
+// putChartOwnersRequestHandler replaces the owners of a chart name, which go back to being claimed
+// by the next upload once emptied. Only owners and admins may change them with --restrict-to-owners
+func (server *MultiTenantServer) putChartOwnersRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	content, getContentErr := c.GetRawData()
+	if getContentErr != nil {
+		if len(c.Errors) > 0 {
+			return // this is a "request too large"
+		}
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, fmt.Sprintf("%s", getContentErr))
+		return
+	}
+	owners, err := cm_repo.ChartOwnersFromContent(content)
+	if err != nil {
+		cm_router.WriteError(c, http.StatusBadRequest, cm_router.ErrorCodeBadRequest, err.Error())
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	server.OwnersLock.Lock()
+	defer server.OwnersLock.Unlock()
+	if err := server.writeChartOwners(log, repo, name, owners); err != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+	c.JSON(200, owners)
+}
+
+// restrictToOwners wraps the handler of a route changing a chart, so that only its owners and
+// the admins may use it
+func (server *MultiTenantServer) restrictToOwners(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := server.checkChartOwner(c, c.Param("repo"), c.Param("name")); err != nil {
+			writeError(c, err)
+			return
+		}
+		handler(c)
+	}
+}
+
+// checkChartOwner checks, with --restrict-to-owners, that the user of a request may change a chart
+// of a repo: one of its owners, an admin, or anyone while the chart has no owners
+func (server *MultiTenantServer) checkChartOwner(c *gin.Context, repo string, name string) *HTTPError {
+	if !server.RestrictToOwners || name == "" {
+		return nil
+	}
+	user := cm_router.RequestUser(c.Request)
+	if user != "" && server.ChartAdmins[user] {
+		return nil
+	}
+	owners, err := server.readChartOwners(repo, name)
+	if err != nil {
+		return &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error()}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	for _, owner := range owners {
+		if owner == user {
+			return nil
+		}
+	}
+	return &HTTPError{http.StatusForbidden, cm_router.ErrorCodeForbidden, fmt.Sprintf("chart %s is owned by other users", name)}
+}
+
+// recordChartOwner records the user of a request having uploaded a chart as its owner,
+// unless the chart already has owners
+func (server *MultiTenantServer) recordChartOwner(c *gin.Context, repo string, name string) {
+	if !server.ChartOwners || name == "" {
+		return
+	}
+	user := cm_router.RequestUser(c.Request)
+	if user == "" {
+		return
+	}
+	log := server.Logger.ContextLoggingFn(c)
+
+	// the first upload of a chart claims it, even when racing with another
+	server.OwnersLock.Lock()
+	defer server.OwnersLock.Unlock()
+	owners, err := server.readChartOwners(repo, name)
+	if err != nil {
+		log(cm_logger.WarnLevel, "Could not read chart owners",
+			"repo", repo,
+			"name", name,
+			"error", err.Error(),
+		)
+		return
+	}
+	if len(owners) > 0 {
+		return
+	}
+	if err := server.writeChartOwners(log, repo, name, []string{user}); err != nil {
+		log(cm_logger.WarnLevel, "Could not record chart owner",
+			"repo", repo,
+			"name", name,
+			"error", err.Error(),
+		)
+	}
+}
+
+// chartNameFromFilename returns the chart name of a chart package or provenance file
+func chartNameFromFilename(filename string) string {
+	name, _ := cm_repo.ChartNameVersionFromPackageFilename(strings.TrimSuffix(filename, ".prov"))
+	return name
+}
+
+// readChartOwners returns the owners of a chart name of a repo, empty if it has none. Storage
+// errors are returned, for owned charts not to look like charts without owners
+func (server *MultiTenantServer) readChartOwners(repo string, name string) ([]string, error) {
+	filename := cm_repo.ChartOwnersFilenameFromName(name)
+	object, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	if err != nil {
+		if server.objectNotFound(repo, filename, err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return cm_repo.ChartOwnersFromContent(object.Content)
+}
+
+func (server *MultiTenantServer) writeChartOwners(log cm_logger.LoggingFn, repo string, name string, owners []string) error {
+	filename := pathutil.Join(repo, cm_repo.ChartOwnersFilenameFromName(name))
+	if len(owners) == 0 {
+		log(cm_logger.DebugLevel, "Deleting chart owners from storage",
+			"owners", filename,
+		)
+		server.StorageBackend.DeleteObject(filename) // ignore error here, may be no owners
+		return nil
+	}
+	content, _ := json.Marshal(owners)
+	log(cm_logger.DebugLevel, "Adding chart owners to storage",
+		"owners", filename,
+	)
+	return server.StorageBackend.PutObject(filename, content)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strings"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// expectedDigest returns the sha256 digest an upload expects the chart package it replaces to have,
+// as in the index, from ?expected-digest or an If-Match header. "*" expects any existing package
+func expectedDigest(c *gin.Context) string {
+	expected := c.Query("expected-digest")
+	if expected == "" {
+		expected = c.GetHeader("If-Match")
+	}
+	expected = strings.Trim(strings.TrimPrefix(strings.TrimSpace(expected), "W/"), `"`)
+	return strings.ToLower(strings.TrimPrefix(expected, "sha256:"))
+}
+
+// checkExpectedDigest fails an upload expecting a digest unless the chart package it replaces has it,
+// so that concurrent publishers do not overwrite each other's changes. Uploads expecting a digest are
+// serialized until the returned func is called, for the package not to change after the check
+func (server *MultiTenantServer) checkExpectedDigest(c *gin.Context, repo string, filename string) (func(), *HTTPError) {
+	expected := expectedDigest(c)
+	if expected == "" {
+		return func() {}, nil
+	}
+	server.DigestLock.Lock()
+	existing, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	if err != nil {
+		server.DigestLock.Unlock()
+		return nil, &HTTPError{http.StatusPreconditionFailed, cm_router.ErrorCodePreconditionFailed,
+			fmt.Sprintf("%s not found, cannot match the expected digest", filename)}
+	}
+	if digest := strings.TrimPrefix(ociDigest(existing.Content), "sha256:"); expected != "*" && digest != expected {
+		server.DigestLock.Unlock()
+		return nil, &HTTPError{http.StatusPreconditionFailed, cm_router.ErrorCodePreconditionFailed,
+			fmt.Sprintf("%s has digest %s, not the expected %s", filename, digest, expected)}
+	}
+	return server.DigestLock.Unlock, nil
+}
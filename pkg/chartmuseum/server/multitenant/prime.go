@@ -0,0 +1,178 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	cm_storage "github.com/chartmuseum/storage"
+)
+
+// primedRepos returns the repos whose index is built at startup: the root repo of a single
+// tenant setup, PrimeTenants, and every repo found in storage with PrimeAllTenants
+func (server *MultiTenantServer) primedRepos() ([]string, error) {
+	seen := map[string]bool{}
+	if server.Router.Depth == 0 {
+		seen[""] = true
+	}
+	for _, repo := range server.PrimeTenants {
+		seen[repo] = true
+	}
+	if server.PrimeAllTenants {
+		discovered, err := server.discoverTenants()
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range discovered {
+			seen[repo] = true
+		}
+	}
+
+	repos := make([]string, 0, len(seen))
+	for repo := range seen {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// discoverTenants returns the repos holding chart packages or a statefile in storage, at the depth
+// of the router. Hidden directories, such as the trash of a repo, are not repos
+func (server *MultiTenantServer) discoverTenants() ([]string, error) {
+	if paged := server.pagedStorage(context.Background()); paged != nil {
+		return server.discoverTenantsByLevel(paged)
+	}
+	paths, err := server.storagePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if !strings.HasSuffix(path, "."+cm_repo.ChartPackageFileExtension) && pathutil.Base(path) != cm_repo.StatefileFilename {
+			continue
+		}
+		repo := pathutil.Dir(path)
+		if repo == "." {
+			continue
+		}
+		segments := strings.Split(repo, "/")
+		if !server.Router.DepthDynamic && len(segments) != server.Router.Depth {
+			continue
+		}
+		hidden := false
+		for _, segment := range segments {
+			hidden = hidden || strings.HasPrefix(segment, ".")
+		}
+		if !hidden {
+			seen[repo] = true
+		}
+	}
+
+	repos := make([]string, 0, len(seen))
+	for repo := range seen {
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// discoverTenantsByLevel lists storage a directory at a time, down to the depth of repos, instead
+// of listing every object of storage at once
+func (server *MultiTenantServer) discoverTenantsByLevel(paged pagedBackend) ([]string, error) {
+	repos := []string{}
+	level := []string{""}
+	for depth := 0; len(level) > 0; depth++ {
+		var next []string
+		for _, directory := range level {
+			isRepo := false
+			err := listPages(context.Background(), paged, directory, func(page *objectsPage) {
+				for _, object := range page.Objects {
+					isRepo = isRepo || object.HasExtension(cm_repo.ChartPackageFileExtension) || object.Path == cm_repo.StatefileFilename
+				}
+				for _, name := range page.Directories {
+					if !strings.HasPrefix(name, ".") {
+						next = append(next, pathutil.Join(directory, name))
+					}
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			if isRepo && directory != "" && (server.Router.DepthDynamic || depth == server.Router.Depth) {
+				repos = append(repos, directory)
+			}
+		}
+		if !server.Router.DepthDynamic && depth >= server.Router.Depth {
+			break
+		}
+		level = next
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// storagePaths lists the paths of every object in storage. Object stores list every object under a
+// prefix, while the local filesystem backend only lists files of a directory, so it is walked instead
+func (server *MultiTenantServer) storagePaths() ([]string, error) {
+	backend := server.StorageBackend
+	if instrumented, ok := backend.(*instrumentedBackend); ok {
+		backend = instrumented.Backend
+	}
+	var rootDirectory string
+	switch b := backend.(type) {
+	case *cm_storage.LocalFilesystemBackend:
+		rootDirectory = b.RootDirectory
+	case cm_storage.LocalFilesystemBackend:
+		rootDirectory = b.RootDirectory
+	default:
+		objects, err := server.StorageBackend.ListObjects("")
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, 0, len(objects))
+		for _, object := range objects {
+			paths = append(paths, object.Path)
+		}
+		return paths, nil
+	}
+
+	var paths []string
+	err := filepath.Walk(rootDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			relativePath, err := filepath.Rel(rootDirectory, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, filepath.ToSlash(relativePath))
+		}
+		return nil
+	})
+	return paths, err
+}
@@ -0,0 +1,64 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"strconv"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForceProtectedHeader must be set to true by the ChartAdmins to delete or overwrite versions of
+// the charts protected by ChartProtection
+const ForceProtectedHeader = "X-Force-Protected"
+
+// checkChartProtection checks that a request deleting or overwriting versions of a protected chart
+// is forced with ForceProtectedHeader by one of the ChartAdmins
+func (server *MultiTenantServer) checkChartProtection(c *gin.Context, name string) *HTTPError {
+	if !server.ChartProtection.Protects(name) {
+		return nil
+	}
+	forced, _ := strconv.ParseBool(c.GetHeader(ForceProtectedHeader))
+	if user := cm_router.RequestUser(c.Request); forced && user != "" && server.ChartAdmins[user] {
+		server.Logger.ContextLoggingFn(c)(cm_logger.InfoLevel, "Change of protected chart forced",
+			"repo", c.Param("repo"),
+			"name", name,
+			"user", user,
+		)
+		return nil
+	}
+	return &HTTPError{http.StatusForbidden, cm_router.ErrorCodeForbidden, fmt.Sprintf("chart %s is protected", name)}
+}
+
+// checkOverwriteProtection checks the protection of the chart of a file uploaded to a repo, when
+// the upload would overwrite it
+func (server *MultiTenantServer) checkOverwriteProtection(c *gin.Context, repo string, filename string, force bool) *HTTPError {
+	name := chartNameFromFilename(filename)
+	if !server.ChartProtection.Protects(name) || (!server.allowOverwrite(repo) && (!server.AllowForceOverwrite || !force)) {
+		return nil
+	}
+	if _, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename)); err != nil {
+		return nil
+	}
+	return server.checkChartProtection(c, name)
+}
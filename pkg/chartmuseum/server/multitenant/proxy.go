@@ -0,0 +1,218 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/upstream"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/ghodss/yaml"
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+type (
+	// proxyIndex is the index of a repo merged with the index of its upstream repo,
+	// kept until either of them changes
+	proxyIndex struct {
+		local    *cm_repo.Index
+		upstream *helm_repo.IndexFile
+		merged   *cm_repo.Index
+	}
+)
+
+// upstreamClient returns the client of the upstream repo proxied by a repo, or nil if it proxies none
+func (server *MultiTenantServer) upstreamClient(log cm_logger.LoggingFn, repo string) *upstream.Client {
+	upstreamURL := server.ProxyUpstream
+	if overrides := server.TenantConfig.Lookup(repo); overrides != nil && overrides.Upstream != nil {
+		upstreamURL = *overrides.Upstream
+	}
+	if upstreamURL == "" {
+		return nil
+	}
+
+	server.ProxyLock.Lock()
+	defer server.ProxyLock.Unlock()
+	if client, ok := server.Upstreams[upstreamURL]; ok {
+		return client
+	}
+	client, err := upstream.NewClient(upstream.ClientOptions{
+		URL:      upstreamURL,
+		IndexTTL: server.ProxyIndexTTL,
+	})
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Invalid upstream repo",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return nil
+	}
+	server.Upstreams[upstreamURL] = client
+	return client
+}
+
+// withUpstreamEntries returns the index of a repo along with the chart versions of its upstream
+// repo it does not have, pointing to the charts route so they are cached in storage once pulled
+func (server *MultiTenantServer) withUpstreamEntries(log cm_logger.LoggingFn, repo string, index *cm_repo.Index) *cm_repo.Index {
+	client := server.upstreamClient(log, repo)
+	if client == nil {
+		return index
+	}
+	upstreamIndex, err := client.Index()
+	if err != nil {
+		log(cm_logger.WarnLevel, "Error fetching upstream index",
+			"repo", repo,
+			"upstream", client.Name(),
+			"error", err.Error(),
+		)
+	}
+	if upstreamIndex == nil {
+		return index
+	}
+
+	server.ProxyLock.Lock()
+	defer server.ProxyLock.Unlock()
+	if cached, ok := server.ProxyIndexes[repo]; ok && cached.local == index && cached.upstream == upstreamIndex {
+		return cached.merged
+	}
+
+	merged := index.Copy()
+	for name, chartVersions := range upstreamIndex.Entries {
+		for _, chartVersion := range chartVersions {
+			if merged.HasEntry(chartVersion) {
+				continue // local charts win over upstream ones
+			}
+			proxied := *chartVersion
+			proxied.URLs = []string{"charts/" + cm_repo.ChartPackageFilenameFromNameVersion(name, chartVersion.Version)}
+			merged.AddEntry(&proxied)
+		}
+	}
+	merged.SortEntries()
+	raw, err := yaml.Marshal(merged.IndexFile)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error merging upstream index",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return index
+	}
+	merged.Raw = raw
+
+	server.ProxyIndexes[repo] = &proxyIndex{local: index, upstream: upstreamIndex, merged: merged}
+	return merged
+}
+
+// getUpstreamObject fetches a chart package or provenance file missing from storage from the
+// upstream repo, and saves it in storage so it is served like any other chart from then on
+func (server *MultiTenantServer) getUpstreamObject(c *gin.Context, log cm_logger.LoggingFn, repo string, filename string) (*StorageObject, *HTTPError) {
+	client := server.upstreamClient(log, repo)
+	if client == nil || pathutil.Base(filename) != filename {
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "object not found"}
+	}
+	isProvenanceFile := strings.HasSuffix(filename, cm_repo.ProvenanceFileExtension)
+	chartFilename := filename
+	if isProvenanceFile {
+		chartFilename = strings.TrimSuffix(filename, cm_repo.ProvenanceFileExtension) + cm_repo.ChartPackageFileExtension
+	}
+
+	objectPath := pathutil.Join(repo, filename)
+	result, err, _ := server.ProxyGroup.Do(objectPath, func() (interface{}, error) {
+		upstreamChartVersion, err := client.ChartVersion(chartFilename)
+		if err != nil {
+			return nil, err
+		}
+		content, err := client.Download(upstreamChartVersion, isProvenanceFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var chartVersion *helm_repo.ChartVersion
+		if !isProvenanceFile {
+			chartVersion, err = cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+				Path:         objectPath,
+				Content:      content,
+				LastModified: time.Now(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			if cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version) != filename {
+				return nil, fmt.Errorf("upstream chart %s is %s-%s", filename, chartVersion.Name, chartVersion.Version)
+			}
+		}
+
+		if inMaintenance, _ := server.inMaintenance(); inMaintenance {
+			return content, nil // served without caching it in storage
+		}
+		if err := server.StorageBackend.PutObject(objectPath, content); err != nil {
+			return nil, err
+		}
+		log(cm_logger.InfoLevel, "Cached chart from upstream",
+			"repo", repo,
+			"filename", filename,
+			"upstream", client.Name(),
+		)
+		if chartVersion != nil {
+			server.emitEvent(c, repo, addChart, chartVersion)
+		}
+		return content, nil
+	})
+	if err == upstream.ErrChartNotFound {
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "object not found"}
+	}
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error fetching chart from upstream",
+			"repo", repo,
+			"filename", filename,
+			"upstream", client.Name(),
+			"error", err.Error(),
+		)
+		return nil, &HTTPError{http.StatusBadGateway, cm_router.ErrorCodeUpstreamUnavailable, "error fetching chart from upstream"}
+	}
+
+	contentType := chartPackageContentType
+	if isProvenanceFile {
+		contentType = provenanceFileContentType
+	}
+	return &StorageObject{
+		Object: &cm_storage.Object{
+			Path:         objectPath,
+			Content:      result.([]byte),
+			LastModified: time.Now(),
+		},
+		ContentType: contentType,
+	}, nil
+}
+
+// redactURL hides the password of a url, so it can be returned by the api
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Redacted()
+}
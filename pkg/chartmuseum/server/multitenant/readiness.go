@@ -0,0 +1,99 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// readinessPrefix is listed to check that storage is reachable, matching no chart so that
+	// the check stays cheap however many charts are stored
+	readinessPrefix = ".chartmuseum-ready"
+
+	// primeRetryDelay is the default PrimeRetryDelay
+	primeRetryDelay    = time.Second
+	maxPrimeRetryDelay = time.Minute
+)
+
+var (
+	livenessResponse  = gin.H{"live": true}
+	readinessResponse = gin.H{"ready": true}
+)
+
+func (server *MultiTenantServer) getLivenessHandler(c *gin.Context) {
+	c.JSON(200, livenessResponse)
+}
+
+// getReadinessHandler reports whether the initial index is built and storage is reachable, so
+// that no traffic is routed to a server still priming a large index
+func (server *MultiTenantServer) getReadinessHandler(c *gin.Context) {
+	if !server.ready() {
+		cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeNotReady, "priming the cache")
+		return
+	}
+	var err error
+	if awaitErr := server.awaitRequest(c, func(c *gin.Context) {
+		_, err = server.storage(requestContext(c)).ListObjects(readinessPrefix)
+	}); awaitErr != nil {
+		writeError(c, awaitErr)
+		return
+	}
+	if err != nil {
+		log := server.Logger.ContextLoggingFn(c)
+		log(cm_logger.WarnLevel, "Storage unreachable",
+			"error", err.Error(),
+		)
+		cm_router.WriteError(c, http.StatusServiceUnavailable, cm_router.ErrorCodeStorageUnavailable, "storage unreachable")
+		return
+	}
+	c.JSON(200, readinessResponse)
+}
+
+func (server *MultiTenantServer) ready() bool {
+	return atomic.LoadInt32(server.Ready) == 1
+}
+
+// primeCacheUntilReady primes the cache in the background, retrying with exponential backoff
+// while storage cannot be listed, and marks the server ready once done
+func (server *MultiTenantServer) primeCacheUntilReady() {
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	delay := server.PrimeRetryDelay
+	for {
+		err := server.primeCache()
+		if err == nil {
+			break
+		}
+		log(cm_logger.ErrorLevel, "Could not prime the cache, retrying",
+			"error", err.Error(),
+			"delay", delay.String(),
+		)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxPrimeRetryDelay {
+			delay = maxPrimeRetryDelay
+		}
+	}
+	atomic.StoreInt32(server.Ready, 1)
+	log(cm_logger.InfoLevel, "Cache primed, ready to serve")
+}
@@ -0,0 +1,129 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// downloadRedirect sends clients downloading chart packages and provenance files elsewhere,
+	// a CDN or presigned urls of the bucket, instead of serving their content
+	downloadRedirect struct {
+		urlTemplate string
+		s3          *cm_storage.AmazonS3Backend
+		ttl         time.Duration
+	}
+)
+
+// newDownloadRedirect returns the redirect of downloads to urlTemplate, e.g.
+// https://cdn.example.com/{path}, or to presigned urls of the Amazon S3 bucket of
+// backend, or nil when neither is set
+func newDownloadRedirect(backend cm_storage.Backend, urlTemplate string, presign bool, ttl time.Duration) (*downloadRedirect, error) {
+	if urlTemplate == "" && !presign {
+		return nil, nil
+	}
+	if urlTemplate != "" && presign {
+		return nil, errors.New("download redirect url and presigned urls cannot be used together")
+	}
+	redirect := &downloadRedirect{urlTemplate: urlTemplate, ttl: ttl}
+	if urlTemplate != "" {
+		u, err := url.Parse(strings.NewReplacer("{repo}", "repo", "{filename}", "filename", "{path}", "path").Replace(urlTemplate))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid download redirect url %q, must be an http or https url", urlTemplate)
+		}
+		return redirect, nil
+	}
+	switch b := backend.(type) {
+	case *cm_storage.AmazonS3Backend:
+		redirect.s3 = b
+	case cm_storage.AmazonS3Backend:
+		redirect.s3 = &b
+	default:
+		return nil, errors.New("presigned download urls need the amazon storage backend")
+	}
+	if redirect.ttl <= 0 {
+		redirect.ttl = 15 * time.Minute
+	}
+	return redirect, nil
+}
+
+// url returns where to download a file of a repo from
+func (redirect *downloadRedirect) url(repo string, filename string) (string, error) {
+	path := pathutil.Join(repo, filename)
+	if redirect.s3 == nil {
+		return strings.NewReplacer(
+			"{repo}", repo,
+			"{filename}", filename,
+			"{path}", path,
+		).Replace(redirect.urlTemplate), nil
+	}
+	req, _ := redirect.s3.Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(redirect.s3.Bucket),
+		Key:    aws.String(pathutil.Join(redirect.s3.Prefix, path)),
+	})
+	return req.Presign(redirect.ttl)
+}
+
+// redirectDownload redirects the download of a chart package or provenance file of a repo, if
+// its chart version is in the index of the repo. Files of virtual repos and proxied upstream
+// repos, which may not be in storage, are served as usual
+func (server *MultiTenantServer) redirectDownload(c *gin.Context, repo string, filename string) bool {
+	if server.DownloadRedirect == nil || server.virtualMembers(repo) != nil || pathutil.Base(filename) != filename {
+		return false
+	}
+	log := server.Logger.ContextLoggingFn(c)
+	if server.upstreamClient(log, repo) != nil {
+		return false
+	}
+	name, version := cm_repo.ChartNameVersionFromPackageFilename(strings.TrimSuffix(filename, ".prov"))
+	if name == "" {
+		return false
+	}
+	if _, err := server.getChartVersion(requestContext(c), log, repo, name, version); err != nil {
+		return false
+	}
+	location, err := server.DownloadRedirect.url(repo, filename)
+	if err != nil {
+		log(cm_logger.WarnLevel, "Could not redirect download",
+			"repo", repo,
+			"filename", filename,
+			"error", err.Error(),
+		)
+		return false
+	}
+	if server.DownloadRedirect.s3 != nil {
+		// presigned urls expire, caches must not keep them
+		c.Header("Cache-Control", "no-store")
+	}
+	c.Redirect(http.StatusFound, location)
+	return true
+}
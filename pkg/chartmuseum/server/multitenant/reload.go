@@ -0,0 +1,71 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// ReloadOptions are the settings of a running server which change when its config is reloaded
+	ReloadOptions struct {
+		Username           string
+		Password           string
+		AnonymousGet       bool
+		PerChartLimit      int
+		TrashRetention     time.Duration
+		MaintenanceMessage string
+	}
+)
+
+// Reload applies new settings to the running server. The per-chart limit and the trash
+// retention can be changed but not turned on or off, as that changes the routes served
+func (server *MultiTenantServer) Reload(options ReloadOptions) error {
+	if (server.ChartLimits != nil) != (options.PerChartLimit > 0) {
+		return errors.New("per-chart limit cannot be turned on or off without a restart")
+	}
+	if (server.trashRetention() > 0) != (options.TrashRetention > 0) {
+		return errors.New("trash retention cannot be turned on or off without a restart")
+	}
+
+	server.Router.SetBasicAuth(options.Username, options.Password, options.AnonymousGet)
+
+	if server.ChartLimits != nil {
+		server.ChartLimits.Lock()
+		server.ChartLimits.Limit = options.PerChartLimit
+		server.ChartLimits.Unlock()
+	}
+
+	server.ReloadLock.Lock()
+	defer server.ReloadLock.Unlock()
+	server.TrashRetention = options.TrashRetention
+	server.MaintenanceMessage = options.MaintenanceMessage
+	return nil
+}
+
+func (server *MultiTenantServer) trashRetention() time.Duration {
+	server.ReloadLock.RLock()
+	defer server.ReloadLock.RUnlock()
+	return server.TrashRetention
+}
+
+func (server *MultiTenantServer) maintenanceMessage() string {
+	server.ReloadLock.RLock()
+	defer server.ReloadLock.RUnlock()
+	return server.MaintenanceMessage
+}
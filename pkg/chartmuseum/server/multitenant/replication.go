@@ -0,0 +1,198 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"fmt"
+	pathutil "path"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	"helm.sh/chartmuseum/pkg/replication"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/upstream"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+type (
+	// replica is a repo replicated into local storage
+	replica struct {
+		source *replication.Source
+		client *upstream.Client
+	}
+)
+
+func newReplicas(config *replication.Config) ([]*replica, error) {
+	if config == nil {
+		return nil, nil
+	}
+	var replicas []*replica
+	for _, source := range config.Sources {
+		client, err := upstream.NewClient(upstream.ClientOptions{URL: source.URL})
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, &replica{source: source, client: client})
+	}
+	return replicas, nil
+}
+
+// startReplication syncs the replicated repos right away, then every replication interval
+func (server *MultiTenantServer) startReplication() {
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	ticker := time.NewTicker(server.Replication.Interval.Duration)
+	for {
+		if inMaintenance, _ := server.inMaintenance(); inMaintenance {
+			log(cm_logger.InfoLevel, "Skipping replication in maintenance mode")
+		} else if !server.Leader.IsLeader() {
+			log(cm_logger.DebugLevel, "Skipping replication, run by the leader")
+		} else {
+			for _, replica := range server.Replicas {
+				server.replicate(log, replica)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// replicate copies the charts of a source missing from its local repo, and with delete
+// propagation removes the local charts no longer in the source, returning how many changed
+func (server *MultiTenantServer) replicate(log cm_logger.LoggingFn, replica *replica) (int, int, error) {
+	source, repo := replica.source, replica.source.Repo
+	index, err := replica.client.Refresh()
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error fetching index of replicated repo",
+			"repo", repo,
+			"source", replica.client.Name(),
+			"error", err.Error(),
+		)
+		return 0, 0, err
+	}
+
+	// the charts replicated and deleted are written as uploads are, so that those of other replicas do not interleave
+	unlock, err := server.lock(context.Background(), "repo/"+repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error locking replicated repo",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return 0, 0, err
+	}
+	defer unlock()
+	objects, err := server.fetchChartsInStorage(context.Background(), log, repo)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error listing charts of replica",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return 0, 0, err
+	}
+	local := map[string]bool{}
+	for _, object := range objects {
+		local[pathutil.Base(object.Path)] = true
+	}
+
+	added, deleted := 0, 0
+	remote := map[string]bool{}
+	for name, chartVersions := range index.Entries {
+		if !source.Matches(name) {
+			continue
+		}
+		for _, chartVersion := range chartVersions {
+			filename := cm_repo.ChartPackageFilenameFromNameVersion(name, chartVersion.Version)
+			remote[filename] = true
+			if local[filename] {
+				continue
+			}
+			if err := server.replicateChartVersion(log, replica, filename, chartVersion); err != nil {
+				log(cm_logger.ErrorLevel, "Error replicating chart",
+					"repo", repo,
+					"source", replica.client.Name(),
+					"filename", filename,
+					"error", err.Error(),
+				)
+				continue
+			}
+			added++
+		}
+	}
+
+	if source.Delete {
+		for filename := range local {
+			chartVersion, err := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{Path: filename})
+			if err != nil || !source.Matches(chartVersion.Name) || remote[filename] {
+				continue
+			}
+			if err := server.deleteChartVersion(log, repo, chartVersion.Name, chartVersion.Version); err != nil {
+				log(cm_logger.ErrorLevel, "Error deleting chart no longer replicated",
+					"repo", repo,
+					"filename", filename,
+					"error", err.Message,
+				)
+				continue
+			}
+			server.emitEvent(&gin.Context{}, repo, deleteChart, chartVersion)
+			deleted++
+		}
+	}
+
+	log(cm_logger.InfoLevel, "Replicated repo",
+		"repo", repo,
+		"source", replica.client.Name(),
+		"added", added,
+		"deleted", deleted,
+	)
+	return added, deleted, nil
+}
+
+// replicateChartVersion saves a chart of the source in storage, along with its provenance file if there is one
+func (server *MultiTenantServer) replicateChartVersion(log cm_logger.LoggingFn, replica *replica, filename string, remoteChartVersion *helm_repo.ChartVersion) error {
+	repo := replica.source.Repo
+	content, err := replica.client.Download(remoteChartVersion, false)
+	if err != nil {
+		return err
+	}
+	objectPath := pathutil.Join(repo, filename)
+	chartVersion, err := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+		Path:         objectPath,
+		Content:      content,
+		LastModified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version) != filename {
+		return fmt.Errorf("chart is %s-%s", chartVersion.Name, chartVersion.Version)
+	}
+
+	// the provenance file goes first, so the chart is never in the index without it
+	if provContent, err := replica.client.Download(remoteChartVersion, true); err == nil {
+		provPath := pathutil.Join(repo, cm_repo.ProvenanceFilenameFromNameVersion(chartVersion.Name, chartVersion.Version))
+		if err := server.StorageBackend.PutObject(provPath, provContent); err != nil {
+			return err
+		}
+	}
+	if err := server.StorageBackend.PutObject(objectPath, content); err != nil {
+		return err
+	}
+	server.emitEvent(&gin.Context{}, repo, addChart, chartVersion)
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCacheMaxEntries bounds the responses cached, as offset and limit make the keys unbounded
+const responseCacheMaxEntries = 10000
+
+type (
+	// responseCache holds the json bodies of the chart listing api, which dashboards poll constantly,
+	// so that they are not built from the whole index on each call. A body is served for the index it
+	// was built from only, for ttl at most, and every body is dropped on writes to the server
+	responseCache struct {
+		ttl     time.Duration
+		mutex   sync.Mutex
+		entries map[string]*cachedResponse
+	}
+
+	cachedResponse struct {
+		index   *cm_repo.Index
+		raw     []byte
+		body    []byte
+		expires time.Time
+	}
+)
+
+// newResponseCache creates a new responseCache, or returns nil without a ttl
+func newResponseCache(ttl time.Duration) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &responseCache{ttl: ttl, entries: map[string]*cachedResponse{}}
+}
+
+// get returns the body cached with key for index, if not expired
+func (cache *responseCache) get(key string, index *cm_repo.Index) ([]byte, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cached, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	// indexes are copied on change, while those of an external cache store are read anew on each call
+	if time.Now().After(cached.expires) || (cached.index != index && !bytes.Equal(cached.raw, index.Raw)) {
+		delete(cache.entries, key)
+		return nil, false
+	}
+	return cached.body, true
+}
+
+func (cache *responseCache) set(key string, index *cm_repo.Index, body []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if len(cache.entries) >= responseCacheMaxEntries {
+		cache.entries = map[string]*cachedResponse{}
+	}
+	cache.entries[key] = &cachedResponse{
+		index:   index,
+		raw:     index.Raw,
+		body:    body,
+		expires: time.Now().Add(cache.ttl),
+	}
+}
+
+// purge drops every body cached
+func (cache *responseCache) purge() {
+	if cache == nil {
+		return
+	}
+	cache.mutex.Lock()
+	cache.entries = map[string]*cachedResponse{}
+	cache.mutex.Unlock()
+}
+
+// purgeResponseCache drops the responses cached once handler served a write
+func (server *MultiTenantServer) purgeResponseCache(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler(c)
+		server.ResponseCache.purge()
+	}
+}
+
+// writeCachedJSON writes the json of the result of build, from the cache while index is unchanged
+func (server *MultiTenantServer) writeCachedJSON(c *gin.Context, key string, index *cm_repo.Index, build func() (interface{}, *HTTPError)) {
+	cache := server.ResponseCache
+	if cache != nil {
+		if body, ok := cache.get(key, index); ok {
+			c.Header("X-Cache", "HIT")
+			c.Data(200, "application/json; charset=utf-8", body)
+			return
+		}
+	}
+	result, err := build()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if cache == nil {
+		c.JSON(200, result)
+		return
+	}
+	body, jsonErr := json.Marshal(result)
+	if jsonErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInternal, jsonErr.Error())
+		return
+	}
+	cache.set(key, index, body)
+	c.Header("X-Cache", "MISS")
+	c.Data(200, "application/json; charset=utf-8", body)
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package multitenant
 
 import (
+	"strings"
+
 	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 
 	cm_auth "github.com/chartmuseum/auth"
@@ -29,6 +31,8 @@ func (s *MultiTenantServer) Routes() []*cm_router.Route {
 		{"GET", "/", s.getWelcomePageHandler, cm_auth.PullAction},
 		{"GET", "/info", s.getInfoHandler, ""},
 		{"GET", "/health", s.getHealthCheckHandler, ""},
+		{"GET", "/live", s.getLivenessHandler, ""},
+		{"GET", "/ready", s.getReadinessHandler, ""},
 	}
 
 	helmChartRepositoryRoutes := []*cm_router.Route{
@@ -37,25 +41,223 @@ func (s *MultiTenantServer) Routes() []*cm_router.Route {
 	}
 
 	chartManipulationRoutes := []*cm_router.Route{
+		// ahead of the chart version routes, which would match them otherwise
+		{"GET", "/api/:repo/charts/:name/channels", s.getChartChannelsRequestHandler, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts/:name/channels/:channel", s.getChartChannelRequestHandler, cm_auth.PullAction},
+		{"PUT", "/api/:repo/charts/:name/channels/:channel", s.putChartChannelRequestHandler, cm_auth.PushAction},
+		{"DELETE", "/api/:repo/charts/:name/channels/:channel", s.deleteChartChannelRequestHandler, cm_auth.PushAction},
 		{"GET", "/api/:repo/charts", s.getAllChartsRequestHandler, cm_auth.PullAction},
 		{"HEAD", "/api/:repo/charts/:name", s.headChartRequestHandler, cm_auth.PullAction},
 		{"GET", "/api/:repo/charts/:name", s.getChartRequestHandler, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts/:name/diff", s.getChartVersionDiffRequestHandler, cm_auth.PullAction},
 		{"HEAD", "/api/:repo/charts/:name/:version", s.headChartVersionRequestHandler, cm_auth.PullAction},
 		{"GET", "/api/:repo/charts/:name/:version", s.getChartVersionRequestHandler, cm_auth.PullAction},
-		{"POST", "/api/:repo/charts", s.postRequestHandler, cm_auth.PushAction},
-		{"POST", "/api/:repo/prov", s.postProvenanceFileRequestHandler, cm_auth.PushAction},
+		{"POST", "/api/:repo/charts", s.verifyChecksum(s.postRequestHandler), cm_auth.PushAction},
+		{"POST", "/api/:repo/prov", s.verifyChecksum(s.postProvenanceFileRequestHandler), cm_auth.PushAction},
+		{"POST", "/api/:repo/charts/:name/:version/promote", s.promoteChartVersionRequestHandler, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts/:name/:version/readme", s.getChartVersionReadmeRequestHandler, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts/:name/:version/values", s.getChartVersionValuesRequestHandler, cm_auth.PullAction},
+		{"GET", "/api/:repo/charts/:name/:version/sbom", s.getChartVersionSBOMRequestHandler, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts/:name/:version/sbom", s.postChartVersionSBOMRequestHandler, cm_auth.PushAction},
+		{"GET", "/api/:repo/charts/:name/:version/attachments/:kind", s.getChartVersionAttachmentRequestHandler, cm_auth.PullAction},
+		{"POST", "/api/:repo/charts/:name/:version/attachments/:kind", s.postChartVersionAttachmentRequestHandler, cm_auth.PushAction},
+		{"DELETE", "/api/:repo/charts/:name/:version/attachments/:kind", s.deleteChartVersionAttachmentRequestHandler, cm_auth.PushAction},
+		{"GET", "/api/:repo/charts/:name/:version/annotations", s.getChartVersionAnnotationsRequestHandler, cm_auth.PullAction},
+		{"PATCH", "/api/:repo/charts/:name/:version/annotations", s.patchChartVersionAnnotationsRequestHandler, cm_auth.PushAction},
+		{"GET", "/api/:repo/events", s.getEventsRequestHandler, cm_auth.PullAction},
+	}
+	if s.ChartOwners {
+		// ahead of the chart version routes, which would match them otherwise
+		chartManipulationRoutes = append([]*cm_router.Route{
+			{"GET", "/api/:repo/charts/:name/owners", s.getChartOwnersRequestHandler, cm_auth.PullAction},
+			{"PUT", "/api/:repo/charts/:name/owners", s.putChartOwnersRequestHandler, cm_auth.PushAction},
+		}, chartManipulationRoutes...)
+	}
+	if s.ChartHistory {
+		// ahead of the chart version routes, which would match it otherwise
+		chartManipulationRoutes = append([]*cm_router.Route{
+			{"GET", "/api/:repo/charts/:name/history", s.getChartHistoryRequestHandler, cm_auth.PullAction},
+		}, chartManipulationRoutes...)
+	}
+	if s.ChartIcons != "" {
+		chartManipulationRoutes = append(chartManipulationRoutes,
+			&cm_router.Route{"GET", "/api/:repo/charts/:name/:version/icon", s.getChartVersionIconRequestHandler, cm_auth.PullAction},
+		)
+	}
+	if s.Scanner != nil {
+		chartManipulationRoutes = append(chartManipulationRoutes,
+			&cm_router.Route{"GET", "/api/:repo/charts/:name/:version/scan", s.getChartVersionScanRequestHandler, cm_auth.PullAction},
+			&cm_router.Route{"POST", "/api/:repo/charts/:name/:version/scan", s.postChartVersionScanRequestHandler, cm_auth.PushAction},
+		)
+	}
+
+	versionRoute := &cm_router.Route{"GET", "/api/version", s.getVersionRequestHandler, ""}
+
+	// listing all tenants and managing them is restricted to users who may push to any repo
+	catalogRoute := &cm_router.Route{"GET", "/api/catalog", s.getCatalogRequestHandler, cm_auth.PushAction}
+	tenantManagementRoutes := []*cm_router.Route{
+		{"GET", "/api/tenants", s.getTenantsRequestHandler, cm_auth.PushAction},
+		{"POST", "/api/tenants", s.postTenantRequestHandler, cm_auth.PushAction},
+		{"DELETE", "/api/tenants", s.deleteTenantRequestHandler, cm_auth.PushAction},
+	}
+
+	adminRoutes := []*cm_router.Route{
+		{"GET", "/api/admin/maintenance", s.getMaintenanceRequestHandler, cm_auth.PushAction},
+		{"PUT", "/api/admin/maintenance", s.putMaintenanceRequestHandler, cm_auth.PushAction},
+		{"GET", "/api/admin/loglevel", s.getLogLevelRequestHandler, cm_auth.PushAction},
+		{"PUT", "/api/admin/loglevel", s.putLogLevelRequestHandler, cm_auth.PushAction},
+		{"GET", "/api/admin/consistency", s.getConsistencyRequestHandler, cm_auth.PushAction},
+		// added after the routes rejected in maintenance mode below, as they write to storage
+		{"POST", "/api/admin/import", s.rejectInMaintenance(s.postImportRequestHandler), cm_auth.PushAction},
+		{"POST", "/api/admin/consistency", s.rejectInMaintenance(s.postConsistencyRequestHandler), cm_auth.PushAction},
+	}
+	if s.PprofEnabled {
+		adminRoutes = append(adminRoutes, s.debugRoutes()...)
+	}
+
+	// these act on no repo, so tenant credentials would leave them open to anyone
+	for _, route := range append(append([]*cm_router.Route{catalogRoute}, tenantManagementRoutes...), adminRoutes...) {
+		route.Handler = s.requireServerAuth(route.Handler)
+	}
+
+	// the OCI distribution api, for helm push and pull with oci:// urls
+	ociPullRoutes := []*cm_router.Route{
+		{"GET", "/v2/", s.getOCIBaseRequestHandler, ""},
+		{"GET", "/v2/:repo/:name/tags/list", s.getOCITagsRequestHandler, cm_auth.PullAction},
+		{"GET", "/v2/:repo/:name/manifests/:reference", s.getOCIManifestRequestHandler, cm_auth.PullAction},
+		{"HEAD", "/v2/:repo/:name/manifests/:reference", s.getOCIManifestRequestHandler, cm_auth.PullAction},
+		{"GET", "/v2/:repo/:name/blobs/:digest", s.getOCIBlobRequestHandler, cm_auth.PullAction},
+		{"HEAD", "/v2/:repo/:name/blobs/:digest", s.getOCIBlobRequestHandler, cm_auth.PullAction},
+	}
+	ociPushRoutes := []*cm_router.Route{
+		{"POST", "/v2/:repo/:name/blobs/uploads/", s.postOCIUploadRequestHandler, cm_auth.PushAction},
+		{"PATCH", "/v2/:repo/:name/blobs/uploads/:uuid", s.patchOCIUploadRequestHandler, cm_auth.PushAction},
+		{"PUT", "/v2/:repo/:name/blobs/uploads/:uuid", s.putOCIUploadRequestHandler, cm_auth.PushAction},
+		{"PUT", "/v2/:repo/:name/manifests/:reference", s.putOCIManifestRequestHandler, cm_auth.PushAction},
+	}
+
+	if s.IndexSigner != nil {
+		helmChartRepositoryRoutes = append(helmChartRepositoryRoutes,
+			&cm_router.Route{"GET", "/:repo/index.yaml.asc", s.getIndexFileSignatureRequestHandler, cm_auth.PullAction},
+		)
+	}
+
+	if s.WebUIEnabled {
+		serverInfoRoutes[0].Handler = s.getWebUIHandler
 	}
 
 	routes = append(routes, serverInfoRoutes...)
 	routes = append(routes, helmChartRepositoryRoutes...)
 
+	if s.IndexSharding != nil {
+		// must come after the other repo routes, as it matches any file at the root of a repo
+		routes = append(routes, &cm_router.Route{"GET", "/:repo/:filename", s.getIndexShardRequestHandler, cm_auth.PullAction})
+	}
+
 	if s.APIEnabled {
 		routes = append(routes, chartManipulationRoutes...)
+		routes = append(routes, catalogRoute, versionRoute)
 	}
 
 	if s.APIEnabled && !s.DisableDelete {
 		routes = append(routes, &cm_router.Route{"DELETE", "/api/:repo/charts/:name/:version", s.deleteChartVersionRequestHandler, cm_auth.PushAction})
 	}
 
+	if s.APIEnabled && !s.DisableDelete && s.TrashRetention > 0 {
+		routes = append(routes, &cm_router.Route{"POST", "/api/:repo/charts/:name/:version/restore", s.restoreChartVersionRequestHandler, cm_auth.PushAction})
+	}
+
+	if s.APIEnabled && len(s.DownloadLinkSecret) > 0 {
+		// the token of the link stands for the credentials of the download
+		routes = append(routes,
+			&cm_router.Route{"GET", "/api/:repo/charts/:name/:version/link", s.getChartVersionLinkRequestHandler, cm_auth.PullAction},
+			&cm_router.Route{"GET", "/:repo/links/:token/:filename", s.getDownloadLinkRequestHandler, ""},
+		)
+	}
+
+	if s.OCIEnabled {
+		routes = append(routes, ociPullRoutes...)
+	}
+
+	if s.OCIEnabled && s.APIEnabled {
+		routes = append(routes, ociPushRoutes...)
+	}
+
+	if s.APIEnabled && s.TenantAPIEnabled {
+		routes = append(routes, tenantManagementRoutes...)
+	}
+
+	// every route but GET and HEAD writes to storage or to the tenant config
+	for _, route := range routes {
+		if route.Method != "GET" && route.Method != "HEAD" {
+			route.Handler = s.rejectInMaintenance(route.Handler)
+		}
+	}
+
+	// the chart listing api lists attachments too, which change without changing the index
+	if s.ResponseCache != nil {
+		for _, route := range routes {
+			if route.Method != "GET" && route.Method != "HEAD" {
+				route.Handler = s.purgeResponseCache(route.Handler)
+			}
+		}
+	}
+
+	// replicas sharing the storage backend take turns writing to a repo
+	if s.Locker != nil {
+		for _, route := range routes {
+			// promotions lock their target along with their repo, see promoteChartVersionRequestHandler
+			if route.Method != "GET" && route.Method != "HEAD" && strings.Contains(route.Path, ":repo") && !strings.HasSuffix(route.Path, "/promote") {
+				route.Handler = s.lockRepoWrites(route.Handler)
+			}
+		}
+	}
+
+	if s.APIEnabled {
+		routes = append(routes, adminRoutes...)
+	}
+
+	// virtual repos only serve the charts of their members
+	for _, route := range routes {
+		if route.Action == cm_auth.PushAction && strings.Contains(route.Path, ":repo") {
+			route.Handler = s.rejectVirtualRepo(route.Handler)
+		}
+	}
+
+	// uploads and promotions overwrite chart versions with ?force on behalf of some users only
+	if s.ForceOverwriteUsers != nil {
+		for _, route := range routes {
+			if route.Method == "POST" && strings.Contains(route.Path, ":repo") {
+				route.Handler = s.restrictForceOverwrite(route.Handler)
+			}
+		}
+	}
+
+	// charts are changed by their owners only, uploads and OCI manifest pushes being checked by their handlers
+	if s.RestrictToOwners {
+		for _, route := range routes {
+			if route.Action == cm_auth.PushAction && strings.Contains(route.Path, ":name") && !strings.Contains(route.Path, "/manifests/") {
+				route.Handler = s.restrictToOwners(route.Handler)
+			}
+		}
+	}
+
+	if s.TrashRetention > 0 {
+		for _, route := range routes {
+			if strings.Contains(route.Path, ":repo") {
+				route.Handler = s.hideTrash(route.Handler)
+			}
+		}
+	}
+
+	// the error rate of a repo is checked against its alert threshold
+	if s.Alerts != nil && s.Alerts.ErrorRate > 0 {
+		for _, route := range routes {
+			if strings.Contains(route.Path, ":repo") {
+				route.Handler = s.countResponses(route.Handler)
+			}
+		}
+	}
+
 	return routes
 }
@@ -0,0 +1,179 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	pathutil "path"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/scan"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (server *MultiTenantServer) getChartVersionScanRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if server.scanPending(repo, chartVersion.Name, chartVersion.Version) {
+		c.JSON(200, gin.H{"status": scan.StatusPending})
+		return
+	}
+	filename := cm_repo.AttachmentFilenameFromNameVersion(chartVersion.Name, chartVersion.Version, scan.AttachmentKind)
+	for _, r := range server.attachmentRepos(repo) {
+		if object, getErr := server.StorageBackend.GetObject(pathutil.Join(r, filename)); getErr == nil {
+			c.Data(200, "application/json; charset=utf-8", object.Content)
+			return
+		}
+	}
+	cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, "chart version not scanned")
+}
+
+func (server *MultiTenantServer) postChartVersionScanRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.getChartVersion(requestContext(c), log, repo, c.Param("name"), c.Param("version"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(chartVersion.Name, chartVersion.Version)
+	object, getErr := server.StorageBackend.GetObject(pathutil.Join(repo, filename))
+	if getErr != nil {
+		cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, getErr.Error())
+		return
+	}
+	server.scanInBackground(repo, chartVersion.Name, chartVersion.Version, object.Content)
+	c.JSON(202, gin.H{"status": scan.StatusPending})
+}
+
+// scanBeforeUpload scans a chart package before it is stored, rejecting those failing their scan
+func (server *MultiTenantServer) scanBeforeUpload(log cm_logger.LoggingFn, repo string, content []byte) (*scan.Result, *HTTPError) {
+	name, version, err := extractFromChart(content)
+	if err != nil {
+		return nil, &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeInvalidChart, err.Error()}
+	}
+	result, err := server.Scanner.Scan(context.Background(), repo, name, version, content)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error scanning chart",
+			"repo", repo,
+			"name", name,
+			"version", version,
+			"error", err.Error(),
+		)
+		return nil, &HTTPError{http.StatusServiceUnavailable, cm_router.ErrorCodeScanFailed, fmt.Sprintf("scanner unavailable: %s", err)}
+	}
+	if result.Status == scan.StatusFailed {
+		log(cm_logger.InfoLevel, "Chart rejected by scanner",
+			"repo", repo,
+			"name", name,
+			"version", version,
+			"findings", len(result.Findings),
+		)
+		return nil, &HTTPError{http.StatusUnprocessableEntity, cm_router.ErrorCodeScanFailed,
+			fmt.Sprintf("%d findings, with some at or above severity %s", len(result.Findings), result.Severity)}
+	}
+	return result, nil
+}
+
+// recordScan stores the result of the scan of an uploaded chart package, scanning it in the
+// background unless it was scanned before being stored
+func (server *MultiTenantServer) recordScan(log cm_logger.LoggingFn, repo string, content []byte, result *scan.Result) {
+	name, version, err := extractFromChart(content)
+	if err != nil {
+		return
+	}
+	if result == nil {
+		server.scanInBackground(repo, name, version, content)
+		return
+	}
+	server.storeScanResult(log, repo, name, version, result)
+}
+
+// scanInBackground scans a chart version, reported as pending by the api until its result is stored
+func (server *MultiTenantServer) scanInBackground(repo string, name string, version string, content []byte) {
+	key := pathutil.Join(repo, name, version)
+	server.ScanLock.Lock()
+	if server.PendingScans[key] {
+		server.ScanLock.Unlock()
+		return
+	}
+	server.PendingScans[key] = true
+	server.ScanLock.Unlock()
+
+	go func() {
+		defer func() {
+			server.ScanLock.Lock()
+			delete(server.PendingScans, key)
+			server.ScanLock.Unlock()
+		}()
+		log := server.Logger.ContextLoggingFn(&gin.Context{})
+		result, err := server.Scanner.Scan(context.Background(), repo, name, version, content)
+		if err != nil {
+			log(cm_logger.ErrorLevel, "Error scanning chart",
+				"repo", repo,
+				"name", name,
+				"version", version,
+				"error", err.Error(),
+			)
+			result = server.Scanner.ErrorResult(err)
+		}
+		server.storeScanResult(log, repo, name, version, result)
+	}()
+}
+
+func (server *MultiTenantServer) scanPending(repo string, name string, version string) bool {
+	server.ScanLock.Lock()
+	defer server.ScanLock.Unlock()
+	return server.PendingScans[pathutil.Join(repo, name, version)]
+}
+
+// storeScanResult stores the result of a scan as the scan attachment of the chart version
+func (server *MultiTenantServer) storeScanResult(log cm_logger.LoggingFn, repo string, name string, version string, result *scan.Result) {
+	content, err := json.Marshal(result)
+	if err == nil {
+		filename := cm_repo.AttachmentFilenameFromNameVersion(name, version, scan.AttachmentKind)
+		err = server.StorageBackend.PutObject(pathutil.Join(repo, filename), content)
+	}
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error storing scan result",
+			"repo", repo,
+			"name", name,
+			"version", version,
+			"error", err.Error(),
+		)
+		return
+	}
+	server.ResponseCache.purge()
+	log(cm_logger.DebugLevel, "Chart scanned",
+		"repo", repo,
+		"name", name,
+		"version", version,
+		"status", result.Status,
+		"findings", len(result.Findings),
+	)
+}
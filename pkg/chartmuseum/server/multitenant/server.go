@@ -17,18 +17,32 @@ limitations under the License.
 package multitenant
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html/template"
+	"net/http"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"helm.sh/chartmuseum/pkg/cache"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	"helm.sh/chartmuseum/pkg/ingestion"
+	"helm.sh/chartmuseum/pkg/lock"
+	"helm.sh/chartmuseum/pkg/replication"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/scan"
+	"helm.sh/chartmuseum/pkg/tenant"
+	"helm.sh/chartmuseum/pkg/upstream"
+	"helm.sh/chartmuseum/pkg/webhook"
 
 	cm_storage "github.com/chartmuseum/storage"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
@@ -52,21 +66,96 @@ type (
 		InternalCacheStore     map[string]*cacheEntry
 		MaxStorageObjects      int
 		IndexLimit             int
+		IndexSharding          *cm_repo.IndexSharding
+		IndexJournal           bool
 		AllowOverwrite         bool
 		AllowForceOverwrite    bool
+		IdempotentUploads      bool
 		APIEnabled             bool
 		DisableDelete          bool
 		UseStatefiles          bool
+		UseMetadataCache       bool
+		PersistMetadataCache   bool
 		ChartURL               string
+		ChartURLTemplate       string
 		ChartPostFormFieldName string
 		ProvPostFormFieldName  string
 		Version                string
+		Revision               string
 		Limiter                chan struct{}
+		RegenerationSlots      chan struct{}
 		Tenants                map[string]*tenantInternals
-		TenantCacheKeyLock     *sync.Mutex
+		TenantCacheKeyLock     *sync.RWMutex
+		TenantInitGroup        *singleflight.Group
 		CacheInterval          time.Duration
+		StaleWhileRevalidate   bool
+		MaxStaleness           time.Duration
 		EventChan              chan event
+		PendingWrites          *int64
+		AbandonedRequests      *int64
+		Ready                  *int32
+		PrimeRetryDelay        time.Duration
 		ChartLimits            *ObjectsPerChartLimit
+		TenantConfig           *tenant.Config
+		TenantAPIEnabled       bool
+		TenantConfigLock       *sync.Mutex
+		Notifier               *webhook.Notifier
+		EventStream            *eventStream
+		OCIEnabled             bool
+		OCIBlobs               map[string]*ociBlob
+		OCIManifests           map[string]bool
+		OCILock                *sync.Mutex
+		WebUIEnabled           bool
+		LandingJSON            bool
+		LandingTemplate        *template.Template
+		PprofEnabled           bool
+		ProxyUpstream          string
+		ProxyIndexTTL          time.Duration
+		Upstreams              map[string]*upstream.Client
+		ProxyIndexes           map[string]*proxyIndex
+		ProxyLock              *sync.Mutex
+		ProxyGroup             *singleflight.Group
+		VirtualIndexes         map[string]*virtualIndex
+		VirtualLock            *sync.Mutex
+		Replication            *replication.Config
+		Replicas               []*replica
+		Ingestion              *ingestion.Config
+		Ingesters              []*ingester
+		TrashRetention         time.Duration
+		MaintenanceMessage     string
+		Maintenance            *maintenanceMode
+		ReloadLock             *sync.RWMutex
+		Scanner                *scan.Scanner
+		PendingScans           map[string]bool
+		ScanLock               *sync.Mutex
+		IndexAnnotations       bool
+		AnnotationsLock        *sync.Mutex
+		ChartOwners            bool
+		ChartHistory           bool
+		RestrictToOwners       bool
+		ChartAdmins            map[string]bool
+		ForceOverwriteUsers    map[string]bool
+		ChartProtection        *cm_repo.ChartProtection
+		OwnersLock             *sync.Mutex
+		ChannelsLock           *sync.Mutex
+		DigestLock             *sync.Mutex
+		IndexSigner            *cm_repo.IndexSigner
+		CacheControlIndex      string
+		CacheControlCharts     string
+		ChartIcons             string
+		DownloadLinkSecret     []byte
+		DownloadLinkMaxTTL     time.Duration
+		DownloadRedirect       *downloadRedirect
+		PrimeTenants           []string
+		PrimeAllTenants        bool
+		CacheTTL               time.Duration
+		CacheMaxEntries        int
+		ResponseCache          *responseCache
+		ChartPolicy            *cm_repo.ChartPolicy
+		Locker                 lock.Locker
+		LockTimeout            time.Duration
+		Leader                 *lock.Elector
+		Alerts                 *alerts
 		// Deprecated: see https://github.com/helm/chartmuseum/issues/485 for more info
 		EnforceSemver2 bool
 	}
@@ -84,28 +173,116 @@ type (
 		ExternalCacheStore     cache.Store
 		TimestampTolerance     time.Duration
 		ChartURL               string
+		ChartURLTemplate       string
 		ChartPostFormFieldName string
 		ProvPostFormFieldName  string
 		Version                string
+		Revision               string
 		MaxStorageObjects      int
 		IndexLimit             int
+		RegenerationLimit      int
+		IndexSharding          string
+		IndexShards            int
+		IndexJournal           bool
 		GenIndex               bool
+		// ExportDirectory is where the repos are exported to as static chart repositories, then exiting
+		ExportDirectory        string
+		ValidateConfig         bool
 		AllowOverwrite         bool
 		AllowForceOverwrite    bool
+		IdempotentUploads      bool
 		EnableAPI              bool
 		DisableDelete          bool
 		UseStatefiles          bool
+		UseMetadataCache       bool
+		PersistMetadataCache   bool
 		CacheInterval          time.Duration
+		StaleWhileRevalidate   bool
+		MaxStaleness           time.Duration
 		PerChartLimit          int
+		TenantConfig           *tenant.Config
+		EnableTenantAPI        bool
+		WebhookURLs            []string
+		WebhookSecret          string
+		WebhookRetries         int
+		EventPublishers        []webhook.Publisher
+		EnableOCI              bool
+		EnableWebUI            bool
+		LandingJSON            bool
+		LandingTemplate        string
+		EnablePprof            bool
+		MutexProfileFraction   int
+		ProxyUpstream          string
+		ProxyIndexTTL          time.Duration
+		Replication            *replication.Config
+		Ingestion              *ingestion.Config
+		TrashRetention         time.Duration
+		MaintenanceMessage     string
+		ScanURL                string
+		ScanPayload            string
+		ScanSeverity           string
+		ScanBlock              bool
+		ScanTimeout            time.Duration
+		IndexAnnotations       bool
+		ChartOwners            bool
+		ChartHistory           bool
+		RestrictToOwners       bool
+		ChartAdmins            []string
+		ForceOverwriteUsers    []string
+		ProtectedCharts        []string
+		IndexSigningKey        string
+		IndexSigningKeyName    string
+		IndexSigningPassphrase string
+		CacheControlIndex      string
+		CacheControlCharts     string
+		ChartIcons             string
+		DownloadLinkSecret     string
+		DownloadLinkMaxTTL     time.Duration
+		DownloadRedirectURL    string
+		PresignDownloads       bool
+		DownloadRedirectTTL    time.Duration
+		PrimeTenants           []string
+		PrimeAllTenants        bool
+		CacheTTL               time.Duration
+		CacheMaxEntries        int
+		APICacheTTL            time.Duration
+		ChartNamePattern       string
+		ChartVersionPattern    string
+		StrictSemver           bool
+		Locker                 lock.Locker
+		LockTimeout            time.Duration
+		Leader                 *lock.Elector
+		AlertStorageBytes      int
+		AlertChartVersions     int
+		AlertIndexBytes        int
+		AlertErrorRate         float64
+		AlertInterval          time.Duration
+		// PrimeRetryDelay is the first delay between attempts to prime the cache, 1s when 0
+		PrimeRetryDelay time.Duration
 		// Deprecated: see https://github.com/helm/chartmuseum/issues/485 for more info
 		EnforceSemver2 bool
 	}
 
 	tenantInternals struct {
-		FetchedObjectsLock      *sync.Mutex
-		RegenerationLock        *sync.Mutex
-		FetchedObjectsChans     []chan fetchedObjects
-		RegeneratedIndexesChans []chan indexRegeneration
+		// FetchedObjectsGroup and RegenerationGroup collapse concurrent
+		// storage listings and index rebuilds for this tenant into one call
+		FetchedObjectsGroup *singleflight.Group
+		RegenerationGroup   *singleflight.Group
+		// RegenerationLock guards the tenant's cached index: readers only
+		// hold it long enough to load the current index, writers to swap it
+		RegenerationLock *sync.RWMutex
+		// MetadataCache is only set when UseMetadataCache is enabled
+		MetadataCache *cm_repo.MetadataCache
+		// RefreshLock guards Refreshing and LastRefreshed, which track
+		// background refreshes of the index in stale-while-revalidate mode
+		RefreshLock   *sync.Mutex
+		Refreshing    bool
+		LastRefreshed time.Time
+		// Journaled is set once the index journal of the tenant is known to be started
+		Journaled bool
+		// LastAccessed is the time in unix nanoseconds the index of the tenant was last
+		// looked up, read and written atomically to evict unused indexes from memory
+		LastAccessed *int64
 	}
 
 	fetchedObjects struct {
@@ -133,42 +310,277 @@ func NewMultiTenantServer(options MultiTenantServerOptions) (*MultiTenantServer,
 		}
 	}
 
+	if options.ChartURL != "" && options.ChartURLTemplate != "" {
+		return nil, errors.New("chart url and chart url template cannot be used together")
+	}
+
+	if err := validateChartIcons(options.ChartIcons); err != nil {
+		return nil, err
+	}
+
+	indexSharding, err := cm_repo.NewIndexSharding(options.IndexSharding, options.IndexShards)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.ProxyUpstream != "" {
+		if _, err := upstream.NewClient(upstream.ClientOptions{URL: options.ProxyUpstream}); err != nil {
+			return nil, err
+		}
+	}
+
+	replicas, err := newReplicas(options.Replication)
+	if err != nil {
+		return nil, err
+	}
+
+	ingesters, err := newIngesters(options.Ingestion)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner, err := scan.NewScanner(scan.ScannerOptions{
+		URL:      options.ScanURL,
+		Payload:  options.ScanPayload,
+		Severity: options.ScanSeverity,
+		Block:    options.ScanBlock,
+		Timeout:  options.ScanTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexSigner, err := cm_repo.NewIndexSigner(options.IndexSigningKey, options.IndexSigningKeyName, options.IndexSigningPassphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	chartPolicy, err := cm_repo.NewChartPolicy(options.ChartNamePattern, options.ChartVersionPattern, options.StrictSemver)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadRedirect, err := newDownloadRedirect(options.StorageBackend, options.DownloadRedirectURL, options.PresignDownloads, options.DownloadRedirectTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts, err := newAlerts(options.AlertStorageBytes, options.AlertChartVersions, options.AlertIndexBytes, options.AlertErrorRate, options.AlertInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	chartProtection, err := cm_repo.NewChartProtection(options.ProtectedCharts)
+	if err != nil {
+		return nil, err
+	}
+
+	chartAdmins := map[string]bool{}
+	for _, admin := range options.ChartAdmins {
+		chartAdmins[admin] = true
+	}
+
+	// nil lets anyone force overwrites
+	var forceOverwriteUsers map[string]bool
+	if len(options.ForceOverwriteUsers) > 0 {
+		forceOverwriteUsers = map[string]bool{}
+		for _, user := range options.ForceOverwriteUsers {
+			forceOverwriteUsers[user] = true
+		}
+	}
+
 	server := &MultiTenantServer{
 		Logger:                 options.Logger,
 		Router:                 options.Router,
-		StorageBackend:         options.StorageBackend,
+		StorageBackend:         &instrumentedBackend{Backend: withPagedListing(options.StorageBackend)},
 		TimestampTolerance:     options.TimestampTolerance,
 		ExternalCacheStore:     options.ExternalCacheStore,
 		InternalCacheStore:     map[string]*cacheEntry{},
 		MaxStorageObjects:      options.MaxStorageObjects,
 		IndexLimit:             options.IndexLimit,
+		IndexSharding:          indexSharding,
+		IndexJournal:           options.IndexJournal,
 		ChartURL:               chartURL,
+		ChartURLTemplate:       options.ChartURLTemplate,
 		ChartPostFormFieldName: options.ChartPostFormFieldName,
 		ProvPostFormFieldName:  options.ProvPostFormFieldName,
 		AllowOverwrite:         options.AllowOverwrite,
 		AllowForceOverwrite:    options.AllowForceOverwrite,
+		IdempotentUploads:      options.IdempotentUploads,
 		APIEnabled:             options.EnableAPI,
 		DisableDelete:          options.DisableDelete,
 		UseStatefiles:          options.UseStatefiles,
+		UseMetadataCache:       options.UseMetadataCache,
+		PersistMetadataCache:   options.PersistMetadataCache,
 		EnforceSemver2:         options.EnforceSemver2,
 		Version:                options.Version,
+		Revision:               options.Revision,
 		Limiter:                make(chan struct{}, options.IndexLimit),
 		Tenants:                map[string]*tenantInternals{},
-		TenantCacheKeyLock:     &sync.Mutex{},
+		TenantCacheKeyLock:     &sync.RWMutex{},
+		TenantInitGroup:        &singleflight.Group{},
+		PendingWrites:          new(int64),
+		AbandonedRequests:      new(int64),
+		Ready:                  new(int32),
+		PrimeRetryDelay:        options.PrimeRetryDelay,
 		CacheInterval:          options.CacheInterval,
+		StaleWhileRevalidate:   options.StaleWhileRevalidate,
+		MaxStaleness:           options.MaxStaleness,
 		ChartLimits:            l,
+		TenantConfig:           options.TenantConfig,
+		TenantAPIEnabled:       options.EnableTenantAPI,
+		TenantConfigLock:       &sync.Mutex{},
+		EventStream:            newEventStream(),
+		OCIEnabled:             options.EnableOCI,
+		OCIBlobs:               map[string]*ociBlob{},
+		OCIManifests:           map[string]bool{},
+		OCILock:                &sync.Mutex{},
+		WebUIEnabled:           options.EnableWebUI,
+		LandingJSON:            options.LandingJSON,
+		PprofEnabled:           options.EnablePprof,
+		ProxyUpstream:          options.ProxyUpstream,
+		ProxyIndexTTL:          options.ProxyIndexTTL,
+		Upstreams:              map[string]*upstream.Client{},
+		ProxyIndexes:           map[string]*proxyIndex{},
+		ProxyLock:              &sync.Mutex{},
+		ProxyGroup:             &singleflight.Group{},
+		VirtualIndexes:         map[string]*virtualIndex{},
+		VirtualLock:            &sync.Mutex{},
+		Replication:            options.Replication,
+		Replicas:               replicas,
+		Ingestion:              options.Ingestion,
+		Ingesters:              ingesters,
+		TrashRetention:         options.TrashRetention,
+		MaintenanceMessage:     options.MaintenanceMessage,
+		Maintenance:            newMaintenanceMode(),
+		ReloadLock:             &sync.RWMutex{},
+		Scanner:                scanner,
+		PendingScans:           map[string]bool{},
+		ScanLock:               &sync.Mutex{},
+		IndexAnnotations:       options.IndexAnnotations,
+		AnnotationsLock:        &sync.Mutex{},
+		ChartOwners:            options.ChartOwners || options.RestrictToOwners,
+		ChartHistory:           options.ChartHistory,
+		RestrictToOwners:       options.RestrictToOwners,
+		ChartAdmins:            chartAdmins,
+		ForceOverwriteUsers:    forceOverwriteUsers,
+		ChartProtection:        chartProtection,
+		OwnersLock:             &sync.Mutex{},
+		ChannelsLock:           &sync.Mutex{},
+		DigestLock:             &sync.Mutex{},
+		IndexSigner:            indexSigner,
+		CacheControlIndex:      options.CacheControlIndex,
+		CacheControlCharts:     options.CacheControlCharts,
+		ChartIcons:             options.ChartIcons,
+		DownloadLinkSecret:     []byte(options.DownloadLinkSecret),
+		DownloadLinkMaxTTL:     options.DownloadLinkMaxTTL,
+		DownloadRedirect:       downloadRedirect,
+		PrimeAllTenants:        options.PrimeAllTenants,
+		CacheTTL:               options.CacheTTL,
+		CacheMaxEntries:        options.CacheMaxEntries,
+		ResponseCache:          newResponseCache(options.APICacheTTL),
+		ChartPolicy:            chartPolicy,
+		Locker:                 options.Locker,
+		LockTimeout:            options.LockTimeout,
+		Leader:                 options.Leader,
+		Alerts:                 alerts,
+		Notifier: webhook.NewNotifier(webhook.NotifierOptions{
+			Logger:     options.Logger,
+			URLs:       options.WebhookURLs,
+			Publishers: options.EventPublishers,
+			Secret:     options.WebhookSecret,
+			MaxRetries: options.WebhookRetries,
+		}),
 	}
 
-	server.Router.SetRoutes(server.Routes())
-	err := server.primeCache()
+	for _, repo := range options.PrimeTenants {
+		repo = strings.Trim(repo, "/")
+		if err := server.validateTenantName(repo); err != nil {
+			return nil, fmt.Errorf("invalid tenant %q to prime: %s", repo, err.Message)
+		}
+		server.PrimeTenants = append(server.PrimeTenants, repo)
+	}
+
+	if server.PrimeRetryDelay <= 0 {
+		server.PrimeRetryDelay = primeRetryDelay
+	}
+
+	if options.RegenerationLimit > 0 {
+		server.RegenerationSlots = make(chan struct{}, options.RegenerationLimit)
+	}
+
+	if server.WebUIEnabled && !server.APIEnabled {
+		return nil, errors.New("web ui requires the api")
+	}
+
+	if server.ChartIcons != "" && !server.APIEnabled {
+		return nil, errors.New("chart icons require the api")
+	}
+
+	if len(server.DownloadLinkSecret) > 0 && !server.APIEnabled {
+		return nil, errors.New("download links require the api")
+	}
+
+	if options.LandingTemplate != "" {
+		server.LandingTemplate, err = template.ParseFiles(options.LandingTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse landing template: %s", err)
+		}
+	}
+
+	if server.PprofEnabled && !server.APIEnabled {
+		return nil, errors.New("pprof requires the api")
+	}
+	if options.MutexProfileFraction > 0 {
+		runtime.SetMutexProfileFraction(options.MutexProfileFraction)
+	}
+
+	if server.TenantAPIEnabled {
+		if server.TenantConfig == nil {
+			return nil, errors.New("tenant api requires a tenant config")
+		}
+		server.loadTenantConfig()
+	}
 
+	server.Router.SetRoutes(server.Routes())
+	if options.ValidateConfig {
+		server.validateConfig()
+	}
+	if options.ExportDirectory != "" {
+		server.export(options.ExportDirectory)
+	}
 	if options.GenIndex && server.Router.Depth == 0 {
+		err = server.primeCache()
 		server.genIndex()
+	} else {
+		// not ready until primed, see /ready
+		go server.primeCacheUntilReady()
 	}
 
 	server.EventChan = make(chan event, server.IndexLimit)
+	server.Router.RegisterOnShutdown(server.drainWrites)
+	// after the writes, which may still notify
+	server.Router.RegisterOnShutdown(func(context.Context) { server.Notifier.Close() })
 	go server.startEventListener()
 	server.initCacheTimer()
+	server.initCacheEviction()
+
+	// background jobs writing to storage run on the elected replica only, see IsLeader
+	if server.Leader != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		server.Router.RegisterOnShutdown(func(context.Context) { cancel() })
+		go server.Leader.Run(ctx)
+	}
+	server.initTrashTimer()
+	server.initAlertTimer()
+
+	if len(server.Replicas) > 0 {
+		go server.startReplication()
+	}
+
+	if len(server.Ingesters) > 0 {
+		go server.startIngestion()
+	}
 
 	return server, err
 }
@@ -178,9 +590,36 @@ func (server *MultiTenantServer) Listen(port int) {
 	server.Router.Start(port)
 }
 
+// Handler returns the handler of the routes of the server, for embedding it instead of calling Listen
+func (server *MultiTenantServer) Handler() http.Handler {
+	return server.Router.Handler()
+}
+
+// Shutdown waits for the writes in progress and stops the background jobs of a server served with
+// Handler, as Listen does on SIGTERM
+func (server *MultiTenantServer) Shutdown(ctx context.Context) {
+	server.Router.Shutdown(ctx)
+}
+
+// allowOverwrite reports whether chart versions can be re-uploaded to a repo without ?force
+func (server *MultiTenantServer) allowOverwrite(repo string) bool {
+	if overrides := server.TenantConfig.Lookup(repo); overrides != nil && overrides.AllowOverwrite != nil {
+		return *overrides.AllowOverwrite
+	}
+	return server.AllowOverwrite
+}
+
+// maxStorageObjects returns the max number of objects allowed in storage for a repo
+func (server *MultiTenantServer) maxStorageObjects(repo string) int {
+	if overrides := server.TenantConfig.Lookup(repo); overrides != nil && overrides.MaxStorageObjects != nil {
+		return *overrides.MaxStorageObjects
+	}
+	return server.MaxStorageObjects
+}
+
 func (server *MultiTenantServer) genIndex() {
 	log := server.Logger.ContextLoggingFn(&gin.Context{})
-	entry, err := server.initCacheEntry(log, "")
+	entry, err := server.initCacheEntry(context.Background(), log, "")
 	if err != nil {
 		panic(err)
 	}
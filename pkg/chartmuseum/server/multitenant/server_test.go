@@ -17,26 +17,57 @@ limitations under the License.
 package multitenant
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	pathutil "path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	"helm.sh/chartmuseum/pkg/ingestion"
+	"helm.sh/chartmuseum/pkg/lock"
+	"helm.sh/chartmuseum/pkg/replication"
 	"helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/scan"
+	"helm.sh/chartmuseum/pkg/tenant"
+	"helm.sh/chartmuseum/pkg/webhook"
 
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/chartmuseum/storage"
+	"github.com/ghodss/yaml"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/openpgp"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
 )
 
 var maxUploadSize = 1024 * 1024 * 20
@@ -66,6 +97,8 @@ type MultiTenantServerTestSuite struct {
 	MaxUploadSizeServer  *MultiTenantServer
 	Semver2Server        *MultiTenantServer
 	PerChartLimitServer  *MultiTenantServer
+	IndexShardingServer  *MultiTenantServer
+	Logger               *cm_logger.Logger
 	TempDirectory        string
 	TestTarballFilename  string
 	TestProvfileFilename string
@@ -113,11 +146,90 @@ func (suite *MultiTenantServerTestSuite) doRequest(stype string, method string,
 		suite.Semver2Server.Router.HandleContext(c)
 	case "per-chart-limit":
 		suite.PerChartLimitServer.Router.HandleContext(c)
+	case "indexsharding":
+		suite.IndexShardingServer.Router.HandleContext(c)
 	}
 
 	return c.Writer
 }
 
+// newTestServer creates a server for a single test, storing charts in dir in the temp directory
+// unless options name a storage backend, with a router and the logger of the suite
+func (suite *MultiTenantServerTestSuite) newTestServer(dir string, routerOptions cm_router.RouterOptions, options MultiTenantServerOptions) *MultiTenantServer {
+	if routerOptions.Logger == nil {
+		routerOptions.Logger = suite.Logger
+	}
+	if routerOptions.MaxUploadSize == 0 {
+		routerOptions.MaxUploadSize = maxUploadSize
+	}
+	if options.Logger == nil {
+		options.Logger = routerOptions.Logger
+	}
+	if options.Router == nil {
+		options.Router = cm_router.NewRouter(routerOptions)
+	}
+	if options.StorageBackend == nil {
+		dir = pathutil.Join(suite.TempDirectory, dir)
+		os.MkdirAll(dir, os.ModePerm)
+		options.StorageBackend = storage.NewLocalFilesystemBackend(dir)
+	}
+	server, err := NewMultiTenantServer(options)
+	suite.Nil(err, "no error creating server")
+	return server
+}
+
+// requestOption sets up a request before it is served by serve.
+type requestOption func(*http.Request)
+
+func withHeader(key string, value string) requestOption {
+	return func(request *http.Request) {
+		request.Header.Set(key, value)
+	}
+}
+
+func withBasicAuth(username string, password string) requestOption {
+	return func(request *http.Request) {
+		request.SetBasicAuth(username, password)
+	}
+}
+
+func withHost(host string) requestOption {
+	return func(request *http.Request) {
+		request.Host = host
+	}
+}
+
+// serve handles a request with the router of server, for the servers created by a single test
+func (suite *MultiTenantServerTestSuite) serve(server *MultiTenantServer, method string, urlStr string, body io.Reader, options ...requestOption) *httptest.ResponseRecorder {
+	if body == nil {
+		body = http.NoBody // as for the requests of an http.Server
+	}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request, _ = http.NewRequest(method, urlStr, body)
+	for _, option := range options {
+		option(c.Request)
+	}
+	server.Router.HandleContext(c)
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+// serveJSON is serve, also returning the JSON object in the response body
+func (suite *MultiTenantServerTestSuite) serveJSON(server *MultiTenantServer, method string, urlStr string, body io.Reader, options ...requestOption) (*httptest.ResponseRecorder, map[string]interface{}) {
+	recorder := suite.serve(server, method, urlStr, body, options...)
+	response := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, response
+}
+
+// readFile returns the content of a test file as a request body
+func (suite *MultiTenantServerTestSuite) readFile(path string) io.Reader {
+	content, err := ioutil.ReadFile(path)
+	suite.Nil(err, "no error opening "+path)
+	return bytes.NewReader(content)
+}
+
 func (suite *MultiTenantServerTestSuite) copyTestFilesTo(dir string) {
 	srcFileTarball, err := os.Open(testTarballPath)
 	suite.Nil(err, "no error opening test tarball")
@@ -222,6 +334,10 @@ func (suite *MultiTenantServerTestSuite) SetupSuite() {
 	})
 	suite.Nil(err, "no error creating logger")
 
+	// for the servers created by single tests
+	suite.Logger, err = cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err, "no error creating logger")
+
 	router := cm_router.NewRouter(cm_router.RouterOptions{
 		Logger:        logger,
 		Depth:         0,
@@ -464,6 +580,22 @@ func (suite *MultiTenantServerTestSuite) SetupSuite() {
 	suite.NotNil(server)
 	suite.Nil(err, "no error creating new max upload size server")
 	suite.MaxUploadSizeServer = server
+
+	router = cm_router.NewRouter(cm_router.RouterOptions{
+		Logger:        logger,
+		Depth:         1,
+		MaxUploadSize: maxUploadSize,
+	})
+	server, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:             logger,
+		Router:             router,
+		StorageBackend:     backend,
+		TimestampTolerance: time.Duration(0),
+		IndexSharding:      repo.IndexShardingAlpha,
+	})
+	suite.NotNil(server)
+	suite.Nil(err, "no error creating new index sharding server")
+	suite.IndexShardingServer = server
 }
 
 func (suite *MultiTenantServerTestSuite) TearDownSuite() {
@@ -477,53 +609,53 @@ func (suite *MultiTenantServerTestSuite) regenerateRepositoryIndex(repo string,
 	}
 	log := server.Logger.ContextLoggingFn(&gin.Context{})
 
-	entry, err := server.initCacheEntry(log, repo)
+	entry, err := server.initCacheEntry(context.Background(), log, repo)
 	suite.Nil(err, "no error on init cache entry")
 
-	objects, err := server.fetchChartsInStorage(log, repo)
+	objects, err := server.fetchChartsInStorage(context.Background(), log, repo)
 	if !isFound {
 		suite.Equal(len(objects), 0)
 		return
 	}
 	suite.Nil(err, "no error on fetchChartsInStorage")
 	diff := storage.GetObjectSliceDiff(server.getRepoObjectSlice(entry), objects, server.TimestampTolerance)
-	_, err = server.regenerateRepositoryIndexWorker(log, entry, diff)
+	_, err = server.regenerateRepositoryIndexWorker(context.Background(), log, entry, diff)
 	suite.Nil(err, "no error regenerating repo index")
 
 	newtime := time.Now().Add(1 * time.Hour)
 	err = os.Chtimes(suite.TestTarballFilename, newtime, newtime)
 	suite.Nil(err, "no error changing modtime on temp file")
 
-	objects, err = server.fetchChartsInStorage(log, repo)
+	objects, err = server.fetchChartsInStorage(context.Background(), log, repo)
 	suite.Nil(err, "no error on fetchChartsInStorage")
 	diff = storage.GetObjectSliceDiff(server.getRepoObjectSlice(entry), objects, server.TimestampTolerance)
-	_, err = server.regenerateRepositoryIndexWorker(log, entry, diff)
+	_, err = server.regenerateRepositoryIndexWorker(context.Background(), log, entry, diff)
 	suite.Nil(err, "no error regenerating repo index with tarball updated")
 
 	brokenTarballFilename := pathutil.Join(suite.TempDirectory, "brokenchart.tgz")
 	destFile, err := os.Create(brokenTarballFilename)
 	suite.Nil(err, "no error creating new broken tarball in temp dir")
 	defer destFile.Close()
-	objects, err = server.fetchChartsInStorage(log, repo)
+	objects, err = server.fetchChartsInStorage(context.Background(), log, repo)
 	suite.Nil(err, "no error on fetchChartsInStorage")
 	diff = storage.GetObjectSliceDiff(server.getRepoObjectSlice(entry), objects, server.TimestampTolerance)
-	_, err = server.regenerateRepositoryIndexWorker(log, entry, diff)
+	_, err = server.regenerateRepositoryIndexWorker(context.Background(), log, entry, diff)
 	suite.Nil(err, "error not returned with broken tarball added")
 
 	err = os.Chtimes(brokenTarballFilename, newtime, newtime)
 	suite.Nil(err, "no error changing modtime on broken tarball")
-	objects, err = server.fetchChartsInStorage(log, repo)
+	objects, err = server.fetchChartsInStorage(context.Background(), log, repo)
 	suite.Nil(err, "no error on fetchChartsInStorage")
 	diff = storage.GetObjectSliceDiff(server.getRepoObjectSlice(entry), objects, server.TimestampTolerance)
-	_, err = server.regenerateRepositoryIndexWorker(log, entry, diff)
+	_, err = server.regenerateRepositoryIndexWorker(context.Background(), log, entry, diff)
 	suite.Nil(err, "error not returned with broken tarball updated")
 
 	err = os.Remove(brokenTarballFilename)
 	suite.Nil(err, "no error removing broken tarball")
-	objects, err = server.fetchChartsInStorage(log, repo)
+	objects, err = server.fetchChartsInStorage(context.Background(), log, repo)
 	suite.Nil(err, "no error on fetchChartsInStorage")
 	diff = storage.GetObjectSliceDiff(server.getRepoObjectSlice(entry), objects, server.TimestampTolerance)
-	_, err = server.regenerateRepositoryIndexWorker(log, entry, diff)
+	_, err = server.regenerateRepositoryIndexWorker(context.Background(), log, entry, diff)
 	suite.Nil(err, "error not returned with broken tarball removed")
 }
 
@@ -629,279 +761,3996 @@ generated: "2018-05-23T15:14:46-05:00"`)
 	suite.Contains(suite.LastPrinted, "apiVersion:", "--gen-index prints yaml")
 }
 
-func (suite *MultiTenantServerTestSuite) TestDisabledServer() {
-	// Test that all /api routes disabled if EnableAPI=false
-	res := suite.doRequest("disabled", "GET", "/api/charts", nil, "")
-	suite.Equal(404, res.Status(), "404 GET /api/charts")
+func (suite *MultiTenantServerTestSuite) TestMetadataCache() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{
+		Debug: true,
+	})
+	suite.Nil(err, "no error creating logger")
 
-	res = suite.doRequest("disabled", "GET", "/api/charts/mychart", nil, "")
-	suite.Equal(404, res.Status(), "404 GET /api/charts")
+	dir := pathutil.Join(suite.TempDirectory, "metadatacache")
+	os.MkdirAll(dir, os.ModePerm)
+	suite.copyTestFilesTo(dir)
+	backend := storage.Backend(storage.NewLocalFilesystemBackend(dir))
 
-	res = suite.doRequest("disabled", "GET", "/api/charts/mychart/0.1.0", nil, "")
-	suite.Equal(404, res.Status(), "404 GET /api/charts")
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:               logger,
+		Router:               cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:       backend,
+		UseMetadataCache:     true,
+		PersistMetadataCache: true,
+	})
+	suite.Nil(err, "no error creating server with metadata cache")
+	suite.waitUntilReady(server)
 
-	body := bytes.NewBuffer([]byte{})
-	res = suite.doRequest("disabled", "POST", "/api/charts", body, "")
-	suite.Equal(404, res.Status(), "404 POST /api/charts")
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	tenant := server.getTenant("")
+	suite.NotNil(tenant.MetadataCache, "metadata cache created")
+	suite.Equal(1, tenant.MetadataCache.Len(), "chart package cached on index regeneration")
 
-	body = bytes.NewBuffer([]byte{})
-	res = suite.doRequest("disabled", "POST", "/api/prov", body, "")
-	suite.Equal(404, res.Status(), "404 POST /api/prov")
+	server.saveMetadataCache(log, "", tenant.MetadataCache)
+	_, err = backend.GetObject(repo.MetadataCacheFilename)
+	suite.Nil(err, "metadata-cache.yaml saved in storage")
 
-	res = suite.doRequest("disabled", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
-	suite.Equal(404, res.Status(), "404 DELETE /api/charts/mychart/0.1.0")
-}
+	loaded := server.newMetadataCache(context.Background(), log, "")
+	suite.Equal(1, loaded.Len(), "metadata-cache.yaml loaded from storage")
 
-func (suite *MultiTenantServerTestSuite) TestDisabledDeleteServer() {
-	res := suite.doRequest("disableddelete", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
-	suite.Equal(404, res.Status(), "404 DELETE /api/charts/mychart/0.1.0")
+	err = ioutil.WriteFile(pathutil.Join(dir, repo.MetadataCacheFilename), []byte("{{{"), 0644)
+	suite.Nil(err, "no error creating invalid metadata-cache.yaml")
+	loaded = server.newMetadataCache(context.Background(), log, "")
+	suite.Equal(0, loaded.Len(), "empty metadata cache when metadata-cache.yaml invalid")
+
+	entry, err := server.initCacheEntry(context.Background(), log, "")
+	suite.Nil(err, "no error on init cache entry")
+	err = os.Remove(pathutil.Join(dir, "mychart-0.1.0.tgz"))
+	suite.Nil(err, "no error removing chart package")
+	objects, err := server.fetchChartsInStorage(context.Background(), log, "")
+	suite.Nil(err, "no error on fetchChartsInStorage")
+	diff := storage.GetObjectSliceDiff(server.getRepoObjectSlice(entry), objects, server.TimestampTolerance)
+	_, err = server.regenerateRepositoryIndexWorker(context.Background(), log, entry, diff)
+	suite.Nil(err, "no error regenerating repo index with chart package removed")
+	suite.Equal(0, tenant.MetadataCache.Len(), "removed chart package evicted from metadata cache")
 }
 
-func (suite *MultiTenantServerTestSuite) extractRepoEntryFromInternalCache(repo string) *cacheEntry {
-	local, ok := suite.OverwriteServer.InternalCacheStore[repo]
-	if ok {
-		return local
+func (suite *MultiTenantServerTestSuite) TestStaleWhileRevalidate() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{
+		Debug: true,
+	})
+	suite.Nil(err, "no error creating logger")
+
+	dir := pathutil.Join(suite.TempDirectory, "stalewhilerevalidate")
+	os.MkdirAll(dir, os.ModePerm)
+	suite.copyTestFilesTo(dir)
+
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:               logger,
+		Router:               cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:       storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		StaleWhileRevalidate: true,
+	})
+	suite.Nil(err, "no error creating server with stale-while-revalidate")
+	suite.waitUntilReady(server)
+
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	tenant := server.getTenant("")
+	waitForRefresh := func() {
+		for i := 0; i < 100; i++ {
+			tenant.RefreshLock.Lock()
+			refreshing := tenant.Refreshing
+			tenant.RefreshLock.Unlock()
+			if !refreshing {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		suite.Fail("background refresh did not complete")
 	}
-	return nil
+
+	index, httpErr := server.getIndexFile(context.Background(), log, "")
+	suite.Nil(httpErr, "no error getting index")
+	suite.Equal(1, len(index.Entries["mychart"]), "index generated on first request")
+	waitForRefresh()
+
+	content, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error reading test tarball")
+	err = ioutil.WriteFile(pathutil.Join(dir, "mychart-0.2.0.tgz"), content, 0644)
+	suite.Nil(err, "no error adding chart package to storage")
+
+	index, httpErr = server.getIndexFile(context.Background(), log, "")
+	suite.Nil(httpErr, "no error getting stale index")
+	suite.Equal(1, len(index.Entries["mychart"]), "stale index served while refreshing")
+	waitForRefresh()
+
+	index, httpErr = server.getIndexFile(context.Background(), log, "")
+	suite.Nil(httpErr, "no error getting refreshed index")
+	suite.Equal(2, len(index.Entries["mychart"]), "refreshed index served")
+	waitForRefresh()
+
+	// once the index is older than the max staleness, requests wait for the refresh
+	server.MaxStaleness = time.Minute
+	tenant.RefreshLock.Lock()
+	tenant.LastRefreshed = time.Now().Add(-time.Hour)
+	tenant.RefreshLock.Unlock()
+	err = os.Remove(pathutil.Join(dir, "mychart-0.2.0.tgz"))
+	suite.Nil(err, "no error removing chart package from storage")
+
+	index, httpErr = server.getIndexFile(context.Background(), log, "")
+	suite.Nil(httpErr, "no error getting index past max staleness")
+	suite.Equal(1, len(index.Entries["mychart"]), "index refreshed before being served")
 }
 
-func (suite *MultiTenantServerTestSuite) TestOverwriteServer() {
-	// Check if files can be overwritten
-	content, err := ioutil.ReadFile(testTarballPath)
-	suite.Nil(err, "no error opening test tarball")
-	body := bytes.NewBuffer(content)
-	res := suite.doRequest("overwrite", "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("overwrite", "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
+func (suite *MultiTenantServerTestSuite) TestIndexShardingServer() {
+	buffer := bytes.NewBufferString("")
+	res := suite.doRequest("indexsharding", "GET", "/org1/index.yaml", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /org1/index.yaml")
+	suite.Contains(buffer.String(), "index-m.yaml", "root index links to shard")
+	suite.NotContains(buffer.String(), "mychart-0.1.0.tgz", "root index has no entries")
 
-	{
-		// waiting for the emit event
-		// the event is transferred via a channel , here do a simple wait for not changing the original structure
-		// only for testing purpose
-		time.Sleep(time.Second)
-		// depth: 0
-		e := suite.extractRepoEntryFromInternalCache("")
-		suite.Equal(1, len(e.RepoIndex.Entries), "overwrite entries validation")
-	}
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest("indexsharding", "GET", "/org1/index-m.yaml", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /org1/index-m.yaml")
+	suite.Contains(buffer.String(), "mychart-0.1.0.tgz", "shard has entries")
 
-	content, err = ioutil.ReadFile(testProvfilePath)
-	suite.Nil(err, "no error opening test provenance file")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("overwrite", "POST", "/api/prov", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/prov")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("overwrite", "POST", "/api/prov", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/prov")
+	buffer = bytes.NewBufferString("")
+	res = suite.doRequest("indexsharding", "GET", "/org1/index-z.yaml", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /org1/index-z.yaml")
+	suite.NotContains(buffer.String(), "mychart-0.1.0.tgz", "empty shard")
 
-	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
-	res = suite.doRequest("overwrite", "POST", "/api/charts", buf, w.FormDataContentType())
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
-	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
-	res = suite.doRequest("overwrite", "POST", "/api/charts", buf, w.FormDataContentType())
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
-	{
-		// the same as chart only case above
-		time.Sleep(time.Second)
-		// depth: 0
-		e := suite.extractRepoEntryFromInternalCache("")
-		suite.Equal(1, len(e.RepoIndex.Entries), "overwrite entries validation")
-	}
+	res = suite.doRequest("indexsharding", "GET", "/org1/index-zz.yaml", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /org1/index-zz.yaml")
+
+	res = suite.doRequest("indexsharding", "GET", "/org1/README.md", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /org1/README.md")
+
+	res = suite.doRequest("indexsharding", "GET", "/org1/charts/mychart-0.1.0.tgz", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /org1/charts/mychart-0.1.0.tgz")
+
+	logger := suite.Logger
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: suite.Depth0Server.StorageBackend,
+		IndexSharding:  "bogus",
+	})
+	suite.NotNil(err, "error creating server with invalid index sharding mode")
 }
 
-func (suite *MultiTenantServerTestSuite) TestBadChartUpload() {
-	content, err := ioutil.ReadFile(badTestTarballPath)
-	suite.Nil(err, "no error opening test tarball")
+func (suite *MultiTenantServerTestSuite) TestChartURLTemplate() {
+	logger := suite.Logger
 
-	body := bytes.NewBuffer(content)
-	res := suite.doRequest("depth0", "POST", "/api/charts", body, "")
-	suite.Equal(400, res.Status(), "400 POST /api/charts")
+	server := suite.newTestServer("", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		StorageBackend:   suite.Depth1Server.StorageBackend,
+		ChartURLTemplate: "{scheme}://{host}/{tenant}/charts",
+	})
 
-	content, err = ioutil.ReadFile(badTestProvfilePath)
-	suite.Nil(err, "no error opening test provenance file")
+	getIndex := func(host string, headers map[string]string) string {
+		options := []requestOption{withHost(host)}
+		for k, v := range headers {
+			options = append(options, withHeader(k, v))
+		}
+		res := suite.serve(server, "GET", "/org1/index.yaml", nil, options...)
+		suite.Equal(200, res.Code, "200 GET /org1/index.yaml")
+		return res.Body.String()
+	}
 
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("depth0", "POST", "/api/prov", body, "")
-	suite.Equal(400, res.Status(), "400 POST /api/prov")
+	body := getIndex("charts.example.com", nil)
+	suite.Contains(body, "http://charts.example.com/org1/charts/mychart-0.1.0.tgz", "chart url built from host")
 
-	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{badTestTarballPath, badTestProvfilePath})
-	res = suite.doRequest("depth0", "POST", "/api/charts", buf, w.FormDataContentType())
-	suite.Equal(400, res.Status(), "400 POST /api/charts")
+	body = getIndex("internal:8080", map[string]string{
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "charts.example.org, proxy.local",
+	})
+	suite.Contains(body, "https://charts.example.org/org1/charts/mychart-0.1.0.tgz", "chart url built from forwarded headers")
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:           logger,
+		Router:           cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:   suite.Depth0Server.StorageBackend,
+		ChartURL:         "https://charts.example.com",
+		ChartURLTemplate: "{scheme}://{host}/charts",
+	})
+	suite.NotNil(err, "error creating server with both chart url and chart url template")
 }
 
-func (suite *MultiTenantServerTestSuite) TestForceOverwriteServer() {
-	// Clear test repo to allow uploading again
-	res := suite.doRequest("forceoverwrite", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
-	suite.Equal(200, res.Status(), "200 DELETE /api/charts/mychart/0.1.0")
+func (suite *MultiTenantServerTestSuite) TestTenantConfig() {
+	allowOverwrite := true
+	maxStorageObjects := 1
+	server := suite.newTestServer("", cm_router.RouterOptions{Depth: 3}, MultiTenantServerOptions{
+		StorageBackend:    suite.Depth3Server.StorageBackend,
+		EnableAPI:         true,
+		MaxStorageObjects: 100,
+		TenantConfig: &tenant.Config{Tenants: map[string]*tenant.Overrides{
+			"org1":       {AllowOverwrite: &allowOverwrite},
+			"org2/team1": {MaxStorageObjects: &maxStorageObjects},
+		}},
+	})
+
+	suite.True(server.allowOverwrite("org1/team1/repo1"), "overwrite allowed by tenant")
+	suite.False(server.allowOverwrite("org2/team1/repo1"), "overwrite not allowed by server")
+	suite.Equal(1, server.maxStorageObjects("org2/team1/repo1"), "storage limit of tenant")
+	suite.Equal(100, server.maxStorageObjects("org1/team1/repo1"), "storage limit of server")
 
-	// Check if files can be overwritten when ?force is on URL
 	content, err := ioutil.ReadFile(testTarballPath)
 	suite.Nil(err, "no error opening test tarball")
-	body := bytes.NewBuffer(content)
-	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", body, "")
-	suite.Equal(409, res.Status(), "409 POST /api/charts")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("forceoverwrite", "POST", "/api/charts?force", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts?force")
 
-	content, err = ioutil.ReadFile(testProvfilePath)
-	suite.Nil(err, "no error opening test provenance file")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("forceoverwrite", "POST", "/api/prov", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/prov")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("forceoverwrite", "POST", "/api/prov", body, "")
-	suite.Equal(409, res.Status(), "409 POST /api/prov")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("forceoverwrite", "POST", "/api/prov?force", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/prov?force")
+	res := suite.serve(server, "POST", "/api/org1/team1/repo1/charts", bytes.NewReader(content))
+	suite.Equal(201, res.Code, "201 POST existing chart to tenant allowing overwrite")
 
-	// Clear test repo to allow uploading again
-	res = suite.doRequest("forceoverwrite", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
-	suite.Equal(200, res.Status(), "200 DELETE /api/charts/mychart/0.1.0")
+	otherContent, err := ioutil.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
 
-	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
-	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", buf, w.FormDataContentType())
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
-	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
-	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", buf, w.FormDataContentType())
-	suite.Equal(409, res.Status(), "409 POST /api/charts")
-	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
-	res = suite.doRequest("forceoverwrite", "POST", "/api/charts?force", buf, w.FormDataContentType())
-	suite.Equal(201, res.Status(), "201 POST /api/charts?force")
+	res = suite.serve(server, "POST", "/api/org2/team1/repo1/charts", bytes.NewReader(otherContent))
+	suite.Equal(507, res.Code, "507 POST chart to tenant over storage limit")
 }
 
-func (suite *MultiTenantServerTestSuite) TestCustomChartURLServer() {
-	res := suite.doRequest("charturl", "GET", "/index.yaml", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /index.yaml")
-}
+func (suite *MultiTenantServerTestSuite) TestTenantAPI() {
+	dir := pathutil.Join(suite.TempDirectory, "tenantapi")
+	os.MkdirAll(dir, os.ModePerm)
+	backend := storage.Backend(storage.NewLocalFilesystemBackend(dir))
+
+	newServer := func(username string, password string) *MultiTenantServer {
+		// the router authorizes requests with the tenants managed by the server
+		tenantConfig := &tenant.Config{}
+		server := suite.newTestServer("", cm_router.RouterOptions{Depth: 2, TenantConfig: tenantConfig, Username: username, Password: password}, MultiTenantServerOptions{
+			StorageBackend:  backend,
+			EnableAPI:       true,
+			EnableTenantAPI: true,
+			UseStatefiles:   true,
+			TenantConfig:    tenantConfig,
+		})
+		return server
+	}
+	server := newServer("admin", "adminpass")
+	admin := withBasicAuth("admin", "adminpass")
+
+	res := suite.serve(server, "POST", "/api/tenants", strings.NewReader(`{"name": "org1/team1", "allowOverwrite": true, "basicAuthUser": "user", "basicAuthPass": "pass", "credentials": {"alice": "secret"}}`), admin)
+	suite.Equal(201, res.Code, "201 POST /api/tenants")
+	_, err := backend.GetObject(pathutil.Join("org1/team1", repo.StatefileFilename))
+	suite.Nil(err, "tenant storage provisioned")
+	_, err = backend.GetObject(tenant.ConfigFilename)
+	suite.Nil(err, "tenants.yaml saved in storage")
+	suite.True(server.allowOverwrite("org1/team1"), "tenant overrides applied")
+	object, err := backend.GetObject(tenant.ConfigFilename)
+	suite.Nil(err, "tenants.yaml saved in storage")
+	suite.False(strings.Contains(string(object.Content), "pass"), "basic auth password hashed in tenants.yaml")
+	suite.False(strings.Contains(string(object.Content), "secret"), "credentials map passwords hashed in tenants.yaml")
+	for password, expectedStatus := range map[string]int{"secret": 200, "wrong": 401} {
+		res = suite.serve(server, "GET", "/org1/team1/index.yaml", nil, withBasicAuth("alice", password))
+		suite.Equal(expectedStatus, res.Code, "GET /org1/team1/index.yaml with password "+password)
+	}
+
+	res = suite.serve(server, "POST", "/api/tenants", strings.NewReader(`{"name": "org1/team1"}`), admin)
+	suite.Equal(409, res.Code, "409 POST /api/tenants existing tenant")
+
+	for _, body := range []string{`{"name": "../org2"}`, `{"name": "org2/team1/repo1"}`, `{"name": "org2", "basicAuthUser": "user"}`, `{"name": "org2", "credentials": {"alice": ""}}`, `not json`} {
+		res = suite.serve(server, "POST", "/api/tenants", strings.NewReader(body), admin)
+		suite.Equal(400, res.Code, "400 POST /api/tenants "+body)
+	}
+
+	res = suite.serve(server, "GET", "/api/tenants", nil, admin)
+	suite.Equal(200, res.Code, "200 GET /api/tenants")
+	suite.True(strings.Contains(res.Body.String(), `"name":"org1/team1"`), "tenant listed")
+	suite.False(strings.Contains(res.Body.String(), "pass"), "credentials not listed")
+	suite.False(strings.Contains(res.Body.String(), "secret"), "credentials map passwords not listed")
+	suite.True(strings.Contains(res.Body.String(), `"alice"`), "credentials map usernames listed")
+
+	server = newServer("admin", "adminpass")
+	suite.True(server.allowOverwrite("org1/team1"), "tenants loaded from storage")
+
+	// with credentials for tenants only, the tenant api would be authorized as any route on no repo
+	openServer := newServer("", "")
+	res = suite.serve(openServer, "POST", "/api/tenants", strings.NewReader(`{"name": "org2"}`))
+	suite.Equal(403, res.Code, "403 POST /api/tenants without server credentials")
+	res = suite.serve(openServer, "DELETE", "/api/tenants?name=org1/team1", nil)
+	suite.Equal(403, res.Code, "403 DELETE /api/tenants without server credentials")
+	res = suite.serve(openServer, "GET", "/api/admin/maintenance", nil)
+	suite.Equal(403, res.Code, "403 GET /api/admin/maintenance without server credentials")
+	suite.True(openServer.allowOverwrite("org1/team1"), "tenant kept")
 
-func (suite *MultiTenantServerTestSuite) TestMaxObjectsServer() {
-	// Overwrites should still be allowed if limit is reached
 	content, err := ioutil.ReadFile(testTarballPath)
 	suite.Nil(err, "no error opening test tarball")
-	body := bytes.NewBuffer(content)
-	res := suite.doRequest("maxobjects", "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	res = suite.serve(server, "POST", "/api/org1/team1/charts", bytes.NewReader(content), withBasicAuth("user", "pass"))
+	suite.Equal(201, res.Code, "201 POST chart to new tenant with its hashed credentials")
 
-	content, err = ioutil.ReadFile(testProvfilePath)
-	suite.Nil(err, "no error opening test provenance file")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("maxobjects", "POST", "/api/prov", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/prov")
+	res = suite.serve(server, "DELETE", "/api/tenants?name=org2", nil, admin)
+	suite.Equal(404, res.Code, "404 DELETE /api/tenants missing tenant")
 
-	// trigger error, reached max
-	content, err = ioutil.ReadFile(otherTestTarballPath)
-	suite.Nil(err, "no error opening other test tarball")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("maxobjects", "POST", "/api/charts", body, "")
-	suite.Equal(507, res.Status(), "507 POST /api/charts")
+	res = suite.serve(server, "DELETE", "/api/tenants?name=org1/team1", nil, admin)
+	suite.Equal(200, res.Code, "200 DELETE /api/tenants")
+	suite.False(server.allowOverwrite("org1/team1"), "tenant overrides removed")
+	_, err = backend.GetObject("org1/team1/mychart-0.1.0.tgz")
+	suite.Nil(err, "tenant charts kept without purge")
 
-	content, err = ioutil.ReadFile(otherTestProvfilePath)
-	suite.Nil(err, "no error opening other test provenance file")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("maxobjects", "POST", "/api/prov", body, "")
-	suite.Equal(507, res.Status(), "507 POST /api/prov")
+	res = suite.serve(server, "POST", "/api/tenants", strings.NewReader(`{"name": "org1/team1"}`), admin)
+	suite.Equal(201, res.Code, "201 POST /api/tenants recreated tenant")
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	entry, err := server.initCacheEntry(context.Background(), log, "org1/team1")
+	suite.Nil(err, "no error on init cache entry")
+	res = suite.serve(server, "DELETE", "/api/tenants?name=org1/team1&purge", nil, admin)
+	suite.Equal(200, res.Code, "200 DELETE /api/tenants with purge")
+	_, err = backend.GetObject("org1/team1/mychart-0.1.0.tgz")
+	suite.NotNil(err, "tenant charts purged")
+	suite.Nil(server.getTenant("org1/team1"), "tenant evicted from cache")
+	suite.NotPanics(func() {
+		server.getRepoIndex(entry)
+		server.refreshCacheEntry(context.Background(), log, "org1/team1", entry)
+	}, "entry of a request in flight still usable once its tenant is evicted")
+	suite.NotContains(server.InternalCacheStore, "org1/team1", "entry of an evicted tenant not saved again")
+
+	// purging a tenant keeps the repos of the tenants nested under it
+	for _, name := range []string{"org1", "org1/team1"} {
+		res = suite.serve(server, "POST", "/api/tenants", strings.NewReader(`{"name": "`+name+`"}`), admin)
+		suite.Equal(201, res.Code, "201 POST /api/tenants "+name)
+	}
+	res = suite.serve(server, "POST", "/api/org1/team1/charts", bytes.NewReader(content), admin)
+	suite.Equal(201, res.Code, "201 POST chart to nested tenant")
+	suite.Nil(backend.PutObject("org1/mychart-0.1.0.tgz", content), "no error saving chart of tenant")
+	res = suite.serve(server, "DELETE", "/api/tenants?name=org1&purge", nil, admin)
+	suite.Equal(200, res.Code, "200 DELETE /api/tenants with purge and nested tenant")
+	_, err = backend.GetObject("org1/mychart-0.1.0.tgz")
+	suite.NotNil(err, "tenant charts purged")
+	_, err = backend.GetObject("org1/team1/mychart-0.1.0.tgz")
+	suite.Nil(err, "charts of nested tenant kept")
 }
 
-func (suite *MultiTenantServerTestSuite) TestPerChartLimit() {
-	ns := "per-chart-limit"
-	content, err := ioutil.ReadFile(testTarballPathV0)
-	suite.Nil(err, "no error opening test tarball")
-	body := bytes.NewBuffer(content)
-	res := suite.doRequest(ns, "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
+func (suite *MultiTenantServerTestSuite) TestPromoteChartVersion() {
+	tenantConfig := &tenant.Config{Tenants: map[string]*tenant.Overrides{
+		"staging": {Credentials: map[string]string{"dev": "devpass", "release": "releasepass"}},
+		"prod":    {Credentials: map[string]string{"release": "releasepass"}},
+	}}
+	server := suite.newTestServer("promote", cm_router.RouterOptions{Depth: 1, TenantConfig: tenantConfig}, MultiTenantServerOptions{
+		EnableAPI:    true,
+		TenantConfig: tenantConfig,
+	})
 
-	content, err = ioutil.ReadFile(testTarballPathV2)
+	content, err := ioutil.ReadFile(testTarballPath)
 	suite.Nil(err, "no error opening test tarball")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest(ns, "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	status := suite.serve(server, "POST", "/api/staging/charts", bytes.NewBuffer(content), withBasicAuth("dev", "devpass")).Code
+	suite.Equal(201, status, "201 POST chart to staging")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	status = suite.serve(server, "POST", "/api/staging/prov", bytes.NewBuffer(provContent), withBasicAuth("dev", "devpass")).Code
+	suite.Equal(201, status, "201 POST prov file to staging")
 
-	content, err = ioutil.ReadFile(testTarballPath)
-	suite.Nil(err, "no error opening test tarball")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest(ns, "POST", "/api/charts", body, "")
-	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	status = suite.serve(server, "POST", "/api/staging/charts/mychart/0.1.0/promote?target=prod", nil, withBasicAuth("dev", "devpass")).Code
+	suite.Equal(401, status, "401 promote without push access to target")
 
-	time.Sleep(time.Second)
+	status = suite.serve(server, "POST", "/api/staging/charts/mychart/0.1.0/promote", nil, withBasicAuth("release", "releasepass")).Code
+	suite.Equal(400, status, "400 promote without target")
 
-	res = suite.doRequest(ns, "GET", "/api/charts/mychart/0.2.0", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart-0.2.0")
+	status = suite.serve(server, "POST", "/api/staging/charts/mychart/9.9.9/promote?target=prod", nil, withBasicAuth("release", "releasepass")).Code
+	suite.Equal(404, status, "404 promote missing chart version")
 
-	res = suite.doRequest(ns, "GET", "/api/charts/mychart/0.1.0", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart-0.1.0")
+	status = suite.serve(server, "POST", "/api/staging/charts/mychart/0.1.0/promote?target=prod", nil, withBasicAuth("release", "releasepass")).Code
+	suite.Equal(201, status, "201 promote chart version")
+	_, err = server.StorageBackend.GetObject("prod/mychart-0.1.0.tgz")
+	suite.Nil(err, "chart package copied to target")
+	_, err = server.StorageBackend.GetObject("prod/mychart-0.1.0.tgz.prov")
+	suite.Nil(err, "provenance file copied to target")
 
-	res = suite.doRequest(ns, "GET", "/api/charts/mychart/0.0.1", nil, "")
-	suite.Equal(404, res.Status(), "200 GET /api/charts/mychart-0.0.1")
+	status = suite.serve(server, "POST", "/api/staging/charts/mychart/0.1.0/promote?target=prod", nil, withBasicAuth("release", "releasepass")).Code
+	suite.Equal(409, status, "409 promote chart version already in target")
+
+	status = suite.serve(server, "GET", "/api/prod/charts/mychart/0.1.0", nil, withBasicAuth("release", "releasepass")).Code
+	suite.Equal(200, status, "200 GET promoted chart version")
 }
 
-func (suite *MultiTenantServerTestSuite) TestMaxUploadSizeServer() {
-	// trigger 413s, "request too large"
-	content, err := ioutil.ReadFile(testTarballPath)
+func (suite *MultiTenantServerTestSuite) TestCatalog() {
+	dir := pathutil.Join(suite.TempDirectory, "catalog")
+	os.MkdirAll(dir, os.ModePerm)
+	// a repo only in storage, never requested before the catalog
+	stored, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	os.MkdirAll(pathutil.Join(dir, "org4"), os.ModePerm)
+	err = ioutil.WriteFile(pathutil.Join(dir, "org4", pathutil.Base(testTarballPath)), stored, 0644)
+	suite.Nil(err, "no error writing chart to org4 storage")
+	server := suite.newTestServer("catalog", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI: true,
+		TenantConfig: &tenant.Config{Tenants: map[string]*tenant.Overrides{
+			"org3": {},
+		}},
+	})
+
+	for _, tarballPath := range []string{testTarballPath, testTarballPathV2} {
+		content, err := ioutil.ReadFile(tarballPath)
+		suite.Nil(err, "no error opening test tarball")
+		res := suite.serve(server, "POST", "/api/org1/charts", bytes.NewBuffer(content))
+		suite.Equal(201, res.Code, "201 POST chart to org1")
+	}
+	res := suite.serve(server, "GET", "/org2/index.yaml", nil)
+	suite.Equal(200, res.Code, "200 GET /org2/index.yaml")
+
+	var catalog struct {
+		Tenants []catalogEntry `json:"tenants"`
+	}
+	// uploads reach the cached index asynchronously
+	suite.Eventually(func() bool {
+		res = suite.serve(server, "GET", "/api/catalog?usage", nil)
+		suite.Equal(200, res.Code, "200 GET /api/catalog")
+		suite.Nil(json.Unmarshal(res.Body.Bytes(), &catalog), "catalog is json")
+		return len(catalog.Tenants) > 0 && catalog.Tenants[0].ChartVersions == 2
+	}, 5*time.Second, 10*time.Millisecond, "uploaded chart versions in catalog")
+	suite.Equal(4, len(catalog.Tenants), "stored, cached and configured tenants in catalog")
+
+	org1 := catalog.Tenants[0]
+	suite.Equal("org1", org1.Name)
+	suite.Equal(1, org1.Charts)
+	suite.Equal(2, org1.ChartVersions)
+	suite.Equal(2, org1.StorageObjects)
+	suite.NotNil(org1.StorageBytes, "storage usage with ?usage")
+	suite.True(*org1.StorageBytes > 0, "storage usage of org1")
+	suite.NotNil(org1.LastUpdated, "last update of org1")
+
+	suite.Equal("org2", catalog.Tenants[1].Name)
+	suite.Equal(0, catalog.Tenants[1].ChartVersions)
+	suite.Nil(catalog.Tenants[1].LastUpdated, "no last update for empty tenant")
+	suite.Equal("org3", catalog.Tenants[2].Name, "configured tenant in catalog")
+	suite.Equal("org4", catalog.Tenants[3].Name, "tenant in storage in catalog")
+	suite.Equal(1, catalog.Tenants[3].ChartVersions, "chart versions of tenant in storage")
+
+	res = suite.serve(server, "GET", "/api/catalog", nil)
+	suite.Equal(200, res.Code, "200 GET /api/catalog")
+	suite.False(strings.Contains(res.Body.String(), "storageBytes"), "no storage usage without ?usage")
+}
+
+func (suite *MultiTenantServerTestSuite) TestWebhooks() {
+	var mutex sync.Mutex
+	var events []webhook.Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := webhook.Event{}
+		suite.Nil(json.NewDecoder(r.Body).Decode(&event), "no error decoding webhook event")
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}))
+	defer receiver.Close()
+	eventTypes := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		types := []string{}
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		return types
+	}
+
+	server := suite.newTestServer("webhooks", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:   true,
+		WebhookURLs: []string{receiver.URL},
+	})
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	res := suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content), withHeader("X-Request-Id", "push-1"))
+	suite.Equal(201, res.Code, "201 POST chart")
+
+	suite.Eventually(func() bool { return len(eventTypes()) == 2 }, 5*time.Second, 10*time.Millisecond,
+		"webhook notified of upload")
+	suite.Equal([]string{webhook.ChartUploadedEvent, webhook.IndexRegeneratedEvent}, eventTypes())
+	mutex.Lock()
+	suite.Equal("org1", events[0].Repo)
+	suite.Equal("mychart", events[0].Chart.Name)
+	suite.Equal("0.1.0", events[0].Chart.Version)
+	suite.Equal("push-1", events[0].RequestID, "request id of the upload in its events")
+	suite.Equal("push-1", events[1].RequestID, "request id of the upload in its events")
+	mutex.Unlock()
+
+	res = suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content), withHeader("X-Request-Id", "push-2"))
+	suite.Equal(409, res.Code, "409 POST existing chart")
+	suite.Equal("push-2", res.Header().Get("X-Request-Id"), "request id echoed")
+	response := map[string]interface{}{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &response), "no error decoding error body")
+	suite.Equal("push-2", response["requestId"], "request id in error body")
+
+	res = suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil)
+	suite.Equal(200, res.Code, "200 DELETE chart")
+
+	suite.Eventually(func() bool { return len(eventTypes()) == 4 }, 5*time.Second, 10*time.Millisecond,
+		"webhook notified of deletion")
+	suite.Equal(webhook.ChartDeletedEvent, eventTypes()[2])
+	mutex.Lock()
+	suite.NotEmpty(events[2].RequestID, "request id generated without X-Request-Id")
+	mutex.Unlock()
+}
+func (suite *MultiTenantServerTestSuite) TestEventStream() {
+	server := suite.newTestServer("events", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+
+	httpServer := httptest.NewServer(server.Router)
+	defer httpServer.Close()
+
+	res, err := http.Get(httpServer.URL + "/api/org1/events")
+	suite.Nil(err, "no error connecting to event stream")
+	defer res.Body.Close()
+	suite.Equal(200, res.StatusCode, "200 GET /api/org1/events")
+	suite.Equal("text/event-stream", res.Header.Get("Content-Type"))
+
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines <- scanner.Text()
+			}
+		}
+		close(lines)
+	}()
+	suite.Equal(": subscribed", <-lines, "subscribed before reading events")
+
+	upload := func(repo string) {
+		content, err := ioutil.ReadFile(testTarballPath)
+		suite.Nil(err, "no error opening test tarball")
+		res := suite.serve(server, "POST", "/api/"+repo+"/charts", bytes.NewReader(content))
+		suite.Equal(201, res.Code, "201 POST chart to "+repo)
+	}
+	upload("org2")
+	upload("org1")
+
+	var line string
+	select {
+	case line = <-lines:
+	case <-time.After(5 * time.Second):
+		suite.Fail("no event streamed")
+	}
+	suite.Equal("event: "+webhook.ChartUploadedEvent, line, "upload to org1 streamed, not the one to org2")
+	line = <-lines
+	suite.True(strings.HasPrefix(line, "data: "), "event data")
+	event := webhook.Event{}
+	suite.Nil(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event), "no error decoding event data")
+	suite.Equal("org1", event.Repo)
+	suite.Equal("mychart", event.Chart.Name)
+	suite.Equal("0.1.0", event.Chart.Version)
+}
+func (suite *MultiTenantServerTestSuite) TestOCI() {
+	dir := pathutil.Join(suite.TempDirectory, "oci")
+	os.MkdirAll(dir, os.ModePerm)
+	backend := &countingBackend{Backend: storage.NewLocalFilesystemBackend(dir)}
+	server := suite.newTestServer("", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		StorageBackend: backend,
+		EnableAPI:      true,
+		EnableOCI:      true,
+	})
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content)).Code, "201 POST chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/prov", bytes.NewReader(provContent)).Code, "201 POST prov file")
+
+	res := suite.serve(server, "GET", "/v2/", nil)
+	suite.Equal(200, res.Code, "200 GET /v2/")
+	suite.Equal("registry/2.0", res.Header().Get("Docker-Distribution-API-Version"))
+
+	res = suite.serve(server, "GET", "/v2/org1/mychart/tags/list", nil)
+	suite.Equal(200, res.Code, "200 GET tags")
+	suite.JSONEq(`{"name":"org1/mychart","tags":["0.1.0"]}`, res.Body.String())
+	suite.Equal(404, suite.serve(server, "GET", "/v2/org1/nochart/tags/list", nil).Code, "404 GET tags of missing chart")
+
+	res = suite.serve(server, "GET", "/v2/org1/mychart/manifests/0.1.0", nil)
+	suite.Equal(200, res.Code, "200 GET manifest by tag")
+	suite.Equal(ociManifestMediaType, res.Header().Get("Content-Type"))
+	manifestDigest := res.Header().Get("Docker-Content-Digest")
+	suite.Equal(ociDigest(res.Body.Bytes()), manifestDigest, "digest of the manifest")
+	manifest := ociManifest{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &manifest), "no error decoding manifest")
+	suite.Equal(helmConfigMediaType, manifest.Config.MediaType)
+	suite.Len(manifest.Layers, 2, "chart and provenance layers")
+	suite.Equal(ociDigest(content), manifest.Layers[0].Digest)
+	suite.Equal(int64(len(content)), manifest.Layers[0].Size)
+	suite.Equal(ociDigest(provContent), manifest.Layers[1].Digest)
+
+	suite.Equal(200, suite.serve(server, "GET", "/v2/org1/mychart/manifests/"+manifestDigest, nil).Code, "200 GET manifest by digest")
+	suite.Equal(200, suite.serve(server, "HEAD", "/v2/org1/mychart/manifests/0.1.0", nil).Code, "200 HEAD manifest")
+	suite.Equal(404, suite.serve(server, "GET", "/v2/org1/mychart/manifests/9.9.9", nil).Code, "404 GET missing manifest")
+
+	res = suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+manifest.Layers[0].Digest, nil)
+	suite.Equal(200, res.Code, "200 GET chart blob")
+	suite.Equal(content, res.Body.Bytes(), "chart blob is the chart package")
+	res = suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+manifest.Config.Digest, nil)
+	suite.Equal(200, res.Code, "200 GET config blob")
+	suite.Contains(res.Body.String(), `"name":"mychart"`)
+	suite.Equal(200, suite.serve(server, "HEAD", "/v2/org1/mychart/blobs/"+manifest.Layers[1].Digest, nil).Code, "200 HEAD prov blob")
+	suite.Equal(404, suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+ociDigest([]byte("missing")), nil).Code, "404 GET missing blob")
+
+	// blobs are found without reading every chart version
+	contentV2, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(contentV2)).Code, "201 POST chart version")
+	suite.Eventually(func() bool {
+		return suite.serve(server, "GET", "/api/org1/charts/mychart/0.2.0", nil).Code == 200
+	}, 5*time.Second, 10*time.Millisecond, "uploaded chart version in the index")
+	reads := backend.countReads(func() {
+		suite.Equal(200, suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+ociDigest(contentV2), nil).Code, "200 GET chart blob of manifest not served")
+	})
+	suite.Equal(2, reads, "chart blob found with the digests of the index")
+	reads = backend.countReads(func() {
+		suite.Equal(200, suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+manifest.Layers[1].Digest, nil).Code, "200 GET prov blob")
+	})
+	suite.Equal(2, reads, "prov blob found with the digests of the served manifest")
+	suite.Equal(404, suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+ociDigest([]byte("missing")), nil).Code, "404 GET missing blob")
+	reads = backend.countReads(func() {
+		suite.Equal(404, suite.serve(server, "GET", "/v2/org1/mychart/blobs/"+ociDigest([]byte("missing")), nil).Code, "404 GET missing blob again")
+	})
+	suite.Equal(1, reads, "no manifest generated again for missing blobs")
+
+	// push the chart to org2, the package in two chunks and the config in a single request
+	res = suite.serve(server, "POST", "/v2/org2/mychart/blobs/uploads/", nil)
+	suite.Equal(202, res.Code, "202 POST upload")
+	location := res.Header().Get("Location")
+	suite.True(strings.HasPrefix(location, "/v2/org2/mychart/blobs/uploads/"), "upload location")
+	res = suite.serve(server, "PATCH", location, bytes.NewReader(content[:100]))
+	suite.Equal(202, res.Code, "202 PATCH upload")
+	suite.Equal("0-99", res.Header().Get("Range"))
+	suite.Equal(400, suite.serve(server, "PUT", location+"?digest="+ociDigest([]byte("other")), bytes.NewReader(content[100:])).Code,
+		"400 PUT upload with wrong digest")
+	res = suite.serve(server, "PUT", location+"?digest="+ociDigest(content), bytes.NewReader(content[100:]))
+	suite.Equal(201, res.Code, "201 PUT upload")
+	suite.Equal("/v2/org2/mychart/blobs/"+ociDigest(content), res.Header().Get("Location"))
+	suite.Equal(404, suite.serve(server, "PATCH", location, bytes.NewReader(content[:100])).Code, "404 PATCH completed upload")
+
+	config := []byte(`{"name":"mychart","version":"0.1.0","apiVersion":"v2"}`)
+	res = suite.serve(server, "POST", "/v2/org2/mychart/blobs/uploads/?digest="+ociDigest(config), bytes.NewReader(config))
+	suite.Equal(201, res.Code, "201 POST monolithic upload")
+	suite.Equal(200, suite.serve(server, "HEAD", "/v2/org2/mychart/blobs/"+ociDigest(config), nil).Code, "200 HEAD uploaded blob")
+
+	pushed, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		Config:        ociDescriptor{MediaType: helmConfigMediaType, Digest: ociDigest(config), Size: int64(len(config))},
+		Layers:        []ociDescriptor{{MediaType: helmChartContentMediaType, Digest: ociDigest(content), Size: int64(len(content))}},
+		Annotations:   map[string]string{"org.opencontainers.image.title": "mychart"},
+	})
+	suite.Nil(err, "no error encoding manifest")
+	suite.Equal(400, suite.serve(server, "PUT", "/v2/org2/mychart/manifests/0.2.0", bytes.NewReader(pushed)).Code, "400 PUT manifest with wrong tag")
+	suite.Equal(400, suite.serve(server, "PUT", "/v2/org2/otherchart/manifests/0.1.0", bytes.NewReader(pushed)).Code, "400 PUT manifest with wrong name")
+	res = suite.serve(server, "PUT", "/v2/org2/mychart/manifests/0.1.0", bytes.NewReader(pushed))
+	suite.Equal(201, res.Code, "201 PUT manifest")
+	suite.Equal(ociDigest(pushed), res.Header().Get("Docker-Content-Digest"))
+
+	_, err = server.StorageBackend.GetObject("org2/mychart-0.1.0.tgz")
+	suite.Nil(err, "pushed chart stored as a chart package")
+	suite.Eventually(func() bool {
+		return suite.serve(server, "GET", "/api/org2/charts/mychart/0.1.0", nil).Code == 200
+	}, 5*time.Second, 10*time.Millisecond, "pushed chart in the index")
+
+	res = suite.serve(server, "GET", "/v2/org2/mychart/manifests/0.1.0", nil)
+	suite.Equal(200, res.Code, "200 GET pushed manifest")
+	suite.Equal(pushed, res.Body.Bytes(), "pushed manifest served as pushed")
+	suite.Equal(200, suite.serve(server, "GET", "/v2/org2/mychart/blobs/"+ociDigest(config), nil).Code, "200 GET pushed config")
+	suite.Equal(201, suite.serve(server, "PUT", "/v2/org2/mychart/manifests/0.1.0", bytes.NewReader(pushed)).Code, "201 PUT same manifest again")
+}
+
+func (suite *MultiTenantServerTestSuite) TestProxy() {
+	logger := suite.Logger
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	contentV2, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	contentV0, err := ioutil.ReadFile(testTarballPathV0)
+	suite.Nil(err, "no error opening test tarball")
+	otherContent, err := ioutil.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+	otherProvContent, err := ioutil.ReadFile(otherTestProvfilePath)
+	suite.Nil(err, "no error opening other test provenance file")
+
+	var mutex sync.Mutex
+	downloads := map[string]int{}
+	files := map[string][]byte{
+		"/charts/mychart-0.1.0.tgz":        content,
+		"/charts/mychart-0.2.0.tgz":        contentV2,
+		"/charts/mychart-0.0.1.tgz":        contentV0,
+		"/other/otherchart-0.1.0.tgz":      otherContent,
+		"/other/otherchart-0.1.0.tgz.prov": otherProvContent,
+	}
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "pass" {
+			w.WriteHeader(401)
+			return
+		}
+		mutex.Lock()
+		downloads[r.URL.Path]++
+		mutex.Unlock()
+		if r.URL.Path == "/index.yaml" {
+			index := repo.NewIndex("", "", nil)
+			for path, fileContent := range files {
+				if strings.HasSuffix(path, ".prov") {
+					continue
+				}
+				chartVersion, err := repo.ChartVersionFromStorageObject(storage.Object{Path: path, Content: fileContent})
+				suite.Nil(err, "no error reading chart version")
+				chartVersion.Description = "upstream"
+				chartVersion.URLs = []string{strings.TrimPrefix(path, "/")}
+				if strings.HasPrefix(path, "/other/") {
+					chartVersion.URLs = []string{"http://" + r.Host + path}
+				}
+				if path == "/charts/mychart-0.0.1.tgz" {
+					chartVersion.Digest = strings.Repeat("0", 64) // does not match the chart
+				}
+				index.AddEntry(chartVersion)
+			}
+			index.Regenerate()
+			w.Write(index.Raw)
+			return
+		}
+		fileContent, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(fileContent)
+	}))
+	defer upstreamServer.Close()
+	upstreamDownloads := func(path string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return downloads[path]
+	}
+
+	disabled := ""
+	dir := pathutil.Join(suite.TempDirectory, "proxy")
+	os.MkdirAll(dir, os.ModePerm)
+	server := suite.newTestServer("proxy", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:     true,
+		ProxyUpstream: strings.Replace(upstreamServer.URL, "http://", "http://user:pass@", 1),
+		TenantConfig: &tenant.Config{Tenants: map[string]*tenant.Overrides{
+			"org2": {Upstream: &disabled},
+		}},
+	})
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		ProxyUpstream:  "oci://localhost",
+	})
+	suite.NotNil(err, "error creating server with invalid upstream")
+
+	getIndex := func(repoName string) *helm_repo.IndexFile {
+		res := suite.serve(server, "GET", "/"+repoName+"/index.yaml", nil)
+		suite.Equal(200, res.Code, "200 GET index.yaml")
+		index := &helm_repo.IndexFile{}
+		suite.Nil(yaml.Unmarshal(res.Body.Bytes(), index), "no error parsing index")
+		return index
+	}
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content)).Code, "201 POST chart")
+	suite.Eventually(func() bool {
+		index, _ := server.getIndexFile(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), "org1")
+		return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: "0.1.0"}})
+	}, 5*time.Second, 10*time.Millisecond, "uploaded chart in index")
+
+	index := getIndex("org1")
+	suite.Len(index.Entries["mychart"], 3, "local and upstream versions of mychart")
+	suite.Len(index.Entries["otherchart"], 1, "upstream otherchart")
+	for _, chartVersion := range index.Entries["mychart"] {
+		if chartVersion.Version == "0.1.0" {
+			suite.NotEqual("upstream", chartVersion.Description, "local chart wins over upstream one")
+		} else {
+			suite.Equal("upstream", chartVersion.Description)
+		}
+	}
+	suite.Equal([]string{"charts/otherchart-0.1.0.tgz"}, index.Entries["otherchart"][0].URLs,
+		"upstream charts served by the proxy")
+	suite.Empty(getIndex("org2").Entries, "no upstream charts for tenant proxying none")
+
+	res := suite.serve(server, "GET", "/org1/charts/mychart-0.2.0.tgz", nil)
+	suite.Equal(200, res.Code, "200 GET upstream chart")
+	suite.Equal(contentV2, res.Body.Bytes())
+	_, err = os.Stat(pathutil.Join(dir, "org1", "mychart-0.2.0.tgz"))
+	suite.Nil(err, "upstream chart cached in storage")
+	suite.Equal(200, suite.serve(server, "GET", "/org1/charts/mychart-0.2.0.tgz", nil).Code, "200 GET cached chart")
+	suite.Equal(1, upstreamDownloads("/charts/mychart-0.2.0.tgz"), "cached chart served from storage")
+	suite.Eventually(func() bool {
+		index, _ := server.getIndexFile(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), "org1")
+		return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: "0.2.0"}})
+	}, 5*time.Second, 10*time.Millisecond, "cached chart added to index")
+
+	res = suite.serve(server, "GET", "/org1/charts/otherchart-0.1.0.tgz.prov", nil)
+	suite.Equal(200, res.Code, "200 GET upstream provenance file")
+	suite.Equal(otherProvContent, res.Body.Bytes())
+	suite.Equal(200, suite.serve(server, "GET", "/org1/charts/otherchart-0.1.0.tgz", nil).Code, "200 GET chart with absolute url")
+
+	suite.Equal(502, suite.serve(server, "GET", "/org1/charts/mychart-0.0.1.tgz", nil).Code, "502 GET chart not matching its digest")
+	_, err = os.Stat(pathutil.Join(dir, "org1", "mychart-0.0.1.tgz"))
+	suite.True(os.IsNotExist(err), "chart not matching its digest not cached")
+	suite.Equal(404, suite.serve(server, "GET", "/org1/charts/nochart-0.1.0.tgz", nil).Code, "404 GET chart not upstream")
+	suite.Equal(404, suite.serve(server, "GET", "/org2/charts/mychart-0.2.0.tgz", nil).Code, "404 GET chart of tenant proxying none")
+	suite.Equal(1, upstreamDownloads("/index.yaml"), "upstream index cached")
+}
+
+func (suite *MultiTenantServerTestSuite) TestVirtualRepo() {
+	server := suite.newTestServer("virtual", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:       true,
+		EnableTenantAPI: true,
+		TenantConfig: &tenant.Config{Tenants: map[string]*tenant.Overrides{
+			"all": {Members: []string{"org1", "org2"}},
+		}},
+	})
+
+	getIndex := func(repoName string) *helm_repo.IndexFile {
+		res := suite.serve(server, "GET", "/"+repoName+"/index.yaml", nil)
+		suite.Equal(200, res.Code, "200 GET index.yaml")
+		index := &helm_repo.IndexFile{}
+		suite.Nil(yaml.Unmarshal(res.Body.Bytes(), index), "no error parsing index")
+		return index
+	}
+	uploaded := func(repoName string, name string, version string) func() bool {
+		return func() bool {
+			index, _ := server.getIndexFile(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), repoName)
+			return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}})
+		}
+	}
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	contentV2, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	otherContent, err := ioutil.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/org2/charts", bytes.NewReader(content)).Code, "201 POST chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org2/charts", bytes.NewReader(contentV2)).Code, "201 POST chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content)).Code, "201 POST chart")
+	suite.Eventually(uploaded("org1", "mychart", "0.1.0"), 5*time.Second, 10*time.Millisecond, "chart in index")
+	suite.Eventually(uploaded("org2", "mychart", "0.2.0"), 5*time.Second, 10*time.Millisecond, "chart in index")
+
+	index := getIndex("all")
+	suite.Len(index.Entries["mychart"], 2, "versions of mychart merged from both members")
+	org1Index := getIndex("org1")
+	org2Index := getIndex("org2")
+	for _, chartVersion := range index.Entries["mychart"] {
+		if chartVersion.Version == "0.1.0" {
+			suite.Equal(org1Index.Entries["mychart"][0].Created, chartVersion.Created, "first member takes precedence")
+		}
+	}
+	suite.NotEqual(org1Index.Entries["mychart"][0].Created, org2Index.Entries["mychart"][1].Created)
+
+	served, _ := server.getIndexFileForRequest(&gin.Context{}, server.Logger.ContextLoggingFn(&gin.Context{}), "all")
+	again, _ := server.getIndexFileForRequest(&gin.Context{}, server.Logger.ContextLoggingFn(&gin.Context{}), "all")
+	suite.True(served == again, "merged index kept until a member changes")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(otherContent)).Code, "201 POST chart")
+	suite.Eventually(func() bool {
+		return len(getIndex("all").Entries["otherchart"]) == 1
+	}, 5*time.Second, 10*time.Millisecond, "virtual index updated with members")
+
+	res := suite.serve(server, "GET", "/api/all/charts", nil)
+	suite.Equal(200, res.Code, "200 GET /api/all/charts")
+	suite.Contains(res.Body.String(), "otherchart", "api lists charts of members")
+
+	res = suite.serve(server, "GET", "/all/charts/mychart-0.2.0.tgz", nil)
+	suite.Equal(200, res.Code, "200 GET chart of second member")
+	suite.Equal(contentV2, res.Body.Bytes())
+	suite.Equal(404, suite.serve(server, "GET", "/all/charts/nochart-0.1.0.tgz", nil).Code, "404 GET chart of no member")
+
+	suite.Equal(405, suite.serve(server, "POST", "/api/all/charts", bytes.NewReader(content)).Code, "405 POST chart to virtual repo")
+	suite.Equal(405, suite.serve(server, "DELETE", "/api/all/charts/mychart/0.1.0", nil).Code, "405 DELETE chart of virtual repo")
+	suite.Equal(405, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/promote?target=all", nil).Code,
+		"405 promote chart to virtual repo")
+
+	for _, body := range []string{`{"name": "loop", "members": ["loop"]}`, `{"name": "nested", "members": ["all"]}`, `{"name": "deep", "members": ["org1/team1"]}`} {
+		suite.Equal(400, suite.serve(server, "POST", "/api/tenants", bytes.NewReader([]byte(body))).Code, "400 POST /api/tenants "+body)
+	}
+	suite.Equal(201, suite.serve(server, "POST", "/api/tenants", strings.NewReader(`{"name": "reversed", "members": ["/org2/", "org1"]}`)).Code,
+		"201 POST /api/tenants virtual repo")
+	for _, chartVersion := range getIndex("reversed").Entries["mychart"] {
+		if chartVersion.Version == "0.1.0" {
+			suite.Equal(org2Index.Entries["mychart"][1].Created, chartVersion.Created, "members in order of precedence")
+		}
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) TestReplication() {
+	logger := suite.Logger
+	log := logger.ContextLoggingFn(&gin.Context{})
+	locker := &testLocker{held: map[string]bool{}}
+
+	newServer := func(name string, depth int) *MultiTenantServer {
+		dir := pathutil.Join(suite.TempDirectory, name)
+		os.MkdirAll(dir, os.ModePerm)
+		server := suite.newTestServer("", cm_router.RouterOptions{Depth: depth}, MultiTenantServerOptions{
+			StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+			EnableAPI:      true,
+			Locker:         locker,
+		})
+		return server
+	}
+	hasEntry := func(server *MultiTenantServer, repoName string, name string, version string) func() bool {
+		return func() bool {
+			index, _ := server.getIndexFile(context.Background(), log, repoName)
+			return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}})
+		}
+	}
+
+	source := newServer("replication-source", 0)
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", suite.readFile(testTarballPath)).Code, "201 POST chart to source")
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", suite.readFile(testTarballPathV2)).Code, "201 POST chart to source")
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", suite.readFile(otherTestTarballPath)).Code, "201 POST chart to source")
+	suite.Equal(201, suite.serve(source, "POST", "/api/prov", suite.readFile(otherTestProvfilePath)).Code, "201 POST prov file to source")
+	suite.Eventually(hasEntry(source, "", "otherchart", "0.1.0"), 5*time.Second, 10*time.Millisecond, "chart in source index")
+	sourceServer := httptest.NewServer(source.Router)
+	defer sourceServer.Close()
+
+	mirror := newServer("replication-mirror", 1)
+	mirrorDir := pathutil.Join(suite.TempDirectory, "replication-mirror")
+	suite.Equal(201, suite.serve(mirror, "POST", "/api/mirror/charts", suite.readFile(otherTestTarballPath)).Code, "201 POST chart to mirror")
+
+	config, err := replication.ConfigFromContent([]byte(fmt.Sprintf(`
+sources:
+  - url: %[1]s
+    repo: mirror
+    include: [mychart]
+    delete: true
+  - url: %[1]s
+    repo: other
+    exclude: [mychart]
+`, sourceServer.URL)))
+	suite.Nil(err, "no error parsing replication config")
+	mirror.Replicas, err = newReplicas(config)
+	suite.Nil(err, "no error creating replicas")
+
+	before := len(locker.names())
+	added, deleted, err := mirror.replicate(log, mirror.Replicas[0])
+	suite.Nil(err, "no error replicating")
+	suite.Equal(2, added, "included charts replicated")
+	suite.Equal(0, deleted, "local chart not matching filters kept")
+	suite.Contains(locker.names()[before:], "repo/mirror", "charts replicated under the lock of the repo")
+	suite.Eventually(hasEntry(mirror, "mirror", "mychart", "0.2.0"), 5*time.Second, 10*time.Millisecond,
+		"replicated chart in index")
+	suite.Eventually(hasEntry(mirror, "mirror", "mychart", "0.1.0"), 5*time.Second, 10*time.Millisecond,
+		"replicated chart in index")
+
+	added, deleted, err = mirror.replicate(log, mirror.Replicas[0])
+	suite.Nil(err, "no error replicating again")
+	suite.Equal(0, added+deleted, "nothing to do once replicated")
+
+	added, _, err = mirror.replicate(log, mirror.Replicas[1])
+	suite.Nil(err, "no error replicating")
+	suite.Equal(1, added, "charts not excluded replicated")
+	_, err = os.Stat(pathutil.Join(mirrorDir, "other", "otherchart-0.1.0.tgz.prov"))
+	suite.Nil(err, "provenance file replicated")
+
+	suite.Equal(200, suite.serve(source, "DELETE", "/api/charts/mychart/0.2.0", nil).Code, "200 DELETE chart from source")
+	suite.Eventually(func() bool { return !hasEntry(source, "", "mychart", "0.2.0")() }, 5*time.Second, 10*time.Millisecond,
+		"chart removed from source index")
+	added, deleted, err = mirror.replicate(log, mirror.Replicas[0])
+	suite.Nil(err, "no error replicating")
+	suite.Equal(0, added)
+	suite.Equal(1, deleted, "chart deleted from source deleted from mirror")
+	_, err = os.Stat(pathutil.Join(mirrorDir, "mirror", "mychart-0.2.0.tgz"))
+	suite.True(os.IsNotExist(err), "chart deleted from storage")
+	_, err = os.Stat(pathutil.Join(mirrorDir, "mirror", "otherchart-0.1.0.tgz"))
+	suite.Nil(err, "local chart not matching filters never deleted")
+	suite.Eventually(func() bool { return !hasEntry(mirror, "mirror", "mychart", "0.2.0")() }, 5*time.Second, 10*time.Millisecond,
+		"deleted chart removed from index")
+
+	locker.mutex.Lock()
+	locker.err = context.DeadlineExceeded
+	locker.mutex.Unlock()
+	_, _, err = mirror.replicate(log, mirror.Replicas[1])
+	suite.NotNil(err, "error replicating without the lock of the repo")
+	locker.mutex.Lock()
+	locker.err = nil
+	locker.mutex.Unlock()
+
+	sourceServer.Close()
+	_, deleted, err = mirror.replicate(log, mirror.Replicas[0])
+	suite.NotNil(err, "error replicating once the source is down")
+	suite.Equal(0, deleted, "nothing deleted when the source is down")
+	_, err = os.Stat(pathutil.Join(mirrorDir, "mirror", "mychart-0.1.0.tgz"))
+	suite.Nil(err, "charts kept when the source is down")
+}
+
+func (suite *MultiTenantServerTestSuite) TestHelmPushCompat() {
+	server := suite.newTestServer("helmpush", cm_router.RouterOptions{Depth: 0}, MultiTenantServerOptions{
+		EnableAPI:           true,
+		AllowForceOverwrite: true,
+	})
+
+	// helm cm-push uploads multipart forms, with --version and --app-version as query params
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?version=0.9.0&appVersion=2.0.0", buf, withHeader("Content-Type", w.FormDataContentType())).Code, "201 POST /api/charts?version=0.9.0")
+	suite.Eventually(func() bool {
+		return suite.serve(server, "GET", "/api/charts/mychart/0.9.0", nil).Code == 200
+	}, time.Second, 10*time.Millisecond, "overridden version in index")
+
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(409, suite.serve(server, "POST", "/api/charts?version=0.9.0&force=false", buf, withHeader("Content-Type", w.FormDataContentType())).Code, "409 POST /api/charts?force=false")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?version=0.9.0&force=true", buf, withHeader("Content-Type", w.FormDataContentType())).Code, "201 POST /api/charts?force=true")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?version=0.9.0&force", buf, withHeader("Content-Type", w.FormDataContentType())).Code, "201 POST /api/charts?force")
+
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	suite.Equal(400, suite.serve(server, "POST", "/api/charts?version=0.9.1", buf, withHeader("Content-Type", w.FormDataContentType())).Code, "400 POST /api/charts?version with provenance file")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(400, suite.serve(server, "POST", "/api/charts?version=notsemver", buf, withHeader("Content-Type", w.FormDataContentType())).Code, "400 POST /api/charts?version=notsemver")
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?version=0.9.2", bytes.NewBuffer(content)).Code, "201 POST /api/charts?version=0.9.2")
+	suite.Eventually(func() bool {
+		return suite.serve(server, "GET", "/api/charts/mychart/0.9.2", nil).Code == 200
+	}, time.Second, 10*time.Millisecond, "overridden version of package in index")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/0.1.0", nil).Code, "original version not uploaded")
+}
+
+func (suite *MultiTenantServerTestSuite) TestTrash() {
+	dir := pathutil.Join(suite.TempDirectory, "trash")
+	os.MkdirAll(dir, os.ModePerm)
+	backend := storage.Backend(storage.NewLocalFilesystemBackend(dir))
+	server := suite.newTestServer("", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		StorageBackend: backend,
+		EnableAPI:      true,
+		TrashRetention: time.Hour,
+	})
+	inIndex := func() bool {
+		return suite.serve(server, "GET", "/api/org1/charts/mychart/0.1.0", nil).Code == 200
+	}
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", suite.readFile(testTarballPath)).Code, "201 POST /api/org1/charts")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/prov", suite.readFile(testProvfilePath)).Code, "201 POST /api/org1/prov")
+	suite.Eventually(inIndex, time.Second, 10*time.Millisecond, "chart in index")
+	suite.Equal(404, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/restore", nil).Code, "404 restoring chart not in trash")
+
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil).Code, "200 DELETE /api/org1/charts/mychart/0.1.0")
+	suite.Eventually(func() bool { return !inIndex() }, time.Second, 10*time.Millisecond, "chart removed from index")
+	_, err := backend.GetObject("org1/mychart-0.1.0.tgz")
+	suite.NotNil(err, "chart moved out of the repo")
+	_, err = backend.GetObject("org1/.trash/mychart-0.1.0.tgz.prov")
+	suite.Nil(err, "provenance file kept in trash")
+
+	suite.Equal(200, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/restore", nil).Code, "200 restoring chart")
+	suite.Eventually(inIndex, time.Second, 10*time.Millisecond, "restored chart in index")
+	suite.Equal(200, suite.serve(server, "GET", "/org1/charts/mychart-0.1.0.tgz.prov", nil).Code, "provenance file restored")
+	suite.Equal(404, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/restore", nil).Code, "404 restoring chart twice")
+
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil).Code, "200 DELETE /api/org1/charts/mychart/0.1.0")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", suite.readFile(testTarballPath)).Code, "201 POST /api/org1/charts")
+	suite.Equal(409, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/restore", nil).Code, "409 restoring chart uploaded again")
+
+	server.Router.DepthDynamic = true
+	suite.Equal(404, suite.serve(server, "GET", "/org1/.trash/index.yaml", nil).Code, "trash not served as a repo")
+	server.Router.DepthDynamic = false
+
+	server.TrashRetention = time.Millisecond
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil).Code, "200 DELETE /api/org1/charts/mychart/0.1.0")
+	time.Sleep(5 * time.Millisecond)
+	suite.Equal(404, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/restore", nil).Code, "404 restoring chart after the trash retention")
+	_, err = backend.GetObject("org1/.trash/mychart-0.1.0.tgz")
+	suite.NotNil(err, "chart purged from trash")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMaintenance() {
+	server := suite.newTestServer("maintenance", cm_router.RouterOptions{Depth: 0}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+
+	res, response := suite.serveJSON(server, "GET", "/api/admin/maintenance", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/maintenance")
+	suite.Equal(false, response["enabled"], "maintenance mode off by default")
+
+	res, _ = suite.serveJSON(server, "PUT", "/api/admin/maintenance", strings.NewReader(`{"message": "backup running"}`))
+	suite.Equal(400, res.Code, "400 PUT /api/admin/maintenance without enabled")
+	res, response = suite.serveJSON(server, "PUT", "/api/admin/maintenance", strings.NewReader(`{"enabled": true, "message": "backup running"}`))
+	suite.Equal(200, res.Code, "200 PUT /api/admin/maintenance")
+	suite.Equal(true, response["enabled"], "maintenance mode on")
+
+	res, response = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(503, res.Code, "503 POST /api/charts in maintenance mode")
+	suite.Equal("backup running", response["detail"], "maintenance message returned")
+	suite.Equal(cm_router.ErrorCodeMaintenance, response["code"], "maintenance error code")
+	res, _ = suite.serveJSON(server, "DELETE", "/api/charts/mychart/0.1.0", nil)
+	suite.Equal(503, res.Code, "503 DELETE /api/charts/mychart/0.1.0 in maintenance mode")
+	res, _ = suite.serveJSON(server, "GET", "/index.yaml", nil)
+	suite.Equal(200, res.Code, "200 GET /index.yaml in maintenance mode")
+	res, _ = suite.serveJSON(server, "GET", "/api/charts", nil)
+	suite.Equal(200, res.Code, "200 GET /api/charts in maintenance mode")
+
+	suite.serve(server, "PUT", "/api/admin/maintenance", strings.NewReader(`{"enabled": true}`))
+	_, response = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(defaultMaintenanceMessage, response["detail"], "default maintenance message")
+
+	res, response = suite.serveJSON(server, "PUT", "/api/admin/maintenance", strings.NewReader(`{"enabled": false}`))
+	suite.Equal(200, res.Code, "200 PUT /api/admin/maintenance")
+	suite.Equal(false, response["enabled"], "maintenance mode off")
+	res, _ = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(201, res.Code, "201 POST /api/charts after maintenance")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDrainWrites() {
+	server := suite.newTestServer("drainwrites", cm_router.RouterOptions{Depth: 0}, MultiTenantServerOptions{
+		EnableAPI:     true,
+		UseStatefiles: true,
+	})
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/api/charts", bytes.NewBuffer(content))
+	server.Router.HandleContext(c)
+	suite.Equal(201, c.Writer.Status(), "201 POST /api/charts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.drainWrites(ctx)
+	suite.Equal(int64(0), *server.PendingWrites, "no writes pending after draining")
+	index, indexErr := server.getIndexFile(context.Background(), server.Logger.ContextLoggingFn(c), "")
+	suite.Nil(indexErr, "no error getting index")
+	suite.Len(index.Entries["mychart"], 1, "uploaded chart in index after draining")
+	_, statefileErr := server.StorageBackend.GetObject(repo.StatefileFilename)
+	suite.Nil(statefileErr, "statefile saved after draining")
+}
+
+func (suite *MultiTenantServerTestSuite) TestReload() {
+	server := suite.newTestServer("reload", cm_router.RouterOptions{Depth: 0, Username: "user", Password: "pass"}, MultiTenantServerOptions{
+		EnableAPI:      true,
+		TrashRetention: time.Hour,
+	})
+
+	suite.Equal(401, suite.serve(server, "GET", "/index.yaml", nil).Code, "401 GET /index.yaml without credentials")
+	suite.Equal(200, suite.serve(server, "GET", "/index.yaml", nil, withBasicAuth("user", "pass")).Code, "200 GET /index.yaml")
+
+	err := server.Reload(ReloadOptions{Username: "user", Password: "pass"})
+	suite.NotNil(err, "error turning trash off on reload")
+	err = server.Reload(ReloadOptions{Username: "user", Password: "pass", PerChartLimit: 2, TrashRetention: time.Hour})
+	suite.NotNil(err, "error turning per-chart limit on on reload")
+
+	err = server.Reload(ReloadOptions{
+		Username:           "newuser",
+		Password:           "newpass",
+		TrashRetention:     2 * time.Hour,
+		MaintenanceMessage: "upgrading",
+	})
+	suite.Nil(err, "no error reloading")
+	suite.Equal(401, suite.serve(server, "GET", "/index.yaml", nil, withBasicAuth("user", "pass")).Code, "401 GET /index.yaml with old credentials")
+	suite.Equal(200, suite.serve(server, "GET", "/index.yaml", nil, withBasicAuth("newuser", "newpass")).Code, "200 GET /index.yaml with new credentials")
+	suite.Equal(2*time.Hour, server.trashRetention(), "trash retention reloaded")
+	server.setMaintenance(true, "")
+	_, message := server.inMaintenance()
+	suite.Equal("upgrading", message, "maintenance message reloaded")
+
+	err = server.Reload(ReloadOptions{AnonymousGet: true, TrashRetention: time.Hour})
+	suite.Nil(err, "no error reloading")
+	suite.Equal(200, suite.serve(server, "GET", "/index.yaml", nil).Code, "200 GET /index.yaml without auth")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDisabledServer() {
+	// Test that all /api routes disabled if EnableAPI=false
+	res := suite.doRequest("disabled", "GET", "/api/charts", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /api/charts")
+
+	res = suite.doRequest("disabled", "GET", "/api/charts/mychart", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /api/charts")
+
+	res = suite.doRequest("disabled", "GET", "/api/charts/mychart/0.1.0", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /api/charts")
+
+	body := bytes.NewBuffer([]byte{})
+	res = suite.doRequest("disabled", "POST", "/api/charts", body, "")
+	suite.Equal(404, res.Status(), "404 POST /api/charts")
+
+	body = bytes.NewBuffer([]byte{})
+	res = suite.doRequest("disabled", "POST", "/api/prov", body, "")
+	suite.Equal(404, res.Status(), "404 POST /api/prov")
+
+	res = suite.doRequest("disabled", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
+	suite.Equal(404, res.Status(), "404 DELETE /api/charts/mychart/0.1.0")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDisabledDeleteServer() {
+	res := suite.doRequest("disableddelete", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
+	suite.Equal(404, res.Status(), "404 DELETE /api/charts/mychart/0.1.0")
+}
+
+func (suite *MultiTenantServerTestSuite) extractRepoEntryFromInternalCache(repo string) *cacheEntry {
+	suite.OverwriteServer.TenantCacheKeyLock.RLock()
+	local, ok := suite.OverwriteServer.InternalCacheStore[repo]
+	suite.OverwriteServer.TenantCacheKeyLock.RUnlock()
+	if ok {
+		return local
+	}
+	return nil
+}
+
+func (suite *MultiTenantServerTestSuite) TestOverwriteServer() {
+	// Check if files can be overwritten
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	body := bytes.NewBuffer(content)
+	res := suite.doRequest("overwrite", "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("overwrite", "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+
+	{
+		// waiting for the emit event
+		// the event is transferred via a channel , here do a simple wait for not changing the original structure
+		// only for testing purpose
+		time.Sleep(time.Second)
+		// depth: 0
+		e := suite.extractRepoEntryFromInternalCache("")
+		suite.Equal(1, len(suite.OverwriteServer.getRepoIndex(e).Entries), "overwrite entries validation")
+	}
+
+	content, err = ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("overwrite", "POST", "/api/prov", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/prov")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("overwrite", "POST", "/api/prov", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/prov")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("overwrite", "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("overwrite", "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	{
+		// the same as chart only case above
+		time.Sleep(time.Second)
+		// depth: 0
+		e := suite.extractRepoEntryFromInternalCache("")
+		suite.Equal(1, len(suite.OverwriteServer.getRepoIndex(e).Entries), "overwrite entries validation")
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) TestBadChartUpload() {
+	content, err := ioutil.ReadFile(badTestTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+
+	body := bytes.NewBuffer(content)
+	res := suite.doRequest("depth0", "POST", "/api/charts", body, "")
+	suite.Equal(400, res.Status(), "400 POST /api/charts")
+
+	content, err = ioutil.ReadFile(badTestProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("depth0", "POST", "/api/prov", body, "")
+	suite.Equal(400, res.Status(), "400 POST /api/prov")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{badTestTarballPath, badTestProvfilePath})
+	res = suite.doRequest("depth0", "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(400, res.Status(), "400 POST /api/charts")
+}
+
+func (suite *MultiTenantServerTestSuite) TestForceOverwriteServer() {
+	// Clear test repo to allow uploading again
+	res := suite.doRequest("forceoverwrite", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 DELETE /api/charts/mychart/0.1.0")
+
+	// Check if files can be overwritten when ?force is on URL
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	body := bytes.NewBuffer(content)
+	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", body, "")
+	suite.Equal(409, res.Status(), "409 POST /api/charts")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("forceoverwrite", "POST", "/api/charts?force", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts?force")
+
+	content, err = ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("forceoverwrite", "POST", "/api/prov", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/prov")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("forceoverwrite", "POST", "/api/prov", body, "")
+	suite.Equal(409, res.Status(), "409 POST /api/prov")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("forceoverwrite", "POST", "/api/prov?force", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/prov?force")
+
+	// Clear test repo to allow uploading again
+	res = suite.doRequest("forceoverwrite", "DELETE", "/api/charts/mychart/0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 DELETE /api/charts/mychart/0.1.0")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("forceoverwrite", "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(409, res.Status(), "409 POST /api/charts")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("forceoverwrite", "POST", "/api/charts?force", buf, w.FormDataContentType())
+	suite.Equal(201, res.Status(), "201 POST /api/charts?force")
+}
+
+func (suite *MultiTenantServerTestSuite) TestCustomChartURLServer() {
+	res := suite.doRequest("charturl", "GET", "/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /index.yaml")
+}
+
+func (suite *MultiTenantServerTestSuite) TestRelativeChartURLs() {
+	buffer := bytes.NewBufferString("")
+	res := suite.doRequest("depth1", "GET", "/org1/index.yaml", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /org1/index.yaml")
+	suite.Contains(buffer.String(), "- charts/mychart-0.1.0.tgz", "relative chart url without --chart-url")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMaxObjectsServer() {
+	// Overwrites should still be allowed if limit is reached
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	body := bytes.NewBuffer(content)
+	res := suite.doRequest("maxobjects", "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+
+	content, err = ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("maxobjects", "POST", "/api/prov", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/prov")
+
+	// trigger error, reached max
+	content, err = ioutil.ReadFile(otherTestTarballPath)
+	suite.Nil(err, "no error opening other test tarball")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("maxobjects", "POST", "/api/charts", body, "")
+	suite.Equal(507, res.Status(), "507 POST /api/charts")
+
+	content, err = ioutil.ReadFile(otherTestProvfilePath)
+	suite.Nil(err, "no error opening other test provenance file")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("maxobjects", "POST", "/api/prov", body, "")
+	suite.Equal(507, res.Status(), "507 POST /api/prov")
+}
+
+func (suite *MultiTenantServerTestSuite) TestPerChartLimit() {
+	ns := "per-chart-limit"
+	content, err := ioutil.ReadFile(testTarballPathV0)
+	suite.Nil(err, "no error opening test tarball")
+	body := bytes.NewBuffer(content)
+	res := suite.doRequest(ns, "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+
+	content, err = ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest(ns, "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+
+	content, err = ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest(ns, "POST", "/api/charts", body, "")
+	suite.Equal(201, res.Status(), "201 POST /api/charts")
+
+	time.Sleep(time.Second)
+
+	res = suite.doRequest(ns, "GET", "/api/charts/mychart/0.2.0", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart-0.2.0")
+
+	res = suite.doRequest(ns, "GET", "/api/charts/mychart/0.1.0", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /api/charts/mychart-0.1.0")
+
+	res = suite.doRequest(ns, "GET", "/api/charts/mychart/0.0.1", nil, "")
+	suite.Equal(404, res.Status(), "200 GET /api/charts/mychart-0.0.1")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMaxUploadSizeServer() {
+	// trigger 413s, "request too large"
+	content, err := ioutil.ReadFile(testTarballPath)
 	suite.Nil(err, "no error opening test tarball")
 	body := bytes.NewBuffer(content)
 	res := suite.doRequest("maxuploadsize", "POST", "/api/charts", body, "")
 	suite.Equal(413, res.Status(), "413 POST /api/charts")
 
-	content, err = ioutil.ReadFile(testProvfilePath)
+	content, err = ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+	body = bytes.NewBuffer(content)
+	res = suite.doRequest("maxuploadsize", "POST", "/api/prov", body, "")
+	suite.Equal(413, res.Status(), "201 POST /api/prov")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.doRequest("maxuploadsize", "POST", "/api/charts", buf, w.FormDataContentType())
+	suite.Equal(413, res.Status(), "413 POST /api/charts")
+}
+
+func (suite *MultiTenantServerTestSuite) TestMetrics() {
+
+	apiPrefix := pathutil.Join("/api", "a")
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "error opening test tarball")
+
+	body := bytes.NewBuffer(content)
+	res := suite.doRequest("depth1", "POST", fmt.Sprintf("%s/charts", apiPrefix), body, "")
+	suite.Equal(201, res.Status(), fmt.Sprintf("201 post %s/charts", apiPrefix))
+
+	otherChart, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "error opening test tarball")
+
+	body = bytes.NewBuffer(otherChart)
+	res = suite.doRequest("depth1", "POST", fmt.Sprintf("%s/charts", apiPrefix), body, "")
+	suite.Equal(201, res.Status(), fmt.Sprintf("201 POST %s/charts", apiPrefix))
+
+	// GET /a/index.yaml to regenerate index (and metrics)
+	res = suite.doRequest("depth1", "GET", "/a/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /a/index.yaml")
+
+	// GET /b/index.yaml to regenerate b index (and metrics)
+	res = suite.doRequest("depth1", "GET", "/b/index.yaml", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /b/index.yaml")
+
+	// Get metrics
+	buffer := bytes.NewBufferString("")
+	res = suite.doRequest("depth1", "GET", "/metrics", nil, "", buffer)
+	suite.Equal(200, res.Status(), "200 GET /metrics")
+
+	metrics := buffer.String()
+	//fmt.Print(metrics) // observe the metric output
+
+	// Ensure that we have the Gauges as documented
+	suite.True(strings.Contains(metrics, "# TYPE chartmuseum_chart_versions_served_total gauge"))
+	suite.True(strings.Contains(metrics, "# TYPE chartmuseum_charts_served_total gauge"))
+
+	suite.True(strings.Contains(metrics, "chartmuseum_charts_served_total{repo=\"a\"} 1"))
+	suite.True(strings.Contains(metrics, "chartmuseum_chart_versions_served_total{repo=\"a\"} 2"))
+
+	// Ensure that the b repo has no charts
+	suite.True(strings.Contains(metrics, "chartmuseum_chart_versions_served_total{repo=\"b\"} 0"))
+
+	// Ensure that requests, index size and regeneration time are labelled by tenant
+	suite.True(strings.Contains(metrics, "chartmuseum_tenant_requests_total{code=\"201\",method=\"POST\",repo=\"a\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_tenant_requests_total{code=\"200\",method=\"GET\",repo=\"b\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_index_size_bytes{repo=\"a\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_index_regeneration_duration_seconds_count{repo=\"a\"}"))
+
+	// Ensure that storage operations, cache lookups and uploads are observed
+	suite.True(strings.Contains(metrics, "chartmuseum_storage_operation_duration_seconds_count{operation=\"PutObject\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_storage_operation_duration_seconds_count{operation=\"ListObjects\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_storage_operation_errors_total{operation=\"GetObject\"}"), "not found charts counted as errors")
+	suite.True(strings.Contains(metrics, "chartmuseum_cache_lookups_total{repo=\"a\",result=\"hit\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_cache_lookups_total{repo=\"b\",result=\"miss\"}"))
+	suite.True(strings.Contains(metrics, "chartmuseum_upload_size_bytes_count{repo=\"a\",type=\"chart\"}"))
+}
+
+func (suite *MultiTenantServerTestSuite) TestConcurrentIndexRequests() {
+	var wg sync.WaitGroup
+	statuses := make(chan int, 30)
+	for i := 0; i < 10; i++ {
+		for _, org := range []string{"org1", "org2", "org3"} {
+			wg.Add(1)
+			go func(org string) {
+				defer wg.Done()
+				res := suite.doRequest("depth1", "GET", fmt.Sprintf("/%s/index.yaml", org), nil, "")
+				statuses <- res.Status()
+			}(org)
+		}
+	}
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		suite.Equal(200, status, "200 GET /:repo/index.yaml (concurrent)")
+	}
+
+	tenant := suite.Depth1Server.getTenant("org1")
+	suite.NotNil(tenant, "tenant initialized after concurrent requests")
+	entry, err := suite.Depth1Server.initCacheEntry(context.Background(), suite.Depth1Server.Logger.ContextLoggingFn(&gin.Context{}), "org1")
+	suite.Nil(err, "no error on init cache entry")
+	suite.Equal(1, len(suite.Depth1Server.getRepoIndex(entry).Entries), "index built once for concurrent requests")
+}
+
+// blockingBackend blocks reads of one object until released, like a tenant with a slow storage prefix
+type blockingBackend struct {
+	storage.Backend
+	path    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (backend *blockingBackend) GetObject(path string) (storage.Object, error) {
+	if path == backend.path {
+		close(backend.started)
+		<-backend.release
+	}
+	return backend.Backend.GetObject(path)
+}
+
+func (suite *MultiTenantServerTestSuite) TestTenantInitIsolation() {
+	dir := pathutil.Join(suite.TempDirectory, "tenantinit")
+	os.MkdirAll(dir, os.ModePerm)
+	backend := &blockingBackend{
+		Backend: storage.NewLocalFilesystemBackend(dir),
+		path:    pathutil.Join("slow", repo.StatefileFilename),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	server := suite.newTestServer("", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		StorageBackend: backend,
+		UseStatefiles:  true,
+	})
+
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		server.initCacheEntry(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), "slow")
+	}()
+	<-backend.started
+
+	fastDone := make(chan int)
+	go func() {
+		fastDone <- suite.serve(server, "GET", "/fast/index.yaml", nil).Code
+	}()
+
+	select {
+	case status := <-fastDone:
+		suite.Equal(200, status, "200 GET /fast/index.yaml while another tenant initializes")
+	case <-time.After(5 * time.Second):
+		suite.Fail("index request blocked by the initialization of another tenant")
+	}
+
+	close(backend.release)
+	<-slowDone
+	suite.NotNil(server.getTenant("slow"), "slow tenant initialized once released")
+}
+
+func (suite *MultiTenantServerTestSuite) TestRequestCancellation() {
+	dir := pathutil.Join(suite.TempDirectory, "cancellation")
+	os.MkdirAll(dir, os.ModePerm)
+	backend := &blockingBackend{
+		Backend: storage.NewLocalFilesystemBackend(dir),
+		path:    "mychart-0.1.0.tgz",
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	server := suite.newTestServer("", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		StorageBackend: backend,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int)
+	go func() {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request, _ = http.NewRequestWithContext(ctx, "GET", "/charts/mychart-0.1.0.tgz", nil)
+		server.Router.HandleContext(c)
+		done <- recorder.Code
+	}()
+	<-backend.started
+	cancel()
+
+	select {
+	case status := <-done:
+		suite.Equal(503, status, "503 GET /charts/mychart-0.1.0.tgz once cancelled")
+	case <-time.After(5 * time.Second):
+		suite.Fail("request blocked on storage after being cancelled")
+	}
+	suite.Equal(int64(1), atomic.LoadInt64(server.AbandonedRequests), "cancelled request still waiting on storage")
+	atomic.StoreInt64(server.AbandonedRequests, maxAbandonedRequests)
+	res := suite.serve(server, "GET", "/index.yaml", nil)
+	suite.Equal(503, res.Code, "503 GET /index.yaml with too many requests waiting on storage")
+	atomic.StoreInt64(server.AbandonedRequests, 1)
+	close(backend.release)
+	suite.Eventually(func() bool {
+		return atomic.LoadInt64(server.AbandonedRequests) == 0
+	}, 5*time.Second, 10*time.Millisecond, "cancelled request done once storage returns")
+
+	// backends taking a context stop reading instead
+	contextDir := pathutil.Join(suite.TempDirectory, "cancellationcontext")
+	os.MkdirAll(contextDir, os.ModePerm)
+	contextBackend := &contextBlockingBackend{blockingBackend: blockingBackend{
+		Backend: storage.NewLocalFilesystemBackend(contextDir),
+		path:    "mychart-0.1.0.tgz",
+		started: make(chan struct{}),
+	}, stopped: make(chan struct{})}
+	server = suite.newTestServer("", cm_router.RouterOptions{RequestTimeout: 1}, MultiTenantServerOptions{
+		StorageBackend: contextBackend,
+	})
+	res = suite.serve(server, "GET", "/charts/mychart-0.1.0.tgz", nil)
+	suite.Equal(504, res.Code, "504 GET /charts/mychart-0.1.0.tgz once timed out")
+	select {
+	case <-contextBackend.stopped:
+	case <-time.After(5 * time.Second):
+		suite.Fail("storage read not stopped after the request timed out")
+	}
+	suite.Eventually(func() bool {
+		return atomic.LoadInt64(server.AbandonedRequests) == 0
+	}, 5*time.Second, 10*time.Millisecond, "timed out request done once storage stops")
+}
+
+// contextBlockingBackend blocks reads of one object until their context is done
+type contextBlockingBackend struct {
+	blockingBackend
+	stopped chan struct{}
+}
+
+func (backend *contextBlockingBackend) ListObjectsContext(ctx context.Context, prefix string) ([]storage.Object, error) {
+	return backend.Backend.ListObjects(prefix)
+}
+
+func (backend *contextBlockingBackend) GetObjectContext(ctx context.Context, path string) (storage.Object, error) {
+	if path == backend.path {
+		close(backend.started)
+		<-ctx.Done()
+		close(backend.stopped)
+		return storage.Object{}, ctx.Err()
+	}
+	return backend.Backend.GetObject(path)
+}
+
+func (suite *MultiTenantServerTestSuite) TestWebUI() {
+	logger := suite.Logger
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "webui-noapi")),
+		EnableWebUI:    true,
+	})
+	suite.NotNil(err, "error creating server with web ui and without api")
+
+	server := suite.newTestServer("webui", cm_router.RouterOptions{ContextPath: "/cm"}, MultiTenantServerOptions{
+		EnableAPI:   true,
+		EnableWebUI: true,
+	})
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	suite.Equal(201, suite.serve(server, "POST", "/cm/api/charts", bytes.NewReader(content)).Code, "201 POST /cm/api/charts")
+
+	res := suite.serve(server, "GET", "/cm/", nil)
+	suite.Equal(200, res.Code, "200 GET /cm/")
+	suite.Equal("text/html; charset=utf-8", res.Header().Get("Content-Type"), "web ui is html")
+	suite.Contains(res.Body.String(), `var contextPath = "/cm";`, "web ui uses the context path")
+
+	res = suite.serve(server, "GET", "/cm/api/charts/mychart/0.1.0/values", nil)
+	suite.Equal(200, res.Code, "200 GET /cm/api/charts/mychart/0.1.0/values")
+	suite.Equal("text/plain; charset=utf-8", res.Header().Get("Content-Type"), "values are text")
+	suite.Empty(res.Body.String(), "chart without values")
+
+	res = suite.serve(server, "GET", "/cm/api/charts/mychart/0.1.0/readme", nil)
+	suite.Equal(200, res.Code, "200 GET /cm/api/charts/mychart/0.1.0/readme")
+	suite.Empty(res.Body.String(), "chart without readme")
+
+	suite.Equal(404, suite.serve(server, "GET", "/cm/api/charts/mychart/9.9.9/readme", nil).Code, "404 GET readme of missing chart")
+	suite.Equal(404, suite.serve(server, "GET", "/cm/api/charts/fakechart/0.1.0/values", nil).Code, "404 GET values of missing chart")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartVersionDiff() {
+	server := suite.newTestServer("diff", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+
+	for _, path := range []string{testTarballPath, testTarballPathV2} {
+		content, err := ioutil.ReadFile(path)
+		suite.Nil(err, "no error reading test tarball")
+		suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content)).Code, "201 POST /api/charts")
+	}
+
+	res := suite.serve(server, "GET", "/api/charts/mychart/diff?from=0.1.0&to=0.2.0", nil)
+	suite.Equal(200, res.Code, "200 GET /api/charts/mychart/diff")
+	var diff chartVersionDiff
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &diff), "no error decoding diff")
+	suite.Equal("mychart", diff.Name)
+	suite.Equal("0.1.0", diff.From)
+	suite.Equal("0.2.0", diff.To)
+	suite.Equal([]repo.Change{{Path: "version", From: "0.1.0", To: "0.2.0"}}, diff.Metadata, "version changed in Chart.yaml")
+	suite.Empty(diff.Values, "no values changed")
+	suite.Equal([]string{"Chart.yaml"}, diff.Files.Modified, "Chart.yaml modified")
+
+	suite.Equal(400, suite.serve(server, "GET", "/api/charts/mychart/diff?from=0.1.0", nil).Code, "400 GET diff without to")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/diff?from=0.1.0&to=9.9.9", nil).Code, "404 GET diff with missing version")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/fakechart/diff?from=0.1.0&to=0.2.0", nil).Code, "404 GET diff of missing chart")
+}
+
+func (suite *MultiTenantServerTestSuite) TestSBOM() {
+	server := suite.newTestServer("sbom", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:           true,
+		AllowForceOverwrite: true,
+	})
+
+	spdx := []byte(`{"spdxVersion": "SPDX-2.3", "SPDXID": "SPDXRef-DOCUMENT", "name": "mychart"}`)
+	cyclonedx := []byte(`{"bomFormat": "CycloneDX", "specVersion": "1.5"}`)
+
+	suite.Equal(404, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/sbom", bytes.NewReader(spdx)).Code, "404 POST sbom of missing chart")
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content)).Code, "201 POST /api/charts")
+
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/0.1.0/sbom", nil).Code, "404 GET sbom of chart without one")
+	suite.Equal(400, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/sbom", strings.NewReader("not an sbom")).Code, "400 POST invalid sbom")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/sbom", bytes.NewReader(spdx)).Code, "201 POST sbom")
+	_, err = server.StorageBackend.GetObject("mychart-0.1.0.tgz.sbom")
+	suite.Nil(err, "sbom stored next to the chart package")
+
+	res := suite.serve(server, "GET", "/api/charts/mychart/0.1.0/sbom", nil)
+	suite.Equal(200, res.Code, "200 GET sbom")
+	suite.Equal(repo.SBOMContentTypeSPDXJSON, res.Header().Get("Content-Type"), "content type of the sbom format")
+	suite.Equal(spdx, res.Body.Bytes())
+	suite.Equal(200, suite.serve(server, "GET", "/api/charts/mychart/latest/sbom", nil).Code, "200 GET sbom of latest version")
+
+	suite.Equal(409, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/sbom", bytes.NewReader(cyclonedx)).Code, "409 POST existing sbom")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/sbom?force", bytes.NewReader(cyclonedx)).Code, "201 POST existing sbom with force")
+	res = suite.serve(server, "GET", "/api/charts/mychart/0.1.0/sbom", nil)
+	suite.Equal(repo.SBOMContentTypeCycloneDXJSON, res.Header().Get("Content-Type"), "content type of the new sbom")
+
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil).Code, "200 DELETE chart")
+	_, err = server.StorageBackend.GetObject("mychart-0.1.0.tgz.sbom")
+	suite.NotNil(err, "sbom deleted with the chart")
+}
+
+func (suite *MultiTenantServerTestSuite) TestAttachments() {
+	server := suite.newTestServer("attachments", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content)).Code, "201 POST /api/charts")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error reading test provenance file")
+	otherProvContent, err := ioutil.ReadFile(otherTestProvfilePath)
+	suite.Nil(err, "no error reading other test provenance file")
+	report := []byte("ok 1 - deployment ready\n")
+
+	suite.Equal(404, suite.serve(server, "POST", "/api/charts/mychart/9.9.9/attachments/test-report", bytes.NewReader(report)).Code, "404 POST attachment of missing chart")
+	suite.Equal(400, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/tgz", bytes.NewReader(report)).Code, "400 POST attachment of chart package kind")
+	suite.Equal(400, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/prov", bytes.NewReader(otherProvContent)).Code, "400 POST provenance file of another chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/prov", bytes.NewReader(provContent)).Code, "201 POST provenance file attachment")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/test-report", bytes.NewReader(report)).Code, "201 POST attachment")
+	suite.Equal(409, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/test-report", bytes.NewReader(report)).Code, "409 POST existing attachment")
+
+	res := suite.serve(server, "GET", "/api/charts/mychart/0.1.0/attachments/test-report", nil)
+	suite.Equal(200, res.Code, "200 GET attachment")
+	suite.Equal("text/plain; charset=utf-8", res.Header().Get("Content-Type"), "content type detected")
+	suite.Equal(report, res.Body.Bytes())
+	res = suite.serve(server, "GET", "/api/charts/mychart/0.1.0/attachments/prov", nil)
+	suite.Equal(200, res.Code, "200 GET provenance file attachment")
+	suite.Equal(provenanceFileContentType, res.Header().Get("Content-Type"), "content type of provenance files")
+	res = suite.serve(server, "GET", "/charts/mychart-0.1.0.tgz.prov", nil)
+	suite.Equal(200, res.Code, "provenance file attachment served with the chart package")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/0.1.0/attachments/scan", nil).Code, "404 GET missing attachment")
+
+	var chartVersion struct {
+		Name        string   `json:"name"`
+		Attachments []string `json:"attachments"`
+	}
+	res = suite.serve(server, "GET", "/api/charts/mychart/0.1.0", nil)
+	suite.Equal(200, res.Code, "200 GET /api/charts/mychart/0.1.0")
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &chartVersion), "chart version is json")
+	suite.Equal("mychart", chartVersion.Name, "metadata of the chart version")
+	suite.Equal([]string{"prov", "test-report"}, chartVersion.Attachments, "attachments listed with the chart version")
+	var chartVersions []struct {
+		Attachments []string `json:"attachments"`
+	}
+	res = suite.serve(server, "GET", "/api/charts/mychart", nil)
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &chartVersions), "chart is json")
+	suite.Equal([]string{"prov", "test-report"}, chartVersions[0].Attachments, "attachments listed with the chart")
+
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0/attachments/test-report", nil).Code, "200 DELETE attachment")
+	suite.Equal(404, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0/attachments/test-report", nil).Code, "404 DELETE missing attachment")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/scan", strings.NewReader(`{"vulnerabilities": []}`)).Code, "201 POST attachment")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil).Code, "200 DELETE chart")
+	for _, filename := range []string{"mychart-0.1.0.tgz.prov", "mychart-0.1.0.tgz.scan"} {
+		_, err = server.StorageBackend.GetObject(filename)
+		suite.NotNil(err, "%s deleted with the chart", filename)
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) TestScan() {
+	logger := suite.Logger
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	contentV2, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error reading test tarball")
+
+	var scannerDown int32
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&scannerDown) == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		var request scan.ImagesRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Version == "0.2.0" {
+			w.Write([]byte(`{"findings": [{"id": "CVE-2022-0002", "severity": "CRITICAL", "target": "busybox"}]}`))
+			return
+		}
+		w.Write([]byte(`{"findings": []}`))
+	}))
+	defer scanner.Close()
+
+	newServer := func(name string, block bool) *MultiTenantServer {
+		server := suite.newTestServer("", cm_router.RouterOptions{}, MultiTenantServerOptions{
+			StorageBackend: storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, name)),
+			EnableAPI:      true,
+			ScanURL:        scanner.URL,
+			ScanBlock:      block,
+		})
+		return server
+	}
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "scan-invalid")),
+		ScanURL:        scanner.URL,
+		ScanSeverity:   "SEVERE",
+	})
+	suite.NotNil(err, "error creating server with invalid scan severity")
+
+	// without blocking, uploads are scanned in the background
+	server := newServer("scan", false)
+	res, _ := suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(201, res.Code, "201 POST /api/charts")
+	res, _ = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(contentV2))
+	suite.Equal(201, res.Code, "201 POST /api/charts with findings")
+	for version, status := range map[string]string{"0.1.0": scan.StatusPassed, "0.2.0": scan.StatusFailed} {
+		var response map[string]interface{}
+		suite.Eventually(func() bool {
+			res, response = suite.serveJSON(server, "GET", "/api/charts/mychart/"+version+"/scan", nil)
+			return res.Code == 200 && response["status"] != scan.StatusPending
+		}, 5*time.Second, 10*time.Millisecond, "scan of %s completed", version)
+		suite.Equal(status, response["status"], "status of the scan of %s", version)
+	}
+	res, response := suite.serveJSON(server, "GET", "/api/charts/mychart/0.2.0/scan", nil)
+	suite.Equal(map[string]interface{}{"CRITICAL": float64(1)}, response["summary"], "findings of 0.2.0 by severity")
+	res, response = suite.serveJSON(server, "GET", "/api/charts/mychart/0.2.0", nil)
+	suite.Equal([]interface{}{"scan"}, response["attachments"], "scan result attached to the chart version")
+	res, _ = suite.serveJSON(server, "POST", "/api/charts/mychart/0.2.0/attachments/scan", strings.NewReader(`{"status": "passed"}`))
+	suite.Equal(400, res.Code, "400 POST scan attachment")
+
+	atomic.StoreInt32(&scannerDown, 1)
+	res, response = suite.serveJSON(server, "POST", "/api/charts/mychart/0.1.0/scan", nil)
+	suite.Equal(202, res.Code, "202 POST /api/charts/mychart/0.1.0/scan")
+	suite.Eventually(func() bool {
+		res, response = suite.serveJSON(server, "GET", "/api/charts/mychart/0.1.0/scan", nil)
+		return res.Code == 200 && response["status"] == scan.StatusError
+	}, 5*time.Second, 10*time.Millisecond, "rescan failed with the scanner down")
+	res, _ = suite.serveJSON(server, "GET", "/api/charts/mychart/9.9.9/scan", nil)
+	suite.Equal(404, res.Code, "404 GET scan of missing chart version")
+	atomic.StoreInt32(&scannerDown, 0)
+
+	// with blocking, uploads failing their scan are rejected
+	server = newServer("scanblock", true)
+	res, _ = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(201, res.Code, "201 POST /api/charts passing its scan")
+	res, response = suite.serveJSON(server, "GET", "/api/charts/mychart/0.1.0/scan", nil)
+	suite.Equal(scan.StatusPassed, response["status"], "scan result stored with the chart")
+	res, response = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(contentV2))
+	suite.Equal(422, res.Code, "422 POST /api/charts failing its scan")
+	suite.Equal(cm_router.ErrorCodeScanFailed, response["code"])
+	_, err = server.StorageBackend.GetObject("mychart-0.2.0.tgz")
+	suite.NotNil(err, "chart failing its scan not stored")
+
+	atomic.StoreInt32(&scannerDown, 1)
+	res, response = suite.serveJSON(server, "POST", "/api/charts?force", bytes.NewReader(content))
+	suite.Equal(409, res.Code, "409 POST existing chart before scanning")
+	server = newServer("scanblockdown", true)
+	res, response = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(503, res.Code, "503 POST /api/charts with the scanner down")
+	suite.Equal(cm_router.ErrorCodeScanFailed, response["code"])
+}
+
+func (suite *MultiTenantServerTestSuite) TestAnnotations() {
+	server := suite.newTestServer("annotations", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:        true,
+		IndexAnnotations: true,
+	})
+
+	indexAnnotations := func() map[string]string {
+		res := suite.serve(server, "GET", "/index.yaml", nil)
+		index := &helm_repo.IndexFile{}
+		suite.Nil(yaml.Unmarshal(res.Body.Bytes(), index), "index is yaml")
+		if len(index.Entries["mychart"]) == 0 {
+			return nil
+		}
+		return index.Entries["mychart"][0].Annotations
+	}
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content)).Code, "201 POST /api/charts")
+
+	res := suite.serve(server, "GET", "/api/charts/mychart/0.1.0/annotations", nil)
+	suite.Equal(200, res.Code, "200 GET annotations")
+	suite.Equal("{}", res.Body.String(), "no annotations yet")
+	suite.Equal(404, suite.serve(server, "PATCH", "/api/charts/mychart/9.9.9/annotations", strings.NewReader(`{"approved-for-prod": "true"}`)).Code, "404 PATCH annotations of missing chart")
+	suite.Equal(400, suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`{"approved-for-prod": true}`)).Code, "400 PATCH annotation not a string")
+	suite.Equal(400, suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`["approved-for-prod"]`)).Code, "400 PATCH annotations not an object")
+
+	res = suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`{"approved-for-prod": "true", "owner": "team-a"}`))
+	suite.Equal(200, res.Code, "200 PATCH annotations")
+	suite.JSONEq(`{"approved-for-prod": "true", "owner": "team-a"}`, res.Body.String(), "annotations returned")
+	res = suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`{"owner": null, "ticket": "OPS-1"}`))
+	suite.Equal(200, res.Code, "200 PATCH annotations")
+	suite.JSONEq(`{"approved-for-prod": "true", "ticket": "OPS-1"}`, res.Body.String(), "annotations merged, null removing them")
+	res = suite.serve(server, "GET", "/api/charts/mychart/0.1.0/annotations", nil)
+	suite.JSONEq(`{"approved-for-prod": "true", "ticket": "OPS-1"}`, res.Body.String(), "annotations persisted")
+	_, err = server.StorageBackend.GetObject("mychart-0.1.0.tgz.annotations")
+	suite.Nil(err, "annotations stored next to the chart package")
+
+	var chartVersion struct {
+		Annotations map[string]string `json:"annotations"`
+		Attachments []string          `json:"attachments"`
+	}
+	res = suite.serve(server, "GET", "/api/charts/mychart/0.1.0", nil)
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &chartVersion), "chart version is json")
+	suite.Equal("true", chartVersion.Annotations["approved-for-prod"], "annotations of the chart version in the api")
+	suite.Equal([]string{"annotations"}, chartVersion.Attachments, "annotations listed as an attachment")
+
+	suite.Eventually(func() bool {
+		return indexAnnotations()["approved-for-prod"] == "true"
+	}, 5*time.Second, 10*time.Millisecond, "annotations in index.yaml")
+
+	suite.Equal(400, suite.serve(server, "POST", "/api/charts/mychart/0.1.0/attachments/annotations", strings.NewReader("approved")).Code, "400 POST invalid annotations attachment")
+
+	res = suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`{"approved-for-prod": null, "ticket": null}`))
+	suite.Equal(200, res.Code, "200 PATCH annotations")
+	suite.Equal("{}", res.Body.String(), "annotations removed")
+	_, err = server.StorageBackend.GetObject("mychart-0.1.0.tgz.annotations")
+	suite.NotNil(err, "annotations deleted from storage once empty")
+	suite.Eventually(func() bool {
+		return len(indexAnnotations()) == 0
+	}, 5*time.Second, 10*time.Millisecond, "annotations removed from index.yaml")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartOwners() {
+	server := suite.newTestServer("owners", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:        true,
+		RestrictToOwners: true,
+		ChartAdmins:      []string{"admin"},
+	})
+
+	// no authorizer is set, the users are the ones of the basic auth headers
+
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	contentV2, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error reading test tarball")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error reading test provenance file")
+
+	res := suite.serve(server, "GET", "/api/charts/mychart/owners", nil, withBasicAuth("alice", "password"))
+	suite.Equal(200, res.Code, "200 GET owners")
+	suite.Equal("[]", res.Body.String(), "no owners before the first upload")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withBasicAuth("alice", "password")).Code, "201 POST first upload")
+	res = suite.serve(server, "GET", "/api/charts/mychart/owners", nil, withBasicAuth("bob", "password"))
+	suite.Equal(`["alice"]`, res.Body.String(), "first uploader recorded as owner")
+
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(contentV2), withBasicAuth("bob", "password"))
+	suite.Equal(403, res.Code, "403 POST chart owned by another user")
+	var response map[string]interface{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &response), "error is json")
+	suite.Equal(cm_router.ErrorCodeForbidden, response["code"], "forbidden error code")
+	suite.Equal(403, suite.serve(server, "POST", "/api/charts", bytes.NewReader(contentV2)).Code, "403 POST anonymous upload of owned chart")
+	suite.Equal(403, suite.serve(server, "POST", "/api/prov", bytes.NewReader(provContent), withBasicAuth("bob", "password")).Code, "403 POST provenance file of chart owned by another user")
+	suite.Equal(403, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil, withBasicAuth("bob", "password")).Code, "403 DELETE chart owned by another user")
+	suite.Equal(403, suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`{"approved-for-prod": "true"}`), withBasicAuth("bob", "password")).Code, "403 PATCH annotations of chart owned by another user")
+	suite.Equal(403, suite.serve(server, "PUT", "/api/charts/mychart/owners", strings.NewReader(`["bob"]`), withBasicAuth("bob", "password")).Code, "403 PUT owners of chart owned by another user")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/prov", bytes.NewReader(provContent), withBasicAuth("alice", "password")).Code, "201 POST provenance file by owner")
+	suite.Equal(200, suite.serve(server, "PATCH", "/api/charts/mychart/0.1.0/annotations", strings.NewReader(`{"approved-for-prod": "true"}`), withBasicAuth("alice", "password")).Code, "200 PATCH annotations by owner")
+	suite.Equal(400, suite.serve(server, "PUT", "/api/charts/mychart/owners", strings.NewReader(`"bob"`), withBasicAuth("alice", "password")).Code, "400 PUT invalid owners")
+	res = suite.serve(server, "PUT", "/api/charts/mychart/owners", strings.NewReader(`["alice", "bob"]`), withBasicAuth("alice", "password"))
+	suite.Equal(200, res.Code, "200 PUT owners by owner")
+	suite.Equal(`["alice","bob"]`, res.Body.String())
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(contentV2), withBasicAuth("bob", "password")).Code, "201 POST by added owner")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.2.0", nil, withBasicAuth("admin", "password")).Code, "200 DELETE by admin")
+
+	suite.Equal(200, suite.serve(server, "PUT", "/api/charts/mychart/owners", strings.NewReader(`[]`), withBasicAuth("admin", "password")).Code, "200 PUT no owners by admin")
+	_, err = server.StorageBackend.GetObject("mychart.owners")
+	suite.NotNil(err, "owners deleted from storage once emptied")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(contentV2), withBasicAuth("carol", "password")).Code, "201 POST chart without owners")
+	res = suite.serve(server, "GET", "/api/charts/mychart/owners", nil, withBasicAuth("carol", "password"))
+	suite.Equal(`["carol"]`, res.Body.String(), "chart claimed again by the next upload")
+
+	// owners that cannot be read are not taken for no owners
+	server = suite.newTestServer("", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		StorageBackend:   &failingReadsBackend{Backend: server.StorageBackend, suffix: ".owners"},
+		EnableAPI:        true,
+		RestrictToOwners: true,
+	})
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(contentV2), withBasicAuth("bob", "password"))
+	suite.Equal(500, res.Code, "500 POST chart whose owners cannot be read")
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &response), "error is json")
+	suite.Equal(cm_router.ErrorCodeInternal, response["code"], "internal error code")
+	suite.Equal(500, suite.serve(server, "DELETE", "/api/charts/mychart/0.2.0", nil, withBasicAuth("bob", "password")).Code, "500 DELETE chart whose owners cannot be read")
+	suite.Equal(500, suite.serve(server, "GET", "/api/charts/mychart/owners", nil, withBasicAuth("bob", "password")).Code, "500 GET owners that cannot be read")
+	_, err = server.StorageBackend.GetObject("mychart-0.2.0.tgz")
+	suite.Nil(err, "chart whose owners cannot be read not deleted")
+}
+
+// failingReadsBackend fails to read the objects with a suffix, like objects denied to the credentials used
+type failingReadsBackend struct {
+	storage.Backend
+	suffix string
+}
+
+func (backend *failingReadsBackend) GetObject(path string) (storage.Object, error) {
+	if strings.HasSuffix(path, backend.suffix) {
+		return storage.Object{}, errors.New("access denied")
+	}
+	return backend.Backend.GetObject(path)
+}
+
+func (suite *MultiTenantServerTestSuite) TestIndexSigning() {
+	logger := suite.Logger
+
+	publicKey, err := os.Open("../../../../testdata/pgp/helm-test-key.pub")
+	suite.Nil(err, "no error opening public key")
+	defer publicKey.Close()
+	keyring, err := openpgp.ReadKeyRing(publicKey)
+	suite.Nil(err, "no error reading public key")
+
+	newServer := func(indexSharding string) *MultiTenantServer {
+		server := suite.newTestServer("", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+			StorageBackend:  suite.Depth1Server.StorageBackend,
+			IndexSharding:   indexSharding,
+			IndexSigningKey: "../../../../testdata/pgp/helm-test-key.secret",
+		})
+		return server
+	}
+	checkSignature := func(server *MultiTenantServer, urlStr string) {
+		index := suite.serve(server, "GET", urlStr, nil)
+		suite.Equal(200, index.Code, "200 GET %s", urlStr)
+		signature := suite.serve(server, "GET", urlStr+".asc", nil)
+		suite.Equal(200, signature.Code, "200 GET %s.asc", urlStr)
+		suite.Equal(repo.IndexSignatureContentType, signature.Header().Get("Content-Type"))
+		_, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(index.Body.Bytes()), bytes.NewReader(signature.Body.Bytes()))
+		suite.Nil(err, "signature of %s checked with the public key", urlStr)
+	}
+
+	server := newServer("")
+	checkSignature(server, "/org1/index.yaml")
+	suite.Equal(404, suite.serve(server, "GET", "/org1/index-m.yaml.asc", nil).Code, "404 GET shard signature without index sharding")
+
+	server = newServer(repo.IndexShardingAlpha)
+	checkSignature(server, "/org1/index.yaml")
+	checkSignature(server, "/org1/index-m.yaml")
+	suite.Equal(404, suite.serve(server, "GET", "/org1/index-zz.yaml.asc", nil).Code, "404 GET signature of missing shard")
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:          logger,
+		Router:          cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:  suite.Depth0Server.StorageBackend,
+		IndexSigningKey: "../../../../testdata/pgp/helm-test-key.pub",
+	})
+	suite.NotNil(err, "error creating server with keyring without private key")
+}
+
+func (suite *MultiTenantServerTestSuite) TestCacheControl() {
+	server := suite.newTestServer("", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		StorageBackend:     suite.Depth0Server.StorageBackend,
+		EnableAPI:          true,
+		CacheControlIndex:  "public, max-age=60",
+		CacheControlCharts: "public, max-age=31536000, immutable",
+	})
+
+	res := suite.serve(server, "GET", "/index.yaml", nil)
+	suite.Equal(200, res.Code, "200 GET /index.yaml")
+	suite.Equal("public, max-age=60", res.Header().Get("Cache-Control"), "cache control of index")
+	expires, err := http.ParseTime(res.Header().Get("Expires"))
+	suite.Nil(err, "expires header is a date")
+	suite.WithinDuration(time.Now().Add(time.Minute), expires, 5*time.Second, "index expires after its max age")
+
+	res = suite.serve(server, "GET", "/charts/mychart-0.1.0.tgz", nil)
+	suite.Equal(200, res.Code, "200 GET /charts/mychart-0.1.0.tgz")
+	suite.Equal("public, max-age=31536000, immutable", res.Header().Get("Cache-Control"), "cache control of chart packages")
+	res = suite.serve(server, "GET", "/charts/mychart-0.1.0.tgz.prov", nil)
+	suite.Equal(200, res.Code, "200 GET /charts/mychart-0.1.0.tgz.prov")
+	suite.Equal("public, max-age=31536000, immutable", res.Header().Get("Cache-Control"), "cache control of provenance files")
+
+	res = suite.serve(server, "GET", "/charts/mychart-9.9.9.tgz", nil)
+	suite.Equal(404, res.Code, "404 GET missing chart package")
+	suite.Empty(res.Header().Get("Cache-Control"), "errors not cached")
+	res = suite.serve(server, "GET", "/api/charts", nil)
+	suite.Empty(res.Header().Get("Cache-Control"), "api not cached")
+
+	server = suite.newTestServer("", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		StorageBackend:    suite.Depth0Server.StorageBackend,
+		CacheControlIndex: "no-cache",
+	})
+	res = suite.serve(server, "GET", "/index.yaml", nil)
+	suite.Equal("no-cache", res.Header().Get("Cache-Control"), "cache control of index")
+	suite.Empty(res.Header().Get("Expires"), "no expires header without max age")
+	res = suite.serve(server, "GET", "/charts/mychart-0.1.0.tgz", nil)
+	suite.Empty(res.Header().Get("Cache-Control"), "no cache control of chart packages by default")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDownloadRedirect() {
+	logger := suite.Logger
+
+	server := suite.newTestServer("", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		StorageBackend:      suite.Depth1Server.StorageBackend,
+		DownloadRedirectURL: "https://cdn.example.com/charts/{path}",
+	})
+
+	res := suite.serve(server, "GET", "/org1/charts/mychart-0.1.0.tgz", nil)
+	suite.Equal(302, res.Code, "302 GET chart package")
+	suite.Equal("https://cdn.example.com/charts/org1/mychart-0.1.0.tgz", res.Header().Get("Location"))
+	res = suite.serve(server, "GET", "/org1/charts/mychart-0.1.0.tgz.prov", nil)
+	suite.Equal(302, res.Code, "302 GET provenance file")
+	suite.Equal("https://cdn.example.com/charts/org1/mychart-0.1.0.tgz.prov", res.Header().Get("Location"))
+	suite.Equal(404, suite.serve(server, "GET", "/org1/charts/mychart-9.9.9.tgz", nil).Code, "404 GET chart version not in index")
+	suite.Equal(200, suite.serve(server, "GET", "/org1/index.yaml", nil).Code, "200 GET index not redirected")
+
+	for _, urlTemplate := range []string{"cdn.example.com/{path}", "ftp://cdn.example.com/{path}"} {
+		_, err := NewMultiTenantServer(MultiTenantServerOptions{
+			Logger:              logger,
+			Router:              cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+			StorageBackend:      suite.Depth0Server.StorageBackend,
+			DownloadRedirectURL: urlTemplate,
+		})
+		suite.NotNil(err, "error with invalid download redirect url %s", urlTemplate)
+	}
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:           logger,
+		Router:           cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:   suite.Depth0Server.StorageBackend,
+		PresignDownloads: true,
+	})
+	suite.NotNil(err, "error presigning downloads without the amazon storage backend")
+
+	backend := storage.NewAmazonS3BackendWithCredentials("charts", "prefix", "us-east-1", "", "",
+		credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""))
+	redirect, err := newDownloadRedirect(backend, "", true, time.Minute)
+	suite.Nil(err, "no error presigning downloads with the amazon storage backend")
+	location, err := redirect.url("org1", "mychart-0.1.0.tgz")
+	suite.Nil(err, "no error presigning url")
+	presigned, err := url.Parse(location)
+	suite.Nil(err, "presigned url is a url")
+	suite.Equal("/prefix/org1/mychart-0.1.0.tgz", strings.TrimPrefix(presigned.Path, "/charts"), "key of the object in the bucket")
+	suite.Equal("60", presigned.Query().Get("X-Amz-Expires"), "presigned url valid for the ttl")
+	suite.NotEmpty(presigned.Query().Get("X-Amz-Signature"), "presigned url signed")
+}
+
+func (suite *MultiTenantServerTestSuite) TestRegenerationLimit() {
+	// in a tenant, as priming the root repo would take the slot of the requests below
+	dir := pathutil.Join(suite.TempDirectory, "regenerationlimit", "org1")
+	os.MkdirAll(dir, os.ModePerm)
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "mychart-0.1.0.tgz"), content, 0644))
+
+	newServer := func() *MultiTenantServer {
+		server := suite.newTestServer("regenerationlimit", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+			StaleWhileRevalidate: true,
+			MaxStaleness:         time.Nanosecond,
+			RegenerationLimit:    1,
+		})
+		return server
+	}
+
+	server := newServer()
+	res := suite.serve(server, "GET", "/org1/index.yaml", nil)
+	suite.Equal(200, res.Code, "200 GET /org1/index.yaml")
+	suite.Contains(res.Body.String(), "version: 0.1.0", "chart in index")
+
+	content, err = ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "mychart-0.2.0.tgz"), content, 0644))
+
+	// every slot taken, e.g. by the regeneration of other repos
+	server.RegenerationSlots <- struct{}{}
+	res = suite.serve(server, "GET", "/org1/index.yaml", nil)
+	suite.Equal(200, res.Code, "200 GET /org1/index.yaml beyond the regeneration limit")
+	suite.NotContains(res.Body.String(), "version: 0.2.0", "cached index served beyond the regeneration limit")
+	<-server.RegenerationSlots
+
+	res = suite.serve(server, "GET", "/org1/index.yaml", nil)
+	suite.Equal(200, res.Code, "200 GET /org1/index.yaml")
+	suite.Contains(res.Body.String(), "version: 0.2.0", "index regenerated with a free slot")
+
+	// without an index to serve, requests wait for a slot
+	server = newServer()
+	server.RegenerationSlots <- struct{}{}
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- suite.serve(server, "GET", "/org1/index.yaml", nil) }()
+	select {
+	case <-done:
+		suite.Fail("request served before a slot was free")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-server.RegenerationSlots
+	select {
+	case res = <-done:
+		suite.Equal(200, res.Code, "200 GET /org1/index.yaml once a slot is free")
+		suite.Contains(res.Body.String(), "version: 0.2.0", "index generated once a slot is free")
+	case <-time.After(5 * time.Second):
+		suite.Fail("request not served once a slot was free")
+	}
+
+	// the requests coalesced on a listing still wait for a slot once the first one is cancelled
+	server = newServer()
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	entry, err := server.initCacheEntry(context.Background(), log, "org1")
+	suite.Nil(err, "no error on init cache entry")
+	server.RegenerationSlots <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan fetchedObjects, 1)
+	second := make(chan fetchedObjects, 1)
+	go func() { first <- <-server.getChartList(ctx, log, entry, true) }()
+	time.Sleep(50 * time.Millisecond)
+	go func() { second <- <-server.getChartList(context.Background(), log, entry, true) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	<-server.RegenerationSlots
+	select {
+	case fo := <-second:
+		suite.Nil(fo.err, "no error listing charts once the first caller is cancelled")
+		suite.Len(fo.objects, 2, "charts listed once the first caller is cancelled")
+	case <-time.After(5 * time.Second):
+		suite.Fail("charts not listed once a slot was free")
+	}
+	<-first
+}
+
+func (suite *MultiTenantServerTestSuite) TestPrimeTenants() {
+	logger := suite.Logger
+
+	dir := pathutil.Join(suite.TempDirectory, "primetenants")
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	for _, path := range []string{"org1/repo1/mychart-0.1.0.tgz", "org1/repo1/.trash/mychart-0.1.0.tgz", "org1/.hidden/mychart-0.1.0.tgz", "org2/mychart-0.1.0.tgz", "mychart-0.1.0.tgz"} {
+		os.MkdirAll(pathutil.Join(dir, pathutil.Dir(path)), os.ModePerm)
+		suite.Nil(ioutil.WriteFile(pathutil.Join(dir, path), content, 0644))
+	}
+	os.MkdirAll(pathutil.Join(dir, "org2/repo1"), os.ModePerm)
+	suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "org2/repo1", repo.StatefileFilename), []byte{}, 0644))
+
+	newServer := func(primeTenants []string, primeAll bool) (*MultiTenantServer, error) {
+		return NewMultiTenantServer(MultiTenantServerOptions{
+			Logger:          logger,
+			Router:          cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 2}),
+			StorageBackend:  storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+			PrimeTenants:    primeTenants,
+			PrimeAllTenants: primeAll,
+		})
+	}
+
+	_, err = newServer([]string{"org1/repo1/chart"}, false)
+	suite.NotNil(err, "error priming tenant deeper than the depth")
+
+	server, err := newServer([]string{"/org3/repo1/"}, true)
+	suite.Nil(err, "no error creating server")
+	repos, err := server.primedRepos()
+	suite.Nil(err, "no error discovering tenants")
+	suite.Equal([]string{"org1/repo1", "org2/repo1", "org3/repo1"}, repos, "tenants set and found in storage primed")
+
+	suite.Eventually(server.ready, 5*time.Second, 10*time.Millisecond, "ready once primed")
+	for _, repo := range repos {
+		suite.NotNil(server.getTenant(repo), "tenant %s primed", repo)
+	}
+	server.TenantCacheKeyLock.RLock()
+	entry := server.InternalCacheStore["org1/repo1"]
+	server.TenantCacheKeyLock.RUnlock()
+	index := server.getRepoIndex(entry)
+	suite.Len(index.Entries["mychart"], 1, "index of org1/repo1 built at startup")
+	suite.Nil(server.getTenant("org2"), "tenant not primed")
+
+	server, err = newServer(nil, false)
+	suite.Nil(err, "no error creating server")
+	repos, err = server.primedRepos()
+	suite.Nil(err, "no error without tenants to prime")
+	suite.Empty(repos, "no tenants primed by default in multitenant mode")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartPolicy() {
+	logger := suite.Logger
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:           logger,
+		Router:           cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:   suite.Depth0Server.StorageBackend,
+		ChartNamePattern: "[a-z",
+	})
+	suite.NotNil(err, "error with invalid chart name pattern")
+
+	newServer := func(namePattern string, versionPattern string, strictSemver bool) *MultiTenantServer {
+		server := suite.newTestServer("chartpolicy", cm_router.RouterOptions{}, MultiTenantServerOptions{
+			EnableAPI:           true,
+			AllowForceOverwrite: true,
+			ChartNamePattern:    namePattern,
+			ChartVersionPattern: versionPattern,
+			StrictSemver:        strictSemver,
+		})
+		return server
+	}
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+
+	server := newServer(`^team-a-[a-z0-9-]+$`, "", false)
+	res := suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content))
+	suite.Equal(400, res.Code, "400 POST chart without team prefix")
+	suite.Contains(res.Body.String(), `chart name \"mychart\" does not match the allowed pattern ^team-a-[a-z0-9-]+$`)
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	res = suite.serve(server, "POST", "/api/charts", buf, withHeader("Content-Type", w.FormDataContentType()))
+	suite.Equal(400, res.Code, "400 POST form chart without team prefix")
+	suite.Contains(res.Body.String(), "does not match the allowed pattern")
+	_, err = server.StorageBackend.GetObject("mychart-0.1.0.tgz")
+	suite.NotNil(err, "chart rejected by policy not stored")
+
+	server = newServer(`^[a-z0-9-]+$`, `^\d+\.\d+\.\d+$`, true)
+	res = suite.serve(server, "POST", "/api/charts?version=1.0", bytes.NewBuffer(content))
+	suite.Equal(400, res.Code, "400 POST chart without strict semver version")
+	suite.Contains(res.Body.String(), "not a strict semver 2.0 version")
+	res = suite.serve(server, "POST", "/api/charts?version=1.0.0-rc.1", bytes.NewBuffer(content))
+	suite.Equal(400, res.Code, "400 POST chart with version not matching the pattern")
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content))
+	suite.Equal(201, res.Code, "201 POST chart allowed by policy")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	res = suite.serve(server, "POST", "/api/charts?version=0.3.0", buf, withHeader("Content-Type", w.FormDataContentType()))
+	suite.Equal(201, res.Code, "201 POST form chart allowed by policy")
+}
+
+func (suite *MultiTenantServerTestSuite) TestIdempotentUploads() {
+	newServer := func(idempotentUploads bool) *MultiTenantServer {
+		server := suite.newTestServer("idempotentuploads", cm_router.RouterOptions{}, MultiTenantServerOptions{
+			EnableAPI:         true,
+			IdempotentUploads: idempotentUploads,
+		})
+		return server
+	}
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provenance file")
+
+	server := newServer(true)
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content)).Code, "201 POST /api/charts")
+	suite.Equal(201, suite.serve(server, "POST", "/api/prov", bytes.NewBuffer(provContent)).Code, "201 POST /api/prov")
+
+	res := suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content))
+	suite.Equal(200, res.Code, "200 POST /api/charts with the same content")
+	suite.JSONEq(`{"saved": true, "unchanged": true}`, res.Body.String(), "upload flagged unchanged")
+	res = suite.serve(server, "POST", "/api/prov", bytes.NewBuffer(provContent))
+	suite.Equal(200, res.Code, "200 POST /api/prov with the same content")
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
+	res = suite.serve(server, "POST", "/api/charts", buf, withHeader("Content-Type", w.FormDataContentType()))
+	suite.Equal(200, res.Code, "200 POST form with the same content")
+	suite.JSONEq(`{"saved": true, "unchanged": true}`, res.Body.String(), "form upload flagged unchanged")
+
+	// repackaged with another app version, the same chart version has another content
+	res = suite.serve(server, "POST", "/api/charts?version=0.1.0&appVersion=9.9.9", bytes.NewBuffer(content))
+	suite.Equal(409, res.Code, "409 POST /api/charts with other content")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	res = suite.serve(server, "POST", "/api/charts?version=0.1.0&appVersion=9.9.9", buf, withHeader("Content-Type", w.FormDataContentType()))
+	suite.Equal(409, res.Code, "409 POST form with other content")
+
+	server = newServer(false)
+	suite.Equal(409, suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content)).Code, "409 POST /api/charts by default")
+	suite.Equal(409, suite.serve(server, "POST", "/api/prov", bytes.NewBuffer(provContent)).Code, "409 POST /api/prov by default")
+}
+
+func (suite *MultiTenantServerTestSuite) TestExpectedDigest() {
+	server := suite.newTestServer("expecteddigest", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:           true,
+		AllowForceOverwrite: true,
+	})
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	digest := strings.TrimPrefix(ociDigest(content), "sha256:")
+
+	res := suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content), withHeader("If-Match", "*"))
+	suite.Equal(412, res.Code, "412 POST chart version not stored yet with If-Match: *")
+	suite.Contains(res.Body.String(), cm_router.ErrorCodePreconditionFailed)
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content)).Code, "201 POST /api/charts")
+
+	res = suite.serve(server, "POST", "/api/charts?force", bytes.NewBuffer(content), withHeader("If-Match", strings.Repeat("0", 64)))
+	suite.Equal(412, res.Code, "412 POST with another digest")
+	suite.Contains(res.Body.String(), "has digest "+digest)
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?force", bytes.NewBuffer(content), withHeader("If-Match", `"`+digest+`"`)).Code, "201 POST with the digest in If-Match")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?force&expected-digest=sha256:"+digest, bytes.NewBuffer(content)).Code,
+		"201 POST with ?expected-digest")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?force", bytes.NewBuffer(content), withHeader("If-Match", "*")).Code, "201 POST existing chart version with If-Match: *")
+	suite.Equal(409, suite.serve(server, "POST", "/api/charts", bytes.NewBuffer(content), withHeader("If-Match", digest)).Code, "409 POST with the digest but without ?force")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(412, suite.serve(server, "POST", "/api/charts?force", buf, withHeader("Content-Type", w.FormDataContentType()), withHeader("If-Match", strings.Repeat("0", 64))).Code, "412 POST form with another digest")
+	buf, w = suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPath})
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?force", buf, withHeader("Content-Type", w.FormDataContentType()), withHeader("If-Match", digest)).Code, "201 POST form with the digest")
+}
+
+// testLocker records the names locked, failing every lock with err if set
+type testLocker struct {
+	mutex  sync.Mutex
+	locked []string
+	held   map[string]bool
+	err    error
+}
+
+func (locker *testLocker) Lock(ctx context.Context, name string) (func(), error) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+	if locker.err != nil {
+		return nil, locker.err
+	}
+	locker.locked = append(locker.locked, name)
+	locker.held[name] = true
+	return func() {
+		locker.mutex.Lock()
+		defer locker.mutex.Unlock()
+		delete(locker.held, name)
+	}, nil
+}
+
+func (locker *testLocker) names() []string {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+	return append([]string{}, locker.locked...)
+}
+
+func (suite *MultiTenantServerTestSuite) TestLocker() {
+	locker := &testLocker{held: map[string]bool{}}
+	server := suite.newTestServer("locker", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:     true,
+		UseStatefiles: true,
+		Locker:        locker,
+		LockTimeout:   time.Second,
+	})
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+
+	suite.Equal(200, suite.serve(server, "GET", "/org1/index.yaml", nil).Code, "200 GET /org1/index.yaml")
+	suite.NotContains(locker.names(), "repo/org1", "no lock of the repo for reads")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewBuffer(content)).Code, "201 POST /api/org1/charts")
+	suite.Contains(locker.names(), "repo/org1", "upload under the lock of the repo")
+	before := len(locker.names())
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/promote?target=org0", nil).Code, "201 POST promote to org0")
+	suite.Equal([]string{"repo/org0", "repo/org1"}, locker.names()[before:], "promotion under the locks of both repos, in order")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil).Code, "200 DELETE /api/org1/charts/mychart/0.1.0")
+	suite.Equal(200, suite.serve(server, "GET", "/org1/index.yaml", nil).Code, "200 GET /org1/index.yaml")
+	suite.Eventually(func() bool {
+		for _, name := range locker.names() {
+			if name == "index-cache/org1" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "index-cache.yaml saved under a lock of its own")
+	suite.Eventually(func() bool {
+		locker.mutex.Lock()
+		defer locker.mutex.Unlock()
+		return len(locker.held) == 0
+	}, 5*time.Second, 10*time.Millisecond, "locks released")
+
+	locker.mutex.Lock()
+	locker.err = context.DeadlineExceeded
+	locker.mutex.Unlock()
+	res := suite.serve(server, "POST", "/api/org1/charts", bytes.NewBuffer(content))
+	suite.Equal(503, res.Code, "503 POST /api/org1/charts without the lock")
+	suite.Contains(res.Body.String(), `"code":"timeout"`, "timeout error code")
+	suite.Equal(503, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil).Code, "503 DELETE without the lock")
+	suite.Equal(200, suite.serve(server, "GET", "/org1/index.yaml", nil).Code, "reads served without the lock")
+}
+
+// testLease grants the lease to its holder once granted is set
+type testLease struct {
+	granted int32
+}
+
+func (lease *testLease) Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	return atomic.LoadInt32(&lease.granted) == 1, nil
+}
+
+func (suite *MultiTenantServerTestSuite) TestLeader() {
+	logger := suite.Logger
+
+	source := suite.newTestServer("leader-source", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", bytes.NewReader(content)).Code, "201 POST chart to source")
+	sourceServer := httptest.NewServer(source.Router)
+	defer sourceServer.Close()
+
+	config, err := replication.ConfigFromContent([]byte(fmt.Sprintf(`
+interval: 50ms
+sources:
+  - url: %s
+    repo: mirror
+`, sourceServer.URL)))
+	suite.Nil(err, "no error parsing replication config")
+	lease := &testLease{}
+	mirrorDir := pathutil.Join(suite.TempDirectory, "leader-mirror")
+	os.MkdirAll(mirrorDir, os.ModePerm)
+	mirror := suite.newTestServer("leader-mirror", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:   true,
+		Replication: config,
+		Leader: lock.NewElector(lock.ElectorOptions{
+			Logger: logger,
+			Lease:  lease,
+			TTL:    150 * time.Millisecond,
+		}),
+	})
+	replicated := func() bool {
+		_, err := os.Stat(pathutil.Join(mirrorDir, "mirror", "mychart-0.1.0.tgz"))
+		return err == nil
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	suite.False(mirror.Leader.IsLeader(), "lease not granted")
+	suite.False(replicated(), "no replication without leadership")
+
+	atomic.StoreInt32(&lease.granted, 1)
+	suite.Eventually(mirror.Leader.IsLeader, 5*time.Second, 10*time.Millisecond, "elected once the lease is granted")
+	suite.Eventually(replicated, 5*time.Second, 10*time.Millisecond, "replication run by the leader")
+}
+
+func (suite *MultiTenantServerTestSuite) TestUploadChecksum() {
+	dir := pathutil.Join(suite.TempDirectory, "uploadchecksum")
+	os.MkdirAll(dir, os.ModePerm)
+	server := suite.newTestServer("uploadchecksum", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	sum := sha256.Sum256(content)
+	sum512 := sha512.Sum512(content)
+	truncated := content[:len(content)-10]
+
+	res := suite.serve(server, "POST", "/api/charts", bytes.NewReader(truncated), withHeader("X-Checksum-SHA256", hex.EncodeToString(sum[:])))
+	suite.Equal(400, res.Code, "400 POST truncated chart")
+	suite.Contains(res.Body.String(), `"code":"checksum_mismatch"`, "checksum mismatch error code")
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(truncated), withHeader("Digest", "SHA-512="+base64.StdEncoding.EncodeToString(sum512[:])))
+	suite.Equal(400, res.Code, "400 POST truncated chart with a Digest header")
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withHeader("X-Checksum-SHA256", "not-hex"))
+	suite.Equal(400, res.Code, "400 POST with an invalid checksum header")
+	suite.Contains(res.Body.String(), `"code":"bad_request"`, "bad request error code")
+	_, err = os.Stat(pathutil.Join(dir, "mychart-0.1.0.tgz"))
+	suite.True(os.IsNotExist(err), "nothing saved")
+
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withHeader("X-Checksum-SHA256", strings.ToUpper(hex.EncodeToString(sum[:]))), withHeader("Digest", "md5=ignored, SHA-256="+base64.StdEncoding.EncodeToString(sum[:])))
+	suite.Equal(201, res.Code, "201 POST chart matching its checksums")
+
+	provContent, err := ioutil.ReadFile(testProvfilePath)
 	suite.Nil(err, "no error opening test provenance file")
-	body = bytes.NewBuffer(content)
-	res = suite.doRequest("maxuploadsize", "POST", "/api/prov", body, "")
-	suite.Equal(413, res.Status(), "201 POST /api/prov")
+	res = suite.serve(server, "POST", "/api/prov", bytes.NewReader(provContent), withHeader("X-Checksum-SHA256", hex.EncodeToString(sum[:])))
+	suite.Equal(400, res.Code, "400 POST provenance file not matching its checksum")
+	provSum := sha256.Sum256(provContent)
+	res = suite.serve(server, "POST", "/api/prov", bytes.NewReader(provContent), withHeader("X-Checksum-SHA256", hex.EncodeToString(provSum[:])))
+	suite.Equal(201, res.Code, "201 POST provenance file matching its checksum")
+
+	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart"}, []string{testTarballPathV2})
+	body, err := ioutil.ReadAll(buf)
+	suite.Nil(err, "no error reading form")
+	bodySum := sha256.Sum256(body)
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(body), withHeader("Content-Type", w.FormDataContentType()), withHeader("X-Checksum-SHA256", hex.EncodeToString(bodySum[:])))
+	suite.Equal(201, res.Code, "201 POST form matching its checksum")
+	res = suite.serve(server, "POST", "/api/charts", bytes.NewReader(body[:len(body)-10]), withHeader("Content-Type", w.FormDataContentType()), withHeader("X-Checksum-SHA256", hex.EncodeToString(bodySum[:])))
+	suite.Equal(400, res.Code, "400 POST truncated form")
+}
 
-	buf, w := suite.getBodyWithMultipartFormFiles([]string{"chart", "prov"}, []string{testTarballPath, testProvfilePath})
-	res = suite.doRequest("maxuploadsize", "POST", "/api/charts", buf, w.FormDataContentType())
-	suite.Equal(413, res.Status(), "413 POST /api/charts")
+func (suite *MultiTenantServerTestSuite) TestIndexJournal() {
+	dir := pathutil.Join(suite.TempDirectory, "indexjournal")
+	os.MkdirAll(dir, os.ModePerm)
+	newServer := func(indexJournal bool) *MultiTenantServer {
+		server := suite.newTestServer("indexjournal", cm_router.RouterOptions{}, MultiTenantServerOptions{
+			EnableAPI:    true,
+			IndexJournal: indexJournal,
+		})
+		return server
+	}
+	server := newServer(true)
+	at := func() string {
+		defer time.Sleep(10 * time.Millisecond)
+		return url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	}
+
+	beforeJournal := at()
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", suite.readFile(testTarballPath)).Code, "201 POST chart 0.1.0")
+	suite.waitForWrites(server)
+	afterFirst := at()
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", suite.readFile(testTarballPathV2)).Code, "201 POST chart 0.2.0")
+	suite.waitForWrites(server)
+	afterSecond := at()
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil).Code, "200 DELETE chart 0.1.0")
+	suite.waitForWrites(server)
+
+	var objects []os.FileInfo
+	suite.Eventually(func() bool {
+		objects, _ = ioutil.ReadDir(pathutil.Join(dir, journalDirectory))
+		return len(objects) == 4
+	}, 5*time.Second, 10*time.Millisecond, "journal of a snapshot and 3 changes")
+	suite.Contains(objects[0].Name(), "-snapshot.json", "journal started with a snapshot")
+
+	versionsAt := func(at string) []string {
+		res := suite.serve(server, "GET", "/index.yaml?at="+at, nil)
+		suite.Equal(200, res.Code, "200 GET /index.yaml?at="+at)
+		indexFile := &helm_repo.IndexFile{}
+		suite.Nil(yaml.Unmarshal(res.Body.Bytes(), indexFile), "index as of a time parsed")
+		var versions []string
+		for _, chartVersion := range indexFile.Entries["mychart"] {
+			versions = append(versions, chartVersion.Version)
+			suite.Equal([]string{"charts/mychart-" + chartVersion.Version + ".tgz"}, chartVersion.URLs, "chart urls kept")
+		}
+		return versions
+	}
+	suite.Equal([]string{"0.1.0"}, versionsAt(afterFirst), "index after the first upload")
+	suite.Equal([]string{"0.2.0", "0.1.0"}, versionsAt(afterSecond), "index after the second upload")
+	suite.Equal([]string{"0.2.0"}, versionsAt(at()), "index after the delete")
+
+	suite.Equal(404, suite.serve(server, "GET", "/index.yaml?at="+beforeJournal, nil).Code, "404 before the journal started")
+	suite.Equal(400, suite.serve(server, "GET", "/index.yaml?at=yesterday", nil).Code, "400 with an invalid time")
+
+	server = newServer(false)
+	suite.Equal(400, suite.serve(server, "GET", "/index.yaml?at="+at(), nil).Code, "400 without --index-journal")
+	suite.Equal(200, suite.serve(server, "GET", "/index.yaml", nil).Code, "200 GET /index.yaml without a time")
 }
 
-func (suite *MultiTenantServerTestSuite) TestMetrics() {
+func (suite *MultiTenantServerTestSuite) TestChartHistory() {
+	server := suite.newTestServer("charthistory", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:           true,
+		AllowForceOverwrite: true,
+		ChartHistory:        true,
+	})
+	history := func() []repo.ChartHistoryEvent {
+		res := suite.serve(server, "GET", "/api/charts/mychart/history", nil, withHeader("X-Request-Id", "GET-"))
+		if res.Code != 200 {
+			return nil
+		}
+		history, err := repo.ChartHistoryFromContent(res.Body.Bytes())
+		suite.Nil(err, "no error parsing history")
+		return history
+	}
 
-	apiPrefix := pathutil.Join("/api", "a")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/history", nil, withHeader("X-Request-Id", "GET-")).Code, "404 GET history of unknown chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", suite.readFile(testTarballPath), withHeader("X-Request-Id", "POST-alice"), withBasicAuth("alice", "password")).Code, "201 POST chart")
+	suite.Eventually(func() bool { return len(history()) == 1 }, 5*time.Second, 10*time.Millisecond, "upload recorded")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts?force", suite.readFile(testTarballPath), withHeader("X-Request-Id", "POST-bob"), withBasicAuth("bob", "password")).Code, "201 POST chart with ?force")
+	suite.Eventually(func() bool { return len(history()) == 2 }, 5*time.Second, 10*time.Millisecond, "overwrite recorded")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil, withHeader("X-Request-Id", "DELETE-alice"), withBasicAuth("alice", "password")).Code, "200 DELETE chart")
+	suite.Eventually(func() bool { return len(history()) == 3 }, 5*time.Second, 10*time.Millisecond, "deletion recorded")
+
+	events := history()
+	suite.Equal(repo.ChartVersionUploaded, events[0].Action)
+	suite.Equal("0.1.0", events[0].Version)
+	suite.Equal("alice", events[0].User, "uploader recorded")
+	suite.Equal("POST-alice", events[0].RequestID, "request id recorded")
+	suite.NotEmpty(events[0].Digest, "digest recorded")
+	suite.Equal(repo.ChartVersionOverwritten, events[1].Action)
+	suite.Equal("bob", events[1].User, "user overwriting recorded")
+	suite.Equal(repo.ChartVersionDeleted, events[2].Action)
+	suite.Equal("alice", events[2].User, "user deleting recorded")
+	suite.False(events[2].Time.Before(events[0].Time), "events in order")
+}
+
+func (suite *MultiTenantServerTestSuite) TestAlerts() {
+	logger := suite.Logger
+	log := logger.ContextLoggingFn(&gin.Context{})
+
+	var mutex sync.Mutex
+	var alerts []webhook.Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := webhook.Event{}
+		suite.Nil(json.NewDecoder(r.Body).Decode(&event), "no error decoding event")
+		if event.Alert != nil {
+			mutex.Lock()
+			alerts = append(alerts, event)
+			mutex.Unlock()
+		}
+	}))
+	defer receiver.Close()
+	received := func() []webhook.Event {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]webhook.Event{}, alerts...)
+	}
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(suite.TempDirectory)),
+		AlertErrorRate: 2,
+	})
+	suite.NotNil(err, "error with an error rate above 1")
+
+	dir := pathutil.Join(suite.TempDirectory, "alerts")
+	os.MkdirAll(dir, os.ModePerm)
+	server := suite.newTestServer("alerts", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:          true,
+		WebhookURLs:        []string{receiver.URL},
+		AlertStorageBytes:  1,
+		AlertChartVersions: 1,
+		AlertIndexBytes:    1024 * 1024,
+		AlertInterval:      time.Hour,
+	})
+	chartVersions := func() int {
+		chartVersions, _, ok := server.indexMetrics("org1")
+		suite.True(ok, "index of repo cached")
+		return chartVersions
+	}
+
+	suite.Equal(404, suite.serve(server, "GET", "/api/org1/charts/mychart", nil).Code, "404 GET chart of empty repo")
+	server.checkAlerts(log)
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", suite.readFile(testTarballPath)).Code, "201 POST chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", suite.readFile(testTarballPathV2)).Code, "201 POST chart")
+	suite.Eventually(func() bool { return chartVersions() == 2 }, 5*time.Second, 10*time.Millisecond, "index updated")
+	server.checkAlerts(log)
+	suite.Eventually(func() bool { return len(received()) == 2 }, 5*time.Second, 10*time.Millisecond,
+		"alerts sent for thresholds crossed")
+	server.checkAlerts(log)
+
+	events := received()
+	suite.Len(events, 2, "alerts sent once while firing")
+	suite.Equal(webhook.AlertFiringEvent, events[0].Type)
+	suite.Equal("org1", events[0].Repo)
+	suite.Equal("chart_versions", events[0].Alert.Metric)
+	suite.Equal(float64(2), events[0].Alert.Value)
+	suite.Equal(float64(1), events[0].Alert.Threshold)
+	suite.Equal(webhook.AlertFiringEvent, events[1].Type)
+	suite.Equal("storage_bytes", events[1].Alert.Metric)
+	suite.True(events[1].Alert.Value > 1, "size of the charts")
+
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.1.0", nil).Code, "200 DELETE chart")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/0.2.0", nil).Code, "200 DELETE chart")
+	suite.Eventually(func() bool { return chartVersions() == 0 }, 5*time.Second, 10*time.Millisecond, "index updated")
+	server.checkAlerts(log)
+	suite.Eventually(func() bool { return len(received()) == 4 }, 5*time.Second, 10*time.Millisecond,
+		"alerts resolved")
+	events = received()
+	suite.Equal(webhook.AlertResolvedEvent, events[2].Type)
+	suite.Equal("chart_versions", events[2].Alert.Metric)
+	suite.Equal(float64(0), events[2].Alert.Value)
+	suite.Equal(webhook.AlertResolvedEvent, events[3].Type)
+	suite.Equal("storage_bytes", events[3].Alert.Metric)
+
+	server.TenantCacheKeyLock.Lock()
+	delete(server.InternalCacheStore, "org1")
+	server.TenantCacheKeyLock.Unlock()
+	server.checkAlerts(log)
+	_, _, ok := server.indexMetrics("org1")
+	suite.False(ok, "evicted index not built again for alerts")
+	suite.Len(received(), 4, "no alert without a cached index")
+
+	dir = pathutil.Join(suite.TempDirectory, "alerts-errors")
+	os.MkdirAll(dir, os.ModePerm)
+	server = suite.newTestServer("alerts", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:      true,
+		WebhookURLs:    []string{receiver.URL},
+		AlertErrorRate: 0.5,
+		AlertInterval:  time.Hour,
+	})
+	server.setMaintenance(true, "")
+
+	for i := 0; i < minAlertRequests-1; i++ {
+		suite.Equal(503, suite.serve(server, "POST", "/api/org2/charts", suite.readFile(testTarballPath)).Code, "503 POST chart in maintenance")
+	}
+	server.checkAlerts(log)
+	for i := 0; i < minAlertRequests; i++ {
+		suite.Equal(503, suite.serve(server, "POST", "/api/org2/charts", suite.readFile(testTarballPath)).Code, "503 POST chart in maintenance")
+	}
+	suite.Equal(200, suite.serve(server, "GET", "/api/org3/charts", nil).Code, "200 GET charts")
+	server.checkAlerts(log)
+	suite.Eventually(func() bool { return len(received()) == 5 }, 5*time.Second, 10*time.Millisecond,
+		"alert sent for error rate")
+	events = received()
+	suite.Equal(webhook.AlertFiringEvent, events[4].Type)
+	suite.Equal("org2", events[4].Repo, "error rate of the repo failing only, once enough requests are counted")
+	suite.Equal("error_rate", events[4].Alert.Metric)
+	suite.Equal(float64(1), events[4].Alert.Value)
+	suite.Equal(0.5, events[4].Alert.Threshold)
+}
+
+func (suite *MultiTenantServerTestSuite) TestIngestion() {
+	logger := suite.Logger
+
+	chartContent, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error opening test tarball")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error opening test provfile")
+	var archive bytes.Buffer
+	gzipWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range map[string]string{
+		"org1-charts-abc123/charts/builtchart/Chart.yaml":         "apiVersion: v2\nname: builtchart\nversion: 1.0.0\n",
+		"org1-charts-abc123/charts/builtchart/templates/pod.yaml": "kind: Pod\n",
+	} {
+		tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+
+	var mutex sync.Mutex
+	downloads := map[string]int{}
+	var github *httptest.Server
+	github = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		downloads[r.URL.Path]++
+		mutex.Unlock()
+		switch r.URL.Path {
+		case "/repos/org1/charts/releases":
+			fmt.Fprintf(w, `[
+				{"tag_name": "v0.2.0", "assets": [{"name": "mychart-0.2.0.tgz", "url": "%[1]s/assets/2"}]},
+				{"tag_name": "v0.1.0", "assets": [
+					{"name": "mychart-0.1.0.tgz", "url": "%[1]s/assets/1"},
+					{"name": "mychart-0.1.0.tgz.prov", "url": "%[1]s/assets/1.prov"},
+					{"name": "checksums.txt", "url": "%[1]s/assets/checksums"}
+				]}
+			]`, github.URL)
+		case "/assets/1":
+			w.Write(chartContent)
+		case "/assets/1.prov":
+			w.Write(provContent)
+		case "/repos/org1/charts/tarball/v0.1.0", "/repos/org1/charts/tarball/v0.2.0":
+			w.Write(archive.Bytes())
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer github.Close()
+
+	dir := pathutil.Join(suite.TempDirectory, "ingestion")
+	os.MkdirAll(pathutil.Join(dir, "org1"), os.ModePerm)
+	// already in the repo, so never downloaded
+	existingContent, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "org1", "mychart-0.2.0.tgz"), existingContent, 0644))
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		Ingestion: &ingestion.Config{Sources: []*ingestion.Source{
+			{GitHub: "org1/charts", URL: "ftp://example.com"},
+		}},
+	})
+	suite.NotNil(err, "error with invalid api url")
+
+	locker := &testLocker{held: map[string]bool{}}
+	server := suite.newTestServer("ingestion", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI: true,
+		Locker:    locker,
+		Ingestion: &ingestion.Config{
+			Interval: replication.Duration{Duration: time.Hour},
+			Sources: []*ingestion.Source{
+				{GitHub: "org1/charts", URL: github.URL, Repo: "org1", Path: "charts"},
+			},
+		},
+	})
+	chartNames := func() []string {
+		res := suite.serve(server, "GET", "/org1/index.yaml", nil)
+		index := &helm_repo.IndexFile{}
+		suite.Nil(yaml.Unmarshal(res.Body.Bytes(), index), "no error parsing index")
+		names := []string{}
+		for name, chartVersions := range index.Entries {
+			for _, chartVersion := range chartVersions {
+				names = append(names, name+"-"+chartVersion.Version)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	suite.Eventually(func() bool { return len(chartNames()) == 3 }, 5*time.Second, 10*time.Millisecond,
+		"charts of releases ingested")
+	suite.Equal([]string{"builtchart-1.0.0", "mychart-0.1.0", "mychart-0.2.0"}, chartNames())
+	_, err = os.Stat(pathutil.Join(dir, "org1", "mychart-0.1.0.tgz.prov"))
+	suite.Nil(err, "provenance file ingested")
+	content, err := ioutil.ReadFile(pathutil.Join(dir, "org1", "mychart-0.2.0.tgz"))
+	suite.Nil(err, "no error reading chart")
+	suite.Equal(existingContent, content, "chart in the repo kept")
+	suite.Contains(locker.names(), "repo/org1", "charts ingested under the lock of the repo")
+
+	added, err := server.ingest(logger.ContextLoggingFn(&gin.Context{}), server.Ingesters[0])
+	suite.Nil(err, "no error ingesting again")
+	suite.Zero(added, "nothing added again")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	suite.Zero(downloads["/assets/2"], "chart in the repo not downloaded")
+	suite.Zero(downloads["/assets/checksums"], "other assets not downloaded")
+	suite.Equal(1, downloads["/assets/1"], "releases ingested not checked again")
+}
+
+func (suite *MultiTenantServerTestSuite) TestExport() {
+	logger := suite.Logger
+
+	dir := pathutil.Join(suite.TempDirectory, "export")
+	os.MkdirAll(pathutil.Join(dir, "org1"), os.ModePerm)
+	os.MkdirAll(pathutil.Join(dir, "org2"), os.ModePerm)
+	suite.copyTestFilesTo(pathutil.Join(dir, "org1"))
+	content, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error opening test tarball")
+	suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "org2", "mychart-0.2.0.tgz"), content, 0644))
+
+	out := pathutil.Join(suite.TempDirectory, "export-out")
+	suite.LastExitCode = -1
+	NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:          logger,
+		Router:          cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, Depth: 1}),
+		StorageBackend:  storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		ExportDirectory: out,
+	})
+	suite.Equal(0, suite.LastExitCode, "export exits 0")
+
+	index, err := ioutil.ReadFile(pathutil.Join(out, "org1", "index.yaml"))
+	suite.Nil(err, "index of org1 exported")
+	suite.Contains(string(index), "- charts/mychart-0.1.0.tgz", "relative chart urls in the index")
+	_, err = os.Stat(pathutil.Join(out, "org1", "charts", "mychart-0.1.0.tgz"))
+	suite.Nil(err, "package of org1 exported")
+	_, err = os.Stat(pathutil.Join(out, "org1", "charts", "mychart-0.1.0.tgz.prov"))
+	suite.Nil(err, "provenance file of org1 exported")
+
+	index, err = ioutil.ReadFile(pathutil.Join(out, "org2", "index.yaml"))
+	suite.Nil(err, "index of org2 exported")
+	suite.Contains(string(index), "- charts/mychart-0.2.0.tgz")
+	exported, err := ioutil.ReadFile(pathutil.Join(out, "org2", "charts", "mychart-0.2.0.tgz"))
+	suite.Nil(err, "package of org2 exported")
+	suite.Equal(content, exported, "package exported as stored")
+	_, err = os.Stat(pathutil.Join(out, "org2", "charts", "mychart-0.2.0.tgz.prov"))
+	suite.True(os.IsNotExist(err), "no provenance file without one in storage")
+
+	// the export directory cannot be created under a file
+	NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:          logger,
+		Router:          cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:  storage.Backend(storage.NewLocalFilesystemBackend(pathutil.Join(dir, "org1"))),
+		ExportDirectory: pathutil.Join(out, "org1", "index.yaml"),
+	})
+	suite.Equal(1, suite.LastExitCode, "export exits 1 on error")
+	suite.Contains(suite.LastPrinted, "Could not export repo")
+}
+
+func (suite *MultiTenantServerTestSuite) TestLanding() {
+	logger := suite.Logger
+
+	dir := pathutil.Join(suite.TempDirectory, "landing")
+	os.MkdirAll(dir, os.ModePerm)
+	tenantConfig := &tenant.Config{Tenants: map[string]*tenant.Overrides{
+		"private": {Credentials: map[string]string{"user": "userpass"}},
+		"public":  {},
+	}}
+	newServer := func(options MultiTenantServerOptions) *MultiTenantServer {
+		options.Logger = logger
+		options.Router = cm_router.NewRouter(cm_router.RouterOptions{
+			Logger:       logger,
+			Depth:        1,
+			TenantConfig: tenantConfig,
+			ContextPath:  "/charts",
+		})
+		options.StorageBackend = storage.Backend(storage.NewLocalFilesystemBackend(dir))
+		options.EnableAPI = true
+		options.TenantConfig = tenantConfig
+		options.Version = "v1.2.3"
+		server, err := NewMultiTenantServer(options)
+		suite.Nil(err, "no error creating server")
+		return server
+	}
+
+	server := newServer(MultiTenantServerOptions{})
+	res := suite.serve(server, "GET", "http://charts.example.com/charts/", nil)
+	suite.Equal(200, res.Code, "200 GET /")
+	suite.Contains(res.Body.String(), "Welcome to ChartMuseum!", "welcome page by default")
+
+	res = suite.serve(server, "GET", "http://charts.example.com/charts/", nil, withHeader("Accept", "application/json"))
+	suite.Equal(200, res.Code, "200 GET / accepting json")
+	var document landingDocument
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &document), "no error decoding landing document")
+	suite.Equal("v1.2.3", document.Version)
+	suite.Equal(1, document.Depth)
+	suite.True(document.Features["api"], "api enabled")
+	suite.False(document.Features["oci"], "oci disabled")
+	suite.Equal([]landingRepo{{
+		Name:     "public",
+		URL:      "http://charts.example.com/charts/public",
+		IndexURL: "http://charts.example.com/charts/public/index.yaml",
+	}}, document.Repos, "repos of other tenants not listed")
+
+	res = suite.serve(server, "GET", "http://charts.example.com/charts/", nil, withHeader("Accept", "application/json"), withBasicAuth("user", "userpass"))
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &document), "no error decoding landing document")
+	suite.Len(document.Repos, 2, "repos the user may pull from listed")
+	suite.Equal("private", document.Repos[0].Name)
+
+	server = newServer(MultiTenantServerOptions{LandingJSON: true})
+	res = suite.serve(server, "GET", "http://charts.example.com/charts/", nil)
+	suite.Equal("application/json; charset=utf-8", res.Header().Get("Content-Type"), "json without accept with --landing-json")
+
+	templatePath := pathutil.Join(suite.TempDirectory, "landing.html")
+	suite.Nil(ioutil.WriteFile(templatePath, []byte(`<h1>Charts {{.Version}}</h1>{{range .Repos}}<a href="{{.URL}}">{{.Name}}</a>{{end}}`), 0644))
+	server = newServer(MultiTenantServerOptions{LandingTemplate: templatePath})
+	res = suite.serve(server, "GET", "http://charts.example.com/charts/", nil, withHeader("Accept", "text/html"))
+	suite.Equal(200, res.Code, "200 GET / with template")
+	suite.Equal(`<h1>Charts v1.2.3</h1><a href="http://charts.example.com/charts/public">public</a>`, res.Body.String())
+	res = suite.serve(server, "GET", "http://charts.example.com/charts/", nil, withHeader("Accept", "application/json"))
+	suite.Contains(res.Body.String(), `"indexURL"`, "json still served to clients accepting it")
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:          logger,
+		Router:          cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:  storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		LandingTemplate: pathutil.Join(dir, "missing.html"),
+	})
+	suite.NotNil(err, "error with missing landing template")
+}
+
+func (suite *MultiTenantServerTestSuite) TestVersion() {
+	var buffer bytes.Buffer
+	res := suite.doRequest("depth1", "GET", "/api/version", nil, "", &buffer)
+	suite.Equal(200, res.Status(), "200 GET /api/version")
+	var document versionDocument
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &document), "no error decoding version document")
+	suite.Equal(suite.Depth1Server.Version, document.Version)
+	suite.True(document.Options.Multitenant, "multitenant with depth 1")
+	suite.Equal(1, document.Options.Depth)
+	suite.True(document.Options.APIEnabled, "api enabled")
+	suite.Equal("never", document.Options.Overwrite, "no overwrite by default")
+	suite.True(document.Features["delete"], "delete enabled")
+
+	buffer.Reset()
+	res = suite.doRequest("overwrite", "GET", "/api/version", nil, "", &buffer)
+	suite.Equal(200, res.Status(), "200 GET /api/version")
+	suite.Nil(json.Unmarshal(buffer.Bytes(), &document), "no error decoding version document")
+	suite.False(document.Options.Multitenant, "single tenant with depth 0")
+	suite.Equal("always", document.Options.Overwrite, "overwrite with --allow-overwrite")
+	suite.True(document.Features["overwrite"])
+
+	res = suite.doRequest("disabled", "GET", "/api/version", nil, "")
+	suite.Equal(404, res.Status(), "404 GET /api/version with the api disabled")
+}
+
+func (suite *MultiTenantServerTestSuite) TestPagedListing() {
+	keys := []string{
+		"prefix/.trash/org1/mychart-0.1.0.tgz",
+		"prefix/index-cache.yaml",
+		"prefix/org1/README.md",
+		"prefix/org1/mychart-0.1.0.tgz",
+		"prefix/org1/mychart-0.2.0.tgz",
+		"prefix/org2/team1/mychart-0.1.0.tgz",
+		"prefix/org3/index-cache.yaml",
+	}
+	var mutex sync.Mutex
+	var listings []string
+	// lists a single object or directory per page, as ListObjectsV2 with the / delimiter does
+	s3Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/charts" || query.Get("list-type") != "2" {
+			w.WriteHeader(404)
+			return
+		}
+		mutex.Lock()
+		listings = append(listings, query.Get("prefix")+" "+query.Get("delimiter"))
+		mutex.Unlock()
+		prefix := query.Get("prefix")
+		var entries []string
+		seen := map[string]bool{}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			entry := key
+			if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+				entry = key[:len(prefix)+i+1]
+			}
+			if !seen[entry] {
+				seen[entry] = true
+				entries = append(entries, entry)
+			}
+		}
+		start, _ := strconv.Atoi(query.Get("continuation-token"))
+		result := `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>charts</Name>`
+		if start < len(entries) {
+			if strings.HasSuffix(entries[start], "/") {
+				result += "<CommonPrefixes><Prefix>" + entries[start] + "</Prefix></CommonPrefixes>"
+			} else {
+				result += "<Contents><Key>" + entries[start] + "</Key><LastModified>2023-01-01T00:00:00.000Z</LastModified></Contents>"
+			}
+		}
+		if start+1 < len(entries) {
+			result += fmt.Sprintf("<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", start+1)
+		} else {
+			result += "<IsTruncated>false</IsTruncated>"
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, result+"</ListBucketResult>")
+	}))
+	defer s3Server.Close()
+
+	newServer := func(depth int, depthDynamic bool) *MultiTenantServer {
+		backend := storage.NewAmazonS3BackendWithCredentials("charts", "prefix", "us-east-1", s3Server.URL, "",
+			credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""))
+		server := suite.newTestServer("", cm_router.RouterOptions{Depth: depth, DepthDynamic: depthDynamic}, MultiTenantServerOptions{
+			StorageBackend: storage.Backend(backend),
+		})
+		return server
+	}
+
+	server := newServer(1, false)
+	mutex.Lock()
+	listings = nil
+	mutex.Unlock()
+	repos, err := server.discoverTenants()
+	suite.Nil(err, "no error discovering tenants")
+	suite.Equal([]string{"org1", "org3"}, repos, "repos with chart packages or a statefile at depth 1")
+	mutex.Lock()
+	for _, listing := range listings {
+		suite.True(strings.HasSuffix(listing, " /"), "listed with the / delimiter: %s", listing)
+	}
+	mutex.Unlock()
 
+	objects, err := server.fetchChartsInStorage(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), "org1")
+	suite.Nil(err, "no error listing charts")
+	suite.Len(objects, 2, "chart packages of the repo, over several pages")
+	suite.Equal("mychart-0.1.0.tgz", objects[0].Path, "paths relative to the repo")
+
+	objects, err = server.fetchChartsInStorage(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), "")
+	suite.Nil(err, "no error listing charts")
+	suite.Len(objects, 0, "charts of nested repos not listed at the root")
+
+	server = newServer(0, true)
+	repos, err = server.discoverTenants()
+	suite.Nil(err, "no error discovering tenants")
+	suite.Equal([]string{"org1", "org2/team1", "org3"}, repos, "repos at any depth, hidden ones excluded")
+}
+
+func (suite *MultiTenantServerTestSuite) TestCacheEviction() {
+	logger := suite.Logger
+
+	dir := pathutil.Join(suite.TempDirectory, "cacheeviction")
 	content, err := ioutil.ReadFile(testTarballPath)
-	suite.Nil(err, "error opening test tarball")
+	suite.Nil(err, "no error opening test tarball")
+	repos := []string{"org1/repo1", "org1/repo2", "org2/repo1"}
+	for _, repo := range repos {
+		os.MkdirAll(pathutil.Join(dir, repo), os.ModePerm)
+		suite.Nil(ioutil.WriteFile(pathutil.Join(dir, repo, "mychart-0.1.0.tgz"), content, 0644))
+	}
 
-	body := bytes.NewBuffer(content)
-	res := suite.doRequest("depth1", "POST", fmt.Sprintf("%s/charts", apiPrefix), body, "")
-	suite.Equal(201, res.Status(), fmt.Sprintf("201 post %s/charts", apiPrefix))
+	log := logger.ContextLoggingFn(&gin.Context{})
+
+	for _, options := range []MultiTenantServerOptions{
+		{},
+		{StaleWhileRevalidate: true},
+		// not ticking during the test, evicted indexes being built on their next lookup
+		{CacheInterval: time.Hour},
+	} {
+		options.CacheTTL = time.Hour
+		options.CacheMaxEntries = 2
+		server := suite.newTestServer("cacheeviction", cm_router.RouterOptions{Depth: 2}, options)
+		mode := fmt.Sprintf("stale-while-revalidate=%t cache-interval=%s", options.StaleWhileRevalidate, options.CacheInterval)
+
+		for _, repo := range repos {
+			index, httpErr := server.getIndexFile(context.Background(), log, repo)
+			suite.Nil(httpErr, "no error getting index of %s with %s", repo, mode)
+			suite.Len(index.Entries["mychart"], 1, "index of %s built with %s", repo, mode)
+			time.Sleep(time.Millisecond)
+		}
+		suite.Len(server.InternalCacheStore, 2, "cache store bounded by max entries with %s", mode)
+		suite.Len(server.Tenants, 2, "tenants bounded by max entries with %s", mode)
+		suite.NotContains(server.InternalCacheStore, "org1/repo1", "least recently used index evicted with %s", mode)
+		suite.Nil(server.getTenant("org1/repo1"), "tenant of evicted index evicted with %s", mode)
+
+		index, httpErr := server.getIndexFile(context.Background(), log, "org1/repo1")
+		suite.Nil(httpErr, "no error getting index of evicted repo with %s", mode)
+		suite.Len(index.Entries["mychart"], 1, "index of evicted repo built again with %s", mode)
+		suite.NotContains(server.InternalCacheStore, "org1/repo2", "next least recently used index evicted with %s", mode)
+
+		server.TenantCacheKeyLock.Lock()
+		server.evictCacheEntries(log, time.Now().Add(30*time.Minute))
+		suite.Len(server.InternalCacheStore, 2, "no index unused for the ttl with %s", mode)
+		server.evictCacheEntries(log, time.Now().Add(2*time.Hour))
+		suite.Empty(server.InternalCacheStore, "indexes unused for the ttl evicted with %s", mode)
+		suite.Empty(server.Tenants, "tenants unused for the ttl evicted with %s", mode)
+		server.TenantCacheKeyLock.Unlock()
+
+		index, httpErr = server.getIndexFile(context.Background(), log, "org2/repo1")
+		suite.Nil(httpErr, "no error getting index of repo unused for the ttl with %s", mode)
+		suite.Len(index.Entries["mychart"], 1, "index of repo unused for the ttl built again with %s", mode)
+	}
+}
 
-	otherChart, err := ioutil.ReadFile(testTarballPathV2)
-	suite.Nil(err, "error opening test tarball")
+func (suite *MultiTenantServerTestSuite) TestProtectedCharts() {
+	logger := suite.Logger
 
-	body = bytes.NewBuffer(otherChart)
-	res = suite.doRequest("depth1", "POST", fmt.Sprintf("%s/charts", apiPrefix), body, "")
-	suite.Equal(201, res.Status(), fmt.Sprintf("201 POST %s/charts", apiPrefix))
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:          logger,
+		Router:          cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:  suite.Depth0Server.StorageBackend,
+		ProtectedCharts: []string{"[a-z"},
+	})
+	suite.NotNil(err, "error with invalid protected chart")
 
-	// GET /a/index.yaml to regenerate index (and metrics)
-	res = suite.doRequest("depth1", "GET", "/a/index.yaml", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /a/index.yaml")
+	server := suite.newTestServer("protectedcharts", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:       true,
+		AllowOverwrite:  true,
+		ChartAdmins:     []string{"admin"},
+		ProtectedCharts: []string{"my.*"},
+	})
 
-	// GET /b/index.yaml to regenerate b index (and metrics)
-	res = suite.doRequest("depth1", "GET", "/b/index.yaml", nil, "")
-	suite.Equal(200, res.Status(), "200 GET /b/index.yaml")
+	// no authorizer is set, the users are the ones of the basic auth headers
 
-	// Get metrics
-	buffer := bytes.NewBufferString("")
-	res = suite.doRequest("depth1", "GET", "/metrics", nil, "", buffer)
-	suite.Equal(200, res.Status(), "200 GET /metrics")
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	provContent, err := ioutil.ReadFile(testProvfilePath)
+	suite.Nil(err, "no error reading test provenance file")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withBasicAuth("alice", "password")).Code, "201 POST new version of protected chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/prov", bytes.NewReader(provContent), withBasicAuth("alice", "password")).Code, "201 POST new provenance file of protected chart")
+
+	res := suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withBasicAuth("alice", "password"))
+	suite.Equal(403, res.Code, "403 POST overwriting protected chart")
+	var response map[string]interface{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &response), "error is json")
+	suite.Equal(cm_router.ErrorCodeForbidden, response["code"], "forbidden error code")
+	suite.Equal(403, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withBasicAuth("alice", "password"), withHeader(ForceProtectedHeader, "true")).Code, "403 POST overwriting protected chart forced by another user")
+	suite.Equal(403, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withBasicAuth("admin", "password")).Code, "403 POST overwriting protected chart not forced by admin")
+	suite.Equal(403, suite.serve(server, "POST", "/api/prov", bytes.NewReader(provContent), withBasicAuth("alice", "password")).Code, "403 POST overwriting provenance file of protected chart")
+	suite.Equal(403, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil, withBasicAuth("alice", "password"), withHeader(ForceProtectedHeader, "true")).Code, "403 DELETE protected chart forced by another user")
+	suite.Equal(403, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil, withBasicAuth("admin", "password")).Code, "403 DELETE protected chart not forced by admin")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(content), withBasicAuth("admin", "password"), withHeader(ForceProtectedHeader, "true")).Code, "201 POST overwriting protected chart forced by admin")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/charts/mychart/0.1.0", nil, withBasicAuth("admin", "password"), withHeader(ForceProtectedHeader, "true")).Code, "200 DELETE protected chart forced by admin")
+
+	// OCI pushes follow the same rules
+	server = suite.newTestServer("protectedcharts-oci", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:        true,
+		EnableOCI:        true,
+		AllowOverwrite:   true,
+		RestrictToOwners: true,
+		ChartAdmins:      []string{"admin"},
+		ProtectedCharts:  []string{"my.*"},
+	})
+	config := []byte(`{"name":"mychart","version":"0.1.0","apiVersion":"v2"}`)
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		Config:        ociDescriptor{MediaType: helmConfigMediaType, Digest: ociDigest(config), Size: int64(len(config))},
+		Layers:        []ociDescriptor{{MediaType: helmChartContentMediaType, Digest: ociDigest(content), Size: int64(len(content))}},
+	})
+	suite.Nil(err, "no error encoding manifest")
+	push := func(user string, options ...requestOption) int {
+		// the blobs of a manifest are uploaded again, they are removed once it is pushed
+		for _, blob := range [][]byte{config, content} {
+			suite.Equal(201, suite.serve(server, "POST", "/v2/org1/mychart/blobs/uploads/?digest="+ociDigest(blob), bytes.NewReader(blob), withBasicAuth("alice", "password")).Code, "201 POST blob")
+		}
+		return suite.serve(server, "PUT", "/v2/org1/mychart/manifests/0.1.0", bytes.NewReader(manifest), append(options, withBasicAuth(user, "password"))...).Code
+	}
+	suite.Equal(201, push("alice"), "201 PUT manifest of new version of protected chart")
+	suite.Equal(403, push("alice"), "403 PUT manifest overwriting protected chart")
+	suite.Equal(403, push("admin"), "403 PUT manifest overwriting protected chart not forced by admin")
+	suite.Equal(403, push("bob", withHeader(ForceProtectedHeader, "true")), "403 PUT manifest of chart owned by another user")
+	suite.Equal(412, push("admin", withHeader(ForceProtectedHeader, "true"), withHeader("If-Match", ociDigest([]byte("other")))), "412 PUT manifest not matching the expected digest")
+	suite.Equal(201, push("admin", withHeader(ForceProtectedHeader, "true"), withHeader("If-Match", ociDigest(content))), "201 PUT manifest overwriting protected chart forced by admin")
+}
 
-	metrics := buffer.String()
-	//fmt.Print(metrics) // observe the metric output
+func (suite *MultiTenantServerTestSuite) TestImport() {
+	logger := suite.Logger
+	log := logger.ContextLoggingFn(&gin.Context{})
+
+	newServer := func(name string, depth int) *MultiTenantServer {
+		dir := pathutil.Join(suite.TempDirectory, name)
+		os.MkdirAll(dir, os.ModePerm)
+		server := suite.newTestServer("", cm_router.RouterOptions{Depth: depth}, MultiTenantServerOptions{
+			StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+			EnableAPI:      true,
+		})
+		return server
+	}
+	lines := func(res *httptest.ResponseRecorder) ([]importProgress, importSummary) {
+		var progress []importProgress
+		var summary importSummary
+		decoder := json.NewDecoder(res.Body)
+		for decoder.More() {
+			var line struct {
+				importProgress
+				importSummary
+			}
+			suite.Nil(decoder.Decode(&line), "no error decoding progress")
+			if line.Filename != "" {
+				progress = append(progress, line.importProgress)
+			} else {
+				summary = line.importSummary
+			}
+		}
+		return progress, summary
+	}
 
-	// Ensure that we have the Gauges as documented
-	suite.True(strings.Contains(metrics, "# TYPE chartmuseum_chart_versions_served_total gauge"))
-	suite.True(strings.Contains(metrics, "# TYPE chartmuseum_charts_served_total gauge"))
+	source := newServer("import-source", 0)
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", suite.readFile(testTarballPath)).Code, "201 POST chart to source")
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", suite.readFile(testTarballPathV2)).Code, "201 POST chart to source")
+	suite.Equal(201, suite.serve(source, "POST", "/api/charts", suite.readFile(otherTestTarballPath)).Code, "201 POST chart to source")
+	suite.Equal(201, suite.serve(source, "POST", "/api/prov", suite.readFile(otherTestProvfilePath)).Code, "201 POST prov file to source")
+	suite.Eventually(func() bool {
+		index, _ := source.getIndexFile(context.Background(), log, "")
+		return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "otherchart", Version: "0.1.0"}})
+	}, 5*time.Second, 10*time.Millisecond, "chart in source index")
+	sourceServer := httptest.NewServer(source.Router)
+	defer sourceServer.Close()
+
+	target := newServer("import-target", 1)
+	targetDir := pathutil.Join(suite.TempDirectory, "import-target")
+	suite.Equal(201, suite.serve(target, "POST", "/api/org/charts", suite.readFile(testTarballPath)).Code, "201 POST chart to target")
+
+	importURL := "/api/admin/import?repo=org&url=" + url.QueryEscape(sourceServer.URL)
+	suite.Equal(400, suite.serve(target, "POST", "/api/admin/import?repo=org", nil).Code, "400 POST import without url")
+	suite.Equal(400, suite.serve(target, "POST", "/api/admin/import?url=ftp://example.com", nil).Code, "400 POST import of ftp url")
+	suite.Equal(400, suite.serve(target, "POST", importURL+"&include=[", nil).Code, "400 POST import with invalid filter")
+	suite.Equal(400, suite.serve(target, "POST", "/api/admin/import?repo=org/team&url="+url.QueryEscape(sourceServer.URL), nil).Code,
+		"400 POST import into repo deeper than depth")
+	suite.Equal(502, suite.serve(target, "POST", "/api/admin/import?repo=org&url=http://127.0.0.1:1", nil).Code,
+		"502 POST import of unreachable repo")
+
+	res := suite.serve(target, "POST", importURL+"&include=mychart", nil)
+	suite.Equal(200, res.Code, "200 POST import")
+	suite.Equal("application/x-ndjson", res.Header().Get("Content-Type"))
+	progress, summary := lines(res)
+	suite.Len(progress, 2, "progress of included charts")
+	suite.Equal(importProgress{Filename: "mychart-0.2.0.tgz", Status: importStatusImported, Done: 1, Total: 2}, progress[0])
+	suite.Equal(importProgress{Filename: "mychart-0.1.0.tgz", Status: importStatusSkipped, Done: 2, Total: 2}, progress[1],
+		"chart already in repo skipped")
+	suite.Equal(importSummary{Source: sourceServer.URL, Repo: "org", Imported: 1, Skipped: 1}, summary)
+	suite.Eventually(func() bool {
+		index, _ := target.getIndexFile(context.Background(), log, "org")
+		return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: "0.2.0"}}) &&
+			index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: "0.1.0"}})
+	}, 5*time.Second, 10*time.Millisecond, "imported chart in index")
+
+	res = suite.serve(target, "POST", importURL+"&exclude=mychart", nil)
+	suite.Equal(200, res.Code, "200 POST import")
+	_, summary = lines(res)
+	suite.Equal(1, summary.Imported, "charts not excluded imported")
+	_, err := os.Stat(pathutil.Join(targetDir, "org", "otherchart-0.1.0.tgz.prov"))
+	suite.Nil(err, "provenance file imported")
+
+	res = suite.serve(target, "POST", importURL, nil)
+	suite.Equal(200, res.Code, "200 POST import again")
+	_, summary = lines(res)
+	suite.Equal(importSummary{Source: sourceServer.URL, Repo: "org", Skipped: 3}, summary, "nothing imported twice")
+}
 
-	suite.True(strings.Contains(metrics, "chartmuseum_charts_served_total{repo=\"a\"} 1"))
-	suite.True(strings.Contains(metrics, "chartmuseum_chart_versions_served_total{repo=\"a\"} 2"))
+func (suite *MultiTenantServerTestSuite) TestResponseCache() {
+	logger := suite.Logger
+	server := suite.newTestServer("responsecache", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:   true,
+		APICacheTTL: time.Minute,
+	})
+	log := logger.ContextLoggingFn(&gin.Context{})
 
-	// Ensure that the b repo has no charts
-	suite.True(strings.Contains(metrics, "chartmuseum_chart_versions_served_total{repo=\"b\"} 0"))
+	waitForEntry := func(name string, version string) {
+		suite.Eventually(func() bool {
+			index, _ := server.getIndexFile(context.Background(), log, "")
+			return index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}})
+		}, 5*time.Second, 10*time.Millisecond, "chart in index")
+	}
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", suite.readFile(testTarballPath)).Code, "201 POST chart")
+	waitForEntry("mychart", "0.1.0")
+
+	res := suite.serve(server, "GET", "/api/charts", nil)
+	suite.Equal(200, res.Code, "200 GET /api/charts")
+	suite.Equal("MISS", res.Header().Get("X-Cache"), "charts not cached yet")
+	body := res.Body.String()
+	res = suite.serve(server, "GET", "/api/charts", nil)
+	suite.Equal(200, res.Code, "200 GET /api/charts")
+	suite.Equal("HIT", res.Header().Get("X-Cache"), "charts cached")
+	suite.Equal("application/json; charset=utf-8", res.Header().Get("Content-Type"))
+	suite.Equal(body, res.Body.String(), "same charts from cache")
+	suite.Equal("MISS", suite.serve(server, "GET", "/api/charts?limit=1", nil).Header().Get("X-Cache"), "pages cached apart")
+
+	res = suite.serve(server, "GET", "/api/charts/mychart", nil)
+	suite.Equal("MISS", res.Header().Get("X-Cache"), "chart not cached yet")
+	res = suite.serve(server, "GET", "/api/charts/mychart", nil)
+	suite.Equal("HIT", res.Header().Get("X-Cache"), "chart cached")
+	var chartVersions []map[string]interface{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &chartVersions), "no error decoding chart")
+	suite.Len(chartVersions, 1)
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/nochart", nil).Code, "404 GET missing chart")
+	suite.Empty(suite.serve(server, "GET", "/api/charts/nochart", nil).Header().Get("X-Cache"), "errors not cached")
+
+	// writes drop the responses cached, and responses of an older index are never served
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", suite.readFile(testTarballPathV2)).Code, "201 POST chart")
+	suite.Equal("MISS", suite.serve(server, "GET", "/api/charts/mychart", nil).Header().Get("X-Cache"), "cache purged on write")
+	waitForEntry("mychart", "0.2.0")
+	res = suite.serve(server, "GET", "/api/charts/mychart", nil)
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &chartVersions), "no error decoding chart")
+	suite.Len(chartVersions, 2, "response of older index not served")
+
+	index, _ := server.getIndexFile(context.Background(), log, "")
+	copied := index.Copy()
+	copied.Raw = append([]byte{}, index.Raw...)
+	_, ok := server.ResponseCache.get("chart\x00\x00mychart", copied)
+	suite.True(ok, "response served for an index with the same content")
+	server.ResponseCache.entries["chart\x00\x00mychart"].expires = time.Now()
+	_, ok = server.ResponseCache.get("chart\x00\x00mychart", index)
+	suite.False(ok, "expired response not served")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartIcons() {
+	logger := suite.Logger
+	dir := pathutil.Join(suite.TempDirectory, "charticons")
+	os.MkdirAll(dir, os.ModePerm)
+	pngIcon := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	var fetched int32
+	iconServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		if r.URL.Path == "/page.html" {
+			w.Write([]byte("<html></html>"))
+			return
+		}
+		w.Write(pngIcon)
+	}))
+	defer iconServer.Close()
+
+	packageChart := func(version string, icon string, files ...*chart.File) []byte {
+		c, err := loader.LoadFile(testTarballPath)
+		suite.Nil(err, "no error loading test chart")
+		c.Metadata.Version = version
+		c.Metadata.Icon = icon
+		c.Files = append(c.Files, files...)
+		filename, err := chartutil.Save(c, suite.T().TempDir())
+		suite.Nil(err, "no error packaging chart")
+		content, err := ioutil.ReadFile(filename)
+		suite.Nil(err, "no error reading chart package")
+		return content
+	}
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		EnableAPI:      true,
+		ChartIcons:     "remote",
+	})
+	suite.NotNil(err, "error with invalid chart icons mode")
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		ChartIcons:     ChartIconsPackage,
+	})
+	suite.NotNil(err, "error with chart icons without the api")
+
+	server := suite.newTestServer("charticons", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		EnableAPI:  true,
+		ChartIcons: ChartIconsProxy,
+	})
+	log := logger.ContextLoggingFn(&gin.Context{})
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(packageChart("1.0.0", iconServer.URL+"/icon.png")), withHost("charts.example.com")).Code, "201 POST chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(packageChart("1.1.0", "icon.png", &chart.File{Name: "icon.png", Data: pngIcon})), withHost("charts.example.com")).Code,
+		"201 POST chart with icon in package")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(packageChart("1.2.0", iconServer.URL+"/page.html")), withHost("charts.example.com")).Code, "201 POST chart")
+	suite.Equal(201, suite.serve(server, "POST", "/api/charts", bytes.NewReader(packageChart("1.3.0", "")), withHost("charts.example.com")).Code, "201 POST chart without icon")
+	suite.Eventually(func() bool {
+		index, _ := server.getIndexFile(context.Background(), log, "")
+		return len(index.Entries["mychart"]) == 4
+	}, 5*time.Second, 10*time.Millisecond, "charts in index")
+
+	res := suite.serve(server, "GET", "/index.yaml", nil, withHost("charts.example.com"))
+	suite.Equal(200, res.Code, "200 GET /index.yaml")
+	indexFile := &helm_repo.IndexFile{}
+	suite.Nil(yaml.Unmarshal(res.Body.Bytes(), indexFile), "no error parsing index")
+	icons := map[string]string{}
+	for _, chartVersion := range indexFile.Entries["mychart"] {
+		icons[chartVersion.Version] = chartVersion.Icon
+	}
+	suite.Equal("http://charts.example.com/api/charts/mychart/1.0.0/icon", icons["1.0.0"], "remote icon rewritten")
+	suite.Equal("http://charts.example.com/api/charts/mychart/1.1.0/icon", icons["1.1.0"], "icon in package rewritten")
+	suite.Empty(icons["1.3.0"], "no icon added")
+
+	res = suite.serve(server, "GET", "/api/charts/mychart/1.1.0/icon", nil, withHost("charts.example.com"))
+	suite.Equal(200, res.Code, "200 GET icon in package")
+	suite.Equal("image/png", res.Header().Get("Content-Type"))
+	suite.Equal(pngIcon, res.Body.Bytes())
+	suite.Equal(int32(0), atomic.LoadInt32(&fetched), "icon in package not fetched")
+
+	for i := 0; i < 2; i++ {
+		res = suite.serve(server, "GET", "/api/charts/mychart/1.0.0/icon", nil, withHost("charts.example.com"))
+		suite.Equal(200, res.Code, "200 GET remote icon")
+		suite.Equal("image/png", res.Header().Get("Content-Type"))
+		suite.Equal(pngIcon, res.Body.Bytes())
+	}
+	suite.Equal(int32(1), atomic.LoadInt32(&fetched), "remote icon fetched once")
+	_, err = os.Stat(pathutil.Join(dir, "mychart-1.0.0.tgz.icon"))
+	suite.Nil(err, "remote icon cached as attachment")
+
+	suite.Equal(502, suite.serve(server, "GET", "/api/charts/mychart/1.2.0/icon", nil, withHost("charts.example.com")).Code, "502 GET remote icon not an image")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/1.3.0/icon", nil, withHost("charts.example.com")).Code, "404 GET icon of chart without icon")
+	suite.Equal(404, suite.serve(server, "GET", "/api/charts/mychart/9.9.9/icon", nil, withHost("charts.example.com")).Code, "404 GET icon of missing chart")
+
+	server.ChartIcons = ChartIconsPackage
+	res = suite.serve(server, "GET", "/api/charts/mychart/1.2.0/icon", nil, withHost("charts.example.com"))
+	suite.Equal(302, res.Code, "302 GET remote icon in package mode")
+	suite.Equal(iconServer.URL+"/page.html", res.Header().Get("Location"))
+}
+
+func (suite *MultiTenantServerTestSuite) TestForceOverwriteUsers() {
+	server := suite.newTestServer("forceoverwriteusers", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI:           true,
+		AllowForceOverwrite: true,
+		ForceOverwriteUsers: []string{"release"},
+	})
+
+	// no authorizer is set, the users are the ones of the basic auth headers
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content), withBasicAuth("alice", "password")).Code, "201 POST new chart version")
+	suite.Equal(409, suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(content), withBasicAuth("alice", "password")).Code, "409 POST existing chart version")
+	res := suite.serve(server, "POST", "/api/org1/charts?force", bytes.NewReader(content), withBasicAuth("alice", "password"))
+	suite.Equal(403, res.Code, "403 POST forced by another user")
+	var response map[string]interface{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &response), "error is json")
+	suite.Equal(cm_router.ErrorCodeForbidden, response["code"], "forbidden error code")
+	suite.Equal(403, suite.serve(server, "POST", "/api/org1/charts?force=true", bytes.NewReader(content)).Code, "403 POST forced without user")
+	suite.Equal(409, suite.serve(server, "POST", "/api/org1/charts?force=false", bytes.NewReader(content), withBasicAuth("alice", "password")).Code, "409 POST not forced")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts?force", bytes.NewReader(content), withBasicAuth("release", "password")).Code, "201 POST forced by release user")
+
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/promote?target=org2", nil, withBasicAuth("alice", "password")).Code, "201 POST promotion")
+	suite.Equal(403, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/promote?target=org2&force", nil, withBasicAuth("alice", "password")).Code,
+		"403 POST promotion forced by another user")
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts/mychart/0.1.0/promote?target=org2&force", nil, withBasicAuth("release", "password")).Code,
+		"201 POST promotion forced by release user")
+
+	server.AllowOverwrite = true
+	suite.Equal(201, suite.serve(server, "POST", "/api/org1/charts?force", bytes.NewReader(content), withBasicAuth("alice", "password")).Code, "201 POST forced when overwrites are allowed anyway")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDownloadLinks() {
+	logger := suite.Logger
+	dir := pathutil.Join(suite.TempDirectory, "downloadlinks")
+	os.MkdirAll(pathutil.Join(dir, "org1"), os.ModePerm)
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test chart")
+	suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "org1", "mychart-0.1.0.tgz"), content, 0644))
+
+	_, err = NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:             logger,
+		Router:             cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend:     storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		DownloadLinkSecret: "secret",
+	})
+	suite.NotNil(err, "error with download links without the api")
+
+	server := suite.newTestServer("downloadlinks", cm_router.RouterOptions{Depth: 1, Username: "user", Password: "pass"}, MultiTenantServerOptions{
+		EnableAPI:          true,
+		DownloadLinkSecret: "secret",
+		DownloadLinkMaxTTL: 24 * time.Hour,
+	})
+
+	suite.Equal(401, suite.serve(server, "GET", "/api/org1/charts/mychart/0.1.0/link", nil, withHost("charts.example.com")).Code, "401 GET link without credentials")
+	suite.Equal(404, suite.serve(server, "GET", "/api/org1/charts/mychart/9.9.9/link", nil, withHost("charts.example.com"), withBasicAuth("user", "pass")).Code, "404 GET link of missing chart version")
+	suite.Equal(400, suite.serve(server, "GET", "/api/org1/charts/mychart/0.1.0/link?ttl=forever", nil, withHost("charts.example.com"), withBasicAuth("user", "pass")).Code, "400 GET link with invalid ttl")
+	suite.Equal(400, suite.serve(server, "GET", "/api/org1/charts/mychart/0.1.0/link?ttl=-1h", nil, withHost("charts.example.com"), withBasicAuth("user", "pass")).Code, "400 GET link with negative ttl")
+	suite.Equal(400, suite.serve(server, "GET", "/api/org1/charts/mychart/0.1.0/link?ttl=48h", nil, withHost("charts.example.com"), withBasicAuth("user", "pass")).Code, "400 GET link with ttl above the max")
+
+	res := suite.serve(server, "GET", "/api/org1/charts/mychart/0.1.0/link?ttl=30m", nil, withHost("charts.example.com"), withBasicAuth("user", "pass"))
+	suite.Equal(200, res.Code, "200 GET link")
+	var link downloadLinkResponse
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &link), "no error parsing link")
+	suite.True(strings.HasPrefix(link.URL, "http://charts.example.com/org1/links/"), "link url %s", link.URL)
+	suite.True(strings.HasSuffix(link.URL, "/mychart-0.1.0.tgz"), "link url %s", link.URL)
+	suite.WithinDuration(time.Now().Add(30*time.Minute), link.Expires, 5*time.Second, "link expires after ttl")
+
+	path := strings.TrimPrefix(link.URL, "http://charts.example.com")
+	res = suite.serve(server, "GET", path, nil, withHost("charts.example.com"))
+	suite.Equal(200, res.Code, "200 GET chart with link, without credentials")
+	suite.Equal(content, res.Body.Bytes(), "chart package downloaded")
+	suite.Equal(401, suite.serve(server, "GET", "/org1/charts/mychart-0.1.0.tgz", nil, withHost("charts.example.com")).Code, "repo still requires credentials")
+
+	token := strings.Split(path, "/")[3]
+	suite.Equal(403, suite.serve(server, "GET", "/org1/links/"+token+"/mychart-0.2.0.tgz", nil, withHost("charts.example.com")).Code, "403 GET other chart with link")
+	suite.Equal(403, suite.serve(server, "GET", "/org2/links/"+token+"/mychart-0.1.0.tgz", nil, withHost("charts.example.com")).Code, "403 GET chart of other repo with link")
+	tampered := signDownloadLink([]byte("other"), downloadLink{Repo: "org1", Filename: "mychart-0.1.0.tgz", Expires: time.Now().Add(time.Hour).Unix()})
+	suite.Equal(403, suite.serve(server, "GET", "/org1/links/"+tampered+"/mychart-0.1.0.tgz", nil, withHost("charts.example.com")).Code, "403 GET link signed with another secret")
+	suite.Equal(403, suite.serve(server, "GET", "/org1/links/invalid/mychart-0.1.0.tgz", nil, withHost("charts.example.com")).Code, "403 GET invalid link")
+
+	_, err = verifyDownloadLink([]byte("secret"), token, time.Now().Add(time.Hour))
+	suite.Equal("download link expired", err.Error(), "link expired after ttl")
+	verified, err := verifyDownloadLink([]byte("secret"), token, time.Now())
+	suite.Nil(err, "no error verifying link")
+	suite.Equal("org1", verified.Repo)
+}
+
+func (suite *MultiTenantServerTestSuite) TestConsistency() {
+	dir := pathutil.Join(suite.TempDirectory, "consistency")
+	os.MkdirAll(pathutil.Join(dir, "org1"), os.ModePerm)
+	copyChart := func(src string, filename string) {
+		content, err := ioutil.ReadFile(src)
+		suite.Nil(err, "no error reading test chart")
+		suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "org1", filename), content, 0644))
+	}
+	copyChart(testTarballPath, "mychart-0.1.0.tgz")
+	copyChart(otherTestTarballPath, "otherchart-0.1.0.tgz")
+
+	server := suite.newTestServer("consistency", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+
+	check := func(method string) consistencyReport {
+		res := suite.serve(server, method, "/api/admin/consistency?repo=org1", nil)
+		suite.Equal(200, res.Code, "200 %s consistency", method)
+		var report consistencyReport
+		suite.Nil(json.Unmarshal(res.Body.Bytes(), &report), "no error parsing report")
+		return report
+	}
+
+	suite.Equal(400, suite.serve(server, "GET", "/api/admin/consistency?repo=org1/../x", nil).Code, "400 GET consistency of invalid repo")
+	report := check("GET")
+	suite.True(report.Consistent, "index loaded from storage consistent")
+	suite.Equal("org1", report.Repo)
+	suite.Empty(report.Missing)
+
+	suite.Nil(os.Remove(pathutil.Join(dir, "org1", "mychart-0.1.0.tgz")))
+	copyChart(testTarballPathV2, "mychart-0.2.0.tgz")
+	older := time.Now().Add(-time.Hour)
+	suite.Nil(os.Chtimes(pathutil.Join(dir, "org1", "otherchart-0.1.0.tgz"), older, older))
+
+	report = check("GET")
+	suite.False(report.Consistent, "drift detected")
+	suite.False(report.Healed, "not healed by GET")
+	suite.Len(report.Missing, 1)
+	suite.Equal("mychart-0.2.0.tgz", report.Missing[0].Path, "chart added to storage missing from index")
+	suite.Len(report.Extra, 1)
+	suite.Equal("mychart-0.1.0.tgz", report.Extra[0].Path, "chart removed from storage extra in index")
+	suite.Equal("0.1.0", report.Extra[0].Version)
+	suite.Len(report.Changed, 1)
+	suite.Equal("otherchart-0.1.0.tgz", report.Changed[0].Path, "chart older in storage changed")
+	suite.WithinDuration(older, *report.Changed[0].StorageModified, time.Second)
+
+	server.setMaintenance(true, "")
+	suite.Equal(503, suite.serve(server, "POST", "/api/admin/consistency?repo=org1", nil).Code, "503 POST consistency in maintenance mode")
+	server.setMaintenance(false, "")
+
+	report = check("POST")
+	suite.False(report.Consistent, "drift reported before healing")
+	suite.True(report.Healed, "index healed")
+	suite.True(check("GET").Consistent, "index consistent once healed")
+
+	index, httpErr := server.getIndexFile(context.Background(), server.Logger.ContextLoggingFn(&gin.Context{}), "org1")
+	suite.Nil(httpErr, "no error getting index")
+	suite.True(index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: "0.2.0"}}), "missing chart added")
+	suite.False(index.HasEntry(&helm_repo.ChartVersion{Metadata: &chart.Metadata{Name: "mychart", Version: "0.1.0"}}), "extra chart removed")
+}
+
+// countingBackend counts the objects read, to check the storage reads of a request
+type countingBackend struct {
+	storage.Backend
+	mutex sync.Mutex
+	reads int
+}
+
+func (backend *countingBackend) GetObject(path string) (storage.Object, error) {
+	backend.mutex.Lock()
+	backend.reads++
+	backend.mutex.Unlock()
+	return backend.Backend.GetObject(path)
+}
+
+// countReads returns the objects read by f
+func (backend *countingBackend) countReads(f func()) int {
+	backend.mutex.Lock()
+	backend.reads = 0
+	backend.mutex.Unlock()
+	f()
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	return backend.reads
+}
+
+// unreachableBackend fails to list objects, like a bucket with wrong credentials
+type unreachableBackend struct {
+	storage.Backend
+}
+
+func (backend *unreachableBackend) ListObjects(prefix string) ([]storage.Object, error) {
+	return nil, errors.New("access denied")
+}
+
+func (suite *MultiTenantServerTestSuite) TestValidateConfig() {
+	logger := suite.Logger
+	dir := pathutil.Join(suite.TempDirectory, "validateconfig")
+	os.MkdirAll(dir, os.ModePerm)
+	suite.copyTestFilesTo(dir)
+
+	suite.LastExitCode = -1
+	NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		ValidateConfig: true,
+	})
+	suite.Equal(0, suite.LastExitCode, "valid config exits 0")
+	suite.Contains(suite.LastPrinted, "Configuration is valid")
+
+	suite.LastExitCode = -1
+	NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, TlsCert: "tls.crt"}),
+		StorageBackend: &unreachableBackend{Backend: storage.NewLocalFilesystemBackend(dir)},
+		ValidateConfig: true,
+	})
+	suite.Equal(1, suite.LastExitCode, "invalid config exits 1")
+	suite.Contains(suite.LastPrinted, "tls: tls cert and key must be set together", "tls error printed")
+	suite.Contains(suite.LastPrinted, "storage: access denied", "storage error printed")
+}
+
+func (suite *MultiTenantServerTestSuite) TestChartChannels() {
+	dir := pathutil.Join(suite.TempDirectory, "channels")
+	os.MkdirAll(pathutil.Join(dir, "org1"), os.ModePerm)
+	for filename, src := range map[string]string{"mychart-0.1.0.tgz": testTarballPath, "mychart-0.2.0.tgz": testTarballPathV2} {
+		content, err := ioutil.ReadFile(src)
+		suite.Nil(err, "no error reading test chart")
+		suite.Nil(ioutil.WriteFile(pathutil.Join(dir, "org1", filename), content, 0644))
+	}
+
+	server := suite.newTestServer("channels", cm_router.RouterOptions{Depth: 1}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+
+	res := suite.serve(server, "GET", "/api/org1/charts/mychart/channels", nil)
+	suite.Equal(200, res.Code, "200 GET channels")
+	suite.Equal("{}", res.Body.String(), "no channels set")
+	suite.Equal(404, suite.serve(server, "GET", "/api/org1/charts/mychart/channels/stable", nil).Code, "404 GET channel not set")
+
+	suite.Equal(400, suite.serve(server, "PUT", "/api/org1/charts/mychart/channels/Stable", strings.NewReader(`{"version": "0.1.0"}`)).Code, "400 PUT invalid channel name")
+	suite.Equal(400, suite.serve(server, "PUT", "/api/org1/charts/mychart/channels/stable", strings.NewReader(`{}`)).Code, "400 PUT channel without version")
+	suite.Equal(404, suite.serve(server, "PUT", "/api/org1/charts/mychart/channels/stable", strings.NewReader(`{"version": "9.9.9"}`)).Code, "404 PUT channel to missing version")
+
+	res = suite.serve(server, "PUT", "/api/org1/charts/mychart/channels/stable", strings.NewReader(`{"version": "0.1.0"}`))
+	suite.Equal(200, res.Code, "200 PUT channel")
+	suite.Equal(`{"stable":"0.1.0"}`, res.Body.String())
+	res = suite.serve(server, "PUT", "/api/org1/charts/mychart/channels/canary", strings.NewReader(`{"version": "latest"}`))
+	suite.Equal(200, res.Code, "200 PUT channel to latest version")
+	suite.Equal(`{"canary":"0.2.0","stable":"0.1.0"}`, res.Body.String(), "latest resolved when set")
+	_, err := os.Stat(pathutil.Join(dir, "org1", "mychart.channels"))
+	suite.Nil(err, "channels saved in storage")
+
+	res = suite.serve(server, "GET", "/api/org1/charts/mychart/channels/stable", nil)
+	suite.Equal(200, res.Code, "200 GET channel")
+	var chartVersion helm_repo.ChartVersion
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &chartVersion), "no error parsing chart version")
+	suite.Equal("0.1.0", chartVersion.Version, "chart version of channel")
+	suite.Equal([]string{"charts/mychart-0.1.0.tgz"}, chartVersion.URLs)
+	suite.Equal(200, suite.serve(server, "GET", "/api/org1/charts/mychart/0.2.0", nil).Code, "200 GET chart version")
+	suite.True(server.features()["chartChannels"], "channels enabled with the api")
+
+	res = suite.serve(server, "DELETE", "/api/org1/charts/mychart/channels/canary", nil)
+	suite.Equal(200, res.Code, "200 DELETE channel")
+	suite.Equal(`{"stable":"0.1.0"}`, res.Body.String())
+	suite.Equal(404, suite.serve(server, "DELETE", "/api/org1/charts/mychart/channels/canary", nil).Code, "404 DELETE channel not set")
+	suite.Equal(200, suite.serve(server, "DELETE", "/api/org1/charts/mychart/channels/stable", nil).Code, "200 DELETE last channel")
+	_, err = os.Stat(pathutil.Join(dir, "org1", "mychart.channels"))
+	suite.True(os.IsNotExist(err), "channels removed from storage once empty")
+}
+
+func (suite *MultiTenantServerTestSuite) TestTracing() {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	dir := pathutil.Join(suite.TempDirectory, "tracing")
+	os.MkdirAll(pathutil.Join(dir, "org1"), os.ModePerm)
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	ioutil.WriteFile(pathutil.Join(dir, "org1", "mychart-0.1.0.tgz"), content, 0644)
+
+	server := suite.newTestServer("tracing", cm_router.RouterOptions{Depth: 1, TracerProvider: tracerProvider}, MultiTenantServerOptions{
+		EnableAPI: true,
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	res := suite.serve(server, "GET", "/org1/index.yaml", nil, withHeader("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"))
+	suite.Equal(200, res.Code, "200 GET /org1/index.yaml")
+
+	// the index is updated after the upload is served, in the trace of the upload
+	contentV2, err := ioutil.ReadFile(testTarballPathV2)
+	suite.Nil(err, "no error reading test tarball")
+	uploadTraceID := "0af7651916cd43dd8448eb211c80319c"
+	res = suite.serve(server, "POST", "/api/org1/charts", bytes.NewReader(contentV2), withHeader("traceparent", "00-"+uploadTraceID+"-b7ad6b7169203331-01"))
+	suite.Equal(201, res.Code, "201 POST /api/org1/charts")
+	suite.Eventually(func() bool {
+		return atomic.LoadInt64(server.PendingWrites) == 0
+	}, 5*time.Second, 10*time.Millisecond, "index updated after the upload")
+	server.Router.Shutdown(context.Background())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	request, ok := spans["GET /:repo/index.yaml"]
+	suite.True(ok, "span of the request exported")
+	suite.Equal(traceID, request.SpanContext().TraceID().String(), "trace of the client continued")
+	suite.Equal("00f067aa0ba902b7", request.Parent().SpanID().String(), "request span child of the client span")
+	suite.Equal(trace.SpanKindServer, request.SpanKind())
+	repo := ""
+	for _, attribute := range request.Attributes() {
+		if attribute.Key == "chartmuseum.repo" {
+			repo = attribute.Value.AsString()
+		}
+	}
+	suite.Equal("org1", repo, "repo of the request")
+
+	parents := map[string]string{
+		"init tenant":         "GET /:repo/index.yaml",
+		"refresh index":       "GET /:repo/index.yaml",
+		"storage ListObjects": "refresh index",
+		"regenerate index":    "refresh index",
+		"storage GetObject":   "regenerate index",
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		suite.True(ok, "span %s exported", name)
+		if !ok {
+			continue
+		}
+		suite.Equal(traceID, span.SpanContext().TraceID().String(), "span %s in the trace of the request", name)
+		suite.Equal(spans[parent].SpanContext().SpanID(), span.Parent().SpanID(), "span %s child of %s", name, parent)
+	}
+
+	upload, ok := spans["POST /api/:repo/charts"]
+	suite.True(ok, "span of the upload exported")
+	update, ok := spans["update index"]
+	suite.True(ok, "span update index exported")
+	if ok {
+		suite.Equal(uploadTraceID, update.SpanContext().TraceID().String(), "index update in the trace of the upload")
+		suite.Equal(upload.SpanContext().SpanID(), update.Parent().SpanID(), "index update child of the upload")
+	}
+}
+
+func (suite *MultiTenantServerTestSuite) TestLogLevel() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err, "no error creating logger")
+	server, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger, MaxUploadSize: maxUploadSize}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, "loglevel"))),
+		EnableAPI:      true,
+	})
+	suite.Nil(err, "no error creating server")
+
+	res, response := suite.serveJSON(server, "GET", "/api/admin/loglevel", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/loglevel")
+	suite.Equal("info", response["level"], "info level without debug")
+
+	res, response = suite.serveJSON(server, "PUT", "/api/admin/loglevel", strings.NewReader(`{"level": "debug"}`))
+	suite.Equal(200, res.Code, "200 PUT /api/admin/loglevel")
+	suite.Equal("debug", response["level"], "debug level set")
+	suite.Equal("debug", logger.Level.String(), "debug level of the logger")
+
+	res, _ = suite.serveJSON(server, "PUT", "/api/admin/loglevel", strings.NewReader(`{"level": "verbose"}`))
+	suite.Equal(400, res.Code, "400 PUT /api/admin/loglevel with unknown level")
+	res, _ = suite.serveJSON(server, "PUT", "/api/admin/loglevel", strings.NewReader(`{`))
+	suite.Equal(400, res.Code, "400 PUT /api/admin/loglevel with invalid json")
+	_, response = suite.serveJSON(server, "GET", "/api/admin/loglevel", nil)
+	suite.Equal("debug", response["level"], "level unchanged on error")
+}
+
+func (suite *MultiTenantServerTestSuite) TestDebug() {
+	logger := suite.Logger
+	dir := pathutil.Join(suite.TempDirectory, "debug")
+	os.MkdirAll(dir, os.ModePerm)
+	suite.copyTestFilesTo(dir)
+	newServer := func(depth int, enablePprof bool) *MultiTenantServer {
+		server := suite.newTestServer("debug", cm_router.RouterOptions{Depth: depth}, MultiTenantServerOptions{
+			EnableAPI:   true,
+			EnablePprof: enablePprof,
+		})
+		suite.waitUntilReady(server)
+		return server
+	}
+
+	server := newServer(0, true)
+	res := suite.serve(server, "GET", "/api/admin/debug/state", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/debug/state")
+	state := debugState{}
+	suite.Nil(json.Unmarshal(res.Body.Bytes(), &state), "no error decoding state")
+	suite.True(state.Ready, "ready once primed")
+	suite.NotZero(state.Goroutines, "goroutines counted")
+	suite.NotZero(state.Memory.HeapAllocBytes, "heap size reported")
+	suite.Len(state.Tenants, 1, "tenant of the primed index")
+	suite.Equal("", state.Tenants[0].Repo)
+	suite.NotZero(state.Tenants[0].Charts, "charts of the index counted")
+	suite.True(state.Tenants[0].ChartVersions >= state.Tenants[0].Charts, "chart versions counted")
+
+	res = suite.serve(server, "GET", "/api/admin/debug/pprof/", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/debug/pprof/")
+	suite.Contains(res.Body.String(), "goroutine", "profiles listed")
+	res = suite.serve(server, "GET", "/api/admin/debug/pprof/goroutine?debug=1", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/debug/pprof/goroutine")
+	suite.Contains(res.Body.String(), "goroutine profile", "goroutine profile as text")
+
+	server = newServer(2, true)
+	res = suite.serve(server, "GET", "/api/admin/debug/pprof/heap?debug=1", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/debug/pprof/heap with depth")
+	res = suite.serve(server, "GET", "/api/admin/debug/state", nil)
+	suite.Equal(200, res.Code, "200 GET /api/admin/debug/state with depth")
+
+	server = newServer(0, false)
+	suite.Equal(404, suite.serve(server, "GET", "/api/admin/debug/state", nil).Code, "404 GET /api/admin/debug/state without pprof")
+	suite.Equal(404, suite.serve(server, "GET", "/api/admin/debug/pprof/", nil).Code, "404 GET /api/admin/debug/pprof/ without pprof")
+
+	_, err := NewMultiTenantServer(MultiTenantServerOptions{
+		Logger:         logger,
+		Router:         cm_router.NewRouter(cm_router.RouterOptions{Logger: logger}),
+		StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(dir)),
+		EnablePprof:    true,
+	})
+	suite.NotNil(err, "error creating server with pprof and without api")
+}
+
+func (suite *MultiTenantServerTestSuite) TestErrorCodes() {
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	newServer := func(name string, legacy bool) *MultiTenantServer {
+		server := suite.newTestServer("", cm_router.RouterOptions{LegacyErrorBodies: legacy}, MultiTenantServerOptions{
+			StorageBackend: storage.Backend(storage.NewLocalFilesystemBackend(pathutil.Join(suite.TempDirectory, name))),
+			EnableAPI:      true,
+		})
+		return server
+	}
+
+	server := newServer("errorcodes", false)
+	res, _ := suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(201, res.Code, "201 POST /api/charts")
+	res, response := suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(409, res.Code, "409 POST /api/charts with existing version")
+	suite.Equal(cm_router.ProblemContentType, res.Header().Get("Content-Type"), "problem+json content type")
+	suite.Equal(cm_router.ErrorCodeVersionExists, response["code"])
+	suite.Equal("urn:chartmuseum:error:version_exists", response["type"])
+	suite.Equal("Conflict", response["title"])
+	suite.Equal(float64(409), response["status"])
+	suite.Equal("file already exists", response["detail"])
+	suite.Equal("/api/charts", response["instance"])
+	suite.NotEmpty(response["requestId"], "request id of the problem")
+	suite.Nil(response["error"], "no legacy error member")
+
+	_, response = suite.serveJSON(server, "POST", "/api/charts", strings.NewReader("not a chart"))
+	suite.Equal(cm_router.ErrorCodeInvalidChart, response["code"], "invalid chart error code")
+	_, response = suite.serveJSON(server, "GET", "/api/charts/nonexistent", nil)
+	suite.Equal(cm_router.ErrorCodeNotFound, response["code"], "not found error code")
+	res, response = suite.serveJSON(server, "GET", "/no/such/route", nil)
+	suite.Equal(404, res.Code, "404 GET of an unknown route")
+	suite.Equal(cm_router.ErrorCodeNotFound, response["code"], "not found error code of the router")
+
+	server = newServer("errorcodeslegacy", true)
+	suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	res, response = suite.serveJSON(server, "POST", "/api/charts", bytes.NewReader(content))
+	suite.Equal(409, res.Code, "409 POST /api/charts with existing version and legacy bodies")
+	suite.Equal("application/json; charset=utf-8", res.Header().Get("Content-Type"), "json content type with legacy bodies")
+	suite.Equal("file already exists", response["error"], "legacy error body")
+	suite.Nil(response["code"], "no error code in legacy bodies")
+}
+
+// waitUntilReady waits for the cache to be primed in the background
+func (suite *MultiTenantServerTestSuite) waitUntilReady(server *MultiTenantServer) {
+	suite.Eventually(server.ready, 5*time.Second, time.Millisecond, "cache primed")
+}
+
+func (suite *MultiTenantServerTestSuite) waitForWrites(server *MultiTenantServer) {
+	suite.Eventually(func() bool { return atomic.LoadInt64(server.PendingWrites) == 0 },
+		5*time.Second, 10*time.Millisecond, "index updated")
+}
+
+func (suite *MultiTenantServerTestSuite) TestProbes() {
+	// storage cannot be listed until the directory of the repo is no longer a file
+	dir := pathutil.Join(suite.TempDirectory, "probes")
+	suite.Nil(ioutil.WriteFile(dir, []byte{}, 0644), "no error creating file")
+	server := suite.newTestServer("probes", cm_router.RouterOptions{}, MultiTenantServerOptions{
+		PrimeRetryDelay: 10 * time.Millisecond,
+	})
+
+	suite.Equal(200, suite.serve(server, "GET", "/live", nil).Code, "200 GET /live while priming")
+	suite.Equal(200, suite.serve(server, "GET", "/health", nil).Code, "200 GET /health while priming")
+	suite.Equal(503, suite.serve(server, "GET", "/ready", nil).Code, "503 GET /ready while priming")
+
+	suite.Nil(os.Remove(dir), "no error removing file")
+	suite.Nil(os.MkdirAll(dir, 0755), "no error creating storage directory")
+	suite.Eventually(func() bool {
+		return suite.serve(server, "GET", "/ready", nil).Code == 200
+	}, 5*time.Second, 10*time.Millisecond, "200 GET /ready once primed")
+
+	suite.Nil(os.RemoveAll(dir), "no error removing storage directory")
+	suite.Nil(ioutil.WriteFile(dir, []byte{}, 0644), "no error creating file")
+	suite.Equal(503, suite.serve(server, "GET", "/ready", nil).Code, "503 GET /ready with storage unreachable")
+	suite.Equal(200, suite.serve(server, "GET", "/live", nil).Code, "200 GET /live with storage unreachable")
 }
 
 func (suite *MultiTenantServerTestSuite) TestRoutes() {
@@ -930,6 +4779,10 @@ func (suite *MultiTenantServerTestSuite) testAllRoutes(repo string, depth int) {
 	res = suite.doRequest(stype, "GET", "/health", nil, "")
 	suite.Equal(200, res.Status(), "200 GET /health")
 
+	// GET /live
+	res = suite.doRequest(stype, "GET", "/live", nil, "")
+	suite.Equal(200, res.Status(), "200 GET /live")
+
 	var repoPrefix string
 	if repo != "" {
 		repoPrefix = pathutil.Join("/", repo)
@@ -17,13 +17,18 @@ limitations under the License.
 package multitenant
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
 	pathutil "path"
 	"strings"
 
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
 	cm_repo "helm.sh/chartmuseum/pkg/repo"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/chartmuseum/storage"
 )
 
@@ -39,7 +44,7 @@ type (
 	}
 )
 
-func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo string, filename string) (*StorageObject, *HTTPError) {
+func (server *MultiTenantServer) getStorageObject(ctx context.Context, log cm_logger.LoggingFn, repo string, filename string) (*StorageObject, *HTTPError) {
 	isChartPackage := strings.HasSuffix(filename, cm_repo.ChartPackageFileExtension)
 	isProvenanceFile := strings.HasSuffix(filename, cm_repo.ProvenanceFileExtension)
 	if !isChartPackage && !isProvenanceFile {
@@ -47,12 +52,12 @@ func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo
 			"repo", repo,
 			"filename", filename,
 		)
-		return nil, &HTTPError{http.StatusInternalServerError, "unsupported file extension"}
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, "unsupported file extension"}
 	}
 
 	objectPath := pathutil.Join(repo, filename)
 
-	object, err := server.StorageBackend.GetObject(objectPath)
+	object, err := server.storage(ctx).GetObject(objectPath)
 	if err != nil {
 		errStr := err.Error()
 		log(cm_logger.WarnLevel, errStr,
@@ -60,7 +65,7 @@ func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo
 			"filename", filename,
 		)
 		// TODO determine if this is true 404
-		return nil, &HTTPError{http.StatusNotFound, "object not found"}
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "object not found"}
 	}
 
 	var contentType string
@@ -77,3 +82,26 @@ func (server *MultiTenantServer) getStorageObject(log cm_logger.LoggingFn, repo
 
 	return storageObject, nil
 }
+
+// objectNotFound tells whether err, from reading filename of repo, is because the object does not
+// exist. Backends have errors of their own for it: those of the local filesystem and Amazon S3 are
+// told apart, and others are checked by listing the repo, any error meaning it is unknown
+func (server *MultiTenantServer) objectNotFound(repo string, filename string, err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	var requestErr awserr.RequestFailure
+	if errors.As(err, &requestErr) {
+		return requestErr.StatusCode() == http.StatusNotFound
+	}
+	objects, listErr := server.StorageBackend.ListObjects(repo)
+	if listErr != nil {
+		return false
+	}
+	for _, object := range objects {
+		if object.Path == filename {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,333 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"fmt"
+	"net/http"
+	pathutil "path"
+	"regexp"
+	"strings"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+	"helm.sh/chartmuseum/pkg/tenant"
+	"helm.sh/chartmuseum/pkg/upstream"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	validTenantName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*(/[a-zA-Z0-9][a-zA-Z0-9._-]*)*$`)
+)
+
+type (
+	// tenantResource is how a tenant is sent to and returned by the tenant api
+	tenantResource struct {
+		Name string `json:"name"`
+		*tenant.Overrides
+	}
+)
+
+func (server *MultiTenantServer) getTenantsRequestHandler(c *gin.Context) {
+	tenants := []tenantResource{}
+	for _, prefix := range server.TenantConfig.Prefixes() {
+		overrides, ok := server.TenantConfig.Get(prefix)
+		if !ok {
+			continue // deleted since listing prefixes
+		}
+		// never return credentials
+		masked := *overrides
+		masked.BasicAuthPass = ""
+		masked.Credentials = nil
+		for username := range overrides.Credentials {
+			if masked.Credentials == nil {
+				masked.Credentials = map[string]string{}
+			}
+			masked.Credentials[username] = ""
+		}
+		if overrides.Upstream != nil {
+			upstreamURL := redactURL(*overrides.Upstream)
+			masked.Upstream = &upstreamURL
+		}
+		tenants = append(tenants, tenantResource{Name: prefix, Overrides: &masked})
+	}
+	c.JSON(200, gin.H{"tenants": tenants})
+}
+
+func (server *MultiTenantServer) postTenantRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	resource := tenantResource{}
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, fmt.Sprintf("invalid tenant: %s", err))
+		return
+	}
+	if resource.Overrides == nil {
+		resource.Overrides = &tenant.Overrides{}
+	}
+	resource.Name = strings.Trim(resource.Name, "/")
+	if err := server.validateTenantName(resource.Name); err != nil {
+		writeError(c, err)
+		return
+	}
+	if (resource.BasicAuthUser == "") != (resource.BasicAuthPass == "") {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "basicAuthUser and basicAuthPass must be set together")
+		return
+	}
+	for username, password := range resource.Credentials {
+		if username == "" || password == "" {
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "credentials must have a username and a password")
+			return
+		}
+	}
+
+	if err := resource.HashPasswords(); err != nil {
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	for i, member := range resource.Members {
+		resource.Members[i] = strings.Trim(member, "/")
+	}
+	if err := server.validateVirtualMembers(resource.Name, resource.Members); err != nil {
+		writeError(c, err)
+		return
+	}
+	if resource.Upstream != nil && *resource.Upstream != "" {
+		if _, err := upstream.NewClient(upstream.ClientOptions{URL: *resource.Upstream}); err != nil {
+			cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	server.TenantConfigLock.Lock()
+	defer server.TenantConfigLock.Unlock()
+
+	if _, ok := server.TenantConfig.Get(resource.Name); ok {
+		cm_router.WriteError(c, 409, cm_router.ErrorCodeConflict, "tenant already exists")
+		return
+	}
+
+	if err := server.provisionTenant(log, resource.Name); err != nil {
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+
+	server.TenantConfig.Set(resource.Name, resource.Overrides)
+	if err := server.saveTenantConfig(log); err != nil {
+		server.TenantConfig.Delete(resource.Name)
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+
+	log(cm_logger.InfoLevel, "Tenant created",
+		"tenant", resource.Name,
+	)
+	c.JSON(201, objectSavedResponse)
+}
+
+func (server *MultiTenantServer) deleteTenantRequestHandler(c *gin.Context) {
+	log := server.Logger.ContextLoggingFn(c)
+	name := strings.Trim(c.Query("name"), "/")
+	if name == "" {
+		cm_router.WriteError(c, 400, cm_router.ErrorCodeBadRequest, "tenant name is required")
+		return
+	}
+	_, purge := c.GetQuery("purge")
+
+	server.TenantConfigLock.Lock()
+	defer server.TenantConfigLock.Unlock()
+
+	overrides, ok := server.TenantConfig.Get(name)
+	if !ok {
+		cm_router.WriteError(c, 404, cm_router.ErrorCodeNotFound, "tenant not found")
+		return
+	}
+
+	server.TenantConfig.Delete(name)
+	if err := server.saveTenantConfig(log); err != nil {
+		server.TenantConfig.Set(name, overrides)
+		cm_router.WriteError(c, 500, cm_router.ErrorCodeStorageUnavailable, err.Error())
+		return
+	}
+
+	if purge {
+		if err := server.purgeTenant(log, name); err != nil {
+			cm_router.WriteError(c, 500, cm_router.ErrorCodeStorageUnavailable, err.Error())
+			return
+		}
+	}
+	server.evictTenant(log, name)
+
+	log(cm_logger.InfoLevel, "Tenant deleted",
+		"tenant", name,
+		"purged", purge,
+	)
+	c.JSON(200, objectDeletedResponse)
+}
+
+// requireServerAuth wraps the handler of a route acting on every tenant, denying it when only
+// tenants have credentials, as routes on no repo are then allowed without any
+func (server *MultiTenantServer) requireServerAuth(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if server.Router.TenantAuthOnly() {
+			cm_router.WriteError(c, http.StatusForbidden, cm_router.ErrorCodeForbidden, "server credentials required")
+			return
+		}
+		handler(c)
+	}
+}
+
+// validateTenantName checks that a tenant name is a repo path reachable with the configured depth
+func (server *MultiTenantServer) validateTenantName(name string) *HTTPError {
+	if !validTenantName.MatchString(name) {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "invalid tenant name"}
+	}
+	depth := server.Router.Depth
+	if !server.Router.DepthDynamic && len(strings.Split(name, "/")) > depth {
+		return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, fmt.Sprintf("tenant name has more than %d path segments", depth)}
+	}
+	return nil
+}
+
+// provisionTenant writes an empty statefile so object stores have the tenant prefix,
+// leaving prefixes which already hold a statefile untouched
+func (server *MultiTenantServer) provisionTenant(log cm_logger.LoggingFn, name string) error {
+	objectPath := pathutil.Join(name, cm_repo.StatefileFilename)
+	if _, err := server.StorageBackend.GetObject(objectPath); err == nil {
+		return nil
+	}
+
+	index := cm_repo.NewIndex("", name, &cm_repo.ServerInfo{
+		ContextPath: server.Router.ContextPath,
+	})
+	err := server.StorageBackend.PutObject(objectPath, index.Raw)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error provisioning tenant storage",
+			"tenant", name,
+			"error", err.Error(),
+		)
+		return err
+	}
+	log(cm_logger.DebugLevel, "Tenant storage provisioned",
+		"tenant", name,
+	)
+	return nil
+}
+
+// purgeTenant deletes all objects stored under a tenant prefix, including nested repos but those
+// of other tenants, with the tenant already removed from the config
+func (server *MultiTenantServer) purgeTenant(log cm_logger.LoggingFn, name string) error {
+	objects, err := server.StorageBackend.ListObjects(name)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		objectPath := pathutil.Join(name, object.Path)
+		if prefix, ok := server.TenantConfig.LookupPrefix(pathutil.Dir(objectPath)); ok && strings.HasPrefix(prefix, name+"/") {
+			continue
+		}
+		err = server.StorageBackend.DeleteObject(objectPath)
+		if err != nil {
+			log(cm_logger.ErrorLevel, "Error purging tenant storage",
+				"tenant", name,
+				"path", object.Path,
+				"error", err.Error(),
+			)
+			return err
+		}
+	}
+	// state files may not be listed by every backend
+	server.StorageBackend.DeleteObject(pathutil.Join(name, cm_repo.StatefileFilename))
+	server.StorageBackend.DeleteObject(pathutil.Join(name, cm_repo.MetadataCacheFilename))
+	return nil
+}
+
+// evictTenant drops the cached indexes of a tenant and of the repos nested under it
+func (server *MultiTenantServer) evictTenant(log cm_logger.LoggingFn, name string) {
+	server.TenantCacheKeyLock.Lock()
+	var repos []string
+	for repo := range server.Tenants {
+		if repo == name || strings.HasPrefix(repo, name+"/") {
+			repos = append(repos, repo)
+		}
+	}
+	for _, repo := range repos {
+		delete(server.Tenants, repo)
+		delete(server.InternalCacheStore, repo)
+	}
+	server.observeCacheEntries()
+	server.TenantCacheKeyLock.Unlock()
+	server.evictOCIBlobs(name)
+
+	if server.ExternalCacheStore == nil {
+		return
+	}
+	for _, repo := range repos {
+		if err := server.ExternalCacheStore.Delete(repo); err != nil {
+			log(cm_logger.WarnLevel, "Could not delete tenant entry from cache store",
+				"repo", repo,
+				"error", err.Error(),
+			)
+		}
+	}
+}
+
+// loadTenantConfig merges tenants saved by the tenant api over the ones from the config file
+func (server *MultiTenantServer) loadTenantConfig() {
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	object, err := server.StorageBackend.GetObject(tenant.ConfigFilename)
+	if err != nil {
+		return
+	}
+	config, err := tenant.ConfigFromContent(object.Content)
+	if err != nil {
+		log(cm_logger.WarnLevel, "tenants.yaml found but could not be parsed",
+			"error", err.Error(),
+		)
+		return
+	}
+	for prefix, overrides := range config.Tenants {
+		// tenants saved before passwords were hashed are hashed on their next save
+		if err := overrides.HashPasswords(); err != nil {
+			log(cm_logger.WarnLevel, "Could not hash tenant passwords",
+				"tenant", prefix,
+				"error", err.Error(),
+			)
+		}
+		server.TenantConfig.Set(prefix, overrides)
+	}
+	log(cm_logger.DebugLevel, "tenants.yaml loaded",
+		"tenants", len(config.Tenants),
+	)
+}
+
+func (server *MultiTenantServer) saveTenantConfig(log cm_logger.LoggingFn) error {
+	content, err := server.TenantConfig.Content()
+	if err == nil {
+		err = server.StorageBackend.PutObject(tenant.ConfigFilename, content)
+	}
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error saving tenants.yaml",
+			"error", err.Error(),
+		)
+		return err
+	}
+	log(cm_logger.DebugLevel, "tenants.yaml saved in storage")
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the server
+const tracerName = "helm.sh/chartmuseum/pkg/chartmuseum/server/multitenant"
+
+type (
+	// tracedBackend records a span for each call to the storage backend, child of the span of ctx
+	tracedBackend struct {
+		cm_storage.Backend
+		tracer trace.Tracer
+		ctx    context.Context
+	}
+)
+
+// noopTracer starts spans recording nothing, for servers without tracing
+var noopTracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+
+// tracer returns the tracer of the server, or noopTracer without tracing
+func (server *MultiTenantServer) tracer() trace.Tracer {
+	if server.Router.TracerProvider == nil {
+		return noopTracer
+	}
+	return server.Router.TracerProvider.Tracer(tracerName)
+}
+
+// storage returns the storage backend reading until ctx is done, see withContext, and tracing its
+// calls when ctx is traced by a request or an index regeneration
+func (server *MultiTenantServer) storage(ctx context.Context) cm_storage.Backend {
+	backend := withContext(server.StorageBackend, ctx)
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return backend
+	}
+	return &tracedBackend{Backend: backend, tracer: server.tracer(), ctx: ctx}
+}
+
+// startSpan starts an internal span about a repo, child of the span of ctx or the root of a new trace
+func (server *MultiTenantServer) startSpan(ctx context.Context, name string, repo string) (context.Context, trace.Span) {
+	return server.tracer().Start(ctx, name, trace.WithAttributes(attribute.String("chartmuseum.repo", repo)))
+}
+
+// recordError marks span as failed with err, unless err is nil
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// requestContext returns the context of the request of c, or the background one for work outside requests
+func requestContext(c *gin.Context) context.Context {
+	if c == nil || c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+func (backend *tracedBackend) ListObjects(prefix string) ([]cm_storage.Object, error) {
+	_, span := backend.tracer.Start(backend.ctx, "storage ListObjects", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.prefix", prefix)),
+	)
+	defer span.End()
+	objects, err := backend.Backend.ListObjects(prefix)
+	span.SetAttributes(attribute.Int("storage.objects", len(objects)))
+	recordError(span, err)
+	return objects, err
+}
+
+func (backend *tracedBackend) GetObject(path string) (cm_storage.Object, error) {
+	_, span := backend.tracer.Start(backend.ctx, "storage GetObject", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.path", path)),
+	)
+	defer span.End()
+	object, err := backend.Backend.GetObject(path)
+	span.SetAttributes(attribute.Int("storage.size", len(object.Content)))
+	recordError(span, err)
+	return object, err
+}
+
+func (backend *tracedBackend) PutObject(path string, content []byte) error {
+	_, span := backend.tracer.Start(backend.ctx, "storage PutObject", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.path", path), attribute.Int("storage.size", len(content))),
+	)
+	defer span.End()
+	err := backend.Backend.PutObject(path, content)
+	recordError(span, err)
+	return err
+}
+
+func (backend *tracedBackend) DeleteObject(path string) error {
+	_, span := backend.tracer.Start(backend.ctx, "storage DeleteObject", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.path", path)),
+	)
+	defer span.End()
+	err := backend.Backend.DeleteObject(path)
+	recordError(span, err)
+	return err
+}
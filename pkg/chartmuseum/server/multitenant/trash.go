@@ -0,0 +1,206 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"net/http"
+	pathutil "path"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	cm_storage "github.com/chartmuseum/storage"
+	"github.com/gin-gonic/gin"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+var (
+	objectRestoredResponse = gin.H{"restored": true}
+)
+
+// trashDirectory holds the deleted objects of a repo. Being nested in the repo, its
+// objects are not listed with the charts of the repo, and no tenant can be named after it
+const trashDirectory = ".trash"
+
+// maxTrashPurgeInterval bounds how long expired objects stay in the trash of a repo
+const maxTrashPurgeInterval = time.Hour
+
+func trashPath(repo string, filename string) string {
+	return pathutil.Join(repo, trashDirectory, filename)
+}
+
+// moveObject copies an object to another path in storage, then deletes it
+func (server *MultiTenantServer) moveObject(from string, to string) error {
+	object, err := server.StorageBackend.GetObject(from)
+	if err != nil {
+		return err
+	}
+	if err := server.StorageBackend.PutObject(to, object.Content); err != nil {
+		return err
+	}
+	return server.StorageBackend.DeleteObject(from)
+}
+
+// trashChartVersion moves a chart package and its attachments to the trash of their repo,
+// where they can be restored until the trash retention expires
+func (server *MultiTenantServer) trashChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) *HTTPError {
+	server.purgeTrash(log, repo)
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(name, version)
+	log(cm_logger.DebugLevel, "Moving package to trash",
+		"package", pathutil.Join(repo, filename),
+	)
+	if err := server.moveObject(pathutil.Join(repo, filename), trashPath(repo, filename)); err != nil {
+		return &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, err.Error()}
+	}
+	for _, attachmentFilename := range server.attachmentFilenames(repo, name, version) {
+		server.moveObject(pathutil.Join(repo, attachmentFilename), trashPath(repo, attachmentFilename)) // ignore error here, may be no attachment
+	}
+	return nil
+}
+
+// restoreChartVersion moves a chart package and its attachments back from the trash of their repo
+func (server *MultiTenantServer) restoreChartVersion(log cm_logger.LoggingFn, repo string, name string, version string) (*helm_repo.ChartVersion, *HTTPError) {
+	server.purgeTrash(log, repo)
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(name, version)
+	object, err := server.StorageBackend.GetObject(trashPath(repo, filename))
+	if err != nil {
+		return nil, &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "chart version not found in trash"}
+	}
+	if _, err := server.StorageBackend.GetObject(pathutil.Join(repo, filename)); err == nil {
+		return nil, &HTTPError{http.StatusConflict, cm_router.ErrorCodeVersionExists, "chart version already exists"}
+	}
+	limitReached, err := server.checkStorageLimit(repo, filename, false)
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	if limitReached {
+		return nil, &HTTPError{http.StatusInsufficientStorage, cm_router.ErrorCodeStorageLimit, "repo has reached storage limit"}
+	}
+	chartVersion, err := cm_repo.ChartVersionFromStorageObject(cm_storage.Object{
+		Path:         pathutil.Join(repo, filename),
+		Content:      object.Content,
+		LastModified: time.Now(),
+	})
+	if err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInvalidChart, err.Error()}
+	}
+
+	log(cm_logger.DebugLevel, "Restoring package from trash",
+		"package", pathutil.Join(repo, filename),
+	)
+	// the attachments go first, so the chart is never in the index without its provenance file
+	for _, attachmentFilename := range server.attachmentFilenames(trashPath(repo, ""), name, version) {
+		if _, err := server.StorageBackend.GetObject(trashPath(repo, attachmentFilename)); err == nil {
+			if err := server.moveObject(trashPath(repo, attachmentFilename), pathutil.Join(repo, attachmentFilename)); err != nil {
+				return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+			}
+		}
+	}
+	if err := server.moveObject(trashPath(repo, filename), pathutil.Join(repo, filename)); err != nil {
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeStorageUnavailable, err.Error()}
+	}
+	return chartVersion, nil
+}
+
+// purgeTrash deletes the objects of the trash of a repo kept for longer than the trash retention
+func (server *MultiTenantServer) purgeTrash(log cm_logger.LoggingFn, repo string) {
+	objects, err := server.StorageBackend.ListObjects(trashPath(repo, ""))
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Error listing trash",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return
+	}
+	for _, object := range objects {
+		if time.Since(object.LastModified) < server.trashRetention() {
+			continue
+		}
+		log(cm_logger.DebugLevel, "Purging object from trash",
+			"repo", repo,
+			"object", object.Path,
+		)
+		if err := server.StorageBackend.DeleteObject(trashPath(repo, object.Path)); err != nil {
+			log(cm_logger.ErrorLevel, "Error purging object from trash",
+				"repo", repo,
+				"object", object.Path,
+				"error", err.Error(),
+			)
+		}
+	}
+}
+
+// initTrashTimer purges the trash of the repos in cache, along with the purges done
+// whenever a chart version of a repo is deleted or restored
+func (server *MultiTenantServer) initTrashTimer() {
+	if server.TrashRetention <= 0 {
+		return
+	}
+	interval := server.TrashRetention
+	if interval > maxTrashPurgeInterval {
+		interval = maxTrashPurgeInterval
+	}
+	go func() {
+		log := server.Logger.ContextLoggingFn(&gin.Context{})
+		t := time.NewTicker(interval)
+		for range t.C {
+			if inMaintenance, _ := server.inMaintenance(); inMaintenance || !server.Leader.IsLeader() {
+				continue
+			}
+			server.TenantCacheKeyLock.RLock()
+			repos := make([]string, 0, len(server.Tenants))
+			for repo := range server.Tenants {
+				repos = append(repos, repo)
+			}
+			server.TenantCacheKeyLock.RUnlock()
+			for _, repo := range repos {
+				server.purgeTrash(log, repo)
+			}
+		}
+	}()
+}
+
+func (server *MultiTenantServer) restoreChartVersionRequestHandler(c *gin.Context) {
+	repo := c.Param("repo")
+	name := c.Param("name")
+	version := c.Param("version")
+	log := server.Logger.ContextLoggingFn(c)
+	chartVersion, err := server.restoreChartVersion(log, repo, name, version)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	server.emitEvent(c, repo, addChart, chartVersion)
+	c.JSON(200, objectRestoredResponse)
+}
+
+// hideTrash wraps the handler of a repo route, so that in dynamic depth the trash of
+// a repo is not served as a repo of its own
+func (server *MultiTenantServer) hideTrash(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, segment := range strings.Split(c.Param("repo"), "/") {
+			if segment == trashDirectory {
+				cm_router.WriteError(c, http.StatusNotFound, cm_router.ErrorCodeNotFound, "not found")
+				return
+			}
+		}
+		handler(c)
+	}
+}
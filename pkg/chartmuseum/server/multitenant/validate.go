@@ -0,0 +1,48 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateConfig checks what is only known to work once the server runs, that storage can be
+// listed with the credentials configured and that the tls files load, the rest of the config
+// being checked when creating the server. It prints every error found, then exits
+func (server *MultiTenantServer) validateConfig() {
+	var errs []string
+	if err := server.Router.ValidateTLS(); err != nil {
+		errs = append(errs, fmt.Sprintf("tls: %s", err))
+	}
+	log := server.Logger.ContextLoggingFn(&gin.Context{})
+	objects, err := server.fetchChartsInStorage(context.Background(), log, "")
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("storage: %s", err))
+	}
+
+	if len(errs) > 0 {
+		echo(fmt.Sprintf("Invalid configuration:\n  %s\n", strings.Join(errs, "\n  ")))
+		exit(1)
+		return
+	}
+	echo(fmt.Sprintf("Configuration is valid, %d chart packages in the root of storage\n", len(objects)))
+	exit(0)
+}
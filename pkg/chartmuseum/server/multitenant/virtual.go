@@ -0,0 +1,195 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"context"
+	"net/http"
+	pathutil "path"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// virtualIndex is the merged index of a virtual repo, kept until the index of a member changes
+	virtualIndex struct {
+		members []*cm_repo.Index
+		merged  *cm_repo.Index
+	}
+)
+
+// virtualMembers returns the repos merged by a virtual repo, in order of precedence,
+// or nil if the repo is not virtual. Unlike other tenant settings, members only apply
+// to the exact repo they are set for
+func (server *MultiTenantServer) virtualMembers(repo string) []string {
+	if server.TenantConfig == nil {
+		return nil
+	}
+	overrides, ok := server.TenantConfig.Get(repo)
+	if !ok || overrides == nil || len(overrides.Members) == 0 {
+		return nil
+	}
+	return overrides.Members
+}
+
+// getServedIndex returns the index served for a repo: the merged index of its members
+// if it is virtual, or its own index along with the charts of its upstream repo
+func (server *MultiTenantServer) getServedIndex(ctx context.Context, log cm_logger.LoggingFn, repo string) (*cm_repo.Index, *HTTPError) {
+	if members := server.virtualMembers(repo); members != nil {
+		return server.getVirtualIndex(ctx, log, repo, members)
+	}
+	index, err := server.getIndexFile(ctx, log, repo)
+	if err != nil {
+		return index, err
+	}
+	return server.withUpstreamEntries(log, repo, index), nil
+}
+
+// getIndexFileForAPI returns the index listed by the api routes of a repo, which for
+// virtual repos is the merged index of their members
+func (server *MultiTenantServer) getIndexFileForAPI(ctx context.Context, log cm_logger.LoggingFn, repo string) (*cm_repo.Index, *HTTPError) {
+	if members := server.virtualMembers(repo); members != nil {
+		return server.getVirtualIndex(ctx, log, repo, members)
+	}
+	return server.getIndexFile(ctx, log, repo)
+}
+
+func (server *MultiTenantServer) getVirtualIndex(ctx context.Context, log cm_logger.LoggingFn, repo string, members []string) (*cm_repo.Index, *HTTPError) {
+	var memberIndexes []*cm_repo.Index
+	for _, member := range members {
+		if server.virtualMembers(member) != nil {
+			log(cm_logger.WarnLevel, "Skipping virtual repo member of virtual repo",
+				"repo", repo,
+				"member", member,
+			)
+			continue
+		}
+		index, err := server.getServedIndex(ctx, log, member)
+		if err != nil {
+			return nil, err
+		}
+		memberIndexes = append(memberIndexes, index)
+	}
+
+	server.VirtualLock.Lock()
+	defer server.VirtualLock.Unlock()
+	if cached, ok := server.VirtualIndexes[repo]; ok && sameIndexes(cached.members, memberIndexes) {
+		return cached.merged, nil
+	}
+
+	merged := server.newVirtualIndex(repo)
+	for _, index := range memberIndexes {
+		for name, chartVersions := range index.Entries {
+			for _, chartVersion := range chartVersions {
+				if merged.HasEntry(chartVersion) {
+					continue // taken from a member with higher precedence
+				}
+				// relative urls now point to the virtual repo, which serves the charts of its members
+				merged.Entries[name] = append(merged.Entries[name], chartVersion)
+			}
+		}
+	}
+	if err := merged.Regenerate(); err != nil {
+		log(cm_logger.ErrorLevel, "Error merging indexes of virtual repo",
+			"repo", repo,
+			"error", err.Error(),
+		)
+		return nil, &HTTPError{http.StatusInternalServerError, cm_router.ErrorCodeInternal, err.Error()}
+	}
+
+	server.VirtualIndexes[repo] = &virtualIndex{members: memberIndexes, merged: merged}
+	return merged, nil
+}
+
+func (server *MultiTenantServer) newVirtualIndex(repo string) *cm_repo.Index {
+	var chartURL string
+	if server.ChartURL != "" {
+		chartURL = server.ChartURL + "/" + repo
+	}
+	return cm_repo.NewIndex(chartURL, repo, &cm_repo.ServerInfo{
+		ContextPath: server.Router.ContextPath,
+	})
+}
+
+func sameIndexes(a []*cm_repo.Index, b []*cm_repo.Index) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// getVirtualObject serves a chart package or provenance file of a virtual repo from
+// the first member having it, in storage or upstream
+func (server *MultiTenantServer) getVirtualObject(c *gin.Context, log cm_logger.LoggingFn, repo string, filename string) (*StorageObject, *HTTPError) {
+	notFound := &HTTPError{http.StatusNotFound, cm_router.ErrorCodeNotFound, "object not found"}
+	if pathutil.Base(filename) != filename {
+		return nil, notFound
+	}
+	err := notFound
+	for _, member := range server.virtualMembers(repo) {
+		if server.virtualMembers(member) != nil {
+			continue
+		}
+		storageObject, memberErr := server.getStorageObject(requestContext(c), log, member, filename)
+		if memberErr != nil && memberErr.Status == http.StatusNotFound {
+			storageObject, memberErr = server.getUpstreamObject(c, log, member, filename)
+		}
+		if memberErr == nil {
+			return storageObject, nil
+		}
+		if memberErr.Status != http.StatusNotFound {
+			err = memberErr
+		}
+	}
+	return nil, err
+}
+
+// rejectVirtualRepo wraps the handler of a route writing to a repo, as virtual repos are read-only
+func (server *MultiTenantServer) rejectVirtualRepo(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if server.virtualMembers(c.Param("repo")) != nil {
+			cm_router.WriteError(c, http.StatusMethodNotAllowed, cm_router.ErrorCodeReadOnly, "virtual repos are read-only")
+			return
+		}
+		handler(c)
+	}
+}
+
+// validateVirtualMembers checks the members of a virtual repo created through the tenant api
+func (server *MultiTenantServer) validateVirtualMembers(repo string, members []string) *HTTPError {
+	for _, member := range members {
+		if member == repo {
+			return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "a virtual repo cannot be its own member"}
+		}
+		if err := server.validateTenantName(member); err != nil {
+			return &HTTPError{err.Status, err.Code, "invalid member: " + err.Message}
+		}
+		if server.virtualMembers(member) != nil {
+			return &HTTPError{http.StatusBadRequest, cm_router.ErrorCodeBadRequest, "members cannot be virtual repos"}
+		}
+	}
+	return nil
+}
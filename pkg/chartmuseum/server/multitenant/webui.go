@@ -0,0 +1,200 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package multitenant
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	cm_router "helm.sh/chartmuseum/pkg/chartmuseum/router"
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// readmeFilenames are looked up in this order, as with helm show readme
+	readmeFilenames = []string{"README.md", "README.txt", "README"}
+
+	// webUIHTML lists the charts of a repo with the api, the repo being in the fragment of the url
+	// with multitenancy, e.g. /#org1/repo1. Everything from the api is set as text, never as html
+	webUIHTML = []byte(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ChartMuseum</title>
+<style>
+    body { margin: 0 auto; max-width: 70em; padding: 0 1em; font-family: Tahoma, Verdana, Arial, sans-serif; }
+    header { display: flex; align-items: center; gap: 1em; }
+    input { padding: 0.3em; }
+    #charts { list-style: none; padding: 0; }
+    #charts li { padding: 0.5em 0; border-bottom: 1px solid #ddd; cursor: pointer; }
+    .description { color: #555; font-size: 0.9em; }
+    pre { background: #f5f5f5; padding: 1em; overflow: auto; }
+    .error { color: #b00; }
+</style>
+</head>
+<body>
+<header>
+<h1>ChartMuseum</h1>
+<input id="repo" placeholder="repo, e.g. org1/repo1" size="25">
+<input id="search" placeholder="search charts" size="25">
+</header>
+<p id="status"></p>
+<ul id="charts"></ul>
+<div id="chart" hidden>
+<p><a href="" id="back">&larr; all charts</a></p>
+<h2 id="title"></h2>
+<p class="description" id="description"></p>
+<label>Version <select id="versions"></select></label>
+<h3>Install</h3>
+<pre id="install"></pre>
+<h3>README</h3>
+<pre id="readme"></pre>
+<h3>Values</h3>
+<pre id="values"></pre>
+</div>
+<script>
+(function() {
+    var contextPath = CONTEXT_PATH;
+    var charts = {};
+    var el = function(id) { return document.getElementById(id); };
+
+    function repo() { return decodeURIComponent(location.hash.replace(/^#\/?/, "")).replace(/\/+$/, ""); }
+    function api(path) { return contextPath + "/api" + (repo() ? "/" + repo() : "") + path; }
+    function repoURL() { return location.origin + contextPath + (repo() ? "/" + repo() : ""); }
+
+    function fetchText(path) {
+        return fetch(api(path), {credentials: "same-origin"}).then(function(response) {
+            if (!response.ok) { throw new Error(response.status + " " + response.statusText); }
+            return response.text();
+        });
+    }
+
+    function showError(err) {
+        el("status").className = "error";
+        el("status").textContent = err.message;
+    }
+
+    function listCharts() {
+        el("chart").hidden = true;
+        el("charts").hidden = false;
+        var search = el("search").value.toLowerCase();
+        var list = el("charts");
+        list.textContent = "";
+        Object.keys(charts).sort().forEach(function(name) {
+            var latest = charts[name][0];
+            if (search && (name + " " + (latest.description || "")).toLowerCase().indexOf(search) < 0) { return; }
+            var item = document.createElement("li");
+            var title = document.createElement("strong");
+            title.textContent = name + " " + latest.version;
+            var description = document.createElement("div");
+            description.className = "description";
+            description.textContent = latest.description || "";
+            item.appendChild(title);
+            item.appendChild(description);
+            item.onclick = function() { showChart(name, latest.version); };
+            list.appendChild(item);
+        });
+    }
+
+    function showChart(name, version) {
+        el("charts").hidden = true;
+        el("chart").hidden = false;
+        var chartVersion = charts[name].filter(function(cv) { return cv.version === version; })[0];
+        el("title").textContent = name;
+        el("description").textContent = chartVersion.description || "";
+        var versions = el("versions");
+        versions.textContent = "";
+        charts[name].forEach(function(cv) {
+            var option = document.createElement("option");
+            option.textContent = cv.version;
+            option.selected = cv.version === version;
+            versions.appendChild(option);
+        });
+        versions.onchange = function() { showChart(name, versions.value); };
+        var repoName = (repo() || "chartmuseum").replace(/\//g, "-");
+        el("install").textContent = "helm repo add " + repoName + " " + repoURL() + "\n" +
+            "helm install my-" + name + " " + repoName + "/" + name + " --version " + version;
+        var path = "/charts/" + encodeURIComponent(name) + "/" + encodeURIComponent(version);
+        ["readme", "values"].forEach(function(file) {
+            el(file).textContent = "loading...";
+            fetchText(path + "/" + file).then(function(text) {
+                el(file).textContent = text || "none";
+            }, function(err) {
+                el(file).textContent = err.message;
+            });
+        });
+    }
+
+    function load() {
+        el("repo").value = repo();
+        el("status").className = "";
+        el("status").textContent = "loading...";
+        fetchText("/charts").then(function(text) {
+            charts = JSON.parse(text);
+            el("status").textContent = Object.keys(charts).length + " charts in " + repoURL();
+            listCharts();
+        }, showError);
+    }
+
+    el("repo").onchange = function() { location.hash = el("repo").value; };
+    el("search").oninput = listCharts;
+    el("back").onclick = function(e) { e.preventDefault(); listCharts(); };
+    window.onhashchange = load;
+    load();
+})();
+</script>
+</body>
+</html>
+`)
+)
+
+func (server *MultiTenantServer) getWebUIHandler(c *gin.Context) {
+	if acceptsJSON(c) {
+		server.writeLandingDocument(c)
+		return
+	}
+	// json strings are valid javascript, with <, > and & escaped so they cannot close the script
+	contextPath, _ := json.Marshal(server.Router.ContextPath)
+	c.Data(200, "text/html; charset=utf-8", bytes.Replace(webUIHTML, []byte("CONTEXT_PATH"), contextPath, 1))
+}
+
+func (server *MultiTenantServer) getChartVersionReadmeRequestHandler(c *gin.Context) {
+	server.getChartVersionFile(c, readmeFilenames...)
+}
+
+func (server *MultiTenantServer) getChartVersionValuesRequestHandler(c *gin.Context) {
+	server.getChartVersionFile(c, "values.yaml")
+}
+
+// getChartVersionFile serves a file of a chart package as text, empty if the chart has none
+func (server *MultiTenantServer) getChartVersionFile(c *gin.Context, filenames ...string) {
+	filename := cm_repo.ChartPackageFilenameFromNameVersion(c.Param("name"), c.Param("version"))
+	storageObject, err := server.findStorageObject(c, c.Param("repo"), filename)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	content, fileErr := cm_repo.ChartFileFromContent(storageObject.Content, filenames...)
+	if fileErr != nil {
+		cm_router.WriteError(c, http.StatusInternalServerError, cm_router.ErrorCodeInvalidChart, fileErr.Error())
+		return
+	}
+	c.Data(200, "text/plain; charset=utf-8", content)
+}
@@ -17,7 +17,10 @@ limitations under the License.
 package chartmuseum
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -25,6 +28,7 @@ import (
 	"github.com/chartmuseum/storage"
 	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -59,6 +63,48 @@ func (suite *ServerTestSuite) TestNewServer() {
 	multiTenantServer, err := NewServer(serverOptions)
 	suite.NotNil(multiTenantServer)
 	suite.Nil(err)
+
+	serverOptions.Depth = -1
+	_, err = NewServer(serverOptions)
+	suite.NotNil(err, "error with negative depth")
+
+	serverOptions.Depth = 0
+	serverOptions.StatsdAddress = "localhost:8125"
+	_, err = NewServer(serverOptions)
+	suite.NotNil(err, "error with statsd and metrics disabled")
+}
+
+func (suite *ServerTestSuite) TestHandler() {
+	log, err := cm_logger.NewLogger(cm_logger.LoggerOptions{})
+	suite.Nil(err, "no error creating logger")
+
+	var paths []string
+	server, err := NewServer(ServerOptions{
+		StorageBackend: suite.Backend,
+		Logger:         log,
+	}, WithMiddleware(func(c *gin.Context) {
+		paths = append(paths, c.Request.URL.Path)
+		c.Header("X-Embedded", "true")
+	}), WithMiddleware(func(c *gin.Context) {
+		if c.Request.URL.Path == "/blocked" {
+			c.AbortWithStatus(http.StatusTeapot)
+		}
+	}))
+	suite.Nil(err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/charts/", http.StripPrefix("/charts", server.Handler()))
+	for path, status := range map[string]int{"/charts/health": 200, "/charts/blocked": http.StatusTeapot} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", path, nil)
+		mux.ServeHTTP(recorder, request)
+		suite.Equal(status, recorder.Code, "status of GET %s", path)
+		suite.Equal("true", recorder.Header().Get("X-Embedded"), "middleware run for GET %s", path)
+	}
+	suite.ElementsMatch([]string{"/health", "/blocked"}, paths, "middleware run once per request")
+
+	server.Shutdown(context.Background())
+	server.Shutdown(context.Background())
 }
 
 func TestServerTestSuite(t *testing.T) {
@@ -21,7 +21,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/spf13/viper"
 	"github.com/urfave/cli"
@@ -75,6 +74,8 @@ func (conf *Config) UpdateFromCLIContext(c *cli.Context) error {
 					conf.Set(key, c.Bool(name))
 				case durationType:
 					conf.Set(key, c.Duration(name))
+				case floatType:
+					conf.Set(key, c.Float64(name))
 				}
 			}
 		}
@@ -97,21 +98,31 @@ func (conf *Config) readConfigFileFromCLIContext(c *cli.Context) error {
 			return errors.New(fmt.Sprintf("config file \"%s\" does not exist", confFilePath))
 		}
 
-		ext := filepath.Ext(confFilePath)
-		if ext != ".yaml" && ext != ".yml" && ext != "" {
-			return errors.New("config file must have .yaml/.yml extension (or no extension)")
+		switch filepath.Ext(confFilePath) {
+		case ".yaml", ".yml", "":
+			conf.SetConfigType("yaml")
+		case ".toml":
+			conf.SetConfigType("toml")
+		default:
+			return errors.New("config file must have .yaml/.yml/.toml extension (or no extension)")
 		}
 
-		base := strings.TrimSuffix(filepath.Base(confFilePath), ext)
-		dir := filepath.Dir(confFilePath)
-		conf.SetConfigName(base)
-		conf.AddConfigPath(dir)
+		conf.SetConfigFile(confFilePath)
 		return conf.ReadInConfig()
 	}
 
 	return nil
 }
 
+// Reload reads the config file again, if there is one. Settings from CLI flags and
+// environment variables still take precedence over the file
+func (conf *Config) Reload() error {
+	if conf.ConfigFileUsed() == "" {
+		return errors.New("no config file to reload")
+	}
+	return conf.ReadInConfig()
+}
+
 func (conf *Config) setDefaults() {
 	for key, configVar := range configVars {
 		conf.SetDefault(key, configVar.Default)
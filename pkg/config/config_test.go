@@ -131,6 +131,46 @@ func (suite *ConfigTestSuite) TestUpdateFromCLIContext() {
 	suite.Equal("mypass", conf.GetString("basicauth.pass"))
 }
 
+func (suite *ConfigTestSuite) TestReload() {
+	tomlConfigFile := pathutil.Join(suite.TempDirectory, "chartmuseum.toml")
+	err := ioutil.WriteFile(tomlConfigFile, []byte(`
+debug = true
+
+[basicauth]
+user = "myuser"
+pass = "mypass"
+`), 0644)
+	suite.Nil(err, "no error creating toml config file")
+
+	conf := NewConfig()
+	suite.NotNil(conf.Reload(), "error reloading without config file")
+
+	c := getNewContext()
+	c.Set("config", tomlConfigFile)
+	c.Set("basic-auth-pass", "flagpass")
+	err = conf.UpdateFromCLIContext(c)
+	suite.Nil(err, "no error reading toml config file")
+	suite.Equal(true, conf.GetBool("debug"))
+	suite.Equal("myuser", conf.GetString("basicauth.user"))
+	suite.Equal("flagpass", conf.GetString("basicauth.pass"), "flag overrides config file")
+
+	err = ioutil.WriteFile(tomlConfigFile, []byte(`
+[basicauth]
+user = "otheruser"
+pass = "otherpass"
+`), 0644)
+	suite.Nil(err, "no error updating toml config file")
+	err = conf.Reload()
+	suite.Nil(err, "no error reloading config file")
+	suite.Equal(false, conf.GetBool("debug"), "setting removed from config file back to default")
+	suite.Equal("otheruser", conf.GetString("basicauth.user"), "setting changed in config file")
+	suite.Equal("flagpass", conf.GetString("basicauth.pass"), "flag still overrides config file")
+
+	err = ioutil.WriteFile(tomlConfigFile, []byte(`debug = `), 0644)
+	suite.Nil(err, "no error breaking toml config file")
+	suite.NotNil(conf.Reload(), "error reloading invalid config file")
+}
+
 func getNewContext() *cli.Context {
 	var c *cli.Context
 	app := cli.NewApp()
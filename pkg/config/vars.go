@@ -41,6 +41,7 @@ var (
 	intType      configVarType = "int"
 	boolType     configVarType = "bool"
 	durationType configVarType = "time.Duration"
+	floatType    configVarType = "float64"
 )
 
 var configVars = map[string]configVar{
@@ -53,6 +54,15 @@ var configVars = map[string]configVar{
 			EnvVar: "GEN_INDEX",
 		},
 	},
+	"validateconfig": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "validate-config",
+			Usage:  "check the configuration, storage access and tls files, print the errors found and exit",
+			EnvVar: "VALIDATE_CONFIG",
+		},
+	},
 	"debug": {
 		Type:    boolType,
 		Default: false,
@@ -71,12 +81,66 @@ var configVars = map[string]configVar{
 			EnvVar: "LOG_JSON",
 		},
 	},
+	"log.output": {
+		Type:    stringType,
+		Default: "stderr",
+		CLIFlag: cli.StringFlag{
+			Name:   "log-output",
+			Usage:  "stderr, stdout, syslog or the path of the file logs are written to",
+			EnvVar: "LOG_OUTPUT",
+		},
+	},
+	"log.maxsize": {
+		Type:    intType,
+		Default: 100,
+		CLIFlag: cli.IntFlag{
+			Name:   "log-max-size",
+			Usage:  "size in megabytes above which the log file is rotated, never if 0",
+			EnvVar: "LOG_MAX_SIZE",
+		},
+	},
+	"log.rotateinterval": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "log-rotate-interval",
+			Usage:  "how long the log file is written to before being rotated, e.g. 24h, never if 0",
+			EnvVar: "LOG_ROTATE_INTERVAL",
+		},
+	},
+	"log.maxbackups": {
+		Type:    intType,
+		Default: 5,
+		CLIFlag: cli.IntFlag{
+			Name:   "log-max-backups",
+			Usage:  "number of rotated log files kept",
+			EnvVar: "LOG_MAX_BACKUPS",
+		},
+	},
+	"log.syslogaddress": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "log-syslog-address",
+			Usage:  "syslog server logs are sent to with --log-output=syslog, e.g. udp://localhost:514, the local one if not set",
+			EnvVar: "LOG_SYSLOG_ADDRESS",
+		},
+	},
+	"log.syslogtag": {
+		Type:    stringType,
+		Default: "chartmuseum",
+		CLIFlag: cli.StringFlag{
+			Name:   "log-syslog-tag",
+			Usage:  "tag of the messages sent to syslog",
+			EnvVar: "LOG_SYSLOG_TAG",
+		},
+	},
 	"loghealth": {
 		Type:    boolType,
 		Default: false,
 		CLIFlag: cli.BoolFlag{
 			Name:   "log-health",
-			Usage:  "log inbound /health requests",
+			Usage:  "log inbound /health, /live and /ready requests",
 			EnvVar: "LOG_HEALTH",
 		},
 	},
@@ -89,6 +153,15 @@ var configVars = map[string]configVar{
 			EnvVar: "LOG_LATENCY_INTEGER",
 		},
 	},
+	"loguploadprogress": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "log-upload-progress",
+			Usage:  "log the progress of request bodies, such as chart uploads, every so many bytes received, and their throughput once received (0 to disable)",
+			EnvVar: "LOG_UPLOAD_PROGRESS",
+		},
+	},
 	"disablemetrics": {
 		Type:    boolType,
 		Default: false,
@@ -98,6 +171,42 @@ var configVars = map[string]configVar{
 			EnvVar: "DISABLE_METRICS",
 		},
 	},
+	"metricsmaxtenants": {
+		Type:    intType,
+		Default: 100,
+		CLIFlag: cli.IntFlag{
+			Name:   "metrics-max-tenants",
+			Usage:  "max number of tenants with their own repo label in Prometheus metrics (0 for no limit)",
+			EnvVar: "METRICS_MAX_TENANTS",
+		},
+	},
+	"metricsbasicauthuser": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "metrics-basic-auth-user",
+			Usage:  "username for basic http authentication of /metrics, apart from the repos",
+			EnvVar: "METRICS_BASIC_AUTH_USER",
+		},
+	},
+	"metricsbasicauthpass": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "metrics-basic-auth-pass",
+			Usage:  "password for basic http authentication of /metrics",
+			EnvVar: "METRICS_BASIC_AUTH_PASS",
+		},
+	},
+	"metricsbearertoken": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "metrics-bearer-token",
+			Usage:  "bearer token required to get /metrics, apart from the repos",
+			EnvVar: "METRICS_BEARER_TOKEN",
+		},
+	},
 	"disableapi": {
 		Type:    boolType,
 		Default: false,
@@ -125,6 +234,24 @@ var configVars = map[string]configVar{
 			EnvVar: "DISABLE_STATEFILES",
 		},
 	},
+	"metadatacache": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "metadata-cache",
+			Usage:  "cache parsed chart metadata by package digest to speed up index regeneration",
+			EnvVar: "METADATA_CACHE",
+		},
+	},
+	"persistmetadatacache": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "persist-metadata-cache",
+			Usage:  "save the chart metadata cache to storage as metadata-cache.yaml",
+			EnvVar: "PERSIST_METADATA_CACHE",
+		},
+	},
 	"allowoverwrite": {
 		Type:    boolType,
 		Default: false,
@@ -143,6 +270,24 @@ var configVars = map[string]configVar{
 			EnvVar: "DISABLE_FORCE_OVERWRITE",
 		},
 	},
+	"forceoverwrite.users": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "force-overwrite-users",
+			Usage:  "comma-separated users who may re-upload chart versions with ?force querystring, others getting 403 (default anyone)",
+			EnvVar: "FORCE_OVERWRITE_USERS",
+		},
+	},
+	"idempotentuploads": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "idempotent-uploads",
+			Usage:  "answer uploads of chart versions already stored with the same content with a 200 instead of a 409",
+			EnvVar: "IDEMPOTENT_UPLOADS",
+		},
+	},
 	"port": {
 		Type:    intType,
 		Default: 8080,
@@ -170,6 +315,51 @@ var configVars = map[string]configVar{
 			EnvVar: "WRITE_TIMEOUT",
 		},
 	},
+	"idletimeout": {
+		Type:    intType,
+		Default: 120,
+		CLIFlag: cli.IntFlag{
+			Name:   "idle-timeout",
+			Usage:  "seconds a keep-alive connection may stay idle",
+			EnvVar: "IDLE_TIMEOUT",
+		},
+	},
+	"readheadertimeout": {
+		Type:    intType,
+		Default: 10,
+		CLIFlag: cli.IntFlag{
+			Name:   "read-header-timeout",
+			Usage:  "seconds given to clients to send request headers",
+			EnvVar: "READ_HEADER_TIMEOUT",
+		},
+	},
+	"maxheaderbytes": {
+		Type:    intType,
+		Default: 1 << 20,
+		CLIFlag: cli.IntFlag{
+			Name:   "max-header-bytes",
+			Usage:  "max size of request headers in bytes",
+			EnvVar: "MAX_HEADER_BYTES",
+		},
+	},
+	"shutdowntimeout": {
+		Type:    intType,
+		Default: 30,
+		CLIFlag: cli.IntFlag{
+			Name:   "shutdown-timeout",
+			Usage:  "seconds given to in-flight requests and index writes to finish on SIGTERM",
+			EnvVar: "SHUTDOWN_TIMEOUT",
+		},
+	},
+	"requesttimeout": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "request-timeout",
+			Usage:  "seconds after which a request is abandoned with a 504, 0 for no deadline",
+			EnvVar: "REQUEST_TIMEOUT",
+		},
+	},
 	"charturl": {
 		Type:    stringType,
 		Default: "",
@@ -179,6 +369,15 @@ var configVars = map[string]configVar{
 			EnvVar: "CHART_URL",
 		},
 	},
+	"charturltemplate": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "chart-url-template",
+			Usage:  "template for .tgz urls in index.yaml, built from each request (e.g. \"{scheme}://{host}/{tenant}/charts\")",
+			EnvVar: "CHART_URL_TEMPLATE",
+		},
+	},
 	"basicauth.user": {
 		Type:    stringType,
 		Default: "",
@@ -206,6 +405,15 @@ var configVars = map[string]configVar{
 			EnvVar: "AUTH_ANONYMOUS_GET",
 		},
 	},
+	"authanonymousmethods": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "auth-anonymous-methods",
+			Usage:  "comma-separated methods of the routes writing to repos allowed without credentials when auth is used, e.g. POST",
+			EnvVar: "AUTH_ANONYMOUS_METHODS",
+		},
+	},
 	"tls.cert": {
 		Type:    stringType,
 		Default: "",
@@ -233,6 +441,87 @@ var configVars = map[string]configVar{
 			EnvVar: "TLS_CA_CERT",
 		},
 	},
+	"tls.minversion": {
+		Type:    stringType,
+		Default: "1.2",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-min-version",
+			Usage:  "minimum tls version accepted, one of 1.0, 1.1, 1.2 or 1.3",
+			EnvVar: "TLS_MIN_VERSION",
+		},
+	},
+	"tls.ciphersuites": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-cipher-suites",
+			Usage:  "comma-separated tls cipher suites accepted below tls 1.3, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			EnvVar: "TLS_CIPHER_SUITES",
+		},
+	},
+	"tls.auto": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "tls-auto",
+			Usage:  "obtain and renew tls certificates automatically with ACME, e.g. from Let's Encrypt",
+			EnvVar: "TLS_AUTO",
+		},
+	},
+	"tls.autohost": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-auto-host",
+			Usage:  "comma-separated hosts to obtain certificates for with --tls-auto",
+			EnvVar: "TLS_AUTO_HOST",
+		},
+	},
+	"tls.autoemail": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-auto-email",
+			Usage:  "contact email of the ACME account, notified about expiring certificates",
+			EnvVar: "TLS_AUTO_EMAIL",
+		},
+	},
+	"tls.autodirectoryurl": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-auto-directory-url",
+			Usage:  "directory url of the ACME server, Let's Encrypt production by default",
+			EnvVar: "TLS_AUTO_DIRECTORY_URL",
+		},
+	},
+	"tls.httpport": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "tls-http-port",
+			Usage:  "plain http port listened on besides the tls one, redirecting to https",
+			EnvVar: "TLS_HTTP_PORT",
+		},
+	},
+	"tls.httpmode": {
+		Type:    stringType,
+		Default: "redirect",
+		CLIFlag: cli.StringFlag{
+			Name:   "tls-http-mode",
+			Usage:  "what the --tls-http-port serves, redirect to redirect every request to https, health to serve only the health checks",
+			EnvVar: "TLS_HTTP_MODE",
+		},
+	},
+	"disablehttp2": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "disable-http2",
+			Usage:  "serve only http/1.1 over tls",
+			EnvVar: "DISABLE_HTTP2",
+		},
+	},
 	"cache.store": {
 		Type:    stringType,
 		Default: "",
@@ -270,6 +559,133 @@ var configVars = map[string]configVar{
 			Value:  0,
 		},
 	},
+	"lock.backend": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "lock-backend",
+			Usage:  "distributed lock serializing the writes of replicas sharing storage, can be one of: redis, dynamodb",
+			EnvVar: "LOCK_BACKEND",
+		},
+	},
+	"lock.ttl": {
+		Type:    durationType,
+		Default: 30 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "lock-ttl",
+			Usage:  "how long a lock outlives a replica which stopped without releasing it",
+			EnvVar: "LOCK_TTL",
+		},
+	},
+	"lock.timeout": {
+		Type:    durationType,
+		Default: 30 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "lock-timeout",
+			Usage:  "how long a write waits for the lock of its repo before failing with a 503",
+			EnvVar: "LOCK_TIMEOUT",
+		},
+	},
+	"lock.redis.addr": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "lock-redis-addr",
+			Usage:  "address of Redis service holding the locks (host:port)",
+			EnvVar: "LOCK_REDIS_ADDR",
+		},
+	},
+	"lock.redis.password": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "lock-redis-password",
+			Usage:  "Redis requirepass server configuration",
+			EnvVar: "LOCK_REDIS_PASSWORD",
+		},
+	},
+	"lock.redis.db": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "lock-redis-db",
+			Usage:  "Redis database to be selected after connect",
+			EnvVar: "LOCK_REDIS_DB",
+			Value:  0,
+		},
+	},
+	"lock.dynamodb.table": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "lock-dynamodb-table",
+			Usage:  "DynamoDB table holding the locks, with LockID as string partition key",
+			EnvVar: "LOCK_DYNAMODB_TABLE",
+		},
+	},
+	"lock.dynamodb.region": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "lock-dynamodb-region",
+			Usage:  "region of the DynamoDB table",
+			EnvVar: "LOCK_DYNAMODB_REGION",
+		},
+	},
+	"lock.dynamodb.endpoint": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "lock-dynamodb-endpoint",
+			Usage:  "alternative DynamoDB endpoint",
+			EnvVar: "LOCK_DYNAMODB_ENDPOINT",
+		},
+	},
+	"leaderelection.backend": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "leader-election",
+			Usage:  "elect the replica running background jobs, can be one of: lock (the lease of --lock-backend), kubernetes",
+			EnvVar: "LEADER_ELECTION",
+		},
+	},
+	"leaderelection.name": {
+		Type:    stringType,
+		Default: "chartmuseum",
+		CLIFlag: cli.StringFlag{
+			Name:   "leader-election-name",
+			Usage:  "name of the lease shared by the replicas",
+			EnvVar: "LEADER_ELECTION_NAME",
+		},
+	},
+	"leaderelection.id": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "leader-election-id",
+			Usage:  "identity of the replica holding the lease, the hostname by default",
+			EnvVar: "LEADER_ELECTION_ID",
+		},
+	},
+	"leaderelection.namespace": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "leader-election-namespace",
+			Usage:  "namespace of the Kubernetes lease, the namespace of the pod by default",
+			EnvVar: "LEADER_ELECTION_NAMESPACE",
+		},
+	},
+	"leaderelection.ttl": {
+		Type:    durationType,
+		Default: 15 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "leader-election-ttl",
+			Usage:  "how long the leader keeps the lease without renewing it",
+			EnvVar: "LEADER_ELECTION_TTL",
+		},
+	},
 	"storage.backend": {
 		Type:    stringType,
 		Default: "",
@@ -668,6 +1084,42 @@ var configVars = map[string]configVar{
 			EnvVar: "INDEX_LIMIT",
 		},
 	},
+	"index.regenerationlimit": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "index-regeneration-limit",
+			Usage:  "max number of repo indexes regenerated at once across tenants, cached indexes being served beyond it",
+			EnvVar: "INDEX_REGENERATION_LIMIT",
+		},
+	},
+	"indexsharding": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "index-sharding",
+			Usage:  "split index.yaml into shard files by chart name (\"alpha\" or \"hash\")",
+			EnvVar: "INDEX_SHARDING",
+		},
+	},
+	"indexshards": {
+		Type:    intType,
+		Default: 16,
+		CLIFlag: cli.IntFlag{
+			Name:   "index-shards",
+			Usage:  "number of shards used with --index-sharding=hash",
+			EnvVar: "INDEX_SHARDS",
+		},
+	},
+	"indexjournal": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "index-journal",
+			Usage:  "journal the changes of the index of each repo, to serve index.yaml as of a time with ?at",
+			EnvVar: "INDEX_JOURNAL",
+		},
+	},
 	"contextpath": {
 		Type:    stringType,
 		Default: "",
@@ -731,6 +1183,15 @@ var configVars = map[string]configVar{
 			EnvVar: "AUTH_ACTIONS_SEARCH_PATH",
 		},
 	},
+	"kubeauthconfig": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "kube-auth-config",
+			Usage:  "path to a file of the Kubernetes service accounts whose tokens are accepted as credentials, and the repos they are granted",
+			EnvVar: "KUBE_AUTH_CONFIG",
+		},
+	},
 	"depthdynamic": {
 		Type:    boolType,
 		Default: false,
@@ -749,25 +1210,772 @@ var configVars = map[string]configVar{
 			EnvVar: "CORS_ALLOW_ORIGIN",
 		},
 	},
-	"enforce-semver2": {
+	"responseheaders": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "response-headers",
+			Usage:  "comma-separated headers set on responses, e.g. X-Content-Type-Options: nosniff, prefixed with api=, repo= or oci= for a group of routes only",
+			EnvVar: "RESPONSE_HEADERS",
+		},
+	},
+	"compression.enabled": {
 		Type:    boolType,
 		Default: false,
 		CLIFlag: cli.BoolFlag{
-			Name:   "enforce-semver2",
-			Usage:  "(deprecated) enforce the chart museum server only accepts the valid chart version as Helm does",
-			EnvVar: "ENFORCE_SEMVER2",
+			Name:   "compression",
+			Usage:  "gzip responses such as index.yaml and the api responses for clients accepting it",
+			EnvVar: "COMPRESSION",
 		},
-		Deprecated: true,
 	},
-	"cacheinterval": {
-		Type:    durationType,
-		Default: time.Duration(0),
+	"compression.minsize": {
+		Type:    intType,
+		Default: 1024,
+		CLIFlag: cli.IntFlag{
+			Name:   "compression-min-size",
+			Usage:  "size in bytes under which responses are not compressed",
+			EnvVar: "COMPRESSION_MIN_SIZE",
+		},
+	},
+	"compression.types": {
+		Type:    stringType,
+		Default: "application/x-yaml,application/json,text/html,text/plain",
+		CLIFlag: cli.StringFlag{
+			Name:   "compression-types",
+			Usage:  "comma-separated content types of the responses compressed",
+			EnvVar: "COMPRESSION_TYPES",
+		},
+	},
+	"enforce-semver2": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "enforce-semver2",
+			Usage:  "(deprecated) enforce the chart museum server only accepts the valid chart version as Helm does",
+			EnvVar: "ENFORCE_SEMVER2",
+		},
+		Deprecated: true,
+	},
+	"cacheinterval": {
+		Type:    durationType,
+		Default: time.Duration(0),
 		CLIFlag: cli.DurationFlag{
 			Name:   "cache-interval",
 			Usage:  "set the interval of delta updating the cache",
 			EnvVar: "CACHE_INTERVAL",
 		},
 	},
+	"stalewhilerevalidate": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "stale-while-revalidate",
+			Usage:  "serve the cached index.yaml immediately and refresh it from storage in the background",
+			EnvVar: "STALE_WHILE_REVALIDATE",
+		},
+	},
+	"maxstaleness": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "max-staleness",
+			Usage:  "with --stale-while-revalidate, max age of a cached index.yaml before requests wait for a refresh (0 for no limit)",
+			EnvVar: "MAX_STALENESS",
+		},
+	},
+	"tenantconfig": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tenant-config",
+			Usage:  "path to a file of settings overridden per tenant",
+			EnvVar: "TENANT_CONFIG",
+		},
+	},
+	"tenanthostpattern": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tenant-host-pattern",
+			Usage:  "resolve the tenant from the request host, e.g. {tenant}.charts.example.com",
+			EnvVar: "TENANT_HOST_PATTERN",
+		},
+	},
+	"enabletenantapi": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "enable-tenant-api",
+			Usage:  "enable the /api/tenants routes to create, list and delete tenants",
+			EnvVar: "ENABLE_TENANT_API",
+		},
+	},
+	"webhookurls": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "webhook-urls",
+			Usage:  "comma-separated URLs notified of chart uploads, deletions and index regenerations",
+			EnvVar: "WEBHOOK_URLS",
+		},
+	},
+	"webhooksecret": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "webhook-secret",
+			Usage:  "secret used to sign webhook payloads with HMAC-SHA256",
+			EnvVar: "WEBHOOK_SECRET",
+		},
+	},
+	"webhookretries": {
+		Type:    intType,
+		Default: 3,
+		CLIFlag: cli.IntFlag{
+			Name:   "webhook-retries",
+			Usage:  "number of times a failed webhook delivery is retried",
+			EnvVar: "WEBHOOK_RETRIES",
+		},
+	},
+	"events.nats.url": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-nats-url",
+			Usage:  "NATS server chart events are published to (nats://[user[:pass]@]host:port)",
+			EnvVar: "EVENTS_NATS_URL",
+		},
+	},
+	"events.nats.subject": {
+		Type:    stringType,
+		Default: "chartmuseum.events",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-nats-subject",
+			Usage:  "NATS subject chart events are published to",
+			EnvVar: "EVENTS_NATS_SUBJECT",
+		},
+	},
+	"events.kafka.brokers": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-kafka-brokers",
+			Usage:  "comma-separated Kafka brokers (host:port) chart events are published to",
+			EnvVar: "EVENTS_KAFKA_BROKERS",
+		},
+	},
+	"events.kafka.topic": {
+		Type:    stringType,
+		Default: "chartmuseum-events",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-kafka-topic",
+			Usage:  "Kafka topic chart events are published to",
+			EnvVar: "EVENTS_KAFKA_TOPIC",
+		},
+	},
+	"events.kafka.tls": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "events-kafka-tls",
+			Usage:  "connect to the Kafka brokers over tls",
+			EnvVar: "EVENTS_KAFKA_TLS",
+		},
+	},
+	"events.kafka.cacert": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-kafka-cacert",
+			Usage:  "path to the CA cert file verifying the Kafka brokers (requires --events-kafka-tls)",
+			EnvVar: "EVENTS_KAFKA_CACERT",
+		},
+	},
+	"events.kafka.sasl.mechanism": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-kafka-sasl-mechanism",
+			Usage:  "SASL mechanism authenticating to the Kafka brokers (plain, scram-sha-256 or scram-sha-512)",
+			EnvVar: "EVENTS_KAFKA_SASL_MECHANISM",
+		},
+	},
+	"events.kafka.sasl.username": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-kafka-sasl-username",
+			Usage:  "SASL username for the Kafka brokers",
+			EnvVar: "EVENTS_KAFKA_SASL_USERNAME",
+		},
+	},
+	"events.kafka.sasl.password": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "events-kafka-sasl-password",
+			Usage:  "SASL password for the Kafka brokers",
+			EnvVar: "EVENTS_KAFKA_SASL_PASSWORD",
+		},
+	},
+	"alert.storagebytes": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "alert-storage-bytes",
+			Usage:  "size in bytes of the objects of a repo above which an alert is sent, never if 0",
+			EnvVar: "ALERT_STORAGE_BYTES",
+		},
+	},
+	"alert.chartversions": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "alert-chart-versions",
+			Usage:  "number of chart versions of a repo above which an alert is sent, never if 0",
+			EnvVar: "ALERT_CHART_VERSIONS",
+		},
+	},
+	"alert.indexbytes": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "alert-index-bytes",
+			Usage:  "size in bytes of the index.yaml of a repo above which an alert is sent, never if 0",
+			EnvVar: "ALERT_INDEX_BYTES",
+		},
+	},
+	"alert.errorrate": {
+		Type:    floatType,
+		Default: 0.0,
+		CLIFlag: cli.Float64Flag{
+			Name:   "alert-error-rate",
+			Usage:  "share of 5xx responses to the requests of a repo, from 0 to 1, above which an alert is sent, never if 0",
+			EnvVar: "ALERT_ERROR_RATE",
+		},
+	},
+	"alert.interval": {
+		Type:    durationType,
+		Default: time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "alert-interval",
+			Usage:  "how often the alert thresholds are checked, the error rate being that of the last interval",
+			EnvVar: "ALERT_INTERVAL",
+		},
+	},
+	"alert.slackurl": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "alert-slack-url",
+			Usage:  "Slack incoming webhook URL alerts are posted to",
+			EnvVar: "ALERT_SLACK_URL",
+		},
+	},
+	"enableoci": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "enable-oci",
+			Usage:  "serve charts with the OCI distribution api, for helm push and pull with oci:// urls",
+			EnvVar: "ENABLE_OCI",
+		},
+	},
+	"webui": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "web-ui",
+			Usage:  "serve a web ui browsing the charts, their readmes and values at the root",
+			EnvVar: "WEB_UI",
+		},
+	},
+	"landing.json": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "landing-json",
+			Usage:  "serve the version, features and repos of the server as json at the root, instead of the welcome page",
+			EnvVar: "LANDING_JSON",
+		},
+	},
+	"landing.template": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "landing-template",
+			Usage:  "path to an html/template file rendering the version, features and repos of the server at the root",
+			EnvVar: "LANDING_TEMPLATE",
+		},
+	},
+	"pprof.enabled": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "pprof",
+			Usage:  "serve net/http/pprof and a dump of the server state under /api/admin/debug",
+			EnvVar: "PPROF",
+		},
+	},
+	"pprof.mutexprofilefraction": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "pprof-mutex-profile-fraction",
+			Usage:  "sample 1 in n mutex contention events for the mutex profile, 0 to sample none",
+			EnvVar: "PPROF_MUTEX_PROFILE_FRACTION",
+		},
+	},
+	"tracing.endpoint": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "tracing-endpoint",
+			Usage:  "OTLP/HTTP collector receiving traces of requests, storage calls and index regenerations (e.g. http://localhost:4318)",
+			EnvVar: "TRACING_ENDPOINT",
+		},
+	},
+	"tracing.servicename": {
+		Type:    stringType,
+		Default: "chartmuseum",
+		CLIFlag: cli.StringFlag{
+			Name:   "tracing-service-name",
+			Usage:  "service name of traces sent to the tracing endpoint",
+			EnvVar: "TRACING_SERVICE_NAME",
+		},
+	},
+	"tracing.sampleratio": {
+		Type:    floatType,
+		Default: 1.0,
+		CLIFlag: cli.Float64Flag{
+			Name:   "tracing-sample-ratio",
+			Usage:  "share of traces started by the server which are recorded, from 0 to 1",
+			EnvVar: "TRACING_SAMPLE_RATIO",
+		},
+	},
+	"accesslog.enabled": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "access-log",
+			Usage:  "write a line per request served, apart from the application logs",
+			EnvVar: "ACCESS_LOG",
+		},
+	},
+	"accesslog.output": {
+		Type:    stringType,
+		Default: "stdout",
+		CLIFlag: cli.StringFlag{
+			Name:   "access-log-output",
+			Usage:  "stdout, stderr or the path of the file access logs are written to",
+			EnvVar: "ACCESS_LOG_OUTPUT",
+		},
+	},
+	"accesslog.format": {
+		Type:    stringType,
+		Default: "json",
+		CLIFlag: cli.StringFlag{
+			Name:   "access-log-format",
+			Usage:  "format of access logs, json or combined (Apache combined log format)",
+			EnvVar: "ACCESS_LOG_FORMAT",
+		},
+	},
+	"accesslog.fields": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "access-log-fields",
+			Usage:  "comma-separated fields of json access logs, e.g. time,tenant,user,chart,latency, all if not set",
+			EnvVar: "ACCESS_LOG_FIELDS",
+		},
+	},
+	"accesslog.maxsize": {
+		Type:    intType,
+		Default: 100,
+		CLIFlag: cli.IntFlag{
+			Name:   "access-log-max-size",
+			Usage:  "size in megabytes above which the access log file is rotated, never if 0",
+			EnvVar: "ACCESS_LOG_MAX_SIZE",
+		},
+	},
+	"accesslog.maxbackups": {
+		Type:    intType,
+		Default: 5,
+		CLIFlag: cli.IntFlag{
+			Name:   "access-log-max-backups",
+			Usage:  "number of rotated access log files kept",
+			EnvVar: "ACCESS_LOG_MAX_BACKUPS",
+		},
+	},
+	"statsd.addr": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "statsd-addr",
+			Usage:  "host:port of a statsd or dogstatsd server receiving the metrics, e.g. localhost:8125",
+			EnvVar: "STATSD_ADDR",
+		},
+	},
+	"statsd.interval": {
+		Type:    durationType,
+		Default: 10 * time.Second,
+		CLIFlag: cli.DurationFlag{
+			Name:   "statsd-interval",
+			Usage:  "interval at which metrics are sent to the statsd server",
+			EnvVar: "STATSD_INTERVAL",
+		},
+	},
+	"statsd.tags": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "statsd-tags",
+			Usage:  "comma-separated tags added to every metric sent to the statsd server, e.g. env:prod",
+			EnvVar: "STATSD_TAGS",
+		},
+	},
+	"legacyerrorbodies": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "legacy-error-bodies",
+			Usage:  "return errors as {\"error\": message} instead of application/problem+json",
+			EnvVar: "LEGACY_ERROR_BODIES",
+		},
+	},
+	"proxy.upstream": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "proxy-upstream",
+			Usage:  "url of a chart repo proxied by the server, whose charts are cached in storage once pulled",
+			EnvVar: "PROXY_UPSTREAM",
+		},
+	},
+	"proxy.indexttl": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "proxy-index-ttl",
+			Usage:  "how long the index of the upstream repo is cached before fetching it again",
+			EnvVar: "PROXY_INDEX_TTL",
+		},
+	},
+	"replicationconfig": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "replication-config",
+			Usage:  "path to a file of chart repos periodically replicated into storage",
+			EnvVar: "REPLICATION_CONFIG",
+		},
+	},
+	"ingestionconfig": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "ingestion-config",
+			Usage:  "path to a file of GitHub and GitLab projects whose release charts are periodically imported into storage",
+			EnvVar: "INGESTION_CONFIG",
+		},
+	},
+	"trash.retention": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "trash-retention",
+			Usage:  "keep deleted chart versions in a trash for this long, restorable with the api (0 deletes them right away)",
+			EnvVar: "TRASH_RETENTION",
+		},
+	},
+	"maintenance.message": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "maintenance-message",
+			Usage:  "error returned for writes in maintenance mode, unless set when enabling it",
+			EnvVar: "MAINTENANCE_MESSAGE",
+		},
+	},
+	"scan.url": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "scan-url",
+			Usage:  "url of a vulnerability scanner uploaded charts are submitted to",
+			EnvVar: "SCAN_URL",
+		},
+	},
+	"scan.payload": {
+		Type:    stringType,
+		Default: "images",
+		CLIFlag: cli.StringFlag{
+			Name:   "scan-payload",
+			Usage:  "what is submitted to the scanner, the images a chart refers to (images) or its package (tarball)",
+			EnvVar: "SCAN_PAYLOAD",
+		},
+	},
+	"scan.severity": {
+		Type:    stringType,
+		Default: "HIGH",
+		CLIFlag: cli.StringFlag{
+			Name:   "scan-severity",
+			Usage:  "lowest severity of the findings failing a scan (LOW, MEDIUM, HIGH or CRITICAL)",
+			EnvVar: "SCAN_SEVERITY",
+		},
+	},
+	"scan.block": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "scan-block",
+			Usage:  "reject uploads failing their scan, instead of only recording the result",
+			EnvVar: "SCAN_BLOCK",
+		},
+	},
+	"scan.timeout": {
+		Type:    durationType,
+		Default: 5 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "scan-timeout",
+			Usage:  "timeout of the requests to the scanner",
+			EnvVar: "SCAN_TIMEOUT",
+		},
+	},
+	"index.annotations": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "index-annotations",
+			Usage:  "add the annotations set on chart versions with the api to index.yaml",
+			EnvVar: "INDEX_ANNOTATIONS",
+		},
+	},
+	"index.signingkey": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "index-signing-key",
+			Usage:  "path to a PGP keyring whose key signs index.yaml, served as index.yaml.asc",
+			EnvVar: "INDEX_SIGNING_KEY",
+		},
+	},
+	"index.signingkeyname": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "index-signing-key-name",
+			Usage:  "name or email of the key of --index-signing-key, the first private key of the keyring if not set",
+			EnvVar: "INDEX_SIGNING_KEY_NAME",
+		},
+	},
+	"index.signingpassphrase": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "index-signing-passphrase",
+			Usage:  "passphrase of the key of --index-signing-key, if encrypted",
+			EnvVar: "INDEX_SIGNING_PASSPHRASE",
+		},
+	},
+	"cachecontrol.index": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "cache-control-index",
+			Usage:  "Cache-Control header of index.yaml, e.g. \"public, max-age=60\"",
+			EnvVar: "CACHE_CONTROL_INDEX",
+		},
+	},
+	"cachecontrol.charts": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "cache-control-charts",
+			Usage:  "Cache-Control header of chart packages and provenance files, e.g. \"public, max-age=31536000, immutable\"",
+			EnvVar: "CACHE_CONTROL_CHARTS",
+		},
+	},
+	"charticons": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "chart-icons",
+			Usage:  "serve chart icons at /api/charts/<name>/<version>/icon and point the index to them, can be one of: package, proxy (also caching remote icons in storage)",
+			EnvVar: "CHART_ICONS",
+		},
+	},
+	"downloadlinks.secret": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "download-link-secret",
+			Usage:  "secret signing the expiring links of /api/charts/<name>/<version>/link, downloading a chart version without credentials",
+			EnvVar: "DOWNLOAD_LINK_SECRET",
+		},
+	},
+	"downloadlinks.maxttl": {
+		Type:    durationType,
+		Default: 24 * time.Hour,
+		CLIFlag: cli.DurationFlag{
+			Name:   "download-link-max-ttl",
+			Usage:  "longest ?ttl of download links",
+			EnvVar: "DOWNLOAD_LINK_MAX_TTL",
+		},
+	},
+	"downloadredirect.url": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "download-redirect-url",
+			Usage:  "redirect downloads of chart packages and provenance files to this url, e.g. https://cdn.example.com/{path}",
+			EnvVar: "DOWNLOAD_REDIRECT_URL",
+		},
+	},
+	"downloadredirect.presign": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "download-redirect-presign",
+			Usage:  "redirect downloads of chart packages and provenance files to presigned urls of the amazon storage bucket",
+			EnvVar: "DOWNLOAD_REDIRECT_PRESIGN",
+		},
+	},
+	"downloadredirect.ttl": {
+		Type:    durationType,
+		Default: 15 * time.Minute,
+		CLIFlag: cli.DurationFlag{
+			Name:   "download-redirect-ttl",
+			Usage:  "how long the presigned urls of --download-redirect-presign are valid",
+			EnvVar: "DOWNLOAD_REDIRECT_TTL",
+		},
+	},
+	"cache.primetenants": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "cache-prime-tenants",
+			Usage:  "comma-separated repos whose index is built at startup in multitenant mode, e.g. org1/repo1,org2/repo1",
+			EnvVar: "CACHE_PRIME_TENANTS",
+		},
+	},
+	"cache.primeall": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "cache-prime-all",
+			Usage:  "build the index of every repo found in storage at startup in multitenant mode",
+			EnvVar: "CACHE_PRIME_ALL",
+		},
+	},
+	"cache.ttl": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "cache-ttl",
+			Usage:  "drop the index of a repo from memory once unused for this long (0 for no limit)",
+			EnvVar: "CACHE_TTL",
+		},
+	},
+	"cache.maxentries": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "cache-max-entries",
+			Usage:  "max number of repo indexes held in memory, the least recently used being dropped beyond it (0 for no limit)",
+			EnvVar: "CACHE_MAX_ENTRIES",
+		},
+	},
+	"cache.apittl": {
+		Type:    durationType,
+		Default: time.Duration(0),
+		CLIFlag: cli.DurationFlag{
+			Name:   "cache-api-ttl",
+			Usage:  "cache the responses of GET /api/charts and GET /api/charts/<name> for this long at most, dropping them on writes (0 to disable)",
+			EnvVar: "CACHE_API_TTL",
+		},
+	},
+	"chartpolicy.name": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "chart-name-pattern",
+			Usage:  "regular expression the names of the charts uploaded must match, e.g. ^[a-z0-9-]+$",
+			EnvVar: "CHART_NAME_PATTERN",
+		},
+	},
+	"chartpolicy.version": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "chart-version-pattern",
+			Usage:  "regular expression the versions of the charts uploaded must match",
+			EnvVar: "CHART_VERSION_PATTERN",
+		},
+	},
+	"chartpolicy.strictsemver": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "strict-semver",
+			Usage:  "reject charts uploaded whose version is not strict semver 2.0, such as 1.0 or v1.0.0",
+			EnvVar: "STRICT_SEMVER",
+		},
+	},
+	"charthistory": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "chart-history",
+			Usage:  "record the uploads, overwrites and deletions of chart versions, served at /api/charts/<name>/history",
+			EnvVar: "CHART_HISTORY",
+		},
+	},
+	"owners.enabled": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "chart-owners",
+			Usage:  "record the user uploading a chart first as the owner of its name",
+			EnvVar: "CHART_OWNERS",
+		},
+	},
+	"owners.restrict": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "restrict-to-owners",
+			Usage:  "only let the owners of a chart and the admins push, delete or change it, implies --chart-owners",
+			EnvVar: "RESTRICT_TO_OWNERS",
+		},
+	},
+	"owners.admins": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "chart-admins",
+			Usage:  "comma-separated users who may change any chart with --restrict-to-owners",
+			EnvVar: "CHART_ADMINS",
+		},
+	},
+	"protectedcharts": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "protected-charts",
+			Usage:  "comma-separated chart names or regular expressions whose versions only --chart-admins may delete or overwrite, with the X-Force-Protected header",
+			EnvVar: "PROTECTED_CHARTS",
+		},
+	},
+	"trustedproxies": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "trusted-proxies",
+			Usage:  "comma-separated ips and cidrs of the proxies whose X-Forwarded-* headers are trusted, all if not set",
+			EnvVar: "TRUSTED_PROXIES",
+		},
+	},
 	"listen.host": {
 		Type:    stringType,
 		Default: "0.0.0.0",
@@ -777,6 +1985,33 @@ var configVars = map[string]configVar{
 			EnvVar: "LISTEN_HOST",
 		},
 	},
+	"adminport": {
+		Type:    intType,
+		Default: 0,
+		CLIFlag: cli.IntFlag{
+			Name:   "admin-port",
+			Usage:  "port serving /metrics, /health, /live, /ready and /api/admin routes instead of the main port",
+			EnvVar: "ADMIN_PORT",
+		},
+	},
+	"listen.unixsocket": {
+		Type:    stringType,
+		Default: "",
+		CLIFlag: cli.StringFlag{
+			Name:   "listen-unix-socket",
+			Usage:  "path of a unix socket to listen on instead of the host and port",
+			EnvVar: "LISTEN_UNIX_SOCKET",
+		},
+	},
+	"listen.proxyprotocol": {
+		Type:    boolType,
+		Default: false,
+		CLIFlag: cli.BoolFlag{
+			Name:   "listen-proxy-protocol",
+			Usage:  "read the client address from the PROXY protocol header sent by trusted proxies",
+			EnvVar: "LISTEN_PROXY_PROTOCOL",
+		},
+	},
 	"per-chart-limit": {
 		Type:    intType,
 		Default: 0,
@@ -792,7 +2027,7 @@ func populateCLIFlags() {
 	CLIFlags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "config, c",
-			Usage:  "chartmuseum configuration file",
+			Usage:  "chartmuseum configuration file (yaml or toml), reloaded on SIGHUP",
 			EnvVar: "CONFIG",
 		},
 	}
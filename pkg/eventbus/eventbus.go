@@ -0,0 +1,33 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package eventbus publishes chart events to message buses, as webhook.Publisher
+// implementations delivered by the webhook notifier
+package eventbus
+
+import (
+	"time"
+
+	"helm.sh/chartmuseum/pkg/webhook"
+)
+
+// defaultTimeout bounds connecting to and waiting for a message bus
+const defaultTimeout = 10 * time.Second
+
+var (
+	_ webhook.Publisher = &NATSPublisher{}
+	_ webhook.Publisher = &KafkaPublisher{}
+)
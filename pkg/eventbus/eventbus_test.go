@@ -0,0 +1,377 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventbus
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/segmentio/kafka-go/protocol/saslauthenticate"
+	"github.com/segmentio/kafka-go/protocol/saslhandshake"
+	"github.com/stretchr/testify/suite"
+)
+
+type EventbusTestSuite struct {
+	suite.Suite
+}
+
+type fakeMessage struct {
+	Subject   string
+	Partition int32
+	Key       string
+	Value     string
+}
+
+type fakeBroker struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	messages []fakeMessage
+	connects []string
+	conns    []net.Conn
+}
+
+func (broker *fakeBroker) Address() string {
+	return broker.listener.Addr().String()
+}
+
+func (broker *fakeBroker) Messages() []fakeMessage {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return append([]fakeMessage{}, broker.messages...)
+}
+
+func (broker *fakeBroker) record(message fakeMessage) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.messages = append(broker.messages, message)
+}
+
+// Close stops listening and drops the open connections
+func (broker *fakeBroker) Close() {
+	broker.listener.Close()
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for _, conn := range broker.conns {
+		conn.Close()
+	}
+}
+
+func (broker *fakeBroker) serve(handle func(net.Conn)) {
+	for {
+		conn, err := broker.listener.Accept()
+		if err != nil {
+			return
+		}
+		broker.mutex.Lock()
+		broker.conns = append(broker.conns, conn)
+		broker.mutex.Unlock()
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+func (suite *EventbusTestSuite) newBroker() *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Nil(err, "no error listening")
+	return &fakeBroker{listener: listener}
+}
+
+// natsServer answers the core NATS protocol, rejecting publishes to the "forbidden" subject
+func (suite *EventbusTestSuite) natsServer() *fakeBroker {
+	broker := suite.newBroker()
+	go broker.serve(func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "CONNECT":
+				broker.mutex.Lock()
+				broker.connects = append(broker.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT")))
+				broker.mutex.Unlock()
+			case fields[0] == "PUB" && len(fields) == 3:
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				if fields[1] == "forbidden" {
+					fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish to forbidden'\r\n")
+					continue
+				}
+				broker.record(fakeMessage{Subject: fields[1], Value: string(payload[:size])})
+			case fields[0] == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			}
+		}
+	})
+	return broker
+}
+
+// kafkaBroker answers the ApiVersions, Metadata and Produce requests of topics with two partitions,
+// after a SASL PLAIN authentication with credentials if set
+func (suite *EventbusTestSuite) kafkaBroker(listener net.Listener, credentials string) *fakeBroker {
+	broker := &fakeBroker{listener: listener}
+	go broker.serve(func(conn net.Conn) {
+		for {
+			apiVersion, correlationID, _, message, err := protocol.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			var response protocol.Message
+			switch request := message.(type) {
+			case *apiversions.Request:
+				response = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+					{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 2},
+					{ApiKey: int16(protocol.Metadata), MinVersion: 1, MaxVersion: 1},
+					{ApiKey: int16(protocol.Produce), MinVersion: 3, MaxVersion: 3},
+					{ApiKey: int16(protocol.SaslHandshake), MinVersion: 1, MaxVersion: 1},
+					{ApiKey: int16(protocol.SaslAuthenticate), MinVersion: 0, MaxVersion: 0},
+				}}
+			case *saslhandshake.Request:
+				response = &saslhandshake.Response{Mechanisms: []string{"PLAIN"}}
+			case *saslauthenticate.Request:
+				authenticated := &saslauthenticate.Response{}
+				if string(request.AuthBytes) != credentials {
+					authenticated.ErrorCode = 58 // sasl authentication failed
+					authenticated.ErrorMessage = "invalid credentials"
+				}
+				response = authenticated
+			case *metadata.Request:
+				host, port, _ := net.SplitHostPort(broker.Address())
+				portNumber, _ := strconv.Atoi(port)
+				names := request.TopicNames
+				if names == nil {
+					names = []string{"chartmuseum-events"} // all topics
+				}
+				topics := []metadata.ResponseTopic{}
+				for _, name := range names {
+					topics = append(topics, metadata.ResponseTopic{Name: name, Partitions: []metadata.ResponsePartition{
+						{PartitionIndex: 0, LeaderID: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}},
+						{PartitionIndex: 1, LeaderID: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}},
+					}})
+				}
+				response = &metadata.Response{
+					Brokers:      []metadata.ResponseBroker{{NodeID: 1, Host: host, Port: int32(portNumber)}},
+					ControllerID: 1,
+					Topics:       topics,
+				}
+			case *produce.Request:
+				produced := &produce.Response{}
+				for _, topic := range request.Topics {
+					partitions := []produce.ResponsePartition{}
+					for _, partition := range topic.Partitions {
+						for {
+							record, err := partition.RecordSet.Records.ReadRecord()
+							if err != nil {
+								break
+							}
+							key, _ := protocol.ReadAll(record.Key)
+							value, _ := protocol.ReadAll(record.Value)
+							broker.record(fakeMessage{Subject: topic.Topic, Partition: partition.Partition, Key: string(key), Value: string(value)})
+						}
+						partitions = append(partitions, produce.ResponsePartition{Partition: partition.Partition})
+					}
+					produced.Topics = append(produced.Topics, produce.ResponseTopic{Topic: topic.Topic, Partitions: partitions})
+				}
+				response = produced
+			default:
+				return
+			}
+			if err := protocol.WriteResponse(conn, apiVersion, correlationID, response); err != nil {
+				return
+			}
+		}
+	})
+	return broker
+}
+
+// tlsListener listens with a certificate for 127.0.0.1, written as a pem file to caCert
+func (suite *EventbusTestSuite) tlsListener(caCert string) net.Listener {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Nil(err, "no error generating key")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	suite.Nil(err, "no error creating certificate")
+	err = ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	suite.Nil(err, "no error writing certificate")
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	suite.Nil(err, "no error listening with tls")
+	return listener
+}
+
+func (suite *EventbusTestSuite) TestNATSPublisher() {
+	broker := suite.natsServer()
+	defer broker.Close()
+
+	_, err := NewNATSPublisher("http://"+broker.Address(), "chartmuseum.events", time.Second)
+	suite.NotNil(err, "error with non-nats url")
+	_, err = NewNATSPublisher("nats://"+broker.Address(), "chart museum", time.Second)
+	suite.NotNil(err, "error with invalid subject")
+
+	publisher, err := NewNATSPublisher("nats://user:pass@"+broker.Address(), "chartmuseum.events", time.Second)
+	suite.Nil(err, "no error creating nats publisher")
+	suite.Equal("nats://"+broker.Address()+"/chartmuseum.events", publisher.Name())
+
+	err = publisher.Publish("org1", []byte(`{"type":"chart.uploaded"}`))
+	suite.Nil(err, "no error publishing")
+	err = publisher.Publish("org1", []byte(`{"type":"index.regenerated"}`))
+	suite.Nil(err, "no error publishing on the same connection")
+
+	messages := broker.Messages()
+	suite.Equal([]fakeMessage{
+		{Subject: "chartmuseum.events", Value: `{"type":"chart.uploaded"}`},
+		{Subject: "chartmuseum.events", Value: `{"type":"index.regenerated"}`},
+	}, messages)
+	suite.Len(broker.connects, 1, "connection reused")
+	suite.Contains(broker.connects[0], `"user":"user","pass":"pass"`, "credentials sent from url")
+
+	publisher, err = NewNATSPublisher("nats://"+broker.Address(), "forbidden", time.Second)
+	suite.Nil(err, "no error creating nats publisher")
+	err = publisher.Publish("org1", []byte(`{}`))
+	suite.NotNil(err, "error when the server rejects the publish")
+	suite.Contains(err.Error(), "Permissions Violation")
+
+	broker.Close()
+	publisher, err = NewNATSPublisher("nats://"+broker.Address(), "chartmuseum.events", time.Second)
+	suite.Nil(err, "no error creating nats publisher")
+	suite.NotNil(publisher.Publish("org1", []byte(`{}`)), "error when the server is down")
+}
+
+func (suite *EventbusTestSuite) TestKafkaPublisher() {
+	broker := suite.kafkaBroker(suite.newBroker().listener, "")
+	defer broker.Close()
+
+	_, err := NewKafkaPublisher(nil, "chartmuseum-events", KafkaOptions{})
+	suite.NotNil(err, "error without brokers")
+	_, err = NewKafkaPublisher([]string{"localhost"}, "chartmuseum-events", KafkaOptions{})
+	suite.NotNil(err, "error with broker without port")
+	_, err = NewKafkaPublisher([]string{broker.Address()}, "", KafkaOptions{})
+	suite.NotNil(err, "error without topic")
+	_, err = NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{SASLMechanism: "gssapi", SASLUsername: "user"})
+	suite.NotNil(err, "error with unknown sasl mechanism")
+	_, err = NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{SASLMechanism: "plain"})
+	suite.NotNil(err, "error with sasl mechanism without username")
+
+	publisher, err := NewKafkaPublisher([]string{"127.0.0.1:1", broker.Address()}, "chartmuseum-events", KafkaOptions{Timeout: time.Second})
+	suite.Nil(err, "no error creating kafka publisher")
+	suite.Equal("kafka://127.0.0.1:1,"+broker.Address()+"/chartmuseum-events", publisher.Name())
+
+	for _, repo := range []string{"org1", "org2", "org1"} {
+		err = publisher.Publish(repo, []byte(`{"repo":"`+repo+`"}`))
+		suite.Nil(err, "no error publishing, skipping the broker down")
+	}
+
+	messages := broker.Messages()
+	suite.Len(messages, 3, "events produced")
+	for i, repo := range []string{"org1", "org2", "org1"} {
+		suite.Equal("chartmuseum-events", messages[i].Subject)
+		suite.Equal(repo, messages[i].Key, "records keyed by repo")
+		suite.Equal(`{"repo":"`+repo+`"}`, messages[i].Value)
+	}
+	suite.Equal(messages[0].Partition, messages[2].Partition, "same partition for a repo")
+
+	broker.Close()
+	suite.NotNil(publisher.Publish("org1", []byte(`{}`)), "error when the broker is down")
+}
+
+func (suite *EventbusTestSuite) TestKafkaPublisherTLS() {
+	dir, err := ioutil.TempDir("", "eventbus")
+	suite.Nil(err, "no error creating temp dir")
+	defer os.RemoveAll(dir)
+	caCert := filepath.Join(dir, "ca.pem")
+	broker := suite.kafkaBroker(suite.tlsListener(caCert), "\x00user\x00pass")
+	defer broker.Close()
+
+	_, err = NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{CACert: caCert})
+	suite.NotNil(err, "error with ca certificate without tls")
+	_, err = NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{TLS: true, CACert: filepath.Join(dir, "missing.pem")})
+	suite.NotNil(err, "error with missing ca certificate")
+
+	publisher, err := NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{
+		TLS:           true,
+		CACert:        caCert,
+		SASLMechanism: "PLAIN",
+		SASLUsername:  "user",
+		SASLPassword:  "pass",
+		Timeout:       time.Second,
+	})
+	suite.Nil(err, "no error creating kafka publisher with tls and sasl")
+	suite.Nil(publisher.Publish("org1", []byte(`{}`)), "no error publishing over tls with sasl")
+	suite.Len(broker.Messages(), 1, "event produced over tls with sasl")
+
+	publisher, err = NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{
+		TLS:           true,
+		CACert:        caCert,
+		SASLMechanism: "plain",
+		SASLUsername:  "user",
+		SASLPassword:  "wrong",
+		Timeout:       time.Second,
+	})
+	suite.Nil(err, "no error creating kafka publisher")
+	suite.NotNil(publisher.Publish("org1", []byte(`{}`)), "error with wrong sasl credentials")
+
+	publisher, err = NewKafkaPublisher([]string{broker.Address()}, "chartmuseum-events", KafkaOptions{TLS: true, Timeout: time.Second})
+	suite.Nil(err, "no error creating kafka publisher")
+	suite.NotNil(publisher.Publish("org1", []byte(`{}`)), "error with certificate of unknown authority")
+	suite.Len(broker.Messages(), 1, "no event produced on errors")
+}
+
+func TestEventbusTestSuite(t *testing.T) {
+	suite.Run(t, new(EventbusTestSuite))
+}
@@ -0,0 +1,160 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventbus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+type (
+	// KafkaPublisher publishes events to a Kafka topic, keyed by repo so the events of a repo
+	// land in the same partition and keep their order
+	KafkaPublisher struct {
+		Brokers []string
+		Topic   string
+		Timeout time.Duration
+		writer  *kafka.Writer
+	}
+
+	// KafkaOptions are the options of the connections to the brokers
+	KafkaOptions struct {
+		// TLS connects to the brokers over tls, trusting the CA certificates of the
+		// CACert pem file if set, the system ones otherwise
+		TLS    bool
+		CACert string
+		// SASLMechanism authenticates with SASLUsername and SASLPassword, and is
+		// one of plain, scram-sha-256 or scram-sha-512
+		SASLMechanism string
+		SASLUsername  string
+		SASLPassword  string
+		Timeout       time.Duration
+	}
+)
+
+// NewKafkaPublisher creates a new KafkaPublisher from the host:port of bootstrap brokers
+func NewKafkaPublisher(brokers []string, topic string, options KafkaOptions) (*KafkaPublisher, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no kafka brokers")
+	}
+	for _, broker := range brokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return nil, fmt.Errorf("invalid kafka broker %q, expected host:port", broker)
+		}
+	}
+	if topic == "" {
+		return nil, errors.New("no kafka topic")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	transport := &kafka.Transport{
+		ClientID:    "chartmuseum",
+		DialTimeout: timeout,
+	}
+	if options.TLS {
+		tlsConfig, err := kafkaTLSConfig(options.CACert)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = tlsConfig
+	} else if options.CACert != "" {
+		return nil, errors.New("kafka ca certificate set without tls")
+	}
+	if options.SASLMechanism != "" {
+		mechanism, err := kafkaSASLMechanism(options.SASLMechanism, options.SASLUsername, options.SASLPassword)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+
+	return &KafkaPublisher{
+		Brokers: brokers,
+		Topic:   topic,
+		Timeout: timeout,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireOne,
+			// events are published one at a time, and failed ones retried by the webhook notifier
+			BatchSize:    1,
+			MaxAttempts:  1,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			Transport:    transport,
+		},
+	}, nil
+}
+
+func kafkaTLSConfig(caCert string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert == "" {
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(caCert)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in kafka ca certificate %s", caCert)
+	}
+	return tlsConfig, nil
+}
+
+func kafkaSASLMechanism(name string, username string, password string) (sasl.Mechanism, error) {
+	if username == "" {
+		return nil, errors.New("kafka sasl mechanism set without username")
+	}
+	switch strings.ToLower(name) {
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("unknown kafka sasl mechanism %q, expected plain, scram-sha-256 or scram-sha-512", name)
+}
+
+// Name identifies the publisher in logs
+func (publisher *KafkaPublisher) Name() string {
+	return fmt.Sprintf("kafka://%s/%s", strings.Join(publisher.Brokers, ","), publisher.Topic)
+}
+
+// Publish produces a record to the partition of its key, and waits for the leader to write it
+func (publisher *KafkaPublisher) Publish(key string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publisher.Timeout)
+	defer cancel()
+	return publisher.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: body})
+}
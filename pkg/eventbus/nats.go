@@ -0,0 +1,106 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventbus
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes events to a NATS subject, connecting on the first event
+type NATSPublisher struct {
+	Address string
+	Subject string
+	Timeout time.Duration
+	url     string
+	scheme  string
+	mutex   sync.Mutex
+	conn    *nats.Conn
+}
+
+// NewNATSPublisher creates a new NATSPublisher from a nats://[user[:pass]@]host:port url,
+// a user without password being sent as an auth token. A tls:// url connects over tls
+func NewNATSPublisher(natsURL string, subject string, timeout time.Duration) (*NATSPublisher, error) {
+	u, err := url.Parse(natsURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("invalid nats url %q, expected nats://host:port", natsURL)
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid nats subject %q", subject)
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATSPublisher{
+		Address: address,
+		Subject: subject,
+		Timeout: timeout,
+		url:     natsURL,
+		scheme:  u.Scheme,
+	}, nil
+}
+
+// Name identifies the publisher in logs
+func (publisher *NATSPublisher) Name() string {
+	return fmt.Sprintf("%s://%s/%s", publisher.scheme, publisher.Address, publisher.Subject)
+}
+
+// Publish sends a message to the subject, and waits for the server to process it. The key
+// is not used, as NATS subjects have no partitions
+func (publisher *NATSPublisher) Publish(key string, body []byte) error {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	if publisher.conn == nil || publisher.conn.IsClosed() {
+		conn, err := nats.Connect(publisher.url,
+			nats.Name("chartmuseum"),
+			nats.Timeout(publisher.Timeout),
+			// asynchronous errors are returned by Publish, not printed
+			nats.ErrorHandler(func(*nats.Conn, *nats.Subscription, error) {}),
+		)
+		if err != nil {
+			return err
+		}
+		publisher.conn = conn
+	}
+
+	// the server reports rejected publishes asynchronously, before answering the flush
+	lastErr := publisher.conn.LastError()
+	if err := publisher.conn.Publish(publisher.Subject, body); err != nil {
+		return err
+	}
+	if err := publisher.conn.FlushTimeout(publisher.Timeout); err != nil {
+		return err
+	}
+	if err := publisher.conn.LastError(); err != nil && err != lastErr {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package incluster sets up clients of the api server of the Kubernetes cluster ChartMuseum runs
+// in, with the service account of its pod
+package incluster
+
+import (
+	"io/ioutil"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// serviceAccountDir holds the token, ca certificate and namespace of the service account of a pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Config returns the config of clients of the api server, authenticated with the service
+// account of the pod. Its token is read again as it is rotated
+func Config() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = 10 * time.Second
+	return config, nil
+}
+
+// Namespace returns the namespace of the pod
+func Namespace() (string, error) {
+	content, err := ioutil.ReadFile(pathutil.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
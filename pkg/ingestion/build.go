@@ -0,0 +1,148 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingestion
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// ChartsFromArchive packages the charts of a directory of a source archive, the gzipped tarball
+// of a release with the single top directory served by GitHub and GitLab. The directory is either
+// a chart or holds charts, e.g. charts/mychart. Charts failing to package are skipped, and
+// returned in the error along with the packages of the others
+func ChartsFromArchive(content []byte, path string) ([][]byte, error) {
+	dir, err := ioutil.TempDir("", "chartmuseum-ingestion-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := extractDirectory(content, path, dir); err != nil {
+		return nil, err
+	}
+
+	var chartDirs []string
+	if _, err := os.Stat(filepath.Join(dir, chartutil.ChartfileName)); err == nil {
+		chartDirs = append(chartDirs, dir)
+	} else {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			chartDir := filepath.Join(dir, entry.Name())
+			if _, err := os.Stat(filepath.Join(chartDir, chartutil.ChartfileName)); entry.IsDir() && err == nil {
+				chartDirs = append(chartDirs, chartDir)
+			}
+		}
+	}
+	sort.Strings(chartDirs)
+
+	packagesDir, err := ioutil.TempDir("", "chartmuseum-packages-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(packagesDir)
+	var packages [][]byte
+	var failures []string
+	for _, chartDir := range chartDirs {
+		chartPackage, err := packageChart(chartDir, packagesDir)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", filepath.Base(chartDir), err))
+			continue
+		}
+		packages = append(packages, chartPackage)
+	}
+	if len(failures) > 0 {
+		return packages, fmt.Errorf("could not package charts %s", strings.Join(failures, ", "))
+	}
+	return packages, nil
+}
+
+// packageChart packages a chart directory, honoring its .helmignore as helm package does
+func packageChart(chartDir string, packagesDir string) ([]byte, error) {
+	chart, err := loader.LoadDir(chartDir)
+	if err != nil {
+		return nil, err
+	}
+	filename, err := chartutil.Save(chart, packagesDir)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// extractDirectory writes the regular files of a directory of a source archive to dest,
+// skipping the top directory of the archive
+func extractDirectory(content []byte, path string, dest string) error {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	prefix := pathutil.Clean(path) + "/"
+	if path == "" {
+		prefix = ""
+	}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := pathutil.Clean(header.Name)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		} else {
+			continue
+		}
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, "../") {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tarReader)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingestion
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BuildTestSuite struct {
+	suite.Suite
+}
+
+// sourceArchive returns a gzipped tarball of files under a top directory, as served by GitHub and GitLab
+func sourceArchive(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		tarWriter.WriteHeader(&tar.Header{Name: "org1-charts-abc123/" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+	return buf.Bytes()
+}
+
+func (suite *BuildTestSuite) TestChartsFromArchive() {
+	archive := sourceArchive(map[string]string{
+		"README.md":                             "charts",
+		"charts/mychart/Chart.yaml":             "apiVersion: v2\nname: mychart\nversion: 0.1.0\n",
+		"charts/mychart/values.yaml":            "replicas: 1\n",
+		"charts/mychart/templates/cm.yaml":      "kind: ConfigMap\n",
+		"charts/mychart/.helmignore":            "*.md\n",
+		"charts/mychart/NOTES.md":               "ignored",
+		"charts/otherchart/Chart.yaml":          "apiVersion: v2\nname: otherchart\nversion: 1.0.0\n",
+		"charts/invalid/Chart.yaml":             "apiVersion: v2\nname: invalid\n",
+		"charts/notachart/values.yaml":          "replicas: 1\n",
+		"charts/../../../escaped/Chart.yaml":    "apiVersion: v2\nname: escaped\nversion: 1.0.0\n",
+		"other/outside/Chart.yaml":              "apiVersion: v2\nname: outside\nversion: 1.0.0\n",
+		"charts/mychart/charts/dep/Chart.yaml":  "apiVersion: v2\nname: dep\nversion: 0.1.0\n",
+		"charts/mychart/charts/dep/values.yaml": "{}\n",
+	})
+
+	packages, err := ChartsFromArchive(archive, "charts")
+	suite.NotNil(err, "error for the invalid chart")
+	suite.Contains(err.Error(), "invalid")
+	suite.Len(packages, 2, "other charts packaged")
+	chart, err := loader.LoadArchive(bytes.NewReader(packages[0]))
+	suite.Nil(err, "no error loading package")
+	suite.Equal("mychart", chart.Name())
+	suite.Equal("0.1.0", chart.Metadata.Version)
+	suite.Len(chart.Templates, 1)
+	suite.Len(chart.Dependencies(), 1, "vendored dependency packaged")
+	for _, file := range chart.Files {
+		suite.NotEqual("NOTES.md", file.Name, ".helmignore honored")
+	}
+	chart, err = loader.LoadArchive(bytes.NewReader(packages[1]))
+	suite.Nil(err, "no error loading package")
+	suite.Equal("otherchart", chart.Name())
+
+	packages, err = ChartsFromArchive(archive, "charts/otherchart")
+	suite.Nil(err, "no error packaging a single chart")
+	suite.Len(packages, 1, "path is a chart")
+
+	packages, err = ChartsFromArchive(archive, "missing")
+	suite.Nil(err, "no error without charts")
+	suite.Empty(packages)
+
+	_, err = ChartsFromArchive([]byte("not an archive"), "charts")
+	suite.NotNil(err, "error reading invalid archive")
+}
+
+func TestBuildTestSuite(t *testing.T) {
+	suite.Run(t, new(BuildTestSuite))
+}
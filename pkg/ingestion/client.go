@@ -0,0 +1,201 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGitHubURL = "https://api.github.com"
+	defaultGitLabURL = "https://gitlab.com/api/v4"
+	defaultTimeout   = 30 * time.Second
+
+	// releasesPerPage is the number of most recent releases checked, the max page size of both apis
+	releasesPerPage = 100
+)
+
+type (
+	// Client lists the releases of a source and downloads their assets and source archives
+	Client struct {
+		source     *Source
+		api        *url.URL
+		httpClient *http.Client
+	}
+
+	// Release is a release of a source
+	Release struct {
+		Tag    string
+		Assets []*Asset
+		// Archive is the url of the gzipped tarball of the source of the release
+		Archive string
+	}
+
+	// Asset is a file attached to a release
+	Asset struct {
+		Name string
+		URL  string
+	}
+
+	gitHubRelease struct {
+		TagName string `json:"tag_name"`
+		Draft   bool   `json:"draft"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"assets"`
+	}
+
+	gitLabRelease struct {
+		TagName string `json:"tag_name"`
+		Assets  struct {
+			Links []struct {
+				Name           string `json:"name"`
+				URL            string `json:"url"`
+				DirectAssetURL string `json:"direct_asset_url"`
+			} `json:"links"`
+		} `json:"assets"`
+	}
+)
+
+// NewClient creates a new Client for a source
+func NewClient(source *Source, timeout time.Duration) (*Client, error) {
+	apiURL := source.URL
+	if apiURL == "" {
+		apiURL = defaultGitHubURL
+		if source.GitLab != "" {
+			apiURL = defaultGitLabURL
+		}
+	}
+	api, err := url.Parse(strings.TrimSuffix(apiURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (api.Scheme != "http" && api.Scheme != "https") || api.Host == "" {
+		return nil, fmt.Errorf("api url of %s must be an http or https url: %s", source.Name(), apiURL)
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Client{
+		source:     source,
+		api:        api,
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Releases returns the most recent releases of the source, drafts excluded
+func (client *Client) Releases() ([]*Release, error) {
+	if client.source.GitHub != "" {
+		return client.gitHubReleases()
+	}
+	return client.gitLabReleases()
+}
+
+func (client *Client) gitHubReleases() ([]*Release, error) {
+	var gitHubReleases []gitHubRelease
+	endpoint := fmt.Sprintf("%s/repos/%s/releases?per_page=%d", client.api, client.source.GitHub, releasesPerPage)
+	if err := client.getJSON(endpoint, &gitHubReleases); err != nil {
+		return nil, err
+	}
+	var releases []*Release
+	for _, gitHubRelease := range gitHubReleases {
+		if gitHubRelease.Draft {
+			continue
+		}
+		release := &Release{
+			Tag:     gitHubRelease.TagName,
+			Archive: fmt.Sprintf("%s/repos/%s/tarball/%s", client.api, client.source.GitHub, url.PathEscape(gitHubRelease.TagName)),
+		}
+		for _, asset := range gitHubRelease.Assets {
+			release.Assets = append(release.Assets, &Asset{Name: asset.Name, URL: asset.URL})
+		}
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+func (client *Client) gitLabReleases() ([]*Release, error) {
+	var gitLabReleases []gitLabRelease
+	project := client.api.String() + "/projects/" + url.PathEscape(client.source.GitLab)
+	if err := client.getJSON(fmt.Sprintf("%s/releases?per_page=%d", project, releasesPerPage), &gitLabReleases); err != nil {
+		return nil, err
+	}
+	var releases []*Release
+	for _, gitLabRelease := range gitLabReleases {
+		release := &Release{
+			Tag:     gitLabRelease.TagName,
+			Archive: project + "/repository/archive.tar.gz?sha=" + url.QueryEscape(gitLabRelease.TagName),
+		}
+		for _, link := range gitLabRelease.Assets.Links {
+			assetURL := link.DirectAssetURL
+			if assetURL == "" {
+				assetURL = link.URL
+			}
+			release.Assets = append(release.Assets, &Asset{Name: link.Name, URL: assetURL})
+		}
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+// Download returns the content of an asset or source archive
+func (client *Client) Download(fileURL string) ([]byte, error) {
+	res, err := client.get(fileURL, "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (client *Client) getJSON(endpoint string, v interface{}) error {
+	res, err := client.get(endpoint, "application/json")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// get sends the token to the host of the api only, as asset links of GitLab may be anywhere. The
+// Authorization header is dropped on redirects to other hosts, e.g. to the storage of GitHub assets
+func (client *Client) get(rawURL string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if client.source.Token != "" && req.URL.Host == client.api.Host {
+		req.Header.Set("Authorization", "Bearer "+client.source.Token)
+	}
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d from %s", res.StatusCode, req.URL.Redacted())
+	}
+	return res, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingestion
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ClientTestSuite) TestGitHub() {
+	var authorization string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/org1/charts/releases":
+			authorization = r.Header.Get("Authorization")
+			suite.Equal("100", r.URL.Query().Get("per_page"))
+			fmt.Fprintf(w, `[
+				{"tag_name": "v0.2.0", "draft": true, "assets": []},
+				{"tag_name": "v0.1.0", "assets": [{"name": "mychart-0.1.0.tgz", "url": "%s/repos/org1/charts/releases/assets/1"}]}
+			]`, server.URL)
+		case "/repos/org1/charts/releases/assets/1":
+			suite.Equal("application/octet-stream", r.Header.Get("Accept"), "asset content requested")
+			w.Write([]byte("chart"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&Source{GitHub: "org1/charts", URL: server.URL + "/", Token: "token"}, 0)
+	suite.Nil(err, "no error creating client")
+	releases, err := client.Releases()
+	suite.Nil(err, "no error listing releases")
+	suite.Equal("Bearer token", authorization, "token sent to the api")
+	suite.Len(releases, 1, "drafts skipped")
+	suite.Equal("v0.1.0", releases[0].Tag)
+	suite.Equal(server.URL+"/repos/org1/charts/tarball/v0.1.0", releases[0].Archive)
+	suite.Len(releases[0].Assets, 1)
+	suite.Equal("mychart-0.1.0.tgz", releases[0].Assets[0].Name)
+
+	content, err := client.Download(releases[0].Assets[0].URL)
+	suite.Nil(err, "no error downloading asset")
+	suite.Equal("chart", string(content))
+	_, err = client.Download(server.URL + "/missing")
+	suite.NotNil(err, "error downloading missing asset")
+
+	_, err = NewClient(&Source{GitHub: "org1/charts", URL: "ftp://example.com"}, 0)
+	suite.NotNil(err, "error with api url not http")
+}
+
+func (suite *ClientTestSuite) TestGitLab() {
+	var authorizations []string
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		w.Write([]byte("chart"))
+	}))
+	defer elsewhere.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		suite.Equal("/api/v4/projects/group%2Fproject/releases", r.URL.EscapedPath(), "project path escaped")
+		fmt.Fprintf(w, `[{"tag_name": "v1.0.0", "assets": {"links": [
+			{"name": "mychart-1.0.0.tgz", "url": "https://gitlab.example.com/other", "direct_asset_url": "%s/mychart-1.0.0.tgz"}
+		]}}]`, elsewhere.URL)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&Source{GitLab: "group/project", URL: server.URL + "/api/v4", Token: "token"}, 0)
+	suite.Nil(err, "no error creating client")
+	releases, err := client.Releases()
+	suite.Nil(err, "no error listing releases")
+	suite.Len(releases, 1)
+	suite.Equal(server.URL+"/api/v4/projects/group%2Fproject/repository/archive.tar.gz?sha=v1.0.0", releases[0].Archive)
+	suite.Equal(elsewhere.URL+"/mychart-1.0.0.tgz", releases[0].Assets[0].URL, "direct asset url preferred")
+
+	_, err = client.Download(releases[0].Assets[0].URL)
+	suite.Nil(err, "no error downloading asset")
+	suite.Equal([]string{"Bearer token", ""}, authorizations, "token never sent to other hosts")
+
+	client, err = NewClient(&Source{GitLab: "group/project"}, 0)
+	suite.Nil(err, "no error creating client")
+	suite.Equal(defaultGitLabURL, client.api.String(), "gitlab.com by default")
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientTestSuite))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ingestion holds the config of the GitHub and GitLab projects whose releases are
+// periodically imported into storage
+package ingestion
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"helm.sh/chartmuseum/pkg/replication"
+
+	"github.com/ghodss/yaml"
+)
+
+const defaultInterval = 10 * time.Minute
+
+type (
+	// Config lists the projects whose releases are imported into storage, checked every Interval
+	Config struct {
+		Interval replication.Duration `json:"interval,omitempty"`
+		Sources  []*Source            `json:"sources"`
+	}
+
+	// Source is a GitHub repository or a GitLab project whose releases hold charts
+	Source struct {
+		// GitHub is the owner/name of a GitHub repository, GitLab the path of a GitLab project,
+		// e.g. group/subgroup/project. Exactly one of them is set
+		GitHub string `json:"github,omitempty"`
+		GitLab string `json:"gitlab,omitempty"`
+		// URL is the base url of the api of GitHub Enterprise or of a self-managed GitLab,
+		// https://api.github.com or https://gitlab.com/api/v4 if left out
+		URL string `json:"url,omitempty"`
+		// Token authenticates to the api, read from the TokenEnv environment variable if set
+		Token    string `json:"token,omitempty"`
+		TokenEnv string `json:"tokenEnv,omitempty"`
+		// Repo is the local repo charts are imported to, "" at the root of storage
+		Repo string `json:"repo,omitempty"`
+		// Path is a directory of the project holding charts, such as "charts", which are
+		// packaged from the source archive of each release. Without path, only the chart
+		// packages attached to releases are imported
+		Path string `json:"path,omitempty"`
+	}
+)
+
+// LoadConfig reads an ingestion config file
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigFromContent(content)
+}
+
+// ConfigFromContent parses the content of an ingestion config file
+func ConfigFromContent(content []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	if config.Interval.Duration <= 0 {
+		config.Interval.Duration = defaultInterval
+	}
+	for i, source := range config.Sources {
+		if source == nil || (source.GitHub == "") == (source.GitLab == "") {
+			return nil, fmt.Errorf("ingestion source %d must have either github or gitlab", i)
+		}
+		source.GitHub = strings.Trim(source.GitHub, "/")
+		source.GitLab = strings.Trim(source.GitLab, "/")
+		if source.GitHub != "" && strings.Count(source.GitHub, "/") != 1 {
+			return nil, fmt.Errorf("invalid github repository %q of ingestion source %d, must be owner/name", source.GitHub, i)
+		}
+		if source.TokenEnv != "" {
+			source.Token = os.Getenv(source.TokenEnv)
+		}
+		source.Repo = strings.Trim(source.Repo, "/")
+		source.Path = strings.Trim(source.Path, "/")
+		if strings.Contains("/"+source.Path+"/", "/../") {
+			return nil, fmt.Errorf("invalid path %q of ingestion source %d", source.Path, i)
+		}
+	}
+	return config, nil
+}
+
+// Name identifies the source in logs, e.g. github:owner/name
+func (source *Source) Name() string {
+	if source.GitHub != "" {
+		return "github:" + source.GitHub
+	}
+	return "gitlab:" + source.GitLab
+}
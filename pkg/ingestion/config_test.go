@@ -0,0 +1,74 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingestion
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConfigTestSuite) TestConfigFromContent() {
+	os.Setenv("INGESTION_TEST_TOKEN", "secret")
+	defer os.Unsetenv("INGESTION_TEST_TOKEN")
+	config, err := ConfigFromContent([]byte(`
+interval: 30s
+sources:
+  - github: /org1/charts/
+    repo: /org1/
+    path: /charts/
+    tokenEnv: INGESTION_TEST_TOKEN
+  - gitlab: group/subgroup/project
+    url: https://gitlab.example.com/api/v4
+    token: token
+`))
+	suite.Nil(err, "no error parsing ingestion config")
+	suite.Equal(30*time.Second, config.Interval.Duration)
+	suite.Len(config.Sources, 2)
+	suite.Equal("org1/charts", config.Sources[0].GitHub)
+	suite.Equal("org1", config.Sources[0].Repo)
+	suite.Equal("charts", config.Sources[0].Path)
+	suite.Equal("secret", config.Sources[0].Token, "token read from environment")
+	suite.Equal("github:org1/charts", config.Sources[0].Name())
+	suite.Equal("gitlab:group/subgroup/project", config.Sources[1].Name())
+	suite.Equal("", config.Sources[1].Repo, "root of storage by default")
+
+	config, err = ConfigFromContent([]byte(`sources: [{github: org1/charts}]`))
+	suite.Nil(err, "no error parsing ingestion config")
+	suite.Equal(defaultInterval, config.Interval.Duration, "default interval")
+
+	for _, content := range []string{
+		`sources: [{repo: org1}]`,
+		`sources: [{github: org1/charts, gitlab: org1/charts}]`,
+		`sources: [{github: org1}]`,
+		`sources: [{github: org1/charts, path: ../charts}]`,
+		`interval: often`,
+	} {
+		_, err = ConfigFromContent([]byte(content))
+		suite.NotNil(err, "error parsing invalid config %s", content)
+	}
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
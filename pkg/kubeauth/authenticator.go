@@ -0,0 +1,176 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/chartmuseum/pkg/incluster"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authenticationv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// BasicAuthUsername is the username of basic auth credentials whose password is a service
+	// account token, for clients such as helm that only send basic auth
+	BasicAuthUsername = "serviceaccount"
+
+	serviceAccountUserPrefix = "system:serviceaccount:"
+
+	// maxReviews caps the reviews cached, expired ones being dropped beyond it
+	maxReviews = 1024
+)
+
+type (
+	// Authenticator checks service account tokens with TokenReviews of the api server of the
+	// cluster, and grants the service accounts reviewed the actions of its Config
+	Authenticator struct {
+		Config  *Config
+		Reviews authenticationv1client.TokenReviewInterface
+		reviews map[string]*review
+		mutex   sync.Mutex
+	}
+
+	// review is the service account a token authenticated, "" if none, until it expires
+	review struct {
+		serviceAccount string
+		expires        time.Time
+	}
+)
+
+// NewAuthenticator creates a new Authenticator reviewing tokens with the service account of the
+// pod it runs in, which must be allowed to create tokenreviews.authentication.k8s.io
+func NewAuthenticator(config *Config) (*Authenticator, error) {
+	restConfig, err := incluster.Config()
+	if err != nil {
+		return nil, err
+	}
+	return NewAuthenticatorForConfig(restConfig, config)
+}
+
+// NewAuthenticatorForConfig creates a new Authenticator reviewing tokens with the api server of
+// restConfig
+func NewAuthenticatorForConfig(restConfig *rest.Config, config *Config) (*Authenticator, error) {
+	client, err := authenticationv1client.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{Config: config, Reviews: client.TokenReviews()}, nil
+}
+
+// Authorize tells whether the service account token of an Authorization header may perform an
+// action on a repo. Tokens are sent as bearer tokens, or as the password of basic auth with
+// BasicAuthUsername. Headers without a token are never allowed, and not reviewed
+func (authenticator *Authenticator) Authorize(ctx context.Context, authHeader string, action string, repo string) (bool, error) {
+	token := tokenFromAuthHeader(authHeader)
+	if token == "" {
+		return false, nil
+	}
+	serviceAccount, err := authenticator.ServiceAccount(ctx, token)
+	if err != nil || serviceAccount == "" {
+		return false, err
+	}
+	return authenticator.Config.Allows(serviceAccount, action, repo), nil
+}
+
+// ServiceAccount returns the service account a token authenticates, as namespace/name, or "" if
+// the token is not one of a service account. Reviews are cached for the CacheTTL of the config
+func (authenticator *Authenticator) ServiceAccount(ctx context.Context, token string) (string, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	authenticator.mutex.Lock()
+	cached, ok := authenticator.reviews[key]
+	authenticator.mutex.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.serviceAccount, nil
+	}
+
+	serviceAccount, err := authenticator.review(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	if authenticator.reviews == nil {
+		authenticator.reviews = map[string]*review{}
+	}
+	if len(authenticator.reviews) >= maxReviews {
+		for k, r := range authenticator.reviews {
+			if !now.Before(r.expires) {
+				delete(authenticator.reviews, k)
+			}
+		}
+	}
+	if len(authenticator.reviews) < maxReviews {
+		authenticator.reviews[key] = &review{serviceAccount: serviceAccount, expires: now.Add(authenticator.Config.CacheTTL.Duration)}
+	}
+	return serviceAccount, nil
+}
+
+func (authenticator *Authenticator) review(ctx context.Context, token string) (string, error) {
+	result, err := authenticator.Reviews.Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: authenticator.Config.Audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	username := result.Status.User.Username
+	if !result.Status.Authenticated || !strings.HasPrefix(username, serviceAccountUserPrefix) {
+		return "", nil
+	}
+	// system:serviceaccount:<namespace>:<name>
+	parts := strings.Split(strings.TrimPrefix(username, serviceAccountUserPrefix), ":")
+	if len(parts) != 2 {
+		return "", nil
+	}
+	return parts[0] + "/" + parts[1], nil
+}
+
+// tokenFromAuthHeader returns the token of a bearer or basic auth Authorization header, if any
+func tokenFromAuthHeader(authHeader string) string {
+	scheme, credentials := authHeader, ""
+	if i := strings.IndexByte(authHeader, ' '); i >= 0 {
+		scheme, credentials = authHeader[:i], strings.TrimSpace(authHeader[i+1:])
+	}
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return credentials
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return ""
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) == 2 && parts[0] == BasicAuthUsername {
+			return parts[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/rest"
+)
+
+type AuthenticatorTestSuite struct {
+	suite.Suite
+}
+
+// apiServer returns a test api server reviewing tokens with usernames, and the number of reviews
+func (suite *AuthenticatorTestSuite) apiServer(usernames map[string]string) (*httptest.Server, func() int) {
+	var mutex sync.Mutex
+	reviews := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("POST", r.Method)
+		suite.Equal("/apis/authentication.k8s.io/v1/tokenreviews", r.URL.Path)
+		suite.Equal("Bearer own-token", r.Header.Get("Authorization"), "authenticated with the token of the server")
+		request := &authenticationv1.TokenReview{}
+		suite.Nil(json.NewDecoder(r.Body).Decode(request), "no error decoding token review")
+		suite.Equal([]string{"chartmuseum"}, request.Spec.Audiences)
+		mutex.Lock()
+		reviews++
+		mutex.Unlock()
+		if request.Spec.Token == "unavailable" {
+			w.WriteHeader(503)
+			return
+		}
+		username, ok := usernames[request.Spec.Token]
+		request.Status.Authenticated = ok
+		request.Status.User.Username = username
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(request)
+	}))
+	return server, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return reviews
+	}
+}
+
+func (suite *AuthenticatorTestSuite) TestAuthorize() {
+	server, reviews := suite.apiServer(map[string]string{
+		"builder-token": "system:serviceaccount:ci:builder",
+		"user-token":    "jane@example.com",
+	})
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kubeauth")
+	suite.Nil(err, "no error creating temp dir")
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	suite.Nil(ioutil.WriteFile(tokenFile, []byte("own-token\n"), 0600))
+
+	config, err := ConfigFromContent([]byte(`
+audiences: [chartmuseum]
+accounts:
+  - serviceAccount: ci/builder
+    repos: [org1/*]
+    actions: [pull, push]
+`))
+	suite.Nil(err, "no error parsing kubeauth config")
+	authenticator, err := NewAuthenticatorForConfig(&rest.Config{Host: server.URL, BearerTokenFile: tokenFile}, config)
+	suite.Nil(err, "no error creating authenticator")
+	ctx := context.Background()
+
+	allowed, err := authenticator.Authorize(ctx, "Bearer builder-token", "push", "org1/repo1")
+	suite.Nil(err, "no error reviewing token")
+	suite.True(allowed, "service account granted push")
+	allowed, err = authenticator.Authorize(ctx, "Bearer builder-token", "pull", "org2/repo1")
+	suite.Nil(err, "no error reviewing token")
+	suite.False(allowed, "service account not granted the repo")
+	suite.Equal(1, reviews(), "review of token cached")
+
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("serviceaccount:builder-token"))
+	allowed, err = authenticator.Authorize(ctx, basic, "pull", "org1/repo1")
+	suite.Nil(err, "no error reviewing token")
+	suite.True(allowed, "token as the password of basic auth")
+
+	for _, header := range []string{"", "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")), "Basic !!!", "Bearer"} {
+		allowed, err = authenticator.Authorize(ctx, header, "pull", "org1/repo1")
+		suite.Nil(err, "no error without token")
+		suite.False(allowed, "not allowed without token in %q", header)
+	}
+	suite.Equal(1, reviews(), "headers without token not reviewed")
+
+	allowed, err = authenticator.Authorize(ctx, "Bearer user-token", "pull", "org1/repo1")
+	suite.Nil(err, "no error reviewing token")
+	suite.False(allowed, "users other than service accounts not allowed")
+	allowed, err = authenticator.Authorize(ctx, "Bearer invalid-token", "pull", "org1/repo1")
+	suite.Nil(err, "no error reviewing token")
+	suite.False(allowed, "invalid token not allowed")
+
+	_, err = authenticator.Authorize(ctx, "Bearer unavailable", "pull", "org1/repo1")
+	suite.NotNil(err, "error when the api server does not review tokens")
+
+	// reviews are not reused once expired
+	authenticator.mutex.Lock()
+	for _, review := range authenticator.reviews {
+		review.expires = time.Now()
+	}
+	authenticator.mutex.Unlock()
+	before := reviews()
+	authenticator.Authorize(ctx, "Bearer builder-token", "pull", "org1/repo1")
+	suite.Equal(before+1, reviews(), "expired review renewed")
+}
+
+func TestAuthenticatorTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthenticatorTestSuite))
+}
@@ -0,0 +1,130 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubeauth authenticates Kubernetes service accounts with their tokens, as credentials
+// of the repos they are granted, so that jobs in a cluster need no secret of their own
+package kubeauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"helm.sh/chartmuseum/pkg/replication"
+
+	cm_auth "github.com/chartmuseum/auth"
+	"github.com/ghodss/yaml"
+)
+
+const defaultCacheTTL = time.Minute
+
+type (
+	// Config lists the service accounts that may use the repos, and how their tokens are reviewed
+	Config struct {
+		// Audiences the tokens must be issued for, e.g. chartmuseum, or those of the api server if empty
+		Audiences []string `json:"audiences,omitempty"`
+		// CacheTTL is how long the review of a token is reused, 1m by default
+		CacheTTL replication.Duration `json:"cacheTTL,omitempty"`
+		Accounts []*Account           `json:"accounts"`
+	}
+
+	// Account grants actions on repos to a service account, or every service account of a namespace
+	Account struct {
+		// ServiceAccount is namespace/name, e.g. ci/builder, or namespace/* for every service account of the namespace
+		ServiceAccount string `json:"serviceAccount"`
+		// Repos are patterns of the repos granted, e.g. org1/*, every repo if empty
+		Repos []string `json:"repos,omitempty"`
+		// Actions are pull and push, pull only if empty
+		Actions []string `json:"actions,omitempty"`
+	}
+)
+
+// LoadConfig reads a kubeauth config file
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigFromContent(content)
+}
+
+// ConfigFromContent parses the content of a kubeauth config file
+func ConfigFromContent(content []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	if config.CacheTTL.Duration <= 0 {
+		config.CacheTTL.Duration = defaultCacheTTL
+	}
+	for i, account := range config.Accounts {
+		if account == nil {
+			return nil, fmt.Errorf("kubeauth account %d is empty", i)
+		}
+		parts := strings.Split(account.ServiceAccount, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid service account %q of kubeauth account %d, must be namespace/name", account.ServiceAccount, i)
+		}
+		for j, repo := range account.Repos {
+			account.Repos[j] = strings.Trim(repo, "/")
+			if _, err := pathutil.Match(account.Repos[j], ""); err != nil {
+				return nil, fmt.Errorf("invalid repo pattern %q of kubeauth account %d", repo, i)
+			}
+		}
+		if len(account.Actions) == 0 {
+			account.Actions = []string{cm_auth.PullAction}
+		}
+		for _, action := range account.Actions {
+			if action != cm_auth.PullAction && action != cm_auth.PushAction {
+				return nil, fmt.Errorf("invalid action %q of kubeauth account %d, must be pull or push", action, i)
+			}
+		}
+	}
+	return config, nil
+}
+
+// Allows tells whether a service account, as namespace/name, may perform an action on a repo
+func (config *Config) Allows(serviceAccount string, action string, repo string) bool {
+	namespace := strings.SplitN(serviceAccount, "/", 2)[0]
+	for _, account := range config.Accounts {
+		if account.ServiceAccount != serviceAccount && account.ServiceAccount != namespace+"/*" {
+			continue
+		}
+		if !contains(account.Actions, action) {
+			continue
+		}
+		if len(account.Repos) == 0 {
+			return true
+		}
+		for _, pattern := range account.Repos {
+			if matched, _ := pathutil.Match(pattern, repo); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
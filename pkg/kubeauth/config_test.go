@@ -0,0 +1,74 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConfigTestSuite) TestConfigFromContent() {
+	config, err := ConfigFromContent([]byte(`
+audiences: [chartmuseum]
+cacheTTL: 30s
+accounts:
+  - serviceAccount: ci/builder
+    repos: [/org1/*/, org2/charts]
+    actions: [pull, push]
+  - serviceAccount: apps/*
+`))
+	suite.Nil(err, "no error parsing kubeauth config")
+	suite.Equal([]string{"chartmuseum"}, config.Audiences)
+	suite.Equal(30*time.Second, config.CacheTTL.Duration)
+	suite.Equal([]string{"org1/*", "org2/charts"}, config.Accounts[0].Repos, "repo patterns trimmed")
+	suite.Equal([]string{"pull"}, config.Accounts[1].Actions, "pull only by default")
+
+	suite.True(config.Allows("ci/builder", "push", "org1/repo1"))
+	suite.True(config.Allows("ci/builder", "pull", "org2/charts"))
+	suite.False(config.Allows("ci/builder", "push", "org1/repo1/nested"), "patterns match a path segment each")
+	suite.False(config.Allows("ci/builder", "push", "org3/repo1"), "repo not granted")
+	suite.False(config.Allows("ci/deployer", "pull", "org1/repo1"), "service account not granted")
+	suite.True(config.Allows("apps/web", "pull", "org3/repo1"), "every repo and service account of the namespace")
+	suite.True(config.Allows("apps/web", "pull", ""), "every repo including the root")
+	suite.False(config.Allows("apps/web", "push", "org3/repo1"), "action not granted")
+
+	config, err = ConfigFromContent([]byte(`accounts: []`))
+	suite.Nil(err, "no error parsing kubeauth config")
+	suite.Equal(defaultCacheTTL, config.CacheTTL.Duration, "default cache ttl")
+
+	for _, content := range []string{
+		`accounts: [null]`,
+		`accounts: [{serviceAccount: builder}]`,
+		`accounts: [{serviceAccount: ci/builder/extra}]`,
+		`accounts: [{serviceAccount: ci/builder, actions: [delete]}]`,
+		`accounts: [{serviceAccount: ci/builder, repos: ["org1/["]}]`,
+		`cacheTTL: often`,
+	} {
+		_, err = ConfigFromContent([]byte(content))
+		suite.NotNil(err, "error parsing invalid config %s", content)
+	}
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
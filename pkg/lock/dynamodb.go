@@ -0,0 +1,139 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type (
+	// DynamoDBLocker implements the Locker and Lease interfaces with conditional writes to a table whose
+	// partition key is the string LockID. Tokens and expiry times are saved in Token and Expires
+	DynamoDBLocker struct {
+		Client dynamodbiface.DynamoDBAPI
+		Table  string
+		TTL    time.Duration
+	}
+)
+
+// NewDynamoDBLocker creates a new DynamoDBLocker, locks expiring after ttl if not refreshed.
+// Credentials are found as with the amazon storage backend
+func NewDynamoDBLocker(table string, region string, endpoint string, ttl time.Duration) *DynamoDBLocker {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	return &DynamoDBLocker{
+		Client: dynamodb.New(session.Must(session.NewSessionWithOptions(session.Options{
+			Config:            *config,
+			SharedConfigState: session.SharedConfigEnable,
+		}))),
+		Table: table,
+		TTL:   ttl,
+	}
+}
+
+// Lock locks name, see Locker. Expired items are taken over, their owner having stopped
+func (locker *DynamoDBLocker) Lock(ctx context.Context, name string) (func(), error) {
+	token, err := acquire(ctx, name, func(token string) (bool, error) {
+		now := time.Now()
+		_, err := locker.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(locker.Table),
+			Item: map[string]*dynamodb.AttributeValue{
+				"LockID":  {S: aws.String(name)},
+				"Token":   {S: aws.String(token)},
+				"Expires": unixMillis(now.Add(locker.TTL)),
+			},
+			ConditionExpression:       aws.String("attribute_not_exists(LockID) OR Expires < :now"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":now": unixMillis(now)},
+		})
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	key := map[string]*dynamodb.AttributeValue{"LockID": {S: aws.String(name)}}
+	refresh := func() {
+		locker.Client.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:           aws.String(locker.Table),
+			Key:                 key,
+			UpdateExpression:    aws.String("SET Expires = :expires"),
+			ConditionExpression: aws.String("Token = :token"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":expires": unixMillis(time.Now().Add(locker.TTL)),
+				":token":   {S: aws.String(token)},
+			},
+		})
+	}
+	release := func() {
+		locker.Client.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName:                 aws.String(locker.Table),
+			Key:                       key,
+			ConditionExpression:       aws.String("Token = :token"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":token": {S: aws.String(token)}},
+		})
+	}
+	return hold(locker.TTL, refresh, release), nil
+}
+
+// Acquire takes or renews a lease, see Lease. Leases are items of the table of the locks
+func (locker *DynamoDBLocker) Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := locker.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(locker.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockID":  {S: aws.String(name)},
+			"Token":   {S: aws.String(holder)},
+			"Expires": unixMillis(now.Add(ttl)),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockID) OR Expires < :now OR Token = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":    unixMillis(now),
+			":holder": {S: aws.String(holder)},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unixMillis(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))}
+}
+
+func isConditionalCheckFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,122 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"helm.sh/chartmuseum/pkg/incluster"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/rest"
+)
+
+type (
+	// KubernetesLease implements the Lease interface with Lease objects of the coordination.k8s.io
+	// api, as the leader election of Kubernetes controllers does
+	KubernetesLease struct {
+		Leases coordinationv1client.LeaseInterface
+	}
+)
+
+// NewKubernetesLease creates a new KubernetesLease with the service account of the pod it runs in,
+// its leases being in namespace, or in the namespace of the pod if empty
+func NewKubernetesLease(namespace string) (*KubernetesLease, error) {
+	config, err := incluster.Config()
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		if namespace, err = incluster.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	return NewKubernetesLeaseForConfig(config, namespace)
+}
+
+// NewKubernetesLeaseForConfig creates a new KubernetesLease with the leases of namespace, on the
+// api server of config
+func NewKubernetesLeaseForConfig(config *rest.Config, namespace string) (*KubernetesLease, error) {
+	client, err := coordinationv1client.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesLease{Leases: client.Leases(namespace)}, nil
+}
+
+// Acquire takes or renews a lease, see Lease. Updates of a lease changed by another replica
+// in the meantime are refused by the api server, so only one replica takes an expired lease
+func (lease *KubernetesLease) Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	current, err := lease.Leases.Get(ctx, name, metav1.GetOptions{})
+	exists := err == nil
+	switch {
+	case apierrors.IsNotFound(err):
+		current = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	case err != nil:
+		return false, err
+	case leaseHolder(current) != holder && !leaseExpired(current, now.Time):
+		return false, nil
+	}
+
+	if leaseHolder(current) != holder {
+		if leaseHolder(current) != "" {
+			transitions := int32(1)
+			if current.Spec.LeaseTransitions != nil {
+				transitions += *current.Spec.LeaseTransitions
+			}
+			current.Spec.LeaseTransitions = &transitions
+		}
+		current.Spec.HolderIdentity = &holder
+		current.Spec.AcquireTime = &now
+	}
+	duration := int32(math.Ceil(ttl.Seconds()))
+	current.Spec.RenewTime = &now
+	current.Spec.LeaseDurationSeconds = &duration
+
+	if exists {
+		_, err = lease.Leases.Update(ctx, current, metav1.UpdateOptions{})
+	} else {
+		_, err = lease.Leases.Create(ctx, current, metav1.CreateOptions{})
+	}
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+		// taken or renewed by another replica first
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// leaseHolder returns the holder of a lease, "" if none
+func leaseHolder(current *coordinationv1.Lease) string {
+	if current.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *current.Spec.HolderIdentity
+}
+
+// leaseExpired returns whether the holder of a lease stopped renewing it
+func leaseExpired(current *coordinationv1.Lease, now time.Time) bool {
+	if current.Spec.RenewTime == nil || current.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(current.Spec.RenewTime.Add(time.Duration(*current.Spec.LeaseDurationSeconds) * time.Second))
+}
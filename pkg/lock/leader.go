@@ -0,0 +1,144 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultLeaseTTL is how long the leader keeps its lease without renewing it
+const DefaultLeaseTTL = 15 * time.Second
+
+type (
+	// Lease is held by one replica at a time, until it expires unless renewed by its holder
+	Lease interface {
+		// Acquire takes the lease named name for holder, or renews it if holder already holds it,
+		// returning whether holder holds it for ttl
+		Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	}
+
+	// Elector elects the replica running the background jobs of a server, such as trash purges
+	// and replication, among the replicas sharing one storage backend
+	Elector struct {
+		Logger *cm_logger.Logger
+		Lease  Lease
+		Name   string
+		ID     string
+		TTL    time.Duration
+		until  time.Time
+		mutex  sync.Mutex
+	}
+
+	// ElectorOptions are options for constructing an Elector
+	ElectorOptions struct {
+		Logger *cm_logger.Logger
+		Lease  Lease
+		// Name is the name of the lease, the same for every replica
+		Name string
+		// ID identifies the replica, its hostname by default, e.g. the name of its pod
+		ID  string
+		TTL time.Duration
+	}
+)
+
+// NewElector creates a new Elector, which elects no leader until Run
+func NewElector(options ElectorOptions) *Elector {
+	elector := &Elector{
+		Logger: options.Logger,
+		Lease:  options.Lease,
+		Name:   options.Name,
+		ID:     options.ID,
+		TTL:    options.TTL,
+	}
+	if elector.Name == "" {
+		elector.Name = "chartmuseum"
+	}
+	if elector.ID == "" {
+		elector.ID, _ = os.Hostname()
+	}
+	if elector.TTL <= 0 {
+		elector.TTL = DefaultLeaseTTL
+	}
+	return elector
+}
+
+// IsLeader returns whether this replica holds the lease. A nil Elector always leads,
+// a single replica needing no election
+func (elector *Elector) IsLeader() bool {
+	if elector == nil {
+		return true
+	}
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	return time.Now().Before(elector.until)
+}
+
+// Run campaigns for the lease until ctx is done, renewing it every third of its ttl once taken
+func (elector *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(elector.TTL / 3)
+	defer ticker.Stop()
+	for {
+		elector.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (elector *Elector) campaign(ctx context.Context) {
+	log := elector.Logger.ContextLoggingFn(&gin.Context{})
+	wasLeader := elector.IsLeader()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, elector.TTL/3)
+	defer cancel()
+	ok, err := elector.Lease.Acquire(ctx, elector.Name, elector.ID, elector.TTL)
+	if err != nil {
+		log(cm_logger.WarnLevel, "Error acquiring leader lease",
+			"lease", elector.Name,
+			"error", err.Error(),
+		)
+	}
+
+	elector.mutex.Lock()
+	if ok {
+		// the lease was taken for ttl at the latest when the call started
+		elector.until = start.Add(elector.TTL)
+	}
+	isLeader := time.Now().Before(elector.until)
+	elector.mutex.Unlock()
+
+	if isLeader && !wasLeader {
+		log(cm_logger.InfoLevel, "Elected leader, running background jobs",
+			"lease", elector.Name,
+			"id", elector.ID,
+		)
+	} else if !isLeader && wasLeader {
+		log(cm_logger.WarnLevel, "Lost leader lease, no longer running background jobs",
+			"lease", elector.Name,
+			"id", elector.ID,
+		)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	pathutil "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/alicebob/miniredis"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/suite"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+type LeaderTestSuite struct {
+	suite.Suite
+	Logger         *cm_logger.Logger
+	RedisMock      *miniredis.Miniredis
+	DynamoDBMock   *dynamoDBMock
+	KubernetesMock *kubernetesMock
+	Leases         map[string]Lease
+	Expire         map[string]func(name string)
+}
+
+// kubernetesMock serves the leases api, refusing updates of leases changed in the meantime
+type kubernetesMock struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	leases map[string]*coordinationv1.Lease
+}
+
+func newKubernetesMock() *kubernetesMock {
+	mock := &kubernetesMock{leases: map[string]*coordinationv1.Lease{}}
+	mock.Server = httptest.NewServer(http.HandlerFunc(mock.serveHTTP))
+	return mock
+}
+
+func (mock *kubernetesMock) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	prefix := "/apis/coordination.k8s.io/v1/namespaces/chartmuseum/leases"
+	if !strings.HasPrefix(r.URL.Path, prefix) || r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	var lease coordinationv1.Lease
+	if r.Method != "GET" {
+		json.NewDecoder(r.Body).Decode(&lease)
+		name = lease.Name
+	}
+	current, exists := mock.leases[name]
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == "GET" && !exists:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == "GET":
+		json.NewEncoder(w).Encode(current)
+	case r.Method == "POST" && exists, r.Method == "PUT" && (!exists || current.ResourceVersion != lease.ResourceVersion):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		version, _ := strconv.Atoi(lease.ResourceVersion)
+		lease.ResourceVersion = strconv.Itoa(version + 1)
+		mock.leases[name] = &lease
+		json.NewEncoder(w).Encode(&lease)
+	}
+}
+
+func (mock *kubernetesMock) expire(name string) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	renewTime := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	mock.leases[name].Spec.RenewTime = &renewTime
+}
+
+func (suite *LeaderTestSuite) SetupSuite() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{Debug: true})
+	suite.Nil(err, "no error creating logger")
+	suite.Logger = logger
+
+	redisMock, err := miniredis.Run()
+	suite.Nil(err, "able to create miniredis instance")
+	suite.RedisMock = redisMock
+	suite.DynamoDBMock = &dynamoDBMock{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	suite.KubernetesMock = newKubernetesMock()
+
+	tokenFile := pathutil.Join(suite.T().TempDir(), "token")
+	suite.Nil(ioutil.WriteFile(tokenFile, []byte("token\n"), 0600), "no error writing token")
+
+	suite.Leases = map[string]Lease{
+		"Redis":    NewRedisLocker(redisMock.Addr(), "", 0, 0),
+		"DynamoDB": &DynamoDBLocker{Client: suite.DynamoDBMock, Table: "locks"},
+	}
+	kubernetesLease, err := NewKubernetesLeaseForConfig(&rest.Config{Host: suite.KubernetesMock.URL, BearerTokenFile: tokenFile}, "chartmuseum")
+	suite.Nil(err, "no error creating kubernetes lease")
+	suite.Leases["Kubernetes"] = kubernetesLease
+	suite.Expire = map[string]func(string){
+		"Redis":      func(string) { suite.RedisMock.FastForward(time.Minute) },
+		"DynamoDB":   suite.DynamoDBMock.expire,
+		"Kubernetes": suite.KubernetesMock.expire,
+	}
+}
+
+func (suite *LeaderTestSuite) TearDownSuite() {
+	suite.RedisMock.Close()
+	suite.KubernetesMock.Close()
+}
+
+func (suite *LeaderTestSuite) TestAllLeases() {
+	for key, lease := range suite.Leases {
+		name := "leader-" + strings.ToLower(key)
+		acquired, err := lease.Acquire(context.Background(), name, "replica1", time.Minute)
+		suite.Nil(err, fmt.Sprintf("no error acquiring lease using %s lease", key))
+		suite.True(acquired, fmt.Sprintf("lease not held acquired using %s lease", key))
+
+		acquired, err = lease.Acquire(context.Background(), name, "replica2", time.Minute)
+		suite.Nil(err, fmt.Sprintf("no error acquiring lease held using %s lease", key))
+		suite.False(acquired, fmt.Sprintf("lease held by another replica not acquired using %s lease", key))
+
+		acquired, err = lease.Acquire(context.Background(), name, "replica1", time.Minute)
+		suite.Nil(err, fmt.Sprintf("no error renewing lease using %s lease", key))
+		suite.True(acquired, fmt.Sprintf("lease renewed by its holder using %s lease", key))
+
+		suite.Expire[key](name)
+		acquired, err = lease.Acquire(context.Background(), name, "replica2", time.Minute)
+		suite.Nil(err, fmt.Sprintf("no error acquiring expired lease using %s lease", key))
+		suite.True(acquired, fmt.Sprintf("expired lease taken over using %s lease", key))
+
+		acquired, err = lease.Acquire(context.Background(), name, "replica1", time.Minute)
+		suite.Nil(err, fmt.Sprintf("no error renewing lease taken over using %s lease", key))
+		suite.False(acquired, fmt.Sprintf("lease taken over not renewed by its former holder using %s lease", key))
+	}
+
+	suite.Equal(int32(1), *suite.KubernetesMock.leases["leader-kubernetes"].Spec.LeaseTransitions, "lease transition counted")
+	suite.Equal(int32(60), *suite.KubernetesMock.leases["leader-kubernetes"].Spec.LeaseDurationSeconds, "lease duration in seconds")
+	forbidden, err := NewKubernetesLeaseForConfig(&rest.Config{Host: suite.KubernetesMock.URL}, "other")
+	suite.Nil(err, "no error creating kubernetes lease")
+	_, err = forbidden.Acquire(context.Background(), "leader", "replica1", time.Minute)
+	suite.NotNil(err, "error acquiring lease forbidden")
+}
+
+func (suite *LeaderTestSuite) TestElector() {
+	var nilElector *Elector
+	suite.True(nilElector.IsLeader(), "nil elector always leads")
+
+	newElector := func(id string) *Elector {
+		return NewElector(ElectorOptions{
+			Logger: suite.Logger,
+			Lease:  suite.Leases["DynamoDB"],
+			Name:   "elector",
+			ID:     id,
+			TTL:    300 * time.Millisecond,
+		})
+	}
+	electors := []*Elector{newElector("replica1"), newElector("replica2")}
+	suite.Equal(300*time.Millisecond, electors[0].TTL)
+	suite.False(electors[0].IsLeader(), "no leader until run")
+
+	cancels := []context.CancelFunc{}
+	for _, elector := range electors {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+		go elector.Run(ctx)
+	}
+	leader := -1
+	suite.Eventually(func() bool {
+		for i, elector := range electors {
+			if elector.IsLeader() {
+				leader = i
+			}
+		}
+		return leader >= 0
+	}, 5*time.Second, 10*time.Millisecond, "leader elected")
+	time.Sleep(400 * time.Millisecond)
+	suite.True(electors[leader].IsLeader(), "leader renews its lease")
+	suite.False(electors[1-leader].IsLeader(), "a single leader")
+
+	cancels[leader]()
+	suite.Eventually(func() bool {
+		return electors[1-leader].IsLeader() && !electors[leader].IsLeader()
+	}, 5*time.Second, 10*time.Millisecond, "other replica elected once the leader stopped")
+	cancels[1-leader]()
+
+	elector := NewElector(ElectorOptions{Lease: suite.Leases["Redis"]})
+	suite.Equal("chartmuseum", elector.Name, "default lease name")
+	suite.NotEmpty(elector.ID, "hostname as id by default")
+	suite.Equal(DefaultLeaseTTL, elector.TTL, "default ttl")
+}
+
+func TestLeaderTestSuite(t *testing.T) {
+	suite.Run(t, new(LeaderTestSuite))
+}
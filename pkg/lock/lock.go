@@ -0,0 +1,101 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long a lock is held by a replica which stopped without releasing it
+	DefaultTTL = 30 * time.Second
+
+	retryInterval = 100 * time.Millisecond
+)
+
+type (
+	// Locker locks names across the replicas of a server sharing one storage backend
+	Locker interface {
+		// Lock waits until name is locked or ctx is done, returning the func releasing the lock.
+		// The lock is refreshed until released, it expires only if the replica holding it stops
+		Lock(ctx context.Context, name string) (func(), error)
+	}
+
+	// acquireFunc tries once to take a lock with a token, returning false if it is held
+	acquireFunc func(token string) (bool, error)
+)
+
+// acquire retries to take a lock until ctx is done, returning the token it was taken with
+func acquire(ctx context.Context, name string, try acquireFunc) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		ok, err := try(token)
+		if err != nil {
+			return "", fmt.Errorf("could not lock %s: %w", name, err)
+		}
+		if ok {
+			return token, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("could not lock %s: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// hold refreshes a lock every third of its ttl until the returned func releases it
+func hold(ttl time.Duration, refresh func(), release func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			release()
+		})
+	}
+}
+
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
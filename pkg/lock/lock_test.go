@@ -0,0 +1,193 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/suite"
+)
+
+type LockTestSuite struct {
+	suite.Suite
+	RedisMock    *miniredis.Miniredis
+	DynamoDBMock *dynamoDBMock
+	Lockers      map[string]Locker
+}
+
+// dynamoDBMock evaluates the conditions of DynamoDBLocker on an in-memory table
+type dynamoDBMock struct {
+	dynamodbiface.DynamoDBAPI
+	mutex sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (mock *dynamoDBMock) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	name := *input.Item["LockID"].S
+	if item, ok := mock.items[name]; ok && millis(item["Expires"]) >= millis(input.ExpressionAttributeValues[":now"]) {
+		if holder, renew := input.ExpressionAttributeValues[":holder"]; !renew || *holder.S != *item["Token"].S {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "held", nil)
+		}
+	}
+	mock.items[name] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (mock *dynamoDBMock) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	item, ok := mock.items[*input.Key["LockID"].S]
+	if !ok || *item["Token"].S != *input.ExpressionAttributeValues[":token"].S {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "not held", nil)
+	}
+	item["Expires"] = input.ExpressionAttributeValues[":expires"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (mock *dynamoDBMock) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	name := *input.Key["LockID"].S
+	item, ok := mock.items[name]
+	if !ok || *item["Token"].S != *input.ExpressionAttributeValues[":token"].S {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "not held", nil)
+	}
+	delete(mock.items, name)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// expire makes a lock look as if its replica stopped refreshing it
+func (mock *dynamoDBMock) expire(name string) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.items[name]["Expires"] = unixMillis(time.Now().Add(-time.Second))
+}
+
+func (mock *dynamoDBMock) held(name string) bool {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	_, ok := mock.items[name]
+	return ok
+}
+
+func millis(value *dynamodb.AttributeValue) int64 {
+	n, _ := strconv.ParseInt(*value.N, 10, 64)
+	return n
+}
+
+func (suite *LockTestSuite) SetupSuite() {
+	redisMock, err := miniredis.Run()
+	suite.Nil(err, "able to create miniredis instance")
+	suite.RedisMock = redisMock
+	suite.DynamoDBMock = &dynamoDBMock{items: map[string]map[string]*dynamodb.AttributeValue{}}
+
+	suite.Lockers = map[string]Locker{
+		"Redis":    NewRedisLocker(redisMock.Addr(), "", 0, 0),
+		"DynamoDB": &DynamoDBLocker{Client: suite.DynamoDBMock, Table: "locks", TTL: DefaultTTL},
+	}
+}
+
+func (suite *LockTestSuite) TearDownSuite() {
+	suite.RedisMock.Close()
+}
+
+func (suite *LockTestSuite) timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 300*time.Millisecond)
+}
+
+func (suite *LockTestSuite) TestAllLockers() {
+	for key, locker := range suite.Lockers {
+		unlock, err := locker.Lock(context.Background(), "org1/repo1")
+		suite.Nil(err, fmt.Sprintf("able to lock using %s locker", key))
+
+		ctx, cancel := suite.timeout()
+		_, err = locker.Lock(ctx, "org1/repo1")
+		cancel()
+		suite.True(errors.Is(err, context.DeadlineExceeded), fmt.Sprintf("lock held waited for until timeout using %s locker", key))
+
+		other, err := locker.Lock(context.Background(), "org1/repo2")
+		suite.Nil(err, fmt.Sprintf("able to lock another name using %s locker", key))
+		other()
+
+		go func() {
+			time.Sleep(150 * time.Millisecond)
+			unlock()
+		}()
+		unlock, err = locker.Lock(context.Background(), "org1/repo1")
+		suite.Nil(err, fmt.Sprintf("lock taken once released using %s locker", key))
+		unlock()
+		unlock()
+
+		unlock, err = locker.Lock(context.Background(), "org1/repo1")
+		suite.Nil(err, fmt.Sprintf("able to lock again after release using %s locker", key))
+		unlock()
+	}
+}
+
+func (suite *LockTestSuite) TestExpiry() {
+	locker := suite.Lockers["Redis"]
+	stale, err := locker.Lock(context.Background(), "repo")
+	suite.Nil(err, "able to lock")
+	suite.RedisMock.FastForward(DefaultTTL + time.Second)
+	unlock, err := locker.Lock(context.Background(), "repo")
+	suite.Nil(err, "expired lock taken over")
+	stale()
+	suite.True(suite.RedisMock.Exists("chartmuseum:lock:repo"), "lock taken over not released by its former holder")
+	unlock()
+	suite.False(suite.RedisMock.Exists("chartmuseum:lock:repo"), "lock released")
+
+	locker = suite.Lockers["DynamoDB"]
+	stale, err = locker.Lock(context.Background(), "repo")
+	suite.Nil(err, "able to lock")
+	suite.DynamoDBMock.expire("repo")
+	unlock, err = locker.Lock(context.Background(), "repo")
+	suite.Nil(err, "expired lock taken over")
+	stale()
+	suite.True(suite.DynamoDBMock.held("repo"), "lock taken over not released by its former holder")
+	unlock()
+	suite.False(suite.DynamoDBMock.held("repo"), "lock released")
+}
+
+func (suite *LockTestSuite) TestRefresh() {
+	locker := &RedisLocker{Client: suite.Lockers["Redis"].(*RedisLocker).Client, Prefix: "refresh:", TTL: 150 * time.Millisecond}
+	unlock, err := locker.Lock(context.Background(), "repo")
+	suite.Nil(err, "able to lock")
+	suite.RedisMock.SetTTL("refresh:repo", time.Millisecond)
+	suite.Eventually(func() bool {
+		return suite.RedisMock.TTL("refresh:repo") == locker.TTL
+	}, 5*time.Second, 10*time.Millisecond, "ttl refreshed while held")
+	unlock()
+	suite.False(suite.RedisMock.Exists("refresh:repo"), "lock released")
+}
+
+func TestLockTestSuite(t *testing.T) {
+	suite.Run(t, new(LockTestSuite))
+}
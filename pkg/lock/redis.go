@@ -0,0 +1,85 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// redisRefreshScript extends the ttl of a lock still held with the token
+	redisRefreshScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`
+	// redisReleaseScript deletes a lock still held with the token, never one taken over by another replica
+	redisReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+	// redisAcquireScript takes a lease not held or renews it for its holder
+	redisAcquireScript = `local holder = redis.call("get", KEYS[1])
+if holder == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end
+if not holder then redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2]) return 1 end
+return 0`
+)
+
+type (
+	// RedisLocker implements the Locker and Lease interfaces with keys set only if they do not exist
+	RedisLocker struct {
+		Client *redis.Client
+		Prefix string
+		TTL    time.Duration
+	}
+)
+
+// NewRedisLocker creates a new RedisLocker, locks expiring after ttl if not refreshed
+func NewRedisLocker(addr string, password string, db int, ttl time.Duration) *RedisLocker {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &RedisLocker{
+		Client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+		Prefix: "chartmuseum:lock:",
+		TTL:    ttl,
+	}
+}
+
+// Lock locks name, see Locker
+func (locker *RedisLocker) Lock(ctx context.Context, name string) (func(), error) {
+	key := locker.Prefix + name
+	token, err := acquire(ctx, name, func(token string) (bool, error) {
+		return locker.Client.SetNX(key, token, locker.TTL).Result()
+	})
+	if err != nil {
+		return nil, err
+	}
+	refresh := func() {
+		locker.Client.Eval(redisRefreshScript, []string{key}, token, locker.TTL.Milliseconds())
+	}
+	release := func() {
+		locker.Client.Eval(redisReleaseScript, []string{key}, token)
+	}
+	return hold(locker.TTL, refresh, release), nil
+}
+
+// Acquire takes or renews a lease, see Lease
+func (locker *RedisLocker) Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	acquired, err := locker.Client.Eval(redisAcquireScript, []string{locker.Prefix + name}, holder, ttl.Milliseconds()).Int64()
+	return acquired == 1, err
+}
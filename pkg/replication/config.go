@@ -0,0 +1,123 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package replication holds the config of chart repos periodically copied into local storage
+package replication
+
+import (
+	"fmt"
+	"io/ioutil"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+const defaultInterval = 10 * time.Minute
+
+type (
+	// Config lists the repos replicated into local storage, synced every Interval
+	Config struct {
+		Interval Duration  `json:"interval,omitempty"`
+		Sources  []*Source `json:"sources"`
+	}
+
+	// Source is a Helm chart repo, such as another ChartMuseum, replicated into a local repo
+	Source struct {
+		// URL of the repo, without index.yaml. Credentials in the URL are sent with basic auth
+		URL string `json:"url"`
+		// Repo is the local repo charts are copied to, "" at the root of storage
+		Repo string `json:"repo,omitempty"`
+		// Include and Exclude are globs matched against chart names, e.g. "nginx-*".
+		// Without includes, every chart not excluded is replicated
+		Include []string `json:"include,omitempty"`
+		Exclude []string `json:"exclude,omitempty"`
+		// Delete removes local charts matching the filters which are no longer in the source
+		Delete bool `json:"delete,omitempty"`
+	}
+
+	// Duration is a time.Duration read from strings such as "10m"
+	Duration struct {
+		time.Duration
+	}
+)
+
+// LoadConfig reads a replication config file
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigFromContent(content)
+}
+
+// ConfigFromContent parses the content of a replication config file
+func ConfigFromContent(content []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	if config.Interval.Duration <= 0 {
+		config.Interval.Duration = defaultInterval
+	}
+	for i, source := range config.Sources {
+		if source == nil || source.URL == "" {
+			return nil, fmt.Errorf("replication source %d has no url", i)
+		}
+		source.Repo = strings.Trim(source.Repo, "/")
+		for _, pattern := range append(append([]string{}, source.Include...), source.Exclude...) {
+			if _, err := pathutil.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid filter %q of replication source %s", pattern, source.URL)
+			}
+		}
+	}
+	return config, nil
+}
+
+// Matches reports whether charts with a name are replicated from the source
+func (source *Source) Matches(name string) bool {
+	for _, pattern := range source.Exclude {
+		if matched, _ := pathutil.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(source.Include) == 0 {
+		return true
+	}
+	for _, pattern := range source.Include {
+		if matched, _ := pathutil.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON reads a duration from a string such as "10m"
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s", value)
+	}
+	duration.Duration = parsed
+	return nil
+}
+
+// MarshalJSON writes a duration as a string such as "10m0s"
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + duration.String() + `"`), nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replication
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConfigTestSuite) TestConfigFromContent() {
+	config, err := ConfigFromContent([]byte(`
+interval: 30s
+sources:
+  - url: https://charts.example.com/org1
+    repo: /mirror/org1/
+    include: [nginx*, redis]
+    exclude: ["*-dev"]
+    delete: true
+  - url: https://charts.example.com/org2
+`))
+	suite.Nil(err, "no error parsing replication config")
+	suite.Equal(30*time.Second, config.Interval.Duration)
+	suite.Len(config.Sources, 2)
+	suite.Equal("mirror/org1", config.Sources[0].Repo, "repo normalized")
+	suite.True(config.Sources[0].Delete)
+	suite.False(config.Sources[1].Delete)
+
+	config, err = ConfigFromContent([]byte(`sources: [{url: "https://charts.example.com"}]`))
+	suite.Nil(err, "no error parsing replication config")
+	suite.Equal(defaultInterval, config.Interval.Duration, "default interval")
+
+	for _, content := range []string{
+		`sources: [{repo: org1}]`,
+		`sources: [{url: "https://charts.example.com", include: ["[nginx"]}]`,
+		`interval: often`,
+		`sources: not a list`,
+	} {
+		_, err = ConfigFromContent([]byte(content))
+		suite.NotNil(err, "error parsing invalid replication config: "+content)
+	}
+
+	_, err = LoadConfig("does-not-exist.yaml")
+	suite.NotNil(err, "error loading missing replication config")
+}
+
+func (suite *ConfigTestSuite) TestMatches() {
+	source := &Source{Include: []string{"nginx*", "redis"}, Exclude: []string{"*-dev"}}
+	suite.True(source.Matches("nginx"))
+	suite.True(source.Matches("nginx-ingress"))
+	suite.True(source.Matches("redis"))
+	suite.False(source.Matches("redis-cluster"), "not included")
+	suite.False(source.Matches("nginx-dev"), "excluded despite being included")
+
+	source = &Source{Exclude: []string{"*-dev"}}
+	suite.True(source.Matches("mychart"), "everything included without includes")
+	suite.False(source.Matches("mychart-dev"))
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
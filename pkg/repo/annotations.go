@@ -0,0 +1,103 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+
+	helm_chart "helm.sh/helm/v3/pkg/chart"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// AnnotationsAttachmentKind is the kind of attachment holding the annotations of a chart
+	// version set with the api, a JSON object of strings
+	AnnotationsAttachmentKind = "annotations"
+)
+
+var (
+	// ErrorInvalidAnnotations is raised when annotations are not a JSON object of strings
+	ErrorInvalidAnnotations = errors.New("invalid annotations, must be a JSON object of strings")
+)
+
+// AnnotationsFromContent parses the annotations of a chart version, as stored in their attachment
+func AnnotationsFromContent(content []byte) (map[string]string, error) {
+	annotations := map[string]string{}
+	if err := json.Unmarshal(content, &annotations); err != nil {
+		return nil, ErrorInvalidAnnotations
+	}
+	if _, ok := annotations[""]; ok {
+		return nil, ErrorInvalidAnnotations
+	}
+	return annotations, nil
+}
+
+// PatchAnnotations applies a JSON merge patch of annotations, a null value removing the annotation,
+// returning a new map
+func PatchAnnotations(annotations map[string]string, patch []byte) (map[string]string, error) {
+	changes := map[string]*string{}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, ErrorInvalidAnnotations
+	}
+	patched := make(map[string]string, len(annotations)+len(changes))
+	for key, value := range annotations {
+		patched[key] = value
+	}
+	for key, value := range changes {
+		if key == "" {
+			return nil, ErrorInvalidAnnotations
+		}
+		if value == nil {
+			delete(patched, key)
+		} else {
+			patched[key] = *value
+		}
+	}
+	return patched, nil
+}
+
+// ChartVersionWithAnnotations returns a copy of a chart version with annotations added to those of
+// its Chart.yaml, which they override. The chart version is left as is, as it may be cached
+func ChartVersionWithAnnotations(chartVersion *helm_repo.ChartVersion, annotations map[string]string) *helm_repo.ChartVersion {
+	if len(annotations) == 0 {
+		return chartVersion
+	}
+	metadata := helm_chart.Metadata{}
+	if chartVersion.Metadata != nil {
+		metadata = *chartVersion.Metadata
+	}
+	metadata.Annotations = MergeAnnotations(metadata.Annotations, annotations)
+	annotated := *chartVersion
+	annotated.Metadata = &metadata
+	return &annotated
+}
+
+// MergeAnnotations returns the annotations of a chart with those set with the api, which override them
+func MergeAnnotations(chartAnnotations map[string]string, annotations map[string]string) map[string]string {
+	if len(chartAnnotations) == 0 && len(annotations) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(chartAnnotations)+len(annotations))
+	for key, value := range chartAnnotations {
+		merged[key] = value
+	}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,77 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	helm_chart "helm.sh/helm/v3/pkg/chart"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+type AnnotationsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AnnotationsTestSuite) TestAnnotationsFromContent() {
+	annotations, err := AnnotationsFromContent([]byte(`{"approved-for-prod": "true"}`))
+	suite.Nil(err, "no error parsing annotations")
+	suite.Equal(map[string]string{"approved-for-prod": "true"}, annotations)
+
+	for _, content := range []string{"", "approved", `{"approved-for-prod": true}`, `["approved-for-prod"]`, `{"": "true"}`} {
+		_, err = AnnotationsFromContent([]byte(content))
+		suite.Equal(ErrorInvalidAnnotations, err, "invalid annotations %q", content)
+	}
+}
+
+func (suite *AnnotationsTestSuite) TestPatchAnnotations() {
+	annotations := map[string]string{"approved-for-prod": "false", "owner": "team-a"}
+	patched, err := PatchAnnotations(annotations, []byte(`{"approved-for-prod": "true", "owner": null, "ticket": "OPS-1"}`))
+	suite.Nil(err, "no error patching annotations")
+	suite.Equal(map[string]string{"approved-for-prod": "true", "ticket": "OPS-1"}, patched)
+	suite.Equal("false", annotations["approved-for-prod"], "annotations patched left as is")
+
+	patched, err = PatchAnnotations(nil, []byte(`{"owner": null}`))
+	suite.Nil(err, "no error removing missing annotation")
+	suite.Empty(patched)
+
+	for _, patch := range []string{"", `{"owner": 1}`, `{"": "team-a"}`} {
+		_, err = PatchAnnotations(annotations, []byte(patch))
+		suite.Equal(ErrorInvalidAnnotations, err, "invalid patch %q", patch)
+	}
+}
+
+func (suite *AnnotationsTestSuite) TestChartVersionWithAnnotations() {
+	chartVersion := &helm_repo.ChartVersion{Metadata: &helm_chart.Metadata{
+		Name:        "mychart",
+		Annotations: map[string]string{"category": "database", "owner": "team-a"},
+	}}
+	annotated := ChartVersionWithAnnotations(chartVersion, map[string]string{"owner": "team-b", "approved-for-prod": "true"})
+	suite.Equal("mychart", annotated.Name)
+	suite.Equal(map[string]string{"category": "database", "owner": "team-b", "approved-for-prod": "true"}, annotated.Annotations,
+		"annotations set with the api override those of Chart.yaml")
+	suite.Equal("team-a", chartVersion.Annotations["owner"], "chart version left as is")
+	suite.Len(chartVersion.Annotations, 2, "chart version left as is")
+
+	suite.Equal(chartVersion, ChartVersionWithAnnotations(chartVersion, nil), "chart version without annotations")
+	suite.Nil(MergeAnnotations(nil, nil), "no annotations")
+}
+
+func TestAnnotationsTestSuite(t *testing.T) {
+	suite.Run(t, new(AnnotationsTestSuite))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chartmuseum/storage"
+)
+
+const (
+	// ProvenanceAttachmentKind is the kind of attachment of provenance files
+	ProvenanceAttachmentKind = "prov"
+	// SBOMAttachmentKind is the kind of attachment of SBOMs
+	SBOMAttachmentKind = "sbom"
+)
+
+var (
+	// ErrorInvalidAttachmentKind is raised when the kind of an attachment cannot be a file extension
+	ErrorInvalidAttachmentKind = errors.New("invalid attachment kind, must be lowercase letters, digits and dashes")
+
+	attachmentKindRegex = regexp.MustCompile("^[a-z0-9][a-z0-9-]{0,62}$")
+)
+
+// ValidateAttachmentKind checks that the kind of an attachment can be the extension of its file.
+// The kind of chart packages is rejected, so attachments are never taken for charts
+func ValidateAttachmentKind(kind string) error {
+	if !attachmentKindRegex.MatchString(kind) || kind == ChartPackageFileExtension {
+		return ErrorInvalidAttachmentKind
+	}
+	return nil
+}
+
+// AttachmentFilenameFromNameVersion returns the filename of an attachment of a chart version,
+// stored next to its package, e.g. mychart-0.1.0.tgz.sbom
+func AttachmentFilenameFromNameVersion(name string, version string, kind string) string {
+	return fmt.Sprintf("%s.%s", ChartPackageFilenameFromNameVersion(name, version), kind)
+}
+
+// AttachmentKindsFromObjects returns the sorted kinds of the attachments of a chart version
+// among the objects of its repo, or of several repos
+func AttachmentKindsFromObjects(objects []storage.Object, name string, version string) []string {
+	prefix := ChartPackageFilenameFromNameVersion(name, version) + "."
+	kinds := []string{}
+	found := map[string]bool{}
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Path, prefix) {
+			continue
+		}
+		kind := strings.TrimPrefix(object.Path, prefix)
+		if ValidateAttachmentKind(kind) == nil && !found[kind] {
+			found[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,64 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type AttachmentTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AttachmentTestSuite) TestValidateAttachmentKind() {
+	for _, kind := range []string{"prov", "sbom", "test-report", "cosign-sig", "v2"} {
+		suite.Nil(ValidateAttachmentKind(kind), "valid kind %s", kind)
+	}
+	for _, kind := range []string{"", "tgz", "Report", "test.report", "../prov", "-report", "report_1"} {
+		suite.Equal(ErrorInvalidAttachmentKind, ValidateAttachmentKind(kind), "invalid kind %q", kind)
+	}
+}
+
+func (suite *AttachmentTestSuite) TestAttachmentFilenameFromNameVersion() {
+	suite.Equal("mychart-0.1.0.tgz.test-report", AttachmentFilenameFromNameVersion("mychart", "0.1.0", "test-report"))
+	suite.Equal(ProvenanceFilenameFromNameVersion("mychart", "0.1.0"), AttachmentFilenameFromNameVersion("mychart", "0.1.0", ProvenanceAttachmentKind))
+	suite.Equal(SBOMFilenameFromNameVersion("mychart", "0.1.0"), AttachmentFilenameFromNameVersion("mychart", "0.1.0", SBOMAttachmentKind))
+}
+
+func (suite *AttachmentTestSuite) TestAttachmentKindsFromObjects() {
+	objects := []storage.Object{
+		{Path: "mychart-0.1.0.tgz"},
+		{Path: "mychart-0.1.0.tgz.sbom"},
+		{Path: "mychart-0.1.0.tgz.prov"},
+		{Path: "mychart-0.1.0.tgz.prov"},
+		{Path: "mychart-0.1.0.tgz.scan.json"},
+		{Path: "mychart-0.1.0.tgz.test-report"},
+		{Path: "mychart-0.2.0.tgz.prov"},
+		{Path: "otherchart-0.1.0.tgz.prov"},
+		{Path: "index-cache.yaml"},
+	}
+	suite.Equal([]string{"prov", "sbom", "test-report"}, AttachmentKindsFromObjects(objects, "mychart", "0.1.0"))
+	suite.Equal([]string{"prov"}, AttachmentKindsFromObjects(objects, "mychart", "0.2.0"))
+	suite.Equal([]string{}, AttachmentKindsFromObjects(objects, "mychart", "0.3.0"))
+}
+
+func TestAttachmentTestSuite(t *testing.T) {
+	suite.Run(t, new(AttachmentTestSuite))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// ChartChannelsFileExtension is the file extension used for the channels of chart names
+	ChartChannelsFileExtension = "channels"
+)
+
+var (
+	// ErrorInvalidChannelName is raised when a channel name has other characters than lowercase letters,
+	// digits and dashes
+	ErrorInvalidChannelName = errors.New("invalid channel name, must be lowercase letters, digits and dashes")
+
+	// ErrorInvalidChartChannels is raised when the channels of a chart are not a JSON object of channel
+	// names and versions
+	ErrorInvalidChartChannels = errors.New("invalid chart channels, must be a JSON object of channels and versions")
+
+	channelNameRegex = regexp.MustCompile("^[a-z0-9][a-z0-9-]{0,62}$")
+)
+
+// ValidateChannelName checks that a channel name, e.g. stable or canary, may be used in urls
+func ValidateChannelName(channel string) error {
+	if !channelNameRegex.MatchString(channel) {
+		return ErrorInvalidChannelName
+	}
+	return nil
+}
+
+// ChartChannelsFilenameFromName returns the filename of the channels of a chart name, e.g. mychart.channels,
+// mapping each channel to a version of the chart
+func ChartChannelsFilenameFromName(name string) string {
+	return fmt.Sprintf("%s.%s", name, ChartChannelsFileExtension)
+}
+
+// ChartChannelsFromContent parses the channels of a chart, e.g. {"stable": "1.2.0", "canary": "1.3.0-rc.1"}
+func ChartChannelsFromContent(content []byte) (map[string]string, error) {
+	channels := map[string]string{}
+	if err := json.Unmarshal(content, &channels); err != nil || channels == nil {
+		return nil, ErrorInvalidChartChannels
+	}
+	for channel, version := range channels {
+		if ValidateChannelName(channel) != nil || version == "" {
+			return nil, ErrorInvalidChartChannels
+		}
+	}
+	return channels, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ChannelsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ChannelsTestSuite) TestChartChannelsFilenameFromName() {
+	suite.Equal("mychart.channels", ChartChannelsFilenameFromName("mychart"))
+}
+
+func (suite *ChannelsTestSuite) TestValidateChannelName() {
+	for _, channel := range []string{"stable", "canary", "v1", "release-2"} {
+		suite.Nil(ValidateChannelName(channel), "valid channel %q", channel)
+	}
+	for _, channel := range []string{"", "Stable", "-stable", "stable/1", "stable.1", "stable channel"} {
+		suite.Equal(ErrorInvalidChannelName, ValidateChannelName(channel), "invalid channel %q", channel)
+	}
+}
+
+func (suite *ChannelsTestSuite) TestChartChannelsFromContent() {
+	channels, err := ChartChannelsFromContent([]byte(`{"stable": "0.1.0", "canary": "0.2.0"}`))
+	suite.Nil(err, "no error parsing channels")
+	suite.Equal(map[string]string{"stable": "0.1.0", "canary": "0.2.0"}, channels)
+
+	channels, err = ChartChannelsFromContent([]byte(`{}`))
+	suite.Nil(err, "no error parsing no channels")
+	suite.Empty(channels)
+
+	for _, content := range []string{"", "null", `["stable"]`, `{"stable": ""}`, `{"Stable": "0.1.0"}`, `{"stable": 1}`} {
+		_, err = ChartChannelsFromContent([]byte(content))
+		suite.Equal(ErrorInvalidChartChannels, err, "invalid channels %q", content)
+	}
+}
+
+func TestChannelsTestSuite(t *testing.T) {
+	suite.Run(t, new(ChannelsTestSuite))
+}
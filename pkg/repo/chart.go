@@ -17,14 +17,19 @@ limitations under the License.
 package repo
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	pathutil "path"
 	"strconv"
 	"strings"
 
 	"github.com/chartmuseum/storage"
+	"github.com/ghodss/yaml"
 	helm_chart "helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	helm_repo "helm.sh/helm/v3/pkg/repo"
@@ -47,6 +52,13 @@ func ChartPackageFilenameFromNameVersion(name string, version string) string {
 	return filename
 }
 
+// ChartNameVersionFromPackageFilename returns the name and version of a chart from
+// the filename of its package, e.g. mychart-0.1.0.tgz
+func ChartNameVersionFromPackageFilename(filename string) (string, string) {
+	chartVersion := emptyChartVersionFromPackageFilename(filename)
+	return chartVersion.Name, chartVersion.Version
+}
+
 // ChartPackageFilenameFromContent returns a chart filename from binary content
 func ChartPackageFilenameFromContent(content []byte) (string, error) {
 	chart, err := chartFromContent(content)
@@ -105,6 +117,93 @@ func StorageObjectFromChartVersion(chartVersion *helm_repo.ChartVersion) storage
 	return object
 }
 
+// OverrideChartVersion returns a chart package with the version and app version of its
+// Chart.yaml replaced, leaving either of them untouched if empty
+func OverrideChartVersion(content []byte, version string, appVersion string) ([]byte, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	overridden := false
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrorInvalidChartPackage
+		}
+		file, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, ErrorInvalidChartPackage
+		}
+		// the Chart.yaml of the chart itself, not of its subcharts
+		if parts := strings.Split(strings.TrimPrefix(header.Name, "./"), "/"); len(parts) == 2 && parts[1] == "Chart.yaml" {
+			metadata := map[string]interface{}{}
+			if err := yaml.Unmarshal(file, &metadata); err != nil {
+				return nil, ErrorInvalidChartPackage
+			}
+			if version != "" {
+				metadata["version"] = version
+			}
+			if appVersion != "" {
+				metadata["appVersion"] = appVersion
+			}
+			if file, err = yaml.Marshal(metadata); err != nil {
+				return nil, err
+			}
+			header.Size = int64(len(file))
+			overridden = true
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(file); err != nil {
+			return nil, err
+		}
+	}
+	if !overridden {
+		return nil, ErrorInvalidChartPackage
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	chart, err := chartFromContent(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := chart.Validate(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ChartFileFromContent returns the first file at the root of a chart package named one of
+// filenames, case-insensitively, such as its README.md or values.yaml, or nil if there is none
+func ChartFileFromContent(content []byte, filenames ...string) ([]byte, error) {
+	chart, err := chartFromContent(content)
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	for _, filename := range filenames {
+		for _, file := range chart.Raw {
+			if strings.EqualFold(file.Name, filename) {
+				return file.Data, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
 func chartFromContent(content []byte) (*helm_chart.Chart, error) {
 	chart, err := loader.LoadArchive(bytes.NewBuffer(content))
 	return chart, err
@@ -199,6 +199,44 @@ func (suite *ChartTestSuite) TestChartPackageFilenameFromContent() {
 	suite.Equal("mychart-0.1.0.tgz", filename, "chart tarball filename as expected")
 }
 
+func (suite *ChartTestSuite) TestChartFileFromContent() {
+	file, err := ChartFileFromContent(suite.TarballContent, "templates/POD.yaml")
+	suite.Nil(err, "no error reading chart file")
+	suite.Contains(string(file), "kind: Pod", "file found case-insensitively")
+
+	file, err = ChartFileFromContent(suite.TarballContent, "README.md", "values.yaml")
+	suite.Nil(err, "no error reading missing chart file")
+	suite.Nil(file, "no file when the chart has none")
+
+	_, err = ChartFileFromContent([]byte("not a chart"), "README.md")
+	suite.Equal(ErrorInvalidChartPackage, err, "error with invalid chart package")
+}
+
+func (suite *ChartTestSuite) TestOverrideChartVersion() {
+	content, err := OverrideChartVersion(suite.TarballContent, "1.2.3-rc.1", "2.0")
+	suite.Nil(err, "no error overriding chart version")
+	chart, err := chartFromContent(content)
+	suite.Nil(err, "no error loading overridden chart")
+	suite.Equal("mychart", chart.Metadata.Name, "name kept")
+	suite.Equal("1.2.3-rc.1", chart.Metadata.Version, "version overridden")
+	suite.Equal("2.0", chart.Metadata.AppVersion, "app version overridden")
+	original, err := chartFromContent(suite.TarballContent)
+	suite.Nil(err, "no error loading test tarball")
+	suite.Equal(len(original.Templates), len(chart.Templates), "other files kept")
+
+	content, err = OverrideChartVersion(suite.TarballContent, "", "3.0")
+	suite.Nil(err, "no error overriding app version only")
+	chart, err = chartFromContent(content)
+	suite.Nil(err, "no error loading overridden chart")
+	suite.Equal("0.1.0", chart.Metadata.Version, "version kept")
+	suite.Equal("3.0", chart.Metadata.AppVersion)
+
+	_, err = OverrideChartVersion(suite.TarballContent, "not-a-version", "")
+	suite.NotNil(err, "error overriding with invalid version")
+	_, err = OverrideChartVersion([]byte("not a chart"), "1.2.3", "")
+	suite.Equal(ErrorInvalidChartPackage, err, "error overriding invalid chart package")
+}
+
 func TestChartTestSuite(t *testing.T) {
 	suite.Run(t, new(ChartTestSuite))
 }
@@ -0,0 +1,161 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	helm_chart "helm.sh/helm/v3/pkg/chart"
+)
+
+type (
+	// ChartDiff is what changed between two versions of a chart: the fields of Chart.yaml, the
+	// default values by path, e.g. "image.tag", and the files of the packages
+	ChartDiff struct {
+		Metadata []Change   `json:"metadata"`
+		Values   []Change   `json:"values"`
+		Files    FileChange `json:"files"`
+	}
+
+	// Change is a field added, removed or changed. From is unset for fields added, and To for
+	// fields removed
+	Change struct {
+		Path string      `json:"path"`
+		From interface{} `json:"from,omitempty"`
+		To   interface{} `json:"to,omitempty"`
+	}
+
+	// FileChange lists the files of a chart package added, removed or with another content
+	FileChange struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	}
+)
+
+// DiffChartPackages returns what changed from the chart package from to the chart package to
+func DiffChartPackages(from []byte, to []byte) (*ChartDiff, error) {
+	fromChart, err := chartFromContent(from)
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	toChart, err := chartFromContent(to)
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+
+	fromMetadata, err := metadataFields(fromChart.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	toMetadata, err := metadataFields(toChart.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// values are compared by path, lists being compared as a whole
+	fromValues := map[string]interface{}{}
+	flattenValues(fromValues, "", fromChart.Values)
+	toValues := map[string]interface{}{}
+	flattenValues(toValues, "", toChart.Values)
+
+	return &ChartDiff{
+		Metadata: diffFields(fromMetadata, toMetadata),
+		Values:   diffFields(fromValues, toValues),
+		Files:    diffFiles(fromChart.Raw, toChart.Raw),
+	}, nil
+}
+
+// metadataFields returns the fields of Chart.yaml by their name in the file
+func metadataFields(metadata *helm_chart.Metadata) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if metadata == nil {
+		return fields, nil
+	}
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(raw, &fields)
+	return fields, err
+}
+
+func flattenValues(flat map[string]interface{}, prefix string, values map[string]interface{}) {
+	for key, value := range values {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenValues(flat, path, nested)
+			continue
+		}
+		flat[path] = value
+	}
+}
+
+// diffFields returns the changes of fields sorted by path
+func diffFields(from map[string]interface{}, to map[string]interface{}) []Change {
+	changes := []Change{}
+	for path, fromValue := range from {
+		toValue, ok := to[path]
+		if !ok {
+			changes = append(changes, Change{Path: path, From: fromValue})
+		} else if !reflect.DeepEqual(fromValue, toValue) {
+			changes = append(changes, Change{Path: path, From: fromValue, To: toValue})
+		}
+	}
+	for path, toValue := range to {
+		if _, ok := from[path]; !ok {
+			changes = append(changes, Change{Path: path, To: toValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func diffFiles(from []*helm_chart.File, to []*helm_chart.File) FileChange {
+	change := FileChange{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	fromFiles := map[string][]byte{}
+	for _, file := range from {
+		fromFiles[file.Name] = file.Data
+	}
+	toFiles := map[string][]byte{}
+	for _, file := range to {
+		toFiles[file.Name] = file.Data
+		fromData, ok := fromFiles[file.Name]
+		if !ok {
+			change.Added = append(change.Added, file.Name)
+		} else if !bytes.Equal(fromData, file.Data) {
+			change.Modified = append(change.Modified, file.Name)
+		}
+	}
+	for _, file := range from {
+		if _, ok := toFiles[file.Name]; !ok {
+			change.Removed = append(change.Removed, file.Name)
+		}
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+	sort.Strings(change.Modified)
+	return change
+}
@@ -0,0 +1,83 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+type DiffTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DiffTestSuite) packageChart(chart *chart.Chart) []byte {
+	filename, err := chartutil.Save(chart, suite.T().TempDir())
+	suite.Nil(err, "no error packaging chart")
+	content, err := ioutil.ReadFile(filename)
+	suite.Nil(err, "no error reading chart package")
+	return content
+}
+
+func (suite *DiffTestSuite) TestDiffChartPackages() {
+	from, err := loader.LoadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error loading test chart")
+	from.Raw = append(from.Raw, &chart.File{Name: "values.yaml", Data: []byte("replicas: 1\nimage:\n  repository: nginx\n  tag: \"1.20\"\ndebug: false\n")})
+	fromContent := suite.packageChart(from)
+
+	to, err := loader.LoadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error loading test chart")
+	to.Metadata.Version = "0.2.0"
+	to.Metadata.Keywords = []string{"web"}
+	to.Raw = append(to.Raw, &chart.File{Name: "values.yaml", Data: []byte("replicas: 1\nimage:\n  repository: nginx\n  tag: \"1.21\"\nservice:\n  port: 80\n")})
+	to.Templates = nil
+	to.Files = append(to.Files, &chart.File{Name: "README.md", Data: []byte("# mychart")})
+	toContent := suite.packageChart(to)
+
+	diff, err := DiffChartPackages(fromContent, toContent)
+	suite.Nil(err, "no error diffing chart packages")
+	suite.Equal([]Change{
+		{Path: "keywords", To: []interface{}{"web"}},
+		{Path: "version", From: "0.1.0", To: "0.2.0"},
+	}, diff.Metadata, "Chart.yaml changes")
+	suite.Equal([]Change{
+		{Path: "debug", From: false},
+		{Path: "image.tag", From: "1.20", To: "1.21"},
+		{Path: "service.port", To: float64(80)},
+	}, diff.Values, "values changes by path")
+	suite.Equal([]string{"README.md"}, diff.Files.Added)
+	suite.Equal([]string{"templates/pod.yaml"}, diff.Files.Removed)
+	suite.Equal([]string{"Chart.yaml", "values.yaml"}, diff.Files.Modified)
+
+	diff, err = DiffChartPackages(fromContent, fromContent)
+	suite.Nil(err, "no error diffing a chart package with itself")
+	suite.Empty(diff.Metadata, "no metadata changes")
+	suite.Empty(diff.Values, "no values changes")
+	suite.Empty(diff.Files.Modified, "no files changes")
+
+	_, err = DiffChartPackages([]byte("not a chart"), toContent)
+	suite.Equal(ErrorInvalidChartPackage, err, "error diffing an invalid chart package")
+}
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
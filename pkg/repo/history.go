@@ -0,0 +1,68 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// ChartHistoryFileExtension is the file extension used for the history of chart names
+	ChartHistoryFileExtension = "history"
+
+	// ChartVersionUploaded is the action of the first upload of a chart version
+	ChartVersionUploaded = "uploaded"
+	// ChartVersionOverwritten is the action of an upload replacing a chart version
+	ChartVersionOverwritten = "overwritten"
+	// ChartVersionDeleted is the action of the deletion of a chart version
+	ChartVersionDeleted = "deleted"
+)
+
+var (
+	// ErrorInvalidChartHistory is raised when the history of a chart is not a JSON list of events
+	ErrorInvalidChartHistory = errors.New("invalid chart history, must be a JSON list of events")
+)
+
+type (
+	// ChartHistoryEvent is a change of a version of a chart, by the user of the request making it if known
+	ChartHistoryEvent struct {
+		Version   string    `json:"version"`
+		Action    string    `json:"action"`
+		Time      time.Time `json:"time"`
+		User      string    `json:"user,omitempty"`
+		Digest    string    `json:"digest,omitempty"`
+		RequestID string    `json:"requestId,omitempty"`
+	}
+)
+
+// ChartHistoryFilenameFromName returns the filename of the history of a chart name, e.g. mychart.history,
+// shared by all its versions
+func ChartHistoryFilenameFromName(name string) string {
+	return fmt.Sprintf("%s.%s", name, ChartHistoryFileExtension)
+}
+
+// ChartHistoryFromContent parses the history of a chart, its events in the order they happened
+func ChartHistoryFromContent(content []byte) ([]ChartHistoryEvent, error) {
+	history := []ChartHistoryEvent{}
+	if err := json.Unmarshal(content, &history); err != nil {
+		return nil, ErrorInvalidChartHistory
+	}
+	return history, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HistoryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *HistoryTestSuite) TestChartHistoryFilenameFromName() {
+	suite.Equal("mychart.history", ChartHistoryFilenameFromName("mychart"))
+}
+
+func (suite *HistoryTestSuite) TestChartHistoryFromContent() {
+	history, err := ChartHistoryFromContent([]byte(`[
+		{"version": "0.1.0", "action": "uploaded", "time": "2023-09-01T10:00:00Z", "user": "alice", "digest": "abc"},
+		{"version": "0.1.0", "action": "deleted", "time": "2023-09-02T10:00:00Z"}
+	]`))
+	suite.Nil(err, "no error parsing history")
+	suite.Len(history, 2)
+	suite.Equal(ChartHistoryEvent{
+		Version: "0.1.0",
+		Action:  ChartVersionUploaded,
+		Time:    time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC),
+		User:    "alice",
+		Digest:  "abc",
+	}, history[0])
+	suite.Equal(ChartVersionDeleted, history[1].Action)
+
+	for _, content := range []string{"", "uploaded", `{"version": "0.1.0"}`, `[1]`} {
+		_, err = ChartHistoryFromContent([]byte(content))
+		suite.Equal(ErrorInvalidChartHistory, err, "invalid history %q", content)
+	}
+}
+
+func TestHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(HistoryTestSuite))
+}
@@ -0,0 +1,109 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	pathutil "path"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// IconAttachmentKind is the kind of attachment holding the icon of a chart version,
+// uploaded with the api or cached from the icon url of its Chart.yaml
+const IconAttachmentKind = "icon"
+
+// iconFilenames are looked up at the root of chart packages whose Chart.yaml has no icon in the package
+var iconFilenames = []string{"icon.svg", "icon.png", "icon.jpg", "icon.jpeg", "icon.gif", "icon.webp"}
+
+// ChartIconFromContent returns the icon of a chart package along with its filename, the file
+// the icon of its Chart.yaml points to if it is a path in the package, e.g. "icon.png" or
+// "file://assets/icon.svg", or else a conventional icon.svg, icon.png... at its root. Without
+// an icon in the package, the icon of its Chart.yaml is returned, such as a remote url, if any
+func ChartIconFromContent(content []byte) ([]byte, string, error) {
+	chart, err := chartFromContent(content)
+	if err != nil {
+		return nil, "", ErrorInvalidChartPackage
+	}
+	icon := chart.Metadata.Icon
+	filenames := iconFilenames
+	if path := strings.TrimPrefix(icon, "file://"); icon != "" && !strings.Contains(path, "://") {
+		filenames = append([]string{pathutil.Clean(strings.TrimPrefix(path, "/"))}, filenames...)
+	}
+	for _, filename := range filenames {
+		for _, file := range chart.Raw {
+			if strings.EqualFold(file.Name, filename) {
+				return file.Data, file.Name, nil
+			}
+		}
+	}
+	return nil, icon, nil
+}
+
+// IconContentType returns the content type of an icon from its filename if any, or else from its
+// content, which is sniffed for svg as http.DetectContentType does not recognize it
+func IconContentType(filename string, content []byte) string {
+	if contentType := mime.TypeByExtension(pathutil.Ext(filename)); strings.HasPrefix(contentType, "image/") {
+		return contentType
+	}
+	contentType := http.DetectContentType(content)
+	if !strings.HasPrefix(contentType, "image/") && bytes.Contains(content, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	return contentType
+}
+
+// WithIconURL returns a copy of an index whose chart versions having an icon have it
+// at iconURL/<name>/<version>/icon, e.g. https://example.com/api/charts
+func (index *Index) WithIconURL(iconURL string) (*Index, error) {
+	index.chartURLVariantsMutex.Lock()
+	defer index.chartURLVariantsMutex.Unlock()
+	if variant, ok := index.iconURLVariants[iconURL]; ok {
+		return variant, nil
+	}
+
+	variant := index.Copy()
+	for _, chartVersions := range variant.Entries {
+		for i, chartVersion := range chartVersions {
+			if chartVersion.Metadata == nil || chartVersion.Icon == "" {
+				continue
+			}
+			cv := *chartVersion
+			metadata := *chartVersion.Metadata
+			metadata.Icon = iconURL + "/" + cv.Name + "/" + cv.Version + "/icon"
+			cv.Metadata = &metadata
+			chartVersions[i] = &cv
+		}
+	}
+	raw, err := yaml.Marshal(variant.IndexFile)
+	if err != nil {
+		return nil, err
+	}
+	variant.Raw = raw
+
+	// bounded, as icon URLs come from request headers
+	if index.iconURLVariants == nil {
+		index.iconURLVariants = map[string]*Index{}
+	}
+	if len(index.iconURLVariants) < maxChartURLVariants {
+		index.iconURLVariants[iconURL] = variant
+	}
+	return variant, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+var (
+	pngIcon = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	svgIcon = []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+)
+
+type IconTestSuite struct {
+	suite.Suite
+}
+
+func (suite *IconTestSuite) chartWithIcon(icon string, files ...*chart.File) []byte {
+	c, err := loader.LoadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error loading test chart")
+	c.Metadata.Icon = icon
+	c.Files = append(c.Files, files...)
+	filename, err := chartutil.Save(c, suite.T().TempDir())
+	suite.Nil(err, "no error packaging chart")
+	content, err := ioutil.ReadFile(filename)
+	suite.Nil(err, "no error reading chart package")
+	return content
+}
+
+func (suite *IconTestSuite) TestChartIconFromContent() {
+	content := suite.chartWithIcon("https://example.com/icon.png")
+	icon, filename, err := ChartIconFromContent(content)
+	suite.Nil(err, "no error getting icon of chart")
+	suite.Nil(icon, "no icon in package")
+	suite.Equal("https://example.com/icon.png", filename, "remote icon of Chart.yaml")
+
+	content = suite.chartWithIcon("https://example.com/icon.png", &chart.File{Name: "icon.png", Data: pngIcon})
+	icon, filename, err = ChartIconFromContent(content)
+	suite.Nil(err, "no error getting icon of chart")
+	suite.Equal(pngIcon, icon, "conventional icon of package")
+	suite.Equal("icon.png", filename)
+
+	content = suite.chartWithIcon("file://assets/logo.svg",
+		&chart.File{Name: "icon.png", Data: pngIcon},
+		&chart.File{Name: "assets/logo.svg", Data: svgIcon},
+	)
+	icon, filename, err = ChartIconFromContent(content)
+	suite.Nil(err, "no error getting icon of chart")
+	suite.Equal(svgIcon, icon, "icon of Chart.yaml in package first")
+	suite.Equal("assets/logo.svg", filename)
+
+	icon, filename, err = ChartIconFromContent(suite.chartWithIcon(""))
+	suite.Nil(err, "no error getting icon of chart")
+	suite.Nil(icon, "no icon")
+	suite.Empty(filename)
+
+	_, _, err = ChartIconFromContent([]byte("not a chart"))
+	suite.Equal(ErrorInvalidChartPackage, err, "error getting icon of invalid chart")
+}
+
+func (suite *IconTestSuite) TestIconContentType() {
+	suite.Equal("image/png", IconContentType("icon.png", pngIcon))
+	suite.Equal("image/png", IconContentType("", pngIcon), "png sniffed")
+	suite.Equal("image/svg+xml", IconContentType("assets/logo.svg", svgIcon))
+	suite.Equal("image/svg+xml", IconContentType("", svgIcon), "svg sniffed")
+	suite.Equal("text/plain; charset=utf-8", IconContentType("icon", []byte("not an icon")))
+}
+
+func (suite *IconTestSuite) TestWithIconURL() {
+	index := NewIndex("", "", &ServerInfo{})
+	index.AddEntry(&helm_repo.ChartVersion{
+		Metadata: &chart.Metadata{Name: "a", Version: "0.1.0", Icon: "https://example.com/a.png"},
+		URLs:     []string{"charts/a-0.1.0.tgz"},
+	})
+	index.AddEntry(&helm_repo.ChartVersion{
+		Metadata: &chart.Metadata{Name: "b", Version: "0.1.0"},
+		URLs:     []string{"charts/b-0.1.0.tgz"},
+	})
+	suite.Nil(index.Regenerate(), "no error regenerating index")
+
+	variant, err := index.WithIconURL("https://charts.example.com/api/org1/charts")
+	suite.Nil(err, "no error rewriting icon urls")
+	suite.Equal("https://charts.example.com/api/org1/charts/a/0.1.0/icon", variant.Entries["a"][0].Icon, "icon rewritten")
+	suite.Empty(variant.Entries["b"][0].Icon, "no icon added")
+	suite.Contains(string(variant.Raw), "icon: https://charts.example.com/api/org1/charts/a/0.1.0/icon")
+	suite.Equal("https://example.com/a.png", index.Entries["a"][0].Icon, "original index unchanged")
+
+	again, err := index.WithIconURL("https://charts.example.com/api/org1/charts")
+	suite.Nil(err, "no error rewriting icon urls again")
+	suite.True(variant == again, "variant reused")
+}
+
+func TestIconTestSuite(t *testing.T) {
+	suite.Run(t, new(IconTestSuite))
+}
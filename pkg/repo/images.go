@@ -0,0 +1,122 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	helm_chart "helm.sh/helm/v3/pkg/chart"
+)
+
+// imageLineRegex matches image fields of templates with a literal image, not a template expression
+var imageLineRegex = regexp.MustCompile(`(?m)^[\s-]*image:\s*["']?([^"'\s{}#]+)["']?\s*$`)
+
+// ChartImagesFromContent returns the container images a chart package refers to, without
+// rendering it: the images of the default values of the chart and its dependencies, either
+// strings or maps of registry, repository, tag and digest, and the literal images of templates.
+// Images without a tag are given the appVersion of their chart, as charts commonly do
+func ChartImagesFromContent(content []byte) ([]string, error) {
+	chart, err := chartFromContent(content)
+	if err != nil {
+		return nil, ErrorInvalidChartPackage
+	}
+	found := map[string]bool{}
+	addChartImages(found, chart)
+	images := []string{}
+	for image := range found {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+func addChartImages(found map[string]bool, chart *helm_chart.Chart) {
+	appVersion := ""
+	if chart.Metadata != nil {
+		appVersion = chart.Metadata.AppVersion
+	}
+	addValuesImages(found, chart.Values, appVersion)
+	for _, template := range chart.Templates {
+		for _, match := range imageLineRegex.FindAllSubmatch(template.Data, -1) {
+			found[string(match[1])] = true
+		}
+	}
+	for _, dependency := range chart.Dependencies() {
+		addChartImages(found, dependency)
+	}
+}
+
+func addValuesImages(found map[string]bool, values map[string]interface{}, appVersion string) {
+	for key, value := range values {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if key == "image" {
+				if image := imageFromValues(v, appVersion); image != "" {
+					found[image] = true
+					continue
+				}
+			}
+			addValuesImages(found, v, appVersion)
+		case []interface{}:
+			for _, item := range v {
+				if itemValues, ok := item.(map[string]interface{}); ok {
+					addValuesImages(found, itemValues, appVersion)
+				}
+			}
+		case string:
+			if key == "image" && v != "" && !strings.Contains(v, "{{") {
+				found[v] = true
+			}
+		}
+	}
+}
+
+// imageFromValues returns the image of the values of an image, e.g.
+// {registry: docker.io, repository: bitnami/nginx, tag: 1.21.6}, or "" without a repository
+func imageFromValues(values map[string]interface{}, appVersion string) string {
+	stringValue := func(key string) string {
+		switch v := values[key].(type) {
+		case string:
+			return strings.TrimSpace(v)
+		case float64:
+			// unquoted tags, e.g. tag: 1.21
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}
+	image := stringValue("repository")
+	if image == "" {
+		return ""
+	}
+	if registry := stringValue("registry"); registry != "" {
+		image = registry + "/" + image
+	}
+	if digest := stringValue("digest"); digest != "" {
+		return image + "@" + digest
+	}
+	tag := stringValue("tag")
+	if tag == "" {
+		tag = appVersion
+	}
+	if tag != "" {
+		image += ":" + tag
+	}
+	return image
+}
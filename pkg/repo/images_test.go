@@ -0,0 +1,92 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+type ImagesTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ImagesTestSuite) TestChartImagesFromContent() {
+	content, err := ioutil.ReadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error reading test chart")
+	images, err := ChartImagesFromContent(content)
+	suite.Nil(err, "no error getting images of test chart")
+	suite.Equal([]string{"busybox"}, images, "literal image of the templates")
+
+	c, err := loader.LoadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error loading test chart")
+	c.Metadata.AppVersion = "1.21.6"
+	c.Raw = append(c.Raw, &chart.File{Name: "values.yaml", Data: []byte(`
+image:
+  registry: docker.io
+  repository: bitnami/nginx
+  tag: ""
+metrics:
+  image:
+    repository: bitnami/nginx-exporter
+    tag: 1.22
+sidecars:
+- name: proxy
+  image: envoyproxy/envoy:v1.22.0
+- name: pinned
+  image:
+    repository: alpine
+    digest: sha256:4edbd2beb5f78b1014028f4fbb99f3237d9561100b6881aabbf5acce2c4f9454
+templated:
+  image: "{{ .Values.global.image }}"
+`)})
+	c.Templates = append(c.Templates, &chart.File{Name: "templates/job.yaml", Data: []byte(`
+spec:
+  containers:
+  - name: migrate
+    image: "migrate/migrate:v4.15.2"
+  - name: app
+    image: {{ .Values.image.repository }}
+`)})
+	filename, err := chartutil.Save(c, suite.T().TempDir())
+	suite.Nil(err, "no error packaging chart")
+	content, err = ioutil.ReadFile(filename)
+	suite.Nil(err, "no error reading chart package")
+
+	images, err = ChartImagesFromContent(content)
+	suite.Nil(err, "no error getting images of chart")
+	suite.Equal([]string{
+		"alpine@sha256:4edbd2beb5f78b1014028f4fbb99f3237d9561100b6881aabbf5acce2c4f9454",
+		"bitnami/nginx-exporter:1.22",
+		"busybox",
+		"docker.io/bitnami/nginx:1.21.6",
+		"envoyproxy/envoy:v1.22.0",
+		"migrate/migrate:v4.15.2",
+	}, images, "images of values and templates, without template expressions")
+
+	_, err = ChartImagesFromContent([]byte("not a chart"))
+	suite.Equal(ErrorInvalidChartPackage, err, "error getting images of invalid chart")
+}
+
+func TestImagesTestSuite(t *testing.T) {
+	suite.Run(t, new(ImagesTestSuite))
+}
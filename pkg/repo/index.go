@@ -17,8 +17,12 @@ limitations under the License.
 package repo
 
 import (
+	"strings"
+	"sync"
 	"time"
 
+	"helm.sh/chartmuseum/pkg/tenant"
+
 	"github.com/ghodss/yaml"
 
 	helm_repo "helm.sh/helm/v3/pkg/repo"
@@ -28,12 +32,16 @@ var (
 	// IndexFileContentType is the http content-type header for index.yaml
 	IndexFileContentType = "application/x-yaml"
 	StatefileFilename    = "index-cache.yaml"
+
+	// maxChartURLVariants is the number of indexes with rewritten chart URLs kept per index
+	maxChartURLVariants = 16
 )
 
 type (
 	// ServerInfo contains extra data about the server
 	ServerInfo struct {
-		ContextPath string `json:"contextPath,omitempty"`
+		ContextPath string   `json:"contextPath,omitempty"`
+		Shards      []string `json:"shards,omitempty"`
 	}
 
 	// IndexFile is a copy of Helm struct with extra data
@@ -49,6 +57,10 @@ type (
 		RepoName   string `json:"b"`
 		Raw        []byte `json:"c"`
 		ChartURL   string `json:"d"`
+
+		chartURLVariantsMutex sync.Mutex
+		chartURLVariants      map[string]*Index
+		iconURLVariants       map[string]*Index
 	}
 )
 
@@ -58,7 +70,12 @@ func NewIndex(chartURL string, repo string, serverInfo *ServerInfo) *Index {
 		IndexFile:  &helm_repo.IndexFile{},
 		ServerInfo: serverInfo,
 	}
-	index := Index{indexFile, repo, []byte{}, chartURL}
+	index := Index{
+		IndexFile: indexFile,
+		RepoName:  repo,
+		Raw:       []byte{},
+		ChartURL:  chartURL,
+	}
 	index.Entries = map[string]helm_repo.ChartVersions{}
 	index.APIVersion = helm_repo.APIVersionV1
 	index.Regenerate()
@@ -78,6 +95,66 @@ func (index *Index) Regenerate() error {
 	return nil
 }
 
+// Copy returns a copy of the index whose entries can be modified
+// without affecting readers of the original
+func (index *Index) Copy() *Index {
+	indexFile := *index.IndexFile.IndexFile
+	indexFile.Entries = make(map[string]helm_repo.ChartVersions, len(index.Entries))
+	for name, chartVersions := range index.Entries {
+		indexFile.Entries[name] = append(helm_repo.ChartVersions{}, chartVersions...)
+	}
+	return &Index{
+		IndexFile: &IndexFile{
+			IndexFile:  &indexFile,
+			ServerInfo: index.ServerInfo,
+		},
+		RepoName: index.RepoName,
+		Raw:      index.Raw,
+		ChartURL: index.ChartURL,
+	}
+}
+
+// WithChartURL returns a copy of an index generated with relative chart URLs,
+// with chartURL prefixed to them in place of the "charts" directory
+func (index *Index) WithChartURL(chartURL string) (*Index, error) {
+	index.chartURLVariantsMutex.Lock()
+	defer index.chartURLVariantsMutex.Unlock()
+	if variant, ok := index.chartURLVariants[chartURL]; ok {
+		return variant, nil
+	}
+
+	variant := index.Copy()
+	variant.ChartURL = chartURL
+	for _, chartVersions := range variant.Entries {
+		for i, chartVersion := range chartVersions {
+			cv := *chartVersion
+			cv.URLs = make([]string, len(chartVersion.URLs))
+			for j, url := range chartVersion.URLs {
+				if strings.Contains(url, "://") {
+					cv.URLs[j] = url
+				} else {
+					cv.URLs[j] = chartURL + "/" + strings.TrimPrefix(url, "charts/")
+				}
+			}
+			chartVersions[i] = &cv
+		}
+	}
+	raw, err := yaml.Marshal(variant.IndexFile)
+	if err != nil {
+		return nil, err
+	}
+	variant.Raw = raw
+
+	// bounded, as chart URLs may come from request headers
+	if index.chartURLVariants == nil {
+		index.chartURLVariants = map[string]*Index{}
+	}
+	if len(index.chartURLVariants) < maxChartURLVariants {
+		index.chartURLVariants[chartURL] = variant
+	}
+	return variant, nil
+}
+
 // RemoveEntry removes a chart version from index
 func (index *Index) RemoveEntry(chartVersion *helm_repo.ChartVersion) {
 	if entries, ok := index.Entries[chartVersion.Name]; ok {
@@ -151,6 +228,11 @@ func (index *Index) updateMetrics() {
 	for _, chartVersions := range index.Entries {
 		nChartVersions += len(chartVersions)
 	}
-	chartTotalGaugeVec.WithLabelValues(index.RepoName).Set(float64(len(index.Entries)))
-	chartVersionTotalGaugeVec.WithLabelValues(index.RepoName).Set(float64(nChartVersions))
+	label, ok := tenant.MetricsLabel(index.RepoName)
+	if !ok {
+		return // gauges of tenants over the limit would overwrite each other
+	}
+	chartTotalGaugeVec.WithLabelValues(label).Set(float64(len(index.Entries)))
+	chartVersionTotalGaugeVec.WithLabelValues(label).Set(float64(nChartVersions))
+	indexSizeGaugeVec.WithLabelValues(label).Set(float64(len(index.Raw)))
 }
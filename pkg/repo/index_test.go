@@ -105,6 +105,40 @@ func (suite *IndexTestSuite) TestChartURLs() {
 		index.Entries["a"][0].URLs[0], "absolute chart url")
 }
 
+func (suite *IndexTestSuite) TestCopy() {
+	index := NewIndex("", "", &ServerInfo{})
+	index.AddEntry(getChartVersion("a", 0, time.Now()))
+
+	indexCopy := index.Copy()
+	indexCopy.AddEntry(getChartVersion("a", 1, time.Now()))
+	indexCopy.AddEntry(getChartVersion("b", 0, time.Now()))
+	indexCopy.RemoveEntry(getChartVersion("a", 0, time.Now()))
+
+	suite.Equal(1, len(index.Entries), "original index entries unchanged")
+	suite.Equal(1, len(index.Entries["a"]), "original chart versions unchanged")
+	suite.Equal("1.0.0", index.Entries["a"][0].Version, "original chart version unchanged")
+	suite.Equal(2, len(indexCopy.Entries), "copy has new entry")
+	suite.Equal("1.0.1", indexCopy.Entries["a"][0].Version, "copy has updated chart versions")
+}
+
+func (suite *IndexTestSuite) TestWithChartURL() {
+	index := NewIndex("", "", &ServerInfo{})
+	index.AddEntry(getChartVersion("a", 0, time.Now()))
+	index.Regenerate()
+
+	variant, err := index.WithChartURL("https://mysite.com/myrepo/charts")
+	suite.Nil(err, "no error rewriting chart urls")
+	suite.Equal("https://mysite.com/myrepo/charts/a-1.0.0.tgz",
+		variant.Entries["a"][0].URLs[0], "rewritten chart url")
+	suite.True(strings.Contains(string(variant.Raw), "https://mysite.com/myrepo/charts/a-1.0.0.tgz"),
+		"rewritten chart url in raw index")
+	suite.Equal("charts/a-1.0.0.tgz", index.Entries["a"][0].URLs[0], "original chart url unchanged")
+
+	cached, err := index.WithChartURL("https://mysite.com/myrepo/charts")
+	suite.Nil(err, "no error rewriting chart urls again")
+	suite.True(variant == cached, "rewritten index reused")
+}
+
 func (suite *IndexTestSuite) TestServerInfo() {
 	serverInfo := &ServerInfo{}
 	index := NewIndex("", "", serverInfo)
@@ -0,0 +1,129 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	pathutil "path"
+	"sync"
+
+	"github.com/chartmuseum/storage"
+	"github.com/ghodss/yaml"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+var (
+	// MetadataCacheFilename is the name of the file used to persist a MetadataCache in storage
+	MetadataCacheFilename = "metadata-cache.yaml"
+)
+
+type (
+	// MetadataCache holds chart versions parsed from chart packages, keyed by
+	// package filename, so that packages whose content did not change are not parsed again
+	MetadataCache struct {
+		mutex   sync.RWMutex
+		Entries map[string]*MetadataCacheEntry `json:"entries"`
+	}
+
+	// MetadataCacheEntry is a chart version along with the digest of the package it was parsed from
+	MetadataCacheEntry struct {
+		Digest       string                  `json:"digest"`
+		ChartVersion *helm_repo.ChartVersion `json:"chartVersion"`
+	}
+)
+
+// NewMetadataCache creates a new, empty MetadataCache
+func NewMetadataCache() *MetadataCache {
+	return &MetadataCache{
+		Entries: map[string]*MetadataCacheEntry{},
+	}
+}
+
+// MetadataCacheFromContent loads a MetadataCache previously saved with Content
+func MetadataCacheFromContent(content []byte) (*MetadataCache, error) {
+	cache := NewMetadataCache()
+	err := yaml.Unmarshal(content, cache)
+	if err != nil {
+		return nil, err
+	}
+	if cache.Entries == nil {
+		cache.Entries = map[string]*MetadataCacheEntry{}
+	}
+	return cache, nil
+}
+
+// Content returns the serialized cache, suitable for saving in storage
+func (cache *MetadataCache) Content() ([]byte, error) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return yaml.Marshal(cache)
+}
+
+// Remove drops the entry for a chart package filename
+func (cache *MetadataCache) Remove(filename string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.Entries, pathutil.Base(filename))
+}
+
+// Len returns the number of chart packages in cache
+func (cache *MetadataCache) Len() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return len(cache.Entries)
+}
+
+// ChartVersionFromStorageObject behaves like the package-level function of the same name,
+// but only parses the chart package if its digest differs from the cached one
+func (cache *MetadataCache) ChartVersionFromStorageObject(object storage.Object) (*helm_repo.ChartVersion, error) {
+	if len(object.Content) == 0 {
+		return ChartVersionFromStorageObject(object)
+	}
+
+	digest, err := provenanceDigestFromContent(object.Content)
+	if err != nil {
+		return nil, err
+	}
+	filename := pathutil.Base(object.Path)
+
+	cache.mutex.RLock()
+	entry, ok := cache.Entries[filename]
+	cache.mutex.RUnlock()
+	if ok && entry.Digest == digest {
+		chartVersion := *entry.ChartVersion
+		chartVersion.URLs = []string{fmt.Sprintf("charts/%s", filename)}
+		chartVersion.Created = object.LastModified
+		return &chartVersion, nil
+	}
+
+	chartVersion, err := ChartVersionFromStorageObject(object)
+	if err != nil {
+		return nil, err
+	}
+
+	// URLs are rewritten when added to an index, so do not share them with the cached copy
+	cached := *chartVersion
+	cached.URLs = nil
+	cache.mutex.Lock()
+	cache.Entries[filename] = &MetadataCacheEntry{
+		Digest:       digest,
+		ChartVersion: &cached,
+	}
+	cache.mutex.Unlock()
+
+	return chartVersion, nil
+}
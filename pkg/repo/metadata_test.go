@@ -0,0 +1,106 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/chartmuseum/storage"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetadataCacheTestSuite struct {
+	suite.Suite
+	TarballContent   []byte
+	TarballContentV2 []byte
+}
+
+func (suite *MetadataCacheTestSuite) SetupSuite() {
+	content, err := ioutil.ReadFile("../../testdata/charts/mychart/mychart-0.1.0.tgz")
+	suite.Nil(err, "no error reading test tarball")
+	suite.TarballContent = content
+
+	content, err = ioutil.ReadFile("../../testdata/charts/mychart/mychart-0.2.0.tgz")
+	suite.Nil(err, "no error reading test tarball")
+	suite.TarballContentV2 = content
+}
+
+func (suite *MetadataCacheTestSuite) TestChartVersionFromStorageObject() {
+	cache := NewMetadataCache()
+	object := storage.Object{
+		Path:         "mychart-0.1.0.tgz",
+		Content:      suite.TarballContent,
+		LastModified: time.Now(),
+	}
+
+	chartVersion, err := cache.ChartVersionFromStorageObject(object)
+	suite.Nil(err, "no error parsing chart package")
+	suite.Equal("0.1.0", chartVersion.Version, "chart version as expected")
+	suite.Equal(1, cache.Len(), "chart package cached")
+
+	// modifying the returned chart version must not affect the cache
+	chartVersion.URLs[0] = "http://example.com/" + chartVersion.URLs[0]
+
+	object.LastModified = time.Now().Add(time.Hour)
+	cached, err := cache.ChartVersionFromStorageObject(object)
+	suite.Nil(err, "no error reading cached chart package")
+	suite.Equal("0.1.0", cached.Version, "cached chart version as expected")
+	suite.Equal("charts/mychart-0.1.0.tgz", cached.URLs[0], "cached chart url as expected")
+	suite.Equal(object.LastModified, cached.Created, "created taken from storage object")
+	suite.Equal(chartVersion.Digest, cached.Digest, "digest as expected")
+
+	// same filename with different content invalidates the entry
+	object.Content = suite.TarballContentV2
+	chartVersion, err = cache.ChartVersionFromStorageObject(object)
+	suite.Nil(err, "no error parsing changed chart package")
+	suite.Equal("0.2.0", chartVersion.Version, "changed chart package parsed again")
+	suite.Equal(1, cache.Len(), "cache entry replaced")
+
+	object.Content = []byte("this should create an error")
+	_, err = cache.ChartVersionFromStorageObject(object)
+	suite.Equal(ErrorInvalidChartPackage, err, "error parsing bad content")
+
+	cache.Remove("mychart-0.1.0.tgz")
+	suite.Equal(0, cache.Len(), "cache entry removed")
+}
+
+func (suite *MetadataCacheTestSuite) TestContent() {
+	cache := NewMetadataCache()
+	_, err := cache.ChartVersionFromStorageObject(storage.Object{
+		Path:         "mychart-0.1.0.tgz",
+		Content:      suite.TarballContent,
+		LastModified: time.Now(),
+	})
+	suite.Nil(err, "no error parsing chart package")
+
+	content, err := cache.Content()
+	suite.Nil(err, "no error serializing cache")
+
+	loaded, err := MetadataCacheFromContent(content)
+	suite.Nil(err, "no error loading cache")
+	suite.Equal(1, loaded.Len(), "loaded cache has entry")
+	suite.Equal("mychart", loaded.Entries["mychart-0.1.0.tgz"].ChartVersion.Name, "loaded chart name as expected")
+
+	_, err = MetadataCacheFromContent([]byte("{{{"))
+	suite.NotNil(err, "error loading bad content")
+}
+
+func TestMetadataCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(MetadataCacheTestSuite))
+}
@@ -17,6 +17,10 @@ limitations under the License.
 package repo
 
 import (
+	"time"
+
+	"helm.sh/chartmuseum/pkg/tenant"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -39,8 +43,33 @@ var (
 		},
 		[]string{"repo"},
 	)
+	// Size of the generated index.yaml
+	indexSizeGaugeVec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "chartmuseum",
+			Name:      "index_size_bytes",
+			Help:      "Current size of index.yaml in bytes",
+		},
+		[]string{"repo"},
+	)
+	// Time taken to bring the index up to date with storage
+	indexRegenerationHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "chartmuseum",
+			Name:      "index_regeneration_duration_seconds",
+			Help:      "Time taken to regenerate index.yaml",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"repo"},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(chartTotalGaugeVec, chartVersionTotalGaugeVec)
+	prometheus.MustRegister(chartTotalGaugeVec, chartVersionTotalGaugeVec, indexSizeGaugeVec, indexRegenerationHistogramVec)
+}
+
+// ObserveIndexRegeneration records the time taken to regenerate the index of a repo
+func ObserveIndexRegeneration(repo string, duration time.Duration) {
+	label, _ := tenant.MetricsLabel(repo)
+	indexRegenerationHistogramVec.WithLabelValues(label).Observe(duration.Seconds())
 }
@@ -0,0 +1,55 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ChartOwnersFileExtension is the file extension used for the owners of chart names
+	ChartOwnersFileExtension = "owners"
+)
+
+var (
+	// ErrorInvalidChartOwners is raised when the owners of a chart are not a JSON list of users
+	ErrorInvalidChartOwners = errors.New("invalid chart owners, must be a JSON list of users")
+)
+
+// ChartOwnersFilenameFromName returns the filename of the owners of a chart name, e.g. mychart.owners,
+// shared by all its versions
+func ChartOwnersFilenameFromName(name string) string {
+	return fmt.Sprintf("%s.%s", name, ChartOwnersFileExtension)
+}
+
+// ChartOwnersFromContent parses the owners of a chart, the users who may change it
+func ChartOwnersFromContent(content []byte) ([]string, error) {
+	owners := []string{}
+	if err := json.Unmarshal(content, &owners); err != nil {
+		return nil, ErrorInvalidChartOwners
+	}
+	seen := map[string]bool{}
+	for _, owner := range owners {
+		if owner == "" || seen[owner] {
+			return nil, ErrorInvalidChartOwners
+		}
+		seen[owner] = true
+	}
+	return owners, nil
+}
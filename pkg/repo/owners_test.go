@@ -0,0 +1,50 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OwnersTestSuite struct {
+	suite.Suite
+}
+
+func (suite *OwnersTestSuite) TestChartOwnersFilenameFromName() {
+	suite.Equal("mychart.owners", ChartOwnersFilenameFromName("mychart"))
+}
+
+func (suite *OwnersTestSuite) TestChartOwnersFromContent() {
+	owners, err := ChartOwnersFromContent([]byte(`["team-a", "alice"]`))
+	suite.Nil(err, "no error parsing owners")
+	suite.Equal([]string{"team-a", "alice"}, owners)
+
+	owners, err = ChartOwnersFromContent([]byte(`[]`))
+	suite.Nil(err, "no error parsing no owners")
+	suite.Empty(owners)
+
+	for _, content := range []string{"", "alice", `{"alice": true}`, `[""]`, `["alice", "alice"]`, `[1]`} {
+		_, err = ChartOwnersFromContent([]byte(content))
+		suite.Equal(ErrorInvalidChartOwners, err, "invalid owners %q", content)
+	}
+}
+
+func TestOwnersTestSuite(t *testing.T) {
+	suite.Run(t, new(OwnersTestSuite))
+}
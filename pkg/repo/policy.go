@@ -0,0 +1,77 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// strictSemverRegex matches versions following semver 2.0 exactly, see https://semver.org
+	strictSemverRegex = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+		`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+		`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+)
+
+type (
+	// ChartPolicy restricts the names and versions of the charts uploaded, beyond what helm accepts
+	ChartPolicy struct {
+		NamePattern    *regexp.Regexp
+		VersionPattern *regexp.Regexp
+		// StrictSemver rejects versions helm parses loosely, e.g. 1.0 or v1.0.0
+		StrictSemver bool
+	}
+)
+
+// NewChartPolicy creates a new ChartPolicy from regular expressions, either of them being optional,
+// or returns nil when nothing is restricted
+func NewChartPolicy(namePattern string, versionPattern string, strictSemver bool) (*ChartPolicy, error) {
+	if namePattern == "" && versionPattern == "" && !strictSemver {
+		return nil, nil
+	}
+	policy := &ChartPolicy{StrictSemver: strictSemver}
+	var err error
+	if namePattern != "" {
+		if policy.NamePattern, err = regexp.Compile(namePattern); err != nil {
+			return nil, fmt.Errorf("invalid chart name pattern: %s", err)
+		}
+	}
+	if versionPattern != "" {
+		if policy.VersionPattern, err = regexp.Compile(versionPattern); err != nil {
+			return nil, fmt.Errorf("invalid chart version pattern: %s", err)
+		}
+	}
+	return policy, nil
+}
+
+// Check returns an error telling why a chart name and version are not allowed, or nil
+func (policy *ChartPolicy) Check(name string, version string) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.NamePattern != nil && !policy.NamePattern.MatchString(name) {
+		return fmt.Errorf("chart name %q does not match the allowed pattern %s", name, policy.NamePattern)
+	}
+	if policy.StrictSemver && !strictSemverRegex.MatchString(version) {
+		return fmt.Errorf("chart version %q is not a strict semver 2.0 version, e.g. 1.2.3", version)
+	}
+	if policy.VersionPattern != nil && !policy.VersionPattern.MatchString(version) {
+		return fmt.Errorf("chart version %q does not match the allowed pattern %s", version, policy.VersionPattern)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PolicyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PolicyTestSuite) TestNewChartPolicy() {
+	policy, err := NewChartPolicy("", "", false)
+	suite.Nil(err, "no error without policy")
+	suite.Nil(policy, "no policy without restrictions")
+	suite.Nil(policy.Check("Any_Name", "v1"), "anything allowed without policy")
+
+	_, err = NewChartPolicy("[a-z", "", false)
+	suite.NotNil(err, "error with invalid name pattern")
+	_, err = NewChartPolicy("", "(", false)
+	suite.NotNil(err, "error with invalid version pattern")
+}
+
+func (suite *PolicyTestSuite) TestCheck() {
+	policy, err := NewChartPolicy(`^(team-a|team-b)-[a-z0-9-]+$`, `^\d+\.\d+\.\d+$`, false)
+	suite.Nil(err, "no error creating policy")
+	suite.Nil(policy.Check("team-a-mychart", "1.2.3"))
+	suite.NotNil(policy.Check("mychart", "1.2.3"), "name without team prefix")
+	suite.NotNil(policy.Check("team-a-MyChart", "1.2.3"), "name with uppercase letters")
+	suite.NotNil(policy.Check("team-a-mychart", "1.2.3-rc.1"), "prerelease not allowed by version pattern")
+
+	policy, err = NewChartPolicy("", "", true)
+	suite.Nil(err, "no error creating policy")
+	for _, version := range []string{"0.1.0", "1.2.3-rc.1", "1.2.3+build.5", "10.20.30-alpha-1.beta+exp.sha.5114f85"} {
+		suite.Nil(policy.Check("mychart", version), "strict semver %s", version)
+	}
+	for _, version := range []string{"1.0", "v1.0.0", "01.2.3", "1.2.3-01", "1.2.3.4", ""} {
+		suite.NotNil(policy.Check("mychart", version), "not strict semver %s", version)
+	}
+}
+
+func TestPolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(PolicyTestSuite))
+}
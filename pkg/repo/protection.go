@@ -0,0 +1,60 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"regexp"
+)
+
+type (
+	// ChartProtection matches the names of the charts whose versions may not be deleted or
+	// overwritten, unless forced by an admin
+	ChartProtection struct {
+		Patterns []*regexp.Regexp
+	}
+)
+
+// NewChartProtection creates a new ChartProtection from chart names or regular expressions matching
+// whole names, e.g. ingress-nginx or platform-.*, or returns nil when no chart is protected
+func NewChartProtection(charts []string) (*ChartProtection, error) {
+	if len(charts) == 0 {
+		return nil, nil
+	}
+	protection := &ChartProtection{}
+	for _, chart := range charts {
+		pattern, err := regexp.Compile("^(?:" + chart + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected chart %q: %s", chart, err)
+		}
+		protection.Patterns = append(protection.Patterns, pattern)
+	}
+	return protection, nil
+}
+
+// Protects tells whether the versions of a chart are protected
+func (protection *ChartProtection) Protects(name string) bool {
+	if protection == nil || name == "" {
+		return false
+	}
+	for _, pattern := range protection.Patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProtectionTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ProtectionTestSuite) TestNewChartProtection() {
+	protection, err := NewChartProtection(nil)
+	suite.Nil(err, "no error without protected charts")
+	suite.Nil(protection, "no protection without protected charts")
+	suite.False(protection.Protects("mychart"), "nothing protected without protection")
+
+	_, err = NewChartProtection([]string{"[a-z"})
+	suite.NotNil(err, "error with invalid pattern")
+}
+
+func (suite *ProtectionTestSuite) TestProtects() {
+	protection, err := NewChartProtection([]string{"ingress-nginx", "platform-.*"})
+	suite.Nil(err, "no error creating protection")
+	suite.True(protection.Protects("ingress-nginx"), "chart name protected")
+	suite.True(protection.Protects("platform-dns"), "chart matching pattern protected")
+	suite.False(protection.Protects("ingress-nginx-test"), "names matched whole")
+	suite.False(protection.Protects("my-platform-dns"), "patterns matched whole")
+	suite.False(protection.Protects(""), "empty name not protected")
+}
+
+func TestProtectionTestSuite(t *testing.T) {
+	suite.Run(t, new(ProtectionTestSuite))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// SBOMFileExtension is the file extension used for SBOMs stored next to chart packages
+	SBOMFileExtension = "tgz.sbom"
+
+	// ErrorInvalidSBOM is raised when an SBOM is neither an SPDX nor a CycloneDX document
+	ErrorInvalidSBOM = errors.New("invalid sbom, must be an SPDX or CycloneDX document")
+)
+
+const (
+	// SBOMContentTypeSPDXJSON is the http content-type header for SPDX documents in JSON
+	SBOMContentTypeSPDXJSON = "application/spdx+json"
+	// SBOMContentTypeSPDXTagValue is the http content-type header for SPDX documents in tag-value
+	SBOMContentTypeSPDXTagValue = "text/spdx"
+	// SBOMContentTypeCycloneDXJSON is the http content-type header for CycloneDX documents in JSON
+	SBOMContentTypeCycloneDXJSON = "application/vnd.cyclonedx+json"
+	// SBOMContentTypeCycloneDXXML is the http content-type header for CycloneDX documents in XML
+	SBOMContentTypeCycloneDXXML = "application/vnd.cyclonedx+xml"
+)
+
+// SBOMFilenameFromNameVersion returns an SBOM filename from a name and version
+func SBOMFilenameFromNameVersion(name string, version string) string {
+	filename := fmt.Sprintf("%s-%s.%s", name, version, SBOMFileExtension)
+	return filename
+}
+
+// SBOMContentTypeFromContent returns the content type of an SBOM from its format, SPDX in JSON
+// or tag-value, or CycloneDX in JSON or XML
+func SBOMContentTypeFromContent(content []byte) (string, error) {
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var document struct {
+			SPDXVersion string `json:"spdxVersion"`
+			BOMFormat   string `json:"bomFormat"`
+		}
+		if err := json.Unmarshal(trimmed, &document); err != nil {
+			return "", ErrorInvalidSBOM
+		}
+		if strings.HasPrefix(document.SPDXVersion, "SPDX-") {
+			return SBOMContentTypeSPDXJSON, nil
+		}
+		if document.BOMFormat == "CycloneDX" {
+			return SBOMContentTypeCycloneDXJSON, nil
+		}
+	case bytes.HasPrefix(trimmed, []byte("SPDXVersion:")):
+		return SBOMContentTypeSPDXTagValue, nil
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		// the root element must be a CycloneDX bom, whatever the version of the schema
+		decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return "", ErrorInvalidSBOM
+			}
+			if element, ok := token.(xml.StartElement); ok {
+				if element.Name.Local == "bom" && strings.HasPrefix(element.Name.Space, "http://cyclonedx.org/schema/bom/") {
+					return SBOMContentTypeCycloneDXXML, nil
+				}
+				return "", ErrorInvalidSBOM
+			}
+		}
+	}
+	return "", ErrorInvalidSBOM
+}
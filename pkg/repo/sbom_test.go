@@ -0,0 +1,62 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SBOMTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SBOMTestSuite) TestSBOMFilenameFromNameVersion() {
+	suite.Equal("mychart-0.1.0.tgz.sbom", SBOMFilenameFromNameVersion("mychart", "0.1.0"))
+}
+
+func (suite *SBOMTestSuite) TestSBOMContentTypeFromContent() {
+	for content, contentType := range map[string]string{
+		`{"spdxVersion": "SPDX-2.3", "SPDXID": "SPDXRef-DOCUMENT", "name": "mychart"}`:                   SBOMContentTypeSPDXJSON,
+		"SPDXVersion: SPDX-2.3\nDataLicense: CC0-1.0\nSPDXID: SPDXRef-DOCUMENT\n":                        SBOMContentTypeSPDXTagValue,
+		`{"bomFormat": "CycloneDX", "specVersion": "1.5", "components": []}`:                             SBOMContentTypeCycloneDXJSON,
+		`<?xml version="1.0"?><bom xmlns="http://cyclonedx.org/schema/bom/1.5" version="1"></bom>`:       SBOMContentTypeCycloneDXXML,
+		"\n  <!-- generated --><bom xmlns=\"http://cyclonedx.org/schema/bom/1.4\"><components/></bom>\n": SBOMContentTypeCycloneDXXML,
+	} {
+		actual, err := SBOMContentTypeFromContent([]byte(content))
+		suite.Nil(err, "no error getting content type of %s", content)
+		suite.Equal(contentType, actual, "content type of %s", content)
+	}
+
+	for _, content := range []string{
+		"",
+		"not an sbom",
+		`{"name": "mychart"}`,
+		`{"spdxVersion": "SPDX-2.3"`,
+		`{"bomFormat": "Other"}`,
+		`<project xmlns="http://maven.apache.org/POM/4.0.0"></project>`,
+		`<bom></bom>`,
+	} {
+		_, err := SBOMContentTypeFromContent([]byte(content))
+		suite.Equal(ErrorInvalidSBOM, err, "ErrorInvalidSBOM from %q", content)
+	}
+}
+
+func TestSBOMTestSuite(t *testing.T) {
+	suite.Run(t, new(SBOMTestSuite))
+}
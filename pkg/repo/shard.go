@@ -0,0 +1,141 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+var (
+	// IndexShardingAlpha shards index entries by the first character of the chart name
+	IndexShardingAlpha = "alpha"
+	// IndexShardingHash shards index entries by a hash of the chart name
+	IndexShardingHash = "hash"
+
+	indexShardFilenameRegex = regexp.MustCompile(`^index-([a-z0-9_]+)\.yaml$`)
+)
+
+type (
+	// IndexSharding describes how index entries are split across shard index files
+	IndexSharding struct {
+		Mode   string
+		Shards int
+	}
+)
+
+// NewIndexSharding validates a sharding mode, returning nil if sharding is disabled
+func NewIndexSharding(mode string, shards int) (*IndexSharding, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case IndexShardingAlpha:
+		return &IndexSharding{Mode: mode}, nil
+	case IndexShardingHash:
+		if shards <= 0 {
+			return nil, fmt.Errorf("invalid number of index shards: %d", shards)
+		}
+		return &IndexSharding{Mode: mode, Shards: shards}, nil
+	}
+	return nil, fmt.Errorf("invalid index sharding mode: %s", mode)
+}
+
+// IndexShardFilename returns the filename of the index for a shard (e.g. index-a.yaml)
+func IndexShardFilename(shard string) string {
+	return fmt.Sprintf("index-%s.yaml", shard)
+}
+
+// ShardFromIndexShardFilename extracts the shard from the filename of a shard index
+func ShardFromIndexShardFilename(filename string) (string, bool) {
+	match := indexShardFilenameRegex.FindStringSubmatch(filename)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// ShardOf returns the shard holding the entries of a chart
+func (sharding *IndexSharding) ShardOf(chartName string) string {
+	if sharding.Mode == IndexShardingHash {
+		h := fnv.New32a()
+		h.Write([]byte(chartName))
+		return strconv.Itoa(int(h.Sum32() % uint32(sharding.Shards)))
+	}
+	if chartName != "" {
+		c := strings.ToLower(chartName[:1])[0]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			return string(c)
+		}
+	}
+	return "_"
+}
+
+// Valid checks that a shard can exist with this sharding
+func (sharding *IndexSharding) Valid(shard string) bool {
+	if sharding.Mode == IndexShardingHash {
+		n, err := strconv.Atoi(shard)
+		return err == nil && n >= 0 && n < sharding.Shards && strconv.Itoa(n) == shard
+	}
+	return len(shard) == 1
+}
+
+// Shard returns the raw content of an index holding only the entries of one shard
+func (index *Index) Shard(sharding *IndexSharding, shard string) ([]byte, error) {
+	entries := map[string]helm_repo.ChartVersions{}
+	for name, chartVersions := range index.Entries {
+		if sharding.ShardOf(name) == shard {
+			entries[name] = chartVersions
+		}
+	}
+	return index.marshalWithEntries(entries, index.ServerInfo)
+}
+
+// RootIndex returns the raw content of an index with no entries, linking to the shards that have entries
+func (index *Index) RootIndex(sharding *IndexSharding) ([]byte, error) {
+	seen := map[string]bool{}
+	shards := []string{}
+	for name := range index.Entries {
+		shard := sharding.ShardOf(name)
+		if !seen[shard] {
+			seen[shard] = true
+			shards = append(shards, IndexShardFilename(shard))
+		}
+	}
+	sort.Strings(shards)
+
+	serverInfo := &ServerInfo{Shards: shards}
+	if index.ServerInfo != nil {
+		serverInfo.ContextPath = index.ServerInfo.ContextPath
+	}
+	return index.marshalWithEntries(map[string]helm_repo.ChartVersions{}, serverInfo)
+}
+
+func (index *Index) marshalWithEntries(entries map[string]helm_repo.ChartVersions, serverInfo *ServerInfo) ([]byte, error) {
+	indexFile := *index.IndexFile.IndexFile
+	indexFile.Entries = entries
+	return yaml.Marshal(&IndexFile{
+		IndexFile:  &indexFile,
+		ServerInfo: serverInfo,
+	})
+}
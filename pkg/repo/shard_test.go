@@ -0,0 +1,124 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/suite"
+)
+
+type ShardTestSuite struct {
+	suite.Suite
+	Index *Index
+}
+
+func (suite *ShardTestSuite) SetupSuite() {
+	suite.Index = NewIndex("", "", &ServerInfo{ContextPath: "/v1/helm"})
+	now := time.Now()
+	for _, name := range []string{"apple", "avocado", "banana", "9lives", "_private"} {
+		suite.Index.AddEntry(getChartVersion(name, 0, now))
+	}
+	suite.Index.Regenerate()
+}
+
+func (suite *ShardTestSuite) TestNewIndexSharding() {
+	sharding, err := NewIndexSharding("", 0)
+	suite.Nil(err, "no error with sharding disabled")
+	suite.Nil(sharding, "no sharding when disabled")
+
+	sharding, err = NewIndexSharding(IndexShardingAlpha, 0)
+	suite.Nil(err, "no error with alpha sharding")
+	suite.Equal(IndexShardingAlpha, sharding.Mode)
+
+	_, err = NewIndexSharding(IndexShardingHash, 0)
+	suite.NotNil(err, "error with hash sharding and no shards")
+
+	_, err = NewIndexSharding("bogus", 16)
+	suite.NotNil(err, "error with unknown sharding mode")
+}
+
+func (suite *ShardTestSuite) TestShardFilename() {
+	suite.Equal("index-a.yaml", IndexShardFilename("a"))
+
+	shard, ok := ShardFromIndexShardFilename("index-a.yaml")
+	suite.True(ok, "shard index filename")
+	suite.Equal("a", shard)
+
+	for _, filename := range []string{"index.yaml", "index-.yaml", "index-A.yaml", "mychart-0.1.0.tgz"} {
+		_, ok = ShardFromIndexShardFilename(filename)
+		suite.False(ok, filename+" is not a shard index filename")
+	}
+}
+
+func (suite *ShardTestSuite) TestShardOf() {
+	alpha := &IndexSharding{Mode: IndexShardingAlpha}
+	suite.Equal("a", alpha.ShardOf("apple"))
+	suite.Equal("a", alpha.ShardOf("Avocado"))
+	suite.Equal("9", alpha.ShardOf("9lives"))
+	suite.Equal("_", alpha.ShardOf("_private"))
+	suite.True(alpha.Valid("a"))
+	suite.False(alpha.Valid("ab"))
+
+	hash := &IndexSharding{Mode: IndexShardingHash, Shards: 4}
+	shard := hash.ShardOf("apple")
+	suite.Equal(shard, hash.ShardOf("apple"), "hash shard is stable")
+	suite.True(hash.Valid(shard))
+	suite.False(hash.Valid("4"))
+	suite.False(hash.Valid("01"))
+}
+
+func (suite *ShardTestSuite) TestShard() {
+	sharding := &IndexSharding{Mode: IndexShardingAlpha}
+
+	raw, err := suite.Index.Shard(sharding, "a")
+	suite.Nil(err, "no error getting shard")
+	indexFile := &IndexFile{}
+	suite.Nil(yaml.Unmarshal(raw, indexFile))
+	suite.Equal(2, len(indexFile.Entries), "shard has its entries only")
+	suite.Contains(indexFile.Entries, "apple")
+	suite.Contains(indexFile.Entries, "avocado")
+	suite.Equal("/v1/helm", indexFile.ServerInfo.ContextPath)
+
+	raw, err = suite.Index.Shard(sharding, "z")
+	suite.Nil(err, "no error getting empty shard")
+	indexFile = &IndexFile{}
+	suite.Nil(yaml.Unmarshal(raw, indexFile))
+	suite.Equal(0, len(indexFile.Entries), "empty shard")
+
+	suite.Equal(5, len(suite.Index.Entries), "index entries unchanged")
+}
+
+func (suite *ShardTestSuite) TestRootIndex() {
+	sharding := &IndexSharding{Mode: IndexShardingAlpha}
+
+	raw, err := suite.Index.RootIndex(sharding)
+	suite.Nil(err, "no error getting root index")
+	indexFile := &IndexFile{}
+	suite.Nil(yaml.Unmarshal(raw, indexFile))
+	suite.Equal(0, len(indexFile.Entries), "root index has no entries")
+	suite.Equal([]string{"index-9.yaml", "index-_.yaml", "index-a.yaml", "index-b.yaml"},
+		indexFile.ServerInfo.Shards, "root index links to shards")
+	suite.Equal("/v1/helm", indexFile.ServerInfo.ContextPath)
+	suite.Nil(suite.Index.ServerInfo.Shards, "index server info unchanged")
+}
+
+func TestShardTestSuite(t *testing.T) {
+	suite.Run(t, new(ShardTestSuite))
+}
@@ -0,0 +1,109 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+const (
+	// IndexSignatureFileExtension is the extension added to the filenames of index files for their signatures
+	IndexSignatureFileExtension = "asc"
+	// IndexSignatureContentType is the content type of the signatures of index files
+	IndexSignatureContentType = "application/pgp-signature"
+)
+
+type (
+	// IndexSigner signs index files with a PGP key, so that clients can check they were not
+	// changed in transit or in storage
+	IndexSigner struct {
+		Entity *openpgp.Entity
+	}
+)
+
+// NewIndexSigner loads the signing key from a keyring, armored or not, as exported with
+// gpg --export-secret-keys. The key is the first one with a private key, or the first one with
+// an identity containing keyName if set. Returns nil without a keyring
+func NewIndexSigner(keyringPath string, keyName string, passphrase string) (*IndexSigner, error) {
+	if keyringPath == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	if err != nil {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid index signing keyring %s: %s", keyringPath, err)
+	}
+
+	var entity *openpgp.Entity
+	for _, e := range entities {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if keyName == "" {
+			entity = e
+			break
+		}
+		for name := range e.Identities {
+			if strings.Contains(name, keyName) {
+				entity = e
+				break
+			}
+		}
+		if entity != nil {
+			break
+		}
+	}
+	if entity == nil {
+		if keyName != "" {
+			return nil, fmt.Errorf("no private key named %q in index signing keyring %s", keyName, keyringPath)
+		}
+		return nil, fmt.Errorf("no private key in index signing keyring %s", keyringPath)
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if passphrase == "" {
+			return nil, errors.New("index signing key is encrypted, a passphrase is needed")
+		}
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("could not decrypt index signing key: %s", err)
+		}
+	}
+	return &IndexSigner{Entity: entity}, nil
+}
+
+// Sign returns the armored detached signature of an index file, e.g. served as index.yaml.asc
+func (signer *IndexSigner) Sign(content []byte) ([]byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer.Entity, bytes.NewReader(content), &packet.Config{}); err != nil {
+		return nil, err
+	}
+	signature.WriteString("\n")
+	return signature.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	testKeyringPath         = "../../testdata/pgp/helm-test-key.secret"
+	testPublicKeyPath       = "../../testdata/pgp/helm-test-key.pub"
+	testPasswordKeyringPath = "../../testdata/pgp/helm-password-key.secret"
+)
+
+type SigningTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SigningTestSuite) TestNewIndexSigner() {
+	signer, err := NewIndexSigner("", "", "")
+	suite.Nil(err, "no error without keyring")
+	suite.Nil(signer, "no signer without keyring")
+
+	signer, err = NewIndexSigner(testKeyringPath, "", "")
+	suite.Nil(err, "no error loading keyring")
+	suite.NotNil(signer.Entity.PrivateKey, "private key loaded")
+	signer, err = NewIndexSigner(testKeyringPath, "helm-testing@helm.sh", "")
+	suite.Nil(err, "no error loading key by name")
+	suite.NotNil(signer, "key found by name")
+
+	_, err = NewIndexSigner(testKeyringPath, "nobody@helm.sh", "")
+	suite.NotNil(err, "error with key name missing from keyring")
+	_, err = NewIndexSigner(testPublicKeyPath, "", "")
+	suite.NotNil(err, "error with keyring without private key")
+	_, err = NewIndexSigner("../../testdata/pgp/missing.secret", "", "")
+	suite.NotNil(err, "error with missing keyring")
+	_, err = NewIndexSigner("../../testdata/pgp/NOTE.txt", "", "")
+	suite.NotNil(err, "error with invalid keyring")
+
+	_, err = NewIndexSigner(testPasswordKeyringPath, "", "")
+	suite.NotNil(err, "error with encrypted key without passphrase")
+	_, err = NewIndexSigner(testPasswordKeyringPath, "", "secrets_and_lies")
+	suite.NotNil(err, "error with wrong passphrase")
+	signer, err = NewIndexSigner(testPasswordKeyringPath, "", "secret")
+	suite.Nil(err, "no error decrypting key")
+	suite.False(signer.Entity.PrivateKey.Encrypted, "key decrypted")
+}
+
+func (suite *SigningTestSuite) TestSign() {
+	signer, err := NewIndexSigner(testKeyringPath, "", "")
+	suite.Nil(err, "no error loading keyring")
+
+	publicKey, err := os.Open(testPublicKeyPath)
+	suite.Nil(err, "no error opening public key")
+	defer publicKey.Close()
+	keyring, err := openpgp.ReadKeyRing(publicKey)
+	suite.Nil(err, "no error reading public key")
+
+	content := []byte("apiVersion: v1\nentries: {}\n")
+	signature, err := signer.Sign(content)
+	suite.Nil(err, "no error signing index")
+	suite.True(bytes.HasPrefix(signature, []byte("-----BEGIN PGP SIGNATURE-----")), "armored signature")
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature))
+	suite.Nil(err, "signature checked with the public key")
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader([]byte("apiVersion: v1\nentries: {tampered: []}\n")), bytes.NewReader(signature))
+	suite.NotNil(err, "signature of another index")
+}
+
+func TestSigningTestSuite(t *testing.T) {
+	suite.Run(t, new(SigningTestSuite))
+}
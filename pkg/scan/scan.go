@@ -0,0 +1,296 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+)
+
+const (
+	// PayloadImages submits the images a chart refers to as JSON
+	PayloadImages = "images"
+	// PayloadTarball submits the chart package itself
+	PayloadTarball = "tarball"
+
+	// StatusPassed is the status of charts without findings at or above the severity of the scanner
+	StatusPassed = "passed"
+	// StatusFailed is the status of charts with findings at or above the severity of the scanner
+	StatusFailed = "failed"
+	// StatusPending is the status of charts being scanned
+	StatusPending = "pending"
+	// StatusError is the status of charts the scanner could not scan
+	StatusError = "error"
+
+	// AttachmentKind is the kind of the attachment holding the result of the scan of a chart version
+	AttachmentKind = "scan"
+
+	// maxResponseSize bounds the reports read from scanners
+	maxResponseSize = 64 << 20
+)
+
+// severities from the least to the most severe, as Trivy and Clair rank them
+var severities = []string{"UNKNOWN", "NEGLIGIBLE", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+type (
+	// Finding is a vulnerability found in a chart or one of its images
+	Finding struct {
+		ID       string `json:"id"`
+		Severity string `json:"severity"`
+		Target   string `json:"target,omitempty"`
+		Package  string `json:"package,omitempty"`
+		Title    string `json:"title,omitempty"`
+	}
+
+	// Result is the outcome of the scan of a chart version, stored as its scan attachment
+	Result struct {
+		Status    string         `json:"status"`
+		ScannedAt time.Time      `json:"scannedAt"`
+		Severity  string         `json:"severity"`
+		Images    []string       `json:"images,omitempty"`
+		Summary   map[string]int `json:"summary"`
+		Findings  []Finding      `json:"findings"`
+		Error     string         `json:"error,omitempty"`
+	}
+
+	// ImagesRequest is the JSON body of the requests submitting the images of a chart
+	ImagesRequest struct {
+		Repo    string   `json:"repo"`
+		Name    string   `json:"name"`
+		Version string   `json:"version"`
+		Images  []string `json:"images"`
+	}
+
+	// Scanner submits chart versions to a scanner, which replies with a list of findings, a Trivy
+	// JSON report (trivy image --format json) or a Clair vulnerability report
+	Scanner struct {
+		URL      string
+		Payload  string
+		Severity string
+		Block    bool
+		Client   *http.Client
+	}
+
+	// ScannerOptions are options for constructing a Scanner
+	ScannerOptions struct {
+		URL     string
+		Payload string
+		// Severity is the lowest severity failing a scan, HIGH by default
+		Severity string
+		// Block rejects uploads failing their scan, instead of only annotating them
+		Block   bool
+		Timeout time.Duration
+	}
+
+	// the reports understood, merged: a list of findings, Trivy or Clair
+	report struct {
+		Findings []Finding `json:"findings"`
+		Results  []struct {
+			Target          string `json:"Target"`
+			Vulnerabilities []struct {
+				VulnerabilityID string `json:"VulnerabilityID"`
+				PkgName         string `json:"PkgName"`
+				Severity        string `json:"Severity"`
+				Title           string `json:"Title"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+		Vulnerabilities map[string]struct {
+			Name               string `json:"name"`
+			NormalizedSeverity string `json:"normalized_severity"`
+			Description        string `json:"description"`
+			Package            struct {
+				Name string `json:"name"`
+			} `json:"package"`
+		} `json:"vulnerabilities"`
+	}
+)
+
+// NewScanner creates a new Scanner, or returns nil without a url
+func NewScanner(options ScannerOptions) (*Scanner, error) {
+	if options.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(options.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid scan url %q, must be an http or https url", options.URL)
+	}
+	payload := options.Payload
+	if payload == "" {
+		payload = PayloadImages
+	}
+	if payload != PayloadImages && payload != PayloadTarball {
+		return nil, fmt.Errorf("invalid scan payload %q, must be %s or %s", options.Payload, PayloadImages, PayloadTarball)
+	}
+	severity := strings.ToUpper(options.Severity)
+	if severity == "" {
+		severity = "HIGH"
+	}
+	if severityRank(severity) < 0 {
+		return nil, fmt.Errorf("invalid scan severity %q, must be one of %s", options.Severity, strings.Join(severities, ", "))
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	return &Scanner{
+		URL:      options.URL,
+		Payload:  payload,
+		Severity: severity,
+		Block:    options.Block,
+		Client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Scan submits a chart package to the scanner and returns the result of the scan
+func (scanner *Scanner) Scan(ctx context.Context, repo string, name string, version string, content []byte) (*Result, error) {
+	images, err := cm_repo.ChartImagesFromContent(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if scanner.Payload == PayloadTarball {
+		query := url.Values{"repo": {repo}, "name": {name}, "version": {version}}
+		req, err = http.NewRequestWithContext(ctx, "POST", scanner.URL+"?"+query.Encode(), bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/gzip")
+	} else {
+		body, err := json.Marshal(ImagesRequest{Repo: repo, Name: name, Version: version, Images: images})
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", scanner.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := scanner.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d from scanner", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, res.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var r report
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("invalid report from scanner: %s", err)
+	}
+	return scanner.result(images, r.findings()), nil
+}
+
+// ErrorResult is the result of a scan which could not complete
+func (scanner *Scanner) ErrorResult(err error) *Result {
+	return &Result{
+		Status:    StatusError,
+		ScannedAt: time.Now().UTC(),
+		Severity:  scanner.Severity,
+		Summary:   map[string]int{},
+		Findings:  []Finding{},
+		Error:     err.Error(),
+	}
+}
+
+// result summarizes findings by severity, failing with any at or above the severity of the scanner
+func (scanner *Scanner) result(images []string, findings []Finding) *Result {
+	result := &Result{
+		Status:    StatusPassed,
+		ScannedAt: time.Now().UTC(),
+		Severity:  scanner.Severity,
+		Images:    images,
+		Summary:   map[string]int{},
+		Findings:  findings,
+	}
+	threshold := severityRank(scanner.Severity)
+	for _, finding := range findings {
+		result.Summary[finding.Severity]++
+		if severityRank(finding.Severity) >= threshold {
+			result.Status = StatusFailed
+		}
+	}
+	return result
+}
+
+func (r *report) findings() []Finding {
+	findings := []Finding{}
+	for _, finding := range r.Findings {
+		finding.Severity = normalizeSeverity(finding.Severity)
+		findings = append(findings, finding)
+	}
+	for _, result := range r.Results {
+		for _, v := range result.Vulnerabilities {
+			findings = append(findings, Finding{
+				ID:       v.VulnerabilityID,
+				Severity: normalizeSeverity(v.Severity),
+				Target:   result.Target,
+				Package:  v.PkgName,
+				Title:    v.Title,
+			})
+		}
+	}
+	for _, v := range r.Vulnerabilities {
+		findings = append(findings, Finding{
+			ID:       v.Name,
+			Severity: normalizeSeverity(v.NormalizedSeverity),
+			Package:  v.Package.Name,
+			Title:    v.Description,
+		})
+	}
+	// the most severe first
+	sort.SliceStable(findings, func(i, j int) bool {
+		if rank, other := severityRank(findings[i].Severity), severityRank(findings[j].Severity); rank != other {
+			return rank > other
+		}
+		return findings[i].ID < findings[j].ID
+	})
+	return findings
+}
+
+func normalizeSeverity(severity string) string {
+	severity = strings.ToUpper(severity)
+	if severityRank(severity) < 0 {
+		return "UNKNOWN"
+	}
+	return severity
+}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,157 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+var testTarballPath = "../../testdata/charts/mychart/mychart-0.1.0.tgz"
+
+type ScanTestSuite struct {
+	suite.Suite
+	Content []byte
+}
+
+func (suite *ScanTestSuite) SetupSuite() {
+	content, err := ioutil.ReadFile(testTarballPath)
+	suite.Nil(err, "no error reading test tarball")
+	suite.Content = content
+}
+
+func (suite *ScanTestSuite) newScanner(url string, payload string, severity string) *Scanner {
+	scanner, err := NewScanner(ScannerOptions{URL: url, Payload: payload, Severity: severity})
+	suite.Nil(err, "no error creating scanner")
+	suite.NotNil(scanner, "scanner created")
+	return scanner
+}
+
+func (suite *ScanTestSuite) TestNewScanner() {
+	scanner, err := NewScanner(ScannerOptions{})
+	suite.Nil(err, "no error without url")
+	suite.Nil(scanner, "no scanner without url")
+
+	_, err = NewScanner(ScannerOptions{URL: "localhost:4954"})
+	suite.NotNil(err, "error with url missing scheme")
+	_, err = NewScanner(ScannerOptions{URL: "http://localhost:4954", Payload: "manifests"})
+	suite.NotNil(err, "error with unknown payload")
+	_, err = NewScanner(ScannerOptions{URL: "http://localhost:4954", Severity: "SEVERE"})
+	suite.NotNil(err, "error with unknown severity")
+
+	scanner = suite.newScanner("http://localhost:4954", "", "critical")
+	suite.Equal(PayloadImages, scanner.Payload, "images submitted by default")
+	suite.Equal("CRITICAL", scanner.Severity, "severity in upper case")
+	scanner = suite.newScanner("http://localhost:4954", PayloadTarball, "")
+	suite.Equal("HIGH", scanner.Severity, "HIGH severity by default")
+}
+
+func (suite *ScanTestSuite) TestScanImages() {
+	var request ImagesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("application/json", r.Header.Get("Content-Type"))
+		suite.Nil(json.NewDecoder(r.Body).Decode(&request), "no error decoding images request")
+		w.Write([]byte(`{"findings": [
+			{"id": "CVE-2022-0001", "severity": "medium", "target": "busybox"},
+			{"id": "CVE-2022-0002", "severity": "HIGH", "target": "busybox", "package": "libc"},
+			{"id": "CVE-2022-0003", "severity": "whatever"}
+		]}`))
+	}))
+	defer server.Close()
+
+	result, err := suite.newScanner(server.URL, PayloadImages, "HIGH").Scan(context.Background(), "org1", "mychart", "0.1.0", suite.Content)
+	suite.Nil(err, "no error scanning chart")
+	suite.Equal(ImagesRequest{Repo: "org1", Name: "mychart", Version: "0.1.0", Images: []string{"busybox"}}, request, "images of the chart submitted")
+	suite.Equal(StatusFailed, result.Status, "failed with a HIGH finding")
+	suite.Equal([]string{"busybox"}, result.Images)
+	suite.Equal(map[string]int{"HIGH": 1, "MEDIUM": 1, "UNKNOWN": 1}, result.Summary, "findings by severity")
+	suite.Equal("CVE-2022-0002", result.Findings[0].ID, "most severe finding first")
+	suite.Equal("libc", result.Findings[0].Package)
+
+	result, err = suite.newScanner(server.URL, PayloadImages, "CRITICAL").Scan(context.Background(), "org1", "mychart", "0.1.0", suite.Content)
+	suite.Nil(err, "no error scanning chart")
+	suite.Equal(StatusPassed, result.Status, "passed without CRITICAL findings")
+}
+
+func (suite *ScanTestSuite) TestScanTarball() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("application/gzip", r.Header.Get("Content-Type"))
+		suite.Equal("mychart", r.URL.Query().Get("name"))
+		suite.Equal("0.1.0", r.URL.Query().Get("version"))
+		body, _ := ioutil.ReadAll(r.Body)
+		suite.Equal(suite.Content, body, "chart package submitted")
+		// a Trivy JSON report
+		w.Write([]byte(`{"SchemaVersion": 2, "Results": [{"Target": "busybox", "Vulnerabilities": [
+			{"VulnerabilityID": "CVE-2022-0004", "PkgName": "busybox", "Severity": "LOW", "Title": "minor"}
+		]}]}`))
+	}))
+	defer server.Close()
+
+	result, err := suite.newScanner(server.URL, PayloadTarball, "").Scan(context.Background(), "", "mychart", "0.1.0", suite.Content)
+	suite.Nil(err, "no error scanning chart")
+	suite.Equal(StatusPassed, result.Status, "passed with a LOW finding")
+	suite.Equal([]Finding{{ID: "CVE-2022-0004", Severity: "LOW", Target: "busybox", Package: "busybox", Title: "minor"}}, result.Findings)
+}
+
+func (suite *ScanTestSuite) TestScanClairReport() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"vulnerabilities": {
+			"1": {"name": "CVE-2022-0005", "normalized_severity": "Critical", "package": {"name": "openssl"}},
+			"2": {"name": "CVE-2022-0006", "normalized_severity": "Negligible", "package": {"name": "zlib"}}
+		}}`))
+	}))
+	defer server.Close()
+
+	result, err := suite.newScanner(server.URL, PayloadImages, "").Scan(context.Background(), "", "mychart", "0.1.0", suite.Content)
+	suite.Nil(err, "no error scanning chart")
+	suite.Equal(StatusFailed, result.Status, "failed with a CRITICAL finding")
+	suite.Len(result.Findings, 2)
+	suite.Equal("CRITICAL", result.Findings[0].Severity)
+	suite.Equal("NEGLIGIBLE", result.Findings[1].Severity)
+}
+
+func (suite *ScanTestSuite) TestScanErrors() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/invalid" {
+			w.Write([]byte("not json"))
+			return
+		}
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	_, err := suite.newScanner(server.URL, PayloadImages, "").Scan(context.Background(), "", "mychart", "0.1.0", suite.Content)
+	suite.NotNil(err, "error with scanner failing")
+	_, err = suite.newScanner(server.URL+"/invalid", PayloadImages, "").Scan(context.Background(), "", "mychart", "0.1.0", suite.Content)
+	suite.NotNil(err, "error with invalid report")
+	_, err = suite.newScanner(server.URL, PayloadImages, "").Scan(context.Background(), "", "mychart", "0.1.0", []byte("not a chart"))
+	suite.NotNil(err, "error with invalid chart")
+
+	result := suite.newScanner(server.URL, PayloadImages, "").ErrorResult(err)
+	suite.Equal(StatusError, result.Status)
+	suite.Equal(err.Error(), result.Error)
+}
+
+func TestScanTestSuite(t *testing.T) {
+	suite.Run(t, new(ScanTestSuite))
+}
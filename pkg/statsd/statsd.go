@@ -0,0 +1,261 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// namespace is the prefix of the metrics of ChartMuseum, the only ones emitted
+	namespace = "chartmuseum_"
+
+	// maxPacketSize keeps packets under the MTU of most networks
+	maxPacketSize = 1432
+)
+
+var (
+	// tagNames renames labels to tags, labels with an empty name being dropped: the url label of
+	// the request metrics is the route template, while handler and host add little but series
+	tagNames = map[string]string{
+		"repo":    "tenant",
+		"url":     "route",
+		"handler": "",
+		"host":    "",
+	}
+
+	// tagValueReplacer replaces the separators of the dogstatsd format in tag values
+	tagValueReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_")
+)
+
+type (
+	// Emitter sends the Prometheus metrics of the server to a statsd server in the dogstatsd
+	// format, gauges as gauges and counters, histograms and summaries as counters of their increase
+	Emitter struct {
+		Logger   *cm_logger.Logger
+		Address  string
+		Interval time.Duration
+		Tags     []string
+		Gatherer prometheus.Gatherer
+		conn     net.Conn
+		// last holds the values of counters at the previous flush
+		last      map[string]float64
+		mutex     sync.Mutex
+		closed    chan struct{}
+		closeOnce sync.Once
+		done      chan struct{}
+	}
+
+	// EmitterOptions are options for constructing an Emitter
+	EmitterOptions struct {
+		Logger *cm_logger.Logger
+		// Address is the host:port of the statsd server, e.g. the Datadog agent on localhost:8125
+		Address  string
+		Interval time.Duration
+		// Tags are added to every metric, e.g. env:prod
+		Tags []string
+		// Gatherer gathers the metrics sent, prometheus.DefaultGatherer if nil
+		Gatherer prometheus.Gatherer
+	}
+)
+
+// NewEmitter creates a new Emitter and starts sending metrics at each interval, or returns nil without an address
+func NewEmitter(options EmitterOptions) (*Emitter, error) {
+	if options.Address == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", options.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statsd address %q: %s", options.Address, err)
+	}
+	interval := options.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	gatherer := options.Gatherer
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	emitter := &Emitter{
+		Logger:   options.Logger,
+		Address:  options.Address,
+		Interval: interval,
+		Tags:     options.Tags,
+		Gatherer: gatherer,
+		conn:     conn,
+		last:     map[string]float64{},
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go emitter.start()
+	return emitter, nil
+}
+
+// Flush sends the metrics gathered since the previous flush
+func (emitter *Emitter) Flush() {
+	log := emitter.Logger.ContextLoggingFn(&gin.Context{})
+	families, err := emitter.Gatherer.Gather()
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Could not gather metrics for statsd",
+			"error", err.Error(),
+		)
+		return
+	}
+	emitter.mutex.Lock()
+	lines := emitter.lines(families)
+	emitter.mutex.Unlock()
+
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > maxPacketSize {
+			emitter.send(packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		emitter.send(packet)
+	}
+}
+
+// Stop sends the metrics a last time and stops the emitter
+func (emitter *Emitter) Stop(ctx context.Context) {
+	if emitter == nil {
+		return
+	}
+	emitter.closeOnce.Do(func() { close(emitter.closed) })
+	select {
+	case <-emitter.done:
+	case <-ctx.Done():
+	}
+	emitter.conn.Close()
+}
+
+func (emitter *Emitter) start() {
+	defer close(emitter.done)
+	ticker := time.NewTicker(emitter.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			emitter.Flush()
+		case <-emitter.closed:
+			emitter.Flush()
+			return
+		}
+	}
+}
+
+func (emitter *Emitter) send(packet []byte) {
+	if _, err := emitter.conn.Write(packet); err != nil {
+		log := emitter.Logger.ContextLoggingFn(&gin.Context{})
+		log(cm_logger.WarnLevel, "Could not send metrics to statsd",
+			"address", emitter.Address,
+			"error", err.Error(),
+		)
+	}
+}
+
+// lines formats metric families as dogstatsd lines, e.g. chartmuseum.charts_served_total:3|g|#tenant:org1
+func (emitter *Emitter) lines(families []*dto.MetricFamily) []string {
+	var lines []string
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), namespace) {
+			continue
+		}
+		name := "chartmuseum." + strings.TrimPrefix(family.GetName(), namespace)
+		for _, metric := range family.GetMetric() {
+			tags := emitter.tags(metric.GetLabel())
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = emitter.appendCounter(lines, name, tags, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = append(lines, line(name, "g", tags, metric.GetGauge().GetValue()))
+			case dto.MetricType_UNTYPED:
+				lines = append(lines, line(name, "g", tags, metric.GetUntyped().GetValue()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				lines = emitter.appendCounter(lines, name+".count", tags, float64(histogram.GetSampleCount()))
+				lines = emitter.appendCounter(lines, name+".sum", tags, histogram.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				lines = emitter.appendCounter(lines, name+".count", tags, float64(summary.GetSampleCount()))
+				lines = emitter.appendCounter(lines, name+".sum", tags, summary.GetSampleSum())
+				for _, quantile := range summary.GetQuantile() {
+					quantileTags := append(append([]string{}, tags...), "quantile:"+formatValue(quantile.GetQuantile()))
+					lines = append(lines, line(name, "g", quantileTags, quantile.GetValue()))
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// appendCounter appends the increase of a counter since the previous flush, if any
+func (emitter *Emitter) appendCounter(lines []string, name string, tags []string, value float64) []string {
+	key := name + "|" + strings.Join(tags, ",")
+	delta := value - emitter.last[key]
+	emitter.last[key] = value
+	if delta <= 0 {
+		return lines
+	}
+	return append(lines, line(name, "c", tags, delta))
+}
+
+func (emitter *Emitter) tags(labels []*dto.LabelPair) []string {
+	tags := append([]string{}, emitter.Tags...)
+	for _, label := range labels {
+		name := label.GetName()
+		if renamed, ok := tagNames[name]; ok {
+			name = renamed
+		}
+		if name == "" || label.GetValue() == "" {
+			continue
+		}
+		tags = append(tags, name+":"+tagValueReplacer.Replace(label.GetValue()))
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func line(name string, metricType string, tags []string, value float64) string {
+	l := name + ":" + formatValue(value) + "|" + metricType
+	if len(tags) > 0 {
+		l += "|#" + strings.Join(tags, ",")
+	}
+	return l
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
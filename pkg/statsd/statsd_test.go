@@ -0,0 +1,130 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statsd
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatsdTestSuite struct {
+	suite.Suite
+	Logger *cm_logger.Logger
+}
+
+func (suite *StatsdTestSuite) SetupSuite() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{
+		Debug: true,
+	})
+	suite.Nil(err, "no error creating logger")
+	suite.Logger = logger
+}
+
+// newEmitter returns an emitter of the metrics of a new registry, sending them to a test listener
+func (suite *StatsdTestSuite) newEmitter(interval time.Duration) (*Emitter, *prometheus.Registry, net.PacketConn) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Nil(err, "no error listening")
+	registry := prometheus.NewRegistry()
+	emitter, err := NewEmitter(EmitterOptions{
+		Logger:   suite.Logger,
+		Address:  listener.LocalAddr().String(),
+		Interval: interval,
+		Tags:     []string{"env:test"},
+		Gatherer: registry,
+	})
+	suite.Nil(err, "no error creating emitter")
+	return emitter, registry, listener
+}
+
+// receive returns the lines of the next packet sent to listener, sorted
+func (suite *StatsdTestSuite) receive(listener net.PacketConn) []string {
+	buffer := make([]byte, maxPacketSize)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := listener.ReadFrom(buffer)
+	suite.Nil(err, "no error receiving metrics")
+	lines := strings.Split(string(buffer[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func (suite *StatsdTestSuite) TestNewEmitter() {
+	emitter, err := NewEmitter(EmitterOptions{})
+	suite.Nil(err, "no error without address")
+	suite.Nil(emitter, "no emitter without address")
+	emitter.Stop(context.Background())
+
+	_, err = NewEmitter(EmitterOptions{Address: "localhost"})
+	suite.NotNil(err, "error with address missing port")
+}
+
+func (suite *StatsdTestSuite) TestFlush() {
+	emitter, registry, listener := suite.newEmitter(time.Hour)
+	defer listener.Close()
+	defer emitter.Stop(context.Background())
+
+	charts := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: "chartmuseum", Name: "charts_served_total"}, []string{"repo"})
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: "chartmuseum", Name: "requests_total"}, []string{"code", "url", "host"})
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{Namespace: "chartmuseum", Name: "index_regeneration_duration_seconds"})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines"})
+	registry.MustRegister(charts, requests, duration, other)
+
+	charts.WithLabelValues("org1/repo1").Set(3)
+	requests.WithLabelValues("200", "/:repo/index.yaml", "localhost").Add(2)
+	duration.Observe(0.5)
+	other.Set(10)
+
+	emitter.Flush()
+	suite.Equal([]string{
+		"chartmuseum.charts_served_total:3|g|#env:test,tenant:org1/repo1",
+		"chartmuseum.index_regeneration_duration_seconds.count:1|c|#env:test",
+		"chartmuseum.index_regeneration_duration_seconds.sum:0.5|c|#env:test",
+		"chartmuseum.requests_total:2|c|#code:200,env:test,route:/:repo/index.yaml",
+	}, suite.receive(listener), "metrics of chartmuseum only, with tenant and route tags")
+
+	requests.WithLabelValues("200", "/:repo/index.yaml", "localhost").Inc()
+	emitter.Flush()
+	suite.Equal([]string{
+		"chartmuseum.charts_served_total:3|g|#env:test,tenant:org1/repo1",
+		"chartmuseum.requests_total:1|c|#code:200,env:test,route:/:repo/index.yaml",
+	}, suite.receive(listener), "increase of counters since the previous flush")
+}
+
+func (suite *StatsdTestSuite) TestInterval() {
+	emitter, registry, listener := suite.newEmitter(10 * time.Millisecond)
+	defer listener.Close()
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "chartmuseum", Name: "index_size_bytes"})
+	registry.MustRegister(gauge)
+	gauge.Set(1024)
+	suite.Equal([]string{"chartmuseum.index_size_bytes:1024|g|#env:test"}, suite.receive(listener), "metrics sent at each interval")
+
+	emitter.Stop(context.Background())
+	emitter.Stop(context.Background())
+}
+
+func TestStatsdTestSuite(t *testing.T) {
+	suite.Run(t, new(StatsdTestSuite))
+}
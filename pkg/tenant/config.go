@@ -0,0 +1,254 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ConfigFilename is the file at the root of storage holding tenants managed through the api
+const ConfigFilename = "tenants.yaml"
+
+type (
+	// Config maps tenant prefixes (e.g. "org1" or "org1/prod") to the settings they override
+	Config struct {
+		mutex   sync.RWMutex
+		Tenants map[string]*Overrides `json:"tenants"`
+	}
+
+	// Overrides are the server settings a tenant may override, unset fields inherit the server setting
+	Overrides struct {
+		AllowOverwrite    *bool  `json:"allowOverwrite,omitempty"`
+		AnonymousGet      *bool  `json:"anonymousGet,omitempty"`
+		MaxStorageObjects *int   `json:"maxStorageObjects,omitempty"`
+		BasicAuthUser     string `json:"basicAuthUser,omitempty"`
+		BasicAuthPass     string `json:"basicAuthPass,omitempty"`
+		// Credentials maps usernames to passwords, for tenants shared by several users.
+		// Passwords may be bcrypt hashes, as saved by the tenant api
+		Credentials map[string]string `json:"credentials,omitempty"`
+		// Members make the tenant a virtual repo, serving the merged indexes of these repos.
+		// Earlier members take precedence, and members only apply to the exact repo they are set for
+		Members []string `json:"members,omitempty"`
+		// Upstream is the url of a chart repo proxied by the tenant, or "" to proxy none
+		Upstream *string `json:"upstream,omitempty"`
+	}
+)
+
+// BasicAuthCredentials returns all the username/password pairs accepted by a tenant
+func (overrides *Overrides) BasicAuthCredentials() map[string]string {
+	credentials := map[string]string{}
+	for username, password := range overrides.Credentials {
+		if username != "" && password != "" {
+			credentials[username] = password
+		}
+	}
+	if overrides.BasicAuthUser != "" && overrides.BasicAuthPass != "" {
+		credentials[overrides.BasicAuthUser] = overrides.BasicAuthPass
+	}
+	return credentials
+}
+
+// Authenticate tells whether a tenant accepts the password of a user, comparing it with the
+// bcrypt hash of its password or, for passwords set in plain text in config files, the password
+func (overrides *Overrides) Authenticate(username string, password string) bool {
+	stored, ok := overrides.BasicAuthCredentials()[username]
+	if !ok || password == "" {
+		return false
+	}
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// HashPasswords replaces the passwords of a tenant with their bcrypt hash, so they are never
+// saved in plain text. Passwords already hashed are kept
+func (overrides *Overrides) HashPasswords() error {
+	hash := func(password string) (string, error) {
+		if password == "" || isPasswordHash(password) {
+			return password, nil
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hashed), err
+	}
+
+	var err error
+	if overrides.BasicAuthPass, err = hash(overrides.BasicAuthPass); err != nil {
+		return err
+	}
+	if overrides.Credentials == nil {
+		return nil
+	}
+	credentials := make(map[string]string, len(overrides.Credentials))
+	for username, password := range overrides.Credentials {
+		if credentials[username], err = hash(password); err != nil {
+			return err
+		}
+	}
+	overrides.Credentials = credentials
+	return nil
+}
+
+// isPasswordHash tells whether a password is a bcrypt hash
+func isPasswordHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+// LoadConfig reads a tenant config file
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigFromContent(content)
+}
+
+// ConfigFromContent parses the content of a tenant config file
+func ConfigFromContent(content []byte) (*Config, error) {
+	config := &Config{}
+	err := yaml.Unmarshal(content, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// normalize prefixes, so "/org1/" and "org1" are the same tenant
+	tenants := map[string]*Overrides{}
+	for prefix, overrides := range config.Tenants {
+		if overrides == nil {
+			overrides = &Overrides{}
+		}
+		for i, member := range overrides.Members {
+			overrides.Members[i] = strings.Trim(member, "/")
+		}
+		tenants[strings.Trim(prefix, "/")] = overrides
+	}
+	config.Tenants = tenants
+	return config, nil
+}
+
+// Content returns the serialized config, suitable for saving in a file or storage
+func (config *Config) Content() ([]byte, error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	return yaml.Marshal(config)
+}
+
+// Get returns the overrides of a tenant prefix
+func (config *Config) Get(prefix string) (*Overrides, bool) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	overrides, ok := config.Tenants[strings.Trim(prefix, "/")]
+	return overrides, ok
+}
+
+// Set adds or replaces the overrides of a tenant prefix
+func (config *Config) Set(prefix string, overrides *Overrides) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	if config.Tenants == nil {
+		config.Tenants = map[string]*Overrides{}
+	}
+	config.Tenants[strings.Trim(prefix, "/")] = overrides
+}
+
+// Delete removes a tenant prefix, returning false if it did not exist
+func (config *Config) Delete(prefix string) bool {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	prefix = strings.Trim(prefix, "/")
+	if _, ok := config.Tenants[prefix]; !ok {
+		return false
+	}
+	delete(config.Tenants, prefix)
+	return true
+}
+
+// Prefixes returns the sorted tenant prefixes
+func (config *Config) Prefixes() []string {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	prefixes := make([]string, 0, len(config.Tenants))
+	for prefix := range config.Tenants {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// HasCredentials tells whether any tenant has credentials of its own
+func (config *Config) HasCredentials() bool {
+	if config == nil {
+		return false
+	}
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	for _, overrides := range config.Tenants {
+		if len(overrides.BasicAuthCredentials()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the overrides of the longest prefix matching a repo, or nil if none match
+func (config *Config) Lookup(repo string) *Overrides {
+	if config == nil {
+		return nil
+	}
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	prefix, ok := config.lookupPrefix(repo)
+	if !ok {
+		return nil
+	}
+	return config.Tenants[prefix]
+}
+
+// LookupPrefix returns the longest configured prefix matching a repo on path segment boundaries
+func (config *Config) LookupPrefix(repo string) (string, bool) {
+	if config == nil {
+		return "", false
+	}
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	return config.lookupPrefix(repo)
+}
+
+func (config *Config) lookupPrefix(repo string) (string, bool) {
+	repo = strings.Trim(repo, "/")
+	for {
+		if _, ok := config.Tenants[repo]; ok {
+			return repo, true
+		}
+		if repo == "" {
+			return "", false
+		}
+		if i := strings.LastIndex(repo, "/"); i >= 0 {
+			repo = repo[:i]
+		} else {
+			repo = ""
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+	Config *Config
+}
+
+func (suite *ConfigTestSuite) SetupSuite() {
+	config, err := ConfigFromContent([]byte(`
+tenants:
+  /org1/:
+    allowOverwrite: false
+  org1/dev:
+    allowOverwrite: true
+    anonymousGet: true
+    maxStorageObjects: 10
+  org2:
+    basicAuthUser: user
+    basicAuthPass: pass
+    credentials:
+      alice: alicepass
+      bob: ""
+  all:
+    members: [/org1/dev/, org2]
+`))
+	suite.Nil(err, "no error parsing tenant config")
+	suite.Config = config
+}
+
+func (suite *ConfigTestSuite) TestLookup() {
+	overrides := suite.Config.Lookup("org1/dev")
+	suite.NotNil(overrides, "exact prefix match")
+	suite.True(*overrides.AllowOverwrite)
+	suite.True(*overrides.AnonymousGet)
+	suite.Equal(10, *overrides.MaxStorageObjects)
+
+	overrides = suite.Config.Lookup("org1/dev/team1")
+	suite.NotNil(overrides, "nested repo matches prefix")
+	suite.True(*overrides.AllowOverwrite)
+
+	overrides = suite.Config.Lookup("org1/prod")
+	suite.NotNil(overrides, "falls back to shorter prefix")
+	suite.False(*overrides.AllowOverwrite)
+	suite.Nil(overrides.AnonymousGet, "unset override")
+
+	suite.Nil(suite.Config.Lookup("org1dev"), "prefix only matches on path segments")
+	suite.Nil(suite.Config.Lookup(""), "no match for root repo")
+	suite.Equal("pass", suite.Config.Lookup("org2").BasicAuthPass)
+	suite.Equal([]string{"org1/dev", "org2"}, suite.Config.Lookup("all").Members, "members normalized")
+
+	var config *Config
+	suite.Nil(config.Lookup("org1"), "no match without config")
+}
+
+func (suite *ConfigTestSuite) TestBasicAuthCredentials() {
+	credentials := suite.Config.Lookup("org2").BasicAuthCredentials()
+	suite.Equal(map[string]string{"user": "pass", "alice": "alicepass"}, credentials,
+		"credentials map merged with basic auth pair, without empty passwords")
+	suite.Equal(0, len(suite.Config.Lookup("org1").BasicAuthCredentials()), "no credentials")
+}
+
+func (suite *ConfigTestSuite) TestHashPasswords() {
+	overrides := &Overrides{BasicAuthUser: "user", BasicAuthPass: "pass", Credentials: map[string]string{"alice": "alicepass"}}
+	suite.True(overrides.Authenticate("alice", "alicepass"), "plain text password accepted")
+
+	suite.Nil(overrides.HashPasswords(), "no error hashing passwords")
+	suite.NotEqual("pass", overrides.BasicAuthPass, "basic auth password hashed")
+	suite.NotEqual("alicepass", overrides.Credentials["alice"], "credentials map password hashed")
+	suite.True(overrides.Authenticate("user", "pass"), "password accepted with its hash")
+	suite.True(overrides.Authenticate("alice", "alicepass"), "credentials map password accepted with its hash")
+	suite.False(overrides.Authenticate("alice", "pass"), "password of another user denied")
+	suite.False(overrides.Authenticate("bob", ""), "unknown user denied")
+
+	hashed := overrides.BasicAuthPass
+	suite.Nil(overrides.HashPasswords(), "no error hashing passwords again")
+	suite.Equal(hashed, overrides.BasicAuthPass, "hashed passwords kept")
+}
+
+func (suite *ConfigTestSuite) TestLoadConfig() {
+	_, err := LoadConfig("does-not-exist.yaml")
+	suite.NotNil(err, "error loading missing tenant config")
+
+	_, err = ConfigFromContent([]byte("tenants: [not, a, map]"))
+	suite.NotNil(err, "error parsing invalid tenant config")
+}
+
+func (suite *ConfigTestSuite) TestModify() {
+	config := &Config{}
+	allowOverwrite := true
+	config.Set("/org3/", &Overrides{AllowOverwrite: &allowOverwrite})
+	config.Set("org4", &Overrides{})
+
+	overrides, ok := config.Get("org3")
+	suite.True(ok, "tenant added")
+	suite.True(*overrides.AllowOverwrite)
+	suite.Equal([]string{"org3", "org4"}, config.Prefixes())
+
+	content, err := config.Content()
+	suite.Nil(err, "no error serializing tenant config")
+	loaded, err := ConfigFromContent(content)
+	suite.Nil(err, "no error parsing serialized tenant config")
+	suite.Equal(config.Prefixes(), loaded.Prefixes(), "tenant config survives serialization")
+
+	suite.True(config.Delete("org3"), "tenant deleted")
+	suite.False(config.Delete("org3"), "tenant already deleted")
+	suite.Nil(config.Lookup("org3/team1"), "no match for deleted tenant")
+}
+
+func (suite *ConfigTestSuite) TestMetricsLabel() {
+	defer SetMaxMetricsLabels(0)
+
+	SetMaxMetricsLabels(2)
+	for _, repo := range []string{"org1", "org2", "org1"} {
+		label, ok := MetricsLabel(repo)
+		suite.True(ok, "repo under the limit")
+		suite.Equal(repo, label)
+	}
+
+	label, ok := MetricsLabel("org3")
+	suite.False(ok, "repo over the limit")
+	suite.Equal(OtherMetricsLabel, label)
+	suite.Equal("org2", AssignedMetricsLabel("org2"), "assigned label")
+	suite.Equal(OtherMetricsLabel, AssignedMetricsLabel("org3"), "no label assigned over the limit")
+
+	SetMaxMetricsLabels(2)
+	suite.Equal(OtherMetricsLabel, AssignedMetricsLabel("org1"), "no label assigned without MetricsLabel")
+	MetricsLabel("org1")
+	suite.Equal("org1", AssignedMetricsLabel("org1"), "label assigned by MetricsLabel")
+
+	SetMaxMetricsLabels(0)
+	label, ok = MetricsLabel("org3")
+	suite.True(ok, "no limit")
+	suite.Equal("org3", label)
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
@@ -0,0 +1,72 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"sync"
+)
+
+// OtherMetricsLabel is the repo label shared by the tenants over the metrics label limit
+const OtherMetricsLabel = "_other"
+
+var metricsLabels = &labelGuard{seen: map[string]struct{}{}}
+
+type (
+	// labelGuard bounds the number of distinct repo label values, so a server with many
+	// tenants does not flood Prometheus with series
+	labelGuard struct {
+		mutex sync.Mutex
+		max   int
+		seen  map[string]struct{}
+	}
+)
+
+// SetMaxMetricsLabels limits metrics to the first max tenants seen, 0 for no limit
+func SetMaxMetricsLabels(max int) {
+	metricsLabels.mutex.Lock()
+	defer metricsLabels.mutex.Unlock()
+	metricsLabels.max = max
+	metricsLabels.seen = map[string]struct{}{}
+}
+
+// MetricsLabel returns the repo label to use for a repo in metrics, giving it one of the labels
+// left if it has none. Past the limit, new repos get OtherMetricsLabel and false, counters can
+// add to it but gauges should not be set. Only repos the server serves should be given labels,
+// such as those of authorized requests, so made up repos cannot use them up
+func MetricsLabel(repo string) (string, bool) {
+	metricsLabels.mutex.Lock()
+	defer metricsLabels.mutex.Unlock()
+	if _, ok := metricsLabels.seen[repo]; ok || metricsLabels.max <= 0 {
+		return repo, true
+	}
+	if len(metricsLabels.seen) >= metricsLabels.max {
+		return OtherMetricsLabel, false
+	}
+	metricsLabels.seen[repo] = struct{}{}
+	return repo, true
+}
+
+// AssignedMetricsLabel returns the repo label of a repo given one by MetricsLabel, without giving
+// it one, or OtherMetricsLabel
+func AssignedMetricsLabel(repo string) string {
+	metricsLabels.mutex.Lock()
+	defer metricsLabels.mutex.Unlock()
+	if _, ok := metricsLabels.seen[repo]; ok || metricsLabels.max <= 0 {
+		return repo
+	}
+	return OtherMetricsLabel
+}
@@ -0,0 +1,117 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up the OpenTelemetry SDK exporting the traces of the server to an OTLP/HTTP collector
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+type (
+	// TracerProviderOptions are options for constructing a TracerProvider
+	TracerProviderOptions struct {
+		Logger *cm_logger.Logger
+		// Endpoint is the base url of the collector, e.g. http://localhost:4318, spans being
+		// posted to /v1/traces unless it already ends with it
+		Endpoint       string
+		ServiceName    string
+		ServiceVersion string
+		// SampleRatio is the share of new traces recorded, from 0 to 1. Traces continued from
+		// a traceparent header are recorded as the client sampled them
+		SampleRatio   float64
+		FlushInterval time.Duration
+		Timeout       time.Duration
+	}
+)
+
+// Propagator reads and writes the traceparent header, see https://www.w3.org/TR/trace-context/
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// NewTracerProvider creates a TracerProvider exporting its spans in batches in the background,
+// or returns nil without an endpoint
+func NewTracerProvider(options TracerProviderOptions) (*sdktrace.TracerProvider, error) {
+	if options.Endpoint == "" {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid tracing endpoint %q, must be an http or https url", options.Endpoint)
+	}
+	if !strings.HasSuffix(endpoint.Path, "/v1/traces") {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/v1/traces"
+	}
+	if options.SampleRatio < 0 || options.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid tracing sample ratio %v, must be between 0 and 1", options.SampleRatio)
+	}
+	serviceName := options.ServiceName
+	if serviceName == "" {
+		serviceName = "chartmuseum"
+	}
+	flushInterval := options.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	clientOptions := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithURLPath(endpoint.Path),
+		otlptracehttp.WithTimeout(timeout),
+	}
+	if endpoint.Scheme == "http" {
+		clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+	}
+	// the exporter only connects when exporting, so this does not wait on the collector
+	exporter, err := otlptracehttp.New(context.Background(), clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := []attribute.KeyValue{semconv.ServiceNameKey.String(serviceName)}
+	if options.ServiceVersion != "" {
+		attributes = append(attributes, semconv.ServiceVersionKey.String(options.ServiceVersion))
+	}
+	if options.Logger != nil {
+		// the SDK reports failed exports to the global handler
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			options.Logger.Warnw("Could not export spans", "error", err.Error())
+		}))
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(flushInterval), sdktrace.WithExportTimeout(timeout)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attributes...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	), nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	collector_trace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	otlp_trace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type TracingTestSuite struct {
+	suite.Suite
+	Logger *cm_logger.Logger
+}
+
+func (suite *TracingTestSuite) SetupSuite() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{
+		Debug: true,
+	})
+	suite.Nil(err, "no error creating logger")
+	suite.Logger = logger
+}
+
+// collector returns a test server recording the spans exported to it, and the path they were posted to
+func (suite *TracingTestSuite) collector() (*httptest.Server, func() ([]*otlp_trace.Span, string)) {
+	var mutex sync.Mutex
+	var spans []*otlp_trace.Span
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		path = r.URL.Path
+		body, err := ioutil.ReadAll(r.Body)
+		suite.Nil(err, "no error reading export request")
+		var request collector_trace.ExportTraceServiceRequest
+		suite.Nil(proto.Unmarshal(body, &request), "no error decoding export request")
+		for _, resourceSpans := range request.ResourceSpans {
+			suite.Equal("service.name", resourceSpans.Resource.Attributes[0].Key)
+			suite.Equal("test", resourceSpans.Resource.Attributes[0].Value.GetStringValue())
+			for _, librarySpans := range resourceSpans.InstrumentationLibrarySpans {
+				spans = append(spans, librarySpans.Spans...)
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		response, _ := proto.Marshal(&collector_trace.ExportTraceServiceResponse{})
+		w.Write(response)
+	}))
+	return server, func() ([]*otlp_trace.Span, string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return spans, path
+	}
+}
+
+func (suite *TracingTestSuite) TestNewTracerProvider() {
+	provider, err := NewTracerProvider(TracerProviderOptions{})
+	suite.Nil(err, "no error without endpoint")
+	suite.Nil(provider, "no tracer provider without endpoint")
+
+	_, err = NewTracerProvider(TracerProviderOptions{Endpoint: "localhost:4318"})
+	suite.NotNil(err, "error with endpoint missing scheme")
+	_, err = NewTracerProvider(TracerProviderOptions{Endpoint: "http://localhost:4318", SampleRatio: 2})
+	suite.NotNil(err, "error with sample ratio above 1")
+
+	provider, err = NewTracerProvider(TracerProviderOptions{Endpoint: "https://localhost:4318/otlp/v1/traces", SampleRatio: 1})
+	suite.Nil(err, "no error with https endpoint")
+	suite.NotNil(provider, "tracer provider created")
+	provider.Shutdown(context.Background())
+}
+
+func (suite *TracingTestSuite) TestExport() {
+	for _, endpoint := range []string{"", "/v1/traces"} {
+		server, received := suite.collector()
+		provider, err := NewTracerProvider(TracerProviderOptions{
+			Logger:      suite.Logger,
+			Endpoint:    server.URL + endpoint,
+			ServiceName: "test",
+			SampleRatio: 1,
+		})
+		suite.Nil(err, "no error creating tracer provider")
+		tracer := provider.Tracer("test")
+
+		ctx, parent := tracer.Start(context.Background(), "GET /index.yaml", trace.WithSpanKind(trace.SpanKindServer))
+		_, child := tracer.Start(ctx, "storage ListObjects", trace.WithSpanKind(trace.SpanKindClient))
+		child.SetStatus(codes.Error, "storage unavailable")
+		child.End()
+		parent.End()
+		suite.Nil(provider.Shutdown(context.Background()), "no error exporting spans on shutdown")
+
+		spans, path := received()
+		suite.Equal("/v1/traces", path, "spans posted to /v1/traces")
+		suite.Len(spans, 2, "spans exported")
+		c, p := spans[0], spans[1]
+		suite.Equal("storage ListObjects", c.Name)
+		suite.Equal(otlp_trace.Span_SPAN_KIND_CLIENT, c.Kind)
+		suite.Equal(p.TraceId, c.TraceId, "child in trace of parent")
+		suite.Equal(p.SpanId, c.ParentSpanId, "child of parent")
+		suite.Equal(otlp_trace.Status_STATUS_CODE_ERROR, c.Status.Code, "error status")
+		suite.Equal("storage unavailable", c.Status.Message)
+		suite.Equal("GET /index.yaml", p.Name)
+		suite.Empty(p.ParentSpanId, "root span")
+		server.Close()
+	}
+}
+
+func (suite *TracingTestSuite) TestSampling() {
+	server, received := suite.collector()
+	defer server.Close()
+	provider, err := NewTracerProvider(TracerProviderOptions{
+		Logger:        suite.Logger,
+		Endpoint:      server.URL,
+		ServiceName:   "test",
+		SampleRatio:   0,
+		FlushInterval: 10 * time.Millisecond,
+	})
+	suite.Nil(err, "no error creating tracer provider")
+	tracer := provider.Tracer("test")
+
+	// new traces are not sampled with a ratio of 0, but traces sampled by clients are continued
+	_, unsampled := tracer.Start(context.Background(), "unsampled")
+	suite.False(unsampled.IsRecording(), "new trace not recorded")
+	unsampled.End()
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := Propagator.Extract(context.Background(), propagation.HeaderCarrier(header))
+	_, sampled := tracer.Start(ctx, "sampled")
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sampled.SpanContext().TraceID().String(), "trace of client continued")
+	sampled.End()
+
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx = Propagator.Extract(context.Background(), propagation.HeaderCarrier(header))
+	_, notSampled := tracer.Start(ctx, "not sampled")
+	notSampled.End()
+
+	suite.Eventually(func() bool {
+		spans, _ := received()
+		return len(spans) == 1
+	}, 5*time.Second, 10*time.Millisecond, "span exported after the flush interval")
+	provider.Shutdown(context.Background())
+	spans, _ := received()
+	suite.Len(spans, 1, "only the span sampled by the client exported")
+	suite.Equal("sampled", spans[0].Name)
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
@@ -0,0 +1,232 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package upstream reads the index and charts of Helm chart repositories served elsewhere,
+// e.g. https://charts.bitnami.com/bitnami or another ChartMuseum
+package upstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	cm_repo "helm.sh/chartmuseum/pkg/repo"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/sync/singleflight"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	defaultIndexTTL = 5 * time.Minute
+	defaultTimeout  = 30 * time.Second
+)
+
+var (
+	// ErrChartNotFound is returned for charts missing from the upstream index
+	ErrChartNotFound = errors.New("chart not found in upstream index")
+)
+
+type (
+	// Client fetches the index and charts of an upstream repo, caching the index for IndexTTL
+	Client struct {
+		url        *url.URL
+		username   string
+		password   string
+		indexTTL   time.Duration
+		httpClient *http.Client
+
+		mutex   sync.Mutex
+		index   *helm_repo.IndexFile
+		fetched time.Time
+		group   singleflight.Group
+	}
+
+	// ClientOptions are options for constructing a Client
+	ClientOptions struct {
+		// URL of the repo, without index.yaml. Credentials in the URL are sent with basic auth
+		URL      string
+		IndexTTL time.Duration
+		Timeout  time.Duration
+	}
+)
+
+// NewClient creates a new Client instance
+func NewClient(options ClientOptions) (*Client, error) {
+	repoURL, err := url.Parse(options.URL)
+	if err != nil {
+		return nil, err
+	}
+	if repoURL.Scheme != "http" && repoURL.Scheme != "https" {
+		return nil, fmt.Errorf("upstream url must be http or https: %s", options.URL)
+	}
+	if repoURL.Host == "" {
+		return nil, fmt.Errorf("upstream url has no host: %s", options.URL)
+	}
+
+	client := &Client{
+		indexTTL:   options.IndexTTL,
+		httpClient: &http.Client{Timeout: options.Timeout},
+	}
+	if client.indexTTL <= 0 {
+		client.indexTTL = defaultIndexTTL
+	}
+	if client.httpClient.Timeout <= 0 {
+		client.httpClient.Timeout = defaultTimeout
+	}
+	if repoURL.User != nil {
+		client.username = repoURL.User.Username()
+		client.password, _ = repoURL.User.Password()
+		repoURL.User = nil
+	}
+	repoURL.Path = strings.TrimSuffix(repoURL.Path, "/")
+	client.url = repoURL
+	return client, nil
+}
+
+// Name returns the url of the repo, without credentials
+func (client *Client) Name() string {
+	return client.url.String()
+}
+
+// Index returns the upstream index, fetching it again once IndexTTL has passed.
+// When fetching fails, the last index fetched is returned along with the error, if any
+func (client *Client) Index() (*helm_repo.IndexFile, error) {
+	client.mutex.Lock()
+	index, fetched := client.index, client.fetched
+	client.mutex.Unlock()
+	if index != nil && time.Since(fetched) < client.indexTTL {
+		return index, nil
+	}
+
+	refreshed, err := client.Refresh()
+	if err != nil {
+		return index, err
+	}
+	return refreshed, nil
+}
+
+// Refresh fetches the upstream index, even if the one cached is not older than IndexTTL
+func (client *Client) Refresh() (*helm_repo.IndexFile, error) {
+	result, err, _ := client.group.Do("index", func() (interface{}, error) {
+		return client.fetchIndex()
+	})
+	if err != nil {
+		return nil, err
+	}
+	index := result.(*helm_repo.IndexFile)
+	client.mutex.Lock()
+	client.index, client.fetched = index, time.Now()
+	client.mutex.Unlock()
+	return index, nil
+}
+
+func (client *Client) fetchIndex() (*helm_repo.IndexFile, error) {
+	content, err := client.get(client.url.String() + "/index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	index := &helm_repo.IndexFile{}
+	if err := yaml.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("invalid upstream index: %s", err)
+	}
+	for name, chartVersions := range index.Entries {
+		valid := helm_repo.ChartVersions{}
+		for _, chartVersion := range chartVersions {
+			if chartVersion == nil || chartVersion.Metadata == nil || chartVersion.Version == "" || len(chartVersion.URLs) == 0 {
+				continue
+			}
+			chartVersion.Name = name
+			valid = append(valid, chartVersion)
+		}
+		index.Entries[name] = valid
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+// ChartVersion returns the upstream chart version stored locally as filename, e.g. "mychart-0.1.0.tgz"
+func (client *Client) ChartVersion(filename string) (*helm_repo.ChartVersion, error) {
+	index, err := client.Index()
+	if index == nil {
+		return nil, err
+	}
+	for name, chartVersions := range index.Entries {
+		if !strings.HasPrefix(filename, name+"-") {
+			continue
+		}
+		for _, chartVersion := range chartVersions {
+			if cm_repo.ChartPackageFilenameFromNameVersion(name, chartVersion.Version) == filename {
+				return chartVersion, nil
+			}
+		}
+	}
+	return nil, ErrChartNotFound
+}
+
+// Download returns the package of an upstream chart version, checked against its digest,
+// or its provenance file if prov is set
+func (client *Client) Download(chartVersion *helm_repo.ChartVersion, prov bool) ([]byte, error) {
+	// relative urls are relative to the repo, even without a trailing slash on its url
+	rawURL := chartVersion.URLs[0]
+	if !strings.Contains(rawURL, "://") && !strings.HasPrefix(rawURL, "/") {
+		rawURL = client.url.String() + "/" + rawURL
+	}
+	chartURL, err := client.url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if prov {
+		chartURL.Path += ".prov"
+	}
+
+	content, err := client.get(chartURL.String())
+	if err != nil || prov || chartVersion.Digest == "" {
+		return content, err
+	}
+	sum := sha256.Sum256(content)
+	if digest := hex.EncodeToString(sum[:]); digest != strings.TrimPrefix(chartVersion.Digest, "sha256:") {
+		return nil, fmt.Errorf("digest of %s is %s, upstream index has %s", chartURL, digest, chartVersion.Digest)
+	}
+	return content, nil
+}
+
+func (client *Client) get(rawURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// credentials are only for the repo host, not where charts may be hosted
+	if client.username != "" && req.URL.Host == client.url.Host {
+		req.SetBasicAuth(client.username, client.password)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: upstream returned %s", req.URL.Redacted(), resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	// SlackPublisher posts alert events to a Slack incoming webhook as messages, other events
+	// being left to the webhooks
+	SlackPublisher struct {
+		URL    string
+		Client *http.Client
+	}
+)
+
+// NewSlackPublisher creates a new SlackPublisher posting to the url of a Slack incoming webhook
+func NewSlackPublisher(url string, timeout time.Duration) *SlackPublisher {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &SlackPublisher{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Name identifies the publisher in logs
+func (publisher *SlackPublisher) Name() string {
+	return "slack"
+}
+
+// Publish posts the message of an alert event
+func (publisher *SlackPublisher) Publish(key string, body []byte) error {
+	event := Event{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	text := SlackText(&event)
+	if text == "" {
+		return nil
+	}
+	message, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	res, err := publisher.Client.Post(publisher.URL, "application/json", bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// SlackText returns the message of an alert event, or "" for other events
+func SlackText(event *Event) string {
+	if event.Alert == nil {
+		return ""
+	}
+	repo := event.Repo
+	if repo == "" {
+		repo = "/"
+	}
+	switch event.Type {
+	case AlertFiringEvent:
+		return fmt.Sprintf(":warning: %s of repo %s is %s, above the threshold of %s",
+			event.Alert.Metric, repo, formatFloat(event.Alert.Value), formatFloat(event.Alert.Threshold))
+	case AlertResolvedEvent:
+		return fmt.Sprintf(":white_check_mark: %s of repo %s is back to %s, under the threshold of %s",
+			event.Alert.Metric, repo, formatFloat(event.Alert.Value), formatFloat(event.Alert.Threshold))
+	}
+	return ""
+}
+
+// formatFloat formats byte counts without exponents
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,310 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ChartUploadedEvent is sent when a chart version is added or overwritten
+	ChartUploadedEvent = "chart.uploaded"
+	// ChartDeletedEvent is sent when a chart version is deleted
+	ChartDeletedEvent = "chart.deleted"
+	// IndexRegeneratedEvent is sent when the index of a repo changes
+	IndexRegeneratedEvent = "index.regenerated"
+	// AlertFiringEvent is sent when a metric of a repo crosses its alert threshold
+	AlertFiringEvent = "alert.firing"
+	// AlertResolvedEvent is sent when a metric of a repo is back under its alert threshold
+	AlertResolvedEvent = "alert.resolved"
+
+	// SignatureHeader holds the hex HMAC-SHA256 of the timestamp and the body, keyed with the
+	// webhook secret, see Sign
+	SignatureHeader = "X-ChartMuseum-Signature"
+	// TimestampHeader holds the unix time of the delivery, for receivers to reject replayed events
+	TimestampHeader = "X-ChartMuseum-Timestamp"
+	// EventHeader holds the type of the event
+	EventHeader = "X-ChartMuseum-Event"
+
+	queueSize = 1000
+)
+
+type (
+	// Event is the JSON body posted to webhooks
+	Event struct {
+		Type      string    `json:"type"`
+		Repo      string    `json:"repo"`
+		Chart     *Chart    `json:"chart,omitempty"`
+		Alert     *Alert    `json:"alert,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+		// RequestID is the X-Request-Id of the request causing the event, if any
+		RequestID string `json:"requestId,omitempty"`
+	}
+
+	// Chart identifies the chart version of an event
+	Chart struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	// Alert is the metric of a repo crossing its threshold, for alert events
+	Alert struct {
+		Metric    string  `json:"metric"`
+		Value     float64 `json:"value"`
+		Threshold float64 `json:"threshold"`
+	}
+
+	// Publisher publishes events to a message bus, such as a NATS subject or a Kafka topic
+	Publisher interface {
+		// Name identifies the publisher in logs
+		Name() string
+		// Publish sends the JSON body of an event, keyed by its repo
+		Publish(key string, body []byte) error
+	}
+
+	// Notifier posts events to webhooks and publishers in the background, retrying failed deliveries.
+	// Each webhook and publisher has a queue of its own, so a slow one does not hold back the others
+	Notifier struct {
+		Logger     *cm_logger.Logger
+		URLs       []string
+		Publishers []Publisher
+		Secret     string
+		MaxRetries int
+		RetryDelay time.Duration
+		Client     *http.Client
+		targets    []*target
+		ctx        context.Context
+		cancel     context.CancelFunc
+		wait       sync.WaitGroup
+	}
+
+	// target is a webhook or publisher, with the queue of events left to deliver to it
+	target struct {
+		name  string
+		send  func(ctx context.Context, event *Event, body []byte) error
+		queue chan *delivery
+	}
+
+	// delivery is a queued event, along with its JSON body
+	delivery struct {
+		event *Event
+		body  []byte
+	}
+
+	// NotifierOptions are options for constructing a Notifier
+	NotifierOptions struct {
+		Logger     *cm_logger.Logger
+		URLs       []string
+		Publishers []Publisher
+		Secret     string
+		MaxRetries int
+		RetryDelay time.Duration
+		Timeout    time.Duration
+	}
+)
+
+// NewNotifier creates a new Notifier and starts delivering its events, or returns nil without
+// URLs nor publishers
+func NewNotifier(options NotifierOptions) *Notifier {
+	if len(options.URLs) == 0 && len(options.Publishers) == 0 {
+		return nil
+	}
+	retryDelay := options.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = time.Second
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	notifier := &Notifier{
+		Logger:     options.Logger,
+		URLs:       options.URLs,
+		Publishers: options.Publishers,
+		Secret:     options.Secret,
+		MaxRetries: options.MaxRetries,
+		RetryDelay: retryDelay,
+		Client:     &http.Client{Timeout: timeout},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, url := range options.URLs {
+		url := url
+		notifier.targets = append(notifier.targets, &target{
+			name: url,
+			send: func(ctx context.Context, event *Event, body []byte) error {
+				return notifier.post(ctx, url, event.Type, body)
+			},
+		})
+	}
+	for _, publisher := range options.Publishers {
+		publisher := publisher
+		notifier.targets = append(notifier.targets, &target{
+			name: publisher.Name(),
+			send: func(ctx context.Context, event *Event, body []byte) error {
+				return publisher.Publish(event.Repo, body)
+			},
+		})
+	}
+	for _, target := range notifier.targets {
+		target.queue = make(chan *delivery, queueSize)
+		notifier.wait.Add(1)
+		go notifier.start(target)
+	}
+	return notifier
+}
+
+// NewEvent creates a new Event which happened now
+func NewEvent(eventType string, repo string, chart *Chart) *Event {
+	return &Event{
+		Type:      eventType,
+		Repo:      repo,
+		Chart:     chart,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// Notify queues an event for delivery to every webhook and publisher, dropping it for those whose
+// queue is full so requests never wait on webhooks
+func (notifier *Notifier) Notify(event *Event) {
+	if notifier == nil {
+		return
+	}
+	log := notifier.Logger.ContextLoggingFn(&gin.Context{})
+	body, err := json.Marshal(event)
+	if err != nil {
+		log(cm_logger.ErrorLevel, "Could not marshal webhook event",
+			"error", err.Error(),
+		)
+		return
+	}
+	for _, target := range notifier.targets {
+		select {
+		case target.queue <- &delivery{event: event, body: body}:
+		default:
+			log(cm_logger.WarnLevel, "Event queue full, dropping event",
+				"target", target.name,
+				"type", event.Type,
+				"repo", event.Repo,
+			)
+		}
+	}
+}
+
+// Close stops delivering events, interrupting the deliveries being retried, and waits for the
+// deliveries in progress. Events left in the queues are dropped
+func (notifier *Notifier) Close() {
+	if notifier == nil {
+		return
+	}
+	notifier.cancel()
+	notifier.wait.Wait()
+}
+
+// start delivers the events queued for a target, one at a time, until the notifier is closed
+func (notifier *Notifier) start(target *target) {
+	defer notifier.wait.Done()
+	log := notifier.Logger.ContextLoggingFn(&gin.Context{})
+	for {
+		select {
+		case <-notifier.ctx.Done():
+			return
+		case delivery := <-target.queue:
+			notifier.deliver(log, target, delivery)
+		}
+	}
+}
+
+// deliver sends an event to a webhook or publisher, retrying with exponential backoff until the
+// retries are exhausted or the notifier is closed
+func (notifier *Notifier) deliver(log cm_logger.LoggingFn, target *target, delivery *delivery) {
+	delay := notifier.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := target.send(notifier.ctx, delivery.event, delivery.body)
+		if err == nil {
+			log(cm_logger.DebugLevel, "Event delivered",
+				"target", target.name,
+				"type", delivery.event.Type,
+			)
+			return
+		}
+		if attempt >= notifier.MaxRetries || notifier.ctx.Err() != nil {
+			log(cm_logger.ErrorLevel, "Event delivery failed",
+				"target", target.name,
+				"type", delivery.event.Type,
+				"attempts", attempt+1,
+				"error", err.Error(),
+			)
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-notifier.ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (notifier *Notifier) post(ctx context.Context, url string, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if notifier.Secret != "" {
+		// each attempt is signed with its own time, so retries are not rejected as replays
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(notifier.Secret, timestamp, body))
+	}
+	res, err := notifier.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp of TimestampHeader, a ".", and the body, for
+// receivers to verify SignatureHeader. Receivers should also reject timestamps too far in the past
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
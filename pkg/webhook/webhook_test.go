@@ -0,0 +1,250 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cm_logger "helm.sh/chartmuseum/pkg/chartmuseum/logger"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type WebhookTestSuite struct {
+	suite.Suite
+	Logger *cm_logger.Logger
+}
+
+type received struct {
+	Event     Event
+	Header    http.Header
+	Body      []byte
+	Signature string
+}
+
+func (suite *WebhookTestSuite) SetupSuite() {
+	logger, err := cm_logger.NewLogger(cm_logger.LoggerOptions{
+		Debug: true,
+	})
+	suite.Nil(err, "no error creating logger")
+	suite.Logger = logger
+}
+
+// receiver returns a test server recording the events it receives, failing the first failures requests
+func (suite *WebhookTestSuite) receiver(failures int) (*httptest.Server, func() []received) {
+	var mutex sync.Mutex
+	var events []received
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+		if requests <= failures {
+			w.WriteHeader(500)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		event := Event{}
+		suite.Nil(json.Unmarshal(body, &event), "no error unmarshaling event")
+		events = append(events, received{Event: event, Header: r.Header, Body: body})
+	}))
+	return server, func() []received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]received{}, events...)
+	}
+}
+
+func (suite *WebhookTestSuite) TestNilNotifier() {
+	notifier := NewNotifier(NotifierOptions{Logger: suite.Logger})
+	suite.Nil(notifier, "no notifier without urls")
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+}
+
+func (suite *WebhookTestSuite) TestNotify() {
+	server, events := suite.receiver(0)
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierOptions{
+		Logger: suite.Logger,
+		URLs:   []string{server.URL},
+		Secret: "secret",
+	})
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+	notifier.Notify(NewEvent(IndexRegeneratedEvent, "org1", nil))
+
+	suite.Eventually(func() bool { return len(events()) == 2 }, 5*time.Second, 10*time.Millisecond,
+		"webhook receives both events")
+
+	uploaded := events()[0]
+	suite.Equal(ChartUploadedEvent, uploaded.Event.Type)
+	suite.Equal("org1", uploaded.Event.Repo)
+	suite.Equal("mychart", uploaded.Event.Chart.Name)
+	suite.Equal("0.1.0", uploaded.Event.Chart.Version)
+	suite.Equal(ChartUploadedEvent, uploaded.Header.Get(EventHeader))
+	timestamp := uploaded.Header.Get(TimestampHeader)
+	suite.NotEmpty(timestamp, "delivery timestamp sent")
+	suite.Equal("sha256="+Sign("secret", timestamp, uploaded.Body), uploaded.Header.Get(SignatureHeader),
+		"timestamp and payload signed with secret")
+	suite.NotEqual(Sign("secret", "0", uploaded.Body), Sign("secret", timestamp, uploaded.Body),
+		"signature depends on the timestamp")
+
+	regenerated := events()[1]
+	suite.Equal(IndexRegeneratedEvent, regenerated.Event.Type)
+	suite.Nil(regenerated.Event.Chart, "no chart for index events")
+}
+
+func (suite *WebhookTestSuite) TestNotifyUnsigned() {
+	server, events := suite.receiver(0)
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierOptions{
+		Logger: suite.Logger,
+		URLs:   []string{server.URL},
+	})
+	notifier.Notify(NewEvent(ChartDeletedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+
+	suite.Eventually(func() bool { return len(events()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"webhook receives event")
+	suite.Empty(events()[0].Header.Get(SignatureHeader), "no signature without secret")
+}
+
+func (suite *WebhookTestSuite) TestSlowTarget() {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	server, events := suite.receiver(0)
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierOptions{
+		Logger: suite.Logger,
+		URLs:   []string{slow.URL, server.URL},
+	})
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+	notifier.Notify(NewEvent(IndexRegeneratedEvent, "org1", nil))
+	suite.Eventually(func() bool { return len(events()) == 2 }, 5*time.Second, 10*time.Millisecond,
+		"events delivered while another webhook is stuck")
+}
+
+func (suite *WebhookTestSuite) TestClose() {
+	server, events := suite.receiver(100)
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierOptions{
+		Logger:     suite.Logger,
+		URLs:       []string{server.URL},
+		MaxRetries: 10,
+		RetryDelay: time.Hour,
+	})
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+	time.Sleep(50 * time.Millisecond) // let the first attempt fail
+
+	closed := make(chan struct{})
+	go func() {
+		notifier.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		suite.Fail("backoff not interrupted by Close")
+	}
+	suite.Empty(events(), "no event delivered")
+
+	var nilNotifier *Notifier
+	nilNotifier.Close()
+}
+
+func (suite *WebhookTestSuite) TestRetry() {
+	server, events := suite.receiver(2)
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierOptions{
+		Logger:     suite.Logger,
+		URLs:       []string{server.URL},
+		MaxRetries: 2,
+		RetryDelay: 10 * time.Millisecond,
+	})
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+	suite.Eventually(func() bool { return len(events()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"event delivered after retries")
+
+	server, events = suite.receiver(2)
+	defer server.Close()
+
+	notifier = NewNotifier(NotifierOptions{
+		Logger:     suite.Logger,
+		URLs:       []string{server.URL},
+		MaxRetries: 1,
+		RetryDelay: 10 * time.Millisecond,
+	})
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+	notifier.Notify(NewEvent(IndexRegeneratedEvent, "org1", nil))
+	suite.Eventually(func() bool { return len(events()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"next event delivered")
+	suite.Equal(IndexRegeneratedEvent, events()[0].Event.Type, "event dropped once retries are exhausted")
+}
+
+func (suite *WebhookTestSuite) TestSlackPublisher() {
+	var mutex sync.Mutex
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		message := map[string]string{}
+		suite.Nil(json.NewDecoder(r.Body).Decode(&message), "no error decoding slack message")
+		messages = append(messages, message["text"])
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(NotifierOptions{
+		Logger:     suite.Logger,
+		Publishers: []Publisher{NewSlackPublisher(server.URL, 0)},
+	})
+	suite.NotNil(notifier, "notifier with a publisher only")
+	notifier.Notify(NewEvent(ChartUploadedEvent, "org1", &Chart{Name: "mychart", Version: "0.1.0"}))
+	firing := NewEvent(AlertFiringEvent, "org1", nil)
+	firing.Alert = &Alert{Metric: "storage_bytes", Value: 2097152, Threshold: 1048576}
+	notifier.Notify(firing)
+	resolved := NewEvent(AlertResolvedEvent, "", nil)
+	resolved.Alert = &Alert{Metric: "error_rate", Value: 0.05, Threshold: 0.1}
+	notifier.Notify(resolved)
+
+	suite.Eventually(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(messages) == 2
+	}, 5*time.Second, 10*time.Millisecond, "alerts posted to slack")
+	mutex.Lock()
+	defer mutex.Unlock()
+	suite.Equal(":warning: storage_bytes of repo org1 is 2097152, above the threshold of 1048576", messages[0],
+		"chart events not posted to slack")
+	suite.Equal(":white_check_mark: error_rate of repo / is back to 0.05, under the threshold of 0.1", messages[1])
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}